@@ -0,0 +1,93 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package conversiondata keeps the spec and status of an object converted
+// from v1beta1 to an earlier version, in an annotation of the object, so
+// that the fields the earlier version does not have are restored when the
+// object is converted back.
+package conversiondata
+
+import (
+	"encoding/json"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/fluxcd/image-reflector-controller/api/v1beta1"
+)
+
+// data is what is kept in the annotation.
+type data struct {
+	Spec   json.RawMessage `json:"spec,omitempty"`
+	Status json.RawMessage `json:"status,omitempty"`
+}
+
+// Keep records the v1beta1 spec and status given in the annotations of
+// the object.
+func Keep(obj *metav1.ObjectMeta, spec, status interface{}) error {
+	var d data
+	var err error
+	if d.Spec, err = json.Marshal(spec); err != nil {
+		return fmt.Errorf("unable to keep the v1beta1 spec: %w", err)
+	}
+	if d.Status, err = json.Marshal(status); err != nil {
+		return fmt.Errorf("unable to keep the v1beta1 status: %w", err)
+	}
+	raw, err := json.Marshal(d)
+	if err != nil {
+		return fmt.Errorf("unable to keep the v1beta1 spec and status: %w", err)
+	}
+	if obj.Annotations == nil {
+		obj.Annotations = map[string]string{}
+	}
+	obj.Annotations[v1beta1.ConversionDataAnnotation] = string(raw)
+	return nil
+}
+
+// Restore decodes the v1beta1 spec and status recorded by Keep, if any,
+// into those given, and removes them from the annotations of the object.
+// An annotation holding only the spec, as kept by earlier versions of
+// the controller, restores the spec.
+func Restore(obj *metav1.ObjectMeta, spec, status interface{}) error {
+	raw, ok := obj.Annotations[v1beta1.ConversionDataAnnotation]
+	if !ok {
+		return nil
+	}
+	delete(obj.Annotations, v1beta1.ConversionDataAnnotation)
+	if len(obj.Annotations) == 0 {
+		obj.Annotations = nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(raw), &fields); err != nil {
+		return fmt.Errorf("unable to restore the v1beta1 spec from the annotation %s: %w", v1beta1.ConversionDataAnnotation, err)
+	}
+	d := data{Spec: json.RawMessage(raw)}
+	if _, ok := fields["spec"]; ok {
+		d = data{Spec: fields["spec"], Status: fields["status"]}
+	}
+	if len(d.Spec) > 0 {
+		if err := json.Unmarshal(d.Spec, spec); err != nil {
+			return fmt.Errorf("unable to restore the v1beta1 spec from the annotation %s: %w", v1beta1.ConversionDataAnnotation, err)
+		}
+	}
+	if len(d.Status) > 0 {
+		if err := json.Unmarshal(d.Status, status); err != nil {
+			return fmt.Errorf("unable to restore the v1beta1 status from the annotation %s: %w", v1beta1.ConversionDataAnnotation, err)
+		}
+	}
+	return nil
+}
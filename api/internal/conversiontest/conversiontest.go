@@ -0,0 +1,191 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package conversiontest checks that objects of a version converted to
+// v1beta1 and back, and objects of v1beta1 converted to a version and
+// back, come back as they were.
+package conversiontest
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	fuzz "github.com/google/gofuzz"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/diff"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	"github.com/fluxcd/image-reflector-controller/api/v1beta1"
+)
+
+// iterations is the number of objects fuzzed for each round trip.
+const iterations = 200
+
+// RoundTrip converts objects of a version to and from v1beta1.
+type RoundTrip struct {
+	// Spoke and Hub return new objects of the version and of v1beta1.
+	Spoke func() conversion.Convertible
+	Hub   func() conversion.Hub
+	// KeepsHub is whether the version keeps the spec and status of the
+	// v1beta1 object it was converted from in the annotation
+	// v1beta1.ConversionDataAnnotation.
+	KeepsHub bool
+	// SpokeFuncs are the fuzz functions for the types of the version,
+	// e.g., to leave out objects that are not valid.
+	SpokeFuncs []interface{}
+}
+
+// HubFuncs are the fuzz functions for the types of v1beta1, leaving out
+// objects that are not valid and would not come back as they were.
+var HubFuncs = []interface{}{
+	// An image policy has one policy.
+	func(c *v1beta1.ImagePolicyChoice, cont fuzz.Continue) {
+		*c = v1beta1.ImagePolicyChoice{}
+		switch cont.Intn(5) {
+		case 0:
+			cont.Fuzz(&c.SemVer)
+		case 1:
+			cont.Fuzz(&c.Alphabetical)
+		case 2:
+			cont.Fuzz(&c.Numerical)
+		case 3:
+			cont.Fuzz(&c.CalVer)
+		case 4:
+			cont.Fuzz(&c.CreatedAt)
+		}
+		cont.Fuzz(&c.SoakTime)
+	},
+}
+
+// Run fuzzes objects of the version and of v1beta1, and checks that each
+// converted to the other and back is as it was.
+func (rt RoundTrip) Run(t *testing.T) {
+	t.Helper()
+	t.Run("spoke-hub-spoke", rt.spokeHubSpoke)
+	t.Run("hub-spoke-hub", rt.hubSpokeHub)
+}
+
+// spokeHubSpoke checks that an object of the version converted to
+// v1beta1 and back is as it was, but for the spec and status of the
+// v1beta1 object it gains in the annotation, if the version keeps them.
+func (rt RoundTrip) spokeHubSpoke(t *testing.T) {
+	f := fuzzer(rt.SpokeFuncs)
+	for i := 0; i < iterations; i++ {
+		spoke := rt.Spoke()
+		f.Fuzz(spoke)
+		// An object created with the version has no conversion data.
+		deleteConversionData(spoke)
+
+		hub := rt.Hub()
+		if err := spoke.DeepCopyObject().(conversion.Convertible).ConvertTo(hub); err != nil {
+			t.Fatalf("ConvertTo() error = %v", err)
+		}
+		got := rt.Spoke()
+		if err := got.ConvertFrom(hub.DeepCopyObject().(conversion.Hub)); err != nil {
+			t.Fatalf("ConvertFrom() error = %v", err)
+		}
+
+		kept, ok := got.(metav1.Object).GetAnnotations()[v1beta1.ConversionDataAnnotation]
+		if ok != rt.KeepsHub {
+			t.Fatalf("annotation %s present = %v, want %v", v1beta1.ConversionDataAnnotation, ok, rt.KeepsHub)
+		}
+		if ok {
+			checkConversionData(t, kept, hub)
+		}
+		deleteConversionData(got)
+		if !apiequality.Semantic.DeepEqual(spoke, got) {
+			t.Fatalf("object converted to v1beta1 and back differs:\n%s", diff.ObjectReflectDiff(spoke, got))
+		}
+	}
+}
+
+// hubSpokeHub checks that an object of v1beta1 converted to the version
+// and back is as it was, with the annotations it had.
+func (rt RoundTrip) hubSpokeHub(t *testing.T) {
+	f := fuzzer(HubFuncs)
+	for i := 0; i < iterations; i++ {
+		hub := rt.Hub()
+		f.Fuzz(hub)
+		deleteConversionData(hub)
+
+		spoke := rt.Spoke()
+		if err := spoke.ConvertFrom(hub.DeepCopyObject().(conversion.Hub)); err != nil {
+			t.Fatalf("ConvertFrom() error = %v", err)
+		}
+		got := rt.Hub()
+		if err := spoke.ConvertTo(got); err != nil {
+			t.Fatalf("ConvertTo() error = %v", err)
+		}
+		if !apiequality.Semantic.DeepEqual(hub, got) {
+			t.Fatalf("v1beta1 object converted and back differs:\n%s", diff.ObjectReflectDiff(hub, got))
+		}
+	}
+}
+
+// checkConversionData checks that the conversion data kept holds the
+// spec and status of the v1beta1 object.
+func checkConversionData(t *testing.T, kept string, hub conversion.Hub) {
+	t.Helper()
+	var got map[string]interface{}
+	if err := json.Unmarshal([]byte(kept), &got); err != nil {
+		t.Fatalf("unable to decode the annotation %s: %v", v1beta1.ConversionDataAnnotation, err)
+	}
+	raw, err := json.Marshal(hub)
+	if err != nil {
+		t.Fatalf("unable to encode the v1beta1 object: %v", err)
+	}
+	var obj map[string]interface{}
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		t.Fatalf("unable to decode the v1beta1 object: %v", err)
+	}
+	want := map[string]interface{}{"spec": obj["spec"], "status": obj["status"]}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("annotation %s differs from the v1beta1 spec and status:\n%s",
+			v1beta1.ConversionDataAnnotation, diff.ObjectReflectDiff(want, got))
+	}
+}
+
+func deleteConversionData(obj interface{}) {
+	o := obj.(metav1.Object)
+	annotations := o.GetAnnotations()
+	delete(annotations, v1beta1.ConversionDataAnnotation)
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+	o.SetAnnotations(annotations)
+}
+
+// metaFuncs are the fuzz functions for the metadata of objects: the
+// kind and version of an object are set by the scheme rather than
+// converted, and its managed fields are left out, as random bytes
+// cannot be encoded as fields.
+var metaFuncs = []interface{}{
+	func(m *metav1.TypeMeta, _ fuzz.Continue) {
+		*m = metav1.TypeMeta{}
+	},
+	func(m *metav1.ObjectMeta, cont fuzz.Continue) {
+		cont.FuzzNoCustom(m)
+		m.ManagedFields = nil
+	},
+}
+
+// fuzzer returns a fuzzer giving no empty slices or maps, which are
+// not told apart from nil ones once encoded, with the functions given.
+func fuzzer(funcs []interface{}) *fuzz.Fuzzer {
+	return fuzz.New().NilChance(.2).NumElements(1, 3).Funcs(metaFuncs...).Funcs(funcs...)
+}
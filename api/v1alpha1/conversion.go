@@ -0,0 +1,182 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	"github.com/fluxcd/image-reflector-controller/api/internal/conversiondata"
+	"github.com/fluxcd/image-reflector-controller/api/v1beta1"
+)
+
+// The types of this version are converted to and from those of v1beta1,
+// the version stored. v1beta1 has all the fields of this version, and
+// many more; an object converted from v1beta1 keeps its spec and status
+// as stored in an annotation, so that converting it back, e.g., when it
+// is updated, restores the fields this version does not have.
+
+// ConvertTo converts the ImageRepository to the v1beta1 ImageRepository.
+func (src *ImageRepository) ConvertTo(dstRaw conversion.Hub) error {
+	dst, ok := dstRaw.(*v1beta1.ImageRepository)
+	if !ok {
+		return fmt.Errorf("expected a v1beta1 ImageRepository, got %T", dstRaw)
+	}
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+
+	dst.Spec = v1beta1.ImageRepositorySpec{}
+	dst.Status = v1beta1.ImageRepositoryStatus{}
+	if err := conversiondata.Restore(&dst.ObjectMeta, &dst.Spec, &dst.Status); err != nil {
+		return err
+	}
+	spec := src.Spec
+	dst.Spec.Image = spec.Image
+	dst.Spec.Interval = spec.Interval
+	dst.Spec.Timeout = spec.Timeout
+	dst.Spec.SecretRef = spec.SecretRef
+	dst.Spec.CertSecretRef = spec.CertSecretRef
+	dst.Spec.Suspend = spec.Suspend
+
+	status := src.Status
+	dst.Status.Conditions = status.Conditions
+	dst.Status.ObservedGeneration = status.ObservedGeneration
+	dst.Status.CanonicalImageName = status.CanonicalImageName
+	dst.Status.ReconcileRequestStatus = status.ReconcileRequestStatus
+	if r := status.LastScanResult; r != nil {
+		if dst.Status.LastScanResult == nil {
+			dst.Status.LastScanResult = &v1beta1.ScanResult{}
+		}
+		dst.Status.LastScanResult.TagCount = r.TagCount
+		dst.Status.LastScanResult.ScanTime = r.ScanTime
+	} else {
+		dst.Status.LastScanResult = nil
+	}
+	return nil
+}
+
+// ConvertFrom converts the v1beta1 ImageRepository to this version.
+func (dst *ImageRepository) ConvertFrom(srcRaw conversion.Hub) error {
+	src, ok := srcRaw.(*v1beta1.ImageRepository)
+	if !ok {
+		return fmt.Errorf("expected a v1beta1 ImageRepository, got %T", srcRaw)
+	}
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+	if err := conversiondata.Keep(&dst.ObjectMeta, src.Spec, src.Status); err != nil {
+		return err
+	}
+
+	spec := src.Spec
+	dst.Spec = ImageRepositorySpec{
+		Image:         spec.Image,
+		Interval:      spec.Interval,
+		Timeout:       spec.Timeout,
+		SecretRef:     spec.SecretRef,
+		CertSecretRef: spec.CertSecretRef,
+		Suspend:       spec.Suspend,
+	}
+
+	status := src.Status
+	dst.Status = ImageRepositoryStatus{
+		Conditions:             status.Conditions,
+		ObservedGeneration:     status.ObservedGeneration,
+		CanonicalImageName:     status.CanonicalImageName,
+		ReconcileRequestStatus: status.ReconcileRequestStatus,
+	}
+	if r := status.LastScanResult; r != nil {
+		dst.Status.LastScanResult = &ScanResult{
+			TagCount: r.TagCount,
+			ScanTime: r.ScanTime,
+		}
+	}
+	return nil
+}
+
+// ConvertTo converts the ImagePolicy to the v1beta1 ImagePolicy.
+func (src *ImagePolicy) ConvertTo(dstRaw conversion.Hub) error {
+	dst, ok := dstRaw.(*v1beta1.ImagePolicy)
+	if !ok {
+		return fmt.Errorf("expected a v1beta1 ImagePolicy, got %T", dstRaw)
+	}
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+
+	dst.Spec = v1beta1.ImagePolicySpec{}
+	dst.Status = v1beta1.ImagePolicyStatus{}
+	if err := conversiondata.Restore(&dst.ObjectMeta, &dst.Spec, &dst.Status); err != nil {
+		return err
+	}
+	spec := src.Spec
+	// The namespace of the image repository, if any, is kept.
+	dst.Spec.ImageRepositoryRef.Name = spec.ImageRepositoryRef.Name
+	dst.Spec.FilterTags = (*v1beta1.TagFilter)(spec.FilterTags)
+	// A policy of this version replaces the one restored, but for the
+	// options of v1beta1; a policy this version cannot give, e.g., a
+	// calendar version policy, is kept.
+	if choice := spec.Policy; choice.SemVer != nil || choice.Alphabetical != nil || choice.Numerical != nil {
+		restored := dst.Spec.Policy
+		dst.Spec.Policy = v1beta1.ImagePolicyChoice{
+			Alphabetical: (*v1beta1.AlphabeticalPolicy)(choice.Alphabetical),
+			Numerical:    (*v1beta1.NumericalPolicy)(choice.Numerical),
+			SoakTime:     restored.SoakTime,
+		}
+		if s := choice.SemVer; s != nil {
+			dst.Spec.Policy.SemVer = &v1beta1.SemVerPolicy{Range: s.Range}
+			if restored.SemVer != nil {
+				dst.Spec.Policy.SemVer.VPrefix = restored.SemVer.VPrefix
+			}
+		}
+	}
+
+	status := src.Status
+	dst.Status.LatestImage = status.LatestImage
+	dst.Status.ObservedGeneration = status.ObservedGeneration
+	dst.Status.Conditions = status.Conditions
+	return nil
+}
+
+// ConvertFrom converts the v1beta1 ImagePolicy to this version.
+func (dst *ImagePolicy) ConvertFrom(srcRaw conversion.Hub) error {
+	src, ok := srcRaw.(*v1beta1.ImagePolicy)
+	if !ok {
+		return fmt.Errorf("expected a v1beta1 ImagePolicy, got %T", srcRaw)
+	}
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+	if err := conversiondata.Keep(&dst.ObjectMeta, src.Spec, src.Status); err != nil {
+		return err
+	}
+
+	spec := src.Spec
+	dst.Spec = ImagePolicySpec{
+		FilterTags: (*TagFilter)(spec.FilterTags),
+		Policy: ImagePolicyChoice{
+			Alphabetical: (*AlphabeticalPolicy)(spec.Policy.Alphabetical),
+			Numerical:    (*NumericalPolicy)(spec.Policy.Numerical),
+		},
+	}
+	dst.Spec.ImageRepositoryRef.Name = spec.ImageRepositoryRef.Name
+	if s := spec.Policy.SemVer; s != nil {
+		dst.Spec.Policy.SemVer = &SemVerPolicy{Range: s.Range}
+	}
+
+	status := src.Status
+	dst.Status = ImagePolicyStatus{
+		LatestImage:        status.LatestImage,
+		ObservedGeneration: status.ObservedGeneration,
+		Conditions:         status.Conditions,
+	}
+	return nil
+}
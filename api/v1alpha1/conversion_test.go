@@ -0,0 +1,42 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"testing"
+
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	"github.com/fluxcd/image-reflector-controller/api/internal/conversiontest"
+	"github.com/fluxcd/image-reflector-controller/api/v1beta1"
+)
+
+func TestImageRepository_roundTrip(t *testing.T) {
+	conversiontest.RoundTrip{
+		Spoke:    func() conversion.Convertible { return &ImageRepository{} },
+		Hub:      func() conversion.Hub { return &v1beta1.ImageRepository{} },
+		KeepsHub: true,
+	}.Run(t)
+}
+
+func TestImagePolicy_roundTrip(t *testing.T) {
+	conversiontest.RoundTrip{
+		Spoke:    func() conversion.Convertible { return &ImagePolicy{} },
+		Hub:      func() conversion.Hub { return &v1beta1.ImagePolicy{} },
+		KeepsHub: true,
+	}.Run(t)
+}
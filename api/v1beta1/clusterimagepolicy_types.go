@@ -0,0 +1,74 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const ClusterImagePolicyKind = "ClusterImagePolicy"
+
+// ClusterImagePolicySpec defines the policy rules that ImagePolicies
+// referring to a ClusterImagePolicy take, for those they do not give
+// themselves.
+type ClusterImagePolicySpec struct {
+	// Policy gives the particulars of the policy to be followed in
+	// selecting the most recent image.
+	// +optional
+	Policy *ImagePolicyChoice `json:"policy,omitempty"`
+	// FilterTags enables filtering for only a subset of tags based on a
+	// set of rules.
+	// +optional
+	FilterTags *TagFilter `json:"filterTags,omitempty"`
+	// Provenance requires the image selected to have a SLSA provenance
+	// attestation from a given builder.
+	// +optional
+	Provenance *ProvenancePolicy `json:"provenance,omitempty"`
+	// ImageLabelSelector selects the tags that refer to an image with
+	// labels in its config that match.
+	// +optional
+	ImageLabelSelector *metav1.LabelSelector `json:"imageLabelSelector,omitempty"`
+	// RequiredPlatforms are platforms that a tag must refer to an image
+	// for to be selected.
+	// +optional
+	RequiredPlatforms []string `json:"requiredPlatforms,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+
+// ClusterImagePolicy is the Schema for the clusterimagepolicies API. It
+// gives policy rules shared by the ImagePolicies referring to it.
+type ClusterImagePolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ClusterImagePolicySpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ClusterImagePolicyList contains a list of ClusterImagePolicy
+type ClusterImagePolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterImagePolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ClusterImagePolicy{}, &ClusterImagePolicyList{})
+}
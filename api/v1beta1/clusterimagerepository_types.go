@@ -0,0 +1,78 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const ClusterImageRepositoryKind = "ClusterImageRepository"
+
+// ClusterImageRepositorySpec defines the parameters for scanning an
+// image repository for ImagePolicies in any namespace.
+type ClusterImageRepositorySpec struct {
+	ImageRepositorySpec `json:",inline"`
+	// SecretNamespace is the namespace of the secrets and service
+	// account given by SecretRef, SecretRefs, CertSecretRef,
+	// ProxySecretRef and ServiceAccountName.
+	// +optional
+	SecretNamespace string `json:"secretNamespace,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Last scan",type=string,JSONPath=`.status.lastScanResult.scanTime`
+// +kubebuilder:printcolumn:name="Tags",type=string,JSONPath=`.status.lastScanResult.tagCount`
+
+// ClusterImageRepository is the Schema for the clusterimagerepositories
+// API. It is a cluster-scoped ImageRepository, which ImagePolicies in
+// any namespace allowed by AccessFrom can refer to.
+type ClusterImageRepository struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ClusterImageRepositorySpec `json:"spec,omitempty"`
+	// +kubebuilder:default={"observedGeneration":-1}
+	Status ImageRepositoryStatus `json:"status,omitempty"`
+}
+
+// ImageRepository returns an ImageRepository with the spec and status of
+// the cluster image repository, in the namespace of its secrets, so it
+// can be scanned and referred to as one.
+func (in *ClusterImageRepository) ImageRepository() *ImageRepository {
+	repo := &ImageRepository{
+		ObjectMeta: *in.ObjectMeta.DeepCopy(),
+		Spec:       *in.Spec.ImageRepositorySpec.DeepCopy(),
+		Status:     *in.Status.DeepCopy(),
+	}
+	repo.Namespace = in.Spec.SecretNamespace
+	return repo
+}
+
+// +kubebuilder:object:root=true
+
+// ClusterImageRepositoryList contains a list of ClusterImageRepository
+type ClusterImageRepositoryList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterImageRepository `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ClusterImageRepository{}, &ClusterImageRepositoryList{})
+}
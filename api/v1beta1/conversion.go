@@ -0,0 +1,31 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+// Hub marks ImageRepository as the version other versions are converted
+// to and from, being the version stored.
+func (*ImageRepository) Hub() {}
+
+// Hub marks ImagePolicy as the version other versions are converted to
+// and from, being the version stored.
+func (*ImagePolicy) Hub() {}
+
+// ConversionDataAnnotation is the annotation in which an object
+// converted to a version earlier than this one keeps its spec and status
+// as stored, so that the fields the earlier version does not have are
+// restored when the object is converted back.
+const ConversionDataAnnotation = "image.toolkit.fluxcd.io/conversion-data"
@@ -26,6 +26,11 @@ import (
 const ImagePolicyKind = "ImagePolicy"
 const ImagePolicyFinalizer = "finalizers.fluxcd.io"
 
+// AllowDowngradeAnnotation is the annotation which, when set to "true" on
+// an ImagePolicy with PreventDowngrade, lets it select an image ordered
+// lower than the one it has selected.
+const AllowDowngradeAnnotation = "image.toolkit.fluxcd.io/allow-downgrade"
+
 // ImagePolicySpec defines the parameters for calculating the
 // ImagePolicy
 type ImagePolicySpec struct {
@@ -33,17 +38,202 @@ type ImagePolicySpec struct {
 	// being scanned
 	// +required
 	ImageRepositoryRef meta.NamespacedObjectReference `json:"imageRepositoryRef"`
+	// ImageRepositoryKind is the kind of the object ImageRepositoryRef
+	// points at: an ImageRepository, or a ClusterImageRepository, in
+	// which case the namespace of ImageRepositoryRef is ignored.
+	// +kubebuilder:validation:Enum=ImageRepository;ClusterImageRepository
+	// +kubebuilder:default:=ImageRepository
+	// +optional
+	ImageRepositoryKind string `json:"imageRepositoryKind,omitempty"`
+	// FallbackImageRepositoryRefs points at ImageRepositories to use, in
+	// order, when the last scan of the one given by ImageRepositoryRef
+	// did not succeed, e.g., mirrors of the same images. The first that
+	// has been scanned successfully is used.
+	// +optional
+	FallbackImageRepositoryRefs []meta.NamespacedObjectReference `json:"fallbackImageRepositoryRefs,omitempty"`
 	// Policy gives the particulars of the policy to be followed in
-	// selecting the most recent image
-	// +required
-	Policy ImagePolicyChoice `json:"policy"`
+	// selecting the most recent image. It may be left out if the
+	// ClusterImagePolicy given by TemplateRef has one.
+	// +optional
+	Policy ImagePolicyChoice `json:"policy,omitempty"`
+	// TemplateRef names a ClusterImagePolicy whose policy rules are used
+	// for those not given here.
+	// +optional
+	TemplateRef *meta.LocalObjectReference `json:"templateRef,omitempty"`
 	// FilterTags enables filtering for only a subset of tags based on a set of
 	// rules. If no rules are provided, all the tags from the repository will be
 	// ordered and compared.
 	// +optional
 	FilterTags *TagFilter `json:"filterTags,omitempty"`
+	// DigestReflectionPolicy governs whether the digest of the image
+	// selected is resolved from the registry and recorded in
+	// `.status.latestDigest`. `Never` (the default) leaves it out;
+	// `IfNotPresent` resolves it when a different tag is selected; and
+	// `Always` resolves it every time the policy is evaluated, so that a
+	// tag moved to another image is noticed.
+	// +kubebuilder:default:="Never"
+	// +kubebuilder:validation:Enum=Never;IfNotPresent;Always
+	// +optional
+	DigestReflectionPolicy ReflectionPolicy `json:"digestReflectionPolicy,omitempty"`
+	// Provenance requires the image selected to have a SLSA provenance
+	// attestation from a given builder. Images without one are skipped,
+	// and the next image in the order given by the policy is considered.
+	// +optional
+	Provenance *ProvenancePolicy `json:"provenance,omitempty"`
+	// PreventDowngrade stops the policy moving to an image that is
+	// ordered lower than the image already selected, e.g., when the tag
+	// selected is deleted or the tag filter changes. This is overridden
+	// by the annotation `image.toolkit.fluxcd.io/allow-downgrade: "true"`.
+	// +optional
+	PreventDowngrade bool `json:"preventDowngrade,omitempty"`
+	// FreezeWindows are recurring periods during which the policy keeps
+	// the image it has selected, even if newer tags appear. The policy is
+	// evaluated again when a window ends.
+	// +optional
+	FreezeWindows []FreezeWindow `json:"freezeWindows,omitempty"`
+	// Pin holds the policy at the image given, whatever the tags scanned,
+	// e.g., while a problem with a newer image is dealt with. The policy
+	// is marked with the `Pinned` condition while it is set.
+	// +optional
+	Pin *ImagePin `json:"pin,omitempty"`
+	// LatestImageTemplate is a Go template for `.status.latestImage`,
+	// given the fields `.Image`, `.Registry`, `.Repository`, `.Tag` and
+	// `.Digest`, e.g., `{{.Registry}}/{{.Repository}}:{{.Tag}}@{{.Digest}}`.
+	// The digest is that in `.status.latestDigest`, which is resolved
+	// whenever the template refers to it; a Pin must then give the digest.
+	// Defaults to `{{.Image}}:{{.Tag}}`.
+	// +optional
+	LatestImageTemplate string `json:"latestImageTemplate,omitempty"`
+	// Platforms has the policy also select the latest image for each of
+	// the platforms given, e.g., `linux/arm64`, from the tags that refer
+	// to an image for that platform. These are recorded in
+	// `.status.latestPlatformImages`.
+	// +optional
+	Platforms []string `json:"platforms,omitempty"`
+	// RequiredPlatforms are platforms, e.g., `linux/arm64`, that a tag
+	// must refer to an image for to be selected, so that the policy does
+	// not move to a tag before the images for all of them are pushed.
+	// +optional
+	RequiredPlatforms []string `json:"requiredPlatforms,omitempty"`
+	// ImageLabelSelector selects the tags that refer to an image with
+	// labels in its config, e.g., `quality: stable`, that match. Other
+	// tags are skipped, and the next in the order given by the policy is
+	// considered.
+	// +optional
+	ImageLabelSelector *metav1.LabelSelector `json:"imageLabelSelector,omitempty"`
+	// DenylistRef refers to a ConfigMap in the same namespace listing
+	// tags that are never selected, e.g., of releases that have been
+	// withdrawn. Each value in the ConfigMap is a list of tags, one per
+	// line. The policy is evaluated again when the ConfigMap changes.
+	// +optional
+	DenylistRef *meta.LocalObjectReference `json:"denylistRef,omitempty"`
+	// DryRun has the policy evaluated without changing the image it has
+	// selected; the image it would select is recorded in
+	// `.status.dryRunImage` instead of `.status.latestImage`.
+	// +optional
+	DryRun bool `json:"dryRun,omitempty"`
+	// Interval is the length of time between evaluations of the policy,
+	// so that it is also evaluated when what it depends on outside the
+	// image repository changes, e.g., the attestations of images. When
+	// it is not given, the policy is evaluated only when the image
+	// repository or the policy changes.
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+}
+
+// PlatformImage is the latest image selected by an ImagePolicy for a
+// platform.
+type PlatformImage struct {
+	// Platform is the platform, as given in the ImagePolicy.
+	Platform string `json:"platform"`
+	// Image is the latest image for the platform, as given by
+	// LatestImageTemplate.
+	Image string `json:"image"`
+	// Tag is the tag of the image.
+	Tag string `json:"tag"`
+	// Digest is the digest of the image manifest for the platform.
+	Digest string `json:"digest"`
 }
 
+// ImageSelection is an image an ImagePolicy selected, and when.
+type ImageSelection struct {
+	// Image is the image, as given by LatestImageTemplate.
+	Image string `json:"image"`
+	// Tag is the tag of the image.
+	Tag string `json:"tag"`
+	// Digest is the digest of the image, if it was resolved.
+	// +optional
+	Digest string `json:"digest,omitempty"`
+	// SelectedTime is when the policy selected the image.
+	SelectedTime metav1.Time `json:"selectedTime"`
+}
+
+// ImagePin gives the image an ImagePolicy is pinned to.
+type ImagePin struct {
+	// Tag is the tag of the image.
+	// +required
+	Tag string `json:"tag"`
+	// Digest is the digest of the image, which is reported as the latest
+	// digest, if given.
+	// +kubebuilder:validation:Pattern="^sha256:[a-f0-9]{64}$"
+	// +optional
+	Digest string `json:"digest,omitempty"`
+}
+
+// FreezeWindow is a recurring period during which an ImagePolicy does not
+// change the image it has selected.
+type FreezeWindow struct {
+	// Schedule is a cron expression, with the fields minute, hour, day of
+	// month, month and day of week, giving the start of each window, e.g.,
+	// `0 18 * * 5` for 18:00 on Fridays.
+	// +required
+	Schedule string `json:"schedule"`
+	// Duration is how long each window lasts from its start.
+	// +required
+	Duration metav1.Duration `json:"duration"`
+	// TimeZone is the name of the time zone for the schedule, from the
+	// IANA time zone database, e.g., `Europe/Berlin`. Defaults to UTC.
+	// +optional
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+// ProvenancePolicy specifies the SLSA provenance required of an image
+// for it to be selected. Attestations are discovered among the
+// artifacts referring to the image in the registry.
+type ProvenancePolicy struct {
+	// BuilderID is the identity of the builder that must be named by the
+	// provenance attestation of the image, e.g.,
+	// `https://github.com/slsa-framework/slsa-github-generator/.github/workflows/generator_container_slsa3.yml@refs/tags/v1.5.0`.
+	// +required
+	BuilderID string `json:"builderID"`
+}
+
+// ReflectionPolicy describes when metadata of the selected image is
+// resolved and recorded in the status.
+type ReflectionPolicy string
+
+const (
+	// ReflectNever means the metadata is never resolved.
+	ReflectNever ReflectionPolicy = "Never"
+	// ReflectIfNotPresent means the metadata is resolved when a different
+	// image is selected, or it has not been resolved before.
+	ReflectIfNotPresent ReflectionPolicy = "IfNotPresent"
+	// ReflectAlways means the metadata is resolved every time the policy
+	// is evaluated.
+	ReflectAlways ReflectionPolicy = "Always"
+)
+
+// VPrefixPolicy describes how a semver policy treats the `v` prefix of
+// tags.
+type VPrefixPolicy string
+
+const (
+	// VPrefixKeep means the latest image has the tag as it is.
+	VPrefixKeep VPrefixPolicy = "Keep"
+	// VPrefixStrip means the latest image has the tag without the prefix.
+	VPrefixStrip VPrefixPolicy = "Strip"
+)
+
 // ImagePolicyChoice is a union of all the types of policy that can be
 // supplied.
 type ImagePolicyChoice struct {
@@ -57,6 +247,18 @@ type ImagePolicyChoice struct {
 	// Numerical set of rules to use for numerical ordering of the tags.
 	// +optional
 	Numerical *NumericalPolicy `json:"numerical,omitempty"`
+	// CalVer gives a calendar version format to parse the tags with; the
+	// tags are ordered by the dates and numbers in them.
+	// +optional
+	CalVer *CalVerPolicy `json:"calver,omitempty"`
+	// CreatedAt set of rules to use for ordering the tags by the creation
+	// time of the images they refer to.
+	// +optional
+	CreatedAt *CreatedAtPolicy `json:"createdAt,omitempty"`
+	// SoakTime is how long a tag must have been present in the image
+	// repository, as seen by its scans, before the policy can select it.
+	// +optional
+	SoakTime *metav1.Duration `json:"soakTime,omitempty"`
 }
 
 // SemVerPolicy specifies a semantic version policy.
@@ -65,6 +267,15 @@ type SemVerPolicy struct {
 	// version within the range that's a tag yields the latest image.
 	// +required
 	Range string `json:"range"`
+	// VPrefix, when given, treats tags with and without a `v` prefix,
+	// e.g., `v1.2.3` and `1.2.3`, as the same version, and gives whether
+	// the latest image keeps the prefix of the tag selected (`Keep`), or
+	// strips it (`Strip`). Of two tags that differ only by the prefix,
+	// the one with the prefix is selected when keeping it, and the one
+	// without when stripping it.
+	// +kubebuilder:validation:Enum=Keep;Strip
+	// +optional
+	VPrefix VPrefixPolicy `json:"vPrefix,omitempty"`
 }
 
 // AlphabeticalPolicy specifies a alphabetical ordering policy.
@@ -89,6 +300,30 @@ type NumericalPolicy struct {
 	Order string `json:"order,omitempty"`
 }
 
+// CalVerPolicy specifies a calendar versioning policy.
+type CalVerPolicy struct {
+	// Format gives the calendar version scheme of the tags, using the
+	// tokens described at https://calver.org (`YYYY`, `YY`, `0Y`, `MM`,
+	// `0M`, `WW`, `0W`, `DD`, `0D`, `MAJOR`, `MINOR` and `MICRO`) and the
+	// separators between them, e.g., `YYYY.0M.0D`. Tags that don't match
+	// the format are ignored; of those that do, the most recent is
+	// selected.
+	// +required
+	Format string `json:"format"`
+}
+
+// CreatedAtPolicy specifies a policy ordering the tags by the creation
+// time of the images they refer to.
+type CreatedAtPolicy struct {
+	// Order specifies the sorting order of the tags. Ascending order
+	// would select the most recently created image, and descending order
+	// would select the least recently created image.
+	// +kubebuilder:default:="asc"
+	// +kubebuilder:validation:Enum=asc;desc
+	// +optional
+	Order string `json:"order,omitempty"`
+}
+
 // TagFilter enables filtering tags based on a set of defined rules
 type TagFilter struct {
 	// Pattern specifies a regular expression pattern used to filter for image
@@ -96,7 +331,8 @@ type TagFilter struct {
 	// +optional
 	Pattern string `json:"pattern"`
 	// Extract allows a capture group to be extracted from the specified regular
-	// expression pattern, useful before tag evaluation.
+	// expression pattern, useful before tag evaluation. It may refer to
+	// several groups, by number or by name (e.g., `$major.$minor.$patch`).
 	// +optional
 	Extract string `json:"extract"`
 }
@@ -107,6 +343,35 @@ type ImagePolicyStatus struct {
 	// the image repository, when filtered and ordered according to
 	// the policy.
 	LatestImage string `json:"latestImage,omitempty"`
+	// LatestTag gives the tag of the image in LatestImage.
+	// +optional
+	LatestTag string `json:"latestTag,omitempty"`
+	// LatestDigest gives the digest of the image in LatestImage, when
+	// the DigestReflectionPolicy calls for it to be resolved.
+	// +optional
+	LatestDigest string `json:"latestDigest,omitempty"`
+	// LatestPlatformImages gives the latest image for each of the
+	// platforms in `.spec.platforms` for which there is one.
+	// +optional
+	LatestPlatformImages []PlatformImage `json:"latestPlatformImages,omitempty"`
+	// DryRunImage gives the image the policy would select, when
+	// `.spec.dryRun` is set.
+	// +optional
+	DryRunImage string `json:"dryRunImage,omitempty"`
+	// History are the images the policy has selected, most recent
+	// first, up to ten of them, starting with the one in LatestImage.
+	// +optional
+	History []ImageSelection `json:"history,omitempty"`
+	// Candidates are the tags ranked highest by the policy when it was
+	// last evaluated, in order, up to five of them. The tag selected is
+	// the first of them to pass the checks the policy makes of images.
+	// +optional
+	Candidates []string `json:"candidates,omitempty"`
+	// ImageRepositoryRef points at the image repository the policy was
+	// last evaluated against, which is one of the fallbacks if the image
+	// repository given in the spec was not scanned successfully.
+	// +optional
+	ImageRepositoryRef *meta.NamespacedObjectReference `json:"imageRepositoryRef,omitempty"`
 	// +optional
 	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
 	// +optional
@@ -117,14 +382,16 @@ func (p *ImagePolicy) GetStatusConditions() *[]metav1.Condition {
 	return &p.Status.Conditions
 }
 
-// SetImageRepositoryReadiness sets the ready condition with the given status, reason and message.
+// SetImageRepositoryReadiness sets the ready condition with the given status, reason and message,
+// for the current generation.
 func SetImagePolicyReadiness(p *ImagePolicy, status metav1.ConditionStatus, reason, message string) {
 	p.Status.ObservedGeneration = p.ObjectMeta.Generation
 	newCondition := metav1.Condition{
-		Type:    meta.ReadyCondition,
-		Status:  status,
-		Reason:  reason,
-		Message: message,
+		Type:               meta.ReadyCondition,
+		Status:             status,
+		ObservedGeneration: p.ObjectMeta.Generation,
+		Reason:             reason,
+		Message:            message,
 	}
 	apimeta.SetStatusCondition(p.GetStatusConditions(), newCondition)
 }
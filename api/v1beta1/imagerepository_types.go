@@ -36,15 +36,51 @@ type ImageRepositorySpec struct {
 	// +required
 	Image string `json:"image,omitempty"`
 	// Interval is the length of time to wait between
-	// scans of the image repository.
+	// scans of the image repository. It is not used to schedule the
+	// scans if Schedule is given.
 	// +required
 	Interval metav1.Duration `json:"interval,omitempty"`
 
+	// Schedule, if given, has the image repository scanned at the
+	// times of a cron expression, in place of each Interval.
+	// +optional
+	Schedule *ScanSchedule `json:"schedule,omitempty"`
+
+	// ScanWindows, if given, are the recurring periods during which
+	// scans are allowed, or denied. A scan due outside the windows
+	// allowing scans, or in a window denying them, waits until it is
+	// allowed.
+	// +optional
+	ScanWindows []ScanWindow `json:"scanWindows,omitempty"`
+
+	// Priority orders the image repositories waiting to be reconciled,
+	// and the scans waiting for the controller to have room for them,
+	// while it is scanning as many at once as it allows: those with a
+	// higher priority go first.
+	// Defaults to 0, and may be negative.
+	// +optional
+	Priority int `json:"priority,omitempty"`
+
 	// Timeout for image scanning.
 	// Defaults to 'Interval' duration.
 	// +optional
 	Timeout *metav1.Duration `json:"timeout,omitempty"`
 
+	// MaxInterval, if given, has the interval between scans doubled
+	// each time a scan finds the same tags as the scan before, up to
+	// MaxInterval; once a scan finds the tags changed, scans are each
+	// Interval again.
+	// +optional
+	MaxInterval *metav1.Duration `json:"maxInterval,omitempty"`
+
+	// FullListInterval, if given, has the tags listed from the highest
+	// of those recorded, rather than from the start, but once each
+	// FullListInterval in full, to find the tags removed and those added
+	// lower than the highest. It is not used with the Harbor artifacts
+	// API.
+	// +optional
+	FullListInterval *metav1.Duration `json:"fullListInterval,omitempty"`
+
 	// SecretRef can be given the name of a secret containing
 	// credentials to use for the image registry. The secret should be
 	// created with `kubectl create secret docker-registry`, or the
@@ -52,6 +88,14 @@ type ImageRepositorySpec struct {
 	// +optional
 	SecretRef *meta.LocalObjectReference `json:"secretRef,omitempty"`
 
+	// SecretRefs can be given the names of secrets containing
+	// credentials, as for SecretRef, to try in order: a scan uses the
+	// credentials of the first secret the registry accepts. They cannot
+	// be given with SecretRef; an image repository stored with both, from
+	// before this was refused, uses SecretRef.
+	// +optional
+	SecretRefs []meta.LocalObjectReference `json:"secretRefs,omitempty"`
+
 	// ServiceAccountName is the name of the Kubernetes ServiceAccount used to authenticate
 	// the image pull if the service account has attached pull secrets.
 	// +optional
@@ -71,6 +115,18 @@ type ImageRepositorySpec struct {
 	// +optional
 	CertSecretRef *meta.LocalObjectReference `json:"certSecretRef,omitempty"`
 
+	// ProxySecretRef can be given the name of a secret containing the
+	// address (`address`) of an HTTP proxy to use for connecting to the
+	// registry, and optionally the credentials (`username` and
+	// `password`) for the proxy. It overrides any proxy configured for
+	// the controller through the environment.
+	// +optional
+	ProxySecretRef *meta.LocalObjectReference `json:"proxySecretRef,omitempty"`
+
+	// Insecure allows connecting to a non-TLS HTTP container registry.
+	// +optional
+	Insecure bool `json:"insecure,omitempty"`
+
 	// This flag tells the controller to suspend subsequent image scans.
 	// It does not apply to already started scans. Defaults to false.
 	// +optional
@@ -85,11 +141,305 @@ type ImageRepositorySpec struct {
 	// from being stored in the database.
 	// +optional
 	ExclusionList []string `json:"exclusionList,omitempty"`
+
+	// InclusionList is a list of regex strings used to select the tags
+	// stored in the database; when given, only tags matching at least
+	// one of the regexes are stored. The ExclusionList is applied to the
+	// tags selected.
+	// +optional
+	InclusionList []string `json:"inclusionList,omitempty"`
+
+	// FetchMetadata tells the controller to fetch, after each scan, the
+	// metadata of the image each tag refers to: the digest and media
+	// type of its manifest, its creation time and its platforms. These
+	// are recorded in the database, so that policies need not fetch
+	// them, and fetched again when a tag is moved to another image.
+	// +optional
+	FetchMetadata bool `json:"fetchMetadata,omitempty"`
+
+	// TagLimit is the greatest number of tags stored in the database,
+	// after the InclusionList and ExclusionList are applied. The tags
+	// kept are those the ImagePolicies using the image repository would
+	// select first, then the most recent, ordered as semantic versions
+	// where they are one, and alphabetically otherwise. Zero means no
+	// limit. When not given, the limit set for the controller applies.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	TagLimit *int `json:"tagLimit,omitempty"`
+
+	// Provider configures how the controller logs in to the registry of
+	// a cloud provider, when it does so automatically.
+	// +optional
+	Provider *RegistryProvider `json:"provider,omitempty"`
+}
+
+// RegistryProvider configures the automatic login to the registries of
+// cloud providers.
+type RegistryProvider struct {
+	// Name is the provider to log in to the registry of, in place of
+	// that detected from the host of the image, for a registry at a
+	// custom domain fronting that of the provider: `aws` for Elastic
+	// Container Registry, `gcp` for Artifact Registry or Container
+	// Registry, and `azure` for Azure Container Registry. `generic` has
+	// no login attempted, whichever the host.
+	// +kubebuilder:validation:Enum=aws;gcp;azure;generic
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// AWS configures the login to Elastic Container Registry.
+	// +optional
+	AWS *AWSProvider `json:"aws,omitempty"`
+
+	// Azure configures the login to Azure Container Registry.
+	// +optional
+	Azure *AzureProvider `json:"azure,omitempty"`
+
+	// Harbor configures how the controller uses the API of a Harbor
+	// registry.
+	// +optional
+	Harbor *HarborProvider `json:"harbor,omitempty"`
+
+	// Exec has the credentials for the registry got from a docker
+	// credential helper supplied to the controller.
+	// +optional
+	Exec *ExecProvider `json:"exec,omitempty"`
+
+	// OIDC has a token requested for the service account given by
+	// ServiceAccountName, and exchanged for the credentials for the
+	// registry, for registries trusting the cluster as an OIDC issuer.
+	// +optional
+	OIDC *OIDCProvider `json:"oidc,omitempty"`
+
+	// Chain has the automatic login tried as well as the credentials of
+	// SecretRef, in place of only the secret being used when it is
+	// given. With `SecretFirst`, the login is used if the secret gives
+	// no credentials for the registry; with `ProviderFirst`, the secret
+	// is used if the login gives none.
+	// +kubebuilder:validation:Enum=SecretFirst;ProviderFirst
+	// +optional
+	Chain CredentialsChain `json:"chain,omitempty"`
+}
+
+// CredentialsChain describes the order in which the credentials of a
+// secret and those of the automatic login are tried.
+type CredentialsChain string
+
+const (
+	// ChainSecretFirst means the credentials of the secret are tried
+	// before the automatic login.
+	ChainSecretFirst CredentialsChain = "SecretFirst"
+	// ChainProviderFirst means the automatic login is tried before the
+	// credentials of the secret.
+	ChainProviderFirst CredentialsChain = "ProviderFirst"
+)
+
+// AWSProvider configures the login to Elastic Container Registry.
+type AWSProvider struct {
+	// RoleARN is the ARN of an IAM role the controller assumes before
+	// getting the token for the registry, e.g., to scan an image
+	// repository owned by another account. The role must trust the
+	// identity of the controller.
+	// +kubebuilder:validation:Pattern="^arn:aws[a-z-]*:iam::[0-9]{12}:role/.+$"
+	// +optional
+	RoleARN string `json:"roleARN,omitempty"`
+
+	// Region is the region of the registry, for a registry at a custom
+	// domain, whose host does not give it. Without it, the region of
+	// the controller is used.
+	// +kubebuilder:validation:Pattern="^[a-z]{2}(-[a-z]+)+-[0-9]+$"
+	// +optional
+	Region string `json:"region,omitempty"`
+}
+
+// HarborProvider configures how the controller uses the API of a Harbor
+// registry.
+type HarborProvider struct {
+	// ArtifactsAPI has the tags listed with the Harbor artifacts API in
+	// place of the registry API. This gives the digest of the image of
+	// each tag as well, so that the metadata of a tag is not fetched
+	// when its image has not changed. The image must be in a project,
+	// e.g., `harbor.example.com/project/app`.
+	// +optional
+	ArtifactsAPI bool `json:"artifactsAPI,omitempty"`
+}
+
+// ExecProvider selects a docker credential helper to get the
+// credentials for the registry from.
+type ExecProvider struct {
+	// Helper is the name of the credential helper, e.g., `example` for
+	// the binary `docker-credential-example` in the directory of
+	// credential helpers given to the controller.
+	// +kubebuilder:validation:Pattern="^[a-z0-9][a-z0-9._-]*$"
+	// +required
+	Helper string `json:"helper"`
+}
+
+// OIDCProvider configures the exchange of a token of a Kubernetes
+// service account for the credentials for the registry.
+type OIDCProvider struct {
+	// Exchange is how the token is exchanged: `GCP` exchanges it with
+	// the Google Security Token Service, with workload identity
+	// federation; `Password` sends it to the registry as the password
+	// of Username, e.g., for Harbor with OIDC authentication.
+	// +kubebuilder:validation:Enum=GCP;Password
+	// +required
+	Exchange OIDCExchange `json:"exchange"`
+
+	// Audience is the audience of the token requested for the service
+	// account, which the registry, or the service exchanging it, must
+	// accept.
+	// +required
+	Audience string `json:"audience"`
+
+	// WorkloadIdentityProvider is, for the `GCP` exchange, the full
+	// resource name of the provider of the workload identity pool
+	// trusting the cluster, e.g.,
+	// `//iam.googleapis.com/projects/123/locations/global/workloadIdentityPools/pool/providers/cluster`.
+	// +optional
+	WorkloadIdentityProvider string `json:"workloadIdentityProvider,omitempty"`
+
+	// GCPServiceAccount is, for the `GCP` exchange, the email of a
+	// Google service account for the federated identity to
+	// impersonate, if it is not allowed to read the registry itself.
+	// +optional
+	GCPServiceAccount string `json:"gcpServiceAccount,omitempty"`
+
+	// Username is, for the `Password` exchange, the user the token is
+	// sent as the password of.
+	// +optional
+	Username string `json:"username,omitempty"`
+}
+
+// OIDCExchange describes how a token of a Kubernetes service account is
+// exchanged for the credentials for a registry.
+type OIDCExchange string
+
+const (
+	// OIDCExchangeGCP means the token is exchanged with the Google
+	// Security Token Service.
+	OIDCExchangeGCP OIDCExchange = "GCP"
+	// OIDCExchangePassword means the token is sent as a password.
+	OIDCExchangePassword OIDCExchange = "Password"
+)
+
+// AzureProvider configures the login to Azure Container Registry.
+type AzureProvider struct {
+	// TenantID is the ID of the Azure tenant to authenticate in, in place
+	// of the default of the identity of the controller.
+	// +optional
+	TenantID string `json:"tenantID,omitempty"`
+
+	// ClientID is the client ID of a user-assigned managed identity to
+	// authenticate as, in place of the default identity of the
+	// controller. The identity must be assigned to the nodes, or the
+	// pod, of the controller.
+	// +optional
+	ClientID string `json:"clientID,omitempty"`
 }
 
 type ScanResult struct {
 	TagCount int         `json:"tagCount"`
 	ScanTime metav1.Time `json:"scanTime,omitempty"`
+	// Duration is how long the scan took, from listing the tags to
+	// recording them.
+	// +optional
+	Duration *metav1.Duration `json:"duration,omitempty"`
+	// Requests is how many requests the scan sent to the registry, to
+	// list the tags and fetch any metadata, including any retries.
+	// +optional
+	Requests int `json:"requests,omitempty"`
+	// LatestTags is a small sample of the tags found in the scan, sorted
+	// in descending order.
+	// +optional
+	LatestTags []string `json:"latestTags,omitempty"`
+	// RemovedTagCount is the number of the tags found by the previous
+	// scan that the registry no longer lists.
+	// +optional
+	RemovedTagCount int `json:"removedTagCount,omitempty"`
+	// RemovedTags are the tags most recently removed from the image
+	// repository, up to ten, with when each was last seen by a scan.
+	// +optional
+	RemovedTags []RemovedTag `json:"removedTags,omitempty"`
+}
+
+// RemovedTag is a tag no longer in the image repository.
+type RemovedTag struct {
+	Tag string `json:"tag"`
+	// LastSeen is the time of the last scan that found the tag.
+	LastSeen metav1.Time `json:"lastSeen"`
+}
+
+// RateLimit is the quota of pulls a registry reports for the
+// controller, e.g., that of Docker Hub.
+type RateLimit struct {
+	// Limit is the number of pulls allowed in each window.
+	Limit int `json:"limit"`
+	// Remaining is the number of pulls remaining in the window when
+	// last reported.
+	Remaining int `json:"remaining"`
+	// Window is the length of the window, if the registry says.
+	// +optional
+	Window *metav1.Duration `json:"window,omitempty"`
+	// ObservedTime is the time of the scan the registry last reported
+	// the quota to.
+	ObservedTime metav1.Time `json:"observedTime"`
+}
+
+// ScanSchedule gives the times an image repository is scanned at.
+type ScanSchedule struct {
+	// Cron is a cron expression, with the fields minute, hour, day of
+	// month, month and day of week, giving the times of the scans,
+	// e.g., `*/30 9-17 * * 1-5` for each half hour of working hours.
+	// +required
+	Cron string `json:"cron"`
+	// TimeZone is the name of the time zone for the schedule, from the
+	// IANA time zone database, e.g., `Europe/Berlin`. Defaults to UTC.
+	// +optional
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+const (
+	// ScanWindowAllow is the type of the scan windows only in which
+	// scans are allowed.
+	ScanWindowAllow = "Allow"
+	// ScanWindowDeny is the type of the scan windows in which scans are
+	// denied.
+	ScanWindowDeny = "Deny"
+)
+
+// ScanWindow is a recurring period during which the scans of an
+// ImageRepository are allowed, or denied.
+type ScanWindow struct {
+	// Schedule is a cron expression, with the fields minute, hour, day of
+	// month, month and day of week, giving the start of each window, e.g.,
+	// `0 1 * * *` for 01:00 each day.
+	// +required
+	Schedule string `json:"schedule"`
+	// Duration is how long each window lasts from its start.
+	// +required
+	Duration metav1.Duration `json:"duration"`
+	// TimeZone is the name of the time zone for the schedule, from the
+	// IANA time zone database, e.g., `Europe/Berlin`. Defaults to UTC.
+	// +optional
+	TimeZone string `json:"timeZone,omitempty"`
+	// Type is Allow if scans are allowed only in the windows of this
+	// type, or Deny if scans are denied in this window. Defaults to
+	// Allow.
+	// +kubebuilder:validation:Enum=Allow;Deny
+	// +kubebuilder:default:=Allow
+	// +optional
+	Type string `json:"type,omitempty"`
+}
+
+// ScanBackoff is the backoff from scanning an image repository while
+// its scans keep failing.
+type ScanBackoff struct {
+	// Failures is the number of scans in a row that failed.
+	Failures int `json:"failures"`
+	// Delay is how long after the last failed scan the next is made.
+	Delay metav1.Duration `json:"delay"`
+	// LastFailureTime is when the last scan failed.
+	LastFailureTime metav1.Time `json:"lastFailureTime"`
 }
 
 // ImageRepositoryStatus defines the observed state of ImageRepository
@@ -111,17 +461,71 @@ type ImageRepositoryStatus struct {
 	// +optional
 	LastScanResult *ScanResult `json:"lastScanResult,omitempty"`
 
+	// SecretRef is the secret of those in `.spec.secretRefs` whose
+	// credentials the last successful scan used.
+	// +optional
+	SecretRef *meta.LocalObjectReference `json:"secretRef,omitempty"`
+
+	// ScanCursor is the position in the tag listing at which an
+	// incomplete scan stopped; the next scan resumes from here rather
+	// than starting again.
+	// +optional
+	ScanCursor string `json:"scanCursor,omitempty"`
+
+	// PartialTagCount is the number of tags fetched so far by an
+	// incomplete scan, which are kept for the scan resumed from
+	// ScanCursor.
+	// +optional
+	PartialTagCount int `json:"partialTagCount,omitempty"`
+
+	// EffectiveInterval is the interval between scans, when
+	// `.spec.maxInterval` has it lengthened while scans find the tags
+	// unchanged.
+	// +optional
+	EffectiveInterval *metav1.Duration `json:"effectiveInterval,omitempty"`
+
+	// LastFullListTime is when the tags were last listed in full, while
+	// `.spec.fullListInterval` has them listed incrementally otherwise.
+	// +optional
+	LastFullListTime *metav1.Time `json:"lastFullListTime,omitempty"`
+
+	// RateLimit is the quota of pulls the registry last reported. When
+	// it is nearly exhausted, scans are put off to spread the pulls
+	// remaining over the window.
+	// +optional
+	RateLimit *RateLimit `json:"rateLimit,omitempty"`
+
+	// Backoff is the backoff from scanning the image repository while
+	// its scans keep failing; it is removed once a scan succeeds.
+	// +optional
+	Backoff *ScanBackoff `json:"backoff,omitempty"`
+
+	// NextScanTime is when the image repository is next to be scanned,
+	// after its interval or schedule, or the backoff from a failed scan.
+	// It is absent while the image repository is not to be scanned
+	// again, e.g., while it is suspended or stalled.
+	// +optional
+	NextScanTime *metav1.Time `json:"nextScanTime,omitempty"`
+
+	// Policies are the image policies using the image repository, by
+	// their image repository reference or a fallback reference, sorted
+	// by namespace and name.
+	// +optional
+	Policies []meta.NamespacedObjectReference `json:"policies,omitempty"`
+
 	meta.ReconcileRequestStatus `json:",inline"`
 }
 
-// SetImageRepositoryReadiness sets the ready condition with the given status, reason and message.
+// SetImageRepositoryReadiness sets the ready condition with the given status, reason and message,
+// for the current generation.
 func SetImageRepositoryReadiness(ir *ImageRepository, status metav1.ConditionStatus, reason, message string) {
 	ir.Status.ObservedGeneration = ir.ObjectMeta.Generation
 	newCondition := metav1.Condition{
-		Type:    meta.ReadyCondition,
-		Status:  status,
-		Reason:  reason,
-		Message: message,
+		Type:               meta.ReadyCondition,
+		Status:             status,
+		ObservedGeneration: ir.ObjectMeta.Generation,
+		Reason:             reason,
+		Message:            message,
 	}
 	apimeta.SetStatusCondition(ir.GetStatusConditions(), newCondition)
 }
@@ -131,9 +535,16 @@ func (in *ImageRepository) GetStatusConditions() *[]metav1.Condition {
 	return &in.Status.Conditions
 }
 
+// ScheduledScanTimeout is the timeout of the scans of an image repository
+// given a schedule, and neither an interval nor a timeout.
+const ScheduledScanTimeout = 5 * time.Minute
+
 // GetTimeout returns the timeout with default.
 func (in ImageRepository) GetTimeout() time.Duration {
 	duration := in.Spec.Interval.Duration
+	if duration == 0 && in.Spec.Schedule != nil {
+		duration = ScheduledScanTimeout
+	}
 	if in.Spec.Timeout != nil {
 		duration = in.Spec.Timeout.Duration
 	}
@@ -28,6 +28,21 @@ import (
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AWSProvider) DeepCopyInto(out *AWSProvider) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AWSProvider.
+func (in *AWSProvider) DeepCopy() *AWSProvider {
+	if in == nil {
+		return nil
+	}
+	out := new(AWSProvider)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AlphabeticalPolicy) DeepCopyInto(out *AlphabeticalPolicy) {
 	*out = *in
@@ -43,6 +58,285 @@ func (in *AlphabeticalPolicy) DeepCopy() *AlphabeticalPolicy {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AzureProvider) DeepCopyInto(out *AzureProvider) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AzureProvider.
+func (in *AzureProvider) DeepCopy() *AzureProvider {
+	if in == nil {
+		return nil
+	}
+	out := new(AzureProvider)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CalVerPolicy) DeepCopyInto(out *CalVerPolicy) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CalVerPolicy.
+func (in *CalVerPolicy) DeepCopy() *CalVerPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(CalVerPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterImagePolicy) DeepCopyInto(out *ClusterImagePolicy) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterImagePolicy.
+func (in *ClusterImagePolicy) DeepCopy() *ClusterImagePolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterImagePolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ClusterImagePolicy) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterImagePolicyList) DeepCopyInto(out *ClusterImagePolicyList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ClusterImagePolicy, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterImagePolicyList.
+func (in *ClusterImagePolicyList) DeepCopy() *ClusterImagePolicyList {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterImagePolicyList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ClusterImagePolicyList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterImagePolicySpec) DeepCopyInto(out *ClusterImagePolicySpec) {
+	*out = *in
+	if in.Policy != nil {
+		in, out := &in.Policy, &out.Policy
+		*out = new(ImagePolicyChoice)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.FilterTags != nil {
+		in, out := &in.FilterTags, &out.FilterTags
+		*out = new(TagFilter)
+		**out = **in
+	}
+	if in.Provenance != nil {
+		in, out := &in.Provenance, &out.Provenance
+		*out = new(ProvenancePolicy)
+		**out = **in
+	}
+	if in.ImageLabelSelector != nil {
+		in, out := &in.ImageLabelSelector, &out.ImageLabelSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.RequiredPlatforms != nil {
+		in, out := &in.RequiredPlatforms, &out.RequiredPlatforms
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterImagePolicySpec.
+func (in *ClusterImagePolicySpec) DeepCopy() *ClusterImagePolicySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterImagePolicySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterImageRepository) DeepCopyInto(out *ClusterImageRepository) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterImageRepository.
+func (in *ClusterImageRepository) DeepCopy() *ClusterImageRepository {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterImageRepository)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ClusterImageRepository) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterImageRepositoryList) DeepCopyInto(out *ClusterImageRepositoryList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ClusterImageRepository, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterImageRepositoryList.
+func (in *ClusterImageRepositoryList) DeepCopy() *ClusterImageRepositoryList {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterImageRepositoryList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ClusterImageRepositoryList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterImageRepositorySpec) DeepCopyInto(out *ClusterImageRepositorySpec) {
+	*out = *in
+	in.ImageRepositorySpec.DeepCopyInto(&out.ImageRepositorySpec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterImageRepositorySpec.
+func (in *ClusterImageRepositorySpec) DeepCopy() *ClusterImageRepositorySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterImageRepositorySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CreatedAtPolicy) DeepCopyInto(out *CreatedAtPolicy) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CreatedAtPolicy.
+func (in *CreatedAtPolicy) DeepCopy() *CreatedAtPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(CreatedAtPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ExecProvider) DeepCopyInto(out *ExecProvider) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ExecProvider.
+func (in *ExecProvider) DeepCopy() *ExecProvider {
+	if in == nil {
+		return nil
+	}
+	out := new(ExecProvider)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FreezeWindow) DeepCopyInto(out *FreezeWindow) {
+	*out = *in
+	out.Duration = in.Duration
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FreezeWindow.
+func (in *FreezeWindow) DeepCopy() *FreezeWindow {
+	if in == nil {
+		return nil
+	}
+	out := new(FreezeWindow)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HarborProvider) DeepCopyInto(out *HarborProvider) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HarborProvider.
+func (in *HarborProvider) DeepCopy() *HarborProvider {
+	if in == nil {
+		return nil
+	}
+	out := new(HarborProvider)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImagePin) DeepCopyInto(out *ImagePin) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImagePin.
+func (in *ImagePin) DeepCopy() *ImagePin {
+	if in == nil {
+		return nil
+	}
+	out := new(ImagePin)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ImagePolicy) DeepCopyInto(out *ImagePolicy) {
 	*out = *in
@@ -88,6 +382,21 @@ func (in *ImagePolicyChoice) DeepCopyInto(out *ImagePolicyChoice) {
 		*out = new(NumericalPolicy)
 		**out = **in
 	}
+	if in.CalVer != nil {
+		in, out := &in.CalVer, &out.CalVer
+		*out = new(CalVerPolicy)
+		**out = **in
+	}
+	if in.CreatedAt != nil {
+		in, out := &in.CreatedAt, &out.CreatedAt
+		*out = new(CreatedAtPolicy)
+		**out = **in
+	}
+	if in.SoakTime != nil {
+		in, out := &in.SoakTime, &out.SoakTime
+		*out = new(v1.Duration)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImagePolicyChoice.
@@ -136,12 +445,62 @@ func (in *ImagePolicyList) DeepCopyObject() runtime.Object {
 func (in *ImagePolicySpec) DeepCopyInto(out *ImagePolicySpec) {
 	*out = *in
 	out.ImageRepositoryRef = in.ImageRepositoryRef
+	if in.FallbackImageRepositoryRefs != nil {
+		in, out := &in.FallbackImageRepositoryRefs, &out.FallbackImageRepositoryRefs
+		*out = make([]meta.NamespacedObjectReference, len(*in))
+		copy(*out, *in)
+	}
 	in.Policy.DeepCopyInto(&out.Policy)
+	if in.TemplateRef != nil {
+		in, out := &in.TemplateRef, &out.TemplateRef
+		*out = new(meta.LocalObjectReference)
+		**out = **in
+	}
 	if in.FilterTags != nil {
 		in, out := &in.FilterTags, &out.FilterTags
 		*out = new(TagFilter)
 		**out = **in
 	}
+	if in.Provenance != nil {
+		in, out := &in.Provenance, &out.Provenance
+		*out = new(ProvenancePolicy)
+		**out = **in
+	}
+	if in.FreezeWindows != nil {
+		in, out := &in.FreezeWindows, &out.FreezeWindows
+		*out = make([]FreezeWindow, len(*in))
+		copy(*out, *in)
+	}
+	if in.Pin != nil {
+		in, out := &in.Pin, &out.Pin
+		*out = new(ImagePin)
+		**out = **in
+	}
+	if in.Platforms != nil {
+		in, out := &in.Platforms, &out.Platforms
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.RequiredPlatforms != nil {
+		in, out := &in.RequiredPlatforms, &out.RequiredPlatforms
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ImageLabelSelector != nil {
+		in, out := &in.ImageLabelSelector, &out.ImageLabelSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.DenylistRef != nil {
+		in, out := &in.DenylistRef, &out.DenylistRef
+		*out = new(meta.LocalObjectReference)
+		**out = **in
+	}
+	if in.Interval != nil {
+		in, out := &in.Interval, &out.Interval
+		*out = new(v1.Duration)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImagePolicySpec.
@@ -157,6 +516,28 @@ func (in *ImagePolicySpec) DeepCopy() *ImagePolicySpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ImagePolicyStatus) DeepCopyInto(out *ImagePolicyStatus) {
 	*out = *in
+	if in.LatestPlatformImages != nil {
+		in, out := &in.LatestPlatformImages, &out.LatestPlatformImages
+		*out = make([]PlatformImage, len(*in))
+		copy(*out, *in)
+	}
+	if in.History != nil {
+		in, out := &in.History, &out.History
+		*out = make([]ImageSelection, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Candidates != nil {
+		in, out := &in.Candidates, &out.Candidates
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ImageRepositoryRef != nil {
+		in, out := &in.ImageRepositoryRef, &out.ImageRepositoryRef
+		*out = new(meta.NamespacedObjectReference)
+		**out = **in
+	}
 	if in.Conditions != nil {
 		in, out := &in.Conditions, &out.Conditions
 		*out = make([]v1.Condition, len(*in))
@@ -239,21 +620,51 @@ func (in *ImageRepositoryList) DeepCopyObject() runtime.Object {
 func (in *ImageRepositorySpec) DeepCopyInto(out *ImageRepositorySpec) {
 	*out = *in
 	out.Interval = in.Interval
+	if in.Schedule != nil {
+		in, out := &in.Schedule, &out.Schedule
+		*out = new(ScanSchedule)
+		**out = **in
+	}
+	if in.ScanWindows != nil {
+		in, out := &in.ScanWindows, &out.ScanWindows
+		*out = make([]ScanWindow, len(*in))
+		copy(*out, *in)
+	}
 	if in.Timeout != nil {
 		in, out := &in.Timeout, &out.Timeout
 		*out = new(v1.Duration)
 		**out = **in
 	}
+	if in.MaxInterval != nil {
+		in, out := &in.MaxInterval, &out.MaxInterval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.FullListInterval != nil {
+		in, out := &in.FullListInterval, &out.FullListInterval
+		*out = new(v1.Duration)
+		**out = **in
+	}
 	if in.SecretRef != nil {
 		in, out := &in.SecretRef, &out.SecretRef
 		*out = new(meta.LocalObjectReference)
 		**out = **in
 	}
+	if in.SecretRefs != nil {
+		in, out := &in.SecretRefs, &out.SecretRefs
+		*out = make([]meta.LocalObjectReference, len(*in))
+		copy(*out, *in)
+	}
 	if in.CertSecretRef != nil {
 		in, out := &in.CertSecretRef, &out.CertSecretRef
 		*out = new(meta.LocalObjectReference)
 		**out = **in
 	}
+	if in.ProxySecretRef != nil {
+		in, out := &in.ProxySecretRef, &out.ProxySecretRef
+		*out = new(meta.LocalObjectReference)
+		**out = **in
+	}
 	if in.AccessFrom != nil {
 		in, out := &in.AccessFrom, &out.AccessFrom
 		*out = new(acl.AccessFrom)
@@ -264,6 +675,21 @@ func (in *ImageRepositorySpec) DeepCopyInto(out *ImageRepositorySpec) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.InclusionList != nil {
+		in, out := &in.InclusionList, &out.InclusionList
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.TagLimit != nil {
+		in, out := &in.TagLimit, &out.TagLimit
+		*out = new(int)
+		**out = **in
+	}
+	if in.Provider != nil {
+		in, out := &in.Provider, &out.Provider
+		*out = new(RegistryProvider)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageRepositorySpec.
@@ -291,6 +717,39 @@ func (in *ImageRepositoryStatus) DeepCopyInto(out *ImageRepositoryStatus) {
 		*out = new(ScanResult)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.SecretRef != nil {
+		in, out := &in.SecretRef, &out.SecretRef
+		*out = new(meta.LocalObjectReference)
+		**out = **in
+	}
+	if in.EffectiveInterval != nil {
+		in, out := &in.EffectiveInterval, &out.EffectiveInterval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.LastFullListTime != nil {
+		in, out := &in.LastFullListTime, &out.LastFullListTime
+		*out = (*in).DeepCopy()
+	}
+	if in.RateLimit != nil {
+		in, out := &in.RateLimit, &out.RateLimit
+		*out = new(RateLimit)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Backoff != nil {
+		in, out := &in.Backoff, &out.Backoff
+		*out = new(ScanBackoff)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.NextScanTime != nil {
+		in, out := &in.NextScanTime, &out.NextScanTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Policies != nil {
+		in, out := &in.Policies, &out.Policies
+		*out = make([]meta.NamespacedObjectReference, len(*in))
+		copy(*out, *in)
+	}
 	out.ReconcileRequestStatus = in.ReconcileRequestStatus
 }
 
@@ -304,6 +763,22 @@ func (in *ImageRepositoryStatus) DeepCopy() *ImageRepositoryStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImageSelection) DeepCopyInto(out *ImageSelection) {
+	*out = *in
+	in.SelectedTime.DeepCopyInto(&out.SelectedTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageSelection.
+func (in *ImageSelection) DeepCopy() *ImageSelection {
+	if in == nil {
+		return nil
+	}
+	out := new(ImageSelection)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NumericalPolicy) DeepCopyInto(out *NumericalPolicy) {
 	*out = *in
@@ -319,10 +794,166 @@ func (in *NumericalPolicy) DeepCopy() *NumericalPolicy {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OIDCProvider) DeepCopyInto(out *OIDCProvider) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OIDCProvider.
+func (in *OIDCProvider) DeepCopy() *OIDCProvider {
+	if in == nil {
+		return nil
+	}
+	out := new(OIDCProvider)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PlatformImage) DeepCopyInto(out *PlatformImage) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PlatformImage.
+func (in *PlatformImage) DeepCopy() *PlatformImage {
+	if in == nil {
+		return nil
+	}
+	out := new(PlatformImage)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ProvenancePolicy) DeepCopyInto(out *ProvenancePolicy) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ProvenancePolicy.
+func (in *ProvenancePolicy) DeepCopy() *ProvenancePolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(ProvenancePolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RateLimit) DeepCopyInto(out *RateLimit) {
+	*out = *in
+	if in.Window != nil {
+		in, out := &in.Window, &out.Window
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	in.ObservedTime.DeepCopyInto(&out.ObservedTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RateLimit.
+func (in *RateLimit) DeepCopy() *RateLimit {
+	if in == nil {
+		return nil
+	}
+	out := new(RateLimit)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RegistryProvider) DeepCopyInto(out *RegistryProvider) {
+	*out = *in
+	if in.AWS != nil {
+		in, out := &in.AWS, &out.AWS
+		*out = new(AWSProvider)
+		**out = **in
+	}
+	if in.Azure != nil {
+		in, out := &in.Azure, &out.Azure
+		*out = new(AzureProvider)
+		**out = **in
+	}
+	if in.Harbor != nil {
+		in, out := &in.Harbor, &out.Harbor
+		*out = new(HarborProvider)
+		**out = **in
+	}
+	if in.Exec != nil {
+		in, out := &in.Exec, &out.Exec
+		*out = new(ExecProvider)
+		**out = **in
+	}
+	if in.OIDC != nil {
+		in, out := &in.OIDC, &out.OIDC
+		*out = new(OIDCProvider)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RegistryProvider.
+func (in *RegistryProvider) DeepCopy() *RegistryProvider {
+	if in == nil {
+		return nil
+	}
+	out := new(RegistryProvider)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RemovedTag) DeepCopyInto(out *RemovedTag) {
+	*out = *in
+	in.LastSeen.DeepCopyInto(&out.LastSeen)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RemovedTag.
+func (in *RemovedTag) DeepCopy() *RemovedTag {
+	if in == nil {
+		return nil
+	}
+	out := new(RemovedTag)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScanBackoff) DeepCopyInto(out *ScanBackoff) {
+	*out = *in
+	out.Delay = in.Delay
+	in.LastFailureTime.DeepCopyInto(&out.LastFailureTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScanBackoff.
+func (in *ScanBackoff) DeepCopy() *ScanBackoff {
+	if in == nil {
+		return nil
+	}
+	out := new(ScanBackoff)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ScanResult) DeepCopyInto(out *ScanResult) {
 	*out = *in
 	in.ScanTime.DeepCopyInto(&out.ScanTime)
+	if in.Duration != nil {
+		in, out := &in.Duration, &out.Duration
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.LatestTags != nil {
+		in, out := &in.LatestTags, &out.LatestTags
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.RemovedTags != nil {
+		in, out := &in.RemovedTags, &out.RemovedTags
+		*out = make([]RemovedTag, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScanResult.
@@ -335,6 +966,37 @@ func (in *ScanResult) DeepCopy() *ScanResult {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScanSchedule) DeepCopyInto(out *ScanSchedule) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScanSchedule.
+func (in *ScanSchedule) DeepCopy() *ScanSchedule {
+	if in == nil {
+		return nil
+	}
+	out := new(ScanSchedule)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScanWindow) DeepCopyInto(out *ScanWindow) {
+	*out = *in
+	out.Duration = in.Duration
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScanWindow.
+func (in *ScanWindow) DeepCopy() *ScanWindow {
+	if in == nil {
+		return nil
+	}
+	out := new(ScanWindow)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SemVerPolicy) DeepCopyInto(out *SemVerPolicy) {
 	*out = *in
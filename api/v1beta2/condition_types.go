@@ -0,0 +1,94 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta2
+
+const (
+	// PinnedCondition indicates that an ImagePolicy reports the image
+	// given by its pin, rather than the image selected by its policy.
+	PinnedCondition string = "Pinned"
+
+	// ThrottledCondition indicates that the registry of an
+	// ImageRepository is throttling requests, and its scans are being
+	// put off.
+	ThrottledCondition string = "Throttled"
+
+	// IntervalClampedCondition indicates that the interval of an
+	// ImageRepository is shorter than the controller allows, and its
+	// scans are each the minimum interval instead.
+	IntervalClampedCondition string = "IntervalClamped"
+)
+
+const (
+	// ImageURLInvalidReason represents the fact that a given repository has an invalid image URL.
+	ImageURLInvalidReason string = "ImageURLInvalid"
+
+	// ScheduleInvalidReason represents the fact that a given repository
+	// has an invalid schedule.
+	ScheduleInvalidReason string = "ScheduleInvalid"
+
+	// DependencyNotReadyReason represents the fact that
+	// one of the dependencies is not ready.
+	DependencyNotReadyReason string = "DependencyNotReady"
+
+	// ReconciliationSucceededReason represents the fact that
+	// the reconciliation succeeded.
+	ReconciliationSucceededReason string = "ReconciliationSucceeded"
+
+	// ReconciliationFailedReason represents the fact that
+	// the reconciliation failed.
+	ReconciliationFailedReason string = "ReconciliationFailed"
+
+	// PinnedReason represents the fact that
+	// the policy is pinned to an image.
+	PinnedReason string = "Pinned"
+
+	// ThrottledReason represents the fact that
+	// the registry is throttling requests.
+	ThrottledReason string = "Throttled"
+
+	// RepositoryNotFoundReason represents the fact that
+	// the registry does not have the image repository.
+	RepositoryNotFoundReason string = "RepositoryNotFound"
+
+	// IntervalBelowMinimumReason represents the fact that
+	// the interval is shorter than the controller allows.
+	IntervalBelowMinimumReason string = "IntervalBelowMinimum"
+
+	// InvalidPolicyReason represents the fact that
+	// the rules of the policy cannot be followed.
+	InvalidPolicyReason string = "InvalidPolicy"
+
+	// ProgressingWithRetryReason represents the fact that
+	// the reconciliation failed, and is being retried.
+	ProgressingWithRetryReason string = "ProgressingWithRetry"
+
+	// AuthenticationFailedReason represents the fact that
+	// the registry refused the credentials given.
+	AuthenticationFailedReason string = "AuthenticationFailed"
+
+	// TLSVerificationFailedReason represents the fact that
+	// the certificate of the registry could not be verified.
+	TLSVerificationFailedReason string = "TLSVerificationFailed"
+
+	// RegistryUnavailableReason represents the fact that
+	// the registry could not be reached, or failed to respond.
+	RegistryUnavailableReason string = "RegistryUnavailable"
+
+	// InvalidSpecReason represents the fact that
+	// the spec has a field that is not valid.
+	InvalidSpecReason string = "InvalidSpec"
+)
@@ -0,0 +1,317 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta2
+
+import (
+	"fmt"
+
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	"github.com/fluxcd/image-reflector-controller/api/v1beta1"
+)
+
+// The types of this version are converted to and from those of v1beta1,
+// the version stored. Each field of one has a field of the other, so
+// that the conversion loses nothing either way; the types of most of the
+// fields are the same but for their package, and are converted directly.
+
+// ConvertTo converts the ImageRepository to the v1beta1 ImageRepository.
+func (src *ImageRepository) ConvertTo(dstRaw conversion.Hub) error {
+	dst, ok := dstRaw.(*v1beta1.ImageRepository)
+	if !ok {
+		return fmt.Errorf("expected a v1beta1 ImageRepository, got %T", dstRaw)
+	}
+	dst.ObjectMeta = src.ObjectMeta
+
+	spec := src.Spec
+	dst.Spec = v1beta1.ImageRepositorySpec{
+		Image:              spec.Image,
+		Interval:           spec.Interval,
+		Schedule:           (*v1beta1.ScanSchedule)(spec.Schedule),
+		ScanWindows:        convertSlice(spec.ScanWindows, func(w ScanWindow) v1beta1.ScanWindow { return v1beta1.ScanWindow(w) }),
+		Priority:           spec.Priority,
+		Timeout:            spec.Timeout,
+		MaxInterval:        spec.MaxInterval,
+		FullListInterval:   spec.FullListInterval,
+		SecretRef:          spec.SecretRef,
+		SecretRefs:         spec.SecretRefs,
+		ServiceAccountName: spec.ServiceAccountName,
+		CertSecretRef:      spec.CertSecretRef,
+		ProxySecretRef:     spec.ProxySecretRef,
+		Insecure:           spec.Insecure,
+		Suspend:            spec.Suspend,
+		AccessFrom:         spec.AccessFrom,
+		ExclusionList:      spec.ExcludeTags,
+		InclusionList:      spec.IncludeTags,
+		FetchMetadata:      spec.FetchMetadata,
+		TagLimit:           spec.MaxTags,
+	}
+	if p := spec.Provider; p != nil {
+		dst.Spec.Provider = &v1beta1.RegistryProvider{
+			Name:   p.Name,
+			AWS:    (*v1beta1.AWSProvider)(p.AWS),
+			Azure:  (*v1beta1.AzureProvider)(p.Azure),
+			Harbor: (*v1beta1.HarborProvider)(p.Harbor),
+			Exec:   (*v1beta1.ExecProvider)(p.Exec),
+			Chain:  v1beta1.CredentialsChain(p.Chain),
+		}
+		if o := p.OIDC; o != nil {
+			dst.Spec.Provider.OIDC = &v1beta1.OIDCProvider{
+				Exchange:                 v1beta1.OIDCExchange(o.Exchange),
+				Audience:                 o.Audience,
+				WorkloadIdentityProvider: o.WorkloadIdentityProvider,
+				GCPServiceAccount:        o.GCPServiceAccount,
+				Username:                 o.Username,
+			}
+		}
+	}
+
+	status := src.Status
+	dst.Status = v1beta1.ImageRepositoryStatus{
+		Conditions:             status.Conditions,
+		ObservedGeneration:     status.ObservedGeneration,
+		CanonicalImageName:     status.CanonicalName,
+		SecretRef:              status.SecretRef,
+		ScanCursor:             status.ScanCursor,
+		PartialTagCount:        status.PartialTagCount,
+		EffectiveInterval:      status.EffectiveInterval,
+		LastFullListTime:       status.LastFullListTime,
+		RateLimit:              (*v1beta1.RateLimit)(status.RateLimit),
+		Backoff:                (*v1beta1.ScanBackoff)(status.Backoff),
+		NextScanTime:           status.NextScanTime,
+		Policies:               status.Policies,
+		ReconcileRequestStatus: status.ReconcileRequestStatus,
+	}
+	if r := status.LastScanResult; r != nil {
+		dst.Status.LastScanResult = &v1beta1.ScanResult{
+			TagCount:        r.TagCount,
+			ScanTime:        r.ScanTime,
+			Duration:        r.Duration,
+			Requests:        r.Requests,
+			LatestTags:      r.LatestTags,
+			RemovedTagCount: r.RemovedTagCount,
+			RemovedTags:     convertSlice(r.RemovedTags, func(t RemovedTag) v1beta1.RemovedTag { return v1beta1.RemovedTag(t) }),
+		}
+	}
+	return nil
+}
+
+// ConvertFrom converts the v1beta1 ImageRepository to this version.
+func (dst *ImageRepository) ConvertFrom(srcRaw conversion.Hub) error {
+	src, ok := srcRaw.(*v1beta1.ImageRepository)
+	if !ok {
+		return fmt.Errorf("expected a v1beta1 ImageRepository, got %T", srcRaw)
+	}
+	dst.ObjectMeta = src.ObjectMeta
+
+	spec := src.Spec
+	dst.Spec = ImageRepositorySpec{
+		Image:              spec.Image,
+		Interval:           spec.Interval,
+		Schedule:           (*ScanSchedule)(spec.Schedule),
+		ScanWindows:        convertSlice(spec.ScanWindows, func(w v1beta1.ScanWindow) ScanWindow { return ScanWindow(w) }),
+		Priority:           spec.Priority,
+		Timeout:            spec.Timeout,
+		MaxInterval:        spec.MaxInterval,
+		FullListInterval:   spec.FullListInterval,
+		SecretRef:          spec.SecretRef,
+		SecretRefs:         spec.SecretRefs,
+		ServiceAccountName: spec.ServiceAccountName,
+		CertSecretRef:      spec.CertSecretRef,
+		ProxySecretRef:     spec.ProxySecretRef,
+		Insecure:           spec.Insecure,
+		Suspend:            spec.Suspend,
+		AccessFrom:         spec.AccessFrom,
+		ExcludeTags:        spec.ExclusionList,
+		IncludeTags:        spec.InclusionList,
+		FetchMetadata:      spec.FetchMetadata,
+		MaxTags:            spec.TagLimit,
+	}
+	if p := spec.Provider; p != nil {
+		dst.Spec.Provider = &RegistryProvider{
+			Name:   p.Name,
+			AWS:    (*AWSProvider)(p.AWS),
+			Azure:  (*AzureProvider)(p.Azure),
+			Harbor: (*HarborProvider)(p.Harbor),
+			Exec:   (*ExecProvider)(p.Exec),
+			Chain:  CredentialsChain(p.Chain),
+		}
+		if o := p.OIDC; o != nil {
+			dst.Spec.Provider.OIDC = &OIDCProvider{
+				Exchange:                 OIDCExchange(o.Exchange),
+				Audience:                 o.Audience,
+				WorkloadIdentityProvider: o.WorkloadIdentityProvider,
+				GCPServiceAccount:        o.GCPServiceAccount,
+				Username:                 o.Username,
+			}
+		}
+	}
+
+	status := src.Status
+	dst.Status = ImageRepositoryStatus{
+		Conditions:             status.Conditions,
+		ObservedGeneration:     status.ObservedGeneration,
+		CanonicalName:          status.CanonicalImageName,
+		SecretRef:              status.SecretRef,
+		ScanCursor:             status.ScanCursor,
+		PartialTagCount:        status.PartialTagCount,
+		EffectiveInterval:      status.EffectiveInterval,
+		LastFullListTime:       status.LastFullListTime,
+		RateLimit:              (*RateLimit)(status.RateLimit),
+		Backoff:                (*ScanBackoff)(status.Backoff),
+		NextScanTime:           status.NextScanTime,
+		Policies:               status.Policies,
+		ReconcileRequestStatus: status.ReconcileRequestStatus,
+	}
+	if r := status.LastScanResult; r != nil {
+		dst.Status.LastScanResult = &ScanResult{
+			TagCount:        r.TagCount,
+			ScanTime:        r.ScanTime,
+			Duration:        r.Duration,
+			Requests:        r.Requests,
+			LatestTags:      r.LatestTags,
+			RemovedTagCount: r.RemovedTagCount,
+			RemovedTags:     convertSlice(r.RemovedTags, func(t v1beta1.RemovedTag) RemovedTag { return RemovedTag(t) }),
+		}
+	}
+	return nil
+}
+
+// ConvertTo converts the ImagePolicy to the v1beta1 ImagePolicy.
+func (src *ImagePolicy) ConvertTo(dstRaw conversion.Hub) error {
+	dst, ok := dstRaw.(*v1beta1.ImagePolicy)
+	if !ok {
+		return fmt.Errorf("expected a v1beta1 ImagePolicy, got %T", dstRaw)
+	}
+	dst.ObjectMeta = src.ObjectMeta
+
+	spec := src.Spec
+	dst.Spec = v1beta1.ImagePolicySpec{
+		ImageRepositoryRef:          spec.ImageRepositoryRef,
+		ImageRepositoryKind:         spec.ImageRepositoryKind,
+		FallbackImageRepositoryRefs: spec.FallbackRepositoryRefs,
+		Policy: v1beta1.ImagePolicyChoice{
+			Alphabetical: (*v1beta1.AlphabeticalPolicy)(spec.Policy.Alphabetical),
+			Numerical:    (*v1beta1.NumericalPolicy)(spec.Policy.Numerical),
+			CalVer:       (*v1beta1.CalVerPolicy)(spec.Policy.CalVer),
+			CreatedAt:    (*v1beta1.CreatedAtPolicy)(spec.Policy.CreatedAt),
+			SoakTime:     spec.Policy.SoakTime,
+		},
+		TemplateRef:            spec.TemplateRef,
+		FilterTags:             (*v1beta1.TagFilter)(spec.TagFilter),
+		DigestReflectionPolicy: v1beta1.ReflectionPolicy(spec.DigestReflection),
+		Provenance:             (*v1beta1.ProvenancePolicy)(spec.Provenance),
+		PreventDowngrade:       spec.PreventDowngrade,
+		FreezeWindows:          convertSlice(spec.FreezeWindows, func(w FreezeWindow) v1beta1.FreezeWindow { return v1beta1.FreezeWindow(w) }),
+		Pin:                    (*v1beta1.ImagePin)(spec.Pin),
+		LatestImageTemplate:    spec.ImageTemplate,
+		Platforms:              spec.Platforms,
+		RequiredPlatforms:      spec.RequiredPlatforms,
+		ImageLabelSelector:     spec.ImageLabelSelector,
+		DenylistRef:            spec.DenylistRef,
+		DryRun:                 spec.DryRun,
+		Interval:               spec.Interval,
+	}
+	if s := spec.Policy.SemVer; s != nil {
+		dst.Spec.Policy.SemVer = &v1beta1.SemVerPolicy{Range: s.Range, VPrefix: v1beta1.VPrefixPolicy(s.VPrefix)}
+	}
+
+	status := src.Status
+	dst.Status = v1beta1.ImagePolicyStatus{
+		LatestPlatformImages: convertSlice(status.LatestPlatformImages, func(i PlatformImage) v1beta1.PlatformImage { return v1beta1.PlatformImage(i) }),
+		DryRunImage:          status.DryRunImage,
+		History:              convertSlice(status.History, func(s ImageSelection) v1beta1.ImageSelection { return v1beta1.ImageSelection(s) }),
+		Candidates:           status.Candidates,
+		ImageRepositoryRef:   status.ImageRepositoryRef,
+		ObservedGeneration:   status.ObservedGeneration,
+		Conditions:           status.Conditions,
+	}
+	if l := status.Latest; l != nil {
+		dst.Status.LatestImage = l.Image
+		dst.Status.LatestTag = l.Tag
+		dst.Status.LatestDigest = l.Digest
+	}
+	return nil
+}
+
+// ConvertFrom converts the v1beta1 ImagePolicy to this version.
+func (dst *ImagePolicy) ConvertFrom(srcRaw conversion.Hub) error {
+	src, ok := srcRaw.(*v1beta1.ImagePolicy)
+	if !ok {
+		return fmt.Errorf("expected a v1beta1 ImagePolicy, got %T", srcRaw)
+	}
+	dst.ObjectMeta = src.ObjectMeta
+
+	spec := src.Spec
+	dst.Spec = ImagePolicySpec{
+		ImageRepositoryRef:     spec.ImageRepositoryRef,
+		ImageRepositoryKind:    spec.ImageRepositoryKind,
+		FallbackRepositoryRefs: spec.FallbackImageRepositoryRefs,
+		Policy: ImagePolicyChoice{
+			Alphabetical: (*AlphabeticalPolicy)(spec.Policy.Alphabetical),
+			Numerical:    (*NumericalPolicy)(spec.Policy.Numerical),
+			CalVer:       (*CalVerPolicy)(spec.Policy.CalVer),
+			CreatedAt:    (*CreatedAtPolicy)(spec.Policy.CreatedAt),
+			SoakTime:     spec.Policy.SoakTime,
+		},
+		TemplateRef:        spec.TemplateRef,
+		TagFilter:          (*TagFilter)(spec.FilterTags),
+		DigestReflection:   ReflectionPolicy(spec.DigestReflectionPolicy),
+		Provenance:         (*ProvenancePolicy)(spec.Provenance),
+		PreventDowngrade:   spec.PreventDowngrade,
+		FreezeWindows:      convertSlice(spec.FreezeWindows, func(w v1beta1.FreezeWindow) FreezeWindow { return FreezeWindow(w) }),
+		Pin:                (*ImagePin)(spec.Pin),
+		ImageTemplate:      spec.LatestImageTemplate,
+		Platforms:          spec.Platforms,
+		RequiredPlatforms:  spec.RequiredPlatforms,
+		ImageLabelSelector: spec.ImageLabelSelector,
+		DenylistRef:        spec.DenylistRef,
+		DryRun:             spec.DryRun,
+		Interval:           spec.Interval,
+	}
+	if s := spec.Policy.SemVer; s != nil {
+		dst.Spec.Policy.SemVer = &SemVerPolicy{Range: s.Range, VPrefix: VPrefixPolicy(s.VPrefix)}
+	}
+
+	status := src.Status
+	dst.Status = ImagePolicyStatus{
+		LatestPlatformImages: convertSlice(status.LatestPlatformImages, func(i v1beta1.PlatformImage) PlatformImage { return PlatformImage(i) }),
+		DryRunImage:          status.DryRunImage,
+		History:              convertSlice(status.History, func(s v1beta1.ImageSelection) ImageSelection { return ImageSelection(s) }),
+		Candidates:           status.Candidates,
+		ImageRepositoryRef:   status.ImageRepositoryRef,
+		ObservedGeneration:   status.ObservedGeneration,
+		Conditions:           status.Conditions,
+	}
+	if status.LatestImage != "" || status.LatestTag != "" || status.LatestDigest != "" {
+		dst.Status.Latest = &LatestImage{Image: status.LatestImage, Tag: status.LatestTag, Digest: status.LatestDigest}
+	}
+	return nil
+}
+
+// convertSlice returns the items of the slice given, each converted, or
+// nil for a nil slice.
+func convertSlice[S, D any](items []S, convert func(S) D) []D {
+	if items == nil {
+		return nil
+	}
+	converted := make([]D, len(items))
+	for i := range items {
+		converted[i] = convert(items[i])
+	}
+	return converted
+}
@@ -0,0 +1,875 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+/*
+Copyright 2020 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by controller-gen. DO NOT EDIT.
+
+package v1beta2
+
+import (
+	"github.com/fluxcd/pkg/apis/acl"
+	"github.com/fluxcd/pkg/apis/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+)
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AWSProvider) DeepCopyInto(out *AWSProvider) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AWSProvider.
+func (in *AWSProvider) DeepCopy() *AWSProvider {
+	if in == nil {
+		return nil
+	}
+	out := new(AWSProvider)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AlphabeticalPolicy) DeepCopyInto(out *AlphabeticalPolicy) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AlphabeticalPolicy.
+func (in *AlphabeticalPolicy) DeepCopy() *AlphabeticalPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(AlphabeticalPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AzureProvider) DeepCopyInto(out *AzureProvider) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AzureProvider.
+func (in *AzureProvider) DeepCopy() *AzureProvider {
+	if in == nil {
+		return nil
+	}
+	out := new(AzureProvider)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CalVerPolicy) DeepCopyInto(out *CalVerPolicy) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CalVerPolicy.
+func (in *CalVerPolicy) DeepCopy() *CalVerPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(CalVerPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CreatedAtPolicy) DeepCopyInto(out *CreatedAtPolicy) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CreatedAtPolicy.
+func (in *CreatedAtPolicy) DeepCopy() *CreatedAtPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(CreatedAtPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ExecProvider) DeepCopyInto(out *ExecProvider) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ExecProvider.
+func (in *ExecProvider) DeepCopy() *ExecProvider {
+	if in == nil {
+		return nil
+	}
+	out := new(ExecProvider)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FreezeWindow) DeepCopyInto(out *FreezeWindow) {
+	*out = *in
+	out.Duration = in.Duration
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FreezeWindow.
+func (in *FreezeWindow) DeepCopy() *FreezeWindow {
+	if in == nil {
+		return nil
+	}
+	out := new(FreezeWindow)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HarborProvider) DeepCopyInto(out *HarborProvider) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HarborProvider.
+func (in *HarborProvider) DeepCopy() *HarborProvider {
+	if in == nil {
+		return nil
+	}
+	out := new(HarborProvider)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImagePin) DeepCopyInto(out *ImagePin) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImagePin.
+func (in *ImagePin) DeepCopy() *ImagePin {
+	if in == nil {
+		return nil
+	}
+	out := new(ImagePin)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImagePolicy) DeepCopyInto(out *ImagePolicy) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImagePolicy.
+func (in *ImagePolicy) DeepCopy() *ImagePolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(ImagePolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ImagePolicy) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImagePolicyChoice) DeepCopyInto(out *ImagePolicyChoice) {
+	*out = *in
+	if in.SemVer != nil {
+		in, out := &in.SemVer, &out.SemVer
+		*out = new(SemVerPolicy)
+		**out = **in
+	}
+	if in.Alphabetical != nil {
+		in, out := &in.Alphabetical, &out.Alphabetical
+		*out = new(AlphabeticalPolicy)
+		**out = **in
+	}
+	if in.Numerical != nil {
+		in, out := &in.Numerical, &out.Numerical
+		*out = new(NumericalPolicy)
+		**out = **in
+	}
+	if in.CalVer != nil {
+		in, out := &in.CalVer, &out.CalVer
+		*out = new(CalVerPolicy)
+		**out = **in
+	}
+	if in.CreatedAt != nil {
+		in, out := &in.CreatedAt, &out.CreatedAt
+		*out = new(CreatedAtPolicy)
+		**out = **in
+	}
+	if in.SoakTime != nil {
+		in, out := &in.SoakTime, &out.SoakTime
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImagePolicyChoice.
+func (in *ImagePolicyChoice) DeepCopy() *ImagePolicyChoice {
+	if in == nil {
+		return nil
+	}
+	out := new(ImagePolicyChoice)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImagePolicyList) DeepCopyInto(out *ImagePolicyList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ImagePolicy, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImagePolicyList.
+func (in *ImagePolicyList) DeepCopy() *ImagePolicyList {
+	if in == nil {
+		return nil
+	}
+	out := new(ImagePolicyList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ImagePolicyList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImagePolicySpec) DeepCopyInto(out *ImagePolicySpec) {
+	*out = *in
+	out.ImageRepositoryRef = in.ImageRepositoryRef
+	if in.FallbackRepositoryRefs != nil {
+		in, out := &in.FallbackRepositoryRefs, &out.FallbackRepositoryRefs
+		*out = make([]meta.NamespacedObjectReference, len(*in))
+		copy(*out, *in)
+	}
+	in.Policy.DeepCopyInto(&out.Policy)
+	if in.TemplateRef != nil {
+		in, out := &in.TemplateRef, &out.TemplateRef
+		*out = new(meta.LocalObjectReference)
+		**out = **in
+	}
+	if in.TagFilter != nil {
+		in, out := &in.TagFilter, &out.TagFilter
+		*out = new(TagFilter)
+		**out = **in
+	}
+	if in.Provenance != nil {
+		in, out := &in.Provenance, &out.Provenance
+		*out = new(ProvenancePolicy)
+		**out = **in
+	}
+	if in.FreezeWindows != nil {
+		in, out := &in.FreezeWindows, &out.FreezeWindows
+		*out = make([]FreezeWindow, len(*in))
+		copy(*out, *in)
+	}
+	if in.Pin != nil {
+		in, out := &in.Pin, &out.Pin
+		*out = new(ImagePin)
+		**out = **in
+	}
+	if in.Platforms != nil {
+		in, out := &in.Platforms, &out.Platforms
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.RequiredPlatforms != nil {
+		in, out := &in.RequiredPlatforms, &out.RequiredPlatforms
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ImageLabelSelector != nil {
+		in, out := &in.ImageLabelSelector, &out.ImageLabelSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.DenylistRef != nil {
+		in, out := &in.DenylistRef, &out.DenylistRef
+		*out = new(meta.LocalObjectReference)
+		**out = **in
+	}
+	if in.Interval != nil {
+		in, out := &in.Interval, &out.Interval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImagePolicySpec.
+func (in *ImagePolicySpec) DeepCopy() *ImagePolicySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ImagePolicySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImagePolicyStatus) DeepCopyInto(out *ImagePolicyStatus) {
+	*out = *in
+	if in.Latest != nil {
+		in, out := &in.Latest, &out.Latest
+		*out = new(LatestImage)
+		**out = **in
+	}
+	if in.LatestPlatformImages != nil {
+		in, out := &in.LatestPlatformImages, &out.LatestPlatformImages
+		*out = make([]PlatformImage, len(*in))
+		copy(*out, *in)
+	}
+	if in.History != nil {
+		in, out := &in.History, &out.History
+		*out = make([]ImageSelection, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Candidates != nil {
+		in, out := &in.Candidates, &out.Candidates
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ImageRepositoryRef != nil {
+		in, out := &in.ImageRepositoryRef, &out.ImageRepositoryRef
+		*out = new(meta.NamespacedObjectReference)
+		**out = **in
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImagePolicyStatus.
+func (in *ImagePolicyStatus) DeepCopy() *ImagePolicyStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ImagePolicyStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImageRepository) DeepCopyInto(out *ImageRepository) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageRepository.
+func (in *ImageRepository) DeepCopy() *ImageRepository {
+	if in == nil {
+		return nil
+	}
+	out := new(ImageRepository)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ImageRepository) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImageRepositoryList) DeepCopyInto(out *ImageRepositoryList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ImageRepository, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageRepositoryList.
+func (in *ImageRepositoryList) DeepCopy() *ImageRepositoryList {
+	if in == nil {
+		return nil
+	}
+	out := new(ImageRepositoryList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ImageRepositoryList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImageRepositorySpec) DeepCopyInto(out *ImageRepositorySpec) {
+	*out = *in
+	out.Interval = in.Interval
+	if in.Schedule != nil {
+		in, out := &in.Schedule, &out.Schedule
+		*out = new(ScanSchedule)
+		**out = **in
+	}
+	if in.ScanWindows != nil {
+		in, out := &in.ScanWindows, &out.ScanWindows
+		*out = make([]ScanWindow, len(*in))
+		copy(*out, *in)
+	}
+	if in.Timeout != nil {
+		in, out := &in.Timeout, &out.Timeout
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.MaxInterval != nil {
+		in, out := &in.MaxInterval, &out.MaxInterval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.FullListInterval != nil {
+		in, out := &in.FullListInterval, &out.FullListInterval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.SecretRef != nil {
+		in, out := &in.SecretRef, &out.SecretRef
+		*out = new(meta.LocalObjectReference)
+		**out = **in
+	}
+	if in.SecretRefs != nil {
+		in, out := &in.SecretRefs, &out.SecretRefs
+		*out = make([]meta.LocalObjectReference, len(*in))
+		copy(*out, *in)
+	}
+	if in.CertSecretRef != nil {
+		in, out := &in.CertSecretRef, &out.CertSecretRef
+		*out = new(meta.LocalObjectReference)
+		**out = **in
+	}
+	if in.ProxySecretRef != nil {
+		in, out := &in.ProxySecretRef, &out.ProxySecretRef
+		*out = new(meta.LocalObjectReference)
+		**out = **in
+	}
+	if in.AccessFrom != nil {
+		in, out := &in.AccessFrom, &out.AccessFrom
+		*out = new(acl.AccessFrom)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ExcludeTags != nil {
+		in, out := &in.ExcludeTags, &out.ExcludeTags
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.IncludeTags != nil {
+		in, out := &in.IncludeTags, &out.IncludeTags
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.MaxTags != nil {
+		in, out := &in.MaxTags, &out.MaxTags
+		*out = new(int)
+		**out = **in
+	}
+	if in.Provider != nil {
+		in, out := &in.Provider, &out.Provider
+		*out = new(RegistryProvider)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageRepositorySpec.
+func (in *ImageRepositorySpec) DeepCopy() *ImageRepositorySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ImageRepositorySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImageRepositoryStatus) DeepCopyInto(out *ImageRepositoryStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.LastScanResult != nil {
+		in, out := &in.LastScanResult, &out.LastScanResult
+		*out = new(ScanResult)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.SecretRef != nil {
+		in, out := &in.SecretRef, &out.SecretRef
+		*out = new(meta.LocalObjectReference)
+		**out = **in
+	}
+	if in.EffectiveInterval != nil {
+		in, out := &in.EffectiveInterval, &out.EffectiveInterval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.LastFullListTime != nil {
+		in, out := &in.LastFullListTime, &out.LastFullListTime
+		*out = (*in).DeepCopy()
+	}
+	if in.RateLimit != nil {
+		in, out := &in.RateLimit, &out.RateLimit
+		*out = new(RateLimit)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Backoff != nil {
+		in, out := &in.Backoff, &out.Backoff
+		*out = new(ScanBackoff)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.NextScanTime != nil {
+		in, out := &in.NextScanTime, &out.NextScanTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Policies != nil {
+		in, out := &in.Policies, &out.Policies
+		*out = make([]meta.NamespacedObjectReference, len(*in))
+		copy(*out, *in)
+	}
+	out.ReconcileRequestStatus = in.ReconcileRequestStatus
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageRepositoryStatus.
+func (in *ImageRepositoryStatus) DeepCopy() *ImageRepositoryStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ImageRepositoryStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImageSelection) DeepCopyInto(out *ImageSelection) {
+	*out = *in
+	in.SelectedTime.DeepCopyInto(&out.SelectedTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageSelection.
+func (in *ImageSelection) DeepCopy() *ImageSelection {
+	if in == nil {
+		return nil
+	}
+	out := new(ImageSelection)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LatestImage) DeepCopyInto(out *LatestImage) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LatestImage.
+func (in *LatestImage) DeepCopy() *LatestImage {
+	if in == nil {
+		return nil
+	}
+	out := new(LatestImage)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NumericalPolicy) DeepCopyInto(out *NumericalPolicy) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NumericalPolicy.
+func (in *NumericalPolicy) DeepCopy() *NumericalPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(NumericalPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OIDCProvider) DeepCopyInto(out *OIDCProvider) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OIDCProvider.
+func (in *OIDCProvider) DeepCopy() *OIDCProvider {
+	if in == nil {
+		return nil
+	}
+	out := new(OIDCProvider)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PlatformImage) DeepCopyInto(out *PlatformImage) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PlatformImage.
+func (in *PlatformImage) DeepCopy() *PlatformImage {
+	if in == nil {
+		return nil
+	}
+	out := new(PlatformImage)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ProvenancePolicy) DeepCopyInto(out *ProvenancePolicy) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ProvenancePolicy.
+func (in *ProvenancePolicy) DeepCopy() *ProvenancePolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(ProvenancePolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RateLimit) DeepCopyInto(out *RateLimit) {
+	*out = *in
+	if in.Window != nil {
+		in, out := &in.Window, &out.Window
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	in.ObservedTime.DeepCopyInto(&out.ObservedTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RateLimit.
+func (in *RateLimit) DeepCopy() *RateLimit {
+	if in == nil {
+		return nil
+	}
+	out := new(RateLimit)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RegistryProvider) DeepCopyInto(out *RegistryProvider) {
+	*out = *in
+	if in.AWS != nil {
+		in, out := &in.AWS, &out.AWS
+		*out = new(AWSProvider)
+		**out = **in
+	}
+	if in.Azure != nil {
+		in, out := &in.Azure, &out.Azure
+		*out = new(AzureProvider)
+		**out = **in
+	}
+	if in.Harbor != nil {
+		in, out := &in.Harbor, &out.Harbor
+		*out = new(HarborProvider)
+		**out = **in
+	}
+	if in.Exec != nil {
+		in, out := &in.Exec, &out.Exec
+		*out = new(ExecProvider)
+		**out = **in
+	}
+	if in.OIDC != nil {
+		in, out := &in.OIDC, &out.OIDC
+		*out = new(OIDCProvider)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RegistryProvider.
+func (in *RegistryProvider) DeepCopy() *RegistryProvider {
+	if in == nil {
+		return nil
+	}
+	out := new(RegistryProvider)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RemovedTag) DeepCopyInto(out *RemovedTag) {
+	*out = *in
+	in.LastSeen.DeepCopyInto(&out.LastSeen)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RemovedTag.
+func (in *RemovedTag) DeepCopy() *RemovedTag {
+	if in == nil {
+		return nil
+	}
+	out := new(RemovedTag)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScanBackoff) DeepCopyInto(out *ScanBackoff) {
+	*out = *in
+	out.Delay = in.Delay
+	in.LastFailureTime.DeepCopyInto(&out.LastFailureTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScanBackoff.
+func (in *ScanBackoff) DeepCopy() *ScanBackoff {
+	if in == nil {
+		return nil
+	}
+	out := new(ScanBackoff)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScanResult) DeepCopyInto(out *ScanResult) {
+	*out = *in
+	in.ScanTime.DeepCopyInto(&out.ScanTime)
+	if in.Duration != nil {
+		in, out := &in.Duration, &out.Duration
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.LatestTags != nil {
+		in, out := &in.LatestTags, &out.LatestTags
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.RemovedTags != nil {
+		in, out := &in.RemovedTags, &out.RemovedTags
+		*out = make([]RemovedTag, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScanResult.
+func (in *ScanResult) DeepCopy() *ScanResult {
+	if in == nil {
+		return nil
+	}
+	out := new(ScanResult)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScanSchedule) DeepCopyInto(out *ScanSchedule) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScanSchedule.
+func (in *ScanSchedule) DeepCopy() *ScanSchedule {
+	if in == nil {
+		return nil
+	}
+	out := new(ScanSchedule)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScanWindow) DeepCopyInto(out *ScanWindow) {
+	*out = *in
+	out.Duration = in.Duration
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScanWindow.
+func (in *ScanWindow) DeepCopy() *ScanWindow {
+	if in == nil {
+		return nil
+	}
+	out := new(ScanWindow)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SemVerPolicy) DeepCopyInto(out *SemVerPolicy) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SemVerPolicy.
+func (in *SemVerPolicy) DeepCopy() *SemVerPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(SemVerPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TagFilter) DeepCopyInto(out *TagFilter) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TagFilter.
+func (in *TagFilter) DeepCopy() *TagFilter {
+	if in == nil {
+		return nil
+	}
+	out := new(TagFilter)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/fluxcd/pkg/runtime/predicates"
+
+	imagev1 "github.com/fluxcd/image-reflector-controller/api/v1beta1"
+)
+
+// ClusterImageRepositoryReconciler reconciles a ClusterImageRepository
+// object. It scans the image repository as an ImageRepository in the
+// namespace of its secrets, and records the status on the
+// ClusterImageRepository.
+type ClusterImageRepositoryReconciler struct {
+	ImageRepositoryReconciler
+}
+
+// +kubebuilder:rbac:groups=image.toolkit.fluxcd.io,resources=clusterimagerepositories,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=image.toolkit.fluxcd.io,resources=clusterimagerepositories/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups="",resources=serviceaccounts,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=serviceaccounts/token,verbs=create
+func (r *ClusterImageRepositoryReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	var clusterRepo imagev1.ClusterImageRepository
+	if err := r.Get(ctx, req.NamespacedName, &clusterRepo); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	// The image repository is scanned as an ImageRepository, and its
+	// status copied back to the ClusterImageRepository.
+	imageRepo := clusterRepo.ImageRepository()
+	return r.reconcileRepository(ctx, imagev1.ClusterImageRepositoryKind, &clusterRepo, imageRepo, func() error {
+		clusterRepo.Status = imageRepo.Status
+		return r.patchStatus(ctx, &clusterRepo, clusterRepo.Status)
+	})
+}
+
+func (r *ClusterImageRepositoryReconciler) SetupWithManager(mgr ctrl.Manager, opts ImageRepositoryReconcilerOptions) error {
+	c, err := ctrl.NewControllerManagedBy(mgr).
+		For(&imagev1.ClusterImageRepository{}).
+		Watches(&source.Kind{Type: &imagev1.ImagePolicy{}}, handler.EnqueueRequestsFromMapFunc(imageRepositoriesForPolicy(true))).
+		WithEventFilter(predicate.Or(predicate.GenerationChangedPredicate{}, predicates.ReconcileRequestedPredicate{})).
+		WithOptions(controller.Options{
+			MaxConcurrentReconciles: opts.MaxConcurrentReconciles,
+		}).
+		Build(r)
+	if err != nil {
+		return err
+	}
+	return withPriorityQueue(c, func(req reconcile.Request) int {
+		var clusterRepo imagev1.ClusterImageRepository
+		if err := mgr.GetClient().Get(context.Background(), req.NamespacedName, &clusterRepo); err != nil {
+			return 0
+		}
+		return clusterRepo.Spec.Priority
+	})
+}
@@ -16,6 +16,12 @@ limitations under the License.
 
 package controllers
 
+import (
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
 // DatabaseWriter implementations record the tags for an image repository.
 type DatabaseWriter interface {
 	SetTags(repo string, tags []string) error
@@ -29,3 +35,125 @@ type DatabaseWriter interface {
 type DatabaseReader interface {
 	Tags(repo string) ([]string, error)
 }
+
+// DatabaseDeleter implementations remove everything recorded for an image
+// repository, e.g., once it is no longer scanned.
+type DatabaseDeleter interface {
+	DeleteRepository(repo string) error
+}
+
+// RepositoryLister implementations list the image repositories which
+// have anything recorded, e.g., to find those no longer scanned.
+type RepositoryLister interface {
+	Repositories() ([]string, error)
+}
+
+// ScanResultStore implementations record the result of a complete scan of
+// an image repository at once: its tags, when each was first seen, and
+// the removal of the tags of an incomplete scan. Implementations should
+// write these in one transaction where they can, so that a failure
+// leaves the result of the previous scan rather than part of each.
+type ScanResultStore interface {
+	SetScanResult(repo string, tags []string, firstSeen map[string]time.Time) error
+}
+
+// PartialScanStore implementations record the tags fetched so far by a scan
+// of an image repository that did not complete, so that the scan can be
+// resumed rather than restarted.
+//
+// If there is no incomplete scan for the repo, then implementations should
+// return an empty set of tags; setting an empty set of tags removes the
+// record.
+type PartialScanStore interface {
+	PartialTags(repo string) ([]string, error)
+	SetPartialTags(repo string, tags []string) error
+}
+
+// CreationTimeStore implementations cache the creation times of the images
+// that the tags of an image repository refer to, so that they need to be
+// fetched from the registry only once.
+//
+// If no creation time has been recorded for the tag, then implementations
+// should return false.
+type CreationTimeStore interface {
+	CreationTime(repo, tag string) (time.Time, bool, error)
+	SetCreationTime(repo, tag string, created time.Time) error
+}
+
+// FirstSeenStore implementations record when each of the tags of an image
+// repository was first seen by a scan.
+//
+// If nothing has been recorded for the repo, then implementations should
+// return false.
+type FirstSeenStore interface {
+	FirstSeen(repo string) (map[string]time.Time, bool, error)
+	SetFirstSeen(repo string, firstSeen map[string]time.Time) error
+}
+
+// LastSeenStore implementations record when each of the tags removed from
+// an image repository was last seen by a scan. The tags still present were
+// last seen by the latest scan.
+//
+// If nothing has been recorded for the repo, then implementations should
+// return false.
+type LastSeenStore interface {
+	LastSeen(repo string) (map[string]time.Time, bool, error)
+	SetLastSeen(repo string, lastSeen map[string]time.Time) error
+}
+
+// PlatformStore implementations cache the platforms of the images that the
+// tags of an image repository refer to, with the digest of the image
+// manifest for each platform, so that they need to be fetched from the
+// registry only once.
+//
+// If no platforms have been recorded for the tag, then implementations
+// should return false.
+type PlatformStore interface {
+	Platforms(repo, tag string) (map[string]string, bool, error)
+	SetPlatforms(repo, tag string, platforms map[string]string) error
+}
+
+// DescriptorStore implementations record the descriptor of the manifest
+// each of the tags of an image repository refers to, as last fetched, so
+// that a tag moved to another image can be told apart.
+//
+// If no descriptor has been recorded for the tag, then implementations
+// should return false.
+type DescriptorStore interface {
+	Descriptor(repo, tag string) (v1.Descriptor, bool, error)
+	SetDescriptor(repo, tag string, desc v1.Descriptor) error
+}
+
+// ImageConfigStore implementations cache the config blobs of the images
+// that the tags of an image repository refer to, so that they need to be
+// fetched from the registry only once.
+//
+// If no config has been recorded for the tag, then implementations should
+// return false.
+type ImageConfigStore interface {
+	ImageConfig(repo, tag string) ([]byte, bool, error)
+	SetImageConfig(repo, tag string, config []byte) error
+}
+
+// ProvenanceStore implementations cache the builders named by the SLSA
+// provenance attestations for the images that the tags of an image
+// repository refer to, so that they need to be fetched from the registry
+// only once. An image without attestations has no builders recorded.
+//
+// If no builders have been recorded for the tag, then implementations
+// should return false.
+type ProvenanceStore interface {
+	Provenance(repo, tag string) ([]string, bool, error)
+	SetProvenance(repo, tag string, builders []string) error
+}
+
+// TagListValidatorStore implementations record the validators a registry
+// gave with the last listing of the tags of an image repository, so that
+// the next listing can be a conditional request.
+//
+// If no validators have been recorded for the repo, then implementations
+// should return false; setting empty validators removes the record.
+type TagListValidatorStore interface {
+	TagListValidators(repo string) ([]byte, bool, error)
+	SetTagListValidators(repo string, validators []byte) error
+}
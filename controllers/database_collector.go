@@ -0,0 +1,105 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	imagev1 "github.com/fluxcd/image-reflector-controller/api/v1beta1"
+)
+
+// DatabaseCollector periodically deletes from the database the records of
+// images which no ImageRepository or ClusterImageRepository scans, e.g.,
+// those left behind by repositories deleted while the controller was not
+// running. It is run by the manager, when elected leader.
+type DatabaseCollector struct {
+	// Reader lists the image repositories. It should read from the API
+	// server rather than a cache, so that an image recorded by a scan is
+	// always seen to be scanned.
+	Reader   client.Reader
+	Database interface {
+		RepositoryLister
+		DatabaseDeleter
+	}
+	Interval time.Duration
+}
+
+// Start implements manager.Runnable, collecting the records once each
+// interval until the context is done.
+func (c *DatabaseCollector) Start(ctx context.Context) error {
+	log := ctrl.Log.WithName("database-collector")
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			deleted, err := c.collect(ctx)
+			if err != nil {
+				log.Error(err, "unable to delete the records of images no longer scanned")
+			}
+			if len(deleted) > 0 {
+				log.Info("deleted the records of images no longer scanned", "images", deleted)
+			}
+		}
+	}
+}
+
+// collect deletes the records of the images which no image repository
+// scans, returning the canonical names of those deleted.
+func (c *DatabaseCollector) collect(ctx context.Context) ([]string, error) {
+	// The recorded images are listed before the image repositories, since
+	// the canonical name of an image is in the status of its image
+	// repository before the image is recorded.
+	recorded, err := c.Database.Repositories()
+	if err != nil {
+		return nil, err
+	}
+
+	scanned := map[string]struct{}{}
+	var repos imagev1.ImageRepositoryList
+	if err := c.Reader.List(ctx, &repos); err != nil {
+		return nil, err
+	}
+	for _, repo := range repos.Items {
+		scanned[repo.Status.CanonicalImageName] = struct{}{}
+	}
+	var clusterRepos imagev1.ClusterImageRepositoryList
+	if err := c.Reader.List(ctx, &clusterRepos); err != nil {
+		return nil, err
+	}
+	for _, repo := range clusterRepos.Items {
+		scanned[repo.Status.CanonicalImageName] = struct{}{}
+	}
+
+	var deleted []string
+	for _, name := range recorded {
+		if _, ok := scanned[name]; ok {
+			continue
+		}
+		if err := c.Database.DeleteRepository(name); err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, name)
+	}
+	return deleted, nil
+}
@@ -18,20 +18,35 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+	"text/template/parse"
 	"time"
 
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	kuberecorder "k8s.io/client-go/tools/record"
 	"k8s.io/client-go/tools/reference"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
@@ -43,6 +58,9 @@ import (
 
 	imagev1 "github.com/fluxcd/image-reflector-controller/api/v1beta1"
 	"github.com/fluxcd/image-reflector-controller/internal/policy"
+	"github.com/fluxcd/image-reflector-controller/internal/registry"
+	"github.com/fluxcd/image-reflector-controller/internal/registry/login"
+	"github.com/fluxcd/image-reflector-controller/internal/registry/throttle"
 )
 
 // this is used as the key for the index of policy->repository; the
@@ -50,14 +68,49 @@ import (
 // from.
 const imageRepoKey = ".spec.imageRepository"
 
+// denylistKey is the key used for indexing image policies by the
+// ConfigMap they take their tag denylist from.
+const denylistKey = ".spec.denylistRef"
+
+// templateKey is the key used for indexing image policies by the
+// ClusterImagePolicy they take policy rules from.
+const templateKey = ".spec.templateRef"
+
 // ImagePolicyReconciler reconciles a ImagePolicy object
 type ImagePolicyReconciler struct {
 	client.Client
 	Scheme          *runtime.Scheme
 	EventRecorder   kuberecorder.EventRecorder
 	MetricsRecorder *metrics.Recorder
-	Database        DatabaseReader
-	ACLOptions      acl.Options
+	Database        interface {
+		DatabaseReader
+		CreationTimeStore
+		FirstSeenStore
+		PlatformStore
+		ImageConfigStore
+		ProvenanceStore
+		DescriptorStore
+	}
+	ACLOptions acl.Options
+	login.ProviderOptions
+	// APIReader reads the ConfigMaps of denylists from the API server,
+	// since only their metadata is cached. Unset, they are read with the
+	// client.
+	APIReader client.Reader
+	// ReadOnly keeps the image each policy has selected, and reports the
+	// image it would select instead in an event.
+	ReadOnly bool
+	// RegistryBackoff and ScanSlots are those of the image repository
+	// reconciler, so that what policies fetch from registries for their
+	// checks waits for the same slots as scans, and is not fetched from
+	// a registry being backed off from. Nil means no backoff, and no
+	// bound.
+	RegistryBackoff *throttle.Backoff
+	ScanSlots       *throttle.Slots
+
+	// platformsRefreshed records the tags whose platforms have been
+	// refreshed since the last scan of each image repository.
+	platformsRefreshed refreshedTags
 }
 
 type ImagePolicyReconcilerOptions struct {
@@ -67,7 +120,13 @@ type ImagePolicyReconcilerOptions struct {
 // +kubebuilder:rbac:groups=image.toolkit.fluxcd.io,resources=imagepolicies,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=image.toolkit.fluxcd.io,resources=imagepolicies/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=image.toolkit.fluxcd.io,resources=imagerepositories,verbs=get;list;watch
+// +kubebuilder:rbac:groups=image.toolkit.fluxcd.io,resources=clusterimagepolicies,verbs=get;list;watch
+// +kubebuilder:rbac:groups=image.toolkit.fluxcd.io,resources=clusterimagerepositories,verbs=get;list;watch
 // +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=serviceaccounts,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=serviceaccounts/token,verbs=create
 // +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 func (r *ImagePolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -118,6 +177,10 @@ func (r *ImagePolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 	if pol.Spec.ImageRepositoryRef.Namespace != "" {
 		repoNamespacedName.Namespace = pol.Spec.ImageRepositoryRef.Namespace
 	}
+	clusterRepo := pol.Spec.ImageRepositoryKind == imagev1.ClusterImageRepositoryKind
+	if clusterRepo {
+		repoNamespacedName.Namespace = ""
+	}
 
 	recordError := func(err error, reason string) (ctrl.Result, error) {
 		r.event(ctx, pol, events.EventSeverityError, err.Error())
@@ -134,24 +197,114 @@ func (r *ImagePolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 	}
 
 	// check if we're allowed to reference across namespaces, before trying to fetch it
-	if r.ACLOptions.NoCrossNamespaceRefs && repoNamespacedName.Namespace != pol.GetNamespace() {
+	if r.ACLOptions.NoCrossNamespaceRefs && !clusterRepo && repoNamespacedName.Namespace != pol.GetNamespace() {
 		err := fmt.Errorf("cannot access '%s/%s', cross-namespace references have been blocked", imagev1.ImageRepositoryKind, repoNamespacedName)
 		// this cannot proceed until the spec changes, so no need to requeue explicitly
 		return recordErrorAndLog(err, "access denied to cross-namespace ImageRepository", aclapi.AccessDeniedReason)
 	}
 
-	if err := r.Get(ctx, repoNamespacedName, &repo); err != nil {
-		if client.IgnoreNotFound(err) == nil {
-			return recordErrorAndLog(err, "referenced ImageRepository does not exist", imagev1.DependencyNotReadyReason)
+	if clusterRepo {
+		var clusterImageRepo imagev1.ClusterImageRepository
+		if err := r.Get(ctx, repoNamespacedName, &clusterImageRepo); err != nil {
+			if client.IgnoreNotFound(err) == nil {
+				return recordErrorAndLog(err, "referenced ClusterImageRepository does not exist", imagev1.DependencyNotReadyReason)
+			}
+			return ctrl.Result{}, err
+		}
+
+		// check if we are allowed to use the referenced ClusterImageRepository
+		if err := r.hasAccessToClusterRepository(ctx, &pol, &clusterImageRepo); err != nil {
+			return recordErrorAndLog(err, "access denied", aclapi.AccessDeniedReason)
+		}
+		repo = *clusterImageRepo.ImageRepository()
+	} else {
+		if err := r.Get(ctx, repoNamespacedName, &repo); err != nil {
+			if client.IgnoreNotFound(err) == nil {
+				return recordErrorAndLog(err, "referenced ImageRepository does not exist", imagev1.DependencyNotReadyReason)
+			}
+			return ctrl.Result{}, err
+		}
+
+		// check if we are allowed to use the referenced ImageRepository
+
+		aclAuth := acl.NewAuthorization(r.Client)
+		if err := aclAuth.HasAccessToRef(ctx, &pol, repoNamespacedName, repo.Spec.AccessFrom); err != nil {
+			return recordErrorAndLog(err, "access denied", aclapi.AccessDeniedReason)
 		}
-		return ctrl.Result{}, err
 	}
 
-	// check if we are allowed to use the referenced ImageRepository
+	// fall back to the first of the fallback image repositories to have
+	// been scanned successfully, if the image repository has not been
+	// scanned, or its last scan failed; the policy goes back to the image
+	// repository once a scan of it succeeds
+	if len(pol.Spec.FallbackImageRepositoryRefs) > 0 && !scanSucceeded(&repo) {
+		if fallback, fallbackName, ok := r.fallbackImageRepository(ctx, &pol); ok {
+			repo, repoNamespacedName = *fallback, fallbackName
+		}
+	}
+	pol.Status.ImageRepositoryRef = &meta.NamespacedObjectReference{
+		Name:      repoNamespacedName.Name,
+		Namespace: repoNamespacedName.Namespace,
+	}
 
-	aclAuth := acl.NewAuthorization(r.Client)
-	if err := aclAuth.HasAccessToRef(ctx, &pol, repoNamespacedName, repo.Spec.AccessFrom); err != nil {
-		return recordErrorAndLog(err, "access denied", aclapi.AccessDeniedReason)
+	// take the policy rules not given by the policy from its template
+	if pol.Spec.TemplateRef != nil {
+		var tmpl imagev1.ClusterImagePolicy
+		if err := r.Get(ctx, types.NamespacedName{Name: pol.Spec.TemplateRef.Name}, &tmpl); err != nil {
+			if client.IgnoreNotFound(err) == nil {
+				return recordErrorAndLog(err, "referenced ClusterImagePolicy does not exist", imagev1.DependencyNotReadyReason)
+			}
+			return ctrl.Result{}, err
+		}
+		applyTemplate(&pol.Spec, tmpl.Spec)
+	}
+
+	// report the image pinned, if there is one, whatever has been scanned
+	if pin := pol.Spec.Pin; pin != nil {
+		// A pin without a digest, admitted before the combination was
+		// rejected, has the digest resolved if the template needs it.
+		digest := pin.Digest
+		if digest == "" && templateUsesDigest(pol.Spec.LatestImageTemplate) {
+			var err error
+			if digest, err = r.resolveDigest(ctx, &repo, pin.Tag); err != nil {
+				return recordErrorAndLog(err, "cannot resolve digest of pinned image", imagev1.ReconciliationFailedReason)
+			}
+		}
+		latestImage, err := renderLatestImage(pol.Spec.LatestImageTemplate, &repo, pin.Tag, digest)
+		if err != nil {
+			return recordErrorAndLog(err, "invalid latest image template", imagev1.InvalidPolicyReason)
+		}
+		msg := fmt.Sprintf("Latest image tag for '%s' pinned to: %s", repo.Spec.Image, pin.Tag)
+		recordSelection(&pol.Status, latestImage, pin.Tag, digest, time.Now())
+		pol.Status.LatestPlatformImages = nil
+		pol.Status.Candidates = nil
+		apimeta.SetStatusCondition(&pol.Status.Conditions, metav1.Condition{
+			Type:               imagev1.PinnedCondition,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: pol.Generation,
+			Reason:             imagev1.PinnedReason,
+			Message:            msg,
+		})
+		imagev1.SetImagePolicyReadiness(
+			&pol,
+			metav1.ConditionTrue,
+			imagev1.PinnedReason,
+			msg,
+		)
+		if err := r.patchStatus(ctx, req, pol.Status); err != nil {
+			return ctrl.Result{}, err
+		}
+		r.event(ctx, pol, events.EventSeverityInfo, msg)
+		return ctrl.Result{}, nil
+	}
+	if apimeta.FindStatusCondition(pol.Status.Conditions, imagev1.PinnedCondition) != nil {
+		// The digest given by the pin is not to be kept by the digest
+		// reflection policy.
+		pol.Status.LatestDigest = ""
+		apimeta.RemoveStatusCondition(&pol.Status.Conditions, imagev1.PinnedCondition)
+	}
+	if !pol.Spec.DryRun {
+		pol.Status.DryRunImage = ""
 	}
 
 	// if the image repo hasn't been scanned, don't bother
@@ -172,33 +325,48 @@ func (r *ImagePolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 
 	policer, err := policy.PolicerFromSpec(pol.Spec.Policy)
 	if err != nil {
-		return recordErrorAndLog(err, "invalid policy", "InvalidPolicy")
+		return recordErrorAndLog(err, "invalid policy", imagev1.InvalidPolicyReason)
+	}
+
+	// Keep the image selected while in a freeze window.
+	frozenUntil, frozen, err := freezeWindowEnd(pol.Spec.FreezeWindows, time.Now())
+	if err != nil {
+		return recordErrorAndLog(err, "invalid freeze window", imagev1.InvalidPolicyReason)
+	}
+	if current, ok := currentTag(&pol, &repo); frozen && ok {
+		msg := fmt.Sprintf("Latest image tag for '%s' frozen at %s until %s", repo.Spec.Image, current, frozenUntil.Format(time.RFC3339))
+		imagev1.SetImagePolicyReadiness(
+			&pol,
+			metav1.ConditionTrue,
+			imagev1.ReconciliationSucceededReason,
+			msg,
+		)
+		if err := r.patchStatus(ctx, req, pol.Status); err != nil {
+			return ctrl.Result{}, err
+		}
+		log.Info(msg)
+		return requeueAfter(&pol, time.Until(frozenUntil)), nil
 	}
 
 	var latest string
+	var soakRemaining time.Duration
+	lookup := r.registryLookup(ctx, &repo)
+	checks := r.tagChecks(ctx, &pol, &repo, lookup)
+	pol.Status.Candidates = nil
 	if policer != nil {
-		var tags []string
-		tags, err = r.Database.Tags(repo.Status.CanonicalImageName)
-		if err == nil {
-			var filter *policy.RegexFilter
-			if pol.Spec.FilterTags != nil {
-				filter, err = policy.NewRegexFilter(pol.Spec.FilterTags.Pattern, pol.Spec.FilterTags.Extract)
-				if err == nil {
-					filter.Apply(tags)
-					tags = filter.Items()
-					latest, err = policer.Latest(tags)
-					if err == nil {
-						latest = filter.GetOriginalTag(latest)
-					}
-				}
-			} else {
-				latest, err = policer.Latest(tags)
-			}
-		}
+		latest, pol.Status.Candidates, soakRemaining, err = r.latestTag(ctx, &pol, &repo, policer, lookup, checks)
 	}
 
 	if err != nil || latest == "" {
-		pol.Status.LatestImage = ""
+		// The image selected is kept if the registry could not be looked
+		// up, as it may be selected again once it can.
+		var lerr *lookupError
+		if !pol.Spec.PreventDowngrade && !pol.Spec.DryRun && !errors.As(err, &lerr) {
+			pol.Status.LatestImage = ""
+			pol.Status.LatestTag = ""
+			pol.Status.LatestPlatformImages = nil
+		}
+		pol.Status.DryRunImage = ""
 		if err == nil {
 			err = fmt.Errorf("Cannot determine latest tag for policy")
 		} else {
@@ -210,11 +378,65 @@ func (r *ImagePolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 			log.Error(err, "")
 			return res, recErr
 		}
+		if soakRemaining > 0 {
+			// Tags will become eligible once they have soaked, without
+			// anything else changing.
+			return requeueAfter(&pol, soakRemaining), nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	latestDigest, err := r.reflectDigest(ctx, &pol, &repo, latest)
+	if err != nil {
+		err = fmt.Errorf("Cannot resolve digest of latest image: %w", err)
+		res, recErr := recordError(err, imagev1.ReconciliationFailedReason)
+		if recErr != nil {
+			log.Error(err, "")
+			return res, recErr
+		}
+		return ctrl.Result{}, err
+	}
+
+	latestImage, err := renderLatestImage(pol.Spec.LatestImageTemplate, &repo, imageTag(&pol, latest), latestDigest)
+	if err != nil {
+		return recordErrorAndLog(err, "invalid latest image template", imagev1.InvalidPolicyReason)
+	}
+
+	// Report the image that would be selected, leaving the status
+	// otherwise as it is.
+	if pol.Spec.DryRun {
+		msg := fmt.Sprintf("Dry run: latest image tag for '%s' would resolve to: %s", repo.Spec.Image, latest)
+		pol.Status.DryRunImage = latestImage
+		imagev1.SetImagePolicyReadiness(
+			&pol,
+			metav1.ConditionTrue,
+			imagev1.ReconciliationSucceededReason,
+			msg,
+		)
+		if err := r.patchStatus(ctx, req, pol.Status); err != nil {
+			return ctrl.Result{}, err
+		}
+		r.event(ctx, pol, events.EventSeverityInfo, msg)
+		return requeueAfter(&pol, soakRemaining), nil
+	}
+
+	platformImages, missing, err := r.latestPlatformImages(ctx, &pol, &repo, lookup, checks)
+	if err != nil {
+		err = fmt.Errorf("Cannot determine latest images for platforms: %w", err)
+		res, recErr := recordError(err, imagev1.ReconciliationFailedReason)
+		if recErr != nil {
+			log.Error(err, "")
+			return res, recErr
+		}
 		return ctrl.Result{}, err
 	}
 
 	msg := fmt.Sprintf("Latest image tag for '%s' resolved to: %s", repo.Spec.Image, latest)
-	pol.Status.LatestImage = repo.Spec.Image + ":" + latest
+	if len(missing) > 0 {
+		msg += fmt.Sprintf(" (no image for platforms: %s)", strings.Join(missing, ", "))
+	}
+	recordSelection(&pol.Status, latestImage, latest, latestDigest, time.Now())
+	pol.Status.LatestPlatformImages = platformImages
 	imagev1.SetImagePolicyReadiness(
 		&pol,
 		metav1.ConditionTrue,
@@ -227,33 +449,1078 @@ func (r *ImagePolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 	}
 	r.event(ctx, pol, events.EventSeverityInfo, msg)
 
-	return ctrl.Result{}, err
+	return requeueAfter(&pol, soakRemaining), err
+}
+
+// recordPolicyProgress sets the stalled and reconciling conditions of
+// the policy from its ready condition: stalled while it is not ready for
+// a reason that will not change until its spec does, e.g., an invalid
+// policy, reconciling while it is not ready for another reason, and
+// neither otherwise.
+func recordPolicyProgress(status *imagev1.ImagePolicyStatus) {
+	var condition *metav1.Condition
+	if ready := apimeta.FindStatusCondition(status.Conditions, meta.ReadyCondition); ready != nil && ready.Status != metav1.ConditionTrue {
+		condition = &metav1.Condition{
+			Type:               meta.ReconcilingCondition,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: ready.ObservedGeneration,
+			Reason:             meta.ProgressingReason,
+			Message:            ready.Message,
+		}
+		switch ready.Reason {
+		case meta.SuspendedReason:
+			condition = nil
+		case imagev1.InvalidPolicyReason, aclapi.AccessDeniedReason:
+			condition.Type = meta.StalledCondition
+			condition.Reason = ready.Reason
+		case imagev1.ReconciliationFailedReason:
+			condition.Reason = imagev1.ProgressingWithRetryReason
+		}
+	}
+	for _, t := range []string{meta.StalledCondition, meta.ReconcilingCondition} {
+		if condition == nil || condition.Type != t {
+			apimeta.RemoveStatusCondition(&status.Conditions, t)
+		}
+	}
+	if condition != nil {
+		apimeta.SetStatusCondition(&status.Conditions, *condition)
+	}
+}
+
+// maxSelectionHistory is the number of images selected by a policy
+// recorded in its status.
+const maxSelectionHistory = 10
+
+// recordSelection sets the latest image in the status to that given,
+// and records it in the history of images selected if it is not the one
+// selected before.
+func recordSelection(status *imagev1.ImagePolicyStatus, image, tag, digest string, now time.Time) {
+	if image != status.LatestImage || len(status.History) == 0 {
+		selection := imagev1.ImageSelection{Image: image, Tag: tag, Digest: digest, SelectedTime: metav1.NewTime(now)}
+		status.History = append([]imagev1.ImageSelection{selection}, status.History...)
+		if len(status.History) > maxSelectionHistory {
+			status.History = status.History[:maxSelectionHistory]
+		}
+	} else if status.History[0].Digest != digest {
+		// The tag has moved to another image.
+		status.History[0].Digest = digest
+	}
+	status.LatestImage = image
+	status.LatestTag = tag
+	status.LatestDigest = digest
+}
+
+// requeueAfter returns a result requeueing the policy after the length
+// of time given, or after the interval of the policy if that is sooner.
+// A length of time of zero means the policy need not be requeued.
+func requeueAfter(pol *imagev1.ImagePolicy, after time.Duration) ctrl.Result {
+	if interval := pol.Spec.Interval; interval != nil && interval.Duration > 0 && (after <= 0 || interval.Duration < after) {
+		after = interval.Duration
+	}
+	return ctrl.Result{RequeueAfter: after}
+}
+
+// scanSucceeded reports whether the image repository has been scanned,
+// and the last scan succeeded.
+func scanSucceeded(repo *imagev1.ImageRepository) bool {
+	return repo.Status.LastScanResult != nil && apimeta.IsStatusConditionTrue(repo.Status.Conditions, meta.ReadyCondition)
+}
+
+// fallbackImageRepository returns the first of the fallback image
+// repositories of the policy that it may refer to, and that has been
+// scanned successfully.
+func (r *ImagePolicyReconciler) fallbackImageRepository(ctx context.Context, pol *imagev1.ImagePolicy) (*imagev1.ImageRepository, types.NamespacedName, bool) {
+	log := ctrl.LoggerFrom(ctx)
+	aclAuth := acl.NewAuthorization(r.Client)
+	for _, ref := range pol.Spec.FallbackImageRepositoryRefs {
+		repoName := types.NamespacedName{
+			Namespace: pol.GetNamespace(),
+			Name:      ref.Name,
+		}
+		if ref.Namespace != "" {
+			repoName.Namespace = ref.Namespace
+		}
+		if r.ACLOptions.NoCrossNamespaceRefs && repoName.Namespace != pol.GetNamespace() {
+			log.Info(fmt.Sprintf("skipping fallback ImageRepository '%s', cross-namespace references have been blocked", repoName))
+			continue
+		}
+
+		var repo imagev1.ImageRepository
+		if err := r.Get(ctx, repoName, &repo); err != nil {
+			log.Error(err, fmt.Sprintf("skipping fallback ImageRepository '%s'", repoName))
+			continue
+		}
+		if err := aclAuth.HasAccessToRef(ctx, pol, repoName, repo.Spec.AccessFrom); err != nil {
+			log.Error(err, fmt.Sprintf("skipping fallback ImageRepository '%s'", repoName))
+			continue
+		}
+		if scanSucceeded(&repo) {
+			return &repo, repoName, true
+		}
+	}
+	return nil, types.NamespacedName{}, false
+}
+
+// hasAccessToClusterRepository returns an error if the policy is not in
+// a namespace allowed to refer to the cluster image repository. Policies
+// in any namespace are allowed to, unless the cluster image repository
+// gives AccessFrom, in which case the namespace must match one of its
+// selectors.
+func (r *ImagePolicyReconciler) hasAccessToClusterRepository(ctx context.Context, pol *imagev1.ImagePolicy, clusterRepo *imagev1.ClusterImageRepository) error {
+	accessFrom := clusterRepo.Spec.AccessFrom
+	if accessFrom == nil {
+		return nil
+	}
+	var namespace corev1.Namespace
+	if err := r.Get(ctx, types.NamespacedName{Name: pol.GetNamespace()}, &namespace); err != nil {
+		return err
+	}
+	for _, selector := range accessFrom.NamespaceSelectors {
+		sel, err := metav1.LabelSelectorAsSelector(&metav1.LabelSelector{MatchLabels: selector.MatchLabels})
+		if err != nil {
+			return err
+		}
+		if sel.Matches(labels.Set(namespace.GetLabels())) {
+			return nil
+		}
+	}
+	return fmt.Errorf("'%s/%s' can't be accessed due to ACL labels mismatch on namespace '%s'",
+		imagev1.ClusterImageRepositoryKind, clusterRepo.GetName(), pol.GetNamespace())
+}
+
+// applyTemplate sets the policy rules of the spec that are not given to
+// those of the template. The ordering of tags is taken from the template
+// only if the spec has none, though its soak time is taken if the spec
+// has an ordering without one.
+func applyTemplate(spec *imagev1.ImagePolicySpec, tmpl imagev1.ClusterImagePolicySpec) {
+	if tmpl.Policy != nil {
+		p := spec.Policy
+		if !hasOrdering(p) {
+			spec.Policy = *tmpl.Policy.DeepCopy()
+			if p.SoakTime != nil {
+				spec.Policy.SoakTime = p.SoakTime
+			}
+		} else if p.SoakTime == nil {
+			spec.Policy.SoakTime = tmpl.Policy.SoakTime
+		}
+	}
+	if spec.FilterTags == nil {
+		spec.FilterTags = tmpl.FilterTags
+	}
+	if spec.Provenance == nil {
+		spec.Provenance = tmpl.Provenance
+	}
+	if spec.ImageLabelSelector == nil {
+		spec.ImageLabelSelector = tmpl.ImageLabelSelector
+	}
+	if len(spec.RequiredPlatforms) == 0 {
+		spec.RequiredPlatforms = tmpl.RequiredPlatforms
+	}
+}
+
+// latestTag applies the policy to the tags recorded for the image
+// repository, and returns the tag selected, with the highest candidates
+// in the order given by the policy. If there are tags not yet eligible
+// because of the soak time, it also returns how long until the first of
+// them will be.
+func (r *ImagePolicyReconciler) latestTag(ctx context.Context, pol *imagev1.ImagePolicy, repo *imagev1.ImageRepository, policer policy.Policer, lookup registryLookup, checks tagChecks) (string, []string, time.Duration, error) {
+	tags, err := r.Database.Tags(repo.Status.CanonicalImageName)
+	if err != nil {
+		return "", nil, 0, err
+	}
+
+	denied, err := r.deniedTags(ctx, pol)
+	if err != nil {
+		return "", nil, 0, err
+	}
+	if len(denied) > 0 {
+		var allowed []string
+		for _, tag := range tags {
+			if !denied[tag] {
+				allowed = append(allowed, tag)
+			}
+		}
+		if len(allowed) == 0 {
+			return "", nil, 0, fmt.Errorf("all tags are in the denylist")
+		}
+		tags = allowed
+	}
+
+	var soakRemaining time.Duration
+	if soakTime := pol.Spec.Policy.SoakTime; soakTime != nil {
+		firstSeen, found, err := r.Database.FirstSeen(repo.Status.CanonicalImageName)
+		if err != nil {
+			return "", nil, 0, err
+		}
+		// Before the first scan that records when tags were first seen,
+		// the tags are treated as having been present for any length of
+		// time, as they will be by that scan.
+		if found {
+			tags, soakRemaining = soakedTags(tags, firstSeen, soakTime.Duration, time.Now())
+			if len(tags) == 0 {
+				return "", nil, soakRemaining, fmt.Errorf("no tag has been present for the soak time of %s yet", soakTime.Duration)
+			}
+		}
+	}
+
+	// Keep the tag already selected as a candidate, even if it is no
+	// longer in the image repository, so that the policy cannot select a
+	// tag ordered lower than it.
+	var current string
+	keepCurrent := pol.Spec.PreventDowngrade && pol.GetAnnotations()[imagev1.AllowDowngradeAnnotation] != "true"
+	if keepCurrent {
+		current, keepCurrent = currentTag(pol, repo)
+		keepCurrent = keepCurrent && !denied[current]
+	}
+	if keepCurrent && !containsTag(tags, current) {
+		tags = append(tags, current)
+	}
+
+	var filter *policy.RegexFilter
+	if pol.Spec.FilterTags != nil {
+		filter, err = policy.NewRegexFilter(pol.Spec.FilterTags.Pattern, pol.Spec.FilterTags.Extract)
+		if err != nil {
+			return "", nil, 0, err
+		}
+		filter.Apply(tags)
+		tags = filter.Items()
+		// If the tag selected is no longer matched by the pattern, it can
+		// still be ordered against the others as long as nothing is
+		// extracted from them.
+		if keepCurrent && pol.Spec.FilterTags.Extract == "" && filter.GetOriginalTag(current) == "" {
+			tags = append(tags, current)
+		}
+	}
+
+	originalTag := func(tag string) string {
+		if filter != nil {
+			if original := filter.GetOriginalTag(tag); original != "" {
+				return original
+			}
+		}
+		return tag
+	}
+
+	// Tags are skipped, rather than failing the policy, if what is needed
+	// of their images cannot be looked up in the registry.
+	var skipped skippedTags
+	if p, ok := policer.(*policy.CreatedAt); ok {
+		creationTime := r.creationTimes(repo, lookup)
+		p.CreationTime = func(tag string) (time.Time, bool, error) {
+			tag = originalTag(tag)
+			created, ok, err := creationTime(tag)
+			if skipped.skip(tag, err) {
+				return time.Time{}, false, nil
+			}
+			return created, ok, err
+		}
+	}
+
+	// Consider the tags in the order given by the policy, until one is
+	// found that refers to an image passing the checks and the highest
+	// candidates have been listed.
+	sorted, err := policer.Sort(tags)
+	if err == nil && len(sorted) == 0 {
+		err = policy.ErrNoLatest
+	}
+	if err != nil {
+		return "", nil, soakRemaining, skipped.explain(err)
+	}
+	var selected string
+	var candidates []string
+	considered := make(map[string]bool, len(sorted))
+	for _, latest := range sorted {
+		if selected != "" && len(candidates) >= maxCandidates {
+			break
+		}
+		if considered[latest] {
+			continue
+		}
+		considered[latest] = true
+		tag := originalTag(latest)
+		if len(candidates) < maxCandidates {
+			candidates = append(candidates, tag)
+		}
+		if selected == "" {
+			ok, err := checks.accept(tag)
+			if skipped.skip(tag, err) {
+				continue
+			}
+			if err != nil {
+				return "", candidates, soakRemaining, err
+			}
+			if ok {
+				selected = tag
+			}
+		}
+	}
+	if selected == "" {
+		return "", candidates, soakRemaining, skipped.explain(fmt.Errorf("%w %s", errNoTagAccepted, checks))
+	}
+	if len(skipped.tags) > 0 {
+		ctrl.LoggerFrom(ctx).Info("skipped tags that could not be looked up", "tags", skipped.tags, "reason", skipped.last.Error())
+	}
+	return selected, candidates, soakRemaining, nil
+}
+
+// skippedTags collects the tags left out when evaluating a policy, since
+// what is needed of their images could not be looked up in the registry.
+type skippedTags struct {
+	tags []string
+	last error
+}
+
+// skip records the tag as skipped, and returns true, if the error is that
+// of a registryLookup.
+func (s *skippedTags) skip(tag string, err error) bool {
+	var lerr *lookupError
+	if !errors.As(err, &lerr) {
+		return false
+	}
+	s.tags = append(s.tags, tag)
+	s.last = err
+	return true
+}
+
+// explain returns the error of no tag being selected, which is that of
+// the lookups failing if any tags were skipped.
+func (s *skippedTags) explain(err error) error {
+	if len(s.tags) == 0 {
+		return err
+	}
+	return fmt.Errorf("%s, skipping %d tags that could not be looked up: %w", err, len(s.tags), s.last)
+}
+
+// deniedTags returns the tags in the denylist of the policy, if it has
+// one.
+func (r *ImagePolicyReconciler) deniedTags(ctx context.Context, pol *imagev1.ImagePolicy) (map[string]bool, error) {
+	if pol.Spec.DenylistRef == nil {
+		return nil, nil
+	}
+	var cm corev1.ConfigMap
+	cmName := types.NamespacedName{
+		Namespace: pol.GetNamespace(),
+		Name:      pol.Spec.DenylistRef.Name,
+	}
+	reader := r.APIReader
+	if reader == nil {
+		reader = r.Client
+	}
+	if err := reader.Get(ctx, cmName, &cm); err != nil {
+		return nil, fmt.Errorf("failed to get denylist ConfigMap '%s': %w", cmName, err)
+	}
+	return parseDenylist(cm.Data), nil
+}
+
+// parseDenylist returns the tags listed, one per line, in the values of
+// the ConfigMap data. Blank lines, and lines starting with `#`, are
+// ignored.
+func parseDenylist(data map[string]string) map[string]bool {
+	denied := map[string]bool{}
+	for _, value := range data {
+		for _, line := range strings.Split(value, "\n") {
+			tag := strings.TrimSpace(line)
+			if tag == "" || strings.HasPrefix(tag, "#") {
+				continue
+			}
+			denied[tag] = true
+		}
+	}
+	return denied
+}
+
+// maxCandidates is the number of candidates recorded in the status of a
+// policy.
+const maxCandidates = 5
+
+// errNoTagAccepted is returned by latestTag when none of the tags refers
+// to an image passing the checks.
+var errNoTagAccepted = errors.New("no tag refers to an image")
+
+// tagCheck is a condition that the image a tag refers to must meet for
+// the tag to be selected.
+type tagCheck struct {
+	// description completes "refers to an image ...".
+	description string
+	accept      func(tag string) (bool, error)
+}
+
+type tagChecks []tagCheck
+
+// accept reports whether the image the tag refers to passes all the
+// checks.
+func (c tagChecks) accept(tag string) (bool, error) {
+	for _, check := range c {
+		if ok, err := check.accept(tag); err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (c tagChecks) String() string {
+	descriptions := make([]string, len(c))
+	for i, check := range c {
+		descriptions[i] = check.description
+	}
+	return strings.Join(descriptions, " and ")
+}
+
+// tagChecks returns the checks the policy makes of the image a tag
+// refers to, before selecting the tag.
+func (r *ImagePolicyReconciler) tagChecks(ctx context.Context, pol *imagev1.ImagePolicy, repo *imagev1.ImageRepository, lookup registryLookup) tagChecks {
+	var checks tagChecks
+	if pol.Spec.Provenance != nil {
+		checks = append(checks, tagCheck{
+			description: fmt.Sprintf("with a SLSA provenance attestation from builder '%s'", pol.Spec.Provenance.BuilderID),
+			accept:      r.provenanceCheck(repo, lookup, pol.Spec.Provenance.BuilderID),
+		})
+	}
+	if pol.Spec.ImageLabelSelector != nil {
+		checks = append(checks, r.labelCheck(repo, lookup, pol.Spec.ImageLabelSelector))
+	}
+	if len(pol.Spec.RequiredPlatforms) > 0 {
+		platforms := r.platforms(repo, lookup)
+		for _, platform := range pol.Spec.RequiredPlatforms {
+			checks = append(checks, platformCheck(platforms, platform))
+		}
+	}
+	return checks
+}
+
+// labelCheck returns a check that the image a tag refers to has labels
+// in its config matching the selector.
+func (r *ImagePolicyReconciler) labelCheck(repo *imagev1.ImageRepository, lookup registryLookup, labelSelector *metav1.LabelSelector) tagCheck {
+	selector, selectorErr := metav1.LabelSelectorAsSelector(labelSelector)
+	if selectorErr != nil {
+		return tagCheck{
+			description: "with labels matching an invalid selector",
+			accept: func(string) (bool, error) {
+				return false, fmt.Errorf("invalid image label selector: %w", selectorErr)
+			},
+		}
+	}
+	configs := r.imageConfigs(repo, lookup)
+	return tagCheck{
+		description: fmt.Sprintf("with labels matching '%s'", selector),
+		accept: func(tag string) (bool, error) {
+			config, err := configs(tag)
+			if err != nil {
+				return false, err
+			}
+			imageLabels, err := registry.ConfigLabels(config)
+			if err != nil {
+				return false, err
+			}
+			return selector.Matches(labels.Set(imageLabels)), nil
+		},
+	}
+}
+
+// platformCheck returns a check that the image a tag refers to is for
+// the platform given. Since the image for a platform may be pushed after
+// the others, the platforms recorded for a tag without the platform are
+// refreshed.
+func platformCheck(platforms platformLookup, platform string) tagCheck {
+	return tagCheck{
+		description: fmt.Sprintf("for platform %s", platform),
+		accept: func(tag string) (bool, error) {
+			p, err := platforms(tag, false)
+			if err != nil {
+				return false, err
+			}
+			_, ok, err := registry.MatchPlatform(p, platform)
+			if err != nil || ok {
+				return ok, err
+			}
+			if p, err = platforms(tag, true); err != nil {
+				return false, err
+			}
+			_, ok, err = registry.MatchPlatform(p, platform)
+			return ok, err
+		},
+	}
+}
+
+// latestPlatformImages returns the latest image for each of the platforms
+// given in the policy, for those that have one.
+func (r *ImagePolicyReconciler) latestPlatformImages(ctx context.Context, pol *imagev1.ImagePolicy, repo *imagev1.ImageRepository, lookup registryLookup, checks tagChecks) ([]imagev1.PlatformImage, []string, error) {
+	var images []imagev1.PlatformImage
+	var missing []string
+	platforms := r.platforms(repo, lookup)
+	// The tag selected for all platforms is not kept as a candidate for
+	// each platform, since it may no longer be in the registry.
+	platformPol := pol.DeepCopy()
+	platformPol.Spec.PreventDowngrade = false
+	for _, platform := range pol.Spec.Platforms {
+		policer, err := policy.PolicerFromSpec(pol.Spec.Policy)
+		if err != nil {
+			return nil, nil, err
+		}
+		platformChecks := append(tagChecks{platformCheck(platforms, platform)}, checks...)
+		tag, _, _, err := r.latestTag(ctx, platformPol, repo, policer, lookup, platformChecks)
+		if errors.Is(err, errNoTagAccepted) {
+			missing = append(missing, platform)
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		p, err := platforms(tag, false)
+		if err != nil {
+			return nil, nil, err
+		}
+		digest, _, err := registry.MatchPlatform(p, platform)
+		if err != nil {
+			return nil, nil, err
+		}
+		image, err := renderLatestImage(pol.Spec.LatestImageTemplate, repo, imageTag(pol, tag), digest)
+		if err != nil {
+			return nil, nil, err
+		}
+		images = append(images, imagev1.PlatformImage{
+			Platform: platform,
+			Image:    image,
+			Tag:      tag,
+			Digest:   digest,
+		})
+	}
+	return images, missing, nil
+}
+
+// platformLookup looks up the platforms of the image a tag refers to,
+// refreshing those recorded if refresh is true.
+type platformLookup func(tag string, refresh bool) (map[string]string, error)
+
+// platforms returns a platformLookup for the tags of the image
+// repository. Platforms are recorded in the database, so they are
+// fetched from the registry only the first time they are looked up.
+// Those recorded are refreshed, if asked for, only when the tag has
+// moved to another image, and this is looked up at most once per scan
+// of the image repository; not at all if its scans fetch the metadata
+// of the tags, and so refresh them already.
+func (r *ImagePolicyReconciler) platforms(repo *imagev1.ImageRepository, lookup registryLookup) platformLookup {
+	return func(tag string, refresh bool) (map[string]string, error) {
+		canonicalName := repo.Status.CanonicalImageName
+		platforms, ok, err := r.Database.Platforms(canonicalName, tag)
+		if err != nil {
+			return nil, err
+		}
+		if ok && (!refresh || repo.Spec.FetchMetadata || !r.platformsRefreshed.add(canonicalName, lastScanTime(repo), tag)) {
+			return platforms, nil
+		}
+
+		if ok {
+			// The platforms recorded are those of the image the
+			// descriptor recorded with them is of, if there is one.
+			err = lookup(tag, func(ctx context.Context, ref name.Tag, auth authn.Authenticator, tr http.RoundTripper) error {
+				return fetchTagMetadata(ctx, r.Database, canonicalName, ref, auth, tr)
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to refresh platforms for '%s:%s': %w", canonicalName, tag, err)
+			}
+			platforms, _, err = r.Database.Platforms(canonicalName, tag)
+			return platforms, err
+		}
+
+		err = lookup(tag, func(ctx context.Context, ref name.Tag, auth authn.Authenticator, tr http.RoundTripper) error {
+			platforms, err = registry.ImagePlatforms(ref, remoteOptions(ctx, auth, tr)...)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := r.Database.SetPlatforms(canonicalName, tag, platforms); err != nil {
+			return nil, fmt.Errorf("failed to set platforms for '%s:%s': %w", canonicalName, tag, err)
+		}
+		return platforms, nil
+	}
+}
+
+// lastScanTime returns the time of the last scan of the image repository,
+// or the zero time if it has not been scanned.
+func lastScanTime(repo *imagev1.ImageRepository) time.Time {
+	if repo.Status.LastScanResult == nil {
+		return time.Time{}
+	}
+	return repo.Status.LastScanResult.ScanTime.Time
+}
+
+// refreshedTags records, for each image repository, the tags something
+// has been refreshed for since its last scan. The zero value is ready to
+// use.
+type refreshedTags struct {
+	mu    sync.Mutex
+	repos map[string]refreshedSince
+}
+
+type refreshedSince struct {
+	scanTime time.Time
+	tags     map[string]bool
+}
+
+// add records that the tag of the image repository is refreshed after
+// the scan at the time given, returning false if it has been already.
+// The tags recorded after an earlier scan are forgotten.
+func (t *refreshedTags) add(repo string, scanTime time.Time, tag string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.repos == nil {
+		t.repos = map[string]refreshedSince{}
+	}
+	since, ok := t.repos[repo]
+	if !ok || !since.scanTime.Equal(scanTime) {
+		since = refreshedSince{scanTime: scanTime, tags: map[string]bool{}}
+		t.repos[repo] = since
+	}
+	if since.tags[tag] {
+		return false
+	}
+	since.tags[tag] = true
+	return true
+}
+
+// registryLookup fetches from the registry something of the image the tag
+// given refers to, with the context, authentication and transport for the
+// image repository.
+type registryLookup func(tag string, fetch func(ctx context.Context, ref name.Tag, auth authn.Authenticator, tr http.RoundTripper) error) error
+
+// lookupError is the error of a registryLookup failing. The lookup may
+// succeed later, e.g., once the registry is no longer throttling
+// requests, so it is not taken as the policy being evaluated failing.
+type lookupError struct {
+	err error
+}
+
+func (e *lookupError) Error() string {
+	return e.err.Error()
+}
+
+func (e *lookupError) Unwrap() error {
+	return e.err
+}
+
+// registryLookup returns a registryLookup for the tags of the image
+// repository. As for a scan, each lookup waits for one of the scan slots,
+// with the priority of the image repository, and then has its timeout;
+// a registry being backed off from is not looked up, and one throttling
+// a lookup is backed off from. Its errors are lookupErrors, but for those
+// of the access to the registry.
+func (r *ImagePolicyReconciler) registryLookup(ctx context.Context, repo *imagev1.ImageRepository) registryLookup {
+	var auth authn.Authenticator
+	var tr http.RoundTripper
+	var accessed bool
+	return func(tag string, fetch func(ctx context.Context, ref name.Tag, auth authn.Authenticator, tr http.RoundTripper) error) error {
+		ref, err := parseImageReference(repo.Spec.Image, repo.Spec.Insecure)
+		if err != nil {
+			return err
+		}
+		registry := ref.Context().RegistryStr()
+		if r.RegistryBackoff != nil {
+			if left, throttled := r.RegistryBackoff.Throttled(registry); throttled {
+				return &lookupError{fmt.Errorf("registry '%s' is throttling requests, backing off for %s", registry, left.Round(time.Second))}
+			}
+		}
+		if err := r.ScanSlots.AcquireWithPriority(ctx, repo.Spec.Priority); err != nil {
+			return &lookupError{fmt.Errorf("waiting to look up '%s:%s': %w", repo.Spec.Image, tag, err)}
+		}
+		defer r.ScanSlots.Release()
+		ctx, cancel := context.WithTimeout(ctx, repo.GetTimeout())
+		defer cancel()
+
+		if !accessed {
+			auth, tr, err = remoteAccess(ctx, r.Client, repo, ref, r.ProviderOptions)
+			if err != nil {
+				return err
+			}
+			accessed = true
+		}
+		if err := fetch(ctx, ref.Context().Tag(tag), auth, tr); err != nil {
+			if r.RegistryBackoff != nil && isThrottledError(err) {
+				r.RegistryBackoff.Failed(registry)
+			}
+			return &lookupError{err}
+		}
+		return nil
+	}
+}
+
+// provenanceCheck returns a func reporting whether the image a tag of
+// the image repository refers to has a SLSA provenance attestation from
+// the builder given. The builders are recorded in the database, so they
+// are looked up in the registry only the first time a tag is checked.
+func (r *ImagePolicyReconciler) provenanceCheck(repo *imagev1.ImageRepository, lookup registryLookup, builderID string) func(tag string) (bool, error) {
+	return func(tag string) (bool, error) {
+		canonicalName := repo.Status.CanonicalImageName
+		builders, ok, err := r.Database.Provenance(canonicalName, tag)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			err := lookup(tag, func(ctx context.Context, ref name.Tag, auth authn.Authenticator, tr http.RoundTripper) error {
+				builders, err = registry.ProvenanceBuilders(ctx, ref, auth, tr)
+				return err
+			})
+			if err != nil {
+				return false, fmt.Errorf("failed to get provenance of '%s:%s': %w", repo.Spec.Image, tag, err)
+			}
+			if err := r.Database.SetProvenance(canonicalName, tag, builders); err != nil {
+				return false, fmt.Errorf("failed to set provenance for '%s:%s': %w", canonicalName, tag, err)
+			}
+		}
+		for _, b := range builders {
+			if b == builderID {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+}
+
+// soakedTags returns the tags that were first seen at least the soak time
+// before now, and how long until the first of the others will have been.
+// Tags not yet recorded as seen are not included.
+func soakedTags(tags []string, firstSeen map[string]time.Time, soakTime time.Duration, now time.Time) ([]string, time.Duration) {
+	var soaked []string
+	var remaining time.Duration
+	for _, tag := range tags {
+		seen, ok := firstSeen[tag]
+		if !ok {
+			continue
+		}
+		if left := seen.Add(soakTime).Sub(now); left > 0 {
+			if remaining == 0 || left < remaining {
+				remaining = left
+			}
+			continue
+		}
+		soaked = append(soaked, tag)
+	}
+	return soaked, remaining
+}
+
+// freezeWindowEnd reports whether any of the freeze windows is active at
+// the time given, and if so the latest time one of them ends.
+func freezeWindowEnd(windows []imagev1.FreezeWindow, now time.Time) (time.Time, bool, error) {
+	var end time.Time
+	var frozen bool
+	for _, fw := range windows {
+		w, err := parseWindow(fw.Schedule, fw.TimeZone, fw.Duration.Duration)
+		if err != nil {
+			return time.Time{}, false, err
+		}
+		if until, active := w.ActiveUntil(now); active {
+			frozen = true
+			if until.After(end) {
+				end = until
+			}
+		}
+	}
+	return end, frozen, nil
+}
+
+// currentTag returns the tag of the image selected by the policy, if it
+// is an image of the image repository. This is so if the latest image
+// recorded is what the template gives for that image.
+func currentTag(pol *imagev1.ImagePolicy, repo *imagev1.ImageRepository) (string, bool) {
+	if pol.Status.LatestTag == "" {
+		// The tag is not recorded by earlier versions of the controller.
+		prefix := repo.Spec.Image + ":"
+		if pol.Spec.LatestImageTemplate != "" || !strings.HasPrefix(pol.Status.LatestImage, prefix) {
+			return "", false
+		}
+		return strings.TrimPrefix(pol.Status.LatestImage, prefix), true
+	}
+	latestImage, err := renderLatestImage(pol.Spec.LatestImageTemplate, repo, imageTag(pol, pol.Status.LatestTag), pol.Status.LatestDigest)
+	if err != nil || latestImage != pol.Status.LatestImage {
+		return "", false
+	}
+	return pol.Status.LatestTag, true
+}
+
+// imageTag returns the tag to give in the latest image for the tag
+// selected, which is without its `v` prefix if the policy strips it.
+func imageTag(pol *imagev1.ImagePolicy, tag string) string {
+	if semver := pol.Spec.Policy.SemVer; semver != nil && semver.VPrefix == imagev1.VPrefixStrip {
+		return strings.TrimPrefix(tag, "v")
+	}
+	return tag
+}
+
+// latestImageData gives the fields for the template of the latest image.
+type latestImageData struct {
+	Image      string
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// renderLatestImage returns the latest image for the tag and digest as
+// given by the template, or as `<image>:<tag>` if the template is empty.
+func renderLatestImage(tmpl string, repo *imagev1.ImageRepository, tag, digest string) (string, error) {
+	if tmpl == "" {
+		return repo.Spec.Image + ":" + tag, nil
+	}
+	t, err := parseLatestImageTemplate(tmpl)
+	if err != nil {
+		return "", err
+	}
+	ref, err := parseImageReference(repo.Spec.Image, repo.Spec.Insecure)
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	if err := t.Execute(&out, latestImageData{
+		Image:      repo.Spec.Image,
+		Registry:   ref.Context().RegistryStr(),
+		Repository: ref.Context().RepositoryStr(),
+		Tag:        tag,
+		Digest:     digest,
+	}); err != nil {
+		return "", fmt.Errorf("invalid latest image template: %w", err)
+	}
+	return out.String(), nil
+}
+
+// parseLatestImageTemplate parses the latest image template of a policy.
+func parseLatestImageTemplate(tmpl string) (*template.Template, error) {
+	t, err := template.New("latestImage").Parse(tmpl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid latest image template: %w", err)
+	}
+	return t, nil
+}
+
+// templateUsesDigest reports whether the latest image template refers to
+// `.Digest`, in which case the digest of the latest image is needed to
+// render it. An invalid template uses nothing.
+func templateUsesDigest(tmpl string) bool {
+	if tmpl == "" {
+		return false
+	}
+	t, err := parseLatestImageTemplate(tmpl)
+	if err != nil {
+		return false
+	}
+	return usesField(t.Tree.Root, "Digest")
+}
+
+// usesField reports whether the field is referred to under the node of a
+// parsed template.
+func usesField(node parse.Node, field string) bool {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return false
+		}
+		for _, child := range n.Nodes {
+			if usesField(child, field) {
+				return true
+			}
+		}
+	case *parse.ActionNode:
+		return usesField(n.Pipe, field)
+	case *parse.PipeNode:
+		if n == nil {
+			return false
+		}
+		for _, cmd := range n.Cmds {
+			if usesField(cmd, field) {
+				return true
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			if usesField(arg, field) {
+				return true
+			}
+		}
+	case *parse.FieldNode:
+		return len(n.Ident) > 0 && n.Ident[0] == field
+	case *parse.ChainNode:
+		return usesField(n.Node, field)
+	case *parse.IfNode:
+		return usesField(n.Pipe, field) || usesField(n.List, field) || usesField(n.ElseList, field)
+	case *parse.RangeNode:
+		return usesField(n.Pipe, field) || usesField(n.List, field) || usesField(n.ElseList, field)
+	case *parse.WithNode:
+		return usesField(n.Pipe, field) || usesField(n.List, field) || usesField(n.ElseList, field)
+	case *parse.TemplateNode:
+		return usesField(n.Pipe, field)
+	}
+	return false
+}
+
+func containsTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// reflectDigest returns the digest to record for the latest image,
+// according to the digest reflection policy: this is resolved from the
+// registry, or taken from the status if it was resolved before for the
+// same image and need not be resolved again. A latest image template
+// referring to the digest has it resolved as for IfNotPresent, whatever
+// the policy, so that the latest image is not rendered without it.
+func (r *ImagePolicyReconciler) reflectDigest(ctx context.Context, pol *imagev1.ImagePolicy, repo *imagev1.ImageRepository, tag string) (string, error) {
+	reflect := pol.Spec.DigestReflectionPolicy
+	if reflect != imagev1.ReflectAlways && templateUsesDigest(pol.Spec.LatestImageTemplate) {
+		reflect = imagev1.ReflectIfNotPresent
+	}
+	switch reflect {
+	case imagev1.ReflectAlways:
+	case imagev1.ReflectIfNotPresent:
+		if current, ok := currentTag(pol, repo); ok && current == tag && pol.Status.LatestDigest != "" {
+			return pol.Status.LatestDigest, nil
+		}
+	default:
+		return "", nil
+	}
+	return r.resolveDigest(ctx, repo, tag)
+}
+
+// resolveDigest resolves the digest of the image the tag refers to from
+// the registry.
+func (r *ImagePolicyReconciler) resolveDigest(ctx context.Context, repo *imagev1.ImageRepository, tag string) (string, error) {
+	ref, err := parseImageReference(repo.Spec.Image, repo.Spec.Insecure)
+	if err != nil {
+		return "", err
+	}
+	auth, tr, err := remoteAccess(ctx, r.Client, repo, ref, r.ProviderOptions)
+	if err != nil {
+		return "", err
+	}
+	desc, err := remote.Head(ref.Context().Tag(tag), remoteOptions(ctx, auth, tr)...)
+	if err != nil {
+		return "", err
+	}
+	return desc.Digest.String(), nil
+}
+
+// creationTimes returns a func for looking up the creation time of the
+// image a tag of the image repository refers to. Creation times are
+// recorded in the database, fetched by the scans of image repositories
+// used by policies ordering tags by them, so those of tags not yet
+// fetched are looked up in the registry.
+func (r *ImagePolicyReconciler) creationTimes(repo *imagev1.ImageRepository, lookup registryLookup) func(tag string) (time.Time, bool, error) {
+	return func(tag string) (time.Time, bool, error) {
+		canonicalName := repo.Status.CanonicalImageName
+		created, ok, err := r.Database.CreationTime(canonicalName, tag)
+		if err != nil || ok {
+			return created, ok, err
+		}
+
+		err = lookup(tag, func(ctx context.Context, ref name.Tag, auth authn.Authenticator, tr http.RoundTripper) error {
+			created, err = registry.ImageCreated(ref, remoteOptions(ctx, auth, tr)...)
+			return err
+		})
+		if err != nil {
+			return time.Time{}, false, err
+		}
+		if err := r.Database.SetCreationTime(canonicalName, tag, created); err != nil {
+			return time.Time{}, false, fmt.Errorf("failed to set creation time for '%s:%s': %w", canonicalName, tag, err)
+		}
+		return created, true, nil
+	}
+}
+
+// imageConfigs returns a func for looking up the config blob of the image
+// a tag of the image repository refers to. Configs are recorded in the
+// database, fetched by the scans of image repositories used by policies
+// checking image labels, so those of tags not yet fetched are looked up
+// in the registry.
+func (r *ImagePolicyReconciler) imageConfigs(repo *imagev1.ImageRepository, lookup registryLookup) func(tag string) ([]byte, error) {
+	return func(tag string) ([]byte, error) {
+		canonicalName := repo.Status.CanonicalImageName
+		config, ok, err := r.Database.ImageConfig(canonicalName, tag)
+		if err != nil || ok {
+			return config, err
+		}
+
+		err = lookup(tag, func(ctx context.Context, ref name.Tag, auth authn.Authenticator, tr http.RoundTripper) error {
+			config, err = registry.ImageConfig(ref, remoteOptions(ctx, auth, tr)...)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := r.Database.SetImageConfig(canonicalName, tag, config); err != nil {
+			return nil, fmt.Errorf("failed to set image config for '%s:%s': %w", canonicalName, tag, err)
+		}
+		return config, nil
+	}
 }
 
 func (r *ImagePolicyReconciler) SetupWithManager(mgr ctrl.Manager, opts ImagePolicyReconcilerOptions) error {
 	// index the policies by which image repo they point at, so that
 	// it's easy to list those out when an image repo changes.
 	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &imagev1.ImagePolicy{}, imageRepoKey, func(obj client.Object) []string {
-		pol := obj.(*imagev1.ImagePolicy)
+		var keys []string
+		for _, name := range imageRepositoriesOf(obj.(*imagev1.ImagePolicy)) {
+			keys = append(keys, name.String())
+		}
+		return keys
+	}); err != nil {
+		return err
+	}
 
-		namespace := pol.Spec.ImageRepositoryRef.Namespace
-		if namespace == "" {
-			namespace = obj.GetNamespace()
+	// index the policies by the ConfigMap they take their denylist from,
+	// so they can be evaluated again when it changes.
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &imagev1.ImagePolicy{}, denylistKey, func(obj client.Object) []string {
+		pol := obj.(*imagev1.ImagePolicy)
+		if pol.Spec.DenylistRef == nil {
+			return nil
 		}
 		namespacedName := types.NamespacedName{
-			Name:      pol.Spec.ImageRepositoryRef.Name,
-			Namespace: namespace,
+			Name:      pol.Spec.DenylistRef.Name,
+			Namespace: obj.GetNamespace(),
 		}
 		return []string{namespacedName.String()}
 	}); err != nil {
 		return err
 	}
 
+	// index the policies by the ClusterImagePolicy they take policy
+	// rules from, so they can be evaluated again when it changes.
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &imagev1.ImagePolicy{}, templateKey, func(obj client.Object) []string {
+		pol := obj.(*imagev1.ImagePolicy)
+		if pol.Spec.TemplateRef == nil {
+			return nil
+		}
+		return []string{types.NamespacedName{Name: pol.Spec.TemplateRef.Name}.String()}
+	}); err != nil {
+		return err
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&imagev1.ImagePolicy{}).
 		Watches(
 			&source.Kind{Type: &imagev1.ImageRepository{}},
 			handler.EnqueueRequestsFromMapFunc(r.imagePoliciesForRepository),
+			builder.WithPredicates(imageRepositoryScannedPredicate{}),
+		).
+		Watches(
+			&source.Kind{Type: &imagev1.ClusterImageRepository{}},
+			handler.EnqueueRequestsFromMapFunc(r.imagePoliciesForRepository),
+			builder.WithPredicates(imageRepositoryScannedPredicate{}),
+		).
+		// Only the metadata of ConfigMaps is watched, rather than every
+		// ConfigMap in the cluster being cached whole; those of
+		// denylists are read with the APIReader.
+		Watches(
+			&source.Kind{Type: &corev1.ConfigMap{}},
+			handler.EnqueueRequestsFromMapFunc(r.imagePoliciesIndexedBy(denylistKey)),
+			builder.OnlyMetadata,
+		).
+		Watches(
+			&source.Kind{Type: &imagev1.ClusterImagePolicy{}},
+			handler.EnqueueRequestsFromMapFunc(r.imagePoliciesIndexedBy(templateKey)),
 		).
 		WithOptions(controller.Options{
 			MaxConcurrentReconciles: opts.MaxConcurrentReconciles,
@@ -263,6 +1530,35 @@ func (r *ImagePolicyReconciler) SetupWithManager(mgr ctrl.Manager, opts ImagePol
 
 // ---
 
+// imageRepositoriesOf returns the names of the image repositories the
+// image policy uses: that of its image repository reference, with no
+// namespace for a cluster image repository, and those of its fallback
+// references.
+func imageRepositoriesOf(pol *imagev1.ImagePolicy) []types.NamespacedName {
+	namespace := pol.Spec.ImageRepositoryRef.Namespace
+	if namespace == "" {
+		namespace = pol.GetNamespace()
+	}
+	if pol.Spec.ImageRepositoryKind == imagev1.ClusterImageRepositoryKind {
+		namespace = ""
+	}
+	names := []types.NamespacedName{{
+		Name:      pol.Spec.ImageRepositoryRef.Name,
+		Namespace: namespace,
+	}}
+	for _, ref := range pol.Spec.FallbackImageRepositoryRefs {
+		namespacedName := types.NamespacedName{
+			Name:      ref.Name,
+			Namespace: ref.Namespace,
+		}
+		if namespacedName.Namespace == "" {
+			namespacedName.Namespace = pol.GetNamespace()
+		}
+		names = append(names, namespacedName)
+	}
+	return names
+}
+
 func (r *ImagePolicyReconciler) imagePoliciesForRepository(obj client.Object) []reconcile.Request {
 	ctx := context.Background()
 	var policies imagev1.ImagePolicyList
@@ -277,6 +1573,81 @@ func (r *ImagePolicyReconciler) imagePoliciesForRepository(obj client.Object) []
 	return reqs
 }
 
+// imageRepositoryScannedPredicate passes on updates to an image
+// repository, or cluster image repository, that policies referring to it
+// depend on: a scan changing the tags, a change to the spec, a change to
+// the canonical image name, or it becoming ready or not, e.g., for a scan
+// failing, which policies with fallback image repositories act on. Other
+// changes to the status, e.g., of the messages of its conditions, or a
+// scan finding the same tags as the last, are filtered out.
+type imageRepositoryScannedPredicate struct {
+	predicate.Funcs
+}
+
+func (imageRepositoryScannedPredicate) Update(e event.UpdateEvent) bool {
+	oldStatus, ok := imageRepositoryStatus(e.ObjectOld)
+	if !ok {
+		return true
+	}
+	newStatus, ok := imageRepositoryStatus(e.ObjectNew)
+	if !ok {
+		return true
+	}
+	if e.ObjectOld.GetGeneration() != e.ObjectNew.GetGeneration() ||
+		oldStatus.CanonicalImageName != newStatus.CanonicalImageName {
+		return true
+	}
+	if apimeta.IsStatusConditionTrue(oldStatus.Conditions, meta.ReadyCondition) !=
+		apimeta.IsStatusConditionTrue(newStatus.Conditions, meta.ReadyCondition) {
+		return true
+	}
+	oldScan, newScan := oldStatus.LastScanResult, newStatus.LastScanResult
+	if oldScan == nil || newScan == nil {
+		return oldScan != newScan
+	}
+	return tagsChanged(oldScan, newScan)
+}
+
+// tagsChanged reports whether the tags found by the scans differ, as
+// far as the scan results tell: a tag added changes the count of the
+// tags, and a tag removed the tags removed, whether or not either is
+// among the latest tags.
+func tagsChanged(oldScan, newScan *imagev1.ScanResult) bool {
+	return oldScan.TagCount != newScan.TagCount ||
+		!equality.Semantic.DeepEqual(oldScan.LatestTags, newScan.LatestTags) ||
+		!equality.Semantic.DeepEqual(oldScan.RemovedTags, newScan.RemovedTags)
+}
+
+// imageRepositoryStatus returns the status of an ImageRepository or a
+// ClusterImageRepository.
+func imageRepositoryStatus(obj client.Object) (*imagev1.ImageRepositoryStatus, bool) {
+	switch repo := obj.(type) {
+	case *imagev1.ImageRepository:
+		return &repo.Status, true
+	case *imagev1.ClusterImageRepository:
+		return &repo.Status, true
+	}
+	return nil, false
+}
+
+// imagePoliciesIndexedBy returns a func listing the policies with the
+// object given under the index key.
+func (r *ImagePolicyReconciler) imagePoliciesIndexedBy(key string) handler.MapFunc {
+	return func(obj client.Object) []reconcile.Request {
+		ctx := context.Background()
+		var policies imagev1.ImagePolicyList
+		if err := r.List(ctx, &policies, client.MatchingFields{key: client.ObjectKeyFromObject(obj).String()}); err != nil {
+			return nil
+		}
+		reqs := make([]reconcile.Request, len(policies.Items))
+		for i := range policies.Items {
+			reqs[i].NamespacedName.Name = policies.Items[i].GetName()
+			reqs[i].NamespacedName.Namespace = policies.Items[i].GetNamespace()
+		}
+		return reqs
+	}
+}
+
 // event emits a Kubernetes event and forwards the event to notification controller if configured
 func (r *ImagePolicyReconciler) event(ctx context.Context, policy imagev1.ImagePolicy, severity, msg string) {
 	eventtype := "Normal"
@@ -314,6 +1685,20 @@ func (r *ImagePolicyReconciler) patchStatus(ctx context.Context, req ctrl.Reques
 	}
 
 	patch := client.MergeFrom(res.DeepCopy())
+	recordPolicyProgress(&newStatus)
+	// In read-only mode the image selected is kept, and a change to it
+	// reported instead.
+	if r.ReadOnly {
+		if newStatus.LatestImage != res.Status.LatestImage {
+			r.event(ctx, res, events.EventSeverityInfo, fmt.Sprintf("Read-only: latest image would change from '%s' to '%s'",
+				res.Status.LatestImage, newStatus.LatestImage))
+		}
+		newStatus.LatestImage = res.Status.LatestImage
+		newStatus.LatestTag = res.Status.LatestTag
+		newStatus.LatestDigest = res.Status.LatestDigest
+		newStatus.LatestPlatformImages = res.Status.LatestPlatformImages
+		newStatus.History = res.Status.History
+	}
 	res.Status = newStatus
 
 	return r.Status().Patch(ctx, &res, patch)
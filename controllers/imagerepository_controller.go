@@ -24,36 +24,55 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
+	"reflect"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/Masterminds/semver/v3"
 	"github.com/google/go-containerregistry/pkg/authn"
-	"github.com/google/go-containerregistry/pkg/authn/k8schain"
 	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
 	corev1 "k8s.io/api/core/v1"
 	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 	kuberecorder "k8s.io/client-go/tools/record"
 	"k8s.io/client-go/tools/reference"
+	"k8s.io/client-go/util/workqueue"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	"github.com/fluxcd/pkg/apis/meta"
 	"github.com/fluxcd/pkg/runtime/events"
 	"github.com/fluxcd/pkg/runtime/metrics"
 	"github.com/fluxcd/pkg/runtime/predicates"
+	"github.com/fluxcd/pkg/version"
 
 	imagev1 "github.com/fluxcd/image-reflector-controller/api/v1beta1"
+	"github.com/fluxcd/image-reflector-controller/internal/policy"
+	"github.com/fluxcd/image-reflector-controller/internal/priorityqueue"
+	"github.com/fluxcd/image-reflector-controller/internal/registry"
+	"github.com/fluxcd/image-reflector-controller/internal/registry/credhelper"
+	"github.com/fluxcd/image-reflector-controller/internal/registry/dockerhub"
+	"github.com/fluxcd/image-reflector-controller/internal/registry/harbor"
 	"github.com/fluxcd/image-reflector-controller/internal/registry/login"
+	"github.com/fluxcd/image-reflector-controller/internal/registry/throttle"
+	"github.com/fluxcd/image-reflector-controller/internal/schedule"
 )
 
 // These are intended to match the keys used in e.g.,
@@ -67,6 +86,33 @@ const (
 	CosignObjectRegex = "^.*\\.sig$"
 )
 
+// These are the keys of the proxy secret, matching those used by the
+// other Flux controllers for `.spec.proxySecretRef`.
+const (
+	ProxyAddress  = "address"
+	ProxyUsername = "username"
+	ProxyPassword = "password"
+)
+
+// latestTagsCount is the number of tags recorded in
+// `.status.lastScanResult.latestTags`, and of those in
+// `.status.lastScanResult.removedTags`.
+const latestTagsCount = 10
+
+// These are the keys of the metadata of the events of a scan finding
+// tags added or removed, giving the latest of the tags, separated by
+// commas, and the number of them.
+const (
+	newTagsKey         = "newTags"
+	newTagCountKey     = "newTagCount"
+	removedTagsKey     = "removedTags"
+	removedTagCountKey = "removedTagCount"
+)
+
+// lastSeenCount is the number of tags removed from an image repository
+// for which the time they were last seen is kept.
+const lastSeenCount = 1000
+
 // ImageRepositoryReconciler reconciles a ImageRepository object
 type ImageRepositoryReconciler struct {
 	client.Client
@@ -77,8 +123,49 @@ type ImageRepositoryReconciler struct {
 	Database              interface {
 		DatabaseWriter
 		DatabaseReader
+		PartialScanStore
+		FirstSeenStore
+		LastSeenStore
+		ScanResultStore
+		DatabaseDeleter
+		DescriptorStore
+		CreationTimeStore
+		PlatformStore
+		ImageConfigStore
+		ProvenanceStore
+		TagListValidatorStore
 	}
 	login.ProviderOptions
+	// InsecureAllowHTTP allows image repositories to use `.spec.insecure`
+	// to connect to registries over plain HTTP.
+	InsecureAllowHTTP bool
+	// DefaultTagLimit is the greatest number of tags stored for an image
+	// repository that does not give `.spec.tagLimit`. Zero means no
+	// limit.
+	DefaultTagLimit int
+	// ReadOnly reports the changes to the tags recorded in the database
+	// that scans would make, in the ready condition and in an event, on
+	// the understanding that the database discards them.
+	ReadOnly bool
+	// RegistryBackoff backs off from the registries throttling requests,
+	// putting off the scans of their images. Nil means no backoff.
+	RegistryBackoff *throttle.Backoff
+	// ScanSlots bounds the number of image repositories listing tags or
+	// fetching metadata at once, across all of them, apart from the
+	// rest of reconciling, giving the slots to those with the highest
+	// `.spec.priority` first. Nil means no bound beyond that of
+	// concurrent reconciles.
+	ScanSlots *throttle.Slots
+	// ScanBackoffBaseDelay is how long after a failed scan of an image
+	// repository the next is made, doubling with each failure in a row
+	// up to ScanBackoffMaxDelay. Zero means failed scans are retried by
+	// the rate limiter of the controller, as other errors are.
+	ScanBackoffBaseDelay time.Duration
+	ScanBackoffMaxDelay  time.Duration
+	// MinScanInterval is the shortest time allowed between the scans of
+	// an image repository, whatever its interval or schedule. Zero means
+	// no minimum.
+	MinScanInterval time.Duration
 }
 
 type ImageRepositoryReconcilerOptions struct {
@@ -86,7 +173,9 @@ type ImageRepositoryReconcilerOptions struct {
 }
 
 type dockerConfig struct {
-	Auths map[string]authn.AuthConfig
+	Auths       map[string]authn.AuthConfig
+	CredHelpers map[string]string `json:"credHelpers,omitempty"`
+	CredsStore  string            `json:"credsStore,omitempty"`
 }
 
 // +kubebuilder:rbac:groups=image.toolkit.fluxcd.io,resources=imagerepositories,verbs=get;list;watch;create;update;patch;delete
@@ -94,7 +183,23 @@ type dockerConfig struct {
 // +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch
 // +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 // +kubebuilder:rbac:groups="",resources=serviceaccounts,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=serviceaccounts/token,verbs=create
 func (r *ImageRepositoryReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	var imageRepo imagev1.ImageRepository
+	if err := r.Get(ctx, req.NamespacedName, &imageRepo); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	return r.reconcileRepository(ctx, imagev1.ImageRepositoryKind, &imageRepo, &imageRepo, func() error {
+		return r.patchStatus(ctx, &imageRepo, imageRepo.Status)
+	})
+}
+
+// reconcileRepository reconciles obj, an ImageRepository or a
+// ClusterImageRepository of the kind given, by scanning imageRepo, the
+// image repository it is scanned as. The status of imageRepo is that of
+// obj, and patchStatus records it on obj. Events and metrics are of obj.
+func (r *ImageRepositoryReconciler) reconcileRepository(ctx context.Context, kind string, obj client.Object,
+	imageRepo *imagev1.ImageRepository, patchStatus func() error) (ctrl.Result, error) {
 	reconcileStart := time.Now()
 
 	// NB: In general, if an error is returned then controller-runtime
@@ -102,44 +207,58 @@ func (r *ImageRepositoryReconciler) Reconcile(ctx context.Context, req ctrl.Requ
 	// is usually made explicit by _also_ returning
 	// `ctrl.Result{Requeue: true}`.
 
-	var imageRepo imagev1.ImageRepository
-	if err := r.Get(ctx, req.NamespacedName, &imageRepo); err != nil {
-		return ctrl.Result{}, client.IgnoreNotFound(err)
-	}
-
-	defer r.recordSuspension(ctx, imageRepo)
+	defer r.recordSuspension(ctx, obj, imageRepo.Spec.Suspend)
 
 	log := ctrl.LoggerFrom(ctx)
 
 	// Add our finalizer if it does not exist.
-	if !controllerutil.ContainsFinalizer(&imageRepo, imagev1.ImageRepositoryFinalizer) {
-		patch := client.MergeFrom(imageRepo.DeepCopy())
-		controllerutil.AddFinalizer(&imageRepo, imagev1.ImageRepositoryFinalizer)
-		if err := r.Patch(ctx, &imageRepo, patch); err != nil {
+	if !controllerutil.ContainsFinalizer(obj, imagev1.ImageRepositoryFinalizer) {
+		patch := client.MergeFrom(obj.DeepCopyObject().(client.Object))
+		controllerutil.AddFinalizer(obj, imagev1.ImageRepositoryFinalizer)
+		if err := r.Patch(ctx, obj, patch); err != nil {
 			log.Error(err, "unable to register finalizer")
 			return ctrl.Result{}, err
 		}
 	}
 
-	// If the object is under deletion, record the readiness, and remove our finalizer.
-	if !imageRepo.ObjectMeta.DeletionTimestamp.IsZero() {
-		r.recordReadinessMetric(ctx, &imageRepo)
-		controllerutil.RemoveFinalizer(&imageRepo, imagev1.ImageRepositoryFinalizer)
-		if err := r.Update(ctx, &imageRepo); err != nil {
+	// If the object is under deletion, record the readiness, delete what
+	// was recorded for the image, and remove our finalizer.
+	if !obj.GetDeletionTimestamp().IsZero() {
+		r.recordReadinessMetric(ctx, obj, &imageRepo.Status)
+		recordRateLimitMetric(kind, obj, nil)
+		if err := r.deleteImageRecords(ctx, imageRepo.Status.CanonicalImageName); err != nil {
+			log.Error(err, "unable to delete the database records of the image")
+			return ctrl.Result{Requeue: true}, err
+		}
+		controllerutil.RemoveFinalizer(obj, imagev1.ImageRepositoryFinalizer)
+		if err := r.Update(ctx, obj); err != nil {
 			return ctrl.Result{}, err
 		}
 		return ctrl.Result{}, nil
 	}
 
+	// Record the image policies using the image repository, suspended or
+	// not, so it can be seen which would be affected by suspending or
+	// deleting it.
+	if changed, err := r.recordPolicies(ctx, imageRepo, client.ObjectKeyFromObject(obj)); err != nil {
+		log.Error(err, "unable to list the image policies using the image repository")
+	} else if changed {
+		if err := patchStatus(); err != nil {
+			return ctrl.Result{Requeue: true}, err
+		}
+	}
+
 	if imageRepo.Spec.Suspend {
-		msg := "ImageRepository is suspended, skipping reconciliation"
+		msg := kind + " is suspended, skipping reconciliation"
+		apimeta.RemoveStatusCondition(&imageRepo.Status.Conditions, meta.ReconcilingCondition)
+		imageRepo.Status.NextScanTime = nil
 		imagev1.SetImageRepositoryReadiness(
-			&imageRepo,
+			imageRepo,
 			metav1.ConditionFalse,
 			meta.SuspendedReason,
 			msg,
 		)
-		if err := r.patchStatus(ctx, req, imageRepo.Status); err != nil {
+		if err := patchStatus(); err != nil {
 			log.Error(err, "unable to update status")
 			return ctrl.Result{Requeue: true}, err
 		}
@@ -148,57 +267,116 @@ func (r *ImageRepositoryReconciler) Reconcile(ctx context.Context, req ctrl.Requ
 	}
 
 	// Record readiness metric
-	defer r.recordReadinessMetric(ctx, &imageRepo)
+	defer r.recordReadinessMetric(ctx, obj, &imageRepo.Status)
 	// Record reconciliation duration
 	if r.MetricsRecorder != nil {
-		objRef, err := reference.GetReference(r.Scheme, &imageRepo)
+		objRef, err := reference.GetReference(r.Scheme, obj)
 		if err != nil {
 			return ctrl.Result{}, err
 		}
 		defer r.MetricsRecorder.RecordDuration(*objRef, reconcileStart)
 	}
 
-	ref, err := parseImageReference(imageRepo.Spec.Image)
+	if imageRepo.Spec.Insecure && !r.InsecureAllowHTTP {
+		err := errors.New("insecure connections to registries are disabled by the controller flag --insecure-allow-http=false")
+		markStalled(imageRepo, imagev1.InvalidSpecReason, err.Error())
+		if err := patchStatus(); err != nil {
+			return ctrl.Result{Requeue: true}, err
+		}
+		r.event(ctx, obj, events.EventSeverityError, err.Error())
+		// Retrying will not help until the spec is changed, which will
+		// trigger another reconciliation anyway.
+		return ctrl.Result{}, nil
+	}
+
+	if err := checkTagFilters(*imageRepo); err != nil {
+		markStalled(imageRepo, imagev1.InvalidSpecReason, err.Error())
+		if err := patchStatus(); err != nil {
+			return ctrl.Result{Requeue: true}, err
+		}
+		r.event(ctx, obj, events.EventSeverityError, err.Error())
+		return ctrl.Result{}, nil
+	}
+
+	if err := checkScanTiming(*imageRepo); err != nil {
+		markStalled(imageRepo, imagev1.ScheduleInvalidReason, err.Error())
+		if err := patchStatus(); err != nil {
+			return ctrl.Result{Requeue: true}, err
+		}
+		r.event(ctx, obj, events.EventSeverityError, err.Error())
+		return ctrl.Result{}, nil
+	}
+
+	ref, err := parseImageReference(imageRepo.Spec.Image, imageRepo.Spec.Insecure)
 	if err != nil {
-		imagev1.SetImageRepositoryReadiness(
-			&imageRepo,
-			metav1.ConditionFalse,
-			imagev1.ImageURLInvalidReason,
-			err.Error(),
-		)
-		if err := r.patchStatus(ctx, req, imageRepo.Status); err != nil {
+		markStalled(imageRepo, imagev1.ImageURLInvalidReason, err.Error())
+		if err := patchStatus(); err != nil {
 			return ctrl.Result{Requeue: true}, err
 		}
 		err := fmt.Errorf("Unable to parse image name: %s: %w", imageRepo.Spec.Image, err)
-		r.event(ctx, imageRepo, events.EventSeverityError, err.Error())
-		return ctrl.Result{Requeue: true}, err
+		r.event(ctx, obj, events.EventSeverityError, err.Error())
+		return ctrl.Result{}, nil
 	}
 
-	// Set CanonicalImageName based on the parsed reference
-	if c := ref.Context().String(); imageRepo.Status.CanonicalImageName != c {
+	// Set CanonicalImageName based on the parsed reference, and end a
+	// stall because of the spec, which is now valid.
+	cleared := clearSpecStall(imageRepo)
+	if c := ref.Context().String(); imageRepo.Status.CanonicalImageName != c || cleared {
 		imageRepo.Status.CanonicalImageName = c
-		if err = r.patchStatus(ctx, req, imageRepo.Status); err != nil {
+		if err = patchStatus(); err != nil {
 			return ctrl.Result{Requeue: true}, err
 		}
 	}
 
 	// Throttle scans based on spec Interval
-	ok, when, err := r.shouldScan(imageRepo, reconcileStart)
+	ok, when, err := r.shouldScan(*imageRepo, reconcileStart)
 	if err != nil {
 		return ctrl.Result{Requeue: true}, err
 	}
 	if ok {
-		reconcileErr := r.scan(ctx, &imageRepo, ref)
-		if err := r.patchStatus(ctx, req, imageRepo.Status); err != nil {
+		markReconciling(imageRepo)
+		if err := patchStatus(); err != nil {
+			return ctrl.Result{Requeue: true}, err
+		}
+		changes, reconcileErr := r.scan(ctx, imageRepo, ref)
+		r.recordScanBackoff(imageRepo, reconcileErr, time.Now())
+		recordReconciling(imageRepo, reconcileErr)
+		recordNextScanTime(imageRepo, time.Now(), nextScanAfter(*imageRepo, reconcileErr, when))
+		if err := patchStatus(); err != nil {
 			return ctrl.Result{Requeue: true}, err
 		}
+		recordRateLimitMetric(kind, obj, imageRepo.Status.RateLimit)
 		if reconcileErr != nil {
-			r.event(ctx, imageRepo, events.EventSeverityError, reconcileErr.Error())
+			r.event(ctx, obj, events.EventSeverityError, reconcileErr.Error())
+			if apimeta.IsStatusConditionTrue(imageRepo.Status.Conditions, meta.StalledCondition) {
+				log.Error(reconcileErr, "scan stalled, not retrying until the spec changes or a reconciliation is requested")
+				return ctrl.Result{}, nil
+			}
+			if b := imageRepo.Status.Backoff; b != nil {
+				log.Error(reconcileErr, "scan failed", "failures", b.Failures, "retryIn", b.Delay.Duration.String())
+				return ctrl.Result{RequeueAfter: b.Delay.Duration}, nil
+			}
 			return ctrl.Result{Requeue: true}, reconcileErr
 		}
 		// emit successful scan event
 		if rc := apimeta.FindStatusCondition(imageRepo.Status.Conditions, imagev1.ReconciliationSucceededReason); rc != nil {
-			r.event(ctx, imageRepo, events.EventSeverityInfo, rc.Message)
+			r.event(ctx, obj, events.EventSeverityInfo, rc.Message)
+		}
+		if len(changes.added) > 0 {
+			msg, metadata := newTagsEvent(changes.added)
+			r.annotatedEvent(ctx, obj, events.EventSeverityInfo, msg, metadata)
+		}
+		if len(changes.removed) > 0 {
+			msg, metadata := removedTagsEvent(changes.removed)
+			r.annotatedEvent(ctx, obj, events.EventSeverityError, msg, metadata)
+		}
+		// in read-only mode, report what would have been recorded
+		if rc := apimeta.FindStatusCondition(imageRepo.Status.Conditions, meta.ReadyCondition); r.ReadOnly && rc != nil {
+			r.event(ctx, obj, events.EventSeverityInfo, rc.Message)
+		}
+	} else if recordNextScanTime(imageRepo, time.Now(), when) {
+		if err := patchStatus(); err != nil {
+			return ctrl.Result{Requeue: true}, err
 		}
 	}
 
@@ -210,12 +388,17 @@ func (r *ImageRepositoryReconciler) Reconcile(ctx context.Context, req ctrl.Requ
 	return ctrl.Result{RequeueAfter: when}, nil
 }
 
-func parseImageReference(url string) (name.Reference, error) {
+func parseImageReference(url string, insecure bool) (name.Reference, error) {
 	if s := strings.Split(url, "://"); len(s) > 1 {
 		return nil, fmt.Errorf(".spec.image value should not start with URL scheme; remove '%s://'", s[0])
 	}
 
-	ref, err := name.ParseReference(url)
+	var opts []name.Option
+	if insecure {
+		opts = append(opts, name.Insecure)
+	}
+
+	ref, err := name.ParseReference(url, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -228,163 +411,146 @@ func parseImageReference(url string) (name.Reference, error) {
 	return ref, nil
 }
 
-func (r *ImageRepositoryReconciler) scan(ctx context.Context, imageRepo *imagev1.ImageRepository, ref name.Reference) error {
+// scan lists the tags of the image repository and records them,
+// returning the tags added to and removed from the image repository
+// since the previous scan, if any.
+func (r *ImageRepositoryReconciler) scan(ctx context.Context, imageRepo *imagev1.ImageRepository, ref name.Reference) (tagChanges, error) {
 	timeout := imageRepo.GetTimeout()
+	// The slot to list the tags is waited for before the timeout starts,
+	// so that scans do not time out waiting their turn.
+	if err := r.ScanSlots.AcquireWithPriority(ctx, imageRepo.Spec.Priority); err != nil {
+		return tagChanges{}, fmt.Errorf("waiting to scan: %w", err)
+	}
+	scanStart := time.Now()
 	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
-
-	// Configure authentication strategy to access the registry.
-	var options []remote.Option
-	var authSecret corev1.Secret
-	var auth authn.Authenticator
-	var authErr error
-	if imageRepo.Spec.SecretRef != nil {
-		if err := r.Get(ctx, types.NamespacedName{
-			Namespace: imageRepo.GetNamespace(),
-			Name:      imageRepo.Spec.SecretRef.Name,
-		}, &authSecret); err != nil {
-			imagev1.SetImageRepositoryReadiness(
-				imageRepo,
-				metav1.ConditionFalse,
-				imagev1.ReconciliationFailedReason,
-				err.Error(),
-			)
-			return err
-		}
-		auth, authErr = authFromSecret(authSecret, ref)
-	} else {
-		// Use the registry provider options to attempt registry login.
-		auth, authErr = login.NewManager().Login(ctx, imageRepo.Spec.Image, ref, r.ProviderOptions)
+	// Any cached login is to last the scan, rather than expire part way.
+	ctx = login.WithValidFor(ctx, timeout)
+	ctx, requests := registry.WithRequestCounter(ctx)
+
+	resumed := imageRepo.Status.ScanCursor != ""
+	// A change to the spec, e.g., to the filter of the tags, has them
+	// listed in full.
+	if imageRepo.Status.ObservedGeneration != imageRepo.Generation {
+		imageRepo.Status.LastFullListTime = nil
 	}
-	if authErr != nil {
-		imagev1.SetImageRepositoryReadiness(
-			imageRepo,
-			metav1.ConditionFalse,
-			imagev1.ReconciliationFailedReason,
-			authErr.Error(),
-		)
-		return authErr
+	auth, tr, tags, digests, err := r.accessAndListTags(ctx, imageRepo, ref)
+	r.ScanSlots.Release()
+	notModified := errors.Is(err, registry.ErrNotModified)
+	if notModified {
+		err = nil
 	}
-	if auth != nil {
-		options = append(options, remote.WithAuth(auth))
+	r.recordThrottling(imageRepo, ref, err)
+	recordStalled(imageRepo, err)
+	r.recordIntervalClamped(imageRepo)
+	if err != nil {
+		return tagChanges{}, err
 	}
-
-	// Load any provided certificate.
-	if imageRepo.Spec.CertSecretRef != nil {
-		var certSecret corev1.Secret
-		if imageRepo.Spec.SecretRef != nil && imageRepo.Spec.SecretRef.Name == imageRepo.Spec.CertSecretRef.Name {
-			certSecret = authSecret
-		} else {
-			if err := r.Get(ctx, types.NamespacedName{
-				Namespace: imageRepo.GetNamespace(),
-				Name:      imageRepo.Spec.CertSecretRef.Name,
-			}, &certSecret); err != nil {
-				imagev1.SetImageRepositoryReadiness(
-					imageRepo,
-					metav1.ConditionFalse,
-					imagev1.ReconciliationFailedReason,
-					err.Error(),
-				)
-				return err
-			}
-		}
-
-		tr, err := transportFromSecret(&certSecret)
-		if err != nil {
-			return err
+	// If the registry says the tags have not changed since they were
+	// last listed, those recorded are scanned again, finding nothing
+	// to record.
+	if notModified {
+		ctrl.LoggerFrom(ctx).V(1).Info("tags not modified since last listed")
+		if tags, err = r.Database.Tags(ref.Context().String()); err != nil {
+			return tagChanges{}, fmt.Errorf("failed to get tags for %q: %w", ref.Context().String(), err)
 		}
-		options = append(options, remote.WithTransport(tr))
 	}
 
-	if imageRepo.Spec.ServiceAccountName != "" {
-
-		serviceAccount := corev1.ServiceAccount{}
-		// lookup service account
-		if err := r.Get(ctx, types.NamespacedName{
-			Namespace: imageRepo.GetNamespace(),
-			Name:      imageRepo.Spec.ServiceAccountName,
-		}, &serviceAccount); err != nil {
-			imagev1.SetImageRepositoryReadiness(
-				imageRepo,
-				metav1.ConditionFalse,
-				imagev1.ReconciliationFailedReason,
-				err.Error(),
-			)
-			return err
-		}
-
-		if len(serviceAccount.ImagePullSecrets) > 0 {
-			imagePullSecrets := make([]corev1.Secret, len(serviceAccount.ImagePullSecrets))
-
-			for i, ips := range serviceAccount.ImagePullSecrets {
-				var saAuthSecret corev1.Secret
-
-				if err := r.Get(ctx, types.NamespacedName{
-					Namespace: imageRepo.GetNamespace(),
-					Name:      ips.Name,
-				}, &saAuthSecret); err != nil {
-					imagev1.SetImageRepositoryReadiness(
-						imageRepo,
-						metav1.ConditionFalse,
-						imagev1.ReconciliationFailedReason,
-						err.Error(),
-					)
-					return err
-				}
-
-				imagePullSecrets[i] = saAuthSecret
-			}
+	// If no exclusion list has been defined, we make sure to always skip tags ending with
+	// ".sig", since that tag does not point to a valid image.
+	exclusionList := imageRepo.Spec.ExclusionList
+	if len(exclusionList) == 0 {
+		exclusionList = []string{CosignObjectRegex}
+	}
 
-			keychain, err := k8schain.NewFromPullSecrets(ctx, imagePullSecrets)
-			if err != nil {
-				return err
-			}
+	filteredTags, err := filterTags(tags, imageRepo.Spec.InclusionList, exclusionList)
+	if err != nil {
+		return tagChanges{}, err
+	}
+	limit := r.DefaultTagLimit
+	if imageRepo.Spec.TagLimit != nil {
+		limit = *imageRepo.Spec.TagLimit
+	}
+	policies := r.policiesUsing(ctx, imageRepo)
+	filteredTags = limitTags(filteredTags, limit, policyOrderings(policies, filteredTags))
 
-			options = append(options, remote.WithAuthFromKeychain(keychain))
+	canonicalName := ref.Context().String()
+	scanTime := metav1.Now()
+	previous, err := r.Database.Tags(canonicalName)
+	if err != nil {
+		return tagChanges{}, fmt.Errorf("failed to get tags for %q: %w", canonicalName, err)
+	}
+	firstSeen, firstSeenChanged, err := r.firstSeen(canonicalName, filteredTags, scanTime.Time)
+	if err != nil {
+		return tagChanges{}, fmt.Errorf("failed to get when tags were first seen for %q: %w", canonicalName, err)
+	}
+	// Most scans find the tags already recorded, in which case nothing
+	// is written, unless a resumed scan has left partial tags to remove.
+	added, removed := tagsDelta(previous, filteredTags)
+	if len(added) > 0 || len(removed) > 0 || firstSeenChanged || resumed {
+		ctrl.LoggerFrom(ctx).V(1).Info("recording tags", "added", len(added), "removed", len(removed))
+		if err := r.Database.SetScanResult(canonicalName, filteredTags, firstSeen); err != nil {
+			// The validators recorded with the listing are of tags that
+			// are not recorded, so they are not to be used.
+			_ = r.Database.SetTagListValidators(canonicalName, nil)
+			return tagChanges{}, fmt.Errorf("failed to set tags for %q: %w", canonicalName, err)
 		}
 	}
 
-	options = append(options, remote.WithContext(ctx))
-
-	tags, err := remote.List(ref.Context(), options...)
+	// The tags removed by this scan were last seen by the previous scan.
+	previousScan := scanTime.Time
+	lastScan := imageRepo.Status.LastScanResult
+	if lastScan != nil && !lastScan.ScanTime.IsZero() {
+		previousScan = lastScan.ScanTime.Time
+	}
+	// A tag recorded is only removed if the registry no longer lists it,
+	// rather than if it is now excluded by the filters or the limit of
+	// the tags; one listed again is no longer removed.
+	listed, unlisted := tagsDelta(previous, tags)
+	// Tags are only changes if some were recorded before: not those of
+	// the first scan, nor all of those recorded again after the database
+	// was replaced, nor those not recorded in read-only mode.
+	var changes tagChanges
+	if lastScan != nil && len(previous) > 0 && !r.ReadOnly {
+		changes.added = added
+		changes.removed = unlisted
+	}
+	lastSeen, lastSeenChanged, err := r.lastSeen(canonicalName, listed, unlisted, previousScan)
 	if err != nil {
-		imagev1.SetImageRepositoryReadiness(
-			imageRepo,
-			metav1.ConditionFalse,
-			imagev1.ReconciliationFailedReason,
-			err.Error(),
-		)
-		return err
+		return tagChanges{}, fmt.Errorf("failed to get when tags were last seen for %q: %w", canonicalName, err)
 	}
-
-	// If no exclusion list has been defined, we make sure to always skip tags ending with
-	// ".sig", since that tag does not point to a valid image.
-	if len(imageRepo.Spec.ExclusionList) == 0 {
-		imageRepo.Spec.ExclusionList = append(imageRepo.Spec.ExclusionList, CosignObjectRegex)
+	if lastSeenChanged {
+		if err := r.Database.SetLastSeen(canonicalName, lastSeen); err != nil {
+			return tagChanges{}, fmt.Errorf("failed to record when tags were last seen for %q: %w", canonicalName, err)
+		}
 	}
 
-	filteredTags := []string{}
-	for _, regex := range imageRepo.Spec.ExclusionList {
-		r, err := regexp.Compile(regex)
-		if err != nil {
-			return fmt.Errorf("failed to compile regex %s: %w", regex, err)
-		}
-		for _, tag := range tags {
-			if !r.MatchString(tag) {
-				filteredTags = append(filteredTags, tag)
+	needs := needsOf(policies)
+	if imageRepo.Spec.FetchMetadata || needs.creationTimes || needs.configs {
+		if err := r.ScanSlots.AcquireWithPriority(ctx, imageRepo.Spec.Priority); err != nil {
+			ctrl.LoggerFrom(ctx).Info("did not fetch the metadata of tags", "reason", err.Error())
+		} else {
+			if imageRepo.Spec.FetchMetadata {
+				r.fetchMetadata(ctx, canonicalName, ref, filteredTags, digests, auth, tr)
 			}
+			r.fetchPolicyMetadata(ctx, canonicalName, ref, filteredTags, needs, remoteOptions(ctx, auth, tr))
+			r.ScanSlots.Release()
 		}
 	}
-
-	canonicalName := ref.Context().String()
-	if err := r.Database.SetTags(canonicalName, filteredTags); err != nil {
-		return fmt.Errorf("failed to set tags for %q: %w", canonicalName, err)
+	if l, ok := dockerhub.RateLimitOf(tr); ok {
+		imageRepo.Status.RateLimit = rateLimitStatus(l, scanTime)
 	}
 
-	scanTime := metav1.Now()
+	imageRepo.Status.EffectiveInterval = nextInterval(*imageRepo, lastScan != nil && len(added) == 0 && len(removed) == 0)
+
 	imageRepo.Status.LastScanResult = &imagev1.ScanResult{
-		TagCount: len(filteredTags),
-		ScanTime: scanTime,
+		TagCount:        len(filteredTags),
+		ScanTime:        scanTime,
+		Duration:        &metav1.Duration{Duration: time.Since(scanStart).Round(time.Millisecond)},
+		Requests:        requests.Count(),
+		LatestTags:      latestTags(filteredTags),
+		RemovedTagCount: len(changes.removed),
+		RemovedTags:     removedTags(lastSeen),
 	}
 
 	// if the reconcile request annotation was set, consider it
@@ -398,185 +564,1774 @@ func (r *ImageRepositoryReconciler) scan(ctx context.Context, imageRepo *imagev1
 		imageRepo,
 		metav1.ConditionTrue,
 		imagev1.ReconciliationSucceededReason,
-		fmt.Sprintf("successful scan, found %v tags", len(filteredTags)),
+		scanMessage(len(filteredTags), lastScan != nil && lastScan.TagCount > 0 && len(previous) == 0)+r.readOnlyMessage(added, removed),
 	)
 
-	return nil
+	return changes, nil
 }
 
-func transportFromSecret(certSecret *corev1.Secret) (*http.Transport, error) {
-	// It's possible the secret doesn't contain any certs after
-	// all and the default transport could be used; but it's
-	// simpler here to assume a fresh transport is needed.
-	transport := &http.Transport{
-		TLSClientConfig: &tls.Config{},
-	}
-	tlsConfig := transport.TLSClientConfig
-
-	if clientCert, ok := certSecret.Data[ClientCert]; ok {
-		// parse and set client cert and secret
-		if clientKey, ok := certSecret.Data[ClientKey]; ok {
-			cert, err := tls.X509KeyPair(clientCert, clientKey)
-			if err != nil {
-				return nil, err
-			}
-			tlsConfig.Certificates = append(tlsConfig.Certificates, cert)
-		} else {
-			return nil, fmt.Errorf("client certificate found, but no key")
-		}
+// recordThrottling backs off from the registry if the error given is
+// it throttling the scan, setting the throttled condition, or ends any
+// backoff from it and removes the condition if the scan succeeded.
+func (r *ImageRepositoryReconciler) recordThrottling(imageRepo *imagev1.ImageRepository, ref name.Reference, err error) {
+	if r.RegistryBackoff == nil {
+		return
 	}
-	if caCert, ok := certSecret.Data[CACert]; ok {
-		syscerts, err := x509.SystemCertPool()
-		if err != nil {
-			return nil, err
-		}
-		syscerts.AppendCertsFromPEM(caCert)
-		tlsConfig.RootCAs = syscerts
+	registry := ref.Context().RegistryStr()
+	switch {
+	case err == nil:
+		r.RegistryBackoff.Recovered(registry)
+		apimeta.RemoveStatusCondition(&imageRepo.Status.Conditions, imagev1.ThrottledCondition)
+	case isThrottledError(err):
+		delay := r.RegistryBackoff.Failed(registry)
+		apimeta.SetStatusCondition(&imageRepo.Status.Conditions, metav1.Condition{
+			Type:               imagev1.ThrottledCondition,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: imageRepo.Generation,
+			Reason:             imagev1.ThrottledReason,
+			Message:            fmt.Sprintf("registry '%s' is throttling requests; scans of its images are put off for %s", registry, delay.Round(time.Second)),
+		})
 	}
-
-	return transport, nil
 }
 
-// shouldScan takes an image repo and the time now, and says whether
-// the repository should be scanned now, and how long to wait for the
-// next scan.
-func (r *ImageRepositoryReconciler) shouldScan(repo imagev1.ImageRepository, now time.Time) (bool, time.Duration, error) {
-	scanInterval := repo.Spec.Interval.Duration
-
-	// never scanned; do it now
-	lastScanResult := repo.Status.LastScanResult
-	if lastScanResult == nil {
-		return true, scanInterval, nil
-	}
-	lastScanTime := lastScanResult.ScanTime
-
-	// Is the controller seeing this because the reconcileAt
-	// annotation was tweaked? Despite the name of the annotation, all
-	// that matters is that it's different.
-	if syncAt, ok := meta.ReconcileAnnotationValue(repo.GetAnnotations()); ok {
-		if syncAt != repo.Status.GetLastHandledReconcileRequest() {
-			return true, scanInterval, nil
-		}
+// recordIntervalClamped sets the interval clamped condition if the
+// interval of the image repository is shorter than the controller
+// allows, or removes the condition otherwise.
+func (r *ImageRepositoryReconciler) recordIntervalClamped(imageRepo *imagev1.ImageRepository) {
+	interval := imageRepo.Spec.Interval.Duration
+	if imageRepo.Spec.Schedule != nil || interval >= r.MinScanInterval {
+		apimeta.RemoveStatusCondition(&imageRepo.Status.Conditions, imagev1.IntervalClampedCondition)
+		return
 	}
+	apimeta.SetStatusCondition(&imageRepo.Status.Conditions, metav1.Condition{
+		Type:               imagev1.IntervalClampedCondition,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: imageRepo.Generation,
+		Reason:             imagev1.IntervalBelowMinimumReason,
+		Message:            fmt.Sprintf("the interval %s is shorter than the minimum of %s the controller allows; scans are each %s instead", interval, r.MinScanInterval, r.MinScanInterval),
+	})
+}
 
-	// when recovering, it's possible that the resource has a last
-	// scan time, but there's no records because the database has been
-	// dropped and created again.
-
-	// FIXME If the repo exists, has been
-	// scanned, and doesn't have any tags, this will mean a scan every
-	// time the resource comes up for reconciliation.
-	tags, err := r.Database.Tags(repo.Status.CanonicalImageName)
-	if err != nil {
-		return false, scanInterval, err
+// recordStalled sets the stalled condition if the error given is the
+// registry not having the image repository, which retrying will not
+// change, or removes the condition otherwise. A reconciliation requested
+// with the annotation is handled by the stalled scan.
+func recordStalled(imageRepo *imagev1.ImageRepository, err error) {
+	if !isNotFoundError(err) {
+		apimeta.RemoveStatusCondition(&imageRepo.Status.Conditions, meta.StalledCondition)
+		return
 	}
-	if len(tags) == 0 {
-		return true, scanInterval, nil
+	markStalled(imageRepo, imagev1.RepositoryNotFoundReason, fmt.Sprintf("the registry does not have the image repository: %s", err))
+	if token, ok := meta.ReconcileAnnotationValue(imageRepo.GetAnnotations()); ok {
+		imageRepo.Status.SetLastHandledReconcileRequest(token)
 	}
+}
 
-	when := scanInterval - now.Sub(lastScanTime.Time)
-	if when < time.Second {
-		return true, scanInterval, nil
-	}
-	return false, when, nil
+// markStalled sets the stalled condition, and the ready condition to
+// false, with the reason and message given, for a failure the controller
+// will not get past by retrying; the image repository is no longer
+// reconciling, nor to be scanned again.
+func markStalled(imageRepo *imagev1.ImageRepository, reason, msg string) {
+	apimeta.RemoveStatusCondition(&imageRepo.Status.Conditions, meta.ReconcilingCondition)
+	imageRepo.Status.NextScanTime = nil
+	apimeta.SetStatusCondition(&imageRepo.Status.Conditions, metav1.Condition{
+		Type:               meta.StalledCondition,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: imageRepo.Generation,
+		Reason:             reason,
+		Message:            msg,
+	})
+	imagev1.SetImageRepositoryReadiness(
+		imageRepo,
+		metav1.ConditionFalse,
+		reason,
+		msg,
+	)
 }
 
-func (r *ImageRepositoryReconciler) SetupWithManager(mgr ctrl.Manager, opts ImageRepositoryReconcilerOptions) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&imagev1.ImageRepository{}).
-		WithEventFilter(predicate.Or(predicate.GenerationChangedPredicate{}, predicates.ReconcileRequestedPredicate{})).
-		WithOptions(controller.Options{
-			MaxConcurrentReconciles: opts.MaxConcurrentReconciles,
-		}).
-		Complete(r)
+// clearSpecStall removes the stalled condition if it was set because of
+// the spec of the image repository, rather than by a scan, reporting
+// whether it did.
+func clearSpecStall(imageRepo *imagev1.ImageRepository) bool {
+	stalled := apimeta.FindStatusCondition(imageRepo.Status.Conditions, meta.StalledCondition)
+	if stalled == nil || stalled.Reason == imagev1.RepositoryNotFoundReason {
+		return false
+	}
+	apimeta.RemoveStatusCondition(&imageRepo.Status.Conditions, meta.StalledCondition)
+	return true
 }
 
-// authFromSecret creates an Authenticator that can be given to the
-// `remote` funcs, from a Kubernetes secret. If the secret doesn't
-// have the right format or data, it returns an error.
-func authFromSecret(secret corev1.Secret, ref name.Reference) (authn.Authenticator, error) {
-	switch secret.Type {
-	case "kubernetes.io/dockerconfigjson":
-		var dockerconfig dockerConfig
-		configData := secret.Data[".dockerconfigjson"]
-		if err := json.NewDecoder(bytes.NewBuffer(configData)).Decode(&dockerconfig); err != nil {
-			return nil, err
-		}
+// markReconciling sets the reconciling condition, for the scan of the
+// image repository about to start.
+func markReconciling(imageRepo *imagev1.ImageRepository) {
+	apimeta.SetStatusCondition(&imageRepo.Status.Conditions, metav1.Condition{
+		Type:               meta.ReconcilingCondition,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: imageRepo.Generation,
+		Reason:             meta.ProgressingReason,
+		Message:            fmt.Sprintf("scanning the image repository for generation %d", imageRepo.Generation),
+	})
+}
 
-		authMap, err := parseAuthMap(dockerconfig)
-		if err != nil {
-			return nil, err
-		}
-		registry := ref.Context().RegistryStr()
-		auth, ok := authMap[registry]
-		if !ok {
-			return nil, fmt.Errorf("auth for %q not found in secret %v", registry, types.NamespacedName{Name: secret.GetName(), Namespace: secret.GetNamespace()})
-		}
-		return authn.FromConfig(auth), nil
-	default:
-		return nil, fmt.Errorf("unknown secret type %q", secret.Type)
+// recordReconciling removes the reconciling condition after a scan that
+// succeeded or stalled, or keeps it, with the error given, while a scan
+// that failed is to be retried.
+func recordReconciling(imageRepo *imagev1.ImageRepository, err error) {
+	if err == nil || apimeta.IsStatusConditionTrue(imageRepo.Status.Conditions, meta.StalledCondition) {
+		apimeta.RemoveStatusCondition(&imageRepo.Status.Conditions, meta.ReconcilingCondition)
+		return
 	}
+	apimeta.SetStatusCondition(&imageRepo.Status.Conditions, metav1.Condition{
+		Type:               meta.ReconcilingCondition,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: imageRepo.Generation,
+		Reason:             imagev1.ProgressingWithRetryReason,
+		Message:            fmt.Sprintf("the scan failed, and is to be retried: %s", err),
+	})
 }
 
-// event emits a Kubernetes event and forwards the event to notification controller if configured
-func (r *ImageRepositoryReconciler) event(ctx context.Context, repo imagev1.ImageRepository, severity, msg string) {
-	eventtype := "Normal"
-	if severity == events.EventSeverityError {
-		eventtype = "Warning"
+// nextScanAfter returns how long after a scan ending with the error given
+// the image repository is to be scanned again: after the interval given
+// if the scan succeeded, or after the backoff from it if it failed. It
+// returns zero for a scan that stalled, or failed without a backoff, the
+// next scan of which is not at a time known.
+func nextScanAfter(imageRepo imagev1.ImageRepository, err error, interval time.Duration) time.Duration {
+	switch {
+	case err == nil:
+		return interval
+	case apimeta.IsStatusConditionTrue(imageRepo.Status.Conditions, meta.StalledCondition):
+		return 0
+	case imageRepo.Status.Backoff != nil:
+		return imageRepo.Status.Backoff.Delay.Duration
 	}
-	r.EventRecorder.Eventf(&repo, eventtype, severity, msg)
+	return 0
 }
 
-func (r *ImageRepositoryReconciler) recordReadinessMetric(ctx context.Context, repo *imagev1.ImageRepository) {
-	if r.MetricsRecorder == nil {
-		return
+// recordNextScanTime sets the time of the next scan in the status of the
+// image repository, the length of time given after now, or removes it
+// for a length of time of zero. It reports whether the time changed by
+// more than a second, since a time recomputed for the same scan differs
+// by the time taken to compute it.
+func recordNextScanTime(imageRepo *imagev1.ImageRepository, now time.Time, after time.Duration) bool {
+	previous := imageRepo.Status.NextScanTime
+	if after <= 0 {
+		imageRepo.Status.NextScanTime = nil
+		return previous != nil
+	}
+	next := now.Add(after)
+	if previous != nil {
+		if d := next.Sub(previous.Time); d > -time.Second && d < time.Second {
+			return false
+		}
 	}
+	t := metav1.NewTime(next)
+	imageRepo.Status.NextScanTime = &t
+	return true
+}
 
-	objRef, err := reference.GetReference(r.Scheme, repo)
-	if err != nil {
-		ctrl.LoggerFrom(ctx).Error(err, "unable to record readiness metric")
+// recordScanBackoff records the backoff from scanning the image
+// repository after the scan that ended with the error given, or removes
+// it if the scan succeeded. A reconciliation requested with the
+// annotation is handled by the failed scan, so that it is not taken as
+// requested again by the next reconciliation.
+func (r *ImageRepositoryReconciler) recordScanBackoff(imageRepo *imagev1.ImageRepository, err error, now time.Time) {
+	// A stalled image repository is not scanned again at all, until
+	// its spec changes or a reconciliation is requested.
+	stalled := apimeta.IsStatusConditionTrue(imageRepo.Status.Conditions, meta.StalledCondition)
+	if err == nil || r.ScanBackoffBaseDelay <= 0 || stalled {
+		imageRepo.Status.Backoff = nil
 		return
 	}
-	if rc := apimeta.FindStatusCondition(repo.Status.Conditions, meta.ReadyCondition); rc != nil {
-		r.MetricsRecorder.RecordCondition(*objRef, *rc, !repo.DeletionTimestamp.IsZero())
-	} else {
-		r.MetricsRecorder.RecordCondition(*objRef, metav1.Condition{
-			Type:   meta.ReadyCondition,
-			Status: metav1.ConditionUnknown,
-		}, !repo.DeletionTimestamp.IsZero())
+	failures := 1
+	if b := imageRepo.Status.Backoff; b != nil {
+		failures = b.Failures + 1
+	}
+	delay := r.ScanBackoffBaseDelay
+	for i := 1; i < failures && (r.ScanBackoffMaxDelay <= 0 || delay < r.ScanBackoffMaxDelay); i++ {
+		delay *= 2
+	}
+	if r.ScanBackoffMaxDelay > 0 && delay > r.ScanBackoffMaxDelay {
+		delay = r.ScanBackoffMaxDelay
+	}
+	imageRepo.Status.Backoff = &imagev1.ScanBackoff{
+		Failures:        failures,
+		Delay:           metav1.Duration{Duration: delay},
+		LastFailureTime: metav1.NewTime(now),
+	}
+	if token, ok := meta.ReconcileAnnotationValue(imageRepo.GetAnnotations()); ok {
+		imageRepo.Status.SetLastHandledReconcileRequest(token)
 	}
 }
 
-func (r *ImageRepositoryReconciler) recordSuspension(ctx context.Context, imageRepo imagev1.ImageRepository) {
-	if r.MetricsRecorder == nil {
-		return
+// effectiveInterval returns the interval between scans of the image
+// repository: that in the status, while `.spec.maxInterval` has it
+// lengthened, between the interval and max interval of the spec.
+func effectiveInterval(repo imagev1.ImageRepository) time.Duration {
+	interval := repo.Spec.Interval.Duration
+	if repo.Spec.MaxInterval == nil || repo.Status.EffectiveInterval == nil {
+		return interval
 	}
-	log := ctrl.LoggerFrom(ctx)
-
-	objRef, err := reference.GetReference(r.Scheme, &imageRepo)
-	if err != nil {
-		log.Error(err, "unable to record suspended metric")
-		return
+	effective := repo.Status.EffectiveInterval.Duration
+	if max := repo.Spec.MaxInterval.Duration; effective > max {
+		effective = max
 	}
-
-	if !imageRepo.DeletionTimestamp.IsZero() {
-		r.MetricsRecorder.RecordSuspend(*objRef, false)
-	} else {
-		r.MetricsRecorder.RecordSuspend(*objRef, imageRepo.Spec.Suspend)
+	if effective < interval {
+		effective = interval
 	}
+	return effective
 }
 
-func (r *ImageRepositoryReconciler) patchStatus(ctx context.Context, req ctrl.Request,
-	newStatus imagev1.ImageRepositoryStatus) error {
-	var res imagev1.ImageRepository
-	if err := r.Get(ctx, req.NamespacedName, &res); err != nil {
-		return err
+// nextInterval returns the effective interval after a scan, doubled if
+// the scan found the tags unchanged, up to `.spec.maxInterval`, or nil
+// if the interval of the spec is to be used, or the scans are scheduled.
+func nextInterval(repo imagev1.ImageRepository, unchanged bool) *metav1.Duration {
+	if repo.Spec.MaxInterval == nil || repo.Spec.Schedule != nil || !unchanged {
+		return nil
+	}
+	next := 2 * effectiveInterval(repo)
+	if max := repo.Spec.MaxInterval.Duration; next > max {
+		next = max
 	}
+	if next <= repo.Spec.Interval.Duration {
+		return nil
+	}
+	return &metav1.Duration{Duration: next}
+}
 
-	patch := client.MergeFrom(res.DeepCopy())
-	res.Status = newStatus
+// scanMessage returns the message of the ready condition after a
+// successful scan finding the number of tags given. If the tags found by
+// the previous scan were missing from the database, e.g., because it was
+// corrupt and has been replaced with an empty database, the message says
+// so.
+func scanMessage(tagCount int, recordsMissing bool) string {
+	msg := fmt.Sprintf("successful scan, found %v tags", tagCount)
+	if recordsMissing {
+		msg += "; the tags found by the previous scan were missing from the database, and have been recorded again"
+	}
+	return msg
+}
+
+// tagChanges are the tags added to and removed from an image
+// repository since the previous scan, as reported in events.
+type tagChanges struct {
+	added, removed []string
+}
+
+// newTagsEvent returns the message and metadata of the event of a scan
+// finding the new tags given.
+func newTagsEvent(tags []string) (string, map[string]string) {
+	return tagsEvent("found %d new tags: %s", newTagsKey, newTagCountKey, tags)
+}
+
+// removedTagsEvent returns the message and metadata of the event of a
+// scan finding the tags given removed from the image repository.
+func removedTagsEvent(tags []string) (string, map[string]string) {
+	return tagsEvent("%d tags removed from the image repository since the previous scan: %s", removedTagsKey, removedTagCountKey, tags)
+}
+
+// tagsEvent returns the message, from the format given, and metadata of
+// an event about the tags given. Both give the count of the tags and,
+// if there are more than latestTagsCount, those latest.
+func tagsEvent(format, tagsKey, countKey string, tags []string) (string, map[string]string) {
+	sample := latestTags(tags)
+	list := strings.Join(sample, ", ")
+	if more := len(tags) - len(sample); more > 0 {
+		list += fmt.Sprintf(" and %d more", more)
+	}
+	return fmt.Sprintf(format, len(tags), list), map[string]string{
+		tagsKey:  strings.Join(sample, ","),
+		countKey: fmt.Sprint(len(tags)),
+	}
+}
+
+// readOnlyMessage returns what is added to the message of the ready
+// condition after a successful scan in read-only mode, giving the tags
+// that would have been added to and removed from the database.
+func (r *ImageRepositoryReconciler) readOnlyMessage(added, removed []string) string {
+	if !r.ReadOnly {
+		return ""
+	}
+	if len(added) == 0 && len(removed) == 0 {
+		return "; read-only, no change to the tags recorded"
+	}
+	return fmt.Sprintf("; read-only, not recorded: %d tags added (%s), %d removed (%s)",
+		len(added), strings.Join(latestTags(added), ", "), len(removed), strings.Join(latestTags(removed), ", "))
+}
+
+// fetchMetadata fetches the descriptor of each of the tags and, for
+// those new or moved to another image since the last scan, the
+// creation time and platforms of the image, and the config and provenance
+// if they were recorded before. A tag whose metadata could not be fetched is logged
+// and left to be fetched by the next scan. Nothing is fetched for a tag
+// whose digest, if given, is that of the descriptor recorded.
+func (r *ImageRepositoryReconciler) fetchMetadata(ctx context.Context, canonicalName string, ref name.Reference, tags []string, digests map[string]v1.Hash, auth authn.Authenticator, tr http.RoundTripper) {
+	log := ctrl.LoggerFrom(ctx)
+	for _, tag := range tags {
+		if ctx.Err() != nil {
+			log.Info("stopped fetching the metadata of tags", "reason", ctx.Err().Error())
+			return
+		}
+		if digest, ok := digests[tag]; ok {
+			previous, found, err := r.Database.Descriptor(canonicalName, tag)
+			if err != nil {
+				log.Error(err, "unable to get the descriptor of tag", "tag", tag)
+				continue
+			}
+			if found && previous.Digest == digest {
+				continue
+			}
+		}
+		if err := fetchTagMetadata(ctx, r.Database, canonicalName, ref.Context().Tag(tag), auth, tr); err != nil {
+			log.Error(err, "unable to fetch the metadata of tag", "tag", tag)
+		}
+	}
+}
+
+// policyMetadata is what the image policies using an image repository
+// need of the images its tags refer to, to be fetched by its scans.
+type policyMetadata struct {
+	// creationTimes are needed by policies ordering tags by the
+	// creation time of their images.
+	creationTimes bool
+	// configs are needed by policies checking the labels of images.
+	configs bool
+}
+
+// policiesUsing returns the specs of the image policies recorded in the
+// status as using the image repository, with the policy rules they take
+// from their templates. A policy, or template, that cannot be read is
+// passed over.
+func (r *ImageRepositoryReconciler) policiesUsing(ctx context.Context, imageRepo *imagev1.ImageRepository) []imagev1.ImagePolicySpec {
+	var specs []imagev1.ImagePolicySpec
+	for _, ref := range imageRepo.Status.Policies {
+		var pol imagev1.ImagePolicy
+		if err := r.Get(ctx, types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}, &pol); err != nil {
+			continue
+		}
+		if pol.Spec.TemplateRef != nil {
+			var tmpl imagev1.ClusterImagePolicy
+			if err := r.Get(ctx, types.NamespacedName{Name: pol.Spec.TemplateRef.Name}, &tmpl); err != nil {
+				continue
+			}
+			applyTemplate(&pol.Spec, tmpl.Spec)
+		}
+		specs = append(specs, pol.Spec)
+	}
+	return specs
+}
+
+// needsOf returns what the image policies need of the images of the
+// image repository they use. What a policy passed over by policiesUsing
+// needs is looked up when it is evaluated.
+func needsOf(policies []imagev1.ImagePolicySpec) policyMetadata {
+	var needs policyMetadata
+	for _, spec := range policies {
+		needs.creationTimes = needs.creationTimes || spec.Policy.CreatedAt != nil
+		needs.configs = needs.configs || spec.ImageLabelSelector != nil
+	}
+	return needs
+}
+
+// policyOrderings returns the tags in the order each of the image
+// policies would consider them, leaving out those a policy would not
+// consider at all. Policies ordering tags by the creation time of their
+// images are left out, since the creation times are only fetched for the
+// tags recorded.
+func policyOrderings(policies []imagev1.ImagePolicySpec, tags []string) [][]string {
+	var orderings [][]string
+	for _, spec := range policies {
+		if spec.Policy.CreatedAt != nil {
+			continue
+		}
+		policer, err := policy.PolicerFromSpec(spec.Policy)
+		if err != nil {
+			continue
+		}
+		candidates := tags
+		var filter *policy.RegexFilter
+		if spec.FilterTags != nil {
+			if filter, err = policy.NewRegexFilter(spec.FilterTags.Pattern, spec.FilterTags.Extract); err != nil {
+				continue
+			}
+			filter.Apply(tags)
+			candidates = filter.Items()
+		}
+		if len(candidates) == 0 {
+			continue
+		}
+		sorted, err := policer.Sort(candidates)
+		if err != nil {
+			continue
+		}
+		ordering := make([]string, 0, len(sorted))
+		for _, tag := range sorted {
+			if filter != nil {
+				tag = filter.GetOriginalTag(tag)
+			}
+			ordering = append(ordering, tag)
+		}
+		orderings = append(orderings, ordering)
+	}
+	return orderings
+}
+
+// fetchPolicyMetadata fetches what the image policies using the image
+// repository need of the images of the tags and is not yet recorded, so
+// that the policies need not fetch it when evaluated. A tag whose
+// metadata could not be fetched is logged and left to be fetched by the
+// next scan, as are the rest once the registry is throttling requests.
+func (r *ImageRepositoryReconciler) fetchPolicyMetadata(ctx context.Context, canonicalName string, ref name.Reference, tags []string, needs policyMetadata, options []remote.Option) {
+	log := ctrl.LoggerFrom(ctx)
+	fetch := func(tag string) error {
+		tagRef := ref.Context().Tag(tag)
+		if needs.creationTimes {
+			if _, ok, err := r.Database.CreationTime(canonicalName, tag); err != nil {
+				return err
+			} else if !ok {
+				created, err := registry.ImageCreated(tagRef, options...)
+				if err != nil {
+					return err
+				}
+				if err := r.Database.SetCreationTime(canonicalName, tag, created); err != nil {
+					return err
+				}
+			}
+		}
+		if needs.configs {
+			if _, ok, err := r.Database.ImageConfig(canonicalName, tag); err != nil {
+				return err
+			} else if !ok {
+				config, err := registry.ImageConfig(tagRef, options...)
+				if err != nil {
+					return err
+				}
+				if err := r.Database.SetImageConfig(canonicalName, tag, config); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	for _, tag := range tags {
+		if ctx.Err() != nil {
+			log.Info("stopped fetching the metadata of tags", "reason", ctx.Err().Error())
+			return
+		}
+		if err := fetch(tag); err != nil {
+			log.Error(err, "unable to fetch the metadata of tag", "tag", tag)
+			if isThrottledError(err) {
+				return
+			}
+		}
+	}
+}
+
+// tagMetadataStore is where fetchTagMetadata records the metadata of the
+// image a tag refers to.
+type tagMetadataStore interface {
+	DescriptorStore
+	CreationTimeStore
+	PlatformStore
+	ImageConfigStore
+	ProvenanceStore
+}
+
+// fetchTagMetadata fetches the descriptor of the tag and, if the tag is
+// new or has moved to another image since it was recorded, the metadata
+// fetchMetadata gives for the image, recording it in the database.
+func fetchTagMetadata(ctx context.Context, db tagMetadataStore, canonicalName string, tagRef name.Tag, auth authn.Authenticator, tr http.RoundTripper) error {
+	tag := tagRef.TagStr()
+	options := remoteOptions(ctx, auth, tr)
+	desc, err := remote.Head(tagRef, options...)
+	if err != nil {
+		return err
+	}
+	previous, found, err := db.Descriptor(canonicalName, tag)
+	if err != nil {
+		return err
+	}
+	if found && previous.Digest == desc.Digest {
+		return nil
+	}
+
+	created, err := registry.ImageCreated(tagRef, options...)
+	if err != nil {
+		return err
+	}
+	if err := db.SetCreationTime(canonicalName, tag, created); err != nil {
+		return err
+	}
+	platforms, err := registry.ImagePlatforms(tagRef, options...)
+	if err != nil {
+		return err
+	}
+	if err := db.SetPlatforms(canonicalName, tag, platforms); err != nil {
+		return err
+	}
+	// A config is fetched only when a policy needs it; one recorded for
+	// the image the tag referred to before is replaced.
+	_, ok, err := db.ImageConfig(canonicalName, tag)
+	if err != nil {
+		return err
+	}
+	if ok {
+		config, err := registry.ImageConfig(tagRef, options...)
+		if err != nil {
+			return err
+		}
+		if err := db.SetImageConfig(canonicalName, tag, config); err != nil {
+			return err
+		}
+	}
+	// So is the provenance.
+	_, ok, err = db.Provenance(canonicalName, tag)
+	if err != nil {
+		return err
+	}
+	if ok {
+		builders, err := registry.ProvenanceBuilders(ctx, tagRef, auth, tr)
+		if err != nil {
+			return err
+		}
+		if err := db.SetProvenance(canonicalName, tag, builders); err != nil {
+			return err
+		}
+	}
+	// The descriptor is recorded last, so that the rest is fetched again
+	// if any of it failed.
+	return db.SetDescriptor(canonicalName, tag, *desc)
+}
+
+// filterTags returns the tags which match at least one of the regexes in
+// the inclusion list, if it is not empty, and none of the regexes in the
+// exclusion list.
+func filterTags(tags, inclusionList, exclusionList []string) ([]string, error) {
+	compile := func(regexes []string) ([]*regexp.Regexp, error) {
+		compiled := make([]*regexp.Regexp, len(regexes))
+		for i, regex := range regexes {
+			r, err := regexp.Compile(regex)
+			if err != nil {
+				return nil, fmt.Errorf("failed to compile regex %s: %w", regex, err)
+			}
+			compiled[i] = r
+		}
+		return compiled, nil
+	}
+	matchesAny := func(regexes []*regexp.Regexp, tag string) bool {
+		for _, r := range regexes {
+			if r.MatchString(tag) {
+				return true
+			}
+		}
+		return false
+	}
+
+	inclusions, err := compile(inclusionList)
+	if err != nil {
+		return nil, err
+	}
+	exclusions, err := compile(exclusionList)
+	if err != nil {
+		return nil, err
+	}
+
+	filteredTags := []string{}
+	for _, tag := range tags {
+		if len(inclusions) > 0 && !matchesAny(inclusions, tag) {
+			continue
+		}
+		if matchesAny(exclusions, tag) {
+			continue
+		}
+		filteredTags = append(filteredTags, tag)
+	}
+	return filteredTags, nil
+}
+
+// limitTags returns up to the limit given of the tags, in the order they
+// are given, keeping those the image policies using the image repository
+// would select: the tags first in each of the orderings of the policies
+// are kept, taking one from each in turn. The rest are filled with the
+// most recent of the other tags; tags that are semantic versions are
+// more recent than those that are not, and by version otherwise, and the
+// others are ordered alphabetically. A limit of zero means no limit.
+func limitTags(tags []string, limit int, orderings [][]string) []string {
+	if limit <= 0 || len(tags) <= limit {
+		return tags
+	}
+
+	kept := make(map[string]struct{}, limit)
+	for rank := 0; len(kept) < limit; rank++ {
+		more := false
+		for _, ordering := range orderings {
+			if rank < len(ordering) && len(kept) < limit {
+				kept[ordering[rank]] = struct{}{}
+				more = true
+			}
+		}
+		if !more {
+			break
+		}
+	}
+
+	versions := make(map[string]*semver.Version, len(tags))
+	for _, tag := range tags {
+		if v, err := version.ParseVersion(tag); err == nil {
+			versions[tag] = v
+		}
+	}
+	sorted := make([]string, len(tags))
+	copy(sorted, tags)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		vi, vj := versions[sorted[i]], versions[sorted[j]]
+		switch {
+		case vi != nil && vj != nil:
+			if !vi.Equal(vj) {
+				return vi.GreaterThan(vj)
+			}
+		case vi != nil:
+			return true
+		case vj != nil:
+			return false
+		}
+		return sorted[i] > sorted[j]
+	})
+	for _, tag := range sorted {
+		if len(kept) >= limit {
+			break
+		}
+		kept[tag] = struct{}{}
+	}
+
+	limited := make([]string, 0, limit)
+	for _, tag := range tags {
+		if _, ok := kept[tag]; ok {
+			limited = append(limited, tag)
+		}
+	}
+	return limited
+}
+
+// firstSeen returns when each of the tags was first seen: the scan time
+// for each new tag, and the time recorded for the others. Tags no longer
+// present are left out. On the first scan, when nothing has been
+// recorded, the tags are given the zero time, since they may have been
+// present for any length of time. It also reports whether this differs
+// from what is recorded.
+func (r *ImageRepositoryReconciler) firstSeen(canonicalName string, tags []string, scanTime time.Time) (map[string]time.Time, bool, error) {
+	previous, found, err := r.Database.FirstSeen(canonicalName)
+	if err != nil {
+		return nil, false, err
+	}
+	seen := scanTime
+	if !found {
+		seen = time.Time{}
+	}
+
+	firstSeen := make(map[string]time.Time, len(tags))
+	changed := len(previous) != len(tags)
+	for _, tag := range tags {
+		if t, ok := previous[tag]; ok {
+			firstSeen[tag] = t
+		} else {
+			firstSeen[tag] = seen
+			changed = true
+		}
+	}
+	return firstSeen, changed, nil
+}
+
+// lastSeen returns when each of the tags removed from the image repository
+// was last seen: the time of the previous scan for the tags removed by
+// this scan, and the time recorded for the others. Tags added again are
+// left out, and only the lastSeenCount most recently seen are kept. It
+// also reports whether this differs from what is recorded.
+func (r *ImageRepositoryReconciler) lastSeen(canonicalName string, added, removed []string, previousScan time.Time) (map[string]time.Time, bool, error) {
+	lastSeen, _, err := r.Database.LastSeen(canonicalName)
+	if err != nil {
+		return nil, false, err
+	}
+	changed := false
+	for _, tag := range added {
+		if _, ok := lastSeen[tag]; ok {
+			delete(lastSeen, tag)
+			changed = true
+		}
+	}
+	for _, tag := range removed {
+		lastSeen[tag] = previousScan
+		changed = true
+	}
+	if len(lastSeen) > lastSeenCount {
+		for _, t := range sortedRemovedTags(lastSeen)[lastSeenCount:] {
+			delete(lastSeen, t.Tag)
+		}
+	}
+	return lastSeen, changed, nil
+}
+
+// removedTags returns up to latestTagsCount of the tags last seen at the
+// times given, the most recently seen first.
+func removedTags(lastSeen map[string]time.Time) []imagev1.RemovedTag {
+	tags := sortedRemovedTags(lastSeen)
+	if len(tags) > latestTagsCount {
+		tags = tags[:latestTagsCount]
+	}
+	return tags
+}
+
+// sortedRemovedTags returns the tags last seen at the times given, the
+// most recently seen first, and in descending order of tag otherwise.
+func sortedRemovedTags(lastSeen map[string]time.Time) []imagev1.RemovedTag {
+	tags := make([]imagev1.RemovedTag, 0, len(lastSeen))
+	for tag, seen := range lastSeen {
+		tags = append(tags, imagev1.RemovedTag{Tag: tag, LastSeen: metav1.NewTime(seen)})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if !tags[i].LastSeen.Equal(&tags[j].LastSeen) {
+			return tags[j].LastSeen.Before(&tags[i].LastSeen)
+		}
+		return tags[i].Tag > tags[j].Tag
+	})
+	return tags
+}
+
+// tagsDelta returns the tags added to and removed from the previous
+// tags, in the order of the tags given.
+func tagsDelta(previous, tags []string) (added, removed []string) {
+	previousSet := make(map[string]struct{}, len(previous))
+	for _, tag := range previous {
+		previousSet[tag] = struct{}{}
+	}
+	tagSet := make(map[string]struct{}, len(tags))
+	for _, tag := range tags {
+		tagSet[tag] = struct{}{}
+		if _, ok := previousSet[tag]; !ok {
+			added = append(added, tag)
+		}
+	}
+	for _, tag := range previous {
+		if _, ok := tagSet[tag]; !ok {
+			removed = append(removed, tag)
+		}
+	}
+	return added, removed
+}
+
+// deleteImageRecords deletes what is recorded in the database for the
+// canonical image name, unless another image repository, not itself
+// under deletion, scans the same image.
+func (r *ImageRepositoryReconciler) deleteImageRecords(ctx context.Context, canonicalName string) error {
+	if canonicalName == "" {
+		return nil
+	}
+
+	var repos imagev1.ImageRepositoryList
+	if err := r.List(ctx, &repos); err != nil {
+		return err
+	}
+	for _, repo := range repos.Items {
+		if repo.DeletionTimestamp.IsZero() && repo.Status.CanonicalImageName == canonicalName {
+			return nil
+		}
+	}
+	var clusterRepos imagev1.ClusterImageRepositoryList
+	if err := r.List(ctx, &clusterRepos); err != nil {
+		return err
+	}
+	for _, repo := range clusterRepos.Items {
+		if repo.DeletionTimestamp.IsZero() && repo.Status.CanonicalImageName == canonicalName {
+			return nil
+		}
+	}
+
+	return r.Database.DeleteRepository(canonicalName)
+}
+
+// accessAndListTags works out how to connect to the registry, and
+// lists the tags of the image repository, returning the authenticator
+// and transport used with the tags. With `.spec.secretRefs`, the
+// credentials of each secret are tried in order, until the registry
+// accepts those of one, which is recorded in the status.
+func (r *ImageRepositoryReconciler) accessAndListTags(ctx context.Context, imageRepo *imagev1.ImageRepository, ref name.Reference) (authn.Authenticator, http.RoundTripper, []string, map[string]v1.Hash, error) {
+	if imageRepo.Spec.SecretRef != nil || len(imageRepo.Spec.SecretRefs) == 0 {
+		imageRepo.Status.SecretRef = nil
+		auth, tr, tags, digests, accessErr, err := r.tryListTags(ctx, imageRepo, ref)
+		if accessErr != nil {
+			imagev1.SetImageRepositoryReadiness(
+				imageRepo,
+				metav1.ConditionFalse,
+				scanFailureReason(accessErr),
+				accessErr.Error(),
+			)
+			return nil, nil, nil, nil, accessErr
+		}
+		return auth, tr, tags, digests, err
+	}
+
+	used := imageRepo.Status.SecretRef
+	var errs []string
+	for _, secretRef := range imageRepo.Spec.SecretRefs {
+		secretRef := secretRef
+		imageRepo.Status.SecretRef = &secretRef
+		auth, tr, tags, digests, accessErr, err := r.tryListTags(ctx, imageRepo, ref)
+		if accessErr == nil {
+			if err == nil || errors.Is(err, registry.ErrNotModified) {
+				return auth, tr, tags, digests, err
+			}
+			// Only a refusal of the credentials has the next ones tried.
+			if !isAuthError(err) {
+				imageRepo.Status.SecretRef = used
+				return nil, nil, nil, nil, err
+			}
+		} else {
+			err = accessErr
+		}
+		errs = append(errs, fmt.Sprintf("secret '%s': %s", secretRef.Name, err))
+	}
+
+	imageRepo.Status.SecretRef = used
+	err := fmt.Errorf("the registry accepted the credentials of none of the secrets of .spec.secretRefs: %s", strings.Join(errs, "; "))
+	imagev1.SetImageRepositoryReadiness(
+		imageRepo,
+		metav1.ConditionFalse,
+		imagev1.AuthenticationFailedReason,
+		err.Error(),
+	)
+	return nil, nil, nil, nil, err
+}
+
+// tryListTags works out how to connect to the registry, and lists the
+// tags of the image repository. It returns any error working out how to
+// connect separately from any error listing the tags. If the registry
+// refuses the credentials of a cached login, e.g., because they were
+// revoked or expired early, they are dropped from the cache, and the
+// tags listed once more with credentials got afresh.
+func (r *ImageRepositoryReconciler) tryListTags(ctx context.Context, imageRepo *imagev1.ImageRepository, ref name.Reference) (authn.Authenticator, http.RoundTripper, []string, map[string]v1.Hash, error, error) {
+	auth, tr, err := remoteAccess(ctx, r.Client, imageRepo, ref, r.ProviderOptions)
+	if err != nil {
+		return nil, nil, nil, nil, err, nil
+	}
+	tags, digests, err := r.listTags(ctx, imageRepo, ref, auth, tr)
+	if isAuthError(err) && loginManager.Forget(auth) {
+		ctrl.LoggerFrom(ctx).Info("registry refused cached credentials; logging in again", "error", err.Error())
+		if auth, tr, err = remoteAccess(ctx, r.Client, imageRepo, ref, r.ProviderOptions); err != nil {
+			return nil, nil, nil, nil, err, nil
+		}
+		tags, digests, err = r.listTags(ctx, imageRepo, ref, auth, tr)
+	}
+	return auth, tr, tags, digests, nil, err
+}
+
+// isAuthError reports whether the error is the registry refusing the
+// credentials given.
+func isAuthError(err error) bool {
+	var terr *transport.Error
+	return errors.As(err, &terr) && (terr.StatusCode == http.StatusUnauthorized || terr.StatusCode == http.StatusForbidden)
+}
+
+// scanFailureReason returns the reason for the ready condition of an
+// image repository whose scan failed with the error given: the registry
+// refusing the credentials, the certificate of the registry not being
+// verified, or the registry not being reached or failing to respond, or
+// else ReconciliationFailed.
+func scanFailureReason(err error) string {
+	var (
+		terr             *transport.Error
+		unknownAuthority x509.UnknownAuthorityError
+		certInvalid      x509.CertificateInvalidError
+		hostname         x509.HostnameError
+		recordHeader     tls.RecordHeaderError
+		netErr           net.Error
+	)
+	switch {
+	case isAuthError(err):
+		return imagev1.AuthenticationFailedReason
+	case errors.As(err, &terr) && hasDiagnostic(terr, transport.UnauthorizedErrorCode, transport.DeniedErrorCode):
+		return imagev1.AuthenticationFailedReason
+	case errors.As(err, &unknownAuthority), errors.As(err, &certInvalid), errors.As(err, &hostname), errors.As(err, &recordHeader):
+		return imagev1.TLSVerificationFailedReason
+	// The errors of the HTTP client are network errors too, and so are
+	// only taken as such once those of TLS have been ruled out.
+	case isThrottledError(err), errors.As(err, &netErr), errors.Is(err, context.DeadlineExceeded):
+		return imagev1.RegistryUnavailableReason
+	}
+	return imagev1.ReconciliationFailedReason
+}
+
+// hasDiagnostic reports whether the registry gave any of the error codes
+// given in the error.
+func hasDiagnostic(terr *transport.Error, codes ...transport.ErrorCode) bool {
+	for _, d := range terr.Errors {
+		for _, code := range codes {
+			if d.Code == code {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isNotFoundError reports whether the error is the registry not having
+// the image repository: `404 Not Found`, or the NAME_UNKNOWN error code.
+func isNotFoundError(err error) bool {
+	var terr *transport.Error
+	if !errors.As(err, &terr) {
+		return false
+	}
+	return terr.StatusCode == http.StatusNotFound || hasDiagnostic(terr, transport.NameUnknownErrorCode)
+}
+
+// isThrottledError reports whether the error is the registry throttling
+// requests: `429 Too Many Requests`, or a server error, as an
+// overloaded registry gives.
+func isThrottledError(err error) bool {
+	var terr *transport.Error
+	return errors.As(err, &terr) && (terr.StatusCode == http.StatusTooManyRequests || terr.StatusCode >= http.StatusInternalServerError)
+}
+
+// listTags fetches the tags of the image repository page by page. If a
+// previous scan did not complete, the listing resumes from the cursor it
+// left in the status, rather than starting again. If this listing does not
+// complete, the tags fetched so far are recorded in the database and the
+// cursor in the status, for the next scan to resume from.
+//
+// With the Harbor artifacts API, the digests of the images the tags
+// listed refer to are returned too; otherwise the digests are nil.
+func (r *ImageRepositoryReconciler) listTags(ctx context.Context, imageRepo *imagev1.ImageRepository, ref name.Reference, auth authn.Authenticator, tr http.RoundTripper) ([]string, map[string]v1.Hash, error) {
+	canonicalName := ref.Context().String()
+
+	var lister tagLister
+	var err error
+	// Only the tags listed by the registry API can be listed with a
+	// conditional request.
+	var conditional *registry.TagLister
+	if p := imageRepo.Spec.Provider; p != nil && p.Harbor != nil && p.Harbor.ArtifactsAPI {
+		lister, err = harbor.NewArtifactLister(ref.Context(), auth, tr)
+	} else {
+		conditional, err = registry.NewTagLister(ctx, ref.Context(), auth, tr)
+		lister = conditional
+	}
+	if err != nil {
+		imagev1.SetImageRepositoryReadiness(
+			imageRepo,
+			metav1.ConditionFalse,
+			scanFailureReason(err),
+			err.Error(),
+		)
+		return nil, nil, err
+	}
+
+	cursor := lister.FirstPage()
+	tags := []string{}
+	if c := imageRepo.Status.ScanCursor; c != "" && lister.ValidCursor(c) {
+		partial, err := r.Database.PartialTags(canonicalName)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get partial tags for %q: %w", canonicalName, err)
+		}
+		// if the partial tags have been lost, e.g., because the database
+		// was dropped, the listing has to start again.
+		if len(partial) > 0 {
+			cursor, tags = c, partial
+		}
+	}
+	fromStart := cursor == lister.FirstPage()
+	// Between full listings, the tags are listed from the highest of
+	// those recorded, and added to them.
+	incremental := false
+	if conditional != nil && fromStart && listIncrementally(imageRepo, time.Now()) {
+		recorded, err := r.Database.Tags(canonicalName)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get tags for %q: %w", canonicalName, err)
+		}
+		if len(recorded) > 0 {
+			cursor, tags, incremental = conditional.PageAfter(highestTag(recorded)), append(tags, recorded...), true
+		}
+	}
+	filter := tagFilterKey(imageRepo, r.DefaultTagLimit)
+	if conditional != nil && cursor == lister.FirstPage() {
+		v, ok, err := r.tagListValidators(canonicalName, filter)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get tag list validators for %q: %w", canonicalName, err)
+		}
+		if ok {
+			conditional.IfChanged(v)
+		}
+	}
+
+	for cursor != "" {
+		page, next, err := lister.Page(ctx, cursor)
+		if errors.Is(err, registry.ErrNotModified) {
+			imageRepo.Status.ScanCursor, imageRepo.Status.PartialTagCount = "", 0
+			return nil, nil, err
+		}
+		if err != nil {
+			imageRepo.Status.ScanCursor, imageRepo.Status.PartialTagCount = "", 0
+			if len(tags) > 0 {
+				if err := r.Database.SetPartialTags(canonicalName, tags); err != nil {
+					return nil, nil, fmt.Errorf("failed to set partial tags for %q: %w", canonicalName, err)
+				}
+				imageRepo.Status.ScanCursor, imageRepo.Status.PartialTagCount = cursor, len(tags)
+				err = fmt.Errorf("scan incomplete after %d tags, will resume on next scan: %w", len(tags), err)
+			}
+			imagev1.SetImageRepositoryReadiness(
+				imageRepo,
+				metav1.ConditionFalse,
+				scanFailureReason(err),
+				err.Error(),
+			)
+			return nil, nil, err
+		}
+		tags = append(tags, page...)
+		cursor = next
+	}
+
+	// The partial tags are removed with the result of the scan.
+	imageRepo.Status.ScanCursor, imageRepo.Status.PartialTagCount = "", 0
+	if incremental {
+		// A registry ignoring the `last` parameter lists the tags
+		// recorded again.
+		return uniqueTags(tags), nil, nil
+	}
+	if conditional != nil {
+		if err := r.recordTagListValidators(canonicalName, filter, conditional.Validators()); err != nil {
+			return nil, nil, fmt.Errorf("failed to record tag list validators for %q: %w", canonicalName, err)
+		}
+	}
+	switch {
+	case imageRepo.Spec.FullListInterval == nil:
+		imageRepo.Status.LastFullListTime = nil
+	case fromStart:
+		now := metav1.Now()
+		imageRepo.Status.LastFullListTime = &now
+	}
+	if l, ok := lister.(*harbor.ArtifactLister); ok {
+		return tags, l.Digests(), nil
+	}
+	return tags, nil, nil
+}
+
+// tagLister lists the tags of an image repository one page at a time,
+// as registry.TagLister and harbor.ArtifactLister do.
+type tagLister interface {
+	FirstPage() string
+	ValidCursor(cursor string) bool
+	Page(ctx context.Context, cursor string) ([]string, string, error)
+}
+
+// listIncrementally reports whether the tags of the image repository are
+// to be listed from the highest of those recorded, since
+// `.spec.fullListInterval` is given and has not passed since the tags
+// were last listed in full.
+func listIncrementally(imageRepo *imagev1.ImageRepository, now time.Time) bool {
+	interval, last := imageRepo.Spec.FullListInterval, imageRepo.Status.LastFullListTime
+	if interval == nil || last == nil {
+		return false
+	}
+	return now.Before(last.Add(interval.Duration))
+}
+
+// highestTag returns the highest of the tags given, in lexical order.
+func highestTag(tags []string) string {
+	var highest string
+	for _, t := range tags {
+		if t > highest {
+			highest = t
+		}
+	}
+	return highest
+}
+
+// uniqueTags returns the tags given without those repeated, in the order
+// first given.
+func uniqueTags(tags []string) []string {
+	seen := make(map[string]struct{}, len(tags))
+	unique := tags[:0]
+	for _, t := range tags {
+		if _, ok := seen[t]; !ok {
+			seen[t] = struct{}{}
+			unique = append(unique, t)
+		}
+	}
+	return unique
+}
+
+// tagListValidators are recorded for the listing of the tags of an image
+// repository, with the filter of the tags recorded from it, since the
+// listing being unchanged says nothing of the tags filtered otherwise.
+type tagListValidators struct {
+	registry.Validators
+	Filter string `json:"filter,omitempty"`
+}
+
+// tagFilterKey returns a key for the filter of the tags recorded for the
+// image repository: its inclusion and exclusion lists and its tag limit.
+func tagFilterKey(imageRepo *imagev1.ImageRepository, defaultTagLimit int) string {
+	limit := defaultTagLimit
+	if imageRepo.Spec.TagLimit != nil {
+		limit = *imageRepo.Spec.TagLimit
+	}
+	return fmt.Sprintf("%q %q %d", imageRepo.Spec.InclusionList, imageRepo.Spec.ExclusionList, limit)
+}
+
+// tagListValidators returns the validators recorded for the listing of
+// the tags of the image repository, if they can be used to list the tags
+// with a conditional request: they were recorded with the same filter,
+// and the tags filtered from the listing are still recorded.
+func (r *ImageRepositoryReconciler) tagListValidators(repo, filter string) (registry.Validators, bool, error) {
+	data, ok, err := r.Database.TagListValidators(repo)
+	if err != nil || !ok {
+		return registry.Validators{}, false, err
+	}
+	var v tagListValidators
+	if err := json.Unmarshal(data, &v); err != nil || v.Filter != filter || v.IsZero() {
+		return registry.Validators{}, false, nil
+	}
+	// The tags may have been lost, e.g., because the database was
+	// dropped, in which case they have to be listed again.
+	tags, err := r.Database.Tags(repo)
+	if err != nil || len(tags) == 0 {
+		return registry.Validators{}, false, err
+	}
+	return v.Validators, true, nil
+}
+
+// recordTagListValidators records the validators given with the listing
+// of the tags of the image repository, if changed, or removes those
+// recorded if none were given.
+func (r *ImageRepositoryReconciler) recordTagListValidators(repo, filter string, v registry.Validators) error {
+	var data []byte
+	if !v.IsZero() {
+		var err error
+		if data, err = json.Marshal(tagListValidators{Validators: v, Filter: filter}); err != nil {
+			return err
+		}
+	}
+	recorded, ok, err := r.Database.TagListValidators(repo)
+	if err != nil {
+		return err
+	}
+	if (!ok && data == nil) || (ok && bytes.Equal(recorded, data)) {
+		return nil
+	}
+	return r.Database.SetTagListValidators(repo, data)
+}
+
+// latestTags returns up to latestTagsCount of the given tags, sorted
+// in descending order. The tags given are not modified.
+func latestTags(tags []string) []string {
+	sorted := make([]string, len(tags))
+	copy(sorted, tags)
+	sort.Sort(sort.Reverse(sort.StringSlice(sorted)))
+	if len(sorted) > latestTagsCount {
+		sorted = sorted[:latestTagsCount]
+	}
+	return sorted
+}
+
+func transportFromSecret(certSecret *corev1.Secret) (*http.Transport, error) {
+	// It's possible the secret doesn't contain any certs after
+	// all and the default transport could be used; but it's
+	// simpler here to assume a fresh transport is needed.
+	transport := &http.Transport{
+		TLSClientConfig: &tls.Config{},
+	}
+	tlsConfig := transport.TLSClientConfig
+
+	if clientCert, ok := certSecret.Data[ClientCert]; ok {
+		// parse and set client cert and secret
+		if clientKey, ok := certSecret.Data[ClientKey]; ok {
+			cert, err := tls.X509KeyPair(clientCert, clientKey)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.Certificates = append(tlsConfig.Certificates, cert)
+		} else {
+			return nil, fmt.Errorf("client certificate found, but no key")
+		}
+	}
+	if caCert, ok := certSecret.Data[CACert]; ok {
+		syscerts, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, err
+		}
+		syscerts.AppendCertsFromPEM(caCert)
+		tlsConfig.RootCAs = syscerts
+	}
+
+	return transport, nil
+}
+
+// proxyURLFromSecret returns the URL of the proxy given in the secret,
+// including any credentials. If the secret doesn't have the address of
+// the proxy, or the address is not a valid URL, it returns an error.
+func proxyURLFromSecret(proxySecret *corev1.Secret) (*url.URL, error) {
+	address, ok := proxySecret.Data[ProxyAddress]
+	if !ok {
+		return nil, fmt.Errorf("invalid proxy secret '%s': key '%s' is missing", proxySecret.Name, ProxyAddress)
+	}
+	proxyURL, err := url.Parse(string(address))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse proxy address '%s': %w", address, err)
+	}
+	if proxyURL.Scheme == "" || proxyURL.Host == "" {
+		return nil, fmt.Errorf("invalid proxy address '%s': must be a URL with a scheme and host", address)
+	}
+
+	username, hasUsername := proxySecret.Data[ProxyUsername]
+	password, hasPassword := proxySecret.Data[ProxyPassword]
+	switch {
+	case hasUsername && hasPassword:
+		proxyURL.User = url.UserPassword(string(username), string(password))
+	case hasUsername || hasPassword:
+		return nil, fmt.Errorf("invalid proxy secret '%s': both '%s' and '%s' must be set, or neither",
+			proxySecret.Name, ProxyUsername, ProxyPassword)
+	}
+	return proxyURL, nil
+}
+
+// shouldScan takes an image repo and the time now, and says whether
+// the repository should be scanned now, and how long to wait for the
+// next scan. A scan that is due waits for the scan windows of the
+// repository to allow it, unless a reconciliation was requested.
+func (r *ImageRepositoryReconciler) shouldScan(repo imagev1.ImageRepository, now time.Time) (bool, time.Duration, error) {
+	ok, when, err := r.scanDue(repo, now)
+	if err != nil || !ok || reconcileRequested(repo) {
+		return ok, when, err
+	}
+	wait, allowed, err := scanWindowWait(repo.Spec.ScanWindows, now)
+	if err != nil {
+		return false, when, err
+	}
+	if !allowed {
+		return false, wait, nil
+	}
+	return true, when, nil
+}
+
+// scanDue says whether a scan of the image repository is due now, and
+// how long to wait for the next scan.
+func (r *ImageRepositoryReconciler) scanDue(repo imagev1.ImageRepository, now time.Time) (bool, time.Duration, error) {
+	scanInterval := effectiveInterval(repo)
+	sched, err := parseScanSchedule(repo)
+	if err != nil {
+		return false, scanInterval, err
+	}
+	if sched != nil {
+		// Scanned now, the image repository is scanned next at the
+		// next time of the schedule.
+		scanInterval = sched.next(now).Sub(now)
+	}
+	if scanInterval < r.MinScanInterval {
+		scanInterval = r.MinScanInterval
+	}
+
+	// Scans of the images of a registry being backed off from wait
+	// until the backoff ends, however soon they are due.
+	if r.RegistryBackoff != nil && repo.Status.CanonicalImageName != "" {
+		registry := strings.SplitN(repo.Status.CanonicalImageName, "/", 2)[0]
+		if left, throttled := r.RegistryBackoff.Throttled(registry); throttled {
+			return false, left, nil
+		}
+	}
+
+	// A stalled image repository is not scanned again until its spec
+	// changes, or a reconciliation is requested; nor is it requeued.
+	if apimeta.IsStatusConditionTrue(repo.Status.Conditions, meta.StalledCondition) && repo.Status.ObservedGeneration == repo.Generation && !reconcileRequested(repo) {
+		return false, 0, nil
+	}
+
+	// Scans failing in a row are backed off from, unless the spec has
+	// changed or a reconciliation has been requested since.
+	if b := repo.Status.Backoff; b != nil && repo.Status.ObservedGeneration == repo.Generation && !reconcileRequested(repo) {
+		if left := b.LastFailureTime.Add(b.Delay.Duration).Sub(now); left > 0 {
+			return false, left, nil
+		}
+	}
+
+	// never scanned; do it now
+	lastScanResult := repo.Status.LastScanResult
+	if lastScanResult == nil {
+		return true, scanInterval, nil
+	}
+
+	// an earlier scan did not complete; resume it now
+	if repo.Status.ScanCursor != "" {
+		return true, scanInterval, nil
+	}
+
+	lastScanTime := lastScanResult.ScanTime
+
+	// Is the controller seeing this because the reconcileAt
+	// annotation was tweaked?
+	if reconcileRequested(repo) {
+		return true, scanInterval, nil
+	}
+
+	// when recovering, it's possible that the resource has a last
+	// scan time, but there's no records because the database has been
+	// dropped and created again. A Badger database given a backup URL
+	// is restored from the backup instead.
+
+	// FIXME If the repo exists, has been
+	// scanned, and doesn't have any tags, this will mean a scan every
+	// time the resource comes up for reconciliation.
+	tags, err := r.Database.Tags(repo.Status.CanonicalImageName)
+	if err != nil {
+		return false, scanInterval, err
+	}
+	if len(tags) == 0 {
+		return true, scanInterval, nil
+	}
+
+	when := scanInterval - now.Sub(lastScanTime.Time)
+	if sched != nil {
+		when = sched.next(lastScanTime.Time).Sub(now)
+	}
+	// Scans are never closer together than the controller allows.
+	if left := r.MinScanInterval - now.Sub(lastScanTime.Time); left > when {
+		when = left
+	}
+	// While the quota of pulls is nearly exhausted, scans are put off to
+	// spread those remaining over the window.
+	if rateLimit := repo.Status.RateLimit; rateLimit != nil {
+		if backoff := rateLimitBackoff(*rateLimit) - now.Sub(rateLimit.ObservedTime.Time); backoff > when {
+			when = backoff
+		}
+	}
+	if when < time.Second {
+		return true, scanInterval, nil
+	}
+	return false, when, nil
+}
+
+// scanSchedule is the schedule of the scans of an image repository
+// given by `.spec.schedule`.
+type scanSchedule struct {
+	cron *schedule.Cron
+	loc  *time.Location
+}
+
+// next returns the time of the first scan of the schedule after the time
+// given. A time of the schedule within a second of the time given is
+// taken as passed, so that a scan made just early is not made again.
+func (s scanSchedule) next(t time.Time) time.Time {
+	return s.cron.Next(t.Add(time.Second).In(s.loc))
+}
+
+// parseScanSchedule returns the schedule of the scans of the image
+// repository, or nil if it is not given one, in which case it is scanned
+// each interval.
+func parseScanSchedule(repo imagev1.ImageRepository) (*scanSchedule, error) {
+	spec := repo.Spec.Schedule
+	if spec == nil {
+		return nil, nil
+	}
+	c, err := schedule.ParseCron(spec.Cron)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := loadLocation(spec.TimeZone)
+	if err != nil {
+		return nil, err
+	}
+	sched := &scanSchedule{cron: c, loc: loc}
+	if sched.next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("the schedule '%s' has no times within five years", spec.Cron)
+	}
+	return sched, nil
+}
+
+// checkTagFilters returns an error if any of the regexes of the
+// exclusion and inclusion lists of the image repository does not
+// compile.
+func checkTagFilters(repo imagev1.ImageRepository) error {
+	spec := field.NewPath("spec")
+	errs := validateRegexes(spec.Child("exclusionList"), repo.Spec.ExclusionList)
+	errs = append(errs, validateRegexes(spec.Child("inclusionList"), repo.Spec.InclusionList)...)
+	return errs.ToAggregate()
+}
+
+// checkScanTiming returns an error if the schedule or any of the scan
+// windows of the image repository is not valid.
+func checkScanTiming(repo imagev1.ImageRepository) error {
+	if _, err := parseScanSchedule(repo); err != nil {
+		return err
+	}
+	_, _, err := scanWindowWait(repo.Spec.ScanWindows, time.Now())
+	return err
+}
+
+// scanWindowWait reports whether the scan windows given allow a scan at
+// the time given and, if not, how long until they might: the latest end
+// of those denying scans, or else the next start of one allowing them.
+// Without any windows allowing scans, scans are allowed outside those
+// denying them.
+func scanWindowWait(windows []imagev1.ScanWindow, now time.Time) (time.Duration, bool, error) {
+	var denyEnd, allowStart time.Time
+	var allowing, allowed bool
+	for _, sw := range windows {
+		w, err := parseWindow(sw.Schedule, sw.TimeZone, sw.Duration.Duration)
+		if err != nil {
+			return 0, false, err
+		}
+		until, active := w.ActiveUntil(now)
+		if sw.Type == imagev1.ScanWindowDeny {
+			if active && until.After(denyEnd) {
+				denyEnd = until
+			}
+			continue
+		}
+		allowing = true
+		if active {
+			allowed = true
+		} else if start := w.NextStart(now); !start.IsZero() && (allowStart.IsZero() || start.Before(allowStart)) {
+			allowStart = start
+		}
+	}
+	switch {
+	case !denyEnd.IsZero():
+		return denyEnd.Sub(now), false, nil
+	case allowing && !allowed && allowStart.IsZero():
+		return 0, false, nil
+	case allowing && !allowed:
+		return allowStart.Sub(now), false, nil
+	}
+	return 0, true, nil
+}
+
+// parseWindow returns the window starting at the times of the cron
+// expression, in the time zone given, and lasting for the duration.
+func parseWindow(cron, timeZone string, duration time.Duration) (schedule.Window, error) {
+	start, err := schedule.ParseCron(cron)
+	if err != nil {
+		return schedule.Window{}, err
+	}
+	loc, err := loadLocation(timeZone)
+	if err != nil {
+		return schedule.Window{}, err
+	}
+	return schedule.Window{Start: start, Duration: duration, Location: loc}, nil
+}
+
+// loadLocation returns the time zone of the name given, from the IANA
+// time zone database, or UTC if no name is given.
+func loadLocation(timeZone string) (*time.Location, error) {
+	if timeZone == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time zone '%s': %w", timeZone, err)
+	}
+	return loc, nil
+}
+
+// reconcileRequested reports whether a reconciliation of the image
+// repository has been requested with the reconcileAt annotation since
+// the last handled. Despite the name of the annotation, all that matters
+// is that it's different.
+func reconcileRequested(repo imagev1.ImageRepository) bool {
+	syncAt, ok := meta.ReconcileAnnotationValue(repo.GetAnnotations())
+	return ok && syncAt != repo.Status.GetLastHandledReconcileRequest()
+}
+
+func (r *ImageRepositoryReconciler) SetupWithManager(mgr ctrl.Manager, opts ImageRepositoryReconcilerOptions) error {
+	c, err := ctrl.NewControllerManagedBy(mgr).
+		For(&imagev1.ImageRepository{}).
+		Watches(&source.Kind{Type: &imagev1.ImagePolicy{}}, handler.EnqueueRequestsFromMapFunc(imageRepositoriesForPolicy(false))).
+		WithEventFilter(predicate.Or(predicate.GenerationChangedPredicate{}, predicates.ReconcileRequestedPredicate{})).
+		WithOptions(controller.Options{
+			MaxConcurrentReconciles: opts.MaxConcurrentReconciles,
+		}).
+		Build(r)
+	if err != nil {
+		return err
+	}
+	return withPriorityQueue(c, func(req reconcile.Request) int {
+		var repo imagev1.ImageRepository
+		if err := mgr.GetClient().Get(context.Background(), req.NamespacedName, &repo); err != nil {
+			return 0
+		}
+		return repo.Spec.Priority
+	})
+}
+
+// withPriorityQueue has the controller take the requests queued in order
+// of the priority given for each, so that the image repositories with a
+// higher spec.priority are reconciled first when they wait for a worker.
+// The queue of a controller cannot be given in its options in this
+// version of controller-runtime, so the func it is made with, which the
+// controller calls once it starts, is set instead.
+func withPriorityQueue(c controller.Controller, priority func(req reconcile.Request) int) error {
+	makeQueue := func() workqueue.RateLimitingInterface {
+		return priorityqueue.New(workqueue.DefaultControllerRateLimiter(), func(item interface{}) int {
+			if req, ok := item.(reconcile.Request); ok {
+				return priority(req)
+			}
+			return 0
+		})
+	}
+	v := reflect.ValueOf(c)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Struct {
+		if f := v.FieldByName("MakeQueue"); f.IsValid() && f.CanSet() && f.Type() == reflect.TypeOf(makeQueue) {
+			f.Set(reflect.ValueOf(makeQueue))
+			return nil
+		}
+	}
+	return fmt.Errorf("unable to give the controller a priority queue")
+}
+
+// recordPolicies records in the status the image policies using the
+// image repository, or cluster image repository, of the name given,
+// returning whether they changed. The policies are listed by the index
+// of the ImagePolicyReconciler.
+func (r *ImageRepositoryReconciler) recordPolicies(ctx context.Context, imageRepo *imagev1.ImageRepository, name types.NamespacedName) (bool, error) {
+	var policies imagev1.ImagePolicyList
+	if err := r.List(ctx, &policies, client.MatchingFields{imageRepoKey: name.String()}); err != nil {
+		return false, err
+	}
+	refs := policyRefs(policies.Items)
+	if reflect.DeepEqual(refs, imageRepo.Status.Policies) {
+		return false, nil
+	}
+	imageRepo.Status.Policies = refs
+	return true, nil
+}
+
+// policyRefs returns references to the image policies given, sorted by
+// namespace and name, or nil if there are none.
+func policyRefs(policies []imagev1.ImagePolicy) []meta.NamespacedObjectReference {
+	var refs []meta.NamespacedObjectReference
+	for _, pol := range policies {
+		refs = append(refs, meta.NamespacedObjectReference{Name: pol.GetName(), Namespace: pol.GetNamespace()})
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].Namespace != refs[j].Namespace {
+			return refs[i].Namespace < refs[j].Namespace
+		}
+		return refs[i].Name < refs[j].Name
+	})
+	return refs
+}
+
+// imageRepositoriesForPolicy returns a func giving the requests for the
+// image repositories, or if cluster is true the cluster image
+// repositories, that an image policy uses, so that the policies using
+// them are recorded again when it changes.
+func imageRepositoriesForPolicy(cluster bool) handler.MapFunc {
+	return func(obj client.Object) []reconcile.Request {
+		var reqs []reconcile.Request
+		for _, name := range imageRepositoriesOf(obj.(*imagev1.ImagePolicy)) {
+			if (name.Namespace == "") == cluster {
+				reqs = append(reqs, reconcile.Request{NamespacedName: name})
+			}
+		}
+		return reqs
+	}
+}
+
+// authFromSecret creates an Authenticator that can be given to the
+// `remote` funcs, from a Kubernetes secret: a docker config, or the
+// username and password for the registry. If the secret doesn't
+// have the right format or data, it returns an error. A docker config
+// naming a credential helper for the registry, or a credentials store,
+// has the helper of that name in helpersDir run, if it is given.
+func authFromSecret(ctx context.Context, secret corev1.Secret, ref name.Reference, helpersDir string) (authn.Authenticator, error) {
+	var configData []byte
+	switch secret.Type {
+	case "kubernetes.io/dockerconfigjson":
+		configData = secret.Data[".dockerconfigjson"]
+	case "kubernetes.io/basic-auth":
+		if auth, ok := basicAuthFromSecret(secret); ok {
+			return auth, nil
+		}
+		return nil, fmt.Errorf("secret %v of type %q has no username and password",
+			types.NamespacedName{Name: secret.GetName(), Namespace: secret.GetNamespace()}, secret.Type)
+	case "Opaque", "":
+		// A generic secret, e.g., one created by an operator syncing
+		// secrets from elsewhere, may hold a docker config under either
+		// key, or else the username and password for the registry.
+		for _, key := range []string{".dockerconfigjson", "config.json"} {
+			if data, ok := secret.Data[key]; ok {
+				configData = data
+				break
+			}
+		}
+		if configData == nil {
+			if auth, ok := basicAuthFromSecret(secret); ok {
+				return auth, nil
+			}
+			return nil, fmt.Errorf("secret %v of type %q has neither a '.dockerconfigjson' nor a 'config.json' key, nor a username and password",
+				types.NamespacedName{Name: secret.GetName(), Namespace: secret.GetNamespace()}, secret.Type)
+		}
+	default:
+		return nil, fmt.Errorf("unknown secret type %q", secret.Type)
+	}
+
+	auth, ok, err := authFromDockerConfig(ctx, configData, ref, helpersDir)
+	if err != nil {
+		return nil, fmt.Errorf("secret %v: %w", types.NamespacedName{Name: secret.GetName(), Namespace: secret.GetNamespace()}, err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("auth for %q not found in secret %v", ref.Context().RegistryStr(), types.NamespacedName{Name: secret.GetName(), Namespace: secret.GetNamespace()})
+	}
+	return auth, nil
+}
+
+// authFromDockerConfig creates an Authenticator from the entry of the
+// docker config for the registry of the reference, or else the
+// credential helper in helpersDir the config names for the registry. It
+// returns false if the config has neither for the registry.
+func authFromDockerConfig(ctx context.Context, configData []byte, ref name.Reference, helpersDir string) (authn.Authenticator, bool, error) {
+	var dockerconfig dockerConfig
+	if err := json.NewDecoder(bytes.NewBuffer(configData)).Decode(&dockerconfig); err != nil {
+		return nil, false, err
+	}
+
+	authMap, err := parseAuthMap(dockerconfig)
+	if err != nil {
+		return nil, false, err
+	}
+	registry := ref.Context().RegistryStr()
+	auth, ok := authMap[registry]
+	if !ok {
+		helperName, err := credHelperFor(dockerconfig, registry)
+		if err != nil {
+			return nil, false, err
+		}
+		if helperName == "" {
+			return nil, false, nil
+		}
+		helper, err := credhelper.Find(helpersDir, helperName)
+		if err != nil {
+			return nil, false, fmt.Errorf("the docker config names a credential helper for %q: %w", registry, err)
+		}
+		helperAuth, err := helper.Login(ctx, ref.Context())
+		return helperAuth, true, err
+	}
+	// An entry giving an identity token has it exchanged with the
+	// token server of the registry. Any auth it gives besides is
+	// that of a placeholder user without a password, e.g., for ACR,
+	// and is not to be sent to the registry as basic auth.
+	if auth.IdentityToken != "" {
+		auth = authn.AuthConfig{
+			Username:      auth.Username,
+			IdentityToken: auth.IdentityToken,
+		}
+	}
+	return authn.FromConfig(auth), true, nil
+}
+
+// basicAuthFromSecret returns an Authenticator for the `username` and
+// `password` of the secret, and `true`, if it gives both, otherwise
+// `false`.
+func basicAuthFromSecret(secret corev1.Secret) (authn.Authenticator, bool) {
+	username, password := secret.Data["username"], secret.Data["password"]
+	if len(username) == 0 || len(password) == 0 {
+		return nil, false
+	}
+	return authn.FromConfig(authn.AuthConfig{
+		Username: string(username),
+		Password: string(password),
+	}), true
+}
+
+// credHelperFor returns the name of the credential helper the docker
+// config gives for the registry, or of its credentials store, if any.
+func credHelperFor(config dockerConfig, registry string) (string, error) {
+	for url, helperName := range config.CredHelpers {
+		host, err := getURLHost(url)
+		if err != nil {
+			return "", err
+		}
+		if host == registry {
+			return helperName, nil
+		}
+	}
+	return config.CredsStore, nil
+}
+
+// event emits a Kubernetes event and forwards the event to notification controller if configured
+func (r *ImageRepositoryReconciler) event(ctx context.Context, obj runtime.Object, severity, msg string) {
+	r.annotatedEvent(ctx, obj, severity, msg, nil)
+}
+
+// annotatedEvent emits an event for the object as event does, with the
+// metadata given, which is forwarded to notification controller.
+func (r *ImageRepositoryReconciler) annotatedEvent(ctx context.Context, obj runtime.Object, severity, msg string, metadata map[string]string) {
+	eventtype := "Normal"
+	if severity == events.EventSeverityError {
+		eventtype = "Warning"
+	}
+	r.EventRecorder.AnnotatedEventf(obj, metadata, eventtype, severity, msg)
+}
+
+// recordReadinessMetric records the ready condition of the status given,
+// that of the image repository or cluster image repository obj.
+func (r *ImageRepositoryReconciler) recordReadinessMetric(ctx context.Context, obj client.Object, status *imagev1.ImageRepositoryStatus) {
+	if r.MetricsRecorder == nil {
+		return
+	}
+
+	objRef, err := reference.GetReference(r.Scheme, obj)
+	if err != nil {
+		ctrl.LoggerFrom(ctx).Error(err, "unable to record readiness metric")
+		return
+	}
+	if rc := apimeta.FindStatusCondition(status.Conditions, meta.ReadyCondition); rc != nil {
+		r.MetricsRecorder.RecordCondition(*objRef, *rc, !obj.GetDeletionTimestamp().IsZero())
+	} else {
+		r.MetricsRecorder.RecordCondition(*objRef, metav1.Condition{
+			Type:   meta.ReadyCondition,
+			Status: metav1.ConditionUnknown,
+		}, !obj.GetDeletionTimestamp().IsZero())
+	}
+}
+
+func (r *ImageRepositoryReconciler) recordSuspension(ctx context.Context, obj client.Object, suspend bool) {
+	if r.MetricsRecorder == nil {
+		return
+	}
+	log := ctrl.LoggerFrom(ctx)
+
+	objRef, err := reference.GetReference(r.Scheme, obj)
+	if err != nil {
+		log.Error(err, "unable to record suspended metric")
+		return
+	}
+
+	if !obj.GetDeletionTimestamp().IsZero() {
+		r.MetricsRecorder.RecordSuspend(*objRef, false)
+	} else {
+		r.MetricsRecorder.RecordSuspend(*objRef, suspend)
+	}
+}
+
+// patchStatus patches the status of obj, an image repository or a
+// cluster image repository, with that given, against the object as it
+// is now.
+func (r *ImageRepositoryReconciler) patchStatus(ctx context.Context, obj client.Object,
+	newStatus imagev1.ImageRepositoryStatus) error {
+	res := obj.DeepCopyObject().(client.Object)
+	if err := r.Get(ctx, client.ObjectKeyFromObject(obj), res); err != nil {
+		return err
+	}
+
+	patch := client.MergeFrom(res.DeepCopyObject().(client.Object))
+	switch o := res.(type) {
+	case *imagev1.ImageRepository:
+		o.Status = newStatus
+	case *imagev1.ClusterImageRepository:
+		o.Status = newStatus
+	default:
+		return fmt.Errorf("expected an image repository, got %T", obj)
+	}
 
-	return r.Status().Patch(ctx, &res, patch)
+	return r.Status().Patch(ctx, res, patch)
 }
 
 func parseAuthMap(config dockerConfig) (map[string]authn.AuthConfig, error) {
@@ -18,9 +18,20 @@ package controllers
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/http"
 	"testing"
+	"time"
 
 	"github.com/fluxcd/pkg/apis/meta"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
 	. "github.com/onsi/gomega"
 	corev1 "k8s.io/api/core/v1"
 	apimeta "k8s.io/apimachinery/pkg/api/meta"
@@ -31,12 +42,14 @@ import (
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 
 	aclapi "github.com/fluxcd/pkg/apis/acl"
 	"github.com/fluxcd/pkg/runtime/acl"
 
 	imagev1 "github.com/fluxcd/image-reflector-controller/api/v1beta1"
 	"github.com/fluxcd/image-reflector-controller/internal/database"
+	"github.com/fluxcd/image-reflector-controller/internal/policy"
 	"github.com/fluxcd/image-reflector-controller/internal/test"
 	// +kubebuilder:scaffold:imports
 )
@@ -241,6 +254,1198 @@ func TestImagePolicyReconciler_calculateImageFromRepoTags(t *testing.T) {
 	}
 }
 
+func TestImagePolicyReconciler_digestReflection(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     imagev1.ReflectionPolicy
+		wantDigest bool
+	}{
+		{
+			name:       "with Never",
+			policy:     imagev1.ReflectNever,
+			wantDigest: false,
+		},
+		{
+			name:       "with IfNotPresent",
+			policy:     imagev1.ReflectIfNotPresent,
+			wantDigest: true,
+		},
+		{
+			name:       "with Always",
+			policy:     imagev1.ReflectAlways,
+			wantDigest: true,
+		},
+	}
+
+	registryServer := test.NewRegistryServer()
+	defer registryServer.Close()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			versions := []string{"1.0.0", "1.0.1", "1.1.0"}
+			imgRepo, err := test.LoadImages(registryServer, "test-digest-policy-"+randStringRunes(5), versions)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			ref, err := name.NewTag(imgRepo + ":1.1.0")
+			g.Expect(err).ToNot(HaveOccurred())
+			desc, err := remote.Head(ref)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			repo := imagev1.ImageRepository{
+				Spec: imagev1.ImageRepositorySpec{
+					Interval: metav1.Duration{Duration: reconciliationInterval},
+					Image:    imgRepo,
+				},
+			}
+			imageObjectName := types.NamespacedName{
+				Name:      "polimage-" + randStringRunes(5),
+				Namespace: "default",
+			}
+			repo.Name = imageObjectName.Name
+			repo.Namespace = imageObjectName.Namespace
+
+			ctx, cancel := context.WithTimeout(context.Background(), contextTimeout)
+			defer cancel()
+
+			g.Expect(testEnv.Create(ctx, &repo)).To(Succeed())
+
+			g.Eventually(func() bool {
+				err := testEnv.Get(ctx, imageObjectName, &repo)
+				return err == nil && repo.Status.LastScanResult != nil
+			}, timeout, interval).Should(BeTrue())
+
+			polName := types.NamespacedName{
+				Name:      "random-pol-" + randStringRunes(5),
+				Namespace: imageObjectName.Namespace,
+			}
+			pol := imagev1.ImagePolicy{
+				Spec: imagev1.ImagePolicySpec{
+					ImageRepositoryRef: meta.NamespacedObjectReference{
+						Name: imageObjectName.Name,
+					},
+					Policy: imagev1.ImagePolicyChoice{
+						SemVer: &imagev1.SemVerPolicy{
+							Range: "1.x",
+						},
+					},
+					DigestReflectionPolicy: tt.policy,
+				},
+			}
+			pol.Namespace = polName.Namespace
+			pol.Name = polName.Name
+
+			g.Expect(testEnv.Create(ctx, &pol)).To(Succeed())
+
+			g.Eventually(func() bool {
+				err := testEnv.Get(ctx, polName, &pol)
+				return err == nil && pol.Status.LatestImage != ""
+			}, timeout, interval).Should(BeTrue())
+			g.Expect(pol.Status.LatestImage).To(Equal(imgRepo + ":1.1.0"))
+			if tt.wantDigest {
+				g.Expect(pol.Status.LatestDigest).To(Equal(desc.Digest.String()))
+			} else {
+				g.Expect(pol.Status.LatestDigest).To(BeEmpty())
+			}
+
+			g.Expect(testEnv.Delete(ctx, &pol)).To(Succeed())
+		})
+	}
+}
+
+func TestImagePolicyReconciler_preventDowngrade(t *testing.T) {
+	g := NewWithT(t)
+
+	registryServer := test.NewRegistryServer()
+	defer registryServer.Close()
+
+	versions := []string{"1.0.0", "1.0.1", "1.1.0"}
+	imgRepo, err := test.LoadImages(registryServer, "test-downgrade-policy-"+randStringRunes(5), versions)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	repo := imagev1.ImageRepository{
+		Spec: imagev1.ImageRepositorySpec{
+			Interval: metav1.Duration{Duration: reconciliationInterval},
+			Image:    imgRepo,
+		},
+	}
+	imageObjectName := types.NamespacedName{
+		Name:      "polimage-" + randStringRunes(5),
+		Namespace: "default",
+	}
+	repo.Name = imageObjectName.Name
+	repo.Namespace = imageObjectName.Namespace
+
+	ctx, cancel := context.WithTimeout(context.Background(), contextTimeout)
+	defer cancel()
+
+	g.Expect(testEnv.Create(ctx, &repo)).To(Succeed())
+
+	g.Eventually(func() bool {
+		err := testEnv.Get(ctx, imageObjectName, &repo)
+		return err == nil && repo.Status.LastScanResult != nil
+	}, timeout, interval).Should(BeTrue())
+
+	polName := types.NamespacedName{
+		Name:      "random-pol-" + randStringRunes(5),
+		Namespace: imageObjectName.Namespace,
+	}
+	pol := imagev1.ImagePolicy{
+		Spec: imagev1.ImagePolicySpec{
+			ImageRepositoryRef: meta.NamespacedObjectReference{
+				Name: imageObjectName.Name,
+			},
+			Policy: imagev1.ImagePolicyChoice{
+				SemVer: &imagev1.SemVerPolicy{
+					Range: "1.x",
+				},
+			},
+			PreventDowngrade: true,
+		},
+	}
+	pol.Namespace = polName.Namespace
+	pol.Name = polName.Name
+
+	g.Expect(testEnv.Create(ctx, &pol)).To(Succeed())
+
+	g.Eventually(func() bool {
+		err := testEnv.Get(ctx, polName, &pol)
+		return err == nil && pol.Status.LatestImage == imgRepo+":1.1.0"
+	}, timeout, interval).Should(BeTrue())
+
+	// Leaving out the tag selected does not make the policy move to a
+	// lower tag.
+	pol.Spec.FilterTags = &imagev1.TagFilter{Pattern: `^1\.0\.`}
+	g.Expect(testEnv.Update(ctx, &pol)).To(Succeed())
+	g.Eventually(func() bool {
+		err := testEnv.Get(ctx, polName, &pol)
+		return err == nil && pol.Status.ObservedGeneration == pol.Generation
+	}, timeout, interval).Should(BeTrue())
+	g.Expect(pol.Status.LatestImage).To(Equal(imgRepo + ":1.1.0"))
+
+	// Unless the downgrade is allowed.
+	pol.SetAnnotations(map[string]string{imagev1.AllowDowngradeAnnotation: "true"})
+	g.Expect(testEnv.Update(ctx, &pol)).To(Succeed())
+	g.Eventually(func() bool {
+		err := testEnv.Get(ctx, polName, &pol)
+		return err == nil && pol.Status.LatestImage == imgRepo+":1.0.1"
+	}, timeout, interval).Should(BeTrue())
+
+	g.Expect(testEnv.Delete(ctx, &pol)).To(Succeed())
+}
+
+func TestImagePolicyReconciler_dryRun(t *testing.T) {
+	g := NewWithT(t)
+
+	registryServer := test.NewRegistryServer()
+	defer registryServer.Close()
+
+	versions := []string{"1.0.0", "1.0.1", "1.1.0"}
+	imgRepo, err := test.LoadImages(registryServer, "test-dry-run-policy-"+randStringRunes(5), versions)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	repo := imagev1.ImageRepository{
+		Spec: imagev1.ImageRepositorySpec{
+			Interval: metav1.Duration{Duration: reconciliationInterval},
+			Image:    imgRepo,
+		},
+	}
+	imageObjectName := types.NamespacedName{
+		Name:      "polimage-" + randStringRunes(5),
+		Namespace: "default",
+	}
+	repo.Name = imageObjectName.Name
+	repo.Namespace = imageObjectName.Namespace
+
+	ctx, cancel := context.WithTimeout(context.Background(), contextTimeout)
+	defer cancel()
+
+	g.Expect(testEnv.Create(ctx, &repo)).To(Succeed())
+
+	g.Eventually(func() bool {
+		err := testEnv.Get(ctx, imageObjectName, &repo)
+		return err == nil && repo.Status.LastScanResult != nil
+	}, timeout, interval).Should(BeTrue())
+
+	polName := types.NamespacedName{
+		Name:      "random-pol-" + randStringRunes(5),
+		Namespace: imageObjectName.Namespace,
+	}
+	pol := imagev1.ImagePolicy{
+		Spec: imagev1.ImagePolicySpec{
+			ImageRepositoryRef: meta.NamespacedObjectReference{
+				Name: imageObjectName.Name,
+			},
+			Policy: imagev1.ImagePolicyChoice{
+				SemVer: &imagev1.SemVerPolicy{
+					Range: "1.x",
+				},
+			},
+		},
+	}
+	pol.Namespace = polName.Namespace
+	pol.Name = polName.Name
+
+	g.Expect(testEnv.Create(ctx, &pol)).To(Succeed())
+
+	g.Eventually(func() bool {
+		err := testEnv.Get(ctx, polName, &pol)
+		return err == nil && pol.Status.LatestImage == imgRepo+":1.1.0"
+	}, timeout, interval).Should(BeTrue())
+	g.Expect(pol.Status.Candidates).To(Equal([]string{"1.1.0", "1.0.1", "1.0.0"}))
+
+	// A dry run reports the image that would be selected, without
+	// changing the image selected.
+	pol.Spec.DryRun = true
+	pol.Spec.Policy.SemVer.Range = "1.0.x"
+	g.Expect(testEnv.Update(ctx, &pol)).To(Succeed())
+	g.Eventually(func() bool {
+		err := testEnv.Get(ctx, polName, &pol)
+		return err == nil && pol.Status.DryRunImage == imgRepo+":1.0.1"
+	}, timeout, interval).Should(BeTrue())
+	g.Expect(pol.Status.LatestImage).To(Equal(imgRepo + ":1.1.0"))
+
+	// Once the dry run is over, the image is selected.
+	pol.Spec.DryRun = false
+	g.Expect(testEnv.Update(ctx, &pol)).To(Succeed())
+	g.Eventually(func() bool {
+		err := testEnv.Get(ctx, polName, &pol)
+		return err == nil && pol.Status.LatestImage == imgRepo+":1.0.1"
+	}, timeout, interval).Should(BeTrue())
+	g.Expect(pol.Status.DryRunImage).To(BeEmpty())
+
+	g.Expect(testEnv.Delete(ctx, &pol)).To(Succeed())
+}
+
+func TestImagePolicyReconciler_platforms(t *testing.T) {
+	g := NewWithT(t)
+
+	registryServer := test.NewRegistryServer()
+	defer registryServer.Close()
+
+	imgRepo := test.RegistryName(registryServer) + "/test-platform-policy-" + randStringRunes(5)
+	amd64 := v1.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := v1.Platform{OS: "linux", Architecture: "arm64"}
+	// The arm64 build of 1.1.0 has not been pushed.
+	tagPlatforms := map[string][]v1.Platform{
+		"1.0.0": {amd64, arm64},
+		"1.1.0": {amd64},
+	}
+	digests := map[string]string{}
+	for tag, platforms := range tagPlatforms {
+		var idx v1.ImageIndex = empty.Index
+		for _, platform := range platforms {
+			platform := platform
+			img, err := random.Image(512, 1)
+			g.Expect(err).ToNot(HaveOccurred())
+			idx = mutate.AppendManifests(idx, mutate.IndexAddendum{Add: img, Descriptor: v1.Descriptor{Platform: &platform}})
+			digest, err := img.Digest()
+			g.Expect(err).ToNot(HaveOccurred())
+			digests[tag+" "+platform.String()] = digest.String()
+		}
+		ref, err := name.NewTag(imgRepo + ":" + tag)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(remote.WriteIndex(ref, idx)).To(Succeed())
+	}
+
+	repo := imagev1.ImageRepository{
+		Spec: imagev1.ImageRepositorySpec{
+			Interval: metav1.Duration{Duration: reconciliationInterval},
+			Image:    imgRepo,
+		},
+	}
+	imageObjectName := types.NamespacedName{
+		Name:      "polimage-" + randStringRunes(5),
+		Namespace: "default",
+	}
+	repo.Name = imageObjectName.Name
+	repo.Namespace = imageObjectName.Namespace
+
+	ctx, cancel := context.WithTimeout(context.Background(), contextTimeout)
+	defer cancel()
+
+	g.Expect(testEnv.Create(ctx, &repo)).To(Succeed())
+
+	g.Eventually(func() bool {
+		err := testEnv.Get(ctx, imageObjectName, &repo)
+		return err == nil && repo.Status.LastScanResult != nil
+	}, timeout, interval).Should(BeTrue())
+
+	polName := types.NamespacedName{
+		Name:      "random-pol-" + randStringRunes(5),
+		Namespace: imageObjectName.Namespace,
+	}
+	pol := imagev1.ImagePolicy{
+		Spec: imagev1.ImagePolicySpec{
+			ImageRepositoryRef: meta.NamespacedObjectReference{
+				Name: imageObjectName.Name,
+			},
+			Policy: imagev1.ImagePolicyChoice{
+				SemVer: &imagev1.SemVerPolicy{
+					Range: "1.x",
+				},
+			},
+			Platforms: []string{"linux/amd64", "linux/arm64", "linux/s390x"},
+		},
+	}
+	pol.Namespace = polName.Namespace
+	pol.Name = polName.Name
+
+	g.Expect(testEnv.Create(ctx, &pol)).To(Succeed())
+
+	g.Eventually(func() bool {
+		err := testEnv.Get(ctx, polName, &pol)
+		return err == nil && pol.Status.LatestImage != ""
+	}, timeout, interval).Should(BeTrue())
+	g.Expect(pol.Status.LatestImage).To(Equal(imgRepo + ":1.1.0"))
+	g.Expect(pol.Status.LatestPlatformImages).To(Equal([]imagev1.PlatformImage{
+		{
+			Platform: "linux/amd64",
+			Image:    imgRepo + ":1.1.0",
+			Tag:      "1.1.0",
+			Digest:   digests["1.1.0 linux/amd64"],
+		},
+		{
+			Platform: "linux/arm64",
+			Image:    imgRepo + ":1.0.0",
+			Tag:      "1.0.0",
+			Digest:   digests["1.0.0 linux/arm64"],
+		},
+	}))
+	ready := apimeta.FindStatusCondition(pol.Status.Conditions, meta.ReadyCondition)
+	g.Expect(ready.Message).To(ContainSubstring("linux/s390x"))
+
+	g.Expect(testEnv.Delete(ctx, &pol)).To(Succeed())
+}
+
+func TestImagePolicyReconciler_clusterImageRepository(t *testing.T) {
+	g := NewWithT(t)
+
+	registryServer := test.NewRegistryServer()
+	defer registryServer.Close()
+
+	versions := []string{"1.0.0", "1.0.1", "1.1.0"}
+	imgRepo, err := test.LoadImages(registryServer, "test-cluster-repo-policy-"+randStringRunes(5), versions)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	repo := imagev1.ClusterImageRepository{
+		Spec: imagev1.ClusterImageRepositorySpec{
+			ImageRepositorySpec: imagev1.ImageRepositorySpec{
+				Interval: metav1.Duration{Duration: reconciliationInterval},
+				Image:    imgRepo,
+			},
+		},
+	}
+	repo.Name = "polimage-" + randStringRunes(5)
+
+	ctx, cancel := context.WithTimeout(context.Background(), contextTimeout)
+	defer cancel()
+
+	g.Expect(testEnv.Create(ctx, &repo)).To(Succeed())
+
+	g.Eventually(func() bool {
+		err := testEnv.Get(ctx, client.ObjectKeyFromObject(&repo), &repo)
+		return err == nil && repo.Status.LastScanResult != nil
+	}, timeout, interval).Should(BeTrue())
+	g.Expect(repo.Status.LastScanResult.TagCount).To(Equal(len(versions)))
+
+	polName := types.NamespacedName{
+		Name:      "random-pol-" + randStringRunes(5),
+		Namespace: "default",
+	}
+	pol := imagev1.ImagePolicy{
+		Spec: imagev1.ImagePolicySpec{
+			ImageRepositoryRef: meta.NamespacedObjectReference{
+				Name: repo.Name,
+			},
+			ImageRepositoryKind: imagev1.ClusterImageRepositoryKind,
+			Policy: imagev1.ImagePolicyChoice{
+				SemVer: &imagev1.SemVerPolicy{
+					Range: "1.0.x",
+				},
+			},
+		},
+	}
+	pol.Namespace = polName.Namespace
+	pol.Name = polName.Name
+
+	g.Expect(testEnv.Create(ctx, &pol)).To(Succeed())
+
+	g.Eventually(func() bool {
+		err := testEnv.Get(ctx, polName, &pol)
+		return err == nil && pol.Status.LatestImage != ""
+	}, timeout, interval).Should(BeTrue())
+	g.Expect(pol.Status.LatestImage).To(Equal(imgRepo + ":1.0.1"))
+
+	g.Expect(testEnv.Delete(ctx, &pol)).To(Succeed())
+	g.Expect(testEnv.Delete(ctx, &repo)).To(Succeed())
+}
+
+func TestImagePolicyReconciler_fallbackImageRepository(t *testing.T) {
+	g := NewWithT(t)
+
+	registryServer := test.NewRegistryServer()
+	defer registryServer.Close()
+
+	versions := []string{"1.0.0", "1.0.1", "1.1.0"}
+	imgRepo, err := test.LoadImages(registryServer, "test-fallback-policy-"+randStringRunes(5), versions)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	ctx, cancel := context.WithTimeout(context.Background(), contextTimeout)
+	defer cancel()
+
+	// The primary image repository names an image that does not exist,
+	// so its scans fail.
+	primary := imagev1.ImageRepository{
+		Spec: imagev1.ImageRepositorySpec{
+			Interval: metav1.Duration{Duration: reconciliationInterval},
+			Image:    imgRepo + "-missing",
+		},
+	}
+	primary.Namespace = "default"
+	primary.Name = "polimage-" + randStringRunes(5)
+	g.Expect(testEnv.Create(ctx, &primary)).To(Succeed())
+
+	fallback := imagev1.ImageRepository{
+		Spec: imagev1.ImageRepositorySpec{
+			Interval: metav1.Duration{Duration: reconciliationInterval},
+			Image:    imgRepo,
+		},
+	}
+	fallback.Namespace = "default"
+	fallback.Name = "polimage-" + randStringRunes(5)
+	g.Expect(testEnv.Create(ctx, &fallback)).To(Succeed())
+
+	g.Eventually(func() bool {
+		err := testEnv.Get(ctx, client.ObjectKeyFromObject(&fallback), &fallback)
+		return err == nil && fallback.Status.LastScanResult != nil
+	}, timeout, interval).Should(BeTrue())
+
+	polName := types.NamespacedName{
+		Name:      "random-pol-" + randStringRunes(5),
+		Namespace: "default",
+	}
+	pol := imagev1.ImagePolicy{
+		Spec: imagev1.ImagePolicySpec{
+			ImageRepositoryRef: meta.NamespacedObjectReference{
+				Name: primary.Name,
+			},
+			FallbackImageRepositoryRefs: []meta.NamespacedObjectReference{
+				{Name: "polimage-missing-" + randStringRunes(5)},
+				{Name: fallback.Name},
+			},
+			Policy: imagev1.ImagePolicyChoice{
+				SemVer: &imagev1.SemVerPolicy{
+					Range: "1.0.x",
+				},
+			},
+		},
+	}
+	pol.Namespace = polName.Namespace
+	pol.Name = polName.Name
+
+	g.Expect(testEnv.Create(ctx, &pol)).To(Succeed())
+
+	g.Eventually(func() bool {
+		err := testEnv.Get(ctx, polName, &pol)
+		return err == nil && pol.Status.LatestImage != ""
+	}, timeout, interval).Should(BeTrue())
+	g.Expect(pol.Status.LatestImage).To(Equal(imgRepo + ":1.0.1"))
+	g.Expect(pol.Status.ImageRepositoryRef).To(Equal(&meta.NamespacedObjectReference{
+		Name:      fallback.Name,
+		Namespace: fallback.Namespace,
+	}))
+
+	g.Expect(testEnv.Delete(ctx, &pol)).To(Succeed())
+	g.Expect(testEnv.Delete(ctx, &primary)).To(Succeed())
+	g.Expect(testEnv.Delete(ctx, &fallback)).To(Succeed())
+}
+
+func TestImagePolicyReconciler_pin(t *testing.T) {
+	g := NewWithT(t)
+
+	registryServer := test.NewRegistryServer()
+	defer registryServer.Close()
+
+	versions := []string{"1.0.0", "1.0.1", "1.1.0"}
+	imgRepo, err := test.LoadImages(registryServer, "test-pin-policy-"+randStringRunes(5), versions)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	repo := imagev1.ImageRepository{
+		Spec: imagev1.ImageRepositorySpec{
+			Interval: metav1.Duration{Duration: reconciliationInterval},
+			Image:    imgRepo,
+		},
+	}
+	imageObjectName := types.NamespacedName{
+		Name:      "polimage-" + randStringRunes(5),
+		Namespace: "default",
+	}
+	repo.Name = imageObjectName.Name
+	repo.Namespace = imageObjectName.Namespace
+
+	ctx, cancel := context.WithTimeout(context.Background(), contextTimeout)
+	defer cancel()
+
+	g.Expect(testEnv.Create(ctx, &repo)).To(Succeed())
+
+	g.Eventually(func() bool {
+		err := testEnv.Get(ctx, imageObjectName, &repo)
+		return err == nil && repo.Status.LastScanResult != nil
+	}, timeout, interval).Should(BeTrue())
+
+	polName := types.NamespacedName{
+		Name:      "random-pol-" + randStringRunes(5),
+		Namespace: imageObjectName.Namespace,
+	}
+	digest := "sha256:2f1ccd4da91afa3f8d0a8f84e6ce5bc7b4ee95b7202815d23e3bd0913ea1e613"
+	pol := imagev1.ImagePolicy{
+		Spec: imagev1.ImagePolicySpec{
+			ImageRepositoryRef: meta.NamespacedObjectReference{
+				Name: imageObjectName.Name,
+			},
+			Policy: imagev1.ImagePolicyChoice{
+				SemVer: &imagev1.SemVerPolicy{
+					Range: "1.x",
+				},
+			},
+			Pin: &imagev1.ImagePin{
+				Tag:    "1.0.0",
+				Digest: digest,
+			},
+		},
+	}
+	pol.Namespace = polName.Namespace
+	pol.Name = polName.Name
+
+	g.Expect(testEnv.Create(ctx, &pol)).To(Succeed())
+
+	g.Eventually(func() bool {
+		err := testEnv.Get(ctx, polName, &pol)
+		return err == nil && apimeta.IsStatusConditionTrue(pol.Status.Conditions, imagev1.PinnedCondition)
+	}, timeout, interval).Should(BeTrue())
+	g.Expect(pol.Status.LatestImage).To(Equal(imgRepo + ":1.0.0"))
+	g.Expect(pol.Status.LatestDigest).To(Equal(digest))
+	g.Expect(apimeta.IsStatusConditionTrue(pol.Status.Conditions, meta.ReadyCondition)).To(BeTrue())
+
+	// Removing the pin lets the policy select an image again.
+	pol.Spec.Pin = nil
+	g.Expect(testEnv.Update(ctx, &pol)).To(Succeed())
+	g.Eventually(func() bool {
+		err := testEnv.Get(ctx, polName, &pol)
+		return err == nil && pol.Status.LatestImage == imgRepo+":1.1.0"
+	}, timeout, interval).Should(BeTrue())
+	g.Expect(apimeta.FindStatusCondition(pol.Status.Conditions, imagev1.PinnedCondition)).To(BeNil())
+
+	g.Expect(testEnv.Delete(ctx, &pol)).To(Succeed())
+}
+
+func TestRenderLatestImage(t *testing.T) {
+	digest := "sha256:2f1ccd4da91afa3f8d0a8f84e6ce5bc7b4ee95b7202815d23e3bd0913ea1e613"
+	tests := []struct {
+		name     string
+		image    string
+		template string
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:  "default",
+			image: "alpine",
+			want:  "alpine:1.0.0",
+		},
+		{
+			name:     "with digest",
+			image:    "ghcr.io/stefanprodan/podinfo",
+			template: "{{.Registry}}/{{.Repository}}:{{.Tag}}@{{.Digest}}",
+			want:     "ghcr.io/stefanprodan/podinfo:1.0.0@" + digest,
+		},
+		{
+			name:     "with registry rewritten",
+			image:    "alpine",
+			template: "mirror.example.com/{{.Repository}}:{{.Tag}}",
+			want:     "mirror.example.com/library/alpine:1.0.0",
+		},
+		{
+			name:     "with unknown field",
+			image:    "alpine",
+			template: "{{.Image}}:{{.Version}}",
+			wantErr:  true,
+		},
+		{
+			name:     "with invalid template",
+			image:    "alpine",
+			template: "{{.Image}:{{.Tag}}",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			repo := &imagev1.ImageRepository{
+				Spec: imagev1.ImageRepositorySpec{
+					Image: tt.image,
+				},
+			}
+			got, err := renderLatestImage(tt.template, repo, "1.0.0", digest)
+			if tt.wantErr {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(got).To(Equal(tt.want))
+
+			pol := &imagev1.ImagePolicy{}
+			pol.Spec.LatestImageTemplate = tt.template
+			pol.Status.LatestImage = got
+			pol.Status.LatestTag = "1.0.0"
+			pol.Status.LatestDigest = digest
+			current, ok := currentTag(pol, repo)
+			g.Expect(ok).To(BeTrue())
+			g.Expect(current).To(Equal("1.0.0"))
+
+			repo.Spec.Image = "other/image"
+			_, ok = currentTag(pol, repo)
+			g.Expect(ok).To(BeFalse())
+		})
+	}
+}
+
+func TestTemplateUsesDigest(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(templateUsesDigest("")).To(BeFalse())
+	g.Expect(templateUsesDigest("{{.Registry}}/{{.Repository}}:{{.Tag}}")).To(BeFalse())
+	g.Expect(templateUsesDigest("{{.Image}}:{{.Tag}}@{{.Digest}}")).To(BeTrue())
+	g.Expect(templateUsesDigest("{{.Image}}{{with .Digest}}@{{.}}{{end}}")).To(BeTrue())
+	g.Expect(templateUsesDigest(`{{.Image}}:{{if eq .Tag "main"}}{{.Digest}}{{end}}`)).To(BeTrue())
+	g.Expect(templateUsesDigest(`{{.Image}}:Digest`)).To(BeFalse())
+}
+
+func TestImageTag(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy imagev1.ImagePolicyChoice
+		tag    string
+		want   string
+	}{
+		{
+			name:   "semver keeping the prefix",
+			policy: imagev1.ImagePolicyChoice{SemVer: &imagev1.SemVerPolicy{Range: "1.x", VPrefix: imagev1.VPrefixKeep}},
+			tag:    "v1.0.0",
+			want:   "v1.0.0",
+		},
+		{
+			name:   "semver stripping the prefix",
+			policy: imagev1.ImagePolicyChoice{SemVer: &imagev1.SemVerPolicy{Range: "1.x", VPrefix: imagev1.VPrefixStrip}},
+			tag:    "v1.0.0",
+			want:   "1.0.0",
+		},
+		{
+			name:   "alphabetical",
+			policy: imagev1.ImagePolicyChoice{Alphabetical: &imagev1.AlphabeticalPolicy{}},
+			tag:    "v1.0.0",
+			want:   "v1.0.0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			pol := &imagev1.ImagePolicy{Spec: imagev1.ImagePolicySpec{Policy: tt.policy}}
+			g.Expect(imageTag(pol, tt.tag)).To(Equal(tt.want))
+		})
+	}
+}
+
+func TestPlatformCheck(t *testing.T) {
+	g := NewWithT(t)
+
+	// The arm64 image is pushed after the amd64 platforms are recorded.
+	recorded := map[string]string{"linux/amd64": "sha256:amd64"}
+	pushed := map[string]string{"linux/amd64": "sha256:amd64", "linux/arm64/v8": "sha256:arm64"}
+	var fetches int
+	platforms := func(tag string, fetch bool) (map[string]string, error) {
+		if fetch {
+			fetches++
+			recorded = pushed
+		}
+		return recorded, nil
+	}
+
+	ok, err := platformCheck(platforms, "linux/amd64").accept("1.0.0")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ok).To(BeTrue())
+	g.Expect(fetches).To(Equal(0))
+
+	ok, err = platformCheck(platforms, "linux/arm64").accept("1.0.0")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ok).To(BeTrue())
+	g.Expect(fetches).To(Equal(1))
+
+	ok, err = platformCheck(platforms, "linux/s390x").accept("1.0.0")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ok).To(BeFalse())
+	g.Expect(fetches).To(Equal(2))
+
+	_, err = platformCheck(platforms, "linux").accept("1.0.0")
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestImagePolicyReconciler_platformsRefresh(t *testing.T) {
+	g := NewWithT(t)
+
+	registryServer := test.NewRegistryServer()
+	defer registryServer.Close()
+
+	tagRef, err := name.NewTag(test.RegistryName(registryServer) + "/test-platforms-refresh:1.0.0")
+	g.Expect(err).ToNot(HaveOccurred())
+	push := func(arch string) {
+		img, err := random.Image(512, 1)
+		g.Expect(err).ToNot(HaveOccurred())
+		config, err := img.ConfigFile()
+		g.Expect(err).ToNot(HaveOccurred())
+		config.OS, config.Architecture = "linux", arch
+		config.Created = v1.Time{Time: time.Now()}
+		img, err = mutate.ConfigFile(img, config)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(remote.Write(tagRef, img)).To(Succeed())
+	}
+
+	db := database.NewMemoryDatabase()
+	r := &ImagePolicyReconciler{
+		Client:   fakeclient.NewClientBuilder().WithScheme(scheme.Scheme).Build(),
+		Database: db,
+	}
+	repo := &imagev1.ImageRepository{}
+	repo.Spec.Image = tagRef.Context().String()
+	repo.Status.CanonicalImageName = tagRef.Context().String()
+	repo.Status.LastScanResult = &imagev1.ScanResult{ScanTime: metav1.NewTime(time.Now())}
+	platforms := r.platforms(repo, r.registryLookup(context.TODO(), repo))
+	archs := func(refresh bool) []string {
+		p, err := platforms("1.0.0", refresh)
+		g.Expect(err).ToNot(HaveOccurred())
+		var archs []string
+		for platform := range p {
+			archs = append(archs, platform)
+		}
+		return archs
+	}
+
+	push("amd64")
+	g.Expect(archs(false)).To(Equal([]string{"linux/amd64"}))
+
+	// Moved to another image, the tag is refreshed once per scan.
+	push("arm64")
+	g.Expect(archs(false)).To(Equal([]string{"linux/amd64"}))
+	g.Expect(archs(true)).To(Equal([]string{"linux/arm64"}))
+	push("s390x")
+	g.Expect(archs(true)).To(Equal([]string{"linux/arm64"}))
+
+	// After the next scan, it is refreshed again.
+	repo.Status.LastScanResult = &imagev1.ScanResult{ScanTime: metav1.NewTime(time.Now().Add(time.Minute))}
+	g.Expect(archs(true)).To(Equal([]string{"linux/s390x"}))
+
+	// Not if its scans fetch its metadata.
+	repo.Spec.FetchMetadata = true
+	push("amd64")
+	repo.Status.LastScanResult = &imagev1.ScanResult{ScanTime: metav1.NewTime(time.Now().Add(2 * time.Minute))}
+	g.Expect(archs(true)).To(Equal([]string{"linux/s390x"}))
+}
+
+func TestProvenanceCheck(t *testing.T) {
+	g := NewWithT(t)
+
+	db := database.NewMemoryDatabase()
+	r := &ImagePolicyReconciler{Database: db}
+	repo := &imagev1.ImageRepository{}
+	repo.Spec.Image = "example.com/foo/bar"
+	repo.Status.CanonicalImageName = "example.com/foo/bar"
+
+	// The lookup, not fetching anything, finds no attestations.
+	var lookups int
+	lookup := func(string, func(context.Context, name.Tag, authn.Authenticator, http.RoundTripper) error) error {
+		lookups++
+		return nil
+	}
+	check := r.provenanceCheck(repo, lookup, "https://example.com/builder@v1")
+
+	// The builders of 1.0.0 are recorded in the database to start with.
+	g.Expect(db.SetProvenance(repo.Status.CanonicalImageName, "1.0.0", []string{"https://example.com/builder@v1"})).To(Succeed())
+	for i := 0; i < 2; i++ {
+		ok, err := check("1.0.0")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(ok).To(BeTrue())
+		// That 1.0.1 has no attestations is recorded once looked up.
+		ok, err = check("1.0.1")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(ok).To(BeFalse())
+	}
+	g.Expect(lookups).To(Equal(1))
+	builders, found, err := db.Provenance(repo.Status.CanonicalImageName, "1.0.1")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(found).To(BeTrue())
+	g.Expect(builders).To(BeEmpty())
+
+	// A failed lookup is not recorded.
+	failing := func(string, func(context.Context, name.Tag, authn.Authenticator, http.RoundTripper) error) error {
+		return fmt.Errorf("registry unavailable")
+	}
+	_, err = r.provenanceCheck(repo, failing, "https://example.com/builder@v1")("1.0.2")
+	g.Expect(err).To(HaveOccurred())
+	_, found, err = db.Provenance(repo.Status.CanonicalImageName, "1.0.2")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(found).To(BeFalse())
+}
+
+func TestImagePolicyReconciler_skipsFailedLookups(t *testing.T) {
+	g := NewWithT(t)
+
+	db := database.NewMemoryDatabase()
+	r := &ImagePolicyReconciler{Database: db}
+	repo := &imagev1.ImageRepository{}
+	repo.Spec.Image = "example.com/foo/bar"
+	repo.Status.CanonicalImageName = "example.com/foo/bar"
+	g.Expect(db.SetTags(repo.Status.CanonicalImageName, []string{"a", "b", "c"})).To(Succeed())
+	now := time.Now()
+	g.Expect(db.SetCreationTime(repo.Status.CanonicalImageName, "a", now.Add(-time.Hour))).To(Succeed())
+	g.Expect(db.SetCreationTime(repo.Status.CanonicalImageName, "b", now)).To(Succeed())
+
+	// The registry is throttling the lookups.
+	failing := func(string, func(context.Context, name.Tag, authn.Authenticator, http.RoundTripper) error) error {
+		return &lookupError{fmt.Errorf("registry 'example.com' is throttling requests")}
+	}
+	pol := &imagev1.ImagePolicy{}
+	newPolicer := func() policy.Policer {
+		policer, err := policy.NewCreatedAt(policy.CreatedAtOrderAsc)
+		g.Expect(err).ToNot(HaveOccurred())
+		return policer
+	}
+
+	// The tag whose creation time cannot be looked up is skipped.
+	latest, _, _, err := r.latestTag(context.TODO(), pol, repo, newPolicer(), failing, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(latest).To(Equal("b"))
+
+	// As is a tag whose image cannot be checked.
+	checks := tagChecks{{
+		description: "that can be checked",
+		accept: func(tag string) (bool, error) {
+			if tag == "b" {
+				return false, &lookupError{fmt.Errorf("registry 'example.com' is throttling requests")}
+			}
+			return true, nil
+		},
+	}}
+	latest, _, _, err = r.latestTag(context.TODO(), pol, repo, newPolicer(), failing, checks)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(latest).To(Equal("a"))
+
+	// If no tag is selected for the lookups failing, the error says so
+	// and is that of a lookup.
+	g.Expect(db.SetTags(repo.Status.CanonicalImageName, []string{"c"})).To(Succeed())
+	_, _, _, err = r.latestTag(context.TODO(), pol, repo, newPolicer(), failing, nil)
+	g.Expect(err).To(MatchError(ContainSubstring("skipping 1 tags that could not be looked up")))
+	var lerr *lookupError
+	g.Expect(errors.As(err, &lerr)).To(BeTrue())
+}
+
+func TestParseDenylist(t *testing.T) {
+	g := NewWithT(t)
+
+	denied := parseDenylist(map[string]string{
+		"yanked": "1.0.1\n  1.2.0  \n\n# withdrawn for CVE-2022-0001\n1.3.0-rc.1\n",
+		"broken": "2.0.0",
+	})
+	g.Expect(denied).To(Equal(map[string]bool{
+		"1.0.1":      true,
+		"1.2.0":      true,
+		"1.3.0-rc.1": true,
+		"2.0.0":      true,
+	}))
+}
+
+func TestFreezeWindowEnd(t *testing.T) {
+	g := NewWithT(t)
+
+	// 2022-05-06 is a Friday.
+	now := time.Date(2022, 5, 7, 12, 0, 0, 0, time.UTC)
+	weekend := imagev1.FreezeWindow{
+		Schedule: "0 18 * * 5",
+		Duration: metav1.Duration{Duration: 60 * time.Hour},
+	}
+	saturday := imagev1.FreezeWindow{
+		Schedule: "0 0 * * 6",
+		Duration: metav1.Duration{Duration: 72 * time.Hour},
+	}
+
+	_, frozen, err := freezeWindowEnd(nil, now)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(frozen).To(BeFalse())
+
+	end, frozen, err := freezeWindowEnd([]imagev1.FreezeWindow{weekend}, now)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(frozen).To(BeTrue())
+	g.Expect(end).To(BeTemporally("==", time.Date(2022, 5, 9, 6, 0, 0, 0, time.UTC)))
+
+	end, frozen, err = freezeWindowEnd([]imagev1.FreezeWindow{weekend, saturday}, now)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(frozen).To(BeTrue())
+	g.Expect(end).To(BeTemporally("==", time.Date(2022, 5, 10, 0, 0, 0, 0, time.UTC)))
+
+	_, frozen, err = freezeWindowEnd([]imagev1.FreezeWindow{weekend}, now.Add(48*time.Hour))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(frozen).To(BeFalse())
+
+	_, _, err = freezeWindowEnd([]imagev1.FreezeWindow{{Schedule: "0 18 * *"}}, now)
+	g.Expect(err).To(HaveOccurred())
+	_, _, err = freezeWindowEnd([]imagev1.FreezeWindow{{Schedule: "0 18 * * 5", TimeZone: "Nowhere/Special"}}, now)
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestImageRepositoryScannedPredicate(t *testing.T) {
+	scanned := imagev1.ImageRepository{}
+	scanned.Generation = 1
+	scanned.Status.CanonicalImageName = "docker.io/library/alpine"
+	scanned.Status.LastScanResult = &imagev1.ScanResult{
+		TagCount:   3,
+		ScanTime:   metav1.NewTime(time.Date(2022, 5, 10, 12, 0, 0, 0, time.UTC)),
+		LatestTags: []string{"v1.2.0", "v1.1.0", "v1.0.0"},
+	}
+
+	tests := []struct {
+		name      string
+		updateOld func(repo *imagev1.ImageRepository)
+		updateNew func(repo *imagev1.ImageRepository)
+		want      bool
+	}{
+		{
+			name: "scan found a tag",
+			updateNew: func(repo *imagev1.ImageRepository) {
+				repo.Status.LastScanResult.ScanTime = metav1.Now()
+				repo.Status.LastScanResult.TagCount = 4
+				repo.Status.LastScanResult.LatestTags = []string{"v1.3.0", "v1.2.0", "v1.1.0", "v1.0.0"}
+			},
+			want: true,
+		},
+		{
+			name: "scan replaced a tag",
+			updateNew: func(repo *imagev1.ImageRepository) {
+				repo.Status.LastScanResult.ScanTime = metav1.Now()
+				repo.Status.LastScanResult.RemovedTagCount = 1
+				repo.Status.LastScanResult.RemovedTags = []imagev1.RemovedTag{{Tag: "v0.9.0", LastSeen: scanned.Status.LastScanResult.ScanTime}}
+			},
+			want: true,
+		},
+		{
+			name:      "scan found the same tags",
+			updateNew: func(repo *imagev1.ImageRepository) { repo.Status.LastScanResult.ScanTime = metav1.Now() },
+			want:      false,
+		},
+		{
+			name:      "first scan finished",
+			updateOld: func(repo *imagev1.ImageRepository) { repo.Status.LastScanResult = nil },
+			want:      true,
+		},
+		{
+			name:      "spec changed",
+			updateNew: func(repo *imagev1.ImageRepository) { repo.Generation = 2 },
+			want:      true,
+		},
+		{
+			name: "conditions changed",
+			updateNew: func(repo *imagev1.ImageRepository) {
+				repo.Status.Conditions = []metav1.Condition{{Type: meta.ReadyCondition, Status: metav1.ConditionFalse}}
+			},
+			want: false,
+		},
+		{
+			name: "scan failed",
+			updateOld: func(repo *imagev1.ImageRepository) {
+				repo.Status.Conditions = []metav1.Condition{{Type: meta.ReadyCondition, Status: metav1.ConditionTrue}}
+			},
+			updateNew: func(repo *imagev1.ImageRepository) {
+				repo.Status.Conditions = []metav1.Condition{{Type: meta.ReadyCondition, Status: metav1.ConditionFalse, Reason: imagev1.ReconciliationFailedReason}}
+			},
+			want: true,
+		},
+		{
+			name: "became ready",
+			updateNew: func(repo *imagev1.ImageRepository) {
+				repo.Status.Conditions = []metav1.Condition{{Type: meta.ReadyCondition, Status: metav1.ConditionTrue}}
+			},
+			want: true,
+		},
+		{
+			name: "ready message changed",
+			updateOld: func(repo *imagev1.ImageRepository) {
+				repo.Status.Conditions = []metav1.Condition{{Type: meta.ReadyCondition, Status: metav1.ConditionTrue, Message: "successful scan, found 3 tags"}}
+			},
+			updateNew: func(repo *imagev1.ImageRepository) {
+				repo.Status.Conditions = []metav1.Condition{{Type: meta.ReadyCondition, Status: metav1.ConditionTrue, Message: "successful scan, found 3 tags, 1 skipped"}}
+			},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			oldRepo, newRepo := scanned.DeepCopy(), scanned.DeepCopy()
+			if tt.updateOld != nil {
+				tt.updateOld(oldRepo)
+			}
+			if tt.updateNew != nil {
+				tt.updateNew(newRepo)
+			}
+			e := event.UpdateEvent{ObjectOld: oldRepo, ObjectNew: newRepo}
+			g.Expect(imageRepositoryScannedPredicate{}.Update(e)).To(Equal(tt.want))
+		})
+	}
+}
+
+func TestApplyTemplate(t *testing.T) {
+	tmpl := imagev1.ClusterImagePolicySpec{
+		Policy: &imagev1.ImagePolicyChoice{
+			SemVer:   &imagev1.SemVerPolicy{Range: ">=1.0.0"},
+			SoakTime: &metav1.Duration{Duration: time.Hour},
+		},
+		FilterTags:        &imagev1.TagFilter{Pattern: `^v(?P<version>.*)`, Extract: "$version"},
+		RequiredPlatforms: []string{"linux/amd64"},
+	}
+
+	tests := []struct {
+		name string
+		spec imagev1.ImagePolicySpec
+		want imagev1.ImagePolicySpec
+	}{
+		{
+			name: "nothing given",
+			want: imagev1.ImagePolicySpec{
+				Policy:            *tmpl.Policy,
+				FilterTags:        tmpl.FilterTags,
+				RequiredPlatforms: tmpl.RequiredPlatforms,
+			},
+		},
+		{
+			name: "ordering given",
+			spec: imagev1.ImagePolicySpec{
+				Policy: imagev1.ImagePolicyChoice{Alphabetical: &imagev1.AlphabeticalPolicy{Order: "asc"}},
+			},
+			want: imagev1.ImagePolicySpec{
+				Policy: imagev1.ImagePolicyChoice{
+					Alphabetical: &imagev1.AlphabeticalPolicy{Order: "asc"},
+					SoakTime:     tmpl.Policy.SoakTime,
+				},
+				FilterTags:        tmpl.FilterTags,
+				RequiredPlatforms: tmpl.RequiredPlatforms,
+			},
+		},
+		{
+			name: "soak time and filter given",
+			spec: imagev1.ImagePolicySpec{
+				Policy:     imagev1.ImagePolicyChoice{SoakTime: &metav1.Duration{Duration: time.Minute}},
+				FilterTags: &imagev1.TagFilter{Pattern: `^1\.`},
+			},
+			want: imagev1.ImagePolicySpec{
+				Policy: imagev1.ImagePolicyChoice{
+					SemVer:   tmpl.Policy.SemVer,
+					SoakTime: &metav1.Duration{Duration: time.Minute},
+				},
+				FilterTags:        &imagev1.TagFilter{Pattern: `^1\.`},
+				RequiredPlatforms: tmpl.RequiredPlatforms,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			spec := tt.spec
+			applyTemplate(&spec, tmpl)
+			g.Expect(spec).To(Equal(tt.want))
+		})
+	}
+}
+
+func TestRequeueAfter(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval *metav1.Duration
+		after    time.Duration
+		want     time.Duration
+	}{
+		{name: "no interval", after: time.Hour, want: time.Hour},
+		{name: "no interval, no requeue", want: 0},
+		{name: "interval, no requeue", interval: &metav1.Duration{Duration: 5 * time.Minute}, want: 5 * time.Minute},
+		{name: "interval sooner", interval: &metav1.Duration{Duration: 5 * time.Minute}, after: time.Hour, want: 5 * time.Minute},
+		{name: "interval later", interval: &metav1.Duration{Duration: 5 * time.Minute}, after: time.Minute, want: time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			pol := &imagev1.ImagePolicy{Spec: imagev1.ImagePolicySpec{Interval: tt.interval}}
+			g.Expect(requeueAfter(pol, tt.after).RequeueAfter).To(Equal(tt.want))
+		})
+	}
+}
+
+func TestSoakedTags(t *testing.T) {
+	g := NewWithT(t)
+
+	now := time.Date(2022, 5, 10, 12, 0, 0, 0, time.UTC)
+	firstSeen := map[string]time.Time{
+		"1.0.0": {},
+		"1.0.1": now.Add(-48 * time.Hour),
+		"1.1.0": now.Add(-time.Hour),
+		"1.2.0": now.Add(-10 * time.Minute),
+	}
+	tags := []string{"1.0.0", "1.0.1", "1.1.0", "1.2.0", "1.3.0"}
+
+	soaked, remaining := soakedTags(tags, firstSeen, 24*time.Hour, now)
+	g.Expect(soaked).To(Equal([]string{"1.0.0", "1.0.1"}))
+	g.Expect(remaining).To(Equal(23 * time.Hour))
+
+	soaked, remaining = soakedTags(tags, firstSeen, time.Hour, now)
+	g.Expect(soaked).To(Equal([]string{"1.0.0", "1.0.1", "1.1.0"}))
+	g.Expect(remaining).To(Equal(50 * time.Minute))
+
+	soaked, remaining = soakedTags(tags, firstSeen, 0, now)
+	g.Expect(soaked).To(Equal([]string{"1.0.0", "1.0.1", "1.1.0", "1.2.0"}))
+	g.Expect(remaining).To(BeZero())
+}
+
+func TestRecordSelection(t *testing.T) {
+	g := NewWithT(t)
+
+	now := time.Date(2022, 5, 10, 12, 0, 0, 0, time.UTC)
+	var status imagev1.ImagePolicyStatus
+	recordSelection(&status, "example.com/app:1.0.0", "1.0.0", "", now)
+	g.Expect(status.LatestImage).To(Equal("example.com/app:1.0.0"))
+	g.Expect(status.History).To(Equal([]imagev1.ImageSelection{
+		{Image: "example.com/app:1.0.0", Tag: "1.0.0", SelectedTime: metav1.NewTime(now)},
+	}))
+
+	// Selecting the same image again records nothing new, but for a
+	// digest now resolved.
+	recordSelection(&status, "example.com/app:1.0.0", "1.0.0", "sha256:abc", now.Add(time.Hour))
+	g.Expect(status.LatestDigest).To(Equal("sha256:abc"))
+	g.Expect(status.History).To(Equal([]imagev1.ImageSelection{
+		{Image: "example.com/app:1.0.0", Tag: "1.0.0", Digest: "sha256:abc", SelectedTime: metav1.NewTime(now)},
+	}))
+
+	// The most recent come first, up to the maximum.
+	for i := 1; i <= maxSelectionHistory; i++ {
+		tag := fmt.Sprintf("1.0.%d", i)
+		recordSelection(&status, "example.com/app:"+tag, tag, "", now.Add(time.Duration(i)*time.Minute))
+	}
+	g.Expect(status.History).To(HaveLen(maxSelectionHistory))
+	g.Expect(status.History[0].Tag).To(Equal(fmt.Sprintf("1.0.%d", maxSelectionHistory)))
+	g.Expect(status.History[maxSelectionHistory-1].Tag).To(Equal("1.0.1"))
+	g.Expect(status.LatestTag).To(Equal(status.History[0].Tag))
+}
+
 func TestImagePolicyReconciler_filterTags(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -514,3 +1719,48 @@ func TestImagePolicyReconciler_accessImageRepo(t *testing.T) {
 		})
 	}
 }
+
+func TestRecordPolicyProgress(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     metav1.ConditionStatus
+		reason     string
+		wantType   string
+		wantReason string
+	}{
+		{name: "ready", status: metav1.ConditionTrue, reason: imagev1.ReconciliationSucceededReason},
+		{name: "invalid policy", status: metav1.ConditionFalse, reason: imagev1.InvalidPolicyReason, wantType: meta.StalledCondition, wantReason: imagev1.InvalidPolicyReason},
+		{name: "access denied", status: metav1.ConditionFalse, reason: aclapi.AccessDeniedReason, wantType: meta.StalledCondition, wantReason: aclapi.AccessDeniedReason},
+		{name: "dependency not ready", status: metav1.ConditionFalse, reason: imagev1.DependencyNotReadyReason, wantType: meta.ReconcilingCondition, wantReason: meta.ProgressingReason},
+		{name: "failed", status: metav1.ConditionFalse, reason: imagev1.ReconciliationFailedReason, wantType: meta.ReconcilingCondition, wantReason: imagev1.ProgressingWithRetryReason},
+		{name: "suspended", status: metav1.ConditionFalse, reason: meta.SuspendedReason},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			// Start from the other condition, to see it removed.
+			status := imagev1.ImagePolicyStatus{Conditions: []metav1.Condition{
+				{Type: meta.StalledCondition, Status: metav1.ConditionTrue, Reason: imagev1.InvalidPolicyReason},
+				{Type: meta.ReconcilingCondition, Status: metav1.ConditionTrue, Reason: meta.ProgressingReason},
+			}}
+			apimeta.SetStatusCondition(&status.Conditions, metav1.Condition{
+				Type: meta.ReadyCondition, Status: tt.status, Reason: tt.reason, Message: "message", ObservedGeneration: 4,
+			})
+			recordPolicyProgress(&status)
+
+			for _, conditionType := range []string{meta.StalledCondition, meta.ReconcilingCondition} {
+				c := apimeta.FindStatusCondition(status.Conditions, conditionType)
+				if conditionType != tt.wantType {
+					g.Expect(c).To(BeNil())
+					continue
+				}
+				g.Expect(c).ToNot(BeNil())
+				g.Expect(c.Status).To(Equal(metav1.ConditionTrue))
+				g.Expect(c.Reason).To(Equal(tt.wantReason))
+				g.Expect(c.Message).To(Equal("message"))
+				g.Expect(c.ObservedGeneration).To(Equal(int64(4)))
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	imagev1 "github.com/fluxcd/image-reflector-controller/api/v1beta1"
+	"github.com/fluxcd/image-reflector-controller/internal/registry/dockerhub"
+)
+
+// RateLimitRemaining is the metric of the quota of pulls remaining that
+// the registry last reported for each image repository. It is to be
+// registered by the controller binary.
+var RateLimitRemaining = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "gotk_registry_ratelimit_remaining",
+	Help: "The quota of pulls remaining that the registry last reported for the image repository.",
+}, []string{"kind", "name", "namespace"})
+
+// rateLimitStatus returns the status recording the rate limit reported
+// to the scan at the time given.
+func rateLimitStatus(l dockerhub.RateLimit, scanTime metav1.Time) *imagev1.RateLimit {
+	status := &imagev1.RateLimit{
+		Limit:        l.Limit,
+		Remaining:    l.Remaining,
+		ObservedTime: scanTime,
+	}
+	if l.Window > 0 {
+		status.Window = &metav1.Duration{Duration: l.Window}
+	}
+	return status
+}
+
+// rateLimitBackoff returns how long after it was observed to put off
+// scanning, given the rate limit recorded in the status.
+func rateLimitBackoff(status imagev1.RateLimit) time.Duration {
+	l := dockerhub.RateLimit{Limit: status.Limit, Remaining: status.Remaining}
+	if status.Window != nil {
+		l.Window = status.Window.Duration
+	}
+	return l.Backoff()
+}
+
+// recordRateLimitMetric records the quota of pulls remaining given by
+// the status of the image repository, if any, deleting what's recorded
+// for it otherwise or once it is deleted.
+func recordRateLimitMetric(kind string, obj metav1.Object, status *imagev1.RateLimit) {
+	labels := prometheus.Labels{"kind": kind, "name": obj.GetName(), "namespace": obj.GetNamespace()}
+	if status == nil || !obj.GetDeletionTimestamp().IsZero() {
+		RateLimitRemaining.Delete(labels)
+		return
+	}
+	RateLimitRemaining.With(labels).Set(float64(status.Remaining))
+}
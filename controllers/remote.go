@@ -0,0 +1,397 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/authn/k8schain"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/fluxcd/pkg/apis/meta"
+
+	imagev1 "github.com/fluxcd/image-reflector-controller/api/v1beta1"
+	"github.com/fluxcd/image-reflector-controller/internal/registry"
+	"github.com/fluxcd/image-reflector-controller/internal/registry/digitalocean"
+	"github.com/fluxcd/image-reflector-controller/internal/registry/dockerhub"
+	"github.com/fluxcd/image-reflector-controller/internal/registry/gcp"
+	"github.com/fluxcd/image-reflector-controller/internal/registry/github"
+	"github.com/fluxcd/image-reflector-controller/internal/registry/harbor"
+	"github.com/fluxcd/image-reflector-controller/internal/registry/login"
+	"github.com/fluxcd/image-reflector-controller/internal/registry/mirror"
+	"github.com/fluxcd/image-reflector-controller/internal/registry/quay"
+)
+
+// loginManager logs in to the registries of cloud providers. It is
+// shared by all reconciles, so that the authentication it caches is
+// reused until it is about to expire.
+var loginManager = login.NewManager()
+
+// remoteAccess works out how to connect to the registry of the image
+// repository: the authenticator, from the secret, the provider login or
+// the image pull secrets of the service account; and the transport,
+// with any certificates, proxy and mirror given. The authenticator may be
+// nil, meaning anonymous access.
+func remoteAccess(ctx context.Context, c client.Reader, imageRepo *imagev1.ImageRepository,
+	ref name.Reference, providerOptions login.ProviderOptions) (authn.Authenticator, http.RoundTripper, error) {
+	// Look up any service account, for its image pull secrets and the
+	// identity bound to it.
+	var serviceAccount corev1.ServiceAccount
+	if imageRepo.Spec.ServiceAccountName != "" {
+		if err := c.Get(ctx, types.NamespacedName{
+			Namespace: imageRepo.GetNamespace(),
+			Name:      imageRepo.Spec.ServiceAccountName,
+		}, &serviceAccount); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Configure authentication strategy to access the registry.
+	var authSecret corev1.Secret
+	secretRef := authSecretRef(imageRepo)
+	if secretRef != nil {
+		if err := c.Get(ctx, types.NamespacedName{
+			Namespace: imageRepo.GetNamespace(),
+			Name:      secretRef.Name,
+		}, &authSecret); err != nil {
+			return nil, nil, err
+		}
+	}
+	fromSecret := func() (authn.Authenticator, *quay.Credentials, error) {
+		// A secret giving a GitHub App has installation tokens minted
+		// for it, and one giving a DigitalOcean API token has docker
+		// credentials got with it, in place of giving credentials
+		// itself; one giving a Harbor robot account is checked before it
+		// is used; and one giving a Quay token has it traded for a
+		// registry token, once the transport is known.
+		if app, ok, err := github.AppFromSecret(authSecret.Data); err != nil {
+			return nil, nil, err
+		} else if ok {
+			auth, err := loginManager.GitHubAppLogin(ctx, app)
+			return auth, nil, err
+		} else if creds, ok, err := digitalocean.CredentialsFromSecret(authSecret.Data); err != nil {
+			return nil, nil, err
+		} else if ok {
+			auth, err := loginManager.DigitalOceanLogin(ctx, creds)
+			return auth, nil, err
+		} else if robot, ok, err := harbor.RobotFromSecret(authSecret.Data); err != nil {
+			return nil, nil, err
+		} else if ok {
+			return authn.FromConfig(robot), nil, nil
+		} else if creds, ok, err := quay.CredentialsFromSecret(authSecret.Data); err != nil {
+			return nil, nil, err
+		} else if ok {
+			return nil, &creds, nil
+		}
+		auth, err := authFromSecret(ctx, authSecret, ref, providerOptions.CredentialHelpersDir)
+		return auth, nil, err
+	}
+	fromProvider := func() (authn.Authenticator, *quay.Credentials, error) {
+		// A token of the service account is exchanged for the
+		// credentials for a registry trusting the cluster.
+		if p := imageRepo.Spec.Provider; p != nil && p.OIDC != nil {
+			if imageRepo.Spec.ServiceAccountName == "" {
+				return nil, nil, fmt.Errorf("'.spec.provider.oidc' needs the service account to request a token for in '.spec.serviceAccountName'")
+			}
+			auth, err := loginManager.ServiceAccountTokenLogin(ctx, providerOptions.ServiceAccounts, login.ServiceAccountToken{
+				Namespace:                imageRepo.GetNamespace(),
+				Name:                     imageRepo.Spec.ServiceAccountName,
+				Audience:                 p.OIDC.Audience,
+				Exchange:                 string(p.OIDC.Exchange),
+				WorkloadIdentityProvider: p.OIDC.WorkloadIdentityProvider,
+				GCPServiceAccount:        p.OIDC.GCPServiceAccount,
+				Username:                 p.OIDC.Username,
+			})
+			return auth, nil, err
+		}
+		// Use the registry provider options to attempt registry login.
+		if p := imageRepo.Spec.Provider; p != nil {
+			providerOptions.Provider = p.Name
+		}
+		if p := imageRepo.Spec.Provider; p != nil && p.AWS != nil {
+			providerOptions.AwsRoleARN = p.AWS.RoleARN
+			providerOptions.AwsRegion = p.AWS.Region
+		}
+		if p := imageRepo.Spec.Provider; p != nil && p.Azure != nil {
+			providerOptions.AzureTenantID = p.Azure.TenantID
+			providerOptions.AzureClientID = p.Azure.ClientID
+		}
+		if p := imageRepo.Spec.Provider; p != nil && p.Exec != nil {
+			providerOptions.CredentialHelper = p.Exec.Helper
+		}
+		// A Google service account bound to the service account with
+		// workload identity is impersonated.
+		providerOptions.GcpServiceAccount = serviceAccount.Annotations[gcp.ServiceAccountAnnotation]
+		auth, err := loginManager.Login(ctx, imageRepo.Spec.Image, ref, providerOptions)
+		return auth, nil, err
+	}
+
+	var chain imagev1.CredentialsChain
+	if p := imageRepo.Spec.Provider; p != nil {
+		chain = p.Chain
+	}
+	var auth authn.Authenticator
+	var quayCreds *quay.Credentials
+	var authErr error
+	switch {
+	case secretRef == nil:
+		auth, quayCreds, authErr = fromProvider()
+	case chain == imagev1.ChainSecretFirst:
+		auth, quayCreds, authErr = chainCredentials(fromSecret, fromProvider)
+	case chain == imagev1.ChainProviderFirst:
+		auth, quayCreds, authErr = chainCredentials(fromProvider, fromSecret)
+	default:
+		auth, quayCreds, authErr = fromSecret()
+	}
+	if authErr != nil {
+		return nil, nil, authErr
+	}
+
+	// Load any provided certificate.
+	var tr *http.Transport
+	if imageRepo.Spec.CertSecretRef != nil {
+		var certSecret corev1.Secret
+		if secretRef != nil && secretRef.Name == imageRepo.Spec.CertSecretRef.Name {
+			certSecret = authSecret
+		} else {
+			if err := c.Get(ctx, types.NamespacedName{
+				Namespace: imageRepo.GetNamespace(),
+				Name:      imageRepo.Spec.CertSecretRef.Name,
+			}, &certSecret); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		certTransport, err := transportFromSecret(&certSecret)
+		if err != nil {
+			return nil, nil, err
+		}
+		tr = certTransport
+	}
+
+	// Route the connection through any provided proxy.
+	if imageRepo.Spec.ProxySecretRef != nil {
+		var proxySecret corev1.Secret
+		if err := c.Get(ctx, types.NamespacedName{
+			Namespace: imageRepo.GetNamespace(),
+			Name:      imageRepo.Spec.ProxySecretRef.Name,
+		}, &proxySecret); err != nil {
+			return nil, nil, err
+		}
+
+		proxyURL, err := proxyURLFromSecret(&proxySecret)
+		if err != nil {
+			return nil, nil, err
+		}
+		if tr == nil {
+			tr = remote.DefaultTransport.Clone()
+		}
+		tr.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	// Connect to any mirror of the registry with the certificates it
+	// gives, in place of those for the registry.
+	registryMirror, mirrored := mirror.For(providerOptions.Mirrors, ref.Context().RegistryStr())
+	if mirrored {
+		tlsConfig, err := registryMirror.TLSConfig()
+		if err != nil {
+			return nil, nil, err
+		}
+		if tlsConfig != nil {
+			if tr == nil {
+				tr = remote.DefaultTransport.Clone()
+			}
+			tr.TLSClientConfig = tlsConfig
+		}
+	}
+
+	// Avoid a nil *http.Transport as a non-nil http.RoundTripper. Each
+	// request sent, including each retry, is counted for the scan.
+	// Requests wait for the limit of the host they are sent to, if any.
+	// Requests for a mirrored registry are sent to the mirror, requests
+	// to Quay are retried when they are rate limited, and the rate limit
+	// Docker Hub reports is recorded.
+	var rt http.RoundTripper = remote.DefaultTransport
+	if tr != nil {
+		rt = tr
+	}
+	rt = registry.CountRequests(rt)
+	if providerOptions.RequestLimits != nil {
+		rt = providerOptions.RequestLimits.Transport(rt)
+	}
+	if mirrored {
+		rt = mirror.NewTransport(registryMirror, rt)
+	}
+	if quayCreds != nil || quay.IsQuay(ref.Context().RegistryStr()) {
+		rt = quay.NewTransport(rt)
+	}
+	if !mirrored && dockerhub.IsDockerHub(ref.Context().RegistryStr()) {
+		rt = dockerhub.NewTransport(rt)
+	}
+	if quayCreds != nil {
+		if auth, authErr = loginManager.QuayLogin(ctx, *quayCreds, ref.Context(), rt); authErr != nil {
+			return nil, nil, authErr
+		}
+	}
+
+	if imageRepo.Spec.ServiceAccountName != "" {
+		if len(serviceAccount.ImagePullSecrets) > 0 {
+			imagePullSecrets := make([]corev1.Secret, len(serviceAccount.ImagePullSecrets))
+
+			for i, ips := range serviceAccount.ImagePullSecrets {
+				var saAuthSecret corev1.Secret
+
+				if err := c.Get(ctx, types.NamespacedName{
+					Namespace: imageRepo.GetNamespace(),
+					Name:      ips.Name,
+				}, &saAuthSecret); err != nil {
+					return nil, nil, err
+				}
+
+				imagePullSecrets[i] = saAuthSecret
+			}
+
+			if auth != nil {
+				return nil, nil, fmt.Errorf("cannot use both registry credentials and the image pull secrets of service account '%s'",
+					imageRepo.Spec.ServiceAccountName)
+			}
+
+			keychain, err := k8schain.NewFromPullSecrets(ctx, imagePullSecrets)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			auth, err = keychain.Resolve(ref.Context())
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	// Fall back on the docker config the controller is given, e.g.,
+	// that of the node, if nothing else gives credentials for the
+	// registry.
+	if (auth == nil || auth == authn.Anonymous) && providerOptions.DockerConfigFile != "" {
+		configData, err := os.ReadFile(providerOptions.DockerConfigFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to read the docker config of the controller: %w", err)
+		}
+		configAuth, ok, err := authFromDockerConfig(ctx, configData, ref, providerOptions.CredentialHelpersDir)
+		if err != nil {
+			return nil, nil, fmt.Errorf("docker config of the controller: %w", err)
+		}
+		if ok {
+			auth = configAuth
+		}
+	}
+
+	// The credentials given for a mirror are used in place of any for
+	// the registry it mirrors.
+	if mirrored && registryMirror.DockerConfigFile != "" {
+		configData, err := os.ReadFile(registryMirror.DockerConfigFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to read the docker config of the mirror of '%s': %w", registryMirror.Registry, err)
+		}
+		mirrorRef, err := registryMirror.Reference(ref)
+		if err != nil {
+			return nil, nil, err
+		}
+		mirrorAuth, ok, err := authFromDockerConfig(ctx, configData, mirrorRef, providerOptions.CredentialHelpersDir)
+		if err != nil {
+			return nil, nil, fmt.Errorf("docker config of the mirror of '%s': %w", registryMirror.Registry, err)
+		}
+		if !ok {
+			return nil, nil, fmt.Errorf("auth for %q not found in the docker config of the mirror of '%s'", registryMirror.Endpoint, registryMirror.Registry)
+		}
+		auth = mirrorAuth
+	}
+
+	return auth, rt, nil
+}
+
+// credentialsSource gives credentials for the registry, either as an
+// authenticator or as Quay credentials to log in with, or neither if it
+// has none for the registry.
+type credentialsSource func() (authn.Authenticator, *quay.Credentials, error)
+
+// chainCredentials returns the credentials of the first of the sources
+// to give any, moving on to the next source if one gives none or fails.
+// If none gives credentials, the errors of the sources that failed are
+// returned, if any.
+func chainCredentials(sources ...credentialsSource) (authn.Authenticator, *quay.Credentials, error) {
+	var errs []string
+	for _, source := range sources {
+		auth, quayCreds, err := source()
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if auth != nil || quayCreds != nil {
+			return auth, quayCreds, nil
+		}
+	}
+	if len(errs) > 0 {
+		return nil, nil, fmt.Errorf("no credentials for the registry: %s", strings.Join(errs, "; "))
+	}
+	return nil, nil, nil
+}
+
+// authSecretRef returns the secret to get the credentials for the
+// registry from: that of `.spec.secretRef`, or else, of those of
+// `.spec.secretRefs`, that recorded in the status as used by the last
+// successful scan, or the first. The webhook refuses both fields given;
+// `.spec.secretRef` takes precedence for those stored with both. It
+// returns nil if there is none.
+func authSecretRef(imageRepo *imagev1.ImageRepository) *meta.LocalObjectReference {
+	if imageRepo.Spec.SecretRef != nil {
+		return imageRepo.Spec.SecretRef
+	}
+	if len(imageRepo.Spec.SecretRefs) == 0 {
+		return nil
+	}
+	if used := imageRepo.Status.SecretRef; used != nil {
+		for i := range imageRepo.Spec.SecretRefs {
+			if imageRepo.Spec.SecretRefs[i].Name == used.Name {
+				return &imageRepo.Spec.SecretRefs[i]
+			}
+		}
+	}
+	return &imageRepo.Spec.SecretRefs[0]
+}
+
+// remoteOptions returns the options for the `remote` funcs to connect
+// with the given authenticator and transport, as returned by
+// remoteAccess.
+func remoteOptions(ctx context.Context, auth authn.Authenticator, tr http.RoundTripper) []remote.Option {
+	options := []remote.Option{remote.WithContext(ctx)}
+	if auth != nil {
+		options = append(options, remote.WithAuth(auth))
+	}
+	if tr != nil {
+		options = append(options, remote.WithTransport(tr))
+	}
+	return options
+}
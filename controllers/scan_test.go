@@ -18,24 +18,47 @@ package controllers
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/fluxcd/pkg/apis/meta"
 	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
 	. "github.com/onsi/gomega"
 	corev1 "k8s.io/api/core/v1"
 	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/workqueue"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	imagev1 "github.com/fluxcd/image-reflector-controller/api/v1beta1"
 	"github.com/fluxcd/image-reflector-controller/internal/database"
+	"github.com/fluxcd/image-reflector-controller/internal/priorityqueue"
+	"github.com/fluxcd/image-reflector-controller/internal/registry"
+	"github.com/fluxcd/image-reflector-controller/internal/registry/throttle"
 	"github.com/fluxcd/image-reflector-controller/internal/test"
 	// +kubebuilder:scaffold:imports
 )
@@ -86,6 +109,7 @@ func TestImageRepositoryReconciler_fetchImageTags(t *testing.T) {
 		versions      []string
 		wantVersions  []string
 		exclusionList []string
+		inclusionList []string
 	}{
 		{
 			name:         "fetch image tags",
@@ -103,6 +127,19 @@ func TestImageRepositoryReconciler_fetchImageTags(t *testing.T) {
 			wantVersions:  []string{"0.1.0", "0.1.1", "0.1.1.sig", "1.0.0"},
 			exclusionList: []string{"^.*\\-alpha$"},
 		},
+		{
+			name:          "fetch image tags - only tags in inclusionList are included",
+			versions:      []string{"0.1.0", "0.1.1-alpha", "0.1.1", "0.1.1.sig", "build-1234", "cache-abcd"},
+			wantVersions:  []string{"0.1.0", "0.1.1-alpha", "0.1.1"},
+			inclusionList: []string{"^[0-9]+\\.[0-9]+\\.[0-9]+"},
+		},
+		{
+			name:          "fetch image tags - exclusionList applies to tags in inclusionList",
+			versions:      []string{"0.1.0", "0.1.1-alpha", "0.1.1", "0.1.1.sig", "build-1234", "cache-abcd"},
+			wantVersions:  []string{"0.1.0", "0.1.1"},
+			inclusionList: []string{"^[0-9]+\\.[0-9]+\\.[0-9]+"},
+			exclusionList: []string{"^.*\\-alpha$", "^.*\\.sig$"},
+		},
 	}
 
 	for _, tt := range tests {
@@ -115,6 +152,7 @@ func TestImageRepositoryReconciler_fetchImageTags(t *testing.T) {
 					Interval:      metav1.Duration{Duration: reconciliationInterval},
 					Image:         imgRepo,
 					ExclusionList: tt.exclusionList,
+					InclusionList: tt.inclusionList,
 				},
 			}
 			objectName := types.NamespacedName{
@@ -135,12 +173,1180 @@ func TestImageRepositoryReconciler_fetchImageTags(t *testing.T) {
 			}, timeout, interval).Should(BeTrue())
 			g.Expect(repo.Status.CanonicalImageName).To(Equal(imgRepo))
 			g.Expect(repo.Status.LastScanResult.TagCount).To(Equal(len(tt.wantVersions)))
+			g.Expect(repo.Status.LastScanResult.LatestTags).To(ConsistOf(tt.wantVersions))
 			// Cleanup.
 			g.Expect(testEnv.Delete(ctx, &repo)).To(Succeed())
 		})
 	}
 }
 
+func TestImageRepositoryReconciler_deleteImageRecords(t *testing.T) {
+	g := NewWithT(t)
+
+	registryServer := test.NewRegistryServer()
+	defer registryServer.Close()
+
+	imgRepo, err := test.LoadImages(registryServer, "test-delete-"+randStringRunes(5), []string{"1.0.0"})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	ctx, cancel := context.WithTimeout(context.Background(), contextTimeout)
+	defer cancel()
+
+	// Two image repositories scan the same image.
+	var repos []*imagev1.ImageRepository
+	for i := 0; i < 2; i++ {
+		repo := &imagev1.ImageRepository{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-delete-records-" + randStringRunes(5),
+				Namespace: "default",
+			},
+			Spec: imagev1.ImageRepositorySpec{
+				Interval: metav1.Duration{Duration: reconciliationInterval},
+				Image:    imgRepo,
+			},
+		}
+		g.Expect(testEnv.Create(ctx, repo)).To(Succeed())
+		g.Eventually(func() bool {
+			err := testEnv.Get(ctx, client.ObjectKeyFromObject(repo), repo)
+			return err == nil && repo.Status.LastScanResult != nil
+		}, timeout, interval).Should(BeTrue())
+		repos = append(repos, repo)
+	}
+
+	db := database.NewBadgerDatabase(testBadgerDB)
+	gone := func(repo *imagev1.ImageRepository) func() bool {
+		return func() bool {
+			err := testEnv.Get(ctx, client.ObjectKeyFromObject(repo), &imagev1.ImageRepository{})
+			return client.IgnoreNotFound(err) == nil && err != nil
+		}
+	}
+
+	// The records are kept while another image repository scans the image.
+	g.Expect(testEnv.Delete(ctx, repos[0])).To(Succeed())
+	g.Eventually(gone(repos[0]), timeout, interval).Should(BeTrue())
+	tags, err := db.Tags(imgRepo)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(tags).To(ConsistOf("1.0.0"))
+
+	// The records are deleted with the last image repository.
+	g.Expect(testEnv.Delete(ctx, repos[1])).To(Succeed())
+	g.Eventually(gone(repos[1]), timeout, interval).Should(BeTrue())
+	tags, err = db.Tags(imgRepo)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(tags).To(BeEmpty())
+}
+
+func TestDatabaseCollector_collect(t *testing.T) {
+	g := NewWithT(t)
+
+	registryServer := test.NewRegistryServer()
+	defer registryServer.Close()
+
+	imgRepo, err := test.LoadImages(registryServer, "test-collect-"+randStringRunes(5), []string{"1.0.0"})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	ctx, cancel := context.WithTimeout(context.Background(), contextTimeout)
+	defer cancel()
+
+	repo := imagev1.ImageRepository{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-collect-" + randStringRunes(5),
+			Namespace: "default",
+		},
+		Spec: imagev1.ImageRepositorySpec{
+			Interval: metav1.Duration{Duration: reconciliationInterval},
+			Image:    imgRepo,
+		},
+	}
+	g.Expect(testEnv.Create(ctx, &repo)).To(Succeed())
+	g.Eventually(func() bool {
+		err := testEnv.Get(ctx, client.ObjectKeyFromObject(&repo), &repo)
+		return err == nil && repo.Status.CanonicalImageName != ""
+	}, timeout, interval).Should(BeTrue())
+
+	db := database.NewMemoryDatabase()
+	orphan := imgRepo + "-orphan"
+	for _, name := range []string{repo.Status.CanonicalImageName, orphan} {
+		g.Expect(db.SetTags(name, []string{"1.0.0"})).To(Succeed())
+	}
+
+	collector := &DatabaseCollector{Reader: testEnv, Database: db, Interval: time.Minute}
+	deleted, err := collector.collect(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(deleted).To(Equal([]string{orphan}))
+	g.Expect(db.Repositories()).To(Equal([]string{repo.Status.CanonicalImageName}))
+
+	// Cleanup.
+	g.Expect(testEnv.Delete(ctx, &repo)).To(Succeed())
+}
+
+func TestFetchTagMetadata(t *testing.T) {
+	g := NewWithT(t)
+
+	registryServer := test.NewRegistryServer()
+	defer registryServer.Close()
+
+	tagRef, err := name.NewTag(test.RegistryName(registryServer) + "/test-metadata:1.0.0")
+	g.Expect(err).ToNot(HaveOccurred())
+	canonicalName := tagRef.Context().String()
+	pushImage := func(created time.Time) v1.Hash {
+		img, err := random.Image(512, 1)
+		g.Expect(err).ToNot(HaveOccurred())
+		config, err := img.ConfigFile()
+		g.Expect(err).ToNot(HaveOccurred())
+		config.OS, config.Architecture = "linux", "amd64"
+		config.Created = v1.Time{Time: created}
+		img, err = mutate.ConfigFile(img, config)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(remote.Write(tagRef, img)).To(Succeed())
+		digest, err := img.Digest()
+		g.Expect(err).ToNot(HaveOccurred())
+		return digest
+	}
+
+	db := database.NewMemoryDatabase()
+	creationTime := func() time.Time {
+		created, ok, err := db.CreationTime(canonicalName, "1.0.0")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(ok).To(BeTrue())
+		return created
+	}
+	platforms := func() map[string]string {
+		platforms, ok, err := db.Platforms(canonicalName, "1.0.0")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(ok).To(BeTrue())
+		return platforms
+	}
+
+	created := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	digest := pushImage(created)
+	g.Expect(fetchTagMetadata(context.TODO(), db, canonicalName, tagRef, nil, nil)).To(Succeed())
+	desc, ok, err := db.Descriptor(canonicalName, "1.0.0")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ok).To(BeTrue())
+	g.Expect(desc.Digest).To(Equal(digest))
+	g.Expect(creationTime()).To(BeTemporally("==", created))
+	g.Expect(platforms()).To(Equal(map[string]string{"linux/amd64": digest.String()}))
+
+	// While the tag refers to the same image, nothing is fetched again.
+	g.Expect(db.SetCreationTime(canonicalName, "1.0.0", created.Add(time.Hour))).To(Succeed())
+	g.Expect(fetchTagMetadata(context.TODO(), db, canonicalName, tagRef, nil, nil)).To(Succeed())
+	g.Expect(creationTime()).To(BeTemporally("==", created.Add(time.Hour)))
+
+	// When the tag is moved to another image, its metadata is fetched
+	// again, including a config and provenance recorded before.
+	g.Expect(db.SetImageConfig(canonicalName, "1.0.0", []byte(`{}`))).To(Succeed())
+	g.Expect(db.SetProvenance(canonicalName, "1.0.0", []string{"https://example.com/builder@v1"})).To(Succeed())
+	moved := created.Add(24 * time.Hour)
+	digest = pushImage(moved)
+	g.Expect(fetchTagMetadata(context.TODO(), db, canonicalName, tagRef, nil, nil)).To(Succeed())
+	desc, _, err = db.Descriptor(canonicalName, "1.0.0")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(desc.Digest).To(Equal(digest))
+	g.Expect(creationTime()).To(BeTemporally("==", moved))
+	g.Expect(platforms()).To(Equal(map[string]string{"linux/amd64": digest.String()}))
+	config, _, err := db.ImageConfig(canonicalName, "1.0.0")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(string(config)).To(ContainSubstring(moved.Format(time.RFC3339)))
+	// The image moved to has no attestations.
+	builders, ok, err := db.Provenance(canonicalName, "1.0.0")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ok).To(BeTrue())
+	g.Expect(builders).To(BeEmpty())
+}
+
+func TestImageRepositoryReconciler_latestTags(t *testing.T) {
+	tests := []struct {
+		name string
+		tags []string
+		want []string
+	}{
+		{
+			name: "no tags",
+			tags: []string{},
+			want: []string{},
+		},
+		{
+			name: "fewer tags than the limit",
+			tags: []string{"0.1.0", "1.0.0", "0.2.0"},
+			want: []string{"1.0.0", "0.2.0", "0.1.0"},
+		},
+		{
+			name: "more tags than the limit",
+			tags: []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l"},
+			want: []string{"l", "k", "j", "i", "h", "g", "f", "e", "d", "c"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(latestTags(tt.tags)).To(Equal(tt.want))
+		})
+	}
+}
+
+func TestNewTagsEvent(t *testing.T) {
+	tests := []struct {
+		name         string
+		tags         []string
+		wantMsg      string
+		wantMetadata map[string]string
+	}{
+		{
+			name:         "fewer tags than the limit",
+			tags:         []string{"1.0.0", "1.1.0"},
+			wantMsg:      "found 2 new tags: 1.1.0, 1.0.0",
+			wantMetadata: map[string]string{newTagsKey: "1.1.0,1.0.0", newTagCountKey: "2"},
+		},
+		{
+			name:         "more tags than the limit",
+			tags:         []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l"},
+			wantMsg:      "found 12 new tags: l, k, j, i, h, g, f, e, d, c and 2 more",
+			wantMetadata: map[string]string{newTagsKey: "l,k,j,i,h,g,f,e,d,c", newTagCountKey: "12"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			msg, metadata := newTagsEvent(tt.tags)
+			g.Expect(msg).To(Equal(tt.wantMsg))
+			g.Expect(metadata).To(Equal(tt.wantMetadata))
+		})
+	}
+}
+
+func TestRemovedTagsEvent(t *testing.T) {
+	g := NewWithT(t)
+	msg, metadata := removedTagsEvent([]string{"1.0.0", "0.9.0"})
+	g.Expect(msg).To(Equal("2 tags removed from the image repository since the previous scan: 1.0.0, 0.9.0"))
+	g.Expect(metadata).To(Equal(map[string]string{removedTagsKey: "1.0.0,0.9.0", removedTagCountKey: "2"}))
+}
+
+func TestImageRepositoryReconciler_scanRemovedTags(t *testing.T) {
+	g := NewWithT(t)
+
+	tags := []string{"v1", "v2", "v3"}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/" {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"name": "foo/bar", "tags": tags})
+	}))
+	defer srv.Close()
+
+	ref, err := name.ParseReference(strings.TrimPrefix(srv.URL, "http://") + "/foo/bar")
+	g.Expect(err).ToNot(HaveOccurred())
+	r := &ImageRepositoryReconciler{Database: database.NewMemoryDatabase()}
+	imageRepo := &imagev1.ImageRepository{Spec: imagev1.ImageRepositorySpec{Image: ref.Context().String()}}
+
+	changes, err := r.scan(context.TODO(), imageRepo, ref)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(changes).To(Equal(tagChanges{}))
+
+	// A tag now excluded is not removed from the registry; one no
+	// longer listed is, in the event and the result alike.
+	tags = []string{"v1", "v3"}
+	imageRepo.Spec.ExclusionList = []string{"^v1$"}
+	changes, err = r.scan(context.TODO(), imageRepo, ref)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(changes.removed).To(Equal([]string{"v2"}))
+	g.Expect(imageRepo.Status.LastScanResult.RemovedTagCount).To(Equal(1))
+	g.Expect(imageRepo.Status.LastScanResult.RemovedTags).To(HaveLen(1))
+	g.Expect(imageRepo.Status.LastScanResult.RemovedTags[0].Tag).To(Equal("v2"))
+}
+
+func TestImageRepositoriesForPolicy(t *testing.T) {
+	g := NewWithT(t)
+
+	pol := &imagev1.ImagePolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "apps"},
+		Spec: imagev1.ImagePolicySpec{
+			ImageRepositoryRef: meta.NamespacedObjectReference{Name: "podinfo"},
+			FallbackImageRepositoryRefs: []meta.NamespacedObjectReference{
+				{Name: "podinfo-mirror"},
+				{Name: "podinfo", Namespace: "shared"},
+			},
+		},
+	}
+	g.Expect(imageRepositoriesForPolicy(false)(pol)).To(Equal([]reconcile.Request{
+		{NamespacedName: types.NamespacedName{Name: "podinfo", Namespace: "apps"}},
+		{NamespacedName: types.NamespacedName{Name: "podinfo-mirror", Namespace: "apps"}},
+		{NamespacedName: types.NamespacedName{Name: "podinfo", Namespace: "shared"}},
+	}))
+	g.Expect(imageRepositoriesForPolicy(true)(pol)).To(BeEmpty())
+
+	// A policy using a cluster image repository has it reconciled by
+	// the cluster image repository reconciler.
+	pol.Spec.ImageRepositoryKind = imagev1.ClusterImageRepositoryKind
+	pol.Spec.FallbackImageRepositoryRefs = nil
+	g.Expect(imageRepositoriesForPolicy(false)(pol)).To(BeEmpty())
+	g.Expect(imageRepositoriesForPolicy(true)(pol)).To(Equal([]reconcile.Request{
+		{NamespacedName: types.NamespacedName{Name: "podinfo"}},
+	}))
+}
+
+func TestPolicyRefs(t *testing.T) {
+	g := NewWithT(t)
+
+	g.Expect(policyRefs(nil)).To(BeNil())
+	policies := []imagev1.ImagePolicy{
+		{ObjectMeta: metav1.ObjectMeta{Name: "b", Namespace: "apps"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "web"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "apps"}},
+	}
+	g.Expect(policyRefs(policies)).To(Equal([]meta.NamespacedObjectReference{
+		{Name: "a", Namespace: "apps"},
+		{Name: "b", Namespace: "apps"},
+		{Name: "a", Namespace: "web"},
+	}))
+}
+
+func TestImageRepositoryReconciler_lastSeen(t *testing.T) {
+	g := NewWithT(t)
+	r := &ImageRepositoryReconciler{Database: database.NewMemoryDatabase()}
+	repo := "example.com/foo/bar"
+	earlier := time.Date(2022, 5, 6, 18, 0, 0, 0, time.UTC)
+	later := earlier.Add(time.Hour)
+
+	// Nothing removed, nothing recorded.
+	lastSeen, changed, err := r.lastSeen(repo, []string{"v1.0.0"}, nil, earlier)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(changed).To(BeFalse())
+	g.Expect(lastSeen).To(BeEmpty())
+
+	lastSeen, changed, err = r.lastSeen(repo, nil, []string{"v0.9.0", "v1.0.0"}, earlier)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(changed).To(BeTrue())
+	g.Expect(lastSeen).To(Equal(map[string]time.Time{"v0.9.0": earlier, "v1.0.0": earlier}))
+	g.Expect(r.Database.SetLastSeen(repo, lastSeen)).To(Succeed())
+
+	// A tag added again is no longer removed.
+	lastSeen, changed, err = r.lastSeen(repo, []string{"v1.0.0"}, []string{"v0.8.0"}, later)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(changed).To(BeTrue())
+	g.Expect(lastSeen).To(Equal(map[string]time.Time{"v0.8.0": later, "v0.9.0": earlier}))
+
+	g.Expect(removedTags(lastSeen)).To(Equal([]imagev1.RemovedTag{
+		{Tag: "v0.8.0", LastSeen: metav1.NewTime(later)},
+		{Tag: "v0.9.0", LastSeen: metav1.NewTime(earlier)},
+	}))
+}
+
+func TestImageRepositoryReconciler_listTagsResume(t *testing.T) {
+	g := NewWithT(t)
+
+	// The tags are served two to a page, and the second page fails
+	// the first time it is requested.
+	tags := []string{"v1", "v2", "v3", "v4", "v5"}
+	failed := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/" {
+			return
+		}
+		start := 0
+		if last := r.URL.Query().Get("last"); last != "" {
+			if !failed {
+				failed = true
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			start = sort.SearchStrings(tags, last) + 1
+		}
+		end := start + 2
+		if end >= len(tags) {
+			end = len(tags)
+		} else {
+			w.Header().Set("Link", fmt.Sprintf(`</v2/foo/bar/tags/list?last=%s>; rel="next"`, tags[end-1]))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"name": "foo/bar", "tags": tags[start:end]})
+	}))
+	defer srv.Close()
+
+	ref, err := name.ParseReference(strings.TrimPrefix(srv.URL, "http://") + "/foo/bar")
+	g.Expect(err).ToNot(HaveOccurred())
+	r := &ImageRepositoryReconciler{Database: database.NewMemoryDatabase()}
+	imageRepo := &imagev1.ImageRepository{}
+
+	_, _, err = r.listTags(context.TODO(), imageRepo, ref, nil, nil)
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(imageRepo.Status.ScanCursor).ToNot(BeEmpty())
+	g.Expect(imageRepo.Status.PartialTagCount).To(Equal(2))
+	partial, err := r.Database.PartialTags(ref.Context().String())
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(partial).To(Equal([]string{"v1", "v2"}))
+
+	// The next listing resumes from the page that failed.
+	got, _, err := r.listTags(context.TODO(), imageRepo, ref, nil, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(got).To(Equal(tags))
+	g.Expect(imageRepo.Status.ScanCursor).To(BeEmpty())
+	g.Expect(imageRepo.Status.PartialTagCount).To(BeZero())
+}
+
+func TestImageRepositoryReconciler_listIncrementally(t *testing.T) {
+	g := NewWithT(t)
+	now := time.Date(2022, 5, 6, 18, 0, 0, 0, time.UTC)
+	imageRepo := &imagev1.ImageRepository{}
+
+	// Not while no full listing interval is given.
+	g.Expect(listIncrementally(imageRepo, now)).To(BeFalse())
+
+	// Nor before the tags have been listed in full.
+	imageRepo.Spec.FullListInterval = &metav1.Duration{Duration: 24 * time.Hour}
+	g.Expect(listIncrementally(imageRepo, now)).To(BeFalse())
+
+	lastFull := metav1.NewTime(now.Add(-time.Hour))
+	imageRepo.Status.LastFullListTime = &lastFull
+	g.Expect(listIncrementally(imageRepo, now)).To(BeTrue())
+	g.Expect(listIncrementally(imageRepo, now.Add(23*time.Hour))).To(BeFalse())
+
+	g.Expect(highestTag([]string{"v1.2.0", "v1.10.0", "latest"})).To(Equal("v1.2.0"))
+	g.Expect(uniqueTags([]string{"v1", "v2", "v1", "v3", "v2"})).To(Equal([]string{"v1", "v2", "v3"}))
+}
+
+func TestImageRepositoryReconciler_tagListValidators(t *testing.T) {
+	g := NewWithT(t)
+	r := &ImageRepositoryReconciler{Database: database.NewMemoryDatabase()}
+	repo := "example.com/foo/bar"
+	imageRepo := &imagev1.ImageRepository{}
+	filter := tagFilterKey(imageRepo, 0)
+	v := registry.Validators{ETag: `"abc"`}
+
+	// Nothing recorded.
+	_, ok, err := r.tagListValidators(repo, filter)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ok).To(BeFalse())
+
+	// The validators are not used while no tags are recorded.
+	g.Expect(r.recordTagListValidators(repo, filter, v)).To(Succeed())
+	_, ok, err = r.tagListValidators(repo, filter)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ok).To(BeFalse())
+
+	g.Expect(r.Database.SetTags(repo, []string{"v1.0.0"})).To(Succeed())
+	got, ok, err := r.tagListValidators(repo, filter)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ok).To(BeTrue())
+	g.Expect(got).To(Equal(v))
+
+	// Nor are they once the tags are filtered otherwise.
+	imageRepo.Spec.InclusionList = []string{"^v1"}
+	_, ok, err = r.tagListValidators(repo, tagFilterKey(imageRepo, 0))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ok).To(BeFalse())
+
+	// A listing without validators removes them.
+	g.Expect(r.recordTagListValidators(repo, filter, registry.Validators{})).To(Succeed())
+	_, ok, err = r.Database.TagListValidators(repo)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ok).To(BeFalse())
+}
+
+func TestImageRepositoryReconciler_shouldScanRateLimited(t *testing.T) {
+	g := NewWithT(t)
+	r := &ImageRepositoryReconciler{Database: database.NewMemoryDatabase()}
+	scanTime := time.Date(2022, 5, 6, 18, 0, 0, 0, time.UTC)
+	repo := imagev1.ImageRepository{
+		Spec: imagev1.ImageRepositorySpec{Interval: metav1.Duration{Duration: time.Minute}},
+		Status: imagev1.ImageRepositoryStatus{
+			CanonicalImageName: "index.docker.io/foo/bar",
+			LastScanResult:     &imagev1.ScanResult{TagCount: 1, ScanTime: metav1.NewTime(scanTime)},
+			RateLimit: &imagev1.RateLimit{
+				Limit:        100,
+				Remaining:    50,
+				Window:       &metav1.Duration{Duration: 6 * time.Hour},
+				ObservedTime: metav1.NewTime(scanTime),
+			},
+		},
+	}
+	g.Expect(r.Database.SetTags(repo.Status.CanonicalImageName, []string{"1.0.0"})).To(Succeed())
+
+	// With plenty of the quota remaining, scans are each interval.
+	ok, _, err := r.shouldScan(repo, scanTime.Add(time.Minute))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ok).To(BeTrue())
+
+	// With the quota nearly exhausted, the pulls remaining are spread
+	// over the window.
+	repo.Status.RateLimit.Remaining = 5
+	ok, when, err := r.shouldScan(repo, scanTime.Add(time.Minute))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ok).To(BeFalse())
+	g.Expect(when).To(Equal(time.Hour - time.Minute))
+
+	ok, _, err = r.shouldScan(repo, scanTime.Add(time.Hour))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ok).To(BeTrue())
+}
+
+func TestImageRepositoryReconciler_shouldScanThrottled(t *testing.T) {
+	g := NewWithT(t)
+	r := &ImageRepositoryReconciler{
+		Database:        database.NewMemoryDatabase(),
+		RegistryBackoff: throttle.NewBackoff(time.Minute, time.Hour),
+	}
+	ref, err := name.ParseReference("example.com/foo/bar")
+	g.Expect(err).ToNot(HaveOccurred())
+	repo := imagev1.ImageRepository{
+		Spec: imagev1.ImageRepositorySpec{Interval: metav1.Duration{Duration: time.Second}},
+		Status: imagev1.ImageRepositoryStatus{
+			CanonicalImageName: ref.Context().String(),
+		},
+	}
+
+	// An error other than throttling leaves the registry alone.
+	r.recordThrottling(&repo, ref, &transport.Error{StatusCode: http.StatusNotFound})
+	ok, _, err := r.shouldScan(repo, time.Now())
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ok).To(BeTrue())
+	g.Expect(apimeta.FindStatusCondition(repo.Status.Conditions, imagev1.ThrottledCondition)).To(BeNil())
+
+	// Once the registry throttles a scan, the scans of its images are
+	// put off, including those of other image repositories.
+	r.recordThrottling(&repo, ref, fmt.Errorf("scan incomplete: %w", &transport.Error{StatusCode: http.StatusTooManyRequests}))
+	g.Expect(apimeta.IsStatusConditionTrue(repo.Status.Conditions, imagev1.ThrottledCondition)).To(BeTrue())
+	other := repo
+	other.Status.CanonicalImageName = "example.com/foo/baz"
+	ok, when, err := r.shouldScan(other, time.Now())
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ok).To(BeFalse())
+	g.Expect(when).To(BeNumerically("~", time.Minute, time.Second))
+
+	// A scan succeeding ends the backoff.
+	r.recordThrottling(&repo, ref, nil)
+	g.Expect(apimeta.FindStatusCondition(repo.Status.Conditions, imagev1.ThrottledCondition)).To(BeNil())
+	ok, _, err = r.shouldScan(repo, time.Now())
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ok).To(BeTrue())
+}
+
+func TestImageRepositoryReconciler_scanBackoff(t *testing.T) {
+	g := NewWithT(t)
+	r := &ImageRepositoryReconciler{
+		Database:             database.NewMemoryDatabase(),
+		ScanBackoffBaseDelay: time.Minute,
+		ScanBackoffMaxDelay:  3 * time.Minute,
+	}
+	repo := imagev1.ImageRepository{
+		Spec: imagev1.ImageRepositorySpec{Interval: metav1.Duration{Duration: time.Second}},
+		Status: imagev1.ImageRepositoryStatus{
+			CanonicalImageName: "example.com/foo/bar",
+		},
+	}
+	now := time.Now()
+	scanErr := errors.New("unauthorized")
+
+	// The delay doubles with each failure, up to the max.
+	for _, want := range []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute, 3 * time.Minute} {
+		r.recordScanBackoff(&repo, scanErr, now)
+		g.Expect(repo.Status.Backoff.Delay.Duration).To(Equal(want))
+	}
+	g.Expect(repo.Status.Backoff.Failures).To(Equal(4))
+
+	// Scans are put off until the delay has passed.
+	ok, when, err := r.shouldScan(repo, now.Add(time.Minute))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ok).To(BeFalse())
+	g.Expect(when).To(Equal(2 * time.Minute))
+	ok, _, err = r.shouldScan(repo, now.Add(3*time.Minute))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ok).To(BeTrue())
+
+	// Unless the spec has changed, or a reconciliation is requested.
+	changed := repo
+	changed.Generation++
+	ok, _, err = r.shouldScan(changed, now)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ok).To(BeTrue())
+	requested := repo
+	requested.Annotations = map[string]string{meta.ReconcileRequestAnnotation: "now"}
+	ok, _, err = r.shouldScan(requested, now)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ok).To(BeTrue())
+
+	// A failed scan handles the request.
+	r.recordScanBackoff(&requested, scanErr, now)
+	ok, _, err = r.shouldScan(requested, now)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ok).To(BeFalse())
+
+	// A scan succeeding ends the backoff.
+	r.recordScanBackoff(&repo, nil, now)
+	g.Expect(repo.Status.Backoff).To(BeNil())
+}
+
+func TestImageRepositoryReconciler_shouldScanStalled(t *testing.T) {
+	g := NewWithT(t)
+	r := &ImageRepositoryReconciler{
+		Database:             database.NewMemoryDatabase(),
+		ScanBackoffBaseDelay: time.Minute,
+	}
+	repo := imagev1.ImageRepository{
+		Spec: imagev1.ImageRepositorySpec{Interval: metav1.Duration{Duration: time.Second}},
+		Status: imagev1.ImageRepositoryStatus{
+			CanonicalImageName: "example.com/foo/bar",
+		},
+	}
+	now := time.Now()
+
+	// Other errors do not stall the image repository.
+	recordStalled(&repo, &transport.Error{StatusCode: http.StatusUnauthorized})
+	g.Expect(apimeta.FindStatusCondition(repo.Status.Conditions, meta.StalledCondition)).To(BeNil())
+
+	tests := []error{
+		&transport.Error{StatusCode: http.StatusNotFound},
+		fmt.Errorf("scan incomplete: %w", &transport.Error{
+			StatusCode: http.StatusBadRequest,
+			Errors:     []transport.Diagnostic{{Code: transport.NameUnknownErrorCode}},
+		}),
+	}
+	for _, scanErr := range tests {
+		stalled := repo
+		recordStalled(&stalled, scanErr)
+		r.recordScanBackoff(&stalled, scanErr, now)
+		g.Expect(apimeta.IsStatusConditionTrue(stalled.Status.Conditions, meta.StalledCondition)).To(BeTrue())
+		g.Expect(apimeta.FindStatusCondition(stalled.Status.Conditions, meta.ReadyCondition).Reason).To(Equal(imagev1.RepositoryNotFoundReason))
+		g.Expect(stalled.Status.Backoff).To(BeNil())
+
+		// A stalled image repository is not scanned, nor requeued.
+		ok, when, err := r.shouldScan(stalled, now.Add(time.Hour))
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(ok).To(BeFalse())
+		g.Expect(when).To(BeZero())
+
+		// Until the spec changes, or a reconciliation is requested.
+		changed := stalled
+		changed.Generation++
+		ok, _, err = r.shouldScan(changed, now)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(ok).To(BeTrue())
+		requested := stalled
+		requested.Annotations = map[string]string{meta.ReconcileRequestAnnotation: "now"}
+		ok, _, err = r.shouldScan(requested, now)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(ok).To(BeTrue())
+
+		// A scan succeeding removes the condition.
+		recordStalled(&stalled, nil)
+		g.Expect(apimeta.FindStatusCondition(stalled.Status.Conditions, meta.StalledCondition)).To(BeNil())
+	}
+}
+
+func TestImageRepositoryReconciler_recordReconciling(t *testing.T) {
+	g := NewWithT(t)
+	repo := imagev1.ImageRepository{}
+	repo.Generation = 2
+
+	// Reconciling while scanning, and after a failure to be retried.
+	markReconciling(&repo)
+	reconciling := apimeta.FindStatusCondition(repo.Status.Conditions, meta.ReconcilingCondition)
+	g.Expect(reconciling).ToNot(BeNil())
+	g.Expect(reconciling.Reason).To(Equal(meta.ProgressingReason))
+	g.Expect(reconciling.ObservedGeneration).To(Equal(int64(2)))
+	recordReconciling(&repo, errors.New("connection refused"))
+	reconciling = apimeta.FindStatusCondition(repo.Status.Conditions, meta.ReconcilingCondition)
+	g.Expect(reconciling.Reason).To(Equal(imagev1.ProgressingWithRetryReason))
+	g.Expect(reconciling.Message).To(ContainSubstring("connection refused"))
+
+	// Not after a scan succeeding.
+	recordReconciling(&repo, nil)
+	g.Expect(apimeta.FindStatusCondition(repo.Status.Conditions, meta.ReconcilingCondition)).To(BeNil())
+
+	// Nor once stalled, which a scan cannot end when the spec is at
+	// fault.
+	markReconciling(&repo)
+	markStalled(&repo, imagev1.ImageURLInvalidReason, "invalid image")
+	g.Expect(apimeta.FindStatusCondition(repo.Status.Conditions, meta.ReconcilingCondition)).To(BeNil())
+	g.Expect(apimeta.IsStatusConditionTrue(repo.Status.Conditions, meta.StalledCondition)).To(BeTrue())
+	g.Expect(apimeta.FindStatusCondition(repo.Status.Conditions, meta.ReadyCondition).Reason).To(Equal(imagev1.ImageURLInvalidReason))
+	g.Expect(repo.Status.ObservedGeneration).To(Equal(int64(2)))
+	// Each condition is stamped with the generation it was computed
+	// from, as is the status.
+	repo.Generation = 3
+	markStalled(&repo, imagev1.ImageURLInvalidReason, "invalid image")
+	for _, conditionType := range []string{meta.StalledCondition, meta.ReadyCondition} {
+		g.Expect(apimeta.FindStatusCondition(repo.Status.Conditions, conditionType).ObservedGeneration).To(Equal(int64(3)))
+	}
+	g.Expect(repo.Status.ObservedGeneration).To(Equal(int64(3)))
+	g.Expect(clearSpecStall(&repo)).To(BeTrue())
+	g.Expect(apimeta.FindStatusCondition(repo.Status.Conditions, meta.StalledCondition)).To(BeNil())
+
+	// A stall because of the registry is ended by a scan.
+	recordStalled(&repo, &transport.Error{StatusCode: http.StatusNotFound})
+	g.Expect(clearSpecStall(&repo)).To(BeFalse())
+	g.Expect(apimeta.IsStatusConditionTrue(repo.Status.Conditions, meta.StalledCondition)).To(BeTrue())
+}
+
+func TestScanFailureReason(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "unauthorized", err: &transport.Error{StatusCode: http.StatusUnauthorized}, want: imagev1.AuthenticationFailedReason},
+		{name: "denied", err: fmt.Errorf("scan incomplete: %w", &transport.Error{
+			StatusCode: http.StatusBadRequest,
+			Errors:     []transport.Diagnostic{{Code: transport.DeniedErrorCode}},
+		}), want: imagev1.AuthenticationFailedReason},
+		{name: "unknown authority", err: &url.Error{Op: "Get", URL: "https://example.com/v2/", Err: x509.UnknownAuthorityError{}}, want: imagev1.TLSVerificationFailedReason},
+		{name: "not TLS", err: &url.Error{Op: "Get", URL: "https://example.com/v2/", Err: tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}}, want: imagev1.TLSVerificationFailedReason},
+		{name: "connection refused", err: &url.Error{Op: "Get", URL: "https://example.com/v2/", Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}}, want: imagev1.RegistryUnavailableReason},
+		{name: "server error", err: &transport.Error{StatusCode: http.StatusServiceUnavailable}, want: imagev1.RegistryUnavailableReason},
+		{name: "timeout", err: fmt.Errorf("listing tags: %w", context.DeadlineExceeded), want: imagev1.RegistryUnavailableReason},
+		{name: "other", err: errors.New("secret 'creds' not found"), want: imagev1.ReconciliationFailedReason},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(scanFailureReason(tt.err)).To(Equal(tt.want))
+		})
+	}
+}
+
+func TestCheckTagFilters(t *testing.T) {
+	g := NewWithT(t)
+	repo := imagev1.ImageRepository{Spec: imagev1.ImageRepositorySpec{
+		ExclusionList: []string{"^.*\\.sig$"},
+		InclusionList: []string{"^v1"},
+	}}
+	g.Expect(checkTagFilters(repo)).To(Succeed())
+	repo.Spec.InclusionList = append(repo.Spec.InclusionList, "(")
+	err := checkTagFilters(repo)
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(err.Error()).To(ContainSubstring("spec.inclusionList[1]"))
+}
+
+func TestRecordNextScanTime(t *testing.T) {
+	g := NewWithT(t)
+	now := time.Now()
+	repo := imagev1.ImageRepository{}
+
+	g.Expect(recordNextScanTime(&repo, now, time.Hour)).To(BeTrue())
+	g.Expect(repo.Status.NextScanTime.Time).To(Equal(now.Add(time.Hour)))
+
+	// The same time computed again a little later is not a change.
+	g.Expect(recordNextScanTime(&repo, now.Add(100*time.Millisecond), time.Hour-100*time.Millisecond)).To(BeFalse())
+	g.Expect(repo.Status.NextScanTime.Time).To(Equal(now.Add(time.Hour)))
+	g.Expect(recordNextScanTime(&repo, now, 30*time.Minute)).To(BeTrue())
+	g.Expect(repo.Status.NextScanTime.Time).To(Equal(now.Add(30 * time.Minute)))
+
+	// Without a next scan, the time is removed.
+	g.Expect(recordNextScanTime(&repo, now, 0)).To(BeTrue())
+	g.Expect(repo.Status.NextScanTime).To(BeNil())
+	g.Expect(recordNextScanTime(&repo, now, 0)).To(BeFalse())
+
+	// After a failed scan, the next is after the backoff; after a stall,
+	// there is none.
+	scanErr := errors.New("connection refused")
+	g.Expect(nextScanAfter(repo, nil, time.Hour)).To(Equal(time.Hour))
+	g.Expect(nextScanAfter(repo, scanErr, time.Hour)).To(BeZero())
+	repo.Status.Backoff = &imagev1.ScanBackoff{Failures: 2, Delay: metav1.Duration{Duration: 20 * time.Second}}
+	g.Expect(nextScanAfter(repo, scanErr, time.Hour)).To(Equal(20 * time.Second))
+	markStalled(&repo, imagev1.RepositoryNotFoundReason, "not found")
+	g.Expect(nextScanAfter(repo, scanErr, time.Hour)).To(BeZero())
+}
+
+func TestImageRepositoryReconciler_shouldScanScheduled(t *testing.T) {
+	g := NewWithT(t)
+	r := &ImageRepositoryReconciler{Database: database.NewMemoryDatabase()}
+	repoName := "example.com/foo/bar"
+	g.Expect(r.Database.SetTags(repoName, []string{"v1.0.0"})).To(Succeed())
+
+	// 2022-05-06 is a Friday; scans are each hour from 9:00 to 17:00 in
+	// Berlin, two hours ahead of UTC.
+	friday := time.Date(2022, 5, 6, 7, 0, 0, 0, time.UTC)
+	repo := imagev1.ImageRepository{
+		Spec: imagev1.ImageRepositorySpec{
+			Interval: metav1.Duration{Duration: time.Minute},
+			Schedule: &imagev1.ScanSchedule{Cron: "0 9-17 * * 1-5", TimeZone: "Europe/Berlin"},
+		},
+		Status: imagev1.ImageRepositoryStatus{
+			CanonicalImageName: repoName,
+			LastScanResult:     &imagev1.ScanResult{ScanTime: metav1.NewTime(friday)},
+		},
+	}
+
+	// A scan made at a time of the schedule waits for the next.
+	ok, when, err := r.shouldScan(repo, friday.Add(30*time.Minute))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ok).To(BeFalse())
+	g.Expect(when).To(Equal(30 * time.Minute))
+
+	// A scan is due at the next time, even if the reconciliation is
+	// just early.
+	ok, when, err = r.shouldScan(repo, friday.Add(time.Hour-100*time.Millisecond))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ok).To(BeTrue())
+	g.Expect(when).To(Equal(time.Hour + 100*time.Millisecond))
+
+	// The scan after the last of the day is on Monday.
+	repo.Status.LastScanResult.ScanTime = metav1.NewTime(friday.Add(8 * time.Hour))
+	_, when, err = r.shouldScan(repo, friday.Add(8*time.Hour))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(when).To(Equal(64 * time.Hour))
+
+	for _, s := range []imagev1.ScanSchedule{
+		{Cron: "0 9-17 * *"},
+		{Cron: "0 9 * * *", TimeZone: "Nowhere/Special"},
+		{Cron: "0 0 30 2 *"},
+	} {
+		s := s
+		repo.Spec.Schedule = &s
+		_, err := parseScanSchedule(repo)
+		g.Expect(err).To(HaveOccurred())
+	}
+}
+
+func TestImageRepositoryReconciler_shouldScanWindows(t *testing.T) {
+	g := NewWithT(t)
+	r := &ImageRepositoryReconciler{Database: database.NewMemoryDatabase()}
+	// 2022-05-06 is a Friday.
+	friday := time.Date(2022, 5, 6, 12, 0, 0, 0, time.UTC)
+	repo := imagev1.ImageRepository{
+		Spec: imagev1.ImageRepositorySpec{Interval: metav1.Duration{Duration: time.Minute}},
+		Status: imagev1.ImageRepositoryStatus{
+			CanonicalImageName: "example.com/foo/bar",
+		},
+	}
+	nightly := imagev1.ScanWindow{Schedule: "0 1 * * *", Duration: metav1.Duration{Duration: 2 * time.Hour}, Type: imagev1.ScanWindowDeny}
+	workingHours := imagev1.ScanWindow{Schedule: "0 9 * * 1-5", Duration: metav1.Duration{Duration: 8 * time.Hour}}
+
+	tests := []struct {
+		name    string
+		windows []imagev1.ScanWindow
+		now     time.Time
+		scan    bool
+		wait    time.Duration
+	}{
+		{
+			name: "no windows",
+			now:  friday,
+			scan: true,
+		},
+		{
+			name:    "outside a window denying scans",
+			windows: []imagev1.ScanWindow{nightly},
+			now:     friday,
+			scan:    true,
+		},
+		{
+			name:    "in a window denying scans",
+			windows: []imagev1.ScanWindow{nightly},
+			now:     friday.Add(-10 * time.Hour),
+			wait:    time.Hour,
+		},
+		{
+			name:    "in a window allowing scans",
+			windows: []imagev1.ScanWindow{workingHours},
+			now:     friday,
+			scan:    true,
+		},
+		{
+			name:    "outside the windows allowing scans",
+			windows: []imagev1.ScanWindow{workingHours},
+			now:     friday.Add(6 * time.Hour),
+			wait:    63 * time.Hour,
+		},
+		{
+			name:    "in windows allowing and denying scans",
+			windows: []imagev1.ScanWindow{nightly, {Schedule: "0 0 * * *", Duration: metav1.Duration{Duration: 24 * time.Hour}}},
+			now:     friday.Add(-10 * time.Hour),
+			wait:    time.Hour,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			repo := repo
+			repo.Spec.ScanWindows = tt.windows
+			ok, when, err := r.shouldScan(repo, tt.now)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(ok).To(Equal(tt.scan))
+			if !tt.scan {
+				g.Expect(when).To(Equal(tt.wait))
+			}
+		})
+	}
+
+	// A reconciliation requested is not held back.
+	repo.Spec.ScanWindows = []imagev1.ScanWindow{nightly}
+	repo.Annotations = map[string]string{meta.ReconcileRequestAnnotation: "now"}
+	ok, _, err := r.shouldScan(repo, friday.Add(-10*time.Hour))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ok).To(BeTrue())
+
+	repo.Spec.ScanWindows = []imagev1.ScanWindow{{Schedule: "0 1 * *", Duration: metav1.Duration{Duration: time.Hour}}}
+	g.Expect(checkScanTiming(repo)).ToNot(Succeed())
+}
+
+func TestImageRepositoryReconciler_minScanInterval(t *testing.T) {
+	g := NewWithT(t)
+	r := &ImageRepositoryReconciler{
+		Database:        database.NewMemoryDatabase(),
+		MinScanInterval: time.Minute,
+	}
+	repoName := "example.com/foo/bar"
+	g.Expect(r.Database.SetTags(repoName, []string{"v1.0.0"})).To(Succeed())
+	now := time.Now()
+	repo := imagev1.ImageRepository{
+		Spec: imagev1.ImageRepositorySpec{Interval: metav1.Duration{Duration: 5 * time.Second}},
+		Status: imagev1.ImageRepositoryStatus{
+			CanonicalImageName: repoName,
+			LastScanResult:     &imagev1.ScanResult{ScanTime: metav1.NewTime(now)},
+		},
+	}
+
+	// An interval shorter than the minimum is held to it.
+	r.recordIntervalClamped(&repo)
+	g.Expect(apimeta.IsStatusConditionTrue(repo.Status.Conditions, imagev1.IntervalClampedCondition)).To(BeTrue())
+	ok, when, err := r.shouldScan(repo, now.Add(10*time.Second))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ok).To(BeFalse())
+	g.Expect(when).To(Equal(50 * time.Second))
+
+	// As is a schedule with times closer together.
+	repo.Spec.Schedule = &imagev1.ScanSchedule{Cron: "* * * * *"}
+	ok, when, err = r.shouldScan(repo, now.Add(10*time.Second))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ok).To(BeFalse())
+	g.Expect(when).To(Equal(50 * time.Second))
+	ok, when, err = r.shouldScan(repo, now.Add(time.Minute))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ok).To(BeTrue())
+	g.Expect(when).To(Equal(time.Minute))
+
+	repo.Spec.Schedule = nil
+	repo.Spec.Interval.Duration = time.Hour
+	r.recordIntervalClamped(&repo)
+	g.Expect(apimeta.FindStatusCondition(repo.Status.Conditions, imagev1.IntervalClampedCondition)).To(BeNil())
+}
+
+func TestImageRepositoryReconciler_nextInterval(t *testing.T) {
+	minute := &metav1.Duration{Duration: time.Minute}
+	tests := []struct {
+		name        string
+		maxInterval *metav1.Duration
+		effective   *metav1.Duration
+		unchanged   bool
+		want        *metav1.Duration
+	}{
+		{
+			name:      "no max interval",
+			unchanged: true,
+		},
+		{
+			name:        "tags unchanged after the first scan",
+			maxInterval: &metav1.Duration{Duration: time.Hour},
+			unchanged:   true,
+			want:        &metav1.Duration{Duration: 2 * time.Minute},
+		},
+		{
+			name:        "tags unchanged again",
+			maxInterval: &metav1.Duration{Duration: time.Hour},
+			effective:   &metav1.Duration{Duration: 8 * time.Minute},
+			unchanged:   true,
+			want:        &metav1.Duration{Duration: 16 * time.Minute},
+		},
+		{
+			name:        "up to the max interval",
+			maxInterval: &metav1.Duration{Duration: time.Hour},
+			effective:   &metav1.Duration{Duration: 32 * time.Minute},
+			unchanged:   true,
+			want:        &metav1.Duration{Duration: time.Hour},
+		},
+		{
+			name:        "tags changed",
+			maxInterval: &metav1.Duration{Duration: time.Hour},
+			effective:   &metav1.Duration{Duration: 32 * time.Minute},
+		},
+		{
+			name:        "max interval no longer than the interval",
+			maxInterval: minute,
+			unchanged:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			repo := imagev1.ImageRepository{
+				Spec:   imagev1.ImageRepositorySpec{Interval: *minute, MaxInterval: tt.maxInterval},
+				Status: imagev1.ImageRepositoryStatus{EffectiveInterval: tt.effective},
+			}
+			next := nextInterval(repo, tt.unchanged)
+			g.Expect(next).To(Equal(tt.want))
+
+			// The scan after is due at the interval given.
+			repo.Status.EffectiveInterval = next
+			want := time.Minute
+			if tt.want != nil {
+				want = tt.want.Duration
+			}
+			g.Expect(effectiveInterval(repo)).To(Equal(want))
+		})
+	}
+}
+
+func TestImageRepositoryReconciler_tagsDelta(t *testing.T) {
+	tests := []struct {
+		name        string
+		previous    []string
+		tags        []string
+		wantAdded   []string
+		wantRemoved []string
+	}{
+		{
+			name: "no tags",
+		},
+		{
+			name:     "unchanged tags in another order",
+			previous: []string{"1.0.0", "1.1.0"},
+			tags:     []string{"1.1.0", "1.0.0"},
+		},
+		{
+			name:      "first scan",
+			tags:      []string{"1.0.0", "1.1.0"},
+			wantAdded: []string{"1.0.0", "1.1.0"},
+		},
+		{
+			name:        "added and removed tags",
+			previous:    []string{"1.0.0", "1.1.0", "1.2.0"},
+			tags:        []string{"1.1.0", "1.3.0"},
+			wantAdded:   []string{"1.3.0"},
+			wantRemoved: []string{"1.0.0", "1.2.0"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			added, removed := tagsDelta(tt.previous, tt.tags)
+			g.Expect(added).To(Equal(tt.wantAdded))
+			g.Expect(removed).To(Equal(tt.wantRemoved))
+		})
+	}
+}
+
+func TestImageRepositoryReconciler_limitTags(t *testing.T) {
+	tests := []struct {
+		name      string
+		tags      []string
+		limit     int
+		orderings [][]string
+		want      []string
+	}{
+		{
+			name:  "no limit",
+			tags:  []string{"a", "b", "c"},
+			limit: 0,
+			want:  []string{"a", "b", "c"},
+		},
+		{
+			name:  "fewer tags than the limit",
+			tags:  []string{"a", "b", "c"},
+			limit: 3,
+			want:  []string{"a", "b", "c"},
+		},
+		{
+			name:  "versions ahead of other tags",
+			tags:  []string{"main-b", "v1.10.0", "1.2.0", "main-c", "v1.9.0"},
+			limit: 3,
+			want:  []string{"v1.10.0", "1.2.0", "v1.9.0"},
+		},
+		{
+			name:  "other tags alphabetically",
+			tags:  []string{"main-b", "1.0.0", "main-c", "main-a"},
+			limit: 3,
+			want:  []string{"main-b", "1.0.0", "main-c"},
+		},
+		{
+			name:      "tags the policies would select first",
+			tags:      []string{"main-b", "v1.10.0", "1.2.0", "main-c", "v1.9.0"},
+			limit:     3,
+			orderings: [][]string{{"main-b"}, {"1.2.0", "v1.9.0"}},
+			want:      []string{"main-b", "1.2.0", "v1.9.0"},
+		},
+		{
+			name:      "taking from each policy in turn",
+			tags:      []string{"main-a", "main-b", "main-c", "1.0.0", "2.0.0"},
+			limit:     3,
+			orderings: [][]string{{"main-c", "main-b", "main-a"}, {"1.0.0"}},
+			want:      []string{"main-b", "main-c", "1.0.0"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(limitTags(tt.tags, tt.limit, tt.orderings)).To(Equal(tt.want))
+		})
+	}
+}
+
+func TestPolicyOrderings(t *testing.T) {
+	g := NewWithT(t)
+
+	tags := []string{"main-a1b2c3-100", "main-d4e5f6-200", "v1.0.0", "v1.1.0", "v2.0.0"}
+	policies := []imagev1.ImagePolicySpec{
+		{
+			Policy: imagev1.ImagePolicyChoice{SemVer: &imagev1.SemVerPolicy{Range: "1.x"}},
+		},
+		{
+			Policy:     imagev1.ImagePolicyChoice{Numerical: &imagev1.NumericalPolicy{Order: "asc"}},
+			FilterTags: &imagev1.TagFilter{Pattern: `^main-[a-f0-9]+-(?P<ts>[0-9]+)$`, Extract: "$ts"},
+		},
+		{
+			// Creation times are not known for every tag.
+			Policy: imagev1.ImagePolicyChoice{CreatedAt: &imagev1.CreatedAtPolicy{}},
+		},
+	}
+	g.Expect(policyOrderings(policies, tags)).To(Equal([][]string{
+		{"v1.1.0", "v1.0.0"},
+		{"main-d4e5f6-200", "main-a1b2c3-100"},
+	}))
+	g.Expect(needsOf(policies)).To(Equal(policyMetadata{creationTimes: true}))
+}
+
+func TestWithPriorityQueue(t *testing.T) {
+	g := NewWithT(t)
+
+	// The manager is not started, so it needs no API server.
+	mgr, err := ctrl.NewManager(&rest.Config{Host: "http://127.0.0.1:1"}, ctrl.Options{
+		MetricsBindAddress: "0",
+		MapperProvider: func(*rest.Config) (apimeta.RESTMapper, error) {
+			return apimeta.NewDefaultRESTMapper(nil), nil
+		},
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+	c, err := controller.NewUnmanaged("test", mgr, controller.Options{
+		Reconciler: reconcile.Func(func(context.Context, reconcile.Request) (reconcile.Result, error) {
+			return reconcile.Result{}, nil
+		}),
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	priorities := map[string]int{"high": 10}
+	g.Expect(withPriorityQueue(c, func(req reconcile.Request) int {
+		return priorities[req.Name]
+	})).To(Succeed())
+
+	// The queue the controller makes when it starts is a priority queue.
+	makeQueue := reflect.ValueOf(c).Elem().FieldByName("MakeQueue").Interface().(func() workqueue.RateLimitingInterface)
+	q, ok := makeQueue().(*priorityqueue.Queue)
+	g.Expect(ok).To(BeTrue())
+	low := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "low"}}
+	high := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "high"}}
+	q.Add(low)
+	q.Add(high)
+	item, _ := q.Get()
+	g.Expect(item).To(Equal(high))
+}
+
 func TestImageRepositoryReconciler_repositorySuspended(t *testing.T) {
 	g := NewWithT(t)
 
@@ -303,6 +1509,72 @@ func TestImageRepositoryReconciler_authRegistry(t *testing.T) {
 	g.Expect(testEnv.Delete(ctx, &repo)).To(Succeed())
 }
 
+func TestImageRepositoryReconciler_authRegistryWithSecretRefs(t *testing.T) {
+	g := NewWithT(t)
+
+	username, password := "authuser", "authpass"
+	registryServer := test.NewAuthenticatedRegistryServer(username, password)
+	defer registryServer.Close()
+
+	// The first secret has credentials the registry refuses, so those
+	// of the second are used.
+	for name, pass := range map[string]string{"docker-stale": "wrongpass", "docker-current": password} {
+		secret := &corev1.Secret{
+			Type: "kubernetes.io/dockerconfigjson",
+			StringData: map[string]string{
+				".dockerconfigjson": fmt.Sprintf(`{"auths": {%q: {"username": %q, "password": %q}}}`,
+					test.RegistryName(registryServer), username, pass),
+			},
+		}
+		secret.Namespace = "default"
+		secret.Name = name
+		g.Expect(testEnv.Create(context.Background(), secret)).To(Succeed())
+		defer func() {
+			g.Expect(testEnv.Delete(context.Background(), secret)).To(Succeed())
+		}()
+	}
+
+	versions := []string{"0.1.0", "0.1.1", "0.2.0"}
+	imgRepo, err := test.LoadImages(registryServer, "test-authn-refs-"+randStringRunes(5),
+		versions, remote.WithAuth(&authn.Basic{
+			Username: username,
+			Password: password,
+		}))
+	g.Expect(err).ToNot(HaveOccurred())
+
+	repo := imagev1.ImageRepository{
+		Spec: imagev1.ImageRepositorySpec{
+			Interval: metav1.Duration{Duration: reconciliationInterval},
+			Image:    imgRepo,
+			SecretRefs: []meta.LocalObjectReference{
+				{Name: "docker-stale"},
+				{Name: "docker-current"},
+			},
+		},
+	}
+	objectName := types.NamespacedName{
+		Name:      "test-auth-reg-refs-" + randStringRunes(5),
+		Namespace: "default",
+	}
+
+	repo.Name = objectName.Name
+	repo.Namespace = objectName.Namespace
+
+	ctx, cancel := context.WithTimeout(context.Background(), contextTimeout)
+	defer cancel()
+	g.Expect(testEnv.Create(ctx, &repo)).To(Succeed())
+
+	g.Eventually(func() bool {
+		err := testEnv.Get(ctx, objectName, &repo)
+		return err == nil && repo.Status.LastScanResult != nil
+	}, timeout, interval).Should(BeTrue())
+	g.Expect(repo.Status.LastScanResult.TagCount).To(Equal(len(versions)))
+	g.Expect(repo.Status.SecretRef).ToNot(BeNil())
+	g.Expect(repo.Status.SecretRef.Name).To(Equal("docker-current"))
+	// Cleanup.
+	g.Expect(testEnv.Delete(ctx, &repo)).To(Succeed())
+}
+
 func TestImageRepositoryReconciler_imageAttribute_schemePrefix(t *testing.T) {
 	g := NewWithT(t)
 
@@ -381,6 +1653,36 @@ func TestImageRepositoryReconciler_imageAttribute_withTag(t *testing.T) {
 	g.Expect(testEnv.Delete(ctx, &repo)).To(Succeed())
 }
 
+func TestImageRepositoryReconciler_parseImageReference(t *testing.T) {
+	tests := []struct {
+		name       string
+		image      string
+		insecure   bool
+		wantScheme string
+	}{
+		{
+			name:       "secure by default",
+			image:      "registry.example.com/org/image",
+			wantScheme: "https",
+		},
+		{
+			name:       "insecure",
+			image:      "registry.example.com/org/image",
+			insecure:   true,
+			wantScheme: "http",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			ref, err := parseImageReference(tt.image, tt.insecure)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(ref.Context().Registry.Scheme()).To(Equal(tt.wantScheme))
+		})
+	}
+}
+
 func TestImageRepositoryReconciler_imageAttribute_hostPort(t *testing.T) {
 	g := NewWithT(t)
 
@@ -87,6 +87,17 @@ func TestMain(m *testing.M) {
 		panic(fmt.Sprintf("Failed to start ImageRepositoryReconciler: %v", err))
 	}
 
+	if err = (&ClusterImageRepositoryReconciler{
+		ImageRepositoryReconciler: ImageRepositoryReconciler{
+			Client:        testEnv,
+			Scheme:        scheme.Scheme,
+			Database:      database.NewBadgerDatabase(testBadgerDB),
+			EventRecorder: testEnv.GetEventRecorderFor(controllerName),
+		},
+	}).SetupWithManager(testEnv, ImageRepositoryReconcilerOptions{}); err != nil {
+		panic(fmt.Sprintf("Failed to start ClusterImageRepositoryReconciler: %v", err))
+	}
+
 	if err = (&ImagePolicyReconciler{
 		Client:        testEnv,
 		Scheme:        scheme.Scheme,
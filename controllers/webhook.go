@@ -0,0 +1,358 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/conversion"
+
+	imagev1 "github.com/fluxcd/image-reflector-controller/api/v1beta1"
+	"github.com/fluxcd/image-reflector-controller/internal/policy"
+	"github.com/fluxcd/image-reflector-controller/internal/registry/login"
+)
+
+// SetupConversionWebhookWithManager registers the webhook converting
+// ImageRepositories and ImagePolicies between the versions of the API
+// with the webhook server of the manager, at `/convert`. All the
+// versions must be in the scheme of the manager, and each must convert
+// to and from v1beta1, the version stored.
+func SetupConversionWebhookWithManager(mgr ctrl.Manager) error {
+	for _, obj := range []client.Object{&imagev1.ImageRepository{}, &imagev1.ImagePolicy{}} {
+		ok, err := conversion.IsConvertible(mgr.GetScheme(), obj)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%T is not convertible between the versions of the scheme", obj)
+		}
+	}
+	mgr.GetWebhookServer().Register("/convert", &conversion.Webhook{})
+	return nil
+}
+
+// +kubebuilder:webhook:path=/validate-image-toolkit-fluxcd-io-v1beta1-imagerepository,mutating=false,failurePolicy=fail,sideEffects=None,groups=image.toolkit.fluxcd.io,resources=imagerepositories,verbs=create;update,versions=v1beta1,name=vimagerepository.image.toolkit.fluxcd.io,admissionReviewVersions=v1
+// +kubebuilder:webhook:path=/validate-image-toolkit-fluxcd-io-v1beta1-clusterimagerepository,mutating=false,failurePolicy=fail,sideEffects=None,groups=image.toolkit.fluxcd.io,resources=clusterimagerepositories,verbs=create;update,versions=v1beta1,name=vclusterimagerepository.image.toolkit.fluxcd.io,admissionReviewVersions=v1
+
+// ImageRepositoryValidator validates ImageRepositories and
+// ClusterImageRepositories at admission, refusing those the controller
+// would fail to scan because of their spec alone.
+type ImageRepositoryValidator struct{}
+
+// SetupWebhookWithManager registers the validator with the webhook
+// server of the manager, for both kinds of image repository.
+func (v *ImageRepositoryValidator) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	if err := ctrl.NewWebhookManagedBy(mgr).For(&imagev1.ImageRepository{}).WithValidator(v).Complete(); err != nil {
+		return err
+	}
+	return ctrl.NewWebhookManagedBy(mgr).For(&imagev1.ClusterImageRepository{}).WithValidator(v).Complete()
+}
+
+// ValidateCreate implements admission.CustomValidator.
+func (v *ImageRepositoryValidator) ValidateCreate(ctx context.Context, obj runtime.Object) error {
+	return v.validate(obj)
+}
+
+// ValidateUpdate implements admission.CustomValidator. Only a change
+// to the spec is validated, as for validateUpdate.
+func (v *ImageRepositoryValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) error {
+	if !validateUpdate(oldObj, newObj) {
+		return nil
+	}
+	return v.validate(newObj)
+}
+
+// ValidateDelete implements admission.CustomValidator. Image
+// repositories can always be deleted.
+func (v *ImageRepositoryValidator) ValidateDelete(ctx context.Context, obj runtime.Object) error {
+	return nil
+}
+
+func (v *ImageRepositoryValidator) validate(obj runtime.Object) error {
+	var repo imagev1.ImageRepository
+	var kind string
+	switch o := obj.(type) {
+	case *imagev1.ImageRepository:
+		repo, kind = *o, imagev1.ImageRepositoryKind
+	case *imagev1.ClusterImageRepository:
+		repo, kind = *o.ImageRepository(), imagev1.ClusterImageRepositoryKind
+	default:
+		return fmt.Errorf("expected an image repository, got %T", obj)
+	}
+	return invalid(kind, obj, validateImageRepository(repo))
+}
+
+// validateImageRepository returns what is invalid in the spec of the
+// image repository.
+func validateImageRepository(repo imagev1.ImageRepository) field.ErrorList {
+	var errs field.ErrorList
+	spec := field.NewPath("spec")
+	if _, err := parseImageReference(repo.Spec.Image, repo.Spec.Insecure); err != nil {
+		errs = append(errs, field.Invalid(spec.Child("image"), repo.Spec.Image, err.Error()))
+	}
+	if repo.Spec.SecretRef != nil && len(repo.Spec.SecretRefs) > 0 {
+		errs = append(errs, field.Forbidden(spec.Child("secretRefs"), "cannot be given with spec.secretRef"))
+	}
+	errs = append(errs, validateRegexes(spec.Child("exclusionList"), repo.Spec.ExclusionList)...)
+	errs = append(errs, validateRegexes(spec.Child("inclusionList"), repo.Spec.InclusionList)...)
+	if sched := repo.Spec.Schedule; sched != nil {
+		if _, err := parseScanSchedule(repo); err != nil {
+			errs = append(errs, field.Invalid(spec.Child("schedule"), sched.Cron, err.Error()))
+		}
+	}
+	for i, sw := range repo.Spec.ScanWindows {
+		if _, err := parseWindow(sw.Schedule, sw.TimeZone, sw.Duration.Duration); err != nil {
+			errs = append(errs, field.Invalid(spec.Child("scanWindows").Index(i), sw.Schedule, err.Error()))
+		}
+	}
+	return errs
+}
+
+// validateRegexes returns an error for each of the regexes that does not
+// compile.
+func validateRegexes(path *field.Path, regexes []string) field.ErrorList {
+	var errs field.ErrorList
+	for i, regex := range regexes {
+		if _, err := regexp.Compile(regex); err != nil {
+			errs = append(errs, field.Invalid(path.Index(i), regex, err.Error()))
+		}
+	}
+	return errs
+}
+
+// +kubebuilder:webhook:path=/mutate-image-toolkit-fluxcd-io-v1beta1-imagerepository,mutating=true,failurePolicy=fail,sideEffects=None,groups=image.toolkit.fluxcd.io,resources=imagerepositories,verbs=create,versions=v1beta1,name=mimagerepository.image.toolkit.fluxcd.io,admissionReviewVersions=v1
+// +kubebuilder:webhook:path=/mutate-image-toolkit-fluxcd-io-v1beta1-clusterimagerepository,mutating=true,failurePolicy=fail,sideEffects=None,groups=image.toolkit.fluxcd.io,resources=clusterimagerepositories,verbs=create,versions=v1beta1,name=mclusterimagerepository.image.toolkit.fluxcd.io,admissionReviewVersions=v1
+
+// ImageRepositoryDefaulter sets the fields that new ImageRepositories and
+// ClusterImageRepositories do not give to the defaults configured for
+// the controller. A zero default leaves the field unset.
+type ImageRepositoryDefaulter struct {
+	// Interval is that of image repositories without an interval or a
+	// schedule.
+	Interval time.Duration
+	// Timeout is that of image repositories without a timeout.
+	Timeout time.Duration
+	// ExclusionList is that of image repositories without one. It
+	// replaces the exclusion of cosign signatures, when given.
+	ExclusionList []string
+	// Provider is the name of the provider of image repositories
+	// without a provider.
+	Provider string
+}
+
+// SetupWebhookWithManager checks the defaults, and registers the
+// defaulter with the webhook server of the manager, for both kinds of
+// image repository.
+func (d *ImageRepositoryDefaulter) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	for _, regex := range d.ExclusionList {
+		if _, err := regexp.Compile(regex); err != nil {
+			return fmt.Errorf("invalid default exclusion '%s': %w", regex, err)
+		}
+	}
+	if d.Provider != "" && !login.IsProviderName(d.Provider) {
+		return fmt.Errorf("invalid default provider '%s'", d.Provider)
+	}
+	if err := ctrl.NewWebhookManagedBy(mgr).For(&imagev1.ImageRepository{}).WithDefaulter(d).Complete(); err != nil {
+		return err
+	}
+	return ctrl.NewWebhookManagedBy(mgr).For(&imagev1.ClusterImageRepository{}).WithDefaulter(d).Complete()
+}
+
+// Default implements admission.CustomDefaulter.
+func (d *ImageRepositoryDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	switch o := obj.(type) {
+	case *imagev1.ImageRepository:
+		d.defaultSpec(&o.Spec)
+	case *imagev1.ClusterImageRepository:
+		d.defaultSpec(&o.Spec.ImageRepositorySpec)
+	default:
+		return fmt.Errorf("expected an image repository, got %T", obj)
+	}
+	return nil
+}
+
+func (d *ImageRepositoryDefaulter) defaultSpec(spec *imagev1.ImageRepositorySpec) {
+	if spec.Interval.Duration == 0 && spec.Schedule == nil && d.Interval > 0 {
+		spec.Interval = metav1.Duration{Duration: d.Interval}
+	}
+	if spec.Timeout == nil && d.Timeout > 0 {
+		spec.Timeout = &metav1.Duration{Duration: d.Timeout}
+	}
+	if spec.ExclusionList == nil && len(d.ExclusionList) > 0 {
+		spec.ExclusionList = append([]string(nil), d.ExclusionList...)
+	}
+	if spec.Provider == nil && d.Provider != "" {
+		spec.Provider = &imagev1.RegistryProvider{Name: d.Provider}
+	}
+}
+
+// +kubebuilder:webhook:path=/validate-image-toolkit-fluxcd-io-v1beta1-imagepolicy,mutating=false,failurePolicy=fail,sideEffects=None,groups=image.toolkit.fluxcd.io,resources=imagepolicies,verbs=create;update,versions=v1beta1,name=vimagepolicy.image.toolkit.fluxcd.io,admissionReviewVersions=v1
+// +kubebuilder:webhook:path=/validate-image-toolkit-fluxcd-io-v1beta1-clusterimagepolicy,mutating=false,failurePolicy=fail,sideEffects=None,groups=image.toolkit.fluxcd.io,resources=clusterimagepolicies,verbs=create;update,versions=v1beta1,name=vclusterimagepolicy.image.toolkit.fluxcd.io,admissionReviewVersions=v1
+
+// ImagePolicyValidator validates ImagePolicies and ClusterImagePolicies
+// at admission, refusing those with policy rules that cannot be
+// followed.
+type ImagePolicyValidator struct{}
+
+// SetupWebhookWithManager registers the validator with the webhook
+// server of the manager, for image policies and their templates.
+func (v *ImagePolicyValidator) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	if err := ctrl.NewWebhookManagedBy(mgr).For(&imagev1.ImagePolicy{}).WithValidator(v).Complete(); err != nil {
+		return err
+	}
+	return ctrl.NewWebhookManagedBy(mgr).For(&imagev1.ClusterImagePolicy{}).WithValidator(v).Complete()
+}
+
+// ValidateCreate implements admission.CustomValidator.
+func (v *ImagePolicyValidator) ValidateCreate(ctx context.Context, obj runtime.Object) error {
+	return v.validate(obj)
+}
+
+// ValidateUpdate implements admission.CustomValidator. Only a change
+// to the spec is validated, as for validateUpdate.
+func (v *ImagePolicyValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) error {
+	if !validateUpdate(oldObj, newObj) {
+		return nil
+	}
+	return v.validate(newObj)
+}
+
+// ValidateDelete implements admission.CustomValidator. Image policies
+// can always be deleted.
+func (v *ImagePolicyValidator) ValidateDelete(ctx context.Context, obj runtime.Object) error {
+	return nil
+}
+
+func (v *ImagePolicyValidator) validate(obj runtime.Object) error {
+	switch o := obj.(type) {
+	case *imagev1.ImagePolicy:
+		return invalid(imagev1.ImagePolicyKind, obj, validateImagePolicy(o.Spec))
+	case *imagev1.ClusterImagePolicy:
+		return invalid(imagev1.ClusterImagePolicyKind, obj, validateClusterImagePolicy(o.Spec))
+	default:
+		return fmt.Errorf("expected an image policy, got %T", obj)
+	}
+}
+
+// validateImagePolicy returns what is invalid in the spec of the image
+// policy. A policy referring to a template may leave the ordering of
+// tags to it.
+func validateImagePolicy(spec imagev1.ImagePolicySpec) field.ErrorList {
+	path := field.NewPath("spec")
+	var errs field.ErrorList
+	if hasOrdering(spec.Policy) || spec.TemplateRef == nil {
+		if _, err := policy.PolicerFromSpec(spec.Policy); err != nil {
+			errs = append(errs, field.Invalid(path.Child("policy"), spec.Policy, err.Error()))
+		}
+	}
+	errs = append(errs, validateTagFilter(path.Child("filterTags"), spec.FilterTags)...)
+	if spec.LatestImageTemplate != "" {
+		if _, err := parseLatestImageTemplate(spec.LatestImageTemplate); err != nil {
+			errs = append(errs, field.Invalid(path.Child("latestImageTemplate"), spec.LatestImageTemplate, err.Error()))
+		} else if spec.Pin != nil && spec.Pin.Digest == "" && templateUsesDigest(spec.LatestImageTemplate) {
+			errs = append(errs, field.Required(path.Child("pin", "digest"), "the latest image template refers to the digest"))
+		}
+	}
+	for i, fw := range spec.FreezeWindows {
+		if _, err := parseWindow(fw.Schedule, fw.TimeZone, fw.Duration.Duration); err != nil {
+			errs = append(errs, field.Invalid(path.Child("freezeWindows").Index(i), fw.Schedule, err.Error()))
+		}
+	}
+	return errs
+}
+
+// validateClusterImagePolicy returns what is invalid in the policy rules
+// of the template.
+func validateClusterImagePolicy(spec imagev1.ClusterImagePolicySpec) field.ErrorList {
+	path := field.NewPath("spec")
+	var errs field.ErrorList
+	if spec.Policy != nil && hasOrdering(*spec.Policy) {
+		if _, err := policy.PolicerFromSpec(*spec.Policy); err != nil {
+			errs = append(errs, field.Invalid(path.Child("policy"), spec.Policy, err.Error()))
+		}
+	}
+	return append(errs, validateTagFilter(path.Child("filterTags"), spec.FilterTags)...)
+}
+
+// hasOrdering reports whether the policy choice gives an ordering of
+// tags.
+func hasOrdering(p imagev1.ImagePolicyChoice) bool {
+	return p.SemVer != nil || p.Alphabetical != nil || p.Numerical != nil || p.CalVer != nil || p.CreatedAt != nil
+}
+
+func validateTagFilter(path *field.Path, filter *imagev1.TagFilter) field.ErrorList {
+	if filter == nil {
+		return nil
+	}
+	if _, err := policy.NewRegexFilter(filter.Pattern, filter.Extract); err != nil {
+		return field.ErrorList{field.Invalid(path, filter, err.Error())}
+	}
+	return nil
+}
+
+// validateUpdate reports whether an update is to be validated: one that
+// changes the spec of an object not being deleted. Other updates, e.g.,
+// adding or removing the finalizer, are admitted whatever the spec, so
+// that objects stored before the spec was validated, or before the
+// validation was tightened, can still be reconciled and deleted.
+func validateUpdate(oldObj, newObj runtime.Object) bool {
+	if m, err := apimeta.Accessor(newObj); err == nil && m.GetDeletionTimestamp() != nil {
+		return false
+	}
+	return !equality.Semantic.DeepEqual(specOf(oldObj), specOf(newObj))
+}
+
+// specOf returns the spec of the object, or the object itself if it is
+// not of a kind validated.
+func specOf(obj runtime.Object) interface{} {
+	switch o := obj.(type) {
+	case *imagev1.ImageRepository:
+		return o.Spec
+	case *imagev1.ClusterImageRepository:
+		return o.Spec
+	case *imagev1.ImagePolicy:
+		return o.Spec
+	case *imagev1.ClusterImagePolicy:
+		return o.Spec
+	default:
+		return obj
+	}
+}
+
+// invalid returns the errors given as an Invalid API error for the
+// object, or nil if there are none.
+func invalid(kind string, obj runtime.Object, errs field.ErrorList) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	name := ""
+	if o, ok := obj.(client.Object); ok {
+		name = o.GetName()
+	}
+	return apierrors.NewInvalid(imagev1.GroupVersion.WithKind(kind).GroupKind(), name, errs)
+}
@@ -0,0 +1,453 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fluxcd/pkg/apis/meta"
+	. "github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/conversion"
+
+	imagev1alpha1 "github.com/fluxcd/image-reflector-controller/api/v1alpha1"
+	imagev1alpha2 "github.com/fluxcd/image-reflector-controller/api/v1alpha2"
+	imagev1 "github.com/fluxcd/image-reflector-controller/api/v1beta1"
+	imagev1beta2 "github.com/fluxcd/image-reflector-controller/api/v1beta2"
+)
+
+func TestImageRepositoryValidator(t *testing.T) {
+	tests := []struct {
+		name      string
+		spec      imagev1.ImageRepositorySpec
+		wantField string
+	}{
+		{
+			name: "valid",
+			spec: imagev1.ImageRepositorySpec{
+				Image:         "ghcr.io/org/image",
+				ExclusionList: []string{"^.*\\.sig$"},
+				Schedule:      &imagev1.ScanSchedule{Cron: "0 * * * *"},
+			},
+		},
+		{
+			name:      "image with scheme",
+			spec:      imagev1.ImageRepositorySpec{Image: "https://ghcr.io/org/image"},
+			wantField: "spec.image",
+		},
+		{
+			name:      "image with tag",
+			spec:      imagev1.ImageRepositorySpec{Image: "ghcr.io/org/image:v1"},
+			wantField: "spec.image",
+		},
+		{
+			name: "secret and secrets",
+			spec: imagev1.ImageRepositorySpec{
+				Image:      "ghcr.io/org/image",
+				SecretRef:  &meta.LocalObjectReference{Name: "token"},
+				SecretRefs: []meta.LocalObjectReference{{Name: "shared"}},
+			},
+			wantField: "spec.secretRefs",
+		},
+		{
+			name:      "exclusion not compiling",
+			spec:      imagev1.ImageRepositorySpec{Image: "ghcr.io/org/image", ExclusionList: []string{"^v1", "("}},
+			wantField: "spec.exclusionList[1]",
+		},
+		{
+			name:      "inclusion not compiling",
+			spec:      imagev1.ImageRepositorySpec{Image: "ghcr.io/org/image", InclusionList: []string{"[a-"}},
+			wantField: "spec.inclusionList[0]",
+		},
+		{
+			name:      "invalid schedule",
+			spec:      imagev1.ImageRepositorySpec{Image: "ghcr.io/org/image", Schedule: &imagev1.ScanSchedule{Cron: "0 * *"}},
+			wantField: "spec.schedule",
+		},
+		{
+			name: "invalid scan window time zone",
+			spec: imagev1.ImageRepositorySpec{Image: "ghcr.io/org/image", ScanWindows: []imagev1.ScanWindow{
+				{Schedule: "0 18 * * 5", TimeZone: "Nowhere/Special"},
+			}},
+			wantField: "spec.scanWindows[0]",
+		},
+	}
+
+	v := &ImageRepositoryValidator{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			repo := &imagev1.ImageRepository{
+				ObjectMeta: metav1.ObjectMeta{Name: "repo", Namespace: "default"},
+				Spec:       tt.spec,
+			}
+			clusterRepo := &imagev1.ClusterImageRepository{
+				ObjectMeta: metav1.ObjectMeta{Name: "repo"},
+				Spec:       imagev1.ClusterImageRepositorySpec{ImageRepositorySpec: tt.spec},
+			}
+			// An update to the spec is validated like a new object.
+			oldRepo := repo.DeepCopy()
+			oldRepo.Spec.Image = "ghcr.io/org/old"
+			for _, err := range []error{
+				v.ValidateCreate(context.TODO(), repo),
+				v.ValidateUpdate(context.TODO(), oldRepo, repo),
+				v.ValidateCreate(context.TODO(), clusterRepo),
+			} {
+				if tt.wantField == "" {
+					g.Expect(err).ToNot(HaveOccurred())
+					continue
+				}
+				g.Expect(apierrors.IsInvalid(err)).To(BeTrue())
+				g.Expect(err.Error()).To(ContainSubstring(tt.wantField))
+			}
+			g.Expect(v.ValidateDelete(context.TODO(), repo)).To(Succeed())
+		})
+	}
+}
+
+func TestImagePolicyValidator(t *testing.T) {
+	tests := []struct {
+		name      string
+		spec      imagev1.ImagePolicySpec
+		wantField string
+	}{
+		{
+			name: "valid",
+			spec: imagev1.ImagePolicySpec{
+				Policy:     imagev1.ImagePolicyChoice{SemVer: &imagev1.SemVerPolicy{Range: ">=1.0.0"}},
+				FilterTags: &imagev1.TagFilter{Pattern: "^v(?P<version>.*)$", Extract: "$version"},
+			},
+		},
+		{
+			name:      "no policy",
+			spec:      imagev1.ImagePolicySpec{},
+			wantField: "spec.policy",
+		},
+		{
+			name: "no policy with template",
+			spec: imagev1.ImagePolicySpec{TemplateRef: &meta.LocalObjectReference{Name: "template"}},
+		},
+		{
+			name:      "invalid semver range",
+			spec:      imagev1.ImagePolicySpec{Policy: imagev1.ImagePolicyChoice{SemVer: &imagev1.SemVerPolicy{Range: "not a range"}}},
+			wantField: "spec.policy",
+		},
+		{
+			name: "invalid filter pattern",
+			spec: imagev1.ImagePolicySpec{
+				Policy:     imagev1.ImagePolicyChoice{Alphabetical: &imagev1.AlphabeticalPolicy{}},
+				FilterTags: &imagev1.TagFilter{Pattern: "^v(?!1)"},
+			},
+			wantField: "spec.filterTags",
+		},
+		{
+			name: "invalid freeze window",
+			spec: imagev1.ImagePolicySpec{
+				Policy:        imagev1.ImagePolicyChoice{Alphabetical: &imagev1.AlphabeticalPolicy{}},
+				FreezeWindows: []imagev1.FreezeWindow{{Schedule: "every friday"}},
+			},
+			wantField: "spec.freezeWindows[0]",
+		},
+		{
+			name: "invalid latest image template",
+			spec: imagev1.ImagePolicySpec{
+				Policy:              imagev1.ImagePolicyChoice{Alphabetical: &imagev1.AlphabeticalPolicy{}},
+				LatestImageTemplate: "{{.Image}:{{.Tag}}",
+			},
+			wantField: "spec.latestImageTemplate",
+		},
+		{
+			name: "pin without the digest the template refers to",
+			spec: imagev1.ImagePolicySpec{
+				Policy:              imagev1.ImagePolicyChoice{Alphabetical: &imagev1.AlphabeticalPolicy{}},
+				LatestImageTemplate: "{{.Image}}:{{.Tag}}{{if .Digest}}@{{.Digest}}{{end}}",
+				Pin:                 &imagev1.ImagePin{Tag: "1.0.0"},
+			},
+			wantField: "spec.pin.digest",
+		},
+		{
+			name: "pin with a template not referring to the digest",
+			spec: imagev1.ImagePolicySpec{
+				Policy:              imagev1.ImagePolicyChoice{Alphabetical: &imagev1.AlphabeticalPolicy{}},
+				LatestImageTemplate: "mirror.example.com/{{.Repository}}:{{.Tag}}",
+				Pin:                 &imagev1.ImagePin{Tag: "1.0.0"},
+			},
+		},
+	}
+
+	v := &ImagePolicyValidator{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			pol := &imagev1.ImagePolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "policy", Namespace: "default"},
+				Spec:       tt.spec,
+			}
+			err := v.ValidateCreate(context.TODO(), pol)
+			if tt.wantField == "" {
+				g.Expect(err).ToNot(HaveOccurred())
+				return
+			}
+			g.Expect(apierrors.IsInvalid(err)).To(BeTrue())
+			g.Expect(err.Error()).To(ContainSubstring(tt.wantField))
+		})
+	}
+
+	// A template need not give an ordering, but one it gives must be
+	// valid.
+	g := NewWithT(t)
+	tmpl := &imagev1.ClusterImagePolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "template"},
+		Spec:       imagev1.ClusterImagePolicySpec{FilterTags: &imagev1.TagFilter{Pattern: "^main-"}},
+	}
+	g.Expect(v.ValidateCreate(context.TODO(), tmpl)).To(Succeed())
+	tmpl.Spec.Policy = &imagev1.ImagePolicyChoice{Numerical: &imagev1.NumericalPolicy{Order: "sideways"}}
+	g.Expect(apierrors.IsInvalid(v.ValidateCreate(context.TODO(), tmpl))).To(BeTrue())
+}
+
+func TestValidateUpdate_unchangedSpec(t *testing.T) {
+	g := NewWithT(t)
+
+	// Objects stored with a spec no longer valid, e.g., from before the
+	// webhook, can still have their metadata and finalizers changed,
+	// and be deleted.
+	repo := &imagev1.ImageRepository{
+		ObjectMeta: metav1.ObjectMeta{Name: "repo", Namespace: "default"},
+		Spec:       imagev1.ImageRepositorySpec{Image: "ghcr.io/org/image", ExclusionList: []string{"("}},
+	}
+	updated := repo.DeepCopy()
+	updated.Finalizers = []string{imagev1.ImageRepositoryFinalizer}
+	updated.Labels = map[string]string{"team": "apps"}
+	rv := &ImageRepositoryValidator{}
+	g.Expect(rv.ValidateUpdate(context.TODO(), repo, updated)).To(Succeed())
+
+	deleting := updated.DeepCopy()
+	deleting.Finalizers = nil
+	now := metav1.Now()
+	deleting.DeletionTimestamp = &now
+	g.Expect(rv.ValidateUpdate(context.TODO(), updated, deleting)).To(Succeed())
+
+	// A change to the spec leaving it invalid is refused.
+	changed := updated.DeepCopy()
+	changed.Spec.InclusionList = []string{"^v"}
+	g.Expect(apierrors.IsInvalid(rv.ValidateUpdate(context.TODO(), updated, changed))).To(BeTrue())
+
+	pol := &imagev1.ImagePolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "policy", Namespace: "default"},
+		Spec:       imagev1.ImagePolicySpec{Policy: imagev1.ImagePolicyChoice{SemVer: &imagev1.SemVerPolicy{Range: "not a range"}}},
+	}
+	updatedPol := pol.DeepCopy()
+	updatedPol.Annotations = map[string]string{meta.ReconcileRequestAnnotation: "now"}
+	pv := &ImagePolicyValidator{}
+	g.Expect(pv.ValidateUpdate(context.TODO(), pol, updatedPol)).To(Succeed())
+	updatedPol.Spec.Policy.SemVer.Range = "still not a range"
+	g.Expect(apierrors.IsInvalid(pv.ValidateUpdate(context.TODO(), pol, updatedPol))).To(BeTrue())
+}
+
+func TestImageRepositoryDefaulter(t *testing.T) {
+	d := &ImageRepositoryDefaulter{
+		Interval:      10 * time.Minute,
+		Timeout:       time.Minute,
+		ExclusionList: []string{"^.*\\.sig$", "^sha256-"},
+		Provider:      "generic",
+	}
+
+	tests := []struct {
+		name string
+		spec imagev1.ImageRepositorySpec
+		want imagev1.ImageRepositorySpec
+	}{
+		{
+			name: "nothing given",
+			spec: imagev1.ImageRepositorySpec{Image: "ghcr.io/org/image"},
+			want: imagev1.ImageRepositorySpec{
+				Image:         "ghcr.io/org/image",
+				Interval:      metav1.Duration{Duration: 10 * time.Minute},
+				Timeout:       &metav1.Duration{Duration: time.Minute},
+				ExclusionList: []string{"^.*\\.sig$", "^sha256-"},
+				Provider:      &imagev1.RegistryProvider{Name: "generic"},
+			},
+		},
+		{
+			name: "all given",
+			spec: imagev1.ImageRepositorySpec{
+				Image:         "ghcr.io/org/image",
+				Interval:      metav1.Duration{Duration: time.Hour},
+				Timeout:       &metav1.Duration{Duration: 5 * time.Minute},
+				ExclusionList: []string{},
+				Provider:      &imagev1.RegistryProvider{Exec: &imagev1.ExecProvider{Helper: "example"}},
+			},
+			want: imagev1.ImageRepositorySpec{
+				Image:         "ghcr.io/org/image",
+				Interval:      metav1.Duration{Duration: time.Hour},
+				Timeout:       &metav1.Duration{Duration: 5 * time.Minute},
+				ExclusionList: []string{},
+				Provider:      &imagev1.RegistryProvider{Exec: &imagev1.ExecProvider{Helper: "example"}},
+			},
+		},
+		{
+			name: "schedule given",
+			spec: imagev1.ImageRepositorySpec{
+				Image:    "ghcr.io/org/image",
+				Schedule: &imagev1.ScanSchedule{Cron: "0 * * * *"},
+				Provider: &imagev1.RegistryProvider{Name: "aws"},
+			},
+			want: imagev1.ImageRepositorySpec{
+				Image:         "ghcr.io/org/image",
+				Schedule:      &imagev1.ScanSchedule{Cron: "0 * * * *"},
+				Timeout:       &metav1.Duration{Duration: time.Minute},
+				ExclusionList: []string{"^.*\\.sig$", "^sha256-"},
+				Provider:      &imagev1.RegistryProvider{Name: "aws"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			repo := &imagev1.ImageRepository{Spec: *tt.spec.DeepCopy()}
+			g.Expect(d.Default(context.TODO(), repo)).To(Succeed())
+			g.Expect(repo.Spec).To(Equal(tt.want))
+
+			clusterRepo := &imagev1.ClusterImageRepository{
+				Spec: imagev1.ClusterImageRepositorySpec{ImageRepositorySpec: *tt.spec.DeepCopy()},
+			}
+			g.Expect(d.Default(context.TODO(), clusterRepo)).To(Succeed())
+			g.Expect(clusterRepo.Spec.ImageRepositorySpec).To(Equal(tt.want))
+		})
+	}
+
+	// Without defaults, nothing is set.
+	g := NewWithT(t)
+	repo := &imagev1.ImageRepository{Spec: imagev1.ImageRepositorySpec{Image: "ghcr.io/org/image"}}
+	g.Expect((&ImageRepositoryDefaulter{}).Default(context.TODO(), repo)).To(Succeed())
+	g.Expect(repo.Spec).To(Equal(imagev1.ImageRepositorySpec{Image: "ghcr.io/org/image"}))
+}
+
+func TestConversion(t *testing.T) {
+	g := NewWithT(t)
+
+	scheme := runtime.NewScheme()
+	g.Expect(imagev1alpha1.AddToScheme(scheme)).To(Succeed())
+	g.Expect(imagev1alpha2.AddToScheme(scheme)).To(Succeed())
+	g.Expect(imagev1.AddToScheme(scheme)).To(Succeed())
+	g.Expect(imagev1beta2.AddToScheme(scheme)).To(Succeed())
+	for _, obj := range []runtime.Object{&imagev1.ImageRepository{}, &imagev1.ImagePolicy{}} {
+		ok, err := conversion.IsConvertible(scheme, obj)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(ok).To(BeTrue())
+	}
+
+	// The fields v1alpha2 does not have are restored when an object is
+	// converted back, with the changes made to those it has.
+	repo := &imagev1.ImageRepository{
+		ObjectMeta: metav1.ObjectMeta{Name: "repo", Namespace: "default"},
+		Spec: imagev1.ImageRepositorySpec{
+			Image:         "ghcr.io/org/image",
+			Interval:      metav1.Duration{Duration: time.Hour},
+			ExclusionList: []string{"^.*\\.sig$"},
+			Provider:      &imagev1.RegistryProvider{Name: "generic"},
+		},
+		Status: imagev1.ImageRepositoryStatus{CanonicalImageName: "ghcr.io/org/image"},
+	}
+	var alphaRepo imagev1alpha2.ImageRepository
+	g.Expect(alphaRepo.ConvertFrom(repo)).To(Succeed())
+	g.Expect(alphaRepo.Spec.Image).To(Equal(repo.Spec.Image))
+	g.Expect(alphaRepo.Annotations).To(HaveKey(imagev1.ConversionDataAnnotation))
+	g.Expect(repo.Annotations).To(BeNil())
+	alphaRepo.Spec.Image = "ghcr.io/org/other"
+
+	var gotRepo imagev1.ImageRepository
+	g.Expect(alphaRepo.ConvertTo(&gotRepo)).To(Succeed())
+	wantRepo := repo.DeepCopy()
+	wantRepo.Spec.Image = "ghcr.io/org/other"
+	g.Expect(gotRepo).To(Equal(*wantRepo))
+
+	pol := &imagev1.ImagePolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "policy", Namespace: "default"},
+		Spec: imagev1.ImagePolicySpec{
+			ImageRepositoryRef: meta.NamespacedObjectReference{Name: "repo", Namespace: "images"},
+			Policy: imagev1.ImagePolicyChoice{
+				SemVer:   &imagev1.SemVerPolicy{Range: "1.x", VPrefix: imagev1.VPrefixStrip},
+				SoakTime: &metav1.Duration{Duration: time.Hour},
+			},
+			PreventDowngrade: true,
+		},
+		Status: imagev1.ImagePolicyStatus{LatestImage: "ghcr.io/org/image:1.0.0"},
+	}
+	var alphaPol imagev1alpha1.ImagePolicy
+	g.Expect(alphaPol.ConvertFrom(pol)).To(Succeed())
+	g.Expect(alphaPol.Spec.Policy.SemVer).To(Equal(&imagev1alpha1.SemVerPolicy{Range: "1.x"}))
+	alphaPol.Spec.Policy.SemVer.Range = "2.x"
+
+	var gotPol imagev1.ImagePolicy
+	g.Expect(alphaPol.ConvertTo(&gotPol)).To(Succeed())
+	wantPol := pol.DeepCopy()
+	wantPol.Spec.Policy.SemVer.Range = "2.x"
+	g.Expect(gotPol).To(Equal(*wantPol))
+
+	// A policy given in v1alpha1 replaces the one restored.
+	g.Expect(alphaPol.ConvertFrom(pol)).To(Succeed())
+	alphaPol.Spec.Policy = imagev1alpha1.ImagePolicyChoice{Alphabetical: &imagev1alpha1.AlphabeticalPolicy{Order: "desc"}}
+	g.Expect(alphaPol.ConvertTo(&gotPol)).To(Succeed())
+	g.Expect(gotPol.Spec.Policy).To(Equal(imagev1.ImagePolicyChoice{
+		Alphabetical: &imagev1.AlphabeticalPolicy{Order: "desc"},
+		SoakTime:     &metav1.Duration{Duration: time.Hour},
+	}))
+
+	// The status fields v1alpha1 does not have survive a round trip too.
+	scanTime := metav1.Unix(1700000000, 0)
+	repo.Status = imagev1.ImageRepositoryStatus{
+		CanonicalImageName: "ghcr.io/org/image",
+		LastScanResult: &imagev1.ScanResult{
+			TagCount:   2,
+			ScanTime:   scanTime,
+			Requests:   3,
+			LatestTags: []string{"1.0.1", "1.0.0"},
+		},
+		ScanCursor: "1.0.0",
+		Backoff:    &imagev1.ScanBackoff{Failures: 1, Delay: metav1.Duration{Duration: time.Minute}, LastFailureTime: scanTime},
+	}
+	var alpha1Repo imagev1alpha1.ImageRepository
+	g.Expect(alpha1Repo.ConvertFrom(repo)).To(Succeed())
+	g.Expect(alpha1Repo.Status.LastScanResult).To(Equal(&imagev1alpha1.ScanResult{TagCount: 2, ScanTime: scanTime}))
+	g.Expect(alpha1Repo.ConvertTo(&gotRepo)).To(Succeed())
+	g.Expect(gotRepo).To(Equal(*repo))
+
+	pol.Status = imagev1.ImagePolicyStatus{
+		LatestImage:  "ghcr.io/org/image:1.0.0",
+		LatestTag:    "1.0.0",
+		LatestDigest: "sha256:0123",
+		History: []imagev1.ImageSelection{
+			{Image: "ghcr.io/org/image:1.0.0", Tag: "1.0.0", SelectedTime: scanTime},
+		},
+	}
+	g.Expect(alphaPol.ConvertFrom(pol)).To(Succeed())
+	g.Expect(alphaPol.ConvertTo(&gotPol)).To(Succeed())
+	g.Expect(gotPol).To(Equal(*pol))
+
+	// A spec kept without the status, as earlier, is still restored.
+	g.Expect(alpha1Repo.ConvertFrom(repo)).To(Succeed())
+	alpha1Repo.Annotations[imagev1.ConversionDataAnnotation] = `{"image":"ghcr.io/org/image","exclusionList":["^v"]}`
+	g.Expect(alpha1Repo.ConvertTo(&gotRepo)).To(Succeed())
+	g.Expect(gotRepo.Spec.ExclusionList).To(Equal([]string{"^v"}))
+	g.Expect(gotRepo.Status.ScanCursor).To(BeEmpty())
+}
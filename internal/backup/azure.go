@@ -0,0 +1,113 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+)
+
+const (
+	// azureStorageScope is the scope of access tokens for Azure Storage.
+	azureStorageScope = "https://storage.azure.com/.default"
+	// azureStorageVersion is the version of the Blob service REST API
+	// used, the first to allow a blob of up to 5000 MiB in one request.
+	azureStorageVersion = "2019-12-12"
+)
+
+// azureStore is a blob in an Azure Blob Storage container.
+type azureStore struct {
+	// endpoint is the blob service of the storage account.
+	endpoint   string
+	credential azcore.TokenCredential
+	container  string
+	blob       string
+}
+
+func newAzureStore(account, container, blob string) *azureStore {
+	return &azureStore{
+		endpoint:  fmt.Sprintf("https://%s.blob.core.windows.net", account),
+		container: container,
+		blob:      blob,
+	}
+}
+
+func (s *azureStore) Put(ctx context.Context, r io.Reader, size int64) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.url(), r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("x-ms-blob-type", "BlockBlob")
+	res, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return checkResponse(res)
+}
+
+func (s *azureStore) Get(ctx context.Context) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url(), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponse(res); err != nil {
+		res.Body.Close()
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+func (s *azureStore) url() string {
+	return fmt.Sprintf("%s/%s/%s", s.endpoint, url.PathEscape(s.container), (&url.URL{Path: s.blob}).EscapedPath())
+}
+
+// do sends the request with an access token of the default credential.
+func (s *azureStore) do(req *http.Request) (*http.Response, error) {
+	// NOTE: NewDefaultAzureCredential() performs a lot of environment
+	// lookup for creating default token credential. Load it only when
+	// it's needed.
+	if s.credential == nil {
+		cred, err := azidentity.NewDefaultAzureCredential(nil)
+		if err != nil {
+			return nil, err
+		}
+		s.credential = cred
+	}
+	token, err := s.credential.GetToken(req.Context(), policy.TokenRequestOptions{
+		Scopes: []string{azureStorageScope},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get an access token: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token.Token)
+	req.Header.Set("x-ms-version", azureStorageVersion)
+	return http.DefaultClient.Do(req)
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+const (
+	// gcsURL is the endpoint of the Google Cloud Storage JSON API.
+	gcsURL = "https://storage.googleapis.com"
+	// gcpTokenURL is the endpoint of the GCP metadata service giving the
+	// access token of the service account of the instance, and so of its
+	// workload identity.
+	gcpTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+)
+
+// gcsStore is an object in a Google Cloud Storage bucket.
+type gcsStore struct {
+	endpoint string
+	tokenURL string
+	bucket   string
+	object   string
+}
+
+func newGCSStore(bucket, object string) *gcsStore {
+	return &gcsStore{endpoint: gcsURL, tokenURL: gcpTokenURL, bucket: bucket, object: object}
+}
+
+func (s *gcsStore) Put(ctx context.Context, r io.Reader, size int64) error {
+	u := fmt.Sprintf("%s/upload/storage/v1/b/%s/o?uploadType=media&name=%s",
+		s.endpoint, url.PathEscape(s.bucket), url.QueryEscape(s.object))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	res, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return checkResponse(res)
+}
+
+func (s *gcsStore) Get(ctx context.Context) (io.ReadCloser, error) {
+	u := fmt.Sprintf("%s/storage/v1/b/%s/o/%s?alt=media",
+		s.endpoint, url.PathEscape(s.bucket), url.PathEscape(s.object))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponse(res); err != nil {
+		res.Body.Close()
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+// do sends the request with an access token from the metadata service.
+func (s *gcsStore) do(req *http.Request) (*http.Response, error) {
+	tokenReq, err := http.NewRequestWithContext(req.Context(), http.MethodGet, s.tokenURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	tokenReq.Header.Set("Metadata-Flavor", "Google")
+	res, err := http.DefaultClient.Do(tokenReq)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if err := checkResponse(res); err != nil {
+		return nil, fmt.Errorf("failed to get an access token: %w", err)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	return http.DefaultClient.Do(req)
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package backup stores backups of the database in object storage.
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ErrNotFound is returned by Store.Get when there is no backup.
+var ErrNotFound = errors.New("backup not found")
+
+// Store is the object in object storage holding a backup.
+type Store interface {
+	// Put replaces the backup with the size bytes read from r.
+	Put(ctx context.Context, r io.Reader, size int64) error
+	// Get returns the backup, which the caller must close, or
+	// ErrNotFound if there is none.
+	Get(ctx context.Context) (io.ReadCloser, error)
+}
+
+// NewStore returns the store of the object at the URL, which is one of:
+//
+//	s3://<bucket>/<key>[?region=<region>&endpoint=<endpoint>]
+//	gs://<bucket>/<object>
+//	azblob://<account>/<container>/<blob>
+//
+// Credentials are found in the environment, as by the SDK or metadata
+// service of each provider.
+func NewStore(rawURL string) (Store, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup URL '%s': %w", rawURL, err)
+	}
+	path := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || path == "" {
+		return nil, fmt.Errorf("invalid backup URL '%s': expected a bucket and an object name", rawURL)
+	}
+
+	switch u.Scheme {
+	case "s3":
+		return newS3Store(u.Host, path, u.Query().Get("region"), u.Query().Get("endpoint"))
+	case "gs":
+		return newGCSStore(u.Host, path), nil
+	case "azblob":
+		container, blob, ok := strings.Cut(path, "/")
+		if !ok || blob == "" {
+			return nil, fmt.Errorf("invalid backup URL '%s': expected an account, a container and a blob name", rawURL)
+		}
+		return newAzureStore(u.Host, container, blob), nil
+	}
+	return nil, fmt.Errorf("invalid backup URL '%s': unknown scheme '%s', expected one of: s3, gs, azblob", rawURL, u.Scheme)
+}
+
+// checkResponse returns an error for an unsuccessful response, which is
+// ErrNotFound for a 404.
+func checkResponse(res *http.Response) error {
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return nil
+	}
+	if res.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+	return fmt.Errorf("unexpected status %s from %s: %s", res.Status, res.Request.URL.Redacted(), strings.TrimSpace(string(body)))
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/fluxcd/image-reflector-controller/internal/registry/azure"
+)
+
+// objectServer serves objects put to it, at their paths, to requests
+// with the token expected.
+type objectServer struct {
+	mu      sync.Mutex
+	token   string
+	objects map[string][]byte
+}
+
+func newObjectServer(t *testing.T, token string, paths func(r *http.Request) string) *httptest.Server {
+	s := &objectServer{token: token, objects: map[string][]byte{}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token != "" && r.Header.Get("Authorization") != "Bearer "+token {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		path := paths(r)
+		switch r.Method {
+		case http.MethodPut, http.MethodPost:
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			s.objects[path] = body
+		case http.MethodGet:
+			body, ok := s.objects[path]
+			if !ok {
+				w.Header().Set("Content-Type", "application/xml")
+				w.WriteHeader(http.StatusNotFound)
+				io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code></Error>`)
+				return
+			}
+			w.Write(body)
+		default:
+			http.Error(w, "unexpected method", http.StatusMethodNotAllowed)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestNewStore(t *testing.T) {
+	tests := []struct {
+		url     string
+		wantErr string
+	}{
+		{url: "s3://bucket/path/to/backup?region=eu-west-1"},
+		{url: "gs://bucket/path/to/backup"},
+		{url: "azblob://account/container/path/to/backup"},
+		{url: "s3://bucket", wantErr: "expected a bucket and an object name"},
+		{url: "azblob://account/container", wantErr: "expected an account, a container and a blob name"},
+		{url: "ftp://host/backup", wantErr: "unknown scheme 'ftp'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			g := NewWithT(t)
+			_, err := NewStore(tt.url)
+			if tt.wantErr != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tt.wantErr)))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+		})
+	}
+}
+
+func TestStores(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "id")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	tests := []struct {
+		name  string
+		store func(t *testing.T) Store
+	}{
+		{
+			name: "s3",
+			store: func(t *testing.T) Store {
+				srv := newObjectServer(t, "", func(r *http.Request) string {
+					return r.URL.Path
+				})
+				store, err := NewStore("s3://bucket/path/to/backup?region=us-east-1&endpoint=" + srv.URL)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return store
+			},
+		},
+		{
+			name: "gcs",
+			store: func(t *testing.T) Store {
+				tokenSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					io.WriteString(w, `{"access_token": "gcs-token", "expires_in": 3600, "token_type": "Bearer"}`)
+				}))
+				t.Cleanup(tokenSrv.Close)
+				srv := newObjectServer(t, "gcs-token", func(r *http.Request) string {
+					if name := r.URL.Query().Get("name"); name != "" {
+						return "/storage/v1/b/bucket/o/" + name
+					}
+					return r.URL.Path
+				})
+				store := newGCSStore("bucket", "path/to/backup")
+				store.endpoint, store.tokenURL = srv.URL, tokenSrv.URL
+				return store
+			},
+		},
+		{
+			name: "azure",
+			store: func(t *testing.T) Store {
+				srv := newObjectServer(t, "azure-token", func(r *http.Request) string {
+					return r.URL.Path
+				})
+				store := newAzureStore("account", "container", "path/to/backup")
+				store.endpoint = srv.URL
+				store.credential = &azure.FakeTokenCredential{Token: "azure-token"}
+				return store
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			store := tt.store(t)
+			ctx := context.Background()
+
+			_, err := store.Get(ctx)
+			g.Expect(errors.Is(err, ErrNotFound)).To(BeTrue(), "expected ErrNotFound, got %v", err)
+
+			for _, content := range []string{"backup", "a later backup"} {
+				g.Expect(store.Put(ctx, strings.NewReader(content), int64(len(content)))).To(Succeed())
+				r, err := store.Get(ctx)
+				g.Expect(err).ToNot(HaveOccurred())
+				var got bytes.Buffer
+				_, err = io.Copy(&got, r)
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(r.Close()).To(Succeed())
+				g.Expect(got.String()).To(Equal(content))
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package database
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	flag "github.com/spf13/pflag"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// Database is what a backend stores about image repositories: their tags,
+// and the metadata of the images the tags refer to. It has the methods of
+// all the database interfaces of the controllers.
+type Database interface {
+	Tags(repo string) ([]string, error)
+	SetTags(repo string, tags []string) error
+	PartialTags(repo string) ([]string, error)
+	SetPartialTags(repo string, tags []string) error
+	CreationTime(repo, tag string) (time.Time, bool, error)
+	SetCreationTime(repo, tag string, created time.Time) error
+	FirstSeen(repo string) (map[string]time.Time, bool, error)
+	SetFirstSeen(repo string, firstSeen map[string]time.Time) error
+	SetScanResult(repo string, tags []string, firstSeen map[string]time.Time) error
+	LastSeen(repo string) (map[string]time.Time, bool, error)
+	SetLastSeen(repo string, lastSeen map[string]time.Time) error
+	TagListValidators(repo string) ([]byte, bool, error)
+	SetTagListValidators(repo string, validators []byte) error
+	Platforms(repo, tag string) (map[string]string, bool, error)
+	SetPlatforms(repo, tag string, platforms map[string]string) error
+	ImageConfig(repo, tag string) ([]byte, bool, error)
+	SetImageConfig(repo, tag string, config []byte) error
+	Descriptor(repo, tag string) (v1.Descriptor, bool, error)
+	SetDescriptor(repo, tag string, desc v1.Descriptor) error
+	Provenance(repo, tag string) ([]string, bool, error)
+	SetProvenance(repo, tag string, builders []string) error
+	DeleteRepository(repo string) error
+	Repositories() ([]string, error)
+}
+
+// Backend is a kind of database that can be selected with the
+// --db-backend flag. Backends register themselves with Register, so that
+// adding one needs no change to the controllers or to main.
+type Backend interface {
+	// BindFlags binds the flags configuring the backend.
+	BindFlags(fs *flag.FlagSet)
+	// Open opens the database, returning a function to close it.
+	Open(opts Options) (Database, func() error, error)
+}
+
+var (
+	backendsMu sync.Mutex
+	backends   = map[string]Backend{}
+)
+
+// Register makes the backend available by the name given. It panics if a
+// backend of that name has already been registered.
+func Register(name string, backend Backend) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	if _, ok := backends[name]; ok {
+		panic(fmt.Sprintf("database backend '%s' registered twice", name))
+	}
+	backends[name] = backend
+}
+
+// Backends returns the names of the backends registered, sorted.
+func Backends() []string {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sharedBackend is implemented by backends whose databases are served,
+// rather than kept in files, and so can be shared by the replicas of the
+// controller.
+type sharedBackend interface {
+	Shared() bool
+}
+
+// Options select and configure the database backend.
+type Options struct {
+	// Backend is the name of the backend to use.
+	Backend string
+	// StoragePath is the directory under which backends keeping the
+	// database in files store it.
+	StoragePath string
+	// Shared is whether the database is shared by the replicas of the
+	// controller, which the backend must support.
+	Shared bool
+	// MigrateFrom is the name of a backend the controller used before,
+	// from which to copy the records of the repositories the database
+	// opened has none for.
+	MigrateFrom string
+}
+
+// BindFlags binds the flags selecting the backend, and those of each
+// backend registered.
+func (o *Options) BindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.Backend, "db-backend", "badger",
+		fmt.Sprintf("The database of image metadata to use, one of: %s.", strings.Join(Backends(), ", ")))
+	fs.StringVar(&o.StoragePath, "storage-path", "/data", "Where to store the persistent database of image metadata")
+	fs.BoolVar(&o.Shared, "shared-database", false, "Share the database between the replicas of the controller, so that a replica elected leader carries on from the tags and metadata recorded by the previous leader, rather than from a database of its own. The database backend must be served, e.g., redis or postgres.")
+	fs.StringVar(&o.MigrateFrom, "db-migrate-from", "", "The database backend used before --db-backend was changed. When opening the database, the records of the image repositories it has none for are copied from the database of this backend, configured by its flags as before.")
+
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	for _, backend := range backends {
+		backend.BindFlags(fs)
+	}
+}
+
+// Open opens the database of the backend selected, returning a function
+// to close it.
+func (o Options) Open() (Database, func() error, error) {
+	backendsMu.Lock()
+	backend, ok := backends[o.Backend]
+	backendsMu.Unlock()
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown database backend '%s', expected one of: %s", o.Backend, strings.Join(Backends(), ", "))
+	}
+	if shared, ok := backend.(sharedBackend); o.Shared && !(ok && shared.Shared()) {
+		return nil, nil, fmt.Errorf("the %s database cannot be shared between replicas", o.Backend)
+	}
+	db, closeDB, err := backend.Open(o)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open the %s database: %w", o.Backend, err)
+	}
+	if o.MigrateFrom != "" {
+		if err := o.migrate(db); err != nil {
+			closeDB()
+			return nil, nil, err
+		}
+	}
+	return db, closeDB, nil
+}
+
+// migrate copies into the database the records of the repositories it
+// has none for from the database of the backend MigrateFrom.
+func (o Options) migrate(db Database) error {
+	if o.MigrateFrom == o.Backend {
+		return fmt.Errorf("cannot migrate the %s database from itself", o.Backend)
+	}
+	from, closeFrom, err := Options{Backend: o.MigrateFrom, StoragePath: o.StoragePath}.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open the database to migrate from: %w", err)
+	}
+	defer closeFrom()
+
+	copied, err := CopyRepositories(db, from)
+	if err != nil {
+		return fmt.Errorf("failed to migrate from the %s database: %w", o.MigrateFrom, err)
+	}
+	if copied > 0 {
+		ctrl.Log.WithName("database").Info("migrated the records of image repositories", "from", o.MigrateFrom, "to", o.Backend, "repositories", copied)
+	}
+	return nil
+}
@@ -16,13 +16,305 @@ limitations under the License.
 package database
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/dgraph-io/badger/v3"
+	"github.com/dgraph-io/badger/v3/options"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	flag "github.com/spf13/pflag"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"github.com/fluxcd/image-reflector-controller/internal/backup"
+)
+
+const (
+	tagsPrefix        = "tags"
+	partialTagsPrefix = "partial-tags"
+	createdPrefix     = "created"
+	firstSeenPrefix   = "first-seen"
+	lastSeenPrefix    = "last-seen"
+	platformsPrefix   = "platforms"
+	configPrefix      = "config"
+	descriptorPrefix  = "descriptor"
+	validatorsPrefix  = "tag-list-validators"
+	provenancePrefix  = "provenance"
 )
 
-const tagsPrefix = "tags"
+func init() {
+	Register("badger", &badgerBackend{
+		valueLogFileSize: 1 << 28,
+		gcInterval:       10 * time.Minute,
+		gcDiscardRatio:   0.5,
+		compression:      "snappy",
+		backupInterval:   time.Hour,
+		recoverCorrupt:   true,
+	})
+}
+
+// corruptBadgerDir is the directory under the storage path to which the
+// files of a database that cannot be opened are moved.
+const corruptBadgerDir = "corrupt"
+
+// badgerBackend opens a Badger database under the storage path, and
+// garbage collects its value log while it is open. If given a backup URL,
+// it restores an empty database from the backup there, and backs up the
+// database to it periodically and when closed. A database that cannot be
+// opened is moved aside, and an empty one opened in its place, unless
+// recovery is disabled.
+type badgerBackend struct {
+	valueLogFileSize int64
+	gcInterval       time.Duration
+	gcDiscardRatio   float64
+	compression      string
+	backupURL        string
+	backupInterval   time.Duration
+	recoverCorrupt   bool
+}
+
+func (b *badgerBackend) BindFlags(fs *flag.FlagSet) {
+	fs.Int64Var(&b.valueLogFileSize, "storage-value-log-file-size", b.valueLogFileSize, "Set the database's memory mapped value log file size in bytes. Effective memory usage is about two times this size.")
+	fs.DurationVar(&b.gcInterval, "storage-gc-interval", b.gcInterval, "How often to garbage collect the value log of the Badger database, reclaiming the space of values overwritten since. Set to 0 to disable garbage collection.")
+	fs.Float64Var(&b.gcDiscardRatio, "storage-gc-discard-ratio", b.gcDiscardRatio, "The fraction of a value log file of the Badger database that must be discardable for garbage collection to rewrite it, greater than 0 and less than 1.")
+	fs.StringVar(&b.compression, "storage-compression", b.compression, "The compression of the Badger database, one of: none, snappy, zstd.")
+	fs.StringVar(&b.backupURL, "storage-backup-url", "", "The URL of an object to back up the Badger database to, and restore an empty database from on startup, one of: s3://<bucket>/<key>[?region=<region>&endpoint=<endpoint>], gs://<bucket>/<object>, azblob://<account>/<container>/<blob>.")
+	fs.DurationVar(&b.backupInterval, "storage-backup-interval", b.backupInterval, "How often to back up the Badger database to the backup URL. Set to 0 to back up only on shutdown.")
+	fs.BoolVar(&b.recoverCorrupt, "storage-recover", b.recoverCorrupt, "Move a Badger database that cannot be opened, e.g., because its files are corrupt, aside to the directory 'corrupt' under the storage path, and start with an empty database rather than failing. An empty database is restored from the backup URL, if given.")
+}
+
+func (b *badgerBackend) Open(opts Options) (Database, func() error, error) {
+	compression, err := badgerCompression(b.compression)
+	if err != nil {
+		return nil, nil, err
+	}
+	if b.gcDiscardRatio <= 0 || b.gcDiscardRatio >= 1 {
+		return nil, nil, fmt.Errorf("invalid value log GC discard ratio %v, must be greater than 0 and less than 1", b.gcDiscardRatio)
+	}
+	var store backup.Store
+	if b.backupURL != "" {
+		if store, err = backup.NewStore(b.backupURL); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	badgerOpts := badger.DefaultOptions(opts.StoragePath)
+	badgerOpts.ValueLogFileSize = b.valueLogFileSize
+	badgerOpts.Compression = compression
+	log := ctrl.Log.WithName("badger")
+	db, err := openBadger(badgerOpts)
+	// The database is locked by another process, rather than corrupt,
+	// if the lock cannot be acquired; Badger gives no error to check
+	// for.
+	if err != nil && b.recoverCorrupt && !strings.Contains(err.Error(), "Cannot acquire directory lock") {
+		aside, moveErr := moveBadgerAside(opts.StoragePath)
+		if moveErr != nil {
+			return nil, nil, fmt.Errorf("%w (and unable to move the database aside: %v)", err, moveErr)
+		}
+		log.Error(err, "unable to open the database; it has been moved aside, and an empty database opened in its place", "path", aside)
+		db, err = openBadger(badgerOpts)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// A restore is attempted only while the database is empty, so that
+	// nothing recorded since the backup is lost; the restore saves
+	// rescanning every image repository.
+	if store != nil {
+		if restored, err := restoreBadger(context.Background(), db, store); err != nil {
+			log.Error(err, "unable to restore the database from the backup", "url", b.backupURL)
+		} else if restored {
+			log.Info("restored the database from the backup", "url", b.backupURL)
+		}
+	}
+	// The database is migrated after any restore, since a backup may be
+	// of an earlier schema.
+	if err := migrateBadger(db, badgerMigrations); err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+
+	stop := make(chan struct{})
+	gcDone := runEvery(stop, b.gcInterval, func() {
+		if err := runValueLogGC(db, b.gcDiscardRatio); err != nil {
+			log.Error(err, "value log garbage collection failed")
+		}
+	})
+	backupDB := func() {
+		if store == nil {
+			return
+		}
+		if err := backupBadger(context.Background(), db, store); err != nil {
+			log.Error(err, "unable to back up the database", "url", b.backupURL)
+		}
+	}
+	backupDone := runEvery(stop, b.backupInterval, backupDB)
+	closeDB := func() error {
+		close(stop)
+		<-gcDone
+		<-backupDone
+		backupDB()
+		return db.Close()
+	}
+	return NewBadgerDatabase(db), closeDB, nil
+}
+
+// openBadger opens the database, and verifies the checksums of its
+// tables. Badger truncates a value log or write-ahead log left incomplete
+// by an unclean shutdown as it opens the database; other corruption is
+// returned as an error.
+func openBadger(opts badger.Options) (*badger.DB, error) {
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.VerifyChecksum(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to verify the checksums of the database: %w", err)
+	}
+	return db, nil
+}
+
+// moveBadgerAside moves the files of the database in the directory to
+// corruptBadgerDir under it, replacing those moved there before, and
+// returns the path they were moved to. The files are moved within the
+// directory, since it may be the root of a volume, and kept so that they
+// can be looked at.
+func moveBadgerAside(dir string) (string, error) {
+	aside := filepath.Join(dir, corruptBadgerDir)
+	if err := os.RemoveAll(aside); err != nil {
+		return "", err
+	}
+	if err := os.Mkdir(aside, 0o700); err != nil {
+		return "", err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if err := os.Rename(filepath.Join(dir, entry.Name()), filepath.Join(aside, entry.Name())); err != nil {
+			return "", err
+		}
+	}
+	return aside, nil
+}
+
+// runEvery calls fn each interval until stop is closed, returning a
+// channel closed once it has stopped. If the interval is not positive,
+// fn is never called.
+func runEvery(stop <-chan struct{}, interval time.Duration, fn func()) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if interval <= 0 {
+			return
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				fn()
+			}
+		}
+	}()
+	return done
+}
+
+// backupBadger writes a full backup of the database to the store. The
+// backup is written to a temporary file first, so that it is uploaded
+// with a known size.
+func backupBadger(ctx context.Context, db *badger.DB, store backup.Store) error {
+	f, err := os.CreateTemp("", "badger-backup-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if _, err := db.Backup(f, 0); err != nil {
+		return err
+	}
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return store.Put(ctx, f, size)
+}
+
+// restoreBadger loads the backup in the store into the database, if the
+// database is empty and there is a backup, and reports whether it did.
+func restoreBadger(ctx context.Context, db *badger.DB, store backup.Store) (bool, error) {
+	empty := true
+	err := db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{})
+		defer it.Close()
+		it.Rewind()
+		empty = !it.Valid()
+		return nil
+	})
+	if err != nil || !empty {
+		return false, err
+	}
+
+	r, err := store.Get(ctx)
+	if errors.Is(err, backup.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+	if err := db.Load(r, 256); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// badgerCompression returns the compression type of the name given.
+func badgerCompression(name string) (options.CompressionType, error) {
+	switch name {
+	case "none":
+		return options.None, nil
+	case "snappy":
+		return options.Snappy, nil
+	case "zstd":
+		return options.ZSTD, nil
+	}
+	return options.None, fmt.Errorf("unknown compression '%s', expected one of: none, snappy, zstd", name)
+}
+
+// runValueLogGC garbage collects the value log of the database, rewriting
+// files for as long as there are any with enough to discard.
+func runValueLogGC(db *badger.DB, discardRatio float64) error {
+	for {
+		err := db.RunValueLogGC(discardRatio)
+		if errors.Is(err, badger.ErrNoRewrite) || errors.Is(err, badger.ErrRejected) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
 
 // BadgerDatabase provides implementations of the tags database based on Badger.
 type BadgerDatabase struct {
@@ -44,7 +336,7 @@ func (a *BadgerDatabase) Tags(repo string) ([]string, error) {
 	var tags []string
 	err := a.db.View(func(txn *badger.Txn) error {
 		var err error
-		tags, err = getOrEmpty(txn, repo)
+		tags, err = getOrEmpty(txn, tagsPrefix, repo)
 		return err
 	})
 	return tags, err
@@ -65,12 +357,471 @@ func (a *BadgerDatabase) SetTags(repo string, tags []string) error {
 	})
 }
 
+// PartialTags implements the PartialScanStore interface, fetching the tags
+// recorded so far by an incomplete scan of the repo.
+//
+// If there is no incomplete scan of the repo, an empty set of tags is
+// returned.
+func (a *BadgerDatabase) PartialTags(repo string) ([]string, error) {
+	var tags []string
+	err := a.db.View(func(txn *badger.Txn) error {
+		var err error
+		tags, err = getOrEmpty(txn, partialTagsPrefix, repo)
+		return err
+	})
+	return tags, err
+}
+
+// SetPartialTags implements the PartialScanStore interface, recording the
+// tags fetched so far by an incomplete scan of the repo.
+//
+// An empty set of tags removes the record, e.g., once the scan is complete.
+func (a *BadgerDatabase) SetPartialTags(repo string, tags []string) error {
+	if len(tags) == 0 {
+		return a.db.Update(func(txn *badger.Txn) error {
+			return txn.Delete(keyForRepo(partialTagsPrefix, repo))
+		})
+	}
+	b, err := marshal(tags)
+	if err != nil {
+		return err
+	}
+	return a.db.Update(func(txn *badger.Txn) error {
+		e := badger.NewEntry(keyForRepo(partialTagsPrefix, repo), b)
+		return txn.SetEntry(e)
+	})
+}
+
+// CreationTime implements the CreationTimeStore interface, fetching the
+// creation time recorded for the image the tag refers to.
+//
+// If no creation time has been recorded for the tag, false is returned.
+func (a *BadgerDatabase) CreationTime(repo, tag string) (time.Time, bool, error) {
+	var created time.Time
+	var found bool
+	err := a.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(keyForTag(createdPrefix, repo, tag))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		found = true
+		return item.Value(func(val []byte) error {
+			return created.UnmarshalText(val)
+		})
+	})
+	return created, found, err
+}
+
+// SetCreationTime implements the CreationTimeStore interface, recording the
+// creation time of the image the tag refers to.
+func (a *BadgerDatabase) SetCreationTime(repo, tag string, created time.Time) error {
+	b, err := created.MarshalText()
+	if err != nil {
+		return err
+	}
+	return a.db.Update(func(txn *badger.Txn) error {
+		e := badger.NewEntry(keyForTag(createdPrefix, repo, tag), b)
+		return txn.SetEntry(e)
+	})
+}
+
+// FirstSeen implements the FirstSeenStore interface, fetching the times
+// recorded for when the tags of the repo were first seen.
+//
+// If nothing has been recorded for the repo, false is returned.
+func (a *BadgerDatabase) FirstSeen(repo string) (map[string]time.Time, bool, error) {
+	firstSeen := map[string]time.Time{}
+	var found bool
+	err := a.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(keyForRepo(firstSeenPrefix, repo))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		found = true
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &firstSeen)
+		})
+	})
+	return firstSeen, found, err
+}
+
+// SetFirstSeen implements the FirstSeenStore interface, recording when the
+// tags of the repo were first seen.
+//
+// It overwrites the existing record for the provided repo.
+func (a *BadgerDatabase) SetFirstSeen(repo string, firstSeen map[string]time.Time) error {
+	b, err := json.Marshal(firstSeen)
+	if err != nil {
+		return err
+	}
+	return a.db.Update(func(txn *badger.Txn) error {
+		e := badger.NewEntry(keyForRepo(firstSeenPrefix, repo), b)
+		return txn.SetEntry(e)
+	})
+}
+
+// SetScanResult implements the ScanResultStore interface, recording the
+// tags of the repo and when each was first seen, and removing the tags
+// of an incomplete scan, in one transaction. Records which have not
+// changed are not written again.
+func (a *BadgerDatabase) SetScanResult(repo string, tags []string, firstSeen map[string]time.Time) error {
+	tagsVal, err := marshal(tags)
+	if err != nil {
+		return err
+	}
+	firstSeenVal, err := json.Marshal(firstSeen)
+	if err != nil {
+		return err
+	}
+	writes := []func(txn *badger.Txn) error{
+		setIfChanged(keyForRepo(firstSeenPrefix, repo), firstSeenVal),
+		setIfChanged(keyForRepo(tagsPrefix, repo), tagsVal),
+		func(txn *badger.Txn) error {
+			return txn.Delete(keyForRepo(partialTagsPrefix, repo))
+		},
+	}
+
+	return a.db.Update(func(txn *badger.Txn) error {
+		for _, write := range writes {
+			if err := write(txn); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// setIfChanged returns a write of the value at the key, which does
+// nothing if the value is already there.
+func setIfChanged(key, val []byte) func(txn *badger.Txn) error {
+	return func(txn *badger.Txn) error {
+		item, err := txn.Get(key)
+		if err != nil && err != badger.ErrKeyNotFound {
+			return err
+		}
+		if err == nil {
+			var unchanged bool
+			if err := item.Value(func(v []byte) error {
+				unchanged = bytes.Equal(v, val)
+				return nil
+			}); err != nil {
+				return err
+			}
+			if unchanged {
+				return nil
+			}
+		}
+		return txn.SetEntry(badger.NewEntry(key, val))
+	}
+}
+
+// LastSeen implements the LastSeenStore interface, fetching the times
+// recorded for when the tags removed from the repo were last seen.
+//
+// If nothing has been recorded for the repo, false is returned.
+func (a *BadgerDatabase) LastSeen(repo string) (map[string]time.Time, bool, error) {
+	lastSeen := map[string]time.Time{}
+	var found bool
+	err := a.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(keyForRepo(lastSeenPrefix, repo))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		found = true
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &lastSeen)
+		})
+	})
+	return lastSeen, found, err
+}
+
+// SetLastSeen implements the LastSeenStore interface, recording when the
+// tags removed from the repo were last seen.
+//
+// It overwrites the existing record for the provided repo.
+func (a *BadgerDatabase) SetLastSeen(repo string, lastSeen map[string]time.Time) error {
+	b, err := json.Marshal(lastSeen)
+	if err != nil {
+		return err
+	}
+	return a.db.Update(func(txn *badger.Txn) error {
+		e := badger.NewEntry(keyForRepo(lastSeenPrefix, repo), b)
+		return txn.SetEntry(e)
+	})
+}
+
+// TagListValidators implements the TagListValidatorStore interface,
+// fetching the validators recorded for the listing of the tags of the
+// repo.
+//
+// If nothing has been recorded for the repo, false is returned.
+func (a *BadgerDatabase) TagListValidators(repo string) ([]byte, bool, error) {
+	var validators []byte
+	var found bool
+	err := a.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(keyForRepo(validatorsPrefix, repo))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		found = true
+		validators, err = item.ValueCopy(nil)
+		return err
+	})
+	return validators, found, err
+}
+
+// SetTagListValidators implements the TagListValidatorStore interface,
+// recording the validators of the listing of the tags of the repo.
+//
+// It overwrites the existing record for the provided repo; empty
+// validators remove it.
+func (a *BadgerDatabase) SetTagListValidators(repo string, validators []byte) error {
+	return a.db.Update(func(txn *badger.Txn) error {
+		if len(validators) == 0 {
+			return txn.Delete(keyForRepo(validatorsPrefix, repo))
+		}
+		return txn.SetEntry(badger.NewEntry(keyForRepo(validatorsPrefix, repo), validators))
+	})
+}
+
+// Platforms implements the PlatformStore interface, fetching the platforms
+// recorded for the image the tag refers to.
+//
+// If no platforms have been recorded for the tag, false is returned.
+func (a *BadgerDatabase) Platforms(repo, tag string) (map[string]string, bool, error) {
+	platforms := map[string]string{}
+	var found bool
+	err := a.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(keyForTag(platformsPrefix, repo, tag))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		found = true
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &platforms)
+		})
+	})
+	return platforms, found, err
+}
+
+// SetPlatforms implements the PlatformStore interface, recording the
+// platforms of the image the tag refers to.
+func (a *BadgerDatabase) SetPlatforms(repo, tag string, platforms map[string]string) error {
+	b, err := json.Marshal(platforms)
+	if err != nil {
+		return err
+	}
+	return a.db.Update(func(txn *badger.Txn) error {
+		e := badger.NewEntry(keyForTag(platformsPrefix, repo, tag), b)
+		return txn.SetEntry(e)
+	})
+}
+
+// ImageConfig implements the ImageConfigStore interface, fetching the
+// config blob recorded for the image the tag refers to.
+//
+// If no config has been recorded for the tag, false is returned.
+func (a *BadgerDatabase) ImageConfig(repo, tag string) ([]byte, bool, error) {
+	var config []byte
+	err := a.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(keyForTag(configPrefix, repo, tag))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		config, err = item.ValueCopy(nil)
+		return err
+	})
+	return config, config != nil, err
+}
+
+// SetImageConfig implements the ImageConfigStore interface, recording
+// the config blob of the image the tag refers to.
+func (a *BadgerDatabase) SetImageConfig(repo, tag string, config []byte) error {
+	return a.db.Update(func(txn *badger.Txn) error {
+		e := badger.NewEntry(keyForTag(configPrefix, repo, tag), config)
+		return txn.SetEntry(e)
+	})
+}
+
+// Descriptor implements the DescriptorStore interface, fetching the
+// descriptor recorded for the manifest the tag refers to.
+//
+// If no descriptor has been recorded for the tag, false is returned.
+func (a *BadgerDatabase) Descriptor(repo, tag string) (v1.Descriptor, bool, error) {
+	var desc v1.Descriptor
+	var found bool
+	err := a.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(keyForTag(descriptorPrefix, repo, tag))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		found = true
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &desc)
+		})
+	})
+	return desc, found, err
+}
+
+// SetDescriptor implements the DescriptorStore interface, recording the
+// descriptor of the manifest the tag refers to.
+func (a *BadgerDatabase) SetDescriptor(repo, tag string, desc v1.Descriptor) error {
+	b, err := json.Marshal(desc)
+	if err != nil {
+		return err
+	}
+	return a.db.Update(func(txn *badger.Txn) error {
+		e := badger.NewEntry(keyForTag(descriptorPrefix, repo, tag), b)
+		return txn.SetEntry(e)
+	})
+}
+
+// Provenance implements the ProvenanceStore interface, fetching the
+// builders recorded for the image the tag refers to.
+//
+// If no builders have been recorded for the tag, false is returned.
+func (a *BadgerDatabase) Provenance(repo, tag string) ([]string, bool, error) {
+	builders := []string{}
+	var found bool
+	err := a.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(keyForTag(provenancePrefix, repo, tag))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		found = true
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &builders)
+		})
+	})
+	return builders, found, err
+}
+
+// SetProvenance implements the ProvenanceStore interface, recording the
+// builders of the image the tag refers to.
+func (a *BadgerDatabase) SetProvenance(repo, tag string, builders []string) error {
+	if builders == nil {
+		builders = []string{}
+	}
+	b, err := json.Marshal(builders)
+	if err != nil {
+		return err
+	}
+	return a.db.Update(func(txn *badger.Txn) error {
+		e := badger.NewEntry(keyForTag(provenancePrefix, repo, tag), b)
+		return txn.SetEntry(e)
+	})
+}
+
+// DeleteRepository implements the DatabaseDeleter interface, removing
+// everything recorded for the repo.
+func (a *BadgerDatabase) DeleteRepository(repo string) error {
+	return a.db.Update(func(txn *badger.Txn) error {
+		for _, prefix := range []string{tagsPrefix, partialTagsPrefix, firstSeenPrefix, lastSeenPrefix, validatorsPrefix} {
+			if err := txn.Delete(keyForRepo(prefix, repo)); err != nil {
+				return err
+			}
+		}
+
+		var keys [][]byte
+		it := txn.NewIterator(badger.IteratorOptions{})
+		for _, prefix := range []string{createdPrefix, platformsPrefix, configPrefix, descriptorPrefix, provenancePrefix} {
+			tagPrefix := keyForTag(prefix, repo, "")
+			for it.Seek(tagPrefix); it.ValidForPrefix(tagPrefix); it.Next() {
+				keys = append(keys, it.Item().KeyCopy(nil))
+			}
+		}
+		it.Close()
+		for _, key := range keys {
+			if err := txn.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Repositories implements the RepositoryLister interface, returning
+// the repos with anything recorded, in order.
+func (a *BadgerDatabase) Repositories() ([]string, error) {
+	repos := map[string]struct{}{}
+	err := a.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{})
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			if repo, ok := repoForKey(string(it.Item().Key())); ok {
+				repos[repo] = struct{}{}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sortedKeys(repos), nil
+}
+
+func keyForTag(prefix, repo, tag string) []byte {
+	return []byte(fmt.Sprintf("%s:%s:%s", prefix, repo, tag))
+}
+
 func keyForRepo(prefix, repo string) []byte {
 	return []byte(fmt.Sprintf("%s:%s", prefix, repo))
 }
 
-func getOrEmpty(txn *badger.Txn, repo string) ([]string, error) {
-	item, err := txn.Get(keyForRepo(tagsPrefix, repo))
+// repoForKey returns the repo of a key given by keyForRepo or keyForTag,
+// and whether the key is one of these. Tags cannot contain a colon, so
+// the tag of a key is what follows the last colon.
+func repoForKey(key string) (string, bool) {
+	prefix, rest, ok := strings.Cut(key, ":")
+	if !ok {
+		return "", false
+	}
+	switch prefix {
+	case tagsPrefix, partialTagsPrefix, firstSeenPrefix, lastSeenPrefix, validatorsPrefix:
+		return rest, true
+	case createdPrefix, platformsPrefix, configPrefix, descriptorPrefix, provenancePrefix:
+		if i := strings.LastIndex(rest, ":"); i >= 0 {
+			return rest[:i], true
+		}
+	}
+	return "", false
+}
+
+func sortedKeys(set map[string]struct{}) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func getOrEmpty(txn *badger.Txn, prefix, repo string) ([]string, error) {
+	item, err := txn.Get(keyForRepo(prefix, repo))
 	if err == badger.ErrKeyNotFound {
 		return []string{}, nil
 	}
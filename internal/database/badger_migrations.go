@@ -0,0 +1,95 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package database
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// schemaVersionKey is the key of the schema version of a Badger
+// database. It is not of the form of the keys of records, so it is never
+// taken for one.
+var schemaVersionKey = []byte("schema-version")
+
+// badgerMigration upgrades the records of a Badger database from one
+// schema version to the next. It is given the database rather than a
+// transaction, so that it can rewrite more than fits in one; it must
+// therefore be safe to run again, if interrupted before the version is
+// recorded.
+type badgerMigration func(db *badger.DB) error
+
+// badgerMigrations are the migrations of the schema of Badger
+// databases, the one at index i upgrading from version i to i+1. The
+// schema version of a database without one is 0. Changes to how records
+// are encoded add a migration to the end, so that existing databases are
+// upgraded when opened rather than dropped.
+var badgerMigrations = []badgerMigration{
+	// 1: the schema version is recorded; records are as they were.
+	func(*badger.DB) error { return nil },
+}
+
+// migrateBadger brings the schema of the database up to the version of
+// the last of the migrations, running those it has not had in order. It
+// returns an error if the database has a schema version newer than that,
+// i.e., it was written by a later version of the controller.
+func migrateBadger(db *badger.DB, migrations []badgerMigration) error {
+	version, err := badgerSchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than the latest supported, %d", version, len(migrations))
+	}
+	for ; version < len(migrations); version++ {
+		if err := migrations[version](db); err != nil {
+			return fmt.Errorf("failed to migrate the database to schema version %d: %w", version+1, err)
+		}
+		err := db.Update(func(txn *badger.Txn) error {
+			return txn.Set(schemaVersionKey, []byte(strconv.Itoa(version+1)))
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// badgerSchemaVersion returns the schema version recorded in the
+// database, or 0 if none is.
+func badgerSchemaVersion(db *badger.DB) (int, error) {
+	var version int
+	err := db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(schemaVersionKey)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			version, err = strconv.Atoi(string(val))
+			if err != nil {
+				return fmt.Errorf("invalid database schema version '%s'", val)
+			}
+			return nil
+		})
+	})
+	return version, err
+}
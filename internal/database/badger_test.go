@@ -16,11 +16,21 @@ limitations under the License.
 package database
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/dgraph-io/badger/v3"
+
+	"github.com/fluxcd/image-reflector-controller/internal/backup"
 )
 
 const testRepo = "testing/testing"
@@ -79,6 +89,408 @@ func TestGetOnlyFetchesForRepo(t *testing.T) {
 	}
 }
 
+func TestPartialTags(t *testing.T) {
+	db := createBadgerDatabase(t)
+	tags := []string{"v0.0.1", "v0.0.2"}
+	fatalIfError(t, db.SetPartialTags(testRepo, tags))
+
+	loaded, err := db.PartialTags(testRepo)
+	fatalIfError(t, err)
+	if !reflect.DeepEqual(tags, loaded) {
+		t.Fatalf("SetPartialTags failed, got %#v want %#v", loaded, tags)
+	}
+
+	// Partial tags are kept apart from the tags of a finished scan.
+	loaded, err = db.Tags(testRepo)
+	fatalIfError(t, err)
+	if !reflect.DeepEqual([]string{}, loaded) {
+		t.Fatalf("Tags() after SetPartialTags got %#v, want %#v", loaded, []string{})
+	}
+}
+
+func TestSetPartialTagsEmptyRemoves(t *testing.T) {
+	db := createBadgerDatabase(t)
+	fatalIfError(t, db.SetPartialTags(testRepo, []string{"v0.0.1"}))
+
+	fatalIfError(t, db.SetPartialTags(testRepo, nil))
+
+	loaded, err := db.PartialTags(testRepo)
+	fatalIfError(t, err)
+	if !reflect.DeepEqual([]string{}, loaded) {
+		t.Fatalf("failed to remove with SetPartialTags: got %#v, want %#v", loaded, []string{})
+	}
+}
+
+func TestCreationTime(t *testing.T) {
+	db := createBadgerDatabase(t)
+	created := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	_, found, err := db.CreationTime(testRepo, "v0.0.1")
+	fatalIfError(t, err)
+	if found {
+		t.Fatal("CreationTime() for unknown tag found a creation time")
+	}
+
+	fatalIfError(t, db.SetCreationTime(testRepo, "v0.0.1", created))
+
+	loaded, found, err := db.CreationTime(testRepo, "v0.0.1")
+	fatalIfError(t, err)
+	if !found || !loaded.Equal(created) {
+		t.Fatalf("SetCreationTime failed, got %v (found: %v) want %v", loaded, found, created)
+	}
+
+	_, found, err = db.CreationTime(testRepo, "v0.0.2")
+	fatalIfError(t, err)
+	if found {
+		t.Fatal("CreationTime() for another tag found a creation time")
+	}
+}
+
+func TestFirstSeen(t *testing.T) {
+	db := createBadgerDatabase(t)
+
+	_, found, err := db.FirstSeen(testRepo)
+	fatalIfError(t, err)
+	if found {
+		t.Fatal("FirstSeen() for unknown repo found a record")
+	}
+
+	firstSeen := map[string]time.Time{
+		"v0.0.1": time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC),
+		"v0.0.2": time.Date(2022, 5, 2, 12, 0, 0, 0, time.UTC),
+	}
+	fatalIfError(t, db.SetFirstSeen(testRepo, firstSeen))
+
+	loaded, found, err := db.FirstSeen(testRepo)
+	fatalIfError(t, err)
+	if !found || len(loaded) != len(firstSeen) {
+		t.Fatalf("SetFirstSeen failed, got %v want %v", loaded, firstSeen)
+	}
+	for tag, seen := range firstSeen {
+		if !loaded[tag].Equal(seen) {
+			t.Fatalf("SetFirstSeen failed for %s, got %v want %v", tag, loaded[tag], seen)
+		}
+	}
+}
+
+func TestPlatforms(t *testing.T) {
+	db := createBadgerDatabase(t)
+	platforms := map[string]string{
+		"linux/amd64":    "sha256:amd64",
+		"linux/arm64/v8": "sha256:arm64",
+	}
+
+	_, found, err := db.Platforms(testRepo, "v0.0.1")
+	fatalIfError(t, err)
+	if found {
+		t.Fatal("Platforms() for unknown tag found platforms")
+	}
+
+	fatalIfError(t, db.SetPlatforms(testRepo, "v0.0.1", platforms))
+
+	loaded, found, err := db.Platforms(testRepo, "v0.0.1")
+	fatalIfError(t, err)
+	if !found || !reflect.DeepEqual(platforms, loaded) {
+		t.Fatalf("SetPlatforms failed, got %#v (found: %v) want %#v", loaded, found, platforms)
+	}
+}
+
+func TestImageConfig(t *testing.T) {
+	db := createBadgerDatabase(t)
+	config := []byte(`{"config":{"Labels":{"quality":"stable"}}}`)
+
+	_, found, err := db.ImageConfig(testRepo, "v0.0.1")
+	fatalIfError(t, err)
+	if found {
+		t.Fatal("ImageConfig() for unknown tag found a config")
+	}
+
+	fatalIfError(t, db.SetImageConfig(testRepo, "v0.0.1", config))
+
+	loaded, found, err := db.ImageConfig(testRepo, "v0.0.1")
+	fatalIfError(t, err)
+	if !found || !reflect.DeepEqual(config, loaded) {
+		t.Fatalf("SetImageConfig failed, got %s (found: %v) want %s", loaded, found, config)
+	}
+}
+
+func TestRunValueLogGC(t *testing.T) {
+	db := createBadgerDatabase(t)
+	for i := 0; i < 10; i++ {
+		fatalIfError(t, db.SetTags(testRepo, []string{fmt.Sprintf("v0.0.%d", i)}))
+	}
+	fatalIfError(t, runValueLogGC(db.db, 0.5))
+}
+
+func TestBadgerBackendOptions(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "badger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+
+	tests := []struct {
+		name    string
+		backend badgerBackend
+		wantErr bool
+	}{
+		{
+			name:    "garbage collected",
+			backend: badgerBackend{valueLogFileSize: 1 << 20, gcInterval: time.Millisecond, gcDiscardRatio: 0.5, compression: "zstd"},
+		},
+		{
+			name:    "not garbage collected",
+			backend: badgerBackend{valueLogFileSize: 1 << 20, gcDiscardRatio: 0.5, compression: "none"},
+		},
+		{
+			name:    "invalid backup URL",
+			backend: badgerBackend{valueLogFileSize: 1 << 20, gcDiscardRatio: 0.5, compression: "snappy", backupURL: "ftp://host/backup"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown compression",
+			backend: badgerBackend{valueLogFileSize: 1 << 20, gcDiscardRatio: 0.5, compression: "lz4"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid discard ratio",
+			backend: badgerBackend{valueLogFileSize: 1 << 20, gcDiscardRatio: 1, compression: "snappy"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, closeDB, err := tt.backend.Open(Options{StoragePath: dir})
+			if tt.wantErr {
+				if err == nil {
+					closeDB()
+					t.Fatal("expected an error, got nil")
+				}
+				return
+			}
+			fatalIfError(t, err)
+			fatalIfError(t, db.SetTags(testRepo, []string{"v0.0.1"}))
+			time.Sleep(10 * time.Millisecond)
+			fatalIfError(t, closeDB())
+		})
+	}
+}
+
+func TestBadgerBackendRecover(t *testing.T) {
+	corrupt := func(t *testing.T) string {
+		dir, err := os.MkdirTemp(os.TempDir(), "badger")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			os.RemoveAll(dir)
+		})
+		bdb, err := badger.Open(badger.DefaultOptions(dir).WithLogger(nil))
+		fatalIfError(t, err)
+		fatalIfError(t, NewBadgerDatabase(bdb).SetTags(testRepo, []string{"v0.0.1"}))
+		fatalIfError(t, bdb.Close())
+		fatalIfError(t, os.WriteFile(filepath.Join(dir, "MANIFEST"), []byte("not a manifest"), 0o600))
+		return dir
+	}
+	backend := badgerBackend{valueLogFileSize: 1 << 20, gcDiscardRatio: 0.5, compression: "snappy"}
+
+	dir := corrupt(t)
+	if _, _, err := backend.Open(Options{StoragePath: dir}); err == nil {
+		t.Fatal("expected an error opening a corrupt database without recovery, got nil")
+	}
+
+	backend.recoverCorrupt = true
+	db, closeDB, err := backend.Open(Options{StoragePath: dir})
+	fatalIfError(t, err)
+	defer closeDB()
+	tags, err := db.Tags(testRepo)
+	fatalIfError(t, err)
+	if len(tags) != 0 {
+		t.Fatalf("expected an empty database, got tags %v", tags)
+	}
+	if _, err := os.Stat(filepath.Join(dir, corruptBadgerDir, "MANIFEST")); err != nil {
+		t.Fatalf("expected the corrupt database to be moved aside: %v", err)
+	}
+}
+
+// memoryStore is a backup.Store holding the backup in memory.
+type memoryStore struct {
+	data []byte
+}
+
+func (s *memoryStore) Put(ctx context.Context, r io.Reader, size int64) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) != size {
+		return fmt.Errorf("read %d bytes, expected %d", len(data), size)
+	}
+	s.data = data
+	return nil
+}
+
+func (s *memoryStore) Get(ctx context.Context) (io.ReadCloser, error) {
+	if s.data == nil {
+		return nil, backup.ErrNotFound
+	}
+	return io.NopCloser(bytes.NewReader(s.data)), nil
+}
+
+func TestBackupAndRestoreBadger(t *testing.T) {
+	ctx := context.Background()
+	store := &memoryStore{}
+
+	db := createBadgerDatabase(t)
+	restored, err := restoreBadger(ctx, db.db, store)
+	fatalIfError(t, err)
+	if restored {
+		t.Fatal("expected no restore without a backup")
+	}
+
+	fatalIfError(t, db.SetTags(testRepo, []string{"v0.0.1"}))
+	fatalIfError(t, backupBadger(ctx, db.db, store))
+
+	// An empty database is restored from the backup, and a database with
+	// anything recorded is left as it is.
+	emptyDB := createBadgerDatabase(t)
+	restored, err = restoreBadger(ctx, emptyDB.db, store)
+	fatalIfError(t, err)
+	if !restored {
+		t.Fatal("expected the empty database to be restored")
+	}
+	tags, err := emptyDB.Tags(testRepo)
+	fatalIfError(t, err)
+	if want := []string{"v0.0.1"}; !reflect.DeepEqual(want, tags) {
+		t.Fatalf("Tags() after restore got %#v, want %#v", tags, want)
+	}
+
+	fatalIfError(t, db.SetTags(testRepo, []string{"v0.0.2"}))
+	restored, err = restoreBadger(ctx, db.db, store)
+	fatalIfError(t, err)
+	if restored {
+		t.Fatal("expected no restore of a database which is not empty")
+	}
+	tags, err = db.Tags(testRepo)
+	fatalIfError(t, err)
+	if want := []string{"v0.0.2"}; !reflect.DeepEqual(want, tags) {
+		t.Fatalf("Tags() got %#v, want %#v", tags, want)
+	}
+}
+
+func TestMigrateBadger(t *testing.T) {
+	db := createBadgerDatabase(t)
+	fatalIfError(t, db.SetTags(testRepo, []string{"v0.0.1"}))
+
+	// Each migration rewrites the tags, so that it can be seen which have
+	// run.
+	var ran []int
+	migration := func(i int) badgerMigration {
+		return func(*badger.DB) error {
+			ran = append(ran, i)
+			return db.SetTags(testRepo, []string{fmt.Sprintf("v0.0.%d", i+2)})
+		}
+	}
+	migrations := []badgerMigration{migration(0), migration(1)}
+
+	fatalIfError(t, migrateBadger(db.db, migrations[:1]))
+	fatalIfError(t, migrateBadger(db.db, migrations))
+	// Migrating a database already up to date does nothing.
+	fatalIfError(t, migrateBadger(db.db, migrations))
+	if want := []int{0, 1}; !reflect.DeepEqual(want, ran) {
+		t.Fatalf("migrations ran %v, want %v", ran, want)
+	}
+	version, err := badgerSchemaVersion(db.db)
+	fatalIfError(t, err)
+	if version != 2 {
+		t.Fatalf("schema version got %d, want 2", version)
+	}
+	tags, err := db.Tags(testRepo)
+	fatalIfError(t, err)
+	if want := []string{"v0.0.3"}; !reflect.DeepEqual(want, tags) {
+		t.Fatalf("Tags() got %#v, want %#v", tags, want)
+	}
+
+	// The schema version is not taken for the record of a repository.
+	repos, err := db.Repositories()
+	fatalIfError(t, err)
+	if want := []string{testRepo}; !reflect.DeepEqual(want, repos) {
+		t.Fatalf("Repositories() got %#v, want %#v", repos, want)
+	}
+
+	if err := migrateBadger(db.db, migrations[:1]); err == nil {
+		t.Fatal("expected an error migrating a database of a newer schema version")
+	}
+
+	failing := append(migrations, func(*badger.DB) error {
+		return fmt.Errorf("failed")
+	})
+	if err := migrateBadger(db.db, failing); err == nil {
+		t.Fatal("expected an error from a failing migration")
+	}
+	version, err = badgerSchemaVersion(db.db)
+	fatalIfError(t, err)
+	if version != 2 {
+		t.Fatalf("schema version after a failing migration got %d, want 2", version)
+	}
+}
+
+func TestSetScanResultTooBigForOneTransaction(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "badger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The tags and when they were first seen fit in a transaction each,
+	// but not both in one, which is limited to 15% of the memtable size.
+	// Values under the threshold are stored with their keys, so count
+	// in full towards the size of the transaction.
+	opts := badger.DefaultOptions(dir).WithMemTableSize(1 << 20).WithValueThreshold(1 << 17).WithLogger(nil)
+	bdb, err := badger.Open(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		bdb.Close()
+		os.RemoveAll(dir)
+	})
+	db := NewBadgerDatabase(bdb)
+
+	previous := []string{"v0.0.1"}
+	fatalIfError(t, db.SetScanResult(testRepo, previous, map[string]time.Time{"v0.0.1": time.Now()}))
+	partialTags := []string{"v0.0.2"}
+	fatalIfError(t, db.SetPartialTags(testRepo, partialTags))
+
+	tags := make([]string, 1800)
+	firstSeen := make(map[string]time.Time, len(tags))
+	for i := range tags {
+		tags[i] = fmt.Sprintf("v0.0.%d-%s", i, strings.Repeat("a", 32))
+		firstSeen[tags[i]] = time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	}
+	if err := db.SetScanResult(testRepo, tags, firstSeen); !errors.Is(err, badger.ErrTxnTooBig) {
+		t.Fatalf("SetScanResult() error = %v, want %v", err, badger.ErrTxnTooBig)
+	}
+
+	// Nothing of the scan result is written, rather than part of it.
+	loaded, err := db.Tags(testRepo)
+	fatalIfError(t, err)
+	if !reflect.DeepEqual(loaded, previous) {
+		t.Fatalf("Tags() got %v, want the previous tags %v", loaded, previous)
+	}
+	loadedFirstSeen, _, err := db.FirstSeen(testRepo)
+	fatalIfError(t, err)
+	if len(loadedFirstSeen) != len(previous) {
+		t.Fatalf("FirstSeen() got %d tags, want %d", len(loadedFirstSeen), len(previous))
+	}
+	partial, err := db.PartialTags(testRepo)
+	fatalIfError(t, err)
+	if !reflect.DeepEqual(partial, partialTags) {
+		t.Fatalf("PartialTags() got %v, want %v", partial, partialTags)
+	}
+}
+
 func createBadgerDatabase(t *testing.T) *BadgerDatabase {
 	t.Helper()
 	dir, err := os.MkdirTemp(os.TempDir(), "badger")
@@ -0,0 +1,501 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package database
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/redis/go-redis/v9"
+)
+
+// conformanceDatabases create a database of each of the backends, for
+// the tests every backend must pass.
+var conformanceDatabases = map[string]func(t *testing.T) Database{
+	"badger": func(t *testing.T) Database {
+		return createBadgerDatabase(t)
+	},
+	"memory": func(t *testing.T) Database {
+		return NewMemoryDatabase()
+	},
+	"postgres": func(t *testing.T) Database {
+		return createPostgresDatabase(t)
+	},
+	"redis": func(t *testing.T) Database {
+		return createRedisDatabase(t)
+	},
+	"sqlite": func(t *testing.T) Database {
+		return createSQLiteDatabase(t)
+	},
+}
+
+// conformanceTests are the tests every backend must pass.
+var conformanceTests = map[string]func(t *testing.T, db Database){
+	"tags":                      testConformanceTags,
+	"partial tags":              testConformancePartialTags,
+	"creation time":             testConformanceCreationTime,
+	"first seen":                testConformanceFirstSeen,
+	"scan result":               testConformanceScanResult,
+	"last seen":                 testConformanceLastSeen,
+	"tag list validators":       testConformanceTagListValidators,
+	"platforms":                 testConformancePlatforms,
+	"image config":              testConformanceImageConfig,
+	"descriptor":                testConformanceDescriptor,
+	"provenance":                testConformanceProvenance,
+	"records kept apart by key": testConformanceKeptApart,
+	"delete repository":         testConformanceDeleteRepository,
+	"list repositories":         testConformanceRepositories,
+}
+
+func TestConformance(t *testing.T) {
+	for _, backend := range Backends() {
+		create, ok := conformanceDatabases[backend]
+		if !ok {
+			t.Errorf("no conformance tests for the %s backend", backend)
+			continue
+		}
+		for name, test := range conformanceTests {
+			t.Run(backend+"/"+name, func(t *testing.T) {
+				test(t, create(t))
+			})
+		}
+	}
+}
+
+func testConformanceTags(t *testing.T, db Database) {
+	loaded, err := db.Tags(testRepo)
+	fatalIfError(t, err)
+	if !reflect.DeepEqual([]string{}, loaded) {
+		t.Fatalf("Tags() for unknown repo got %#v, want %#v", loaded, []string{})
+	}
+
+	fatalIfError(t, db.SetTags(testRepo, []string{"latest", "v0.0.1"}))
+	tags := []string{"latest", "v0.0.1", "v0.0.2"}
+	fatalIfError(t, db.SetTags(testRepo, tags))
+	// The tags recorded are not changed by changing those given.
+	tags[0] = "changed"
+
+	loaded, err = db.Tags(testRepo)
+	fatalIfError(t, err)
+	if want := []string{"latest", "v0.0.1", "v0.0.2"}; !reflect.DeepEqual(want, loaded) {
+		t.Fatalf("SetTags failed, got %#v want %#v", loaded, want)
+	}
+}
+
+func testConformancePartialTags(t *testing.T, db Database) {
+	tags := []string{"v0.0.1", "v0.0.2"}
+	fatalIfError(t, db.SetPartialTags(testRepo, tags))
+
+	loaded, err := db.PartialTags(testRepo)
+	fatalIfError(t, err)
+	if !reflect.DeepEqual(tags, loaded) {
+		t.Fatalf("SetPartialTags failed, got %#v want %#v", loaded, tags)
+	}
+	loaded, err = db.Tags(testRepo)
+	fatalIfError(t, err)
+	if !reflect.DeepEqual([]string{}, loaded) {
+		t.Fatalf("Tags() after SetPartialTags got %#v, want %#v", loaded, []string{})
+	}
+
+	fatalIfError(t, db.SetPartialTags(testRepo, nil))
+
+	loaded, err = db.PartialTags(testRepo)
+	fatalIfError(t, err)
+	if !reflect.DeepEqual([]string{}, loaded) {
+		t.Fatalf("failed to remove with SetPartialTags: got %#v, want %#v", loaded, []string{})
+	}
+}
+
+func testConformanceCreationTime(t *testing.T, db Database) {
+	created := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	_, found, err := db.CreationTime(testRepo, "v0.0.1")
+	fatalIfError(t, err)
+	if found {
+		t.Fatal("CreationTime() for unknown tag found a creation time")
+	}
+
+	fatalIfError(t, db.SetCreationTime(testRepo, "v0.0.1", created))
+
+	loaded, found, err := db.CreationTime(testRepo, "v0.0.1")
+	fatalIfError(t, err)
+	if !found || !loaded.Equal(created) {
+		t.Fatalf("SetCreationTime failed, got %v (found: %v) want %v", loaded, found, created)
+	}
+}
+
+func testConformanceFirstSeen(t *testing.T, db Database) {
+	_, found, err := db.FirstSeen(testRepo)
+	fatalIfError(t, err)
+	if found {
+		t.Fatal("FirstSeen() for unknown repo found a record")
+	}
+
+	firstSeen := map[string]time.Time{
+		"v0.0.1": time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC),
+		"v0.0.2": time.Date(2022, 5, 2, 12, 0, 0, 0, time.UTC),
+	}
+	fatalIfError(t, db.SetFirstSeen(testRepo, firstSeen))
+
+	loaded, found, err := db.FirstSeen(testRepo)
+	fatalIfError(t, err)
+	if !found || len(loaded) != len(firstSeen) {
+		t.Fatalf("SetFirstSeen failed, got %v want %v", loaded, firstSeen)
+	}
+	for tag, seen := range firstSeen {
+		if !loaded[tag].Equal(seen) {
+			t.Fatalf("SetFirstSeen failed for %s, got %v want %v", tag, loaded[tag], seen)
+		}
+	}
+}
+
+func testConformanceLastSeen(t *testing.T, db Database) {
+	_, found, err := db.LastSeen(testRepo)
+	fatalIfError(t, err)
+	if found {
+		t.Fatal("LastSeen() for unknown repo found a record")
+	}
+
+	lastSeen := map[string]time.Time{
+		"v0.0.1": time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC),
+		"v0.0.2": time.Date(2022, 5, 2, 12, 0, 0, 0, time.UTC),
+	}
+	fatalIfError(t, db.SetLastSeen(testRepo, lastSeen))
+
+	loaded, found, err := db.LastSeen(testRepo)
+	fatalIfError(t, err)
+	if !found || len(loaded) != len(lastSeen) {
+		t.Fatalf("SetLastSeen failed, got %v want %v", loaded, lastSeen)
+	}
+	for tag, seen := range lastSeen {
+		if !loaded[tag].Equal(seen) {
+			t.Fatalf("SetLastSeen failed for %s, got %v want %v", tag, loaded[tag], seen)
+		}
+	}
+}
+
+func testConformanceTagListValidators(t *testing.T, db Database) {
+	validators := []byte(`{"etag":"\"abc\""}`)
+
+	_, found, err := db.TagListValidators(testRepo)
+	fatalIfError(t, err)
+	if found {
+		t.Fatal("TagListValidators() for unknown repo found a record")
+	}
+
+	fatalIfError(t, db.SetTagListValidators(testRepo, validators))
+
+	loaded, found, err := db.TagListValidators(testRepo)
+	fatalIfError(t, err)
+	if !found || !reflect.DeepEqual(validators, loaded) {
+		t.Fatalf("SetTagListValidators failed, got %s (found: %v) want %s", loaded, found, validators)
+	}
+
+	// Setting empty validators removes the record.
+	fatalIfError(t, db.SetTagListValidators(testRepo, nil))
+	_, found, err = db.TagListValidators(testRepo)
+	fatalIfError(t, err)
+	if found {
+		t.Fatal("SetTagListValidators() with empty validators did not remove the record")
+	}
+}
+
+func testConformanceScanResult(t *testing.T, db Database) {
+	fatalIfError(t, db.SetPartialTags(testRepo, []string{"v0.0.1"}))
+
+	tags := []string{"v0.0.1", "v0.0.2"}
+	firstSeen := map[string]time.Time{
+		"v0.0.1": time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC),
+		"v0.0.2": time.Date(2022, 5, 2, 12, 0, 0, 0, time.UTC),
+	}
+	// Recording the same result again leaves it as it is.
+	for i := 0; i < 2; i++ {
+		fatalIfError(t, db.SetScanResult(testRepo, tags, firstSeen))
+	}
+
+	loaded, err := db.Tags(testRepo)
+	fatalIfError(t, err)
+	if !reflect.DeepEqual(tags, loaded) {
+		t.Fatalf("SetScanResult failed for tags, got %#v want %#v", loaded, tags)
+	}
+	loadedFirstSeen, found, err := db.FirstSeen(testRepo)
+	fatalIfError(t, err)
+	if !found || len(loadedFirstSeen) != len(firstSeen) {
+		t.Fatalf("SetScanResult failed for first seen, got %v want %v", loadedFirstSeen, firstSeen)
+	}
+	for tag, seen := range firstSeen {
+		if !loadedFirstSeen[tag].Equal(seen) {
+			t.Fatalf("SetScanResult failed for first seen of %s, got %v want %v", tag, loadedFirstSeen[tag], seen)
+		}
+	}
+	partial, err := db.PartialTags(testRepo)
+	fatalIfError(t, err)
+	if len(partial) != 0 {
+		t.Fatalf("SetScanResult did not remove partial tags, got %#v", partial)
+	}
+}
+
+func testConformancePlatforms(t *testing.T, db Database) {
+	platforms := map[string]string{
+		"linux/amd64":    "sha256:amd64",
+		"linux/arm64/v8": "sha256:arm64",
+	}
+
+	_, found, err := db.Platforms(testRepo, "v0.0.1")
+	fatalIfError(t, err)
+	if found {
+		t.Fatal("Platforms() for unknown tag found platforms")
+	}
+
+	fatalIfError(t, db.SetPlatforms(testRepo, "v0.0.1", platforms))
+
+	loaded, found, err := db.Platforms(testRepo, "v0.0.1")
+	fatalIfError(t, err)
+	if !found || !reflect.DeepEqual(platforms, loaded) {
+		t.Fatalf("SetPlatforms failed, got %#v (found: %v) want %#v", loaded, found, platforms)
+	}
+}
+
+func testConformanceImageConfig(t *testing.T, db Database) {
+	config := []byte(`{"config":{"Labels":{"quality":"stable"}}}`)
+
+	_, found, err := db.ImageConfig(testRepo, "v0.0.1")
+	fatalIfError(t, err)
+	if found {
+		t.Fatal("ImageConfig() for unknown tag found a config")
+	}
+
+	fatalIfError(t, db.SetImageConfig(testRepo, "v0.0.1", config))
+
+	loaded, found, err := db.ImageConfig(testRepo, "v0.0.1")
+	fatalIfError(t, err)
+	if !found || !reflect.DeepEqual(config, loaded) {
+		t.Fatalf("SetImageConfig failed, got %s (found: %v) want %s", loaded, found, config)
+	}
+}
+
+// testDescriptor is the descriptor of an image index.
+var testDescriptor = v1.Descriptor{
+	MediaType: types.OCIImageIndex,
+	Size:      1024,
+	Digest:    v1.Hash{Algorithm: "sha256", Hex: strings.Repeat("a", 64)},
+}
+
+func testConformanceDescriptor(t *testing.T, db Database) {
+	desc := testDescriptor
+
+	_, found, err := db.Descriptor(testRepo, "v0.0.1")
+	fatalIfError(t, err)
+	if found {
+		t.Fatal("Descriptor() for unknown tag found a descriptor")
+	}
+
+	fatalIfError(t, db.SetDescriptor(testRepo, "v0.0.1", desc))
+
+	loaded, found, err := db.Descriptor(testRepo, "v0.0.1")
+	fatalIfError(t, err)
+	if !found || !reflect.DeepEqual(desc, loaded) {
+		t.Fatalf("SetDescriptor failed, got %#v (found: %v) want %#v", loaded, found, desc)
+	}
+}
+
+func testConformanceProvenance(t *testing.T, db Database) {
+	builders := []string{"https://example.com/builder@v1"}
+
+	_, found, err := db.Provenance(testRepo, "v0.0.1")
+	fatalIfError(t, err)
+	if found {
+		t.Fatal("Provenance() for unknown tag found builders")
+	}
+
+	fatalIfError(t, db.SetProvenance(testRepo, "v0.0.1", builders))
+	// An image without attestations is recorded as such.
+	fatalIfError(t, db.SetProvenance(testRepo, "v0.0.2", nil))
+
+	loaded, found, err := db.Provenance(testRepo, "v0.0.1")
+	fatalIfError(t, err)
+	if !found || !reflect.DeepEqual(builders, loaded) {
+		t.Fatalf("SetProvenance failed, got %#v (found: %v) want %#v", loaded, found, builders)
+	}
+	loaded, found, err = db.Provenance(testRepo, "v0.0.2")
+	fatalIfError(t, err)
+	if !found || len(loaded) != 0 {
+		t.Fatalf("SetProvenance without builders failed, got %#v (found: %v)", loaded, found)
+	}
+}
+
+func testConformanceKeptApart(t *testing.T, db Database) {
+	testRepo2 := "another/repo"
+	fatalIfError(t, db.SetTags(testRepo, []string{"v0.0.1"}))
+	fatalIfError(t, db.SetTags(testRepo2, []string{"v0.0.2"}))
+	fatalIfError(t, db.SetCreationTime(testRepo, "v0.0.1", time.Now()))
+
+	loaded, err := db.Tags(testRepo)
+	fatalIfError(t, err)
+	if want := []string{"v0.0.1"}; !reflect.DeepEqual(want, loaded) {
+		t.Fatalf("Tags() got %#v, want %#v", loaded, want)
+	}
+	if _, found, err := db.CreationTime(testRepo2, "v0.0.1"); err != nil || found {
+		t.Fatalf("CreationTime() for another repo got found: %v, error: %v", found, err)
+	}
+	if _, found, err := db.CreationTime(testRepo, "v0.0.2"); err != nil || found {
+		t.Fatalf("CreationTime() for another tag got found: %v, error: %v", found, err)
+	}
+}
+
+func testConformanceDeleteRepository(t *testing.T, db Database) {
+	testRepo2 := testRepo + "-2"
+	for _, repo := range []string{testRepo, testRepo2} {
+		fatalIfError(t, db.SetTags(repo, []string{"v0.0.1"}))
+		fatalIfError(t, db.SetPartialTags(repo, []string{"v0.0.1"}))
+		fatalIfError(t, db.SetFirstSeen(repo, map[string]time.Time{"v0.0.1": time.Now()}))
+		fatalIfError(t, db.SetLastSeen(repo, map[string]time.Time{"v0.0.0": time.Now()}))
+		fatalIfError(t, db.SetCreationTime(repo, "v0.0.1", time.Now()))
+		fatalIfError(t, db.SetPlatforms(repo, "v0.0.1", map[string]string{"linux/amd64": "sha256:amd64"}))
+		fatalIfError(t, db.SetImageConfig(repo, "v0.0.1", []byte(`{}`)))
+		fatalIfError(t, db.SetDescriptor(repo, "v0.0.1", testDescriptor))
+		fatalIfError(t, db.SetTagListValidators(repo, []byte(`{}`)))
+		fatalIfError(t, db.SetProvenance(repo, "v0.0.1", []string{"https://example.com/builder@v1"}))
+	}
+
+	fatalIfError(t, db.DeleteRepository(testRepo))
+	// Deleting a repository with nothing recorded is not an error.
+	fatalIfError(t, db.DeleteRepository("unknown/repo"))
+
+	for repo, want := range map[string]bool{testRepo: false, testRepo2: true} {
+		tags, err := db.Tags(repo)
+		fatalIfError(t, err)
+		partialTags, err := db.PartialTags(repo)
+		fatalIfError(t, err)
+		_, firstSeen, err := db.FirstSeen(repo)
+		fatalIfError(t, err)
+		_, lastSeen, err := db.LastSeen(repo)
+		fatalIfError(t, err)
+		_, created, err := db.CreationTime(repo, "v0.0.1")
+		fatalIfError(t, err)
+		_, platforms, err := db.Platforms(repo, "v0.0.1")
+		fatalIfError(t, err)
+		_, config, err := db.ImageConfig(repo, "v0.0.1")
+		fatalIfError(t, err)
+		_, desc, err := db.Descriptor(repo, "v0.0.1")
+		fatalIfError(t, err)
+		_, validators, err := db.TagListValidators(repo)
+		fatalIfError(t, err)
+		_, provenance, err := db.Provenance(repo, "v0.0.1")
+		fatalIfError(t, err)
+		got := []bool{len(tags) > 0, len(partialTags) > 0, firstSeen, lastSeen, created, platforms, config, desc, validators, provenance}
+		for i, found := range got {
+			if found != want {
+				t.Fatalf("after DeleteRepository(%q), record %d of %q found: %v, want %v", testRepo, i, repo, found, want)
+			}
+		}
+	}
+}
+
+func testConformanceRepositories(t *testing.T, db Database) {
+	repos, err := db.Repositories()
+	fatalIfError(t, err)
+	if len(repos) != 0 {
+		t.Fatalf("Repositories() of an empty database got %#v", repos)
+	}
+
+	// Each repository has one kind of record; a registry may have a port,
+	// so the name of a repository may contain a colon.
+	fatalIfError(t, db.SetTags("example.com/tags", []string{"v0.0.1"}))
+	fatalIfError(t, db.SetPartialTags("example.com/partial-tags", []string{"v0.0.1"}))
+	fatalIfError(t, db.SetFirstSeen("example.com/first-seen", map[string]time.Time{"v0.0.1": time.Now()}))
+	fatalIfError(t, db.SetLastSeen("example.com/last-seen", map[string]time.Time{"v0.0.1": time.Now()}))
+	fatalIfError(t, db.SetCreationTime("localhost:5000/created", "v0.0.1", time.Now()))
+	fatalIfError(t, db.SetPlatforms("example.com/platforms", "v0.0.1", map[string]string{"linux/amd64": "sha256:amd64"}))
+	fatalIfError(t, db.SetImageConfig("example.com/config", "v0.0.1", []byte(`{}`)))
+	fatalIfError(t, db.SetImageConfig("example.com/config", "v0.0.2", []byte(`{}`)))
+	fatalIfError(t, db.SetDescriptor("example.com/descriptor", "v0.0.1", testDescriptor))
+	fatalIfError(t, db.SetTagListValidators("example.com/validators", []byte(`{}`)))
+	fatalIfError(t, db.SetProvenance("example.com/provenance", "v0.0.1", nil))
+
+	repos, err = db.Repositories()
+	fatalIfError(t, err)
+	want := []string{
+		"example.com/config",
+		"example.com/descriptor",
+		"example.com/first-seen",
+		"example.com/last-seen",
+		"example.com/partial-tags",
+		"example.com/platforms",
+		"example.com/provenance",
+		"example.com/tags",
+		"example.com/validators",
+		"localhost:5000/created",
+	}
+	if !reflect.DeepEqual(want, repos) {
+		t.Fatalf("Repositories() got %#v, want %#v", repos, want)
+	}
+}
+
+func TestOptionsOpen(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+
+	for _, backend := range []string{"badger", "memory", "sqlite"} {
+		t.Run(backend, func(t *testing.T) {
+			db, closeDB, err := Options{Backend: backend, StoragePath: dir}.Open()
+			fatalIfError(t, err)
+			defer closeDB()
+			fatalIfError(t, db.SetTags(testRepo, []string{"v0.0.1"}))
+		})
+	}
+
+	if _, _, err := (Options{Backend: "unknown"}).Open(); err == nil {
+		t.Fatal("Open() of unknown backend returned no error")
+	}
+
+	// Only a served database can be shared between replicas.
+	if _, _, err := (Options{Backend: "badger", StoragePath: dir, Shared: true}).Open(); err == nil {
+		t.Fatal("Open() of a shared badger database returned no error")
+	}
+	srv := miniredis.RunT(t)
+	backend := backends["redis"].(*redisBackend)
+	url := backend.url
+	backend.url = "redis://" + srv.Addr()
+	t.Cleanup(func() {
+		backend.url = url
+	})
+	_, closeDB, err := Options{Backend: "redis", Shared: true}.Open()
+	fatalIfError(t, err)
+	fatalIfError(t, closeDB())
+}
+
+func createRedisDatabase(t *testing.T) *RedisDatabase {
+	t.Helper()
+	srv := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	t.Cleanup(func() {
+		client.Close()
+	})
+	return NewRedisDatabase(client)
+}
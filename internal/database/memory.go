@@ -0,0 +1,431 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package database
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	flag "github.com/spf13/pflag"
+)
+
+func init() {
+	Register("memory", memoryBackend{})
+}
+
+// memoryBackend keeps the database in memory, until the controller
+// stops.
+type memoryBackend struct{}
+
+func (memoryBackend) BindFlags(*flag.FlagSet) {}
+
+func (memoryBackend) Open(Options) (Database, func() error, error) {
+	return NewMemoryDatabase(), func() error { return nil }, nil
+}
+
+// MemoryDatabase provides implementations of the tags database kept in
+// memory. Nothing is kept once the controller stops, so image
+// repositories are scanned again, and metadata of images fetched again,
+// after it restarts.
+type MemoryDatabase struct {
+	mu          sync.RWMutex
+	tags        map[string][]string
+	partialTags map[string][]string
+	created     map[string]time.Time
+	firstSeen   map[string]map[string]time.Time
+	lastSeen    map[string]map[string]time.Time
+	validators  map[string][]byte
+	platforms   map[string]map[string]string
+	configs     map[string][]byte
+	descriptors map[string][]byte
+	provenance  map[string][]string
+}
+
+// NewMemoryDatabase creates and returns a new, empty database
+// implementation keeping the image tags in memory.
+func NewMemoryDatabase() *MemoryDatabase {
+	return &MemoryDatabase{
+		tags:        map[string][]string{},
+		partialTags: map[string][]string{},
+		created:     map[string]time.Time{},
+		firstSeen:   map[string]map[string]time.Time{},
+		lastSeen:    map[string]map[string]time.Time{},
+		validators:  map[string][]byte{},
+		platforms:   map[string]map[string]string{},
+		configs:     map[string][]byte{},
+		descriptors: map[string][]byte{},
+		provenance:  map[string][]string{},
+	}
+}
+
+// Tags implements the DatabaseReader interface, fetching the tags for the repo.
+//
+// If the repo does not exist, an empty set of tags is returned.
+func (a *MemoryDatabase) Tags(repo string) ([]string, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return append([]string{}, a.tags[repo]...), nil
+}
+
+// SetTags implements the DatabaseWriter interface, recording the tags against
+// the repo.
+//
+// It overwrites existing tag sets for the provided repo.
+func (a *MemoryDatabase) SetTags(repo string, tags []string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.tags[repo] = append([]string{}, tags...)
+	return nil
+}
+
+// PartialTags implements the PartialScanStore interface, fetching the tags
+// recorded so far by an incomplete scan of the repo.
+//
+// If there is no incomplete scan of the repo, an empty set of tags is
+// returned.
+func (a *MemoryDatabase) PartialTags(repo string) ([]string, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return append([]string{}, a.partialTags[repo]...), nil
+}
+
+// SetPartialTags implements the PartialScanStore interface, recording the
+// tags fetched so far by an incomplete scan of the repo.
+//
+// An empty set of tags removes the record, e.g., once the scan is complete.
+func (a *MemoryDatabase) SetPartialTags(repo string, tags []string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(tags) == 0 {
+		delete(a.partialTags, repo)
+		return nil
+	}
+	a.partialTags[repo] = append([]string{}, tags...)
+	return nil
+}
+
+// CreationTime implements the CreationTimeStore interface, fetching the
+// creation time recorded for the image the tag refers to.
+//
+// If no creation time has been recorded for the tag, false is returned.
+func (a *MemoryDatabase) CreationTime(repo, tag string) (time.Time, bool, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	created, found := a.created[string(keyForTag(createdPrefix, repo, tag))]
+	return created, found, nil
+}
+
+// SetCreationTime implements the CreationTimeStore interface, recording the
+// creation time of the image the tag refers to.
+func (a *MemoryDatabase) SetCreationTime(repo, tag string, created time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.created[string(keyForTag(createdPrefix, repo, tag))] = created
+	return nil
+}
+
+// FirstSeen implements the FirstSeenStore interface, fetching the times
+// recorded for when the tags of the repo were first seen.
+//
+// If nothing has been recorded for the repo, false is returned.
+func (a *MemoryDatabase) FirstSeen(repo string) (map[string]time.Time, bool, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	recorded, found := a.firstSeen[repo]
+	firstSeen := make(map[string]time.Time, len(recorded))
+	for tag, seen := range recorded {
+		firstSeen[tag] = seen
+	}
+	return firstSeen, found, nil
+}
+
+// SetFirstSeen implements the FirstSeenStore interface, recording when the
+// tags of the repo were first seen.
+//
+// It overwrites the existing record for the provided repo.
+func (a *MemoryDatabase) SetFirstSeen(repo string, firstSeen map[string]time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	recorded := make(map[string]time.Time, len(firstSeen))
+	for tag, seen := range firstSeen {
+		recorded[tag] = seen
+	}
+	a.firstSeen[repo] = recorded
+	return nil
+}
+
+// SetScanResult implements the ScanResultStore interface, recording the
+// tags of the repo and when each was first seen, and removing the tags
+// of an incomplete scan, at once.
+func (a *MemoryDatabase) SetScanResult(repo string, tags []string, firstSeen map[string]time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	recorded := make(map[string]time.Time, len(firstSeen))
+	for tag, seen := range firstSeen {
+		recorded[tag] = seen
+	}
+	a.firstSeen[repo] = recorded
+	a.tags[repo] = append([]string{}, tags...)
+	delete(a.partialTags, repo)
+	return nil
+}
+
+// LastSeen implements the LastSeenStore interface, fetching the times
+// recorded for when the tags removed from the repo were last seen.
+//
+// If nothing has been recorded for the repo, false is returned.
+func (a *MemoryDatabase) LastSeen(repo string) (map[string]time.Time, bool, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	recorded, found := a.lastSeen[repo]
+	lastSeen := make(map[string]time.Time, len(recorded))
+	for tag, seen := range recorded {
+		lastSeen[tag] = seen
+	}
+	return lastSeen, found, nil
+}
+
+// SetLastSeen implements the LastSeenStore interface, recording when the
+// tags removed from the repo were last seen.
+//
+// It overwrites the existing record for the provided repo.
+func (a *MemoryDatabase) SetLastSeen(repo string, lastSeen map[string]time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	recorded := make(map[string]time.Time, len(lastSeen))
+	for tag, seen := range lastSeen {
+		recorded[tag] = seen
+	}
+	a.lastSeen[repo] = recorded
+	return nil
+}
+
+// TagListValidators implements the TagListValidatorStore interface,
+// fetching the validators recorded for the listing of the tags of the
+// repo.
+//
+// If nothing has been recorded for the repo, false is returned.
+func (a *MemoryDatabase) TagListValidators(repo string) ([]byte, bool, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	validators, found := a.validators[repo]
+	return append([]byte(nil), validators...), found, nil
+}
+
+// SetTagListValidators implements the TagListValidatorStore interface,
+// recording the validators of the listing of the tags of the repo.
+//
+// It overwrites the existing record for the provided repo; empty
+// validators remove it.
+func (a *MemoryDatabase) SetTagListValidators(repo string, validators []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(validators) == 0 {
+		delete(a.validators, repo)
+		return nil
+	}
+	a.validators[repo] = append([]byte(nil), validators...)
+	return nil
+}
+
+// Platforms implements the PlatformStore interface, fetching the platforms
+// recorded for the image the tag refers to.
+//
+// If no platforms have been recorded for the tag, false is returned.
+func (a *MemoryDatabase) Platforms(repo, tag string) (map[string]string, bool, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	recorded, found := a.platforms[string(keyForTag(platformsPrefix, repo, tag))]
+	platforms := make(map[string]string, len(recorded))
+	for platform, digest := range recorded {
+		platforms[platform] = digest
+	}
+	return platforms, found, nil
+}
+
+// SetPlatforms implements the PlatformStore interface, recording the
+// platforms of the image the tag refers to.
+func (a *MemoryDatabase) SetPlatforms(repo, tag string, platforms map[string]string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	recorded := make(map[string]string, len(platforms))
+	for platform, digest := range platforms {
+		recorded[platform] = digest
+	}
+	a.platforms[string(keyForTag(platformsPrefix, repo, tag))] = recorded
+	return nil
+}
+
+// ImageConfig implements the ImageConfigStore interface, fetching the
+// config blob recorded for the image the tag refers to.
+//
+// If no config has been recorded for the tag, false is returned.
+func (a *MemoryDatabase) ImageConfig(repo, tag string) ([]byte, bool, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	config, found := a.configs[string(keyForTag(configPrefix, repo, tag))]
+	if !found {
+		return nil, false, nil
+	}
+	return append([]byte{}, config...), true, nil
+}
+
+// SetImageConfig implements the ImageConfigStore interface, recording
+// the config blob of the image the tag refers to.
+func (a *MemoryDatabase) SetImageConfig(repo, tag string, config []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.configs[string(keyForTag(configPrefix, repo, tag))] = append([]byte{}, config...)
+	return nil
+}
+
+// Descriptor implements the DescriptorStore interface, fetching the
+// descriptor recorded for the manifest the tag refers to.
+//
+// If no descriptor has been recorded for the tag, false is returned.
+func (a *MemoryDatabase) Descriptor(repo, tag string) (v1.Descriptor, bool, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	// Descriptors are kept encoded, so that none of what they refer to
+	// is shared with the caller.
+	var desc v1.Descriptor
+	b, found := a.descriptors[string(keyForTag(descriptorPrefix, repo, tag))]
+	if !found {
+		return desc, false, nil
+	}
+	return desc, true, json.Unmarshal(b, &desc)
+}
+
+// SetDescriptor implements the DescriptorStore interface, recording the
+// descriptor of the manifest the tag refers to.
+func (a *MemoryDatabase) SetDescriptor(repo, tag string, desc v1.Descriptor) error {
+	b, err := json.Marshal(desc)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.descriptors[string(keyForTag(descriptorPrefix, repo, tag))] = b
+	return nil
+}
+
+// Provenance implements the ProvenanceStore interface, fetching the
+// builders recorded for the image the tag refers to.
+//
+// If no builders have been recorded for the tag, false is returned.
+func (a *MemoryDatabase) Provenance(repo, tag string) ([]string, bool, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	builders, found := a.provenance[string(keyForTag(provenancePrefix, repo, tag))]
+	return append([]string{}, builders...), found, nil
+}
+
+// SetProvenance implements the ProvenanceStore interface, recording the
+// builders of the image the tag refers to.
+func (a *MemoryDatabase) SetProvenance(repo, tag string, builders []string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.provenance[string(keyForTag(provenancePrefix, repo, tag))] = append([]string{}, builders...)
+	return nil
+}
+
+// DeleteRepository implements the DatabaseDeleter interface, removing
+// everything recorded for the repo.
+func (a *MemoryDatabase) DeleteRepository(repo string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.tags, repo)
+	delete(a.partialTags, repo)
+	delete(a.firstSeen, repo)
+	delete(a.lastSeen, repo)
+	delete(a.validators, repo)
+	for key := range a.created {
+		if strings.HasPrefix(key, string(keyForTag(createdPrefix, repo, ""))) {
+			delete(a.created, key)
+		}
+	}
+	for key := range a.platforms {
+		if strings.HasPrefix(key, string(keyForTag(platformsPrefix, repo, ""))) {
+			delete(a.platforms, key)
+		}
+	}
+	for key := range a.configs {
+		if strings.HasPrefix(key, string(keyForTag(configPrefix, repo, ""))) {
+			delete(a.configs, key)
+		}
+	}
+	for key := range a.descriptors {
+		if strings.HasPrefix(key, string(keyForTag(descriptorPrefix, repo, ""))) {
+			delete(a.descriptors, key)
+		}
+	}
+	for key := range a.provenance {
+		if strings.HasPrefix(key, string(keyForTag(provenancePrefix, repo, ""))) {
+			delete(a.provenance, key)
+		}
+	}
+	return nil
+}
+
+// Repositories implements the RepositoryLister interface, returning
+// the repos with anything recorded, in order.
+func (a *MemoryDatabase) Repositories() ([]string, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	repos := map[string]struct{}{}
+	for repo := range a.tags {
+		repos[repo] = struct{}{}
+	}
+	for repo := range a.partialTags {
+		repos[repo] = struct{}{}
+	}
+	for repo := range a.firstSeen {
+		repos[repo] = struct{}{}
+	}
+	for repo := range a.lastSeen {
+		repos[repo] = struct{}{}
+	}
+	for repo := range a.validators {
+		repos[repo] = struct{}{}
+	}
+	// The records of tags are keyed as in the other databases.
+	var keys []string
+	for key := range a.created {
+		keys = append(keys, key)
+	}
+	for key := range a.platforms {
+		keys = append(keys, key)
+	}
+	for key := range a.configs {
+		keys = append(keys, key)
+	}
+	for key := range a.descriptors {
+		keys = append(keys, key)
+	}
+	for key := range a.provenance {
+		keys = append(keys, key)
+	}
+	for _, key := range keys {
+		if repo, ok := repoForKey(key); ok {
+			repos[repo] = struct{}{}
+		}
+	}
+	return sortedKeys(repos), nil
+}
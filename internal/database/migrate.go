@@ -0,0 +1,125 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package database
+
+import (
+	"fmt"
+)
+
+// CopyRepositories copies the records of the repositories in src that
+// dst has no tags for into dst, returning the number copied. A
+// repository dst has tags for has been scanned since it was first
+// copied, if it was, so what dst has is kept. The per-tag records of a
+// repository are copied before its tags, so that a copy interrupted
+// partway through is made again the next time.
+func CopyRepositories(dst, src Database) (int, error) {
+	repos, err := src.Repositories()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list the repositories to copy: %w", err)
+	}
+	var copied int
+	for _, repo := range repos {
+		existing, err := dst.Tags(repo)
+		if err != nil {
+			return copied, err
+		}
+		if len(existing) > 0 {
+			continue
+		}
+		if err := copyRepository(dst, src, repo); err != nil {
+			return copied, fmt.Errorf("failed to copy the records of '%s': %w", repo, err)
+		}
+		copied++
+	}
+	return copied, nil
+}
+
+// copyRepository copies the records of the repository from src into dst.
+func copyRepository(dst, src Database, repo string) error {
+	tags, err := src.Tags(repo)
+	if err != nil {
+		return err
+	}
+	partialTags, err := src.PartialTags(repo)
+	if err != nil {
+		return err
+	}
+
+	for _, tag := range append(append([]string{}, tags...), partialTags...) {
+		if created, ok, err := src.CreationTime(repo, tag); err != nil {
+			return err
+		} else if ok {
+			if err := dst.SetCreationTime(repo, tag, created); err != nil {
+				return err
+			}
+		}
+		if platforms, ok, err := src.Platforms(repo, tag); err != nil {
+			return err
+		} else if ok {
+			if err := dst.SetPlatforms(repo, tag, platforms); err != nil {
+				return err
+			}
+		}
+		if config, ok, err := src.ImageConfig(repo, tag); err != nil {
+			return err
+		} else if ok {
+			if err := dst.SetImageConfig(repo, tag, config); err != nil {
+				return err
+			}
+		}
+		if desc, ok, err := src.Descriptor(repo, tag); err != nil {
+			return err
+		} else if ok {
+			if err := dst.SetDescriptor(repo, tag, desc); err != nil {
+				return err
+			}
+		}
+		if builders, ok, err := src.Provenance(repo, tag); err != nil {
+			return err
+		} else if ok {
+			if err := dst.SetProvenance(repo, tag, builders); err != nil {
+				return err
+			}
+		}
+	}
+
+	if lastSeen, ok, err := src.LastSeen(repo); err != nil {
+		return err
+	} else if ok {
+		if err := dst.SetLastSeen(repo, lastSeen); err != nil {
+			return err
+		}
+	}
+	firstSeen, ok, err := src.FirstSeen(repo)
+	if err != nil {
+		return err
+	}
+	if ok {
+		err = dst.SetScanResult(repo, tags, firstSeen)
+	} else {
+		err = dst.SetTags(repo, tags)
+	}
+	if err != nil {
+		return err
+	}
+	// Recording the result of a scan clears the partial tags, so they
+	// are copied after.
+	if len(partialTags) > 0 {
+		return dst.SetPartialTags(repo, partialTags)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package database
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCopyRepositories(t *testing.T) {
+	src := NewMemoryDatabase()
+	seen := time.Now().UTC().Truncate(time.Second)
+	fatalIfError(t, src.SetScanResult(testRepo, []string{"v0.0.1", "v0.0.2"}, map[string]time.Time{"v0.0.1": seen, "v0.0.2": seen}))
+	fatalIfError(t, src.SetPartialTags(testRepo, []string{"v0.0.3"}))
+	fatalIfError(t, src.SetLastSeen(testRepo, map[string]time.Time{"v0.0.0": seen}))
+	fatalIfError(t, src.SetCreationTime(testRepo, "v0.0.2", seen))
+	fatalIfError(t, src.SetPlatforms(testRepo, "v0.0.3", map[string]string{"linux/amd64": "sha256:abc"}))
+	fatalIfError(t, src.SetTags("testing/scanned", []string{"v1.0.0"}))
+
+	dst := NewMemoryDatabase()
+	fatalIfError(t, dst.SetTags("testing/scanned", []string{"v2.0.0"}))
+
+	copied, err := CopyRepositories(dst, src)
+	fatalIfError(t, err)
+	if copied != 1 {
+		t.Fatalf("CopyRepositories() copied %d repositories, want 1", copied)
+	}
+
+	tags, err := dst.Tags(testRepo)
+	fatalIfError(t, err)
+	if !reflect.DeepEqual(tags, []string{"v0.0.1", "v0.0.2"}) {
+		t.Errorf("Tags() got %v, want the tags copied", tags)
+	}
+	partialTags, err := dst.PartialTags(testRepo)
+	fatalIfError(t, err)
+	if !reflect.DeepEqual(partialTags, []string{"v0.0.3"}) {
+		t.Errorf("PartialTags() got %v, want the partial tags copied", partialTags)
+	}
+	if firstSeen, _, err := dst.FirstSeen(testRepo); err != nil || !firstSeen["v0.0.1"].Equal(seen) {
+		t.Errorf("FirstSeen() got %v, error: %v, want the times copied", firstSeen, err)
+	}
+	if lastSeen, _, err := dst.LastSeen(testRepo); err != nil || !lastSeen["v0.0.0"].Equal(seen) {
+		t.Errorf("LastSeen() got %v, error: %v, want the times copied", lastSeen, err)
+	}
+	if created, _, err := dst.CreationTime(testRepo, "v0.0.2"); err != nil || !created.Equal(seen) {
+		t.Errorf("CreationTime() got %v, error: %v, want the time copied", created, err)
+	}
+	if platforms, _, err := dst.Platforms(testRepo, "v0.0.3"); err != nil || platforms["linux/amd64"] != "sha256:abc" {
+		t.Errorf("Platforms() got %v, error: %v, want the platforms of the partial tag copied", platforms, err)
+	}
+
+	// A repository already scanned keeps its tags.
+	tags, err = dst.Tags("testing/scanned")
+	fatalIfError(t, err)
+	if !reflect.DeepEqual(tags, []string{"v2.0.0"}) {
+		t.Errorf("Tags() got %v, want the tags scanned", tags)
+	}
+
+	// Copying again copies nothing.
+	copied, err = CopyRepositories(dst, src)
+	fatalIfError(t, err)
+	if copied != 0 {
+		t.Fatalf("CopyRepositories() copied %d repositories again, want 0", copied)
+	}
+}
+
+func TestOptionsOpenMigrateFrom(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+
+	db, closeDB, err := Options{Backend: "badger", StoragePath: dir}.Open()
+	fatalIfError(t, err)
+	fatalIfError(t, db.SetTags(testRepo, []string{"v0.0.1"}))
+	fatalIfError(t, closeDB())
+
+	db, closeDB, err = Options{Backend: "sqlite", StoragePath: dir, MigrateFrom: "badger"}.Open()
+	fatalIfError(t, err)
+	defer closeDB()
+	tags, err := db.Tags(testRepo)
+	fatalIfError(t, err)
+	if !reflect.DeepEqual(tags, []string{"v0.0.1"}) {
+		t.Fatalf("Tags() got %v, want the tags migrated", tags)
+	}
+
+	if _, _, err := (Options{Backend: "memory", MigrateFrom: "memory"}).Open(); err == nil {
+		t.Fatal("Open() migrating from the same backend returned no error")
+	}
+}
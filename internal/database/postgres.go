@@ -0,0 +1,121 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package database
+
+import (
+	"database/sql"
+
+	_ "github.com/lib/pq"
+	flag "github.com/spf13/pflag"
+)
+
+func init() {
+	Register("postgres", &postgresBackend{})
+}
+
+// postgresBackend connects to a PostgreSQL database.
+type postgresBackend struct {
+	url string
+}
+
+func (b *postgresBackend) BindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&b.url, "postgres-url", b.url, "The URL of the PostgreSQL database to use with --db-backend=postgres, e.g., 'postgres://<user>:<password>@<host>:<port>/<db>?sslmode=verify-full'. The tables are created in it, and migrated when the controller starts.")
+}
+
+// Shared implements sharedBackend, since the database is on the
+// PostgreSQL server.
+func (b *postgresBackend) Shared() bool {
+	return true
+}
+
+func (b *postgresBackend) Open(Options) (Database, func() error, error) {
+	sqlDB, err := sql.Open("postgres", b.url)
+	if err != nil {
+		return nil, nil, err
+	}
+	db, err := NewPostgresDatabase(sqlDB)
+	if err != nil {
+		sqlDB.Close()
+		return nil, nil, err
+	}
+	return db, sqlDB.Close, nil
+}
+
+// postgresDialect gives the schema of the PostgreSQL database.
+var postgresDialect = sqlDialect{
+	migrations: []string{
+		`CREATE TABLE tags (
+			repo TEXT PRIMARY KEY,
+			tags JSONB NOT NULL
+		);
+		CREATE TABLE partial_tags (
+			repo TEXT PRIMARY KEY,
+			tags JSONB NOT NULL
+		);
+		CREATE TABLE first_seen (
+			repo       TEXT PRIMARY KEY,
+			first_seen JSONB NOT NULL
+		);
+		CREATE TABLE creation_times (
+			repo    TEXT NOT NULL,
+			tag     TEXT NOT NULL,
+			created TIMESTAMPTZ NOT NULL,
+			PRIMARY KEY (repo, tag)
+		);
+		CREATE TABLE platforms (
+			repo      TEXT NOT NULL,
+			tag       TEXT NOT NULL,
+			platforms JSONB NOT NULL,
+			PRIMARY KEY (repo, tag)
+		);
+		CREATE TABLE image_configs (
+			repo   TEXT NOT NULL,
+			tag    TEXT NOT NULL,
+			config BYTEA NOT NULL,
+			PRIMARY KEY (repo, tag)
+		);`,
+		`CREATE TABLE descriptors (
+			repo       TEXT NOT NULL,
+			tag        TEXT NOT NULL,
+			descriptor JSONB NOT NULL,
+			PRIMARY KEY (repo, tag)
+		);`,
+		`CREATE TABLE last_seen (
+			repo      TEXT PRIMARY KEY,
+			last_seen JSONB NOT NULL
+		);`,
+		`CREATE TABLE tag_list_validators (
+			repo       TEXT PRIMARY KEY,
+			validators BYTEA NOT NULL
+		);`,
+		`CREATE TABLE provenance (
+			repo     TEXT NOT NULL,
+			tag      TEXT NOT NULL,
+			builders JSONB NOT NULL,
+			PRIMARY KEY (repo, tag)
+		);`,
+	},
+	// The key of the advisory lock spells "ircd".
+	lock: `SELECT pg_advisory_xact_lock(1769104228)`,
+}
+
+// NewPostgresDatabase creates and returns a new database implementation
+// using PostgreSQL for storing the image tags, first bringing the schema
+// of the database up to date.
+func NewPostgresDatabase(db *sql.DB) (*SQLDatabase, error) {
+	return newSQLDatabase(db, postgresDialect)
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+// postgresURLEnv names the environment variable giving the URL of a
+// PostgreSQL database for the tests to use; the tests are skipped when it
+// is not set.
+const postgresURLEnv = "TEST_POSTGRES_URL"
+
+// createPostgresDatabase returns a database in a schema of its own,
+// which is dropped when the test is done.
+func createPostgresDatabase(t *testing.T) *SQLDatabase {
+	t.Helper()
+	url := os.Getenv(postgresURLEnv)
+	if url == "" {
+		t.Skipf("%s is not set", postgresURLEnv)
+	}
+	sqlDB, err := sql.Open("postgres", url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Keep to one connection, so that the search path applies to every
+	// statement.
+	sqlDB.SetMaxOpenConns(1)
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	if _, err := sqlDB.Exec(fmt.Sprintf(`CREATE SCHEMA %s; SET search_path TO %s`, schema, schema)); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		sqlDB.Exec(fmt.Sprintf(`DROP SCHEMA %s CASCADE`, schema))
+		sqlDB.Close()
+	})
+	db, err := NewPostgresDatabase(sqlDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
@@ -0,0 +1,244 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package database
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// readOnlyDatabase reads from the database it wraps, and keeps what is
+// written to it in memory, over what the database has, so that what a
+// scan records can be read by the policies without being persisted.
+// The records of a repository are copied into memory when it is first
+// written, and read from there from then on.
+type readOnlyDatabase struct {
+	Database
+
+	mu       sync.Mutex
+	overlay  *MemoryDatabase
+	overlaid map[string]bool
+}
+
+// ReadOnly returns the database, with everything written to it kept in
+// memory rather than persisted, so that the controller can run without
+// changing what is recorded.
+func ReadOnly(db Database) Database {
+	return &readOnlyDatabase{
+		Database: db,
+		overlay:  NewMemoryDatabase(),
+		overlaid: map[string]bool{},
+	}
+}
+
+// read returns the database to read the records of the repository from.
+func (d *readOnlyDatabase) read(repo string) Database {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.overlaid[repo] {
+		return d.overlay
+	}
+	return d.Database
+}
+
+// write returns the database to write the records of the repository to,
+// copying them into memory first if they are not there yet.
+func (d *readOnlyDatabase) write(repo string) (Database, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.overlaid[repo] {
+		return d.overlay, nil
+	}
+	if err := copyRepository(d.overlay, d.Database, repo); err != nil {
+		return nil, err
+	}
+	validators, ok, err := d.Database.TagListValidators(repo)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		if err := d.overlay.SetTagListValidators(repo, validators); err != nil {
+			return nil, err
+		}
+	}
+	d.overlaid[repo] = true
+	return d.overlay, nil
+}
+
+func (d *readOnlyDatabase) Tags(repo string) ([]string, error) {
+	return d.read(repo).Tags(repo)
+}
+
+func (d *readOnlyDatabase) SetTags(repo string, tags []string) error {
+	db, err := d.write(repo)
+	if err != nil {
+		return err
+	}
+	return db.SetTags(repo, tags)
+}
+
+func (d *readOnlyDatabase) PartialTags(repo string) ([]string, error) {
+	return d.read(repo).PartialTags(repo)
+}
+
+func (d *readOnlyDatabase) SetPartialTags(repo string, tags []string) error {
+	db, err := d.write(repo)
+	if err != nil {
+		return err
+	}
+	return db.SetPartialTags(repo, tags)
+}
+
+func (d *readOnlyDatabase) CreationTime(repo, tag string) (time.Time, bool, error) {
+	return d.read(repo).CreationTime(repo, tag)
+}
+
+func (d *readOnlyDatabase) SetCreationTime(repo, tag string, created time.Time) error {
+	db, err := d.write(repo)
+	if err != nil {
+		return err
+	}
+	return db.SetCreationTime(repo, tag, created)
+}
+
+func (d *readOnlyDatabase) FirstSeen(repo string) (map[string]time.Time, bool, error) {
+	return d.read(repo).FirstSeen(repo)
+}
+
+func (d *readOnlyDatabase) SetFirstSeen(repo string, firstSeen map[string]time.Time) error {
+	db, err := d.write(repo)
+	if err != nil {
+		return err
+	}
+	return db.SetFirstSeen(repo, firstSeen)
+}
+
+func (d *readOnlyDatabase) SetScanResult(repo string, tags []string, firstSeen map[string]time.Time) error {
+	db, err := d.write(repo)
+	if err != nil {
+		return err
+	}
+	return db.SetScanResult(repo, tags, firstSeen)
+}
+
+func (d *readOnlyDatabase) LastSeen(repo string) (map[string]time.Time, bool, error) {
+	return d.read(repo).LastSeen(repo)
+}
+
+func (d *readOnlyDatabase) SetLastSeen(repo string, lastSeen map[string]time.Time) error {
+	db, err := d.write(repo)
+	if err != nil {
+		return err
+	}
+	return db.SetLastSeen(repo, lastSeen)
+}
+
+func (d *readOnlyDatabase) TagListValidators(repo string) ([]byte, bool, error) {
+	return d.read(repo).TagListValidators(repo)
+}
+
+func (d *readOnlyDatabase) SetTagListValidators(repo string, validators []byte) error {
+	db, err := d.write(repo)
+	if err != nil {
+		return err
+	}
+	return db.SetTagListValidators(repo, validators)
+}
+
+func (d *readOnlyDatabase) Platforms(repo, tag string) (map[string]string, bool, error) {
+	return d.read(repo).Platforms(repo, tag)
+}
+
+func (d *readOnlyDatabase) SetPlatforms(repo, tag string, platforms map[string]string) error {
+	db, err := d.write(repo)
+	if err != nil {
+		return err
+	}
+	return db.SetPlatforms(repo, tag, platforms)
+}
+
+func (d *readOnlyDatabase) ImageConfig(repo, tag string) ([]byte, bool, error) {
+	return d.read(repo).ImageConfig(repo, tag)
+}
+
+func (d *readOnlyDatabase) SetImageConfig(repo, tag string, config []byte) error {
+	db, err := d.write(repo)
+	if err != nil {
+		return err
+	}
+	return db.SetImageConfig(repo, tag, config)
+}
+
+func (d *readOnlyDatabase) Descriptor(repo, tag string) (v1.Descriptor, bool, error) {
+	return d.read(repo).Descriptor(repo, tag)
+}
+
+func (d *readOnlyDatabase) SetDescriptor(repo, tag string, desc v1.Descriptor) error {
+	db, err := d.write(repo)
+	if err != nil {
+		return err
+	}
+	return db.SetDescriptor(repo, tag, desc)
+}
+
+func (d *readOnlyDatabase) Provenance(repo, tag string) ([]string, bool, error) {
+	return d.read(repo).Provenance(repo, tag)
+}
+
+func (d *readOnlyDatabase) SetProvenance(repo, tag string, builders []string) error {
+	db, err := d.write(repo)
+	if err != nil {
+		return err
+	}
+	return db.SetProvenance(repo, tag, builders)
+}
+
+// DeleteRepository removes the records of the repository from memory,
+// so that nothing is read for it from then on.
+func (d *readOnlyDatabase) DeleteRepository(repo string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.overlaid[repo] = true
+	return d.overlay.DeleteRepository(repo)
+}
+
+// Repositories returns the repositories with anything recorded in memory,
+// and those of the database not written to, in order.
+func (d *readOnlyDatabase) Repositories() ([]string, error) {
+	repos, err := d.Database.Repositories()
+	if err != nil {
+		return nil, err
+	}
+	overlaid, err := d.overlay.Repositories()
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var all []string
+	for _, repo := range repos {
+		if !d.overlaid[repo] {
+			all = append(all, repo)
+		}
+	}
+	all = append(all, overlaid...)
+	sort.Strings(all)
+	return all, nil
+}
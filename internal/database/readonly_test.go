@@ -0,0 +1,83 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReadOnly(t *testing.T) {
+	db := NewMemoryDatabase()
+	fatalIfError(t, db.SetTags(testRepo, []string{"v0.0.1"}))
+	created := time.Now().UTC().Truncate(time.Second)
+	fatalIfError(t, db.SetCreationTime(testRepo, "v0.0.1", created))
+
+	readOnly := ReadOnly(db)
+	fatalIfError(t, readOnly.SetScanResult(testRepo, []string{"v0.0.1", "v0.0.2"}, map[string]time.Time{"v0.0.2": time.Now()}))
+	fatalIfError(t, readOnly.SetLastSeen(testRepo, map[string]time.Time{"v0.0.0": time.Now()}))
+	fatalIfError(t, readOnly.SetCreationTime(testRepo, "v0.0.2", time.Now()))
+
+	// What is written is read back, along with what was recorded before.
+	tags, err := readOnly.Tags(testRepo)
+	fatalIfError(t, err)
+	if len(tags) != 2 || tags[0] != "v0.0.1" || tags[1] != "v0.0.2" {
+		t.Fatalf("Tags() got %v, want the tags written", tags)
+	}
+	if _, found, err := readOnly.CreationTime(testRepo, "v0.0.2"); err != nil || !found {
+		t.Fatalf("CreationTime() got found: %v, error: %v, want the creation time written", found, err)
+	}
+	if got, found, err := readOnly.CreationTime(testRepo, "v0.0.1"); err != nil || !found || !got.Equal(created) {
+		t.Fatalf("CreationTime() got %v, found: %v, error: %v, want the creation time recorded before", got, found, err)
+	}
+
+	// None of it is recorded in the database.
+	tags, err = db.Tags(testRepo)
+	fatalIfError(t, err)
+	if len(tags) != 1 || tags[0] != "v0.0.1" {
+		t.Fatalf("Tags() got %v, want the tags recorded before", tags)
+	}
+	if _, found, err := db.FirstSeen(testRepo); err != nil || found {
+		t.Fatalf("FirstSeen() got found: %v, error: %v, want nothing recorded", found, err)
+	}
+	if _, found, err := db.LastSeen(testRepo); err != nil || found {
+		t.Fatalf("LastSeen() got found: %v, error: %v, want nothing recorded", found, err)
+	}
+	if _, found, err := db.CreationTime(testRepo, "v0.0.2"); err != nil || found {
+		t.Fatalf("CreationTime() got found: %v, error: %v, want nothing recorded", found, err)
+	}
+
+	// A repository deleted is no longer read, but is kept in the database.
+	const otherRepo = "testing/other"
+	fatalIfError(t, db.SetTags(otherRepo, []string{"v1.0.0"}))
+	fatalIfError(t, readOnly.DeleteRepository(otherRepo))
+	tags, err = readOnly.Tags(otherRepo)
+	fatalIfError(t, err)
+	if len(tags) != 0 {
+		t.Fatalf("Tags() got %v, want no tags for a repository deleted", tags)
+	}
+	repos, err := readOnly.Repositories()
+	fatalIfError(t, err)
+	if len(repos) != 1 || repos[0] != testRepo {
+		t.Fatalf("Repositories() got %v, want only %q", repos, testRepo)
+	}
+	tags, err = db.Tags(otherRepo)
+	fatalIfError(t, err)
+	if len(tags) != 1 {
+		t.Fatalf("Tags() got %v, want the tags recorded before", tags)
+	}
+}
@@ -0,0 +1,395 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/redis/go-redis/v9"
+	flag "github.com/spf13/pflag"
+)
+
+func init() {
+	Register("redis", &redisBackend{url: "redis://localhost:6379/0"})
+}
+
+// redisBackend connects to a Redis server.
+type redisBackend struct {
+	url string
+}
+
+func (b *redisBackend) BindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&b.url, "redis-url", b.url, "The URL of the Redis server to use with --db-backend=redis, e.g., 'redis://<user>:<password>@<host>:<port>/<db>'.")
+}
+
+// Shared implements sharedBackend, since the database is on the Redis
+// server.
+func (b *redisBackend) Shared() bool {
+	return true
+}
+
+func (b *redisBackend) Open(Options) (Database, func() error, error) {
+	redisOpts, err := redis.ParseURL(b.url)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+	client := redis.NewClient(redisOpts)
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		client.Close()
+		return nil, nil, err
+	}
+	return NewRedisDatabase(client), client.Close, nil
+}
+
+// RedisDatabase provides implementations of the tags database based on
+// Redis, so that the database can be shared, e.g., by replicas of the
+// controller.
+type RedisDatabase struct {
+	client redis.UniversalClient
+}
+
+// NewRedisDatabase creates and returns a new database implementation using
+// Redis for storing the image tags.
+func NewRedisDatabase(client redis.UniversalClient) *RedisDatabase {
+	return &RedisDatabase{
+		client: client,
+	}
+}
+
+// Tags implements the DatabaseReader interface, fetching the tags for the repo.
+//
+// If the repo does not exist, an empty set of tags is returned.
+func (a *RedisDatabase) Tags(repo string) ([]string, error) {
+	return a.getOrEmpty(tagsPrefix, repo)
+}
+
+// SetTags implements the DatabaseWriter interface, recording the tags against
+// the repo.
+//
+// It overwrites existing tag sets for the provided repo.
+func (a *RedisDatabase) SetTags(repo string, tags []string) error {
+	b, err := marshal(tags)
+	if err != nil {
+		return err
+	}
+	return a.set(keyForRepo(tagsPrefix, repo), b)
+}
+
+// PartialTags implements the PartialScanStore interface, fetching the tags
+// recorded so far by an incomplete scan of the repo.
+//
+// If there is no incomplete scan of the repo, an empty set of tags is
+// returned.
+func (a *RedisDatabase) PartialTags(repo string) ([]string, error) {
+	return a.getOrEmpty(partialTagsPrefix, repo)
+}
+
+// SetPartialTags implements the PartialScanStore interface, recording the
+// tags fetched so far by an incomplete scan of the repo.
+//
+// An empty set of tags removes the record, e.g., once the scan is complete.
+func (a *RedisDatabase) SetPartialTags(repo string, tags []string) error {
+	if len(tags) == 0 {
+		return a.client.Del(context.TODO(), string(keyForRepo(partialTagsPrefix, repo))).Err()
+	}
+	b, err := marshal(tags)
+	if err != nil {
+		return err
+	}
+	return a.set(keyForRepo(partialTagsPrefix, repo), b)
+}
+
+// CreationTime implements the CreationTimeStore interface, fetching the
+// creation time recorded for the image the tag refers to.
+//
+// If no creation time has been recorded for the tag, false is returned.
+func (a *RedisDatabase) CreationTime(repo, tag string) (time.Time, bool, error) {
+	var created time.Time
+	val, found, err := a.get(keyForTag(createdPrefix, repo, tag))
+	if err != nil || !found {
+		return created, false, err
+	}
+	return created, true, created.UnmarshalText(val)
+}
+
+// SetCreationTime implements the CreationTimeStore interface, recording the
+// creation time of the image the tag refers to.
+func (a *RedisDatabase) SetCreationTime(repo, tag string, created time.Time) error {
+	b, err := created.MarshalText()
+	if err != nil {
+		return err
+	}
+	return a.set(keyForTag(createdPrefix, repo, tag), b)
+}
+
+// FirstSeen implements the FirstSeenStore interface, fetching the times
+// recorded for when the tags of the repo were first seen.
+//
+// If nothing has been recorded for the repo, false is returned.
+func (a *RedisDatabase) FirstSeen(repo string) (map[string]time.Time, bool, error) {
+	firstSeen := map[string]time.Time{}
+	val, found, err := a.get(keyForRepo(firstSeenPrefix, repo))
+	if err != nil || !found {
+		return firstSeen, false, err
+	}
+	return firstSeen, true, json.Unmarshal(val, &firstSeen)
+}
+
+// SetFirstSeen implements the FirstSeenStore interface, recording when the
+// tags of the repo were first seen.
+//
+// It overwrites the existing record for the provided repo.
+func (a *RedisDatabase) SetFirstSeen(repo string, firstSeen map[string]time.Time) error {
+	b, err := json.Marshal(firstSeen)
+	if err != nil {
+		return err
+	}
+	return a.set(keyForRepo(firstSeenPrefix, repo), b)
+}
+
+// SetScanResult implements the ScanResultStore interface, recording the
+// tags of the repo and when each was first seen, and removing the tags
+// of an incomplete scan, in one transaction.
+func (a *RedisDatabase) SetScanResult(repo string, tags []string, firstSeen map[string]time.Time) error {
+	tagsVal, err := marshal(tags)
+	if err != nil {
+		return err
+	}
+	firstSeenVal, err := json.Marshal(firstSeen)
+	if err != nil {
+		return err
+	}
+	_, err = a.client.TxPipelined(context.TODO(), func(pipe redis.Pipeliner) error {
+		pipe.Set(context.TODO(), string(keyForRepo(firstSeenPrefix, repo)), firstSeenVal, 0)
+		pipe.Set(context.TODO(), string(keyForRepo(tagsPrefix, repo)), tagsVal, 0)
+		pipe.Del(context.TODO(), string(keyForRepo(partialTagsPrefix, repo)))
+		return nil
+	})
+	return err
+}
+
+// LastSeen implements the LastSeenStore interface, fetching the times
+// recorded for when the tags removed from the repo were last seen.
+//
+// If nothing has been recorded for the repo, false is returned.
+func (a *RedisDatabase) LastSeen(repo string) (map[string]time.Time, bool, error) {
+	lastSeen := map[string]time.Time{}
+	val, found, err := a.get(keyForRepo(lastSeenPrefix, repo))
+	if err != nil || !found {
+		return lastSeen, false, err
+	}
+	return lastSeen, true, json.Unmarshal(val, &lastSeen)
+}
+
+// SetLastSeen implements the LastSeenStore interface, recording when the
+// tags removed from the repo were last seen.
+//
+// It overwrites the existing record for the provided repo.
+func (a *RedisDatabase) SetLastSeen(repo string, lastSeen map[string]time.Time) error {
+	b, err := json.Marshal(lastSeen)
+	if err != nil {
+		return err
+	}
+	return a.set(keyForRepo(lastSeenPrefix, repo), b)
+}
+
+// TagListValidators implements the TagListValidatorStore interface,
+// fetching the validators recorded for the listing of the tags of the
+// repo.
+//
+// If nothing has been recorded for the repo, false is returned.
+func (a *RedisDatabase) TagListValidators(repo string) ([]byte, bool, error) {
+	return a.get(keyForRepo(validatorsPrefix, repo))
+}
+
+// SetTagListValidators implements the TagListValidatorStore interface,
+// recording the validators of the listing of the tags of the repo.
+//
+// It overwrites the existing record for the provided repo; empty
+// validators remove it.
+func (a *RedisDatabase) SetTagListValidators(repo string, validators []byte) error {
+	if len(validators) == 0 {
+		return a.client.Del(context.TODO(), string(keyForRepo(validatorsPrefix, repo))).Err()
+	}
+	return a.set(keyForRepo(validatorsPrefix, repo), validators)
+}
+
+// Platforms implements the PlatformStore interface, fetching the platforms
+// recorded for the image the tag refers to.
+//
+// If no platforms have been recorded for the tag, false is returned.
+func (a *RedisDatabase) Platforms(repo, tag string) (map[string]string, bool, error) {
+	platforms := map[string]string{}
+	val, found, err := a.get(keyForTag(platformsPrefix, repo, tag))
+	if err != nil || !found {
+		return platforms, false, err
+	}
+	return platforms, true, json.Unmarshal(val, &platforms)
+}
+
+// SetPlatforms implements the PlatformStore interface, recording the
+// platforms of the image the tag refers to.
+func (a *RedisDatabase) SetPlatforms(repo, tag string, platforms map[string]string) error {
+	b, err := json.Marshal(platforms)
+	if err != nil {
+		return err
+	}
+	return a.set(keyForTag(platformsPrefix, repo, tag), b)
+}
+
+// ImageConfig implements the ImageConfigStore interface, fetching the
+// config blob recorded for the image the tag refers to.
+//
+// If no config has been recorded for the tag, false is returned.
+func (a *RedisDatabase) ImageConfig(repo, tag string) ([]byte, bool, error) {
+	return a.get(keyForTag(configPrefix, repo, tag))
+}
+
+// SetImageConfig implements the ImageConfigStore interface, recording
+// the config blob of the image the tag refers to.
+func (a *RedisDatabase) SetImageConfig(repo, tag string, config []byte) error {
+	return a.set(keyForTag(configPrefix, repo, tag), config)
+}
+
+// Descriptor implements the DescriptorStore interface, fetching the
+// descriptor recorded for the manifest the tag refers to.
+//
+// If no descriptor has been recorded for the tag, false is returned.
+func (a *RedisDatabase) Descriptor(repo, tag string) (v1.Descriptor, bool, error) {
+	var desc v1.Descriptor
+	val, found, err := a.get(keyForTag(descriptorPrefix, repo, tag))
+	if err != nil || !found {
+		return desc, false, err
+	}
+	return desc, true, json.Unmarshal(val, &desc)
+}
+
+// SetDescriptor implements the DescriptorStore interface, recording the
+// descriptor of the manifest the tag refers to.
+func (a *RedisDatabase) SetDescriptor(repo, tag string, desc v1.Descriptor) error {
+	b, err := json.Marshal(desc)
+	if err != nil {
+		return err
+	}
+	return a.set(keyForTag(descriptorPrefix, repo, tag), b)
+}
+
+// Provenance implements the ProvenanceStore interface, fetching the
+// builders recorded for the image the tag refers to.
+//
+// If no builders have been recorded for the tag, false is returned.
+func (a *RedisDatabase) Provenance(repo, tag string) ([]string, bool, error) {
+	builders := []string{}
+	val, found, err := a.get(keyForTag(provenancePrefix, repo, tag))
+	if err != nil || !found {
+		return builders, false, err
+	}
+	return builders, true, json.Unmarshal(val, &builders)
+}
+
+// SetProvenance implements the ProvenanceStore interface, recording the
+// builders of the image the tag refers to.
+func (a *RedisDatabase) SetProvenance(repo, tag string, builders []string) error {
+	if builders == nil {
+		builders = []string{}
+	}
+	b, err := json.Marshal(builders)
+	if err != nil {
+		return err
+	}
+	return a.set(keyForTag(provenancePrefix, repo, tag), b)
+}
+
+// DeleteRepository implements the DatabaseDeleter interface, removing
+// everything recorded for the repo.
+func (a *RedisDatabase) DeleteRepository(repo string) error {
+	ctx := context.TODO()
+	keys := []string{
+		string(keyForRepo(tagsPrefix, repo)),
+		string(keyForRepo(partialTagsPrefix, repo)),
+		string(keyForRepo(firstSeenPrefix, repo)),
+		string(keyForRepo(lastSeenPrefix, repo)),
+		string(keyForRepo(validatorsPrefix, repo)),
+	}
+	for _, prefix := range []string{createdPrefix, platformsPrefix, configPrefix, descriptorPrefix, provenancePrefix} {
+		pattern := redisGlobEscaper.Replace(string(keyForTag(prefix, repo, ""))) + "*"
+		iter := a.client.Scan(ctx, 0, pattern, 0).Iterator()
+		for iter.Next(ctx) {
+			keys = append(keys, iter.Val())
+		}
+		if err := iter.Err(); err != nil {
+			return err
+		}
+	}
+	return a.client.Del(ctx, keys...).Err()
+}
+
+// Repositories implements the RepositoryLister interface, returning
+// the repos with anything recorded, in order.
+func (a *RedisDatabase) Repositories() ([]string, error) {
+	ctx := context.TODO()
+	repos := map[string]struct{}{}
+	for _, prefix := range []string{tagsPrefix, partialTagsPrefix, firstSeenPrefix, lastSeenPrefix, validatorsPrefix, createdPrefix, platformsPrefix, configPrefix, descriptorPrefix, provenancePrefix} {
+		iter := a.client.Scan(ctx, 0, redisGlobEscaper.Replace(prefix)+":*", 0).Iterator()
+		for iter.Next(ctx) {
+			if repo, ok := repoForKey(iter.Val()); ok {
+				repos[repo] = struct{}{}
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return sortedKeys(repos), nil
+}
+
+// redisGlobEscaper escapes the characters special to the patterns of the
+// Redis SCAN command.
+var redisGlobEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// get returns the value of the key, and whether it exists.
+func (a *RedisDatabase) get(key []byte) ([]byte, bool, error) {
+	val, err := a.client.Get(context.TODO(), string(key)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return val, true, nil
+}
+
+func (a *RedisDatabase) set(key, val []byte) error {
+	return a.client.Set(context.TODO(), string(key), val, 0).Err()
+}
+
+func (a *RedisDatabase) getOrEmpty(prefix, repo string) ([]string, error) {
+	val, found, err := a.get(keyForRepo(prefix, repo))
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return []string{}, nil
+	}
+	return unmarshal(val)
+}
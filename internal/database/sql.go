@@ -0,0 +1,427 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// sqlDialect gives what differs between the SQL databases supported.
+type sqlDialect struct {
+	// migrations are the changes to the schema of the database, in
+	// order. Each is applied once, and recorded in the schema_version
+	// table; add to the end rather than changing them.
+	migrations []string
+	// lock, if given, is run at the start of the transaction migrating
+	// the schema, to keep other replicas of the controller from doing
+	// so at the same time.
+	lock string
+}
+
+// SQLDatabase provides implementations of the tags database based on an
+// SQL database, with a table for each kind of record.
+type SQLDatabase struct {
+	db *sql.DB
+}
+
+func newSQLDatabase(db *sql.DB, dialect sqlDialect) (*SQLDatabase, error) {
+	if err := migrate(db, dialect); err != nil {
+		return nil, fmt.Errorf("failed to migrate the database schema: %w", err)
+	}
+	return &SQLDatabase{
+		db: db,
+	}, nil
+}
+
+func migrate(db *sql.DB, dialect sqlDialect) error {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)`); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if dialect.lock != "" {
+		if _, err := tx.Exec(dialect.lock); err != nil {
+			return err
+		}
+	}
+	var version int
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version); err != nil {
+		return err
+	}
+	for i := version; i < len(dialect.migrations); i++ {
+		if _, err := tx.Exec(dialect.migrations[i]); err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+	}
+	if version < len(dialect.migrations) {
+		if _, err := tx.Exec(`INSERT INTO schema_version (version) VALUES ($1)`, len(dialect.migrations)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Tags implements the DatabaseReader interface, fetching the tags for the repo.
+//
+// If the repo does not exist, an empty set of tags is returned.
+func (a *SQLDatabase) Tags(repo string) ([]string, error) {
+	return a.getOrEmpty(`SELECT tags FROM tags WHERE repo = $1`, repo)
+}
+
+// SetTags implements the DatabaseWriter interface, recording the tags against
+// the repo.
+//
+// It overwrites existing tag sets for the provided repo.
+func (a *SQLDatabase) SetTags(repo string, tags []string) error {
+	b, err := marshal(tags)
+	if err != nil {
+		return err
+	}
+	_, err = a.db.Exec(`INSERT INTO tags (repo, tags) VALUES ($1, $2)
+		ON CONFLICT (repo) DO UPDATE SET tags = EXCLUDED.tags`, repo, string(b))
+	return err
+}
+
+// PartialTags implements the PartialScanStore interface, fetching the tags
+// recorded so far by an incomplete scan of the repo.
+//
+// If there is no incomplete scan of the repo, an empty set of tags is
+// returned.
+func (a *SQLDatabase) PartialTags(repo string) ([]string, error) {
+	return a.getOrEmpty(`SELECT tags FROM partial_tags WHERE repo = $1`, repo)
+}
+
+// SetPartialTags implements the PartialScanStore interface, recording the
+// tags fetched so far by an incomplete scan of the repo.
+//
+// An empty set of tags removes the record, e.g., once the scan is complete.
+func (a *SQLDatabase) SetPartialTags(repo string, tags []string) error {
+	if len(tags) == 0 {
+		_, err := a.db.Exec(`DELETE FROM partial_tags WHERE repo = $1`, repo)
+		return err
+	}
+	b, err := marshal(tags)
+	if err != nil {
+		return err
+	}
+	_, err = a.db.Exec(`INSERT INTO partial_tags (repo, tags) VALUES ($1, $2)
+		ON CONFLICT (repo) DO UPDATE SET tags = EXCLUDED.tags`, repo, string(b))
+	return err
+}
+
+// CreationTime implements the CreationTimeStore interface, fetching the
+// creation time recorded for the image the tag refers to.
+//
+// If no creation time has been recorded for the tag, false is returned.
+func (a *SQLDatabase) CreationTime(repo, tag string) (time.Time, bool, error) {
+	var created time.Time
+	err := a.db.QueryRow(`SELECT created FROM creation_times WHERE repo = $1 AND tag = $2`, repo, tag).Scan(&created)
+	if errors.Is(err, sql.ErrNoRows) {
+		return created, false, nil
+	}
+	return created, err == nil, err
+}
+
+// SetCreationTime implements the CreationTimeStore interface, recording the
+// creation time of the image the tag refers to.
+func (a *SQLDatabase) SetCreationTime(repo, tag string, created time.Time) error {
+	_, err := a.db.Exec(`INSERT INTO creation_times (repo, tag, created) VALUES ($1, $2, $3)
+		ON CONFLICT (repo, tag) DO UPDATE SET created = EXCLUDED.created`, repo, tag, created)
+	return err
+}
+
+// FirstSeen implements the FirstSeenStore interface, fetching the times
+// recorded for when the tags of the repo were first seen.
+//
+// If nothing has been recorded for the repo, false is returned.
+func (a *SQLDatabase) FirstSeen(repo string) (map[string]time.Time, bool, error) {
+	firstSeen := map[string]time.Time{}
+	val, found, err := a.get(`SELECT first_seen FROM first_seen WHERE repo = $1`, repo)
+	if err != nil || !found {
+		return firstSeen, false, err
+	}
+	return firstSeen, true, json.Unmarshal(val, &firstSeen)
+}
+
+// SetFirstSeen implements the FirstSeenStore interface, recording when the
+// tags of the repo were first seen.
+//
+// It overwrites the existing record for the provided repo.
+func (a *SQLDatabase) SetFirstSeen(repo string, firstSeen map[string]time.Time) error {
+	b, err := json.Marshal(firstSeen)
+	if err != nil {
+		return err
+	}
+	_, err = a.db.Exec(`INSERT INTO first_seen (repo, first_seen) VALUES ($1, $2)
+		ON CONFLICT (repo) DO UPDATE SET first_seen = EXCLUDED.first_seen`, repo, string(b))
+	return err
+}
+
+// SetScanResult implements the ScanResultStore interface, recording the
+// tags of the repo and when each was first seen, and removing the tags
+// of an incomplete scan, in one transaction.
+func (a *SQLDatabase) SetScanResult(repo string, tags []string, firstSeen map[string]time.Time) error {
+	tagsVal, err := marshal(tags)
+	if err != nil {
+		return err
+	}
+	firstSeenVal, err := json.Marshal(firstSeen)
+	if err != nil {
+		return err
+	}
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`INSERT INTO first_seen (repo, first_seen) VALUES ($1, $2)
+		ON CONFLICT (repo) DO UPDATE SET first_seen = EXCLUDED.first_seen`, repo, string(firstSeenVal)); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO tags (repo, tags) VALUES ($1, $2)
+		ON CONFLICT (repo) DO UPDATE SET tags = EXCLUDED.tags`, repo, string(tagsVal)); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM partial_tags WHERE repo = $1`, repo); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// LastSeen implements the LastSeenStore interface, fetching the times
+// recorded for when the tags removed from the repo were last seen.
+//
+// If nothing has been recorded for the repo, false is returned.
+func (a *SQLDatabase) LastSeen(repo string) (map[string]time.Time, bool, error) {
+	lastSeen := map[string]time.Time{}
+	val, found, err := a.get(`SELECT last_seen FROM last_seen WHERE repo = $1`, repo)
+	if err != nil || !found {
+		return lastSeen, false, err
+	}
+	return lastSeen, true, json.Unmarshal(val, &lastSeen)
+}
+
+// SetLastSeen implements the LastSeenStore interface, recording when the
+// tags removed from the repo were last seen.
+//
+// It overwrites the existing record for the provided repo.
+func (a *SQLDatabase) SetLastSeen(repo string, lastSeen map[string]time.Time) error {
+	b, err := json.Marshal(lastSeen)
+	if err != nil {
+		return err
+	}
+	_, err = a.db.Exec(`INSERT INTO last_seen (repo, last_seen) VALUES ($1, $2)
+		ON CONFLICT (repo) DO UPDATE SET last_seen = EXCLUDED.last_seen`, repo, string(b))
+	return err
+}
+
+// TagListValidators implements the TagListValidatorStore interface,
+// fetching the validators recorded for the listing of the tags of the
+// repo.
+//
+// If nothing has been recorded for the repo, false is returned.
+func (a *SQLDatabase) TagListValidators(repo string) ([]byte, bool, error) {
+	return a.get(`SELECT validators FROM tag_list_validators WHERE repo = $1`, repo)
+}
+
+// SetTagListValidators implements the TagListValidatorStore interface,
+// recording the validators of the listing of the tags of the repo.
+//
+// It overwrites the existing record for the provided repo; empty
+// validators remove it.
+func (a *SQLDatabase) SetTagListValidators(repo string, validators []byte) error {
+	if len(validators) == 0 {
+		_, err := a.db.Exec(`DELETE FROM tag_list_validators WHERE repo = $1`, repo)
+		return err
+	}
+	_, err := a.db.Exec(`INSERT INTO tag_list_validators (repo, validators) VALUES ($1, $2)
+		ON CONFLICT (repo) DO UPDATE SET validators = EXCLUDED.validators`, repo, validators)
+	return err
+}
+
+// Platforms implements the PlatformStore interface, fetching the platforms
+// recorded for the image the tag refers to.
+//
+// If no platforms have been recorded for the tag, false is returned.
+func (a *SQLDatabase) Platforms(repo, tag string) (map[string]string, bool, error) {
+	platforms := map[string]string{}
+	val, found, err := a.get(`SELECT platforms FROM platforms WHERE repo = $1 AND tag = $2`, repo, tag)
+	if err != nil || !found {
+		return platforms, false, err
+	}
+	return platforms, true, json.Unmarshal(val, &platforms)
+}
+
+// SetPlatforms implements the PlatformStore interface, recording the
+// platforms of the image the tag refers to.
+func (a *SQLDatabase) SetPlatforms(repo, tag string, platforms map[string]string) error {
+	b, err := json.Marshal(platforms)
+	if err != nil {
+		return err
+	}
+	_, err = a.db.Exec(`INSERT INTO platforms (repo, tag, platforms) VALUES ($1, $2, $3)
+		ON CONFLICT (repo, tag) DO UPDATE SET platforms = EXCLUDED.platforms`, repo, tag, string(b))
+	return err
+}
+
+// ImageConfig implements the ImageConfigStore interface, fetching the
+// config blob recorded for the image the tag refers to.
+//
+// If no config has been recorded for the tag, false is returned.
+func (a *SQLDatabase) ImageConfig(repo, tag string) ([]byte, bool, error) {
+	return a.get(`SELECT config FROM image_configs WHERE repo = $1 AND tag = $2`, repo, tag)
+}
+
+// SetImageConfig implements the ImageConfigStore interface, recording
+// the config blob of the image the tag refers to.
+func (a *SQLDatabase) SetImageConfig(repo, tag string, config []byte) error {
+	_, err := a.db.Exec(`INSERT INTO image_configs (repo, tag, config) VALUES ($1, $2, $3)
+		ON CONFLICT (repo, tag) DO UPDATE SET config = EXCLUDED.config`, repo, tag, config)
+	return err
+}
+
+// Descriptor implements the DescriptorStore interface, fetching the
+// descriptor recorded for the manifest the tag refers to.
+//
+// If no descriptor has been recorded for the tag, false is returned.
+func (a *SQLDatabase) Descriptor(repo, tag string) (v1.Descriptor, bool, error) {
+	var desc v1.Descriptor
+	val, found, err := a.get(`SELECT descriptor FROM descriptors WHERE repo = $1 AND tag = $2`, repo, tag)
+	if err != nil || !found {
+		return desc, false, err
+	}
+	return desc, true, json.Unmarshal(val, &desc)
+}
+
+// SetDescriptor implements the DescriptorStore interface, recording the
+// descriptor of the manifest the tag refers to.
+func (a *SQLDatabase) SetDescriptor(repo, tag string, desc v1.Descriptor) error {
+	b, err := json.Marshal(desc)
+	if err != nil {
+		return err
+	}
+	_, err = a.db.Exec(`INSERT INTO descriptors (repo, tag, descriptor) VALUES ($1, $2, $3)
+		ON CONFLICT (repo, tag) DO UPDATE SET descriptor = EXCLUDED.descriptor`, repo, tag, string(b))
+	return err
+}
+
+// Provenance implements the ProvenanceStore interface, fetching the
+// builders recorded for the image the tag refers to.
+//
+// If no builders have been recorded for the tag, false is returned.
+func (a *SQLDatabase) Provenance(repo, tag string) ([]string, bool, error) {
+	builders := []string{}
+	val, found, err := a.get(`SELECT builders FROM provenance WHERE repo = $1 AND tag = $2`, repo, tag)
+	if err != nil || !found {
+		return builders, false, err
+	}
+	return builders, true, json.Unmarshal(val, &builders)
+}
+
+// SetProvenance implements the ProvenanceStore interface, recording the
+// builders of the image the tag refers to.
+func (a *SQLDatabase) SetProvenance(repo, tag string, builders []string) error {
+	if builders == nil {
+		builders = []string{}
+	}
+	b, err := json.Marshal(builders)
+	if err != nil {
+		return err
+	}
+	_, err = a.db.Exec(`INSERT INTO provenance (repo, tag, builders) VALUES ($1, $2, $3)
+		ON CONFLICT (repo, tag) DO UPDATE SET builders = EXCLUDED.builders`, repo, tag, string(b))
+	return err
+}
+
+// DeleteRepository implements the DatabaseDeleter interface, removing
+// everything recorded for the repo.
+func (a *SQLDatabase) DeleteRepository(repo string) error {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, table := range []string{"tags", "partial_tags", "first_seen", "last_seen", "creation_times", "platforms", "image_configs", "descriptors", "tag_list_validators", "provenance"} {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE repo = $1`, repo); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Repositories implements the RepositoryLister interface, returning
+// the repos with anything recorded, in order.
+func (a *SQLDatabase) Repositories() ([]string, error) {
+	rows, err := a.db.Query(`SELECT repo FROM tags
+		UNION SELECT repo FROM partial_tags
+		UNION SELECT repo FROM first_seen
+		UNION SELECT repo FROM last_seen
+		UNION SELECT repo FROM creation_times
+		UNION SELECT repo FROM platforms
+		UNION SELECT repo FROM image_configs
+		UNION SELECT repo FROM descriptors
+		UNION SELECT repo FROM tag_list_validators
+		UNION SELECT repo FROM provenance
+		ORDER BY repo`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var repos []string
+	for rows.Next() {
+		var repo string
+		if err := rows.Scan(&repo); err != nil {
+			return nil, err
+		}
+		repos = append(repos, repo)
+	}
+	return repos, rows.Err()
+}
+
+// get returns the value selected by the query, and whether there is one.
+func (a *SQLDatabase) get(query string, args ...interface{}) ([]byte, bool, error) {
+	var val []byte
+	err := a.db.QueryRow(query, args...).Scan(&val)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return val, true, nil
+}
+
+func (a *SQLDatabase) getOrEmpty(query, repo string) ([]string, error) {
+	val, found, err := a.get(query, repo)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return []string{}, nil
+	}
+	return unmarshal(val)
+}
@@ -0,0 +1,47 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package database
+
+import (
+	"testing"
+)
+
+// sqlDatabases create each of the SQL databases for the tests.
+var sqlDatabases = map[string]func(t *testing.T) *SQLDatabase{
+	"postgres": createPostgresDatabase,
+	"sqlite":   createSQLiteDatabase,
+}
+
+func TestSQLMigrationIsIdempotent(t *testing.T) {
+	dialects := map[string]sqlDialect{
+		"postgres": postgresDialect,
+		"sqlite":   sqliteDialect,
+	}
+	for name, create := range sqlDatabases {
+		t.Run(name, func(t *testing.T) {
+			db := create(t)
+			dialect := dialects[name]
+			fatalIfError(t, migrate(db.db, dialect))
+
+			var version int
+			fatalIfError(t, db.db.QueryRow(`SELECT MAX(version) FROM schema_version`).Scan(&version))
+			if version != len(dialect.migrations) {
+				t.Fatalf("schema version got %d, want %d", version, len(dialect.migrations))
+			}
+		})
+	}
+}
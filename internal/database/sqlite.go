@@ -0,0 +1,114 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package database
+
+import (
+	"database/sql"
+	"path/filepath"
+
+	flag "github.com/spf13/pflag"
+	_ "modernc.org/sqlite"
+)
+
+func init() {
+	Register("sqlite", sqliteBackend{})
+}
+
+// sqliteBackend opens an SQLite database in a file under the storage
+// path.
+type sqliteBackend struct{}
+
+func (sqliteBackend) BindFlags(*flag.FlagSet) {}
+
+func (sqliteBackend) Open(opts Options) (Database, func() error, error) {
+	sqlDB, err := sql.Open("sqlite", filepath.Join(opts.StoragePath, "metadata.sqlite"))
+	if err != nil {
+		return nil, nil, err
+	}
+	db, err := NewSQLiteDatabase(sqlDB)
+	if err != nil {
+		sqlDB.Close()
+		return nil, nil, err
+	}
+	return db, sqlDB.Close, nil
+}
+
+// sqliteDialect gives the schema of the SQLite database. Times are
+// declared as TIMESTAMP so that the driver parses them.
+var sqliteDialect = sqlDialect{
+	migrations: []string{
+		`CREATE TABLE tags (
+			repo TEXT PRIMARY KEY,
+			tags TEXT NOT NULL
+		);
+		CREATE TABLE partial_tags (
+			repo TEXT PRIMARY KEY,
+			tags TEXT NOT NULL
+		);
+		CREATE TABLE first_seen (
+			repo       TEXT PRIMARY KEY,
+			first_seen TEXT NOT NULL
+		);
+		CREATE TABLE creation_times (
+			repo    TEXT NOT NULL,
+			tag     TEXT NOT NULL,
+			created TIMESTAMP NOT NULL,
+			PRIMARY KEY (repo, tag)
+		);
+		CREATE TABLE platforms (
+			repo      TEXT NOT NULL,
+			tag       TEXT NOT NULL,
+			platforms TEXT NOT NULL,
+			PRIMARY KEY (repo, tag)
+		);
+		CREATE TABLE image_configs (
+			repo   TEXT NOT NULL,
+			tag    TEXT NOT NULL,
+			config BLOB NOT NULL,
+			PRIMARY KEY (repo, tag)
+		);`,
+		`CREATE TABLE descriptors (
+			repo       TEXT NOT NULL,
+			tag        TEXT NOT NULL,
+			descriptor TEXT NOT NULL,
+			PRIMARY KEY (repo, tag)
+		);`,
+		`CREATE TABLE last_seen (
+			repo      TEXT PRIMARY KEY,
+			last_seen TEXT NOT NULL
+		);`,
+		`CREATE TABLE tag_list_validators (
+			repo       TEXT PRIMARY KEY,
+			validators BLOB NOT NULL
+		);`,
+		`CREATE TABLE provenance (
+			repo     TEXT NOT NULL,
+			tag      TEXT NOT NULL,
+			builders TEXT NOT NULL,
+			PRIMARY KEY (repo, tag)
+		);`,
+	},
+}
+
+// NewSQLiteDatabase creates and returns a new database implementation
+// using SQLite for storing the image tags, first bringing the schema of
+// the database up to date. The database is limited to one connection,
+// since SQLite allows only one writer at a time.
+func NewSQLiteDatabase(db *sql.DB) (*SQLDatabase, error) {
+	db.SetMaxOpenConns(1)
+	return newSQLDatabase(db, sqliteDialect)
+}
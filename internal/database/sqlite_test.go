@@ -0,0 +1,45 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package database
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func createSQLiteDatabase(t *testing.T) *SQLDatabase {
+	t.Helper()
+	dir, err := os.MkdirTemp(os.TempDir(), "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := sql.Open("sqlite", filepath.Join(dir, "metadata.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		sqlDB.Close()
+		os.RemoveAll(dir)
+	})
+	db, err := NewSQLiteDatabase(sqlDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
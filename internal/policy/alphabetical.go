@@ -52,15 +52,19 @@ func NewAlphabetical(order string) (*Alphabetical, error) {
 
 // Latest returns latest version from a provided list of strings
 func (p *Alphabetical) Latest(versions []string) (string, error) {
+	return latest(p.Sort(versions))
+}
+
+// Sort returns the versions provided, the latest first
+func (p *Alphabetical) Sort(versions []string) ([]string, error) {
 	if len(versions) == 0 {
-		return "", fmt.Errorf("version list argument cannot be empty")
+		return nil, fmt.Errorf("version list argument cannot be empty")
 	}
-
-	var sorted sort.StringSlice = versions
+	sorted := append(sort.StringSlice(nil), versions...)
 	if p.Order == AlphabeticalOrderDesc {
 		sort.Sort(sorted)
 	} else {
 		sort.Sort(sort.Reverse(sorted))
 	}
-	return sorted[0], nil
+	return sorted, nil
 }
@@ -115,6 +115,17 @@ func TestAlphabetical_Latest(t *testing.T) {
 			order:           AlphabeticalOrderDesc,
 			expectedVersion: "1990-01-08T00-20-00Z",
 		},
+		{
+			label:           "With RELEASE prefixed RFC3339",
+			versions:        []string{"RELEASE.2022-05-01T12-00-00Z", "RELEASE.2022-04-30T23-59-59Z", "RELEASE.2022-05-01T09-30-00Z", "RELEASE.2021-12-31T12-00-00Z"},
+			expectedVersion: "RELEASE.2022-05-01T12-00-00Z",
+		},
+		{
+			label:           "With RELEASE prefixed RFC3339 desc",
+			versions:        []string{"RELEASE.2022-05-01T12-00-00Z", "RELEASE.2022-04-30T23-59-59Z", "RELEASE.2022-05-01T09-30-00Z", "RELEASE.2021-12-31T12-00-00Z"},
+			order:           AlphabeticalOrderDesc,
+			expectedVersion: "RELEASE.2021-12-31T12-00-00Z",
+		},
 		{
 			label:     "Empty version list",
 			versions:  []string{},
@@ -0,0 +1,152 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package policy
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// calVerTokens are the parts of a calendar version format, as described
+// at https://calver.org, and the regular expressions matching them. The
+// tokens are in the order they are tried when parsing a format, so that
+// a longer token is matched before a shorter token it starts with.
+var calVerTokens = []struct {
+	token    string
+	pattern  string
+	calendar bool
+}{
+	{token: "MAJOR", pattern: `[0-9]+`},
+	{token: "MINOR", pattern: `[0-9]+`},
+	{token: "MICRO", pattern: `[0-9]+`},
+	{token: "YYYY", pattern: `[0-9]{4}`, calendar: true},
+	{token: "YY", pattern: `[0-9]{1,3}`, calendar: true},
+	{token: "0Y", pattern: `[0-9]{2,3}`, calendar: true},
+	{token: "MM", pattern: `1[0-2]|[1-9]`, calendar: true},
+	{token: "0M", pattern: `0[1-9]|1[0-2]`, calendar: true},
+	{token: "WW", pattern: `5[0-3]|[1-4][0-9]|[1-9]`, calendar: true},
+	{token: "0W", pattern: `5[0-3]|[1-4][0-9]|0[1-9]`, calendar: true},
+	{token: "DD", pattern: `3[01]|[12][0-9]|[1-9]`, calendar: true},
+	{token: "0D", pattern: `3[01]|[12][0-9]|0[1-9]`, calendar: true},
+}
+
+// CalVer represents a calendar versioning policy
+type CalVer struct {
+	Format string
+
+	pattern *regexp.Regexp
+}
+
+// NewCalVer constructs a CalVer object validating the provided format,
+// which is made of the tokens described at https://calver.org (e.g.,
+// `YYYY.0M.0D` or `YY.MM.MICRO`) and the separators between them
+func NewCalVer(format string) (*CalVer, error) {
+	var expr strings.Builder
+	var calendar bool
+	expr.WriteString("^")
+	for rest := format; rest != ""; {
+		matched := false
+		for _, t := range calVerTokens {
+			if strings.HasPrefix(rest, t.token) {
+				expr.WriteString("(" + t.pattern + ")")
+				calendar = calendar || t.calendar
+				rest = rest[len(t.token):]
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			expr.WriteString(regexp.QuoteMeta(rest[:1]))
+			rest = rest[1:]
+		}
+	}
+	expr.WriteString("$")
+
+	if !calendar {
+		return nil, fmt.Errorf("invalid calver format '%s': must contain at least one of the year, month, week or day tokens", format)
+	}
+
+	pattern, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid calver format '%s': %w", format, err)
+	}
+
+	return &CalVer{
+		Format:  format,
+		pattern: pattern,
+	}, nil
+}
+
+// Latest returns latest version from a provided list of strings
+func (p *CalVer) Latest(versions []string) (string, error) {
+	return latest(p.Sort(versions))
+}
+
+// Sort returns the versions provided that match the format, the latest
+// first; of equal versions, the one provided first is first
+func (p *CalVer) Sort(versions []string) ([]string, error) {
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("version list argument cannot be empty")
+	}
+
+	var sorted []string
+	parsed := make(map[string][]int, len(versions))
+	for _, version := range versions {
+		if parts, ok := p.parse(version); ok {
+			sorted = append(sorted, version)
+			parsed[version] = parts
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return compareCalVer(parsed[sorted[i]], parsed[sorted[j]]) > 0
+	})
+	return sorted, nil
+}
+
+// parse returns the numbers in the version, in the order they appear in
+// the format, or false if the version does not match the format.
+func (p *CalVer) parse(version string) ([]int, bool) {
+	matches := p.pattern.FindStringSubmatch(version)
+	if matches == nil {
+		return nil, false
+	}
+	parts := make([]int, len(matches)-1)
+	for i, m := range matches[1:] {
+		n, err := strconv.Atoi(m)
+		if err != nil {
+			return nil, false
+		}
+		parts[i] = n
+	}
+	return parts, true
+}
+
+// compareCalVer compares two versions of the same format part by part.
+func compareCalVer(a, b []int) int {
+	for i := range a {
+		switch {
+		case a[i] > b[i]:
+			return 1
+		case a[i] < b[i]:
+			return -1
+		}
+	}
+	return 0
+}
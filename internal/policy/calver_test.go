@@ -0,0 +1,130 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package policy
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewCalVer(t *testing.T) {
+	cases := []struct {
+		label     string
+		formats   []string
+		expectErr bool
+	}{
+		{
+			label:   "With valid format",
+			formats: []string{"YYYY.0M.0D", "YY.MM.MICRO", "YYYY.WW", "v0Y.0M", "YYYY-MM-DD_MAJOR"},
+		},
+		{
+			label:     "With no calendar token",
+			formats:   []string{"MAJOR.MINOR.MICRO", "v1", ""},
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range cases {
+		for _, f := range tt.formats {
+			t.Run(tt.label, func(t *testing.T) {
+				_, err := NewCalVer(f)
+				if tt.expectErr && err == nil {
+					t.Fatalf("expecting error, got nil for format value: '%s'", f)
+				}
+				if !tt.expectErr && err != nil {
+					t.Fatalf("returned unexpected error: %s", err)
+				}
+			})
+		}
+	}
+}
+
+func TestCalVer_Latest(t *testing.T) {
+	cases := []struct {
+		label           string
+		format          string
+		versions        []string
+		expectedVersion string
+		expectErr       bool
+	}{
+		{
+			label:           "With zero-padded dates",
+			format:          "YYYY.0M.0D",
+			versions:        shuffle([]string{"2021.12.31", "2022.01.02", "2022.01.10", "2021.02.28"}),
+			expectedVersion: "2022.01.10",
+		},
+		{
+			label:           "With unpadded months and micro",
+			format:          "YY.MM.MICRO",
+			versions:        shuffle([]string{"22.9.10", "22.10.1", "22.10.2", "22.2.100", "21.12.5"}),
+			expectedVersion: "22.10.2",
+		},
+		{
+			label:           "With prefix and tags not matching the format",
+			format:          "vYYYY.0M",
+			versions:        []string{"v2022.03", "latest", "v2022.11", "2023.01", "v2022.13", "1.0.0"},
+			expectedVersion: "v2022.11",
+		},
+		{
+			label:     "With no tags matching the format",
+			format:    "YYYY.0M.0D",
+			versions:  []string{"latest", "1.0.0", "2022.1.1"},
+			expectErr: true,
+		},
+		{
+			label:     "Empty version list",
+			format:    "YYYY.0M.0D",
+			versions:  []string{},
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.label, func(t *testing.T) {
+			policy, err := NewCalVer(tt.format)
+			if err != nil {
+				t.Fatalf("returned unexpected error: %s", err)
+			}
+			latest, err := policy.Latest(tt.versions)
+			if tt.expectErr && err == nil {
+				t.Fatalf("expecting error, got nil")
+			}
+			if !tt.expectErr && err != nil {
+				t.Fatalf("returned unexpected error: %s", err)
+			}
+
+			if latest != tt.expectedVersion {
+				t.Errorf("incorrect computed version returned, got '%s', expected '%s'", latest, tt.expectedVersion)
+			}
+		})
+	}
+}
+
+func TestCalVer_Sort(t *testing.T) {
+	policy, err := NewCalVer("YYYY.0M")
+	if err != nil {
+		t.Fatalf("returned unexpected error: %s", err)
+	}
+	sorted, err := policy.Sort([]string{"2022.03", "latest", "2023.01", "2022.11"})
+	if err != nil {
+		t.Fatalf("returned unexpected error: %s", err)
+	}
+	expected := []string{"2023.01", "2022.11", "2022.03"}
+	if !reflect.DeepEqual(sorted, expected) {
+		t.Errorf("incorrect sorted versions returned, got %v, expected %v", sorted, expected)
+	}
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package policy
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// CreatedAtOrderAsc ascending order
+	CreatedAtOrderAsc = "ASC"
+	// CreatedAtOrderDesc descending order
+	CreatedAtOrderDesc = "DESC"
+)
+
+// CreatedAt represents a policy ordering tags by the creation time of the
+// images they refer to
+type CreatedAt struct {
+	Order string
+
+	// CreationTime looks up the creation time of the image a tag refers
+	// to, returning false if it is not known, in which case the tag is
+	// left out. It must be set before calling Latest.
+	CreationTime func(tag string) (time.Time, bool, error)
+}
+
+// NewCreatedAt constructs a CreatedAt object validating the provided
+// order argument
+func NewCreatedAt(order string) (*CreatedAt, error) {
+	switch order {
+	case "":
+		order = CreatedAtOrderAsc
+	case CreatedAtOrderAsc, CreatedAtOrderDesc:
+		break
+	default:
+		return nil, fmt.Errorf("invalid order argument provided: '%s', must be one of: %s, %s", order, CreatedAtOrderAsc, CreatedAtOrderDesc)
+	}
+
+	return &CreatedAt{
+		Order: order,
+	}, nil
+}
+
+// Latest returns latest version from a provided list of strings
+func (p *CreatedAt) Latest(versions []string) (string, error) {
+	return latest(p.Sort(versions))
+}
+
+// Sort returns the versions provided, the latest first; of images created
+// at the same time, the tag provided last is first. Versions whose images
+// have no known creation time are left out.
+func (p *CreatedAt) Sort(versions []string) ([]string, error) {
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("version list argument cannot be empty")
+	}
+	if p.CreationTime == nil {
+		return nil, fmt.Errorf("no lookup for image creation times given")
+	}
+
+	created := make(map[string]time.Time, len(versions))
+	var known []string
+	for _, version := range versions {
+		ct, ok, err := p.CreationTime(version)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get creation time of image for tag '%s': %w", version, err)
+		}
+		if ok {
+			created[version] = ct
+			known = append(known, version)
+		}
+	}
+
+	sorted := reversed(known)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if p.Order == CreatedAtOrderDesc {
+			return created[sorted[i]].Before(created[sorted[j]])
+		}
+		return created[sorted[i]].After(created[sorted[j]])
+	})
+	return sorted, nil
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package policy
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestNewCreatedAt(t *testing.T) {
+	cases := []struct {
+		label     string
+		order     string
+		expectErr bool
+	}{
+		{
+			label: "With valid empty order",
+			order: "",
+		},
+		{
+			label: "With valid asc order",
+			order: CreatedAtOrderAsc,
+		},
+		{
+			label: "With valid desc order",
+			order: CreatedAtOrderDesc,
+		},
+		{
+			label:     "With invalid order",
+			order:     "invalid",
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.label, func(t *testing.T) {
+			_, err := NewCreatedAt(tt.order)
+			if tt.expectErr && err == nil {
+				t.Fatalf("expecting error, got nil")
+			}
+			if !tt.expectErr && err != nil {
+				t.Fatalf("returned unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestCreatedAt_Latest(t *testing.T) {
+	created := map[string]time.Time{
+		"main-a1b2c3d": time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC),
+		"main-e4f5a6b": time.Date(2022, 5, 3, 9, 30, 0, 0, time.UTC),
+		"main-c7d8e9f": time.Date(2022, 4, 28, 18, 45, 0, 0, time.UTC),
+		"main-0a1b2c3": time.Date(2022, 5, 2, 0, 0, 0, 0, time.UTC),
+	}
+	lookup := func(tag string) (time.Time, bool, error) {
+		if tag == "failing" {
+			return time.Time{}, false, fmt.Errorf("failed to look up tag '%s'", tag)
+		}
+		t, ok := created[tag]
+		return t, ok, nil
+	}
+
+	cases := []struct {
+		label           string
+		order           string
+		versions        []string
+		lookup          func(string) (time.Time, bool, error)
+		expectedVersion string
+		expectErr       bool
+	}{
+		{
+			label:           "With ascending order",
+			versions:        shuffle([]string{"main-a1b2c3d", "main-e4f5a6b", "main-c7d8e9f", "main-0a1b2c3"}),
+			lookup:          lookup,
+			expectedVersion: "main-e4f5a6b",
+		},
+		{
+			label:           "With descending order",
+			order:           CreatedAtOrderDesc,
+			versions:        shuffle([]string{"main-a1b2c3d", "main-e4f5a6b", "main-c7d8e9f", "main-0a1b2c3"}),
+			lookup:          lookup,
+			expectedVersion: "main-c7d8e9f",
+		},
+		{
+			label:           "With an unknown creation time",
+			versions:        shuffle([]string{"main-a1b2c3d", "main-e4f5a6b", "unknown"}),
+			lookup:          lookup,
+			expectedVersion: "main-e4f5a6b",
+		},
+		{
+			label:     "With only unknown creation times",
+			versions:  []string{"unknown"},
+			lookup:    lookup,
+			expectErr: true,
+		},
+		{
+			label:     "With a failed lookup",
+			versions:  []string{"main-a1b2c3d", "failing"},
+			lookup:    lookup,
+			expectErr: true,
+		},
+		{
+			label:     "Without a lookup",
+			versions:  []string{"main-a1b2c3d"},
+			expectErr: true,
+		},
+		{
+			label:     "Empty version list",
+			versions:  []string{},
+			lookup:    lookup,
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.label, func(t *testing.T) {
+			policy, err := NewCreatedAt(tt.order)
+			if err != nil {
+				t.Fatalf("returned unexpected error: %s", err)
+			}
+			policy.CreationTime = tt.lookup
+			latest, err := policy.Latest(tt.versions)
+			if tt.expectErr && err == nil {
+				t.Fatalf("expecting error, got nil")
+			}
+			if !tt.expectErr && err != nil {
+				t.Fatalf("returned unexpected error: %s", err)
+			}
+
+			if latest != tt.expectedVersion {
+				t.Errorf("incorrect computed version returned, got '%s', expected '%s'", latest, tt.expectedVersion)
+			}
+		})
+	}
+}
@@ -29,11 +29,19 @@ func PolicerFromSpec(choice imagev1.ImagePolicyChoice) (Policer, error) {
 	var err error
 	switch {
 	case choice.SemVer != nil:
-		p, err = NewSemVer(choice.SemVer.Range)
+		var semver *SemVer
+		if semver, err = NewSemVer(choice.SemVer.Range); err == nil {
+			semver.VPrefix = choice.SemVer.VPrefix
+		}
+		p = semver
 	case choice.Alphabetical != nil:
 		p, err = NewAlphabetical(strings.ToUpper(choice.Alphabetical.Order))
 	case choice.Numerical != nil:
 		p, err = NewNumerical(strings.ToUpper(choice.Numerical.Order))
+	case choice.CalVer != nil:
+		p, err = NewCalVer(choice.CalVer.Format)
+	case choice.CreatedAt != nil:
+		p, err = NewCreatedAt(strings.ToUpper(choice.CreatedAt.Order))
 	default:
 		return nil, fmt.Errorf("given ImagePolicyChoice object is invalid")
 	}
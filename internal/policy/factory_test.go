@@ -41,6 +41,36 @@ func TestFactory_PolicerFromSpec(t *testing.T) {
 		t.Error("should not return error")
 	}
 
+	// With AlphabeticalPolicy with an invalid order
+	_, err = PolicerFromSpec(imagev1.ImagePolicyChoice{Alphabetical: &imagev1.AlphabeticalPolicy{Order: "sideways"}})
+	if err == nil {
+		t.Error("should return error")
+	}
+
+	// With NumericalPolicy
+	_, err = PolicerFromSpec(imagev1.ImagePolicyChoice{Numerical: &imagev1.NumericalPolicy{Order: "desc"}})
+	if err != nil {
+		t.Error("should not return error")
+	}
+
+	// With NumericalPolicy with an invalid order
+	_, err = PolicerFromSpec(imagev1.ImagePolicyChoice{Numerical: &imagev1.NumericalPolicy{Order: "sideways"}})
+	if err == nil {
+		t.Error("should return error")
+	}
+
+	// With CalVerPolicy
+	_, err = PolicerFromSpec(imagev1.ImagePolicyChoice{CalVer: &imagev1.CalVerPolicy{Format: "YYYY.0M.0D"}})
+	if err != nil {
+		t.Error("should not return error")
+	}
+
+	// With CreatedAtPolicy
+	_, err = PolicerFromSpec(imagev1.ImagePolicyChoice{CreatedAt: &imagev1.CreatedAtPolicy{}})
+	if err != nil {
+		t.Error("should not return error")
+	}
+
 	// A nil checkable Policer for invalid policy.
 	p, err := PolicerFromSpec(imagev1.ImagePolicyChoice{SemVer: &imagev1.SemVerPolicy{Range: "*-*"}})
 	if err == nil {
@@ -19,6 +19,8 @@ package policy
 import (
 	"fmt"
 	"regexp"
+	"strconv"
+	"strings"
 )
 
 // RegexFilter represents a regular expression filter
@@ -35,6 +37,9 @@ func NewRegexFilter(pattern string, replace string) (*RegexFilter, error) {
 	if err != nil {
 		return nil, fmt.Errorf("invalid regular expression pattern '%s': %w", pattern, err)
 	}
+	if err := validateReplace(m, replace); err != nil {
+		return nil, err
+	}
 	return &RegexFilter{
 		Regexp:  m,
 		Replace: replace,
@@ -70,3 +75,56 @@ func (f *RegexFilter) Items() []string {
 func (f *RegexFilter) GetOriginalTag(tag string) string {
 	return f.filtered[tag]
 }
+
+// validateReplace checks that each of the capture group references in
+// replace, written `$name`, `${name}`, `$1` or `${1}`, refers to a
+// group in the regular expression. Otherwise the reference would be
+// silently expanded to an empty string.
+func validateReplace(m *regexp.Regexp, replace string) error {
+	groups := map[string]bool{}
+	for i, name := range m.SubexpNames() {
+		groups[strconv.Itoa(i)] = true
+		if name != "" {
+			groups[name] = true
+		}
+	}
+
+	for rest := replace; rest != ""; {
+		i := strings.IndexByte(rest, '$')
+		if i < 0 {
+			break
+		}
+		rest = rest[i+1:]
+		if rest == "" {
+			break
+		}
+		var name string
+		switch {
+		case rest[0] == '$':
+			rest = rest[1:]
+			continue
+		case rest[0] == '{':
+			end := strings.IndexByte(rest, '}')
+			if end < 0 {
+				continue
+			}
+			name = rest[1:end]
+			rest = rest[end+1:]
+		default:
+			end := 0
+			for end < len(rest) && isNameChar(rest[end]) {
+				end++
+			}
+			name = rest[:end]
+			rest = rest[end:]
+		}
+		if name != "" && !groups[name] {
+			return fmt.Errorf("invalid extract '%s': pattern '%s' has no capture group '%s'", replace, m.String(), name)
+		}
+	}
+	return nil
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
@@ -48,20 +48,111 @@ func TestRegexFilter(t *testing.T) {
 			extract:  `$1`,
 			expected: []string{"1", "2", "3"},
 		},
+		{
+			label:    "valid pattern with named capture groups",
+			tags:     []string{"release-2024-05-build-17", "release-2024-05-build-9", "release-2023-12-build-30", "latest"},
+			pattern:  `^release-(?P<year>\d{4})-(?P<month>\d{2})-build-(?P<build>\d+)$`,
+			extract:  `$year.$month.$build`,
+			expected: []string{"2023.12.30", "2024.05.17", "2024.05.9"},
+		},
+		{
+			label:    "valid pattern with braced references",
+			tags:     []string{"v1_2-rc3", "v1_3-rc1"},
+			pattern:  `^v(?P<major>\d+)_(?P<minor>\d+)-rc(?P<rc>\d+)$`,
+			extract:  `${major}.${minor}.0-rc${rc}`,
+			expected: []string{"1.2.0-rc3", "1.3.0-rc1"},
+		},
 	}
 	for _, tt := range cases {
 		t.Run(tt.label, func(t *testing.T) {
 			filter := newRegexFilter(tt.pattern, tt.extract)
 			filter.Apply(tt.tags)
-			r := sort.StringSlice(filter.Items())
-			if reflect.DeepEqual(r, tt.expected) {
+			r := filter.Items()
+			sort.Strings(r)
+			if !reflect.DeepEqual(r, tt.expected) {
 				t.Errorf("incorrect value returned, got '%s', expected '%s'", r, tt.expected)
 			}
 		})
 	}
 }
 
+func TestNewRegexFilter(t *testing.T) {
+	cases := []struct {
+		label     string
+		pattern   string
+		extract   string
+		expectErr bool
+	}{
+		{
+			label:   "With named groups",
+			pattern: `^(?P<major>\d+)\.(?P<minor>\d+)$`,
+			extract: `$major.${minor}-$1$$`,
+		},
+		{
+			label:     "With invalid pattern",
+			pattern:   `^ver(`,
+			expectErr: true,
+		},
+		{
+			label:     "With unknown named group",
+			pattern:   `^(?P<major>\d+)\.(?P<minor>\d+)$`,
+			extract:   `$major.$patch`,
+			expectErr: true,
+		},
+		{
+			label:     "With out of range group index",
+			pattern:   `^ver(\d+)$`,
+			extract:   `$2`,
+			expectErr: true,
+		},
+		{
+			label:     "With group name running into text",
+			pattern:   `^(?P<major>\d+)\.(?P<minor>\d+)$`,
+			extract:   `$major_$minor`,
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.label, func(t *testing.T) {
+			_, err := NewRegexFilter(tt.pattern, tt.extract)
+			if tt.expectErr && err == nil {
+				t.Fatalf("expecting error, got nil")
+			}
+			if !tt.expectErr && err != nil {
+				t.Fatalf("returned unexpected error: %s", err)
+			}
+		})
+	}
+}
+
 func newRegexFilter(pattern string, extract string) *RegexFilter {
 	f, _ := NewRegexFilter(pattern, extract)
 	return f
 }
+
+func TestRegexFilter_compositeVersion(t *testing.T) {
+	tags := shuffle([]string{
+		"release-2023-12-build-30",
+		"release-2024-05-build-9",
+		"release-2024-05-build-17",
+		"release-2024-10-build-2",
+		"release-2024-10-build-10",
+		"latest",
+	})
+	filter := newRegexFilter(`^release-(?P<year>\d{4})-0?(?P<month>\d+)-build-(?P<build>\d+)$`, `$year.$month.$build`)
+	filter.Apply(tags)
+
+	policy, err := NewSemVer(">=0.0.0")
+	if err != nil {
+		t.Fatalf("returned unexpected error: %s", err)
+	}
+	latest, err := policy.Latest(filter.Items())
+	if err != nil {
+		t.Fatalf("returned unexpected error: %s", err)
+	}
+	expected := "release-2024-10-build-10"
+	if got := filter.GetOriginalTag(latest); got != expected {
+		t.Errorf("incorrect computed version returned, got '%s', expected '%s'", got, expected)
+	}
+}
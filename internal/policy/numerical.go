@@ -18,6 +18,7 @@ package policy
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 )
 
@@ -52,28 +53,29 @@ func NewNumerical(order string) (*Numerical, error) {
 
 // Latest returns latest version from a provided list of strings
 func (p *Numerical) Latest(versions []string) (string, error) {
+	return latest(p.Sort(versions))
+}
+
+// Sort returns the versions provided, the latest first; of equal values,
+// the one provided last is first
+func (p *Numerical) Sort(versions []string) ([]string, error) {
 	if len(versions) == 0 {
-		return "", fmt.Errorf("version list argument cannot be empty")
+		return nil, fmt.Errorf("version list argument cannot be empty")
 	}
-
-	var latest string
-	var pv float64
-	for i, version := range versions {
+	values := make(map[string]float64, len(versions))
+	for _, version := range versions {
 		cv, err := strconv.ParseFloat(version, 64)
 		if err != nil {
-			return "", fmt.Errorf("failed to parse invalid numeric value '%s'", version)
-		}
-
-		switch {
-		case i == 0:
-			// First iteration, nothing to compare
-		case p.Order == NumericalOrderAsc && cv < pv, p.Order == NumericalOrderDesc && cv > pv:
-			continue
+			return nil, fmt.Errorf("failed to parse invalid numeric value '%s'", version)
 		}
-
-		latest = version
-		pv = cv
+		values[version] = cv
 	}
-
-	return latest, nil
+	sorted := reversed(versions)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if p.Order == NumericalOrderDesc {
+			return values[sorted[i]] < values[sorted[j]]
+		}
+		return values[sorted[i]] > values[sorted[j]]
+	})
+	return sorted, nil
 }
@@ -18,6 +18,7 @@ package policy
 
 import (
 	"math/rand"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -101,6 +102,16 @@ func TestNumerical_Latest(t *testing.T) {
 			order:           NumericalOrderDesc,
 			expectedVersion: "1606234201",
 		},
+		{
+			label:           "With Unix Timestamps in milliseconds ascending",
+			versions:        shuffle([]string{"1606234201000", "1606364286123", "1606364286122", "1606334284999"}),
+			expectedVersion: "1606364286123",
+		},
+		{
+			label:           "With build numbers of different lengths ascending",
+			versions:        shuffle([]string{"9", "10", "99", "100", "1000", "999"}),
+			expectedVersion: "1000",
+		},
 		{
 			label:           "With single value ascending",
 			versions:        []string{"1"},
@@ -150,3 +161,41 @@ func shuffle(list []string) []string {
 	rand.Shuffle(len(list), func(i, j int) { list[i], list[j] = list[j], list[i] })
 	return list
 }
+
+func TestNumerical_Sort(t *testing.T) {
+	cases := []struct {
+		label    string
+		order    string
+		versions []string
+		expected []string
+	}{
+		{
+			label:    "With ascending order",
+			order:    NumericalOrderAsc,
+			versions: []string{"1", "10", "2.5", "-1"},
+			expected: []string{"10", "2.5", "1", "-1"},
+		},
+		{
+			label:    "With descending order",
+			order:    NumericalOrderDesc,
+			versions: []string{"1", "10", "2.5", "-1"},
+			expected: []string{"-1", "1", "2.5", "10"},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.label, func(t *testing.T) {
+			policy, err := NewNumerical(tt.order)
+			if err != nil {
+				t.Fatalf("returned unexpected error: %s", err)
+			}
+			sorted, err := policy.Sort(tt.versions)
+			if err != nil {
+				t.Fatalf("returned unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(sorted, tt.expected) {
+				t.Errorf("incorrect sorted versions returned, got %v, expected %v", sorted, tt.expected)
+			}
+		})
+	}
+}
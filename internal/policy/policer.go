@@ -16,7 +16,36 @@ limitations under the License.
 
 package policy
 
+import "errors"
+
 // Policer is an interface representing a policy implementation type
 type Policer interface {
 	Latest([]string) (string, error)
+	// Sort returns the tags the policy can order, the latest first, so
+	// that Latest gives the first of them.
+	Sort([]string) ([]string, error)
+}
+
+// ErrNoLatest is the error of a policy that can order none of the tags.
+var ErrNoLatest = errors.New("unable to determine latest version from provided list")
+
+// latest returns the first of the tags sorted, or the error given if
+// there are none.
+func latest(sorted []string, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	if len(sorted) == 0 {
+		return "", ErrNoLatest
+	}
+	return sorted[0], nil
+}
+
+// reversed returns a copy of the tags in reverse order.
+func reversed(tags []string) []string {
+	r := make([]string, len(tags))
+	for i, tag := range tags {
+		r[len(tags)-1-i] = tag
+	}
+	return r
 }
@@ -18,14 +18,22 @@ package policy
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/Masterminds/semver/v3"
 	"github.com/fluxcd/pkg/version"
+
+	imagev1 "github.com/fluxcd/image-reflector-controller/api/v1beta1"
 )
 
 // SemVer representes a SemVer policy
 type SemVer struct {
 	Range string
+	// VPrefix gives which of two tags that differ only by a `v` prefix
+	// is the latest: the one with the prefix when it is VPrefixKeep, and
+	// the one without when it is VPrefixStrip.
+	VPrefix imagev1.VPrefixPolicy
 
 	constraint *semver.Constraints
 }
@@ -45,21 +53,57 @@ func NewSemVer(r string) (*SemVer, error) {
 
 // Latest returns latest version from a provided list of strings
 func (p *SemVer) Latest(versions []string) (string, error) {
+	return latest(p.Sort(versions))
+}
+
+// Sort returns the versions provided that are in the range, the latest
+// first. Of equal versions, the preferred provided last is first, or
+// else the one provided first.
+func (p *SemVer) Sort(versions []string) ([]string, error) {
 	if len(versions) == 0 {
-		return "", fmt.Errorf("version list argument cannot be empty")
+		return nil, fmt.Errorf("version list argument cannot be empty")
 	}
 
-	var latestVersion *semver.Version
-	for _, tag := range versions {
-		if v, err := version.ParseVersion(tag); err == nil {
-			if p.constraint.Check(v) && (latestVersion == nil || v.GreaterThan(latestVersion)) {
-				latestVersion = v
-			}
+	type tagVersion struct {
+		version   *semver.Version
+		preferred bool
+		index     int
+	}
+	var tags []tagVersion
+	for i, tag := range versions {
+		if v, err := version.ParseVersion(tag); err == nil && p.constraint.Check(v) {
+			tags = append(tags, tagVersion{version: v, preferred: p.preferred(tag), index: i})
 		}
 	}
+	sort.Slice(tags, func(i, j int) bool {
+		a, b := tags[i], tags[j]
+		switch {
+		case !a.version.Equal(b.version):
+			return a.version.GreaterThan(b.version)
+		case a.preferred != b.preferred:
+			return a.preferred
+		case a.preferred:
+			return a.index > b.index
+		default:
+			return a.index < b.index
+		}
+	})
+
+	sorted := make([]string, len(tags))
+	for i, t := range tags {
+		sorted[i] = t.version.Original()
+	}
+	return sorted, nil
+}
 
-	if latestVersion != nil {
-		return latestVersion.Original(), nil
+// preferred reports whether the tag is preferred over another of the
+// same version.
+func (p *SemVer) preferred(tag string) bool {
+	switch p.VPrefix {
+	case imagev1.VPrefixKeep:
+		return strings.HasPrefix(tag, "v")
+	case imagev1.VPrefixStrip:
+		return !strings.HasPrefix(tag, "v")
 	}
-	return "", fmt.Errorf("unable to determine latest version from provided list")
+	return false
 }
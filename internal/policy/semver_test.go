@@ -17,7 +17,10 @@ limitations under the License.
 package policy
 
 import (
+	"reflect"
 	"testing"
+
+	imagev1 "github.com/fluxcd/image-reflector-controller/api/v1beta1"
 )
 
 func TestNewSemVer(t *testing.T) {
@@ -56,6 +59,7 @@ func TestSemVer_Latest(t *testing.T) {
 	cases := []struct {
 		label           string
 		semverRange     string
+		vPrefix         imagev1.VPrefixPolicy
 		versions        []string
 		expectedVersion string
 		expectErr       bool
@@ -72,6 +76,27 @@ func TestSemVer_Latest(t *testing.T) {
 			semverRange:     "1.0.x",
 			expectedVersion: "v1.0.0",
 		},
+		{
+			label:           "With mixed prefix, keeping the prefix",
+			versions:        []string{"1.0.1", "v1.0.1", "v1.0.0"},
+			semverRange:     "1.0.x",
+			vPrefix:         imagev1.VPrefixKeep,
+			expectedVersion: "v1.0.1",
+		},
+		{
+			label:           "With mixed prefix, stripping the prefix",
+			versions:        []string{"v1.0.1", "1.0.1", "v1.0.0"},
+			semverRange:     "1.0.x",
+			vPrefix:         imagev1.VPrefixStrip,
+			expectedVersion: "1.0.1",
+		},
+		{
+			label:           "With mixed prefix, ordered across the prefix",
+			versions:        []string{"1.0.0", "v1.0.2", "1.0.1"},
+			semverRange:     "1.0.x",
+			vPrefix:         imagev1.VPrefixStrip,
+			expectedVersion: "v1.0.2",
+		},
 		{
 			label:       "With invalid format prefix",
 			versions:    []string{"b1.2.3", "b1.0.0", "b0.1.0"},
@@ -98,6 +123,7 @@ func TestSemVer_Latest(t *testing.T) {
 			if err != nil {
 				t.Fatalf("returned unexpected error: %s", err)
 			}
+			policy.VPrefix = tt.vPrefix
 
 			latest, err := policy.Latest(tt.versions)
 			if tt.expectErr && err == nil {
@@ -113,3 +139,19 @@ func TestSemVer_Latest(t *testing.T) {
 		})
 	}
 }
+
+func TestSemVer_Sort(t *testing.T) {
+	policy, err := NewSemVer(">= 1.0.0")
+	if err != nil {
+		t.Fatalf("returned unexpected error: %s", err)
+	}
+	policy.VPrefix = imagev1.VPrefixStrip
+	sorted, err := policy.Sort([]string{"v1.0.0", "1.2.0", "0.9.0", "latest", "1.0.0", "1.10.0"})
+	if err != nil {
+		t.Fatalf("returned unexpected error: %s", err)
+	}
+	expected := []string{"1.10.0", "1.2.0", "1.0.0", "v1.0.0"}
+	if !reflect.DeepEqual(sorted, expected) {
+		t.Errorf("incorrect sorted versions returned, got %v, expected %v", sorted, expected)
+	}
+}
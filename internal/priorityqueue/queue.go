@@ -0,0 +1,268 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package priorityqueue
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/util/workqueue"
+)
+
+// Queue is a work queue, as the rate limiting queues of client-go, that
+// gives out the items queued in order of priority, highest first, and in
+// the order they were queued otherwise. As with those, an item is queued
+// at most once at a time, and is not given out again while it is being
+// processed; one queued again meanwhile is queued once it is done.
+type Queue struct {
+	priority    func(item interface{}) int
+	rateLimiter workqueue.RateLimiter
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// queued holds the items waiting to be given out, and dirty those
+	// to be processed, whether waiting or being processed already.
+	queued     items
+	dirty      map[interface{}]*entry
+	processing map[interface{}]struct{}
+	// seq orders the items queued with the same priority.
+	seq          uint64
+	shuttingDown bool
+
+	// delayed holds the timers of the items to be queued later, with
+	// when they are to be queued.
+	delayed map[interface{}]delay
+}
+
+var _ workqueue.RateLimitingInterface = &Queue{}
+
+// delay is an item to be queued later.
+type delay struct {
+	timer   *time.Timer
+	readyAt time.Time
+}
+
+// New returns a Queue giving out items by the priority given for each
+// when it is queued, with the delays of the rate limiter given for the
+// items queued with AddRateLimited.
+func New(rateLimiter workqueue.RateLimiter, priority func(item interface{}) int) *Queue {
+	q := &Queue{
+		priority:    priority,
+		rateLimiter: rateLimiter,
+		dirty:       map[interface{}]*entry{},
+		processing:  map[interface{}]struct{}{},
+		delayed:     map[interface{}]delay{},
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// Add queues the item, unless it is queued already.
+func (q *Queue) Add(item interface{}) {
+	priority := q.priority(item)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.shuttingDown {
+		return
+	}
+	if e, ok := q.dirty[item]; ok {
+		// An item queued again with a higher priority is moved up.
+		if priority > e.priority {
+			e.priority = priority
+			if e.index >= 0 {
+				heap.Fix(&q.queued, e.index)
+			}
+		}
+		return
+	}
+	q.seq++
+	e := &entry{item: item, priority: priority, seq: q.seq, index: -1}
+	q.dirty[item] = e
+	if _, ok := q.processing[item]; ok {
+		return
+	}
+	heap.Push(&q.queued, e)
+	q.cond.Signal()
+}
+
+// Len returns the number of items waiting to be given out.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.queued)
+}
+
+// Get waits for an item to be queued, and gives out that with the
+// highest priority. It returns shutdown as true once the queue is shut
+// down and nothing is left to give out.
+func (q *Queue) Get() (interface{}, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.queued) == 0 && !q.shuttingDown {
+		q.cond.Wait()
+	}
+	if len(q.queued) == 0 {
+		return nil, true
+	}
+	e := heap.Pop(&q.queued).(*entry)
+	delete(q.dirty, e.item)
+	q.processing[e.item] = struct{}{}
+	return e.item, false
+}
+
+// Done marks the item as processed, queueing it if it was queued again
+// while it was being processed.
+func (q *Queue) Done(item interface{}) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.processing, item)
+	if e, ok := q.dirty[item]; ok {
+		heap.Push(&q.queued, e)
+	}
+	// Those waiting to drain the queue, as well as for an item, are
+	// woken.
+	q.cond.Broadcast()
+}
+
+// ShutDown has the queue stop taking items, and Get return once what is
+// queued has been given out.
+func (q *Queue) ShutDown() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.shutDown()
+}
+
+// ShutDownWithDrain shuts the queue down as ShutDown does, then waits
+// for the items given out to be processed.
+func (q *Queue) ShutDownWithDrain() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.shutDown()
+	for len(q.processing) > 0 {
+		q.cond.Wait()
+	}
+}
+
+func (q *Queue) shutDown() {
+	q.shuttingDown = true
+	for item, d := range q.delayed {
+		d.timer.Stop()
+		delete(q.delayed, item)
+	}
+	q.cond.Broadcast()
+}
+
+// ShuttingDown reports whether the queue is shut down.
+func (q *Queue) ShuttingDown() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.shuttingDown
+}
+
+// AddAfter queues the item once the duration has passed. An item to be
+// queued later is queued at the earliest time it was given.
+func (q *Queue) AddAfter(item interface{}, duration time.Duration) {
+	if duration <= 0 {
+		q.Add(item)
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.shuttingDown {
+		return
+	}
+	readyAt := time.Now().Add(duration)
+	if d, ok := q.delayed[item]; ok {
+		if !readyAt.Before(d.readyAt) {
+			return
+		}
+		d.timer.Stop()
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(duration, func() {
+		q.mu.Lock()
+		if d, ok := q.delayed[item]; ok && d.timer == timer {
+			delete(q.delayed, item)
+		}
+		q.mu.Unlock()
+		q.Add(item)
+	})
+	q.delayed[item] = delay{timer: timer, readyAt: readyAt}
+}
+
+// AddRateLimited queues the item once the rate limiter allows it.
+func (q *Queue) AddRateLimited(item interface{}) {
+	q.AddAfter(item, q.rateLimiter.When(item))
+}
+
+// Forget has the rate limiter stop tracking the item.
+func (q *Queue) Forget(item interface{}) {
+	q.rateLimiter.Forget(item)
+}
+
+// NumRequeues returns the number of times the item was queued with
+// AddRateLimited since it was last forgotten.
+func (q *Queue) NumRequeues(item interface{}) int {
+	return q.rateLimiter.NumRequeues(item)
+}
+
+// entry is an item to be processed.
+type entry struct {
+	item     interface{}
+	priority int
+	seq      uint64
+	// index is the position of the entry in the heap, or -1 if it is not
+	// in the heap, i.e., while the item is being processed.
+	index int
+}
+
+// items is a heap of the items waiting to be given out, that with the
+// highest priority first.
+type items []*entry
+
+func (h items) Len() int { return len(h) }
+
+func (h items) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h items) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *items) Push(x interface{}) {
+	e := x.(*entry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *items) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	e.index = -1
+	return e
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package priorityqueue
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/client-go/util/workqueue"
+)
+
+func newTestQueue(priorities map[string]int) *Queue {
+	return New(workqueue.DefaultControllerRateLimiter(), func(item interface{}) int {
+		return priorities[item.(string)]
+	})
+}
+
+func get(t *testing.T, q *Queue) string {
+	t.Helper()
+	item, shutdown := q.Get()
+	if shutdown {
+		t.Fatal("Get() got shutdown, want an item")
+	}
+	return item.(string)
+}
+
+func TestQueue_priority(t *testing.T) {
+	q := newTestQueue(map[string]int{"high": 10, "low": -1})
+	for _, item := range []string{"low", "first", "high", "second", "first"} {
+		q.Add(item)
+	}
+	if n := q.Len(); n != 4 {
+		t.Fatalf("Len() got %d, want 4", n)
+	}
+	for _, want := range []string{"high", "first", "second", "low"} {
+		if got := get(t, q); got != want {
+			t.Fatalf("Get() got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestQueue_addedWhileProcessing(t *testing.T) {
+	q := newTestQueue(nil)
+	q.Add("a")
+	q.Add("b")
+	if got := get(t, q); got != "a" {
+		t.Fatalf("Get() got %q, want %q", got, "a")
+	}
+	// An item being processed is not given out again until it is done.
+	q.Add("a")
+	if got := get(t, q); got != "b" {
+		t.Fatalf("Get() got %q, want %q", got, "b")
+	}
+	if n := q.Len(); n != 0 {
+		t.Fatalf("Len() got %d, want 0 while the item is processed", n)
+	}
+	q.Done("a")
+	if got := get(t, q); got != "a" {
+		t.Fatalf("Get() got %q, want %q once done", got, "a")
+	}
+}
+
+func TestQueue_addAfter(t *testing.T) {
+	q := newTestQueue(nil)
+	q.AddAfter("later", time.Hour)
+	q.AddAfter("later", 10*time.Millisecond)
+	q.AddAfter("later", time.Hour)
+	if got := get(t, q); got != "later" {
+		t.Fatalf("Get() got %q, want %q", got, "later")
+	}
+	q.Done("later")
+	if n := q.Len(); n != 0 {
+		t.Fatalf("Len() got %d, want the item queued once", n)
+	}
+}
+
+func TestQueue_shutDown(t *testing.T) {
+	q := newTestQueue(nil)
+	q.Add("a")
+	q.ShutDown()
+	q.Add("b")
+	if got := get(t, q); got != "a" {
+		t.Fatalf("Get() got %q, want what was queued before shutting down", got)
+	}
+	if _, shutdown := q.Get(); !shutdown {
+		t.Fatal("Get() got an item, want shutdown")
+	}
+	if !q.ShuttingDown() {
+		t.Fatal("ShuttingDown() got false, want true")
+	}
+}
@@ -23,17 +23,21 @@ import (
 	"fmt"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/ecrpublic"
 	"github.com/google/go-containerregistry/pkg/authn"
 	ctrl "sigs.k8s.io/controller-runtime"
 
 	"github.com/fluxcd/image-reflector-controller/internal/registry"
 )
 
-var registryPartRe = regexp.MustCompile(`([0-9+]*).dkr.ecr.([^/.]*)\.(amazonaws\.com[.cn]*)/([^:]+):?(.*)`)
+var registryPartRe = regexp.MustCompile(`([0-9+]*).dkr.ecr(?:-fips)?.([^/.]*)\.(amazonaws\.com[.cn]*)/([^:]+):?(.*)`)
 
 // ParseImage returns the AWS account ID and region and `true` if
 // the image repository is hosted in AWS's Elastic Container Registry,
@@ -46,6 +50,40 @@ func ParseImage(image string) (accountId, awsEcrRegion string, ok bool) {
 	return registryParts[0][1], registryParts[0][2], true
 }
 
+const (
+	// PublicRegistryHost is the host of the ECR Public registry.
+	PublicRegistryHost = "public.ecr.aws"
+	// PublicRegion is the region of the ECR Public API, from which the
+	// tokens for PublicRegistryHost are got.
+	PublicRegion = "us-east-1"
+)
+
+// IsPublicImage returns `true` if the image repository is hosted in ECR
+// Public, otherwise `false`.
+func IsPublicImage(image string) bool {
+	return strings.HasPrefix(image, PublicRegistryHost+"/")
+}
+
+// LoginOptions configure how the client gets the authentication for a
+// registry.
+type LoginOptions struct {
+	// RoleARN is the ARN of an IAM role to assume to get the
+	// authentication, if any.
+	RoleARN string
+	// Endpoint is the URL of the ECR API to use in place of the default
+	// for the region of the registry, e.g., that of a VPC endpoint. It is
+	// not used for ECR Public.
+	Endpoint string
+	// UseFIPSEndpoint has the FIPS endpoint of the ECR API used for the
+	// region of the registry. It is not used for ECR Public, which has
+	// none.
+	UseFIPSEndpoint bool
+	// Region is the region to get the authentication in for a registry
+	// whose host does not give it, e.g., a custom domain fronting ECR.
+	// If it is not given either, the region of the controller is used.
+	Region string
+}
+
 // Client is a AWS ECR client which can log into the registry and return
 // authorization information.
 type Client struct {
@@ -63,69 +101,136 @@ func NewClient() *Client {
 // be the case if it's running in EKS, and may need additional setup
 // otherwise (visit
 // https://docs.aws.amazon.com/sdk-for-go/api/aws/session/ as a
-// starting point).
-func (c *Client) getLoginAuth(accountId, awsEcrRegion string) (authn.AuthConfig, error) {
-	// No caching of tokens is attempted; the quota for getting an
-	// auth token is high enough that getting a token every time you
-	// scan an image is viable for O(500) images per region. See
-	// https://docs.aws.amazon.com/general/latest/gr/ecr.html.
+// starting point). If a role ARN is given, the role is assumed first,
+// and the token got with its credentials. It also returns when the
+// token expires, or the zero time if that is not given.
+func (c *Client) getLoginAuth(accountId, awsEcrRegion string, opts LoginOptions) (authn.AuthConfig, time.Time, error) {
 	var authConfig authn.AuthConfig
-	accountIDs := []string{accountId}
+	// Without an account ID, the token is that of the default registry
+	// of the account of the controller, which can be used with any
+	// registry the controller has access to.
+	input := &ecr.GetAuthorizationTokenInput{}
+	if accountId != "" {
+		input.RegistryIds = aws.StringSlice([]string{accountId})
+	}
 
 	// Configure session.
-	cfg := c.Config.WithRegion(awsEcrRegion)
-	ecrService := ecr.New(session.Must(session.NewSession(cfg)))
-	ecrToken, err := ecrService.GetAuthorizationToken(&ecr.GetAuthorizationTokenInput{
-		RegistryIds: aws.StringSlice(accountIDs),
-	})
+	sess, serviceCfg := c.session(awsEcrRegion, opts.RoleARN)
+	// The endpoint options are for the ECR API alone, and not, e.g., for
+	// STS when assuming a role.
+	if opts.Endpoint != "" {
+		serviceCfg.Endpoint = aws.String(opts.Endpoint)
+	}
+	if opts.UseFIPSEndpoint {
+		serviceCfg.UseFIPSEndpoint = endpoints.FIPSEndpointStateEnabled
+	}
+	ecrService := ecr.New(sess, serviceCfg)
+	ecrToken, err := ecrService.GetAuthorizationToken(input)
 	if err != nil {
-		return authConfig, err
+		return authConfig, time.Time{}, err
 	}
 
 	// Validate the authorization data.
 	if len(ecrToken.AuthorizationData) == 0 {
-		return authConfig, errors.New("no authorization data")
+		return authConfig, time.Time{}, errors.New("no authorization data")
 	}
-	if ecrToken.AuthorizationData[0].AuthorizationToken == nil {
-		return authConfig, fmt.Errorf("no authorization token")
+	return authConfigFromToken(ecrToken.AuthorizationData[0].AuthorizationToken, ecrToken.AuthorizationData[0].ExpiresAt)
+}
+
+// getPublicLoginAuth obtains authentication for ECR Public, the
+// registry at PublicRegistryHost, the same way as getLoginAuth does for
+// a private registry. The token for ECR Public is got from its API in
+// PublicRegion, whichever the region of the controller.
+func (c *Client) getPublicLoginAuth(roleARN string) (authn.AuthConfig, time.Time, error) {
+	sess, serviceCfg := c.session(PublicRegion, roleARN)
+	publicService := ecrpublic.New(sess, serviceCfg)
+	publicToken, err := publicService.GetAuthorizationToken(&ecrpublic.GetAuthorizationTokenInput{})
+	if err != nil {
+		return authn.AuthConfig{}, time.Time{}, err
 	}
-	token, err := base64.StdEncoding.DecodeString(*ecrToken.AuthorizationData[0].AuthorizationToken)
+	if publicToken.AuthorizationData == nil {
+		return authn.AuthConfig{}, time.Time{}, errors.New("no authorization data")
+	}
+	return authConfigFromToken(publicToken.AuthorizationData.AuthorizationToken, publicToken.AuthorizationData.ExpiresAt)
+}
+
+// session returns a session in the region, and the configuration of
+// the service clients made with it, with the credentials of the role
+// if one is given.
+func (c *Client) session(region, roleARN string) (*session.Session, *aws.Config) {
+	config := c.Config.Copy()
+	if region != "" {
+		config = config.WithRegion(region)
+	}
+	sess := session.Must(session.NewSession(config))
+	serviceCfg := &aws.Config{}
+	if roleARN != "" {
+		serviceCfg.Credentials = stscreds.NewCredentials(sess, roleARN)
+	}
+	return sess, serviceCfg
+}
+
+// authConfigFromToken returns the authentication given by an ECR
+// authorization token, a base64 encoding of the username and password
+// separated by a colon, and when it expires, or the zero time if that
+// is not given.
+func authConfigFromToken(authToken *string, expiry *time.Time) (authn.AuthConfig, time.Time, error) {
+	var authConfig authn.AuthConfig
+	if authToken == nil {
+		return authConfig, time.Time{}, fmt.Errorf("no authorization token")
+	}
+	token, err := base64.StdEncoding.DecodeString(*authToken)
 	if err != nil {
-		return authConfig, err
+		return authConfig, time.Time{}, err
 	}
 
 	tokenSplit := strings.Split(string(token), ":")
 	// Validate the tokens.
 	if len(tokenSplit) != 2 {
 		// NOTE: Maybe think of some better error message?
-		return authConfig, fmt.Errorf("invalid authorization token, expected to be of length 2, have %d", len(tokenSplit))
+		return authConfig, time.Time{}, fmt.Errorf("invalid authorization token, expected to be of length 2, have %d", len(tokenSplit))
 	}
 	authConfig = authn.AuthConfig{
 		Username: tokenSplit[0],
 		Password: tokenSplit[1],
 	}
-	return authConfig, nil
+	var expiresAt time.Time
+	if expiry != nil {
+		expiresAt = *expiry
+	}
+	return authConfig, expiresAt, nil
 }
 
 // Login attempts to get the authentication material for ECR. It extracts
-// the account and region information from the image URI. The caller can ensure
-// that the passed image is a valid ECR image using ParseImage().
-func (c *Client) Login(ctx context.Context, autoLogin bool, image string) (authn.Authenticator, error) {
+// the account and region information from the image URI, if it is that of
+// ECR; the caller can ensure that the passed image is a valid ECR image
+// using ParseImage(), or an ECR Public image using IsPublicImage(), unless
+// the login is forced for a custom domain. It also returns when the
+// authentication expires, or the zero time if that is not known.
+func (c *Client) Login(ctx context.Context, autoLogin bool, image string, opts LoginOptions) (authn.Authenticator, time.Time, error) {
 	if autoLogin {
 		ctrl.LoggerFrom(ctx).Info("logging in to AWS ECR for " + image)
-		accountId, awsEcrRegion, ok := ParseImage(image)
-		if !ok {
-			return nil, errors.New("failed to parse AWS ECR image, invalid ECR image")
+		var authConfig authn.AuthConfig
+		var expiresAt time.Time
+		var err error
+		if IsPublicImage(image) {
+			authConfig, expiresAt, err = c.getPublicLoginAuth(opts.RoleARN)
+		} else {
+			// A registry at a custom domain is logged in to in the
+			// region given, if any, or that of the controller.
+			accountId, awsEcrRegion, ok := ParseImage(image)
+			if !ok {
+				accountId, awsEcrRegion = "", opts.Region
+			}
+			authConfig, expiresAt, err = c.getLoginAuth(accountId, awsEcrRegion, opts)
 		}
-
-		authConfig, err := c.getLoginAuth(accountId, awsEcrRegion)
 		if err != nil {
-			return nil, err
+			return nil, time.Time{}, err
 		}
 
 		auth := authn.FromConfig(authConfig)
-		return auth, nil
+		return auth, expiresAt, nil
 	}
 	ctrl.LoggerFrom(ctx).Info("ECR authentication is not enabled. To enable, set the controller flag --aws-autologin-for-ecr")
-	return nil, fmt.Errorf("ECR authentication failed: %w", registry.ErrUnconfiguredProvider)
+	return nil, time.Time{}, fmt.Errorf("ECR authentication failed: %w", registry.ErrUnconfiguredProvider)
 }
@@ -20,6 +20,7 @@ import (
 	"context"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws/credentials"
@@ -50,6 +51,12 @@ func TestParseImage(t *testing.T) {
 			wantRegion:    "us-east-1",
 			wantOK:        true,
 		},
+		{
+			image:         "012345678901.dkr.ecr-fips.us-gov-west-1.amazonaws.com/foo:v1",
+			wantAccountID: "012345678901",
+			wantRegion:    "us-gov-west-1",
+			wantOK:        true,
+		},
 		{
 			image:  "012345678901.dkr.ecr.us-east-1.amazonaws.com",
 			wantOK: false,
@@ -151,7 +158,7 @@ func TestGetLoginAuth(t *testing.T) {
 			ec.Config = ec.WithEndpoint(srv.URL).
 				WithCredentials(credentials.NewStaticCredentials("x", "y", "z"))
 
-			a, err := ec.getLoginAuth("some-account-id", "us-east-1")
+			a, _, err := ec.getLoginAuth("some-account-id", "us-east-1", LoginOptions{})
 			g.Expect(err != nil).To(Equal(tt.wantErr))
 			if tt.statusCode == http.StatusOK {
 				g.Expect(a).To(Equal(tt.wantAuthConfig))
@@ -160,6 +167,92 @@ func TestGetLoginAuth(t *testing.T) {
 	}
 }
 
+func TestGetLoginAuthAssumingRole(t *testing.T) {
+	g := NewWithT(t)
+
+	const roleARN = "arn:aws:iam::123456789012:role/ecr-reader"
+	var assumed string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		g.Expect(r.ParseForm()).To(Succeed())
+		if r.Form.Get("Action") == "AssumeRole" {
+			assumed = r.Form.Get("RoleArn")
+			w.Write([]byte(`<AssumeRoleResponse><AssumeRoleResult><Credentials>
+	<AccessKeyId>role-key</AccessKeyId>
+	<SecretAccessKey>role-secret</SecretAccessKey>
+	<SessionToken>role-token</SessionToken>
+	<Expiration>2100-01-01T00:00:00Z</Expiration>
+</Credentials></AssumeRoleResult></AssumeRoleResponse>`))
+			return
+		}
+		// The token is requested with the credentials of the role.
+		if !strings.Contains(r.Header.Get("Authorization"), "Credential=role-key/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"authorizationData": [{"authorizationToken": "c29tZS1rZXk6c29tZS1zZWNyZXQ="}]}`))
+	}
+	srv := httptest.NewServer(http.HandlerFunc(handler))
+	t.Cleanup(func() {
+		srv.Close()
+	})
+
+	ec := NewClient()
+	ec.Config = ec.WithEndpoint(srv.URL).
+		WithCredentials(credentials.NewStaticCredentials("x", "y", "z"))
+
+	a, _, err := ec.getLoginAuth("123456789012", "us-east-1", LoginOptions{RoleARN: roleARN})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(assumed).To(Equal(roleARN))
+	g.Expect(a).To(Equal(authn.AuthConfig{Username: "some-key", Password: "some-secret"}))
+}
+
+func TestGetLoginAuthWithEndpoint(t *testing.T) {
+	g := NewWithT(t)
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"authorizationData": [{"authorizationToken": "c29tZS1rZXk6c29tZS1zZWNyZXQ="}]}`))
+	}
+	srv := httptest.NewServer(http.HandlerFunc(handler))
+	t.Cleanup(func() {
+		srv.Close()
+	})
+
+	// The default endpoint of the client is not reachable, so the token
+	// is only got if the endpoint given is used.
+	ec := NewClient()
+	ec.Config = ec.WithEndpoint("http://127.0.0.1:0").
+		WithCredentials(credentials.NewStaticCredentials("x", "y", "z")).
+		WithMaxRetries(0)
+
+	a, _, err := ec.getLoginAuth("012345678901", "us-east-1", LoginOptions{Endpoint: srv.URL})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(a).To(Equal(authn.AuthConfig{Username: "some-key", Password: "some-secret"}))
+}
+
+func TestGetPublicLoginAuth(t *testing.T) {
+	g := NewWithT(t)
+
+	var target string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		target = r.Header.Get("X-Amz-Target")
+		w.Write([]byte(`{"authorizationData": {"authorizationToken": "c29tZS1rZXk6c29tZS1zZWNyZXQ=", "expiresAt": 4102444800}}`))
+	}
+	srv := httptest.NewServer(http.HandlerFunc(handler))
+	t.Cleanup(func() {
+		srv.Close()
+	})
+
+	ec := NewClient()
+	ec.Config = ec.WithEndpoint(srv.URL).
+		WithCredentials(credentials.NewStaticCredentials("x", "y", "z"))
+
+	a, expiresAt, err := ec.getPublicLoginAuth("")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(target).To(HavePrefix("SpencerFrontendService."))
+	g.Expect(a).To(Equal(authn.AuthConfig{Username: "some-key", Password: "some-secret"}))
+	g.Expect(expiresAt.Year()).To(Equal(2100))
+}
+
 func TestLogin(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -188,6 +281,13 @@ func TestLogin(t *testing.T) {
 			statusCode: http.StatusInternalServerError,
 			wantErr:    true,
 		},
+		{
+			name:       "ECR Public image",
+			autoLogin:  true,
+			image:      "public.ecr.aws/foo/bar:v1",
+			statusCode: http.StatusInternalServerError,
+			wantErr:    true,
+		},
 		{
 			name:       "non ECR image",
 			autoLogin:  true,
@@ -214,7 +314,7 @@ func TestLogin(t *testing.T) {
 			ecrClient.Config = ecrClient.WithEndpoint(srv.URL).
 				WithCredentials(credentials.NewStaticCredentials("x", "y", "z"))
 
-			_, err := ecrClient.Login(context.TODO(), tt.autoLogin, tt.image)
+			_, _, err := ecrClient.Login(context.TODO(), tt.autoLogin, tt.image, LoginOptions{})
 			g.Expect(err != nil).To(Equal(tt.wantErr))
 		})
 	}
@@ -20,6 +20,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
@@ -32,6 +33,17 @@ import (
 	"github.com/fluxcd/image-reflector-controller/internal/registry"
 )
 
+// LoginOptions configure how the client gets the authentication for a
+// registry.
+type LoginOptions struct {
+	// TenantID is the ID of the Azure tenant to authenticate in, in place
+	// of the default of the identity, if any.
+	TenantID string
+	// ClientID is the client ID of a user-assigned managed identity to
+	// authenticate as, in place of the default credentials, if any.
+	ClientID string
+}
+
 // Client is an Azure ACR client which can log into the registry and return
 // authorization information.
 type Client struct {
@@ -58,35 +70,49 @@ func (c *Client) WithScheme(scheme string) *Client {
 
 // getLoginAuth returns authentication for ACR. The details needed for authentication
 // are gotten from environment variable so there is not need to mount a host path.
-func (c *Client) getLoginAuth(ctx context.Context, ref name.Reference) (authn.AuthConfig, error) {
+// It also returns when the ARM token exchanged for the ACR token expires, which
+// the ACR token outlasts.
+func (c *Client) getLoginAuth(ctx context.Context, ref name.Reference, opts LoginOptions) (authn.AuthConfig, time.Time, error) {
 	var authConfig authn.AuthConfig
 
-	// Use default credentials if no token credential is provided.
+	// Use the managed identity given, if any, and otherwise default
+	// credentials if no token credential is provided.
 	// NOTE: NewDefaultAzureCredential() performs a lot of environment lookup
 	// for creating default token credential. Load it only when it's needed.
-	if c.credential == nil {
+	credential := c.credential
+	if opts.ClientID != "" {
+		cred, err := azidentity.NewManagedIdentityCredential(&azidentity.ManagedIdentityCredentialOptions{
+			ID: azidentity.ClientID(opts.ClientID),
+		})
+		if err != nil {
+			return authConfig, time.Time{}, err
+		}
+		credential = cred
+	} else if credential == nil {
 		cred, err := azidentity.NewDefaultAzureCredential(nil)
 		if err != nil {
-			return authConfig, err
+			return authConfig, time.Time{}, err
 		}
 		c.credential = cred
+		credential = cred
 	}
 
 	// Obtain access token using the token credential.
 	// TODO: Add support for other azure endpoints as well.
-	armToken, err := c.credential.GetToken(ctx, policy.TokenRequestOptions{
-		Scopes: []string{string(arm.AzurePublicCloud) + ".default"},
+	armToken, err := credential.GetToken(ctx, policy.TokenRequestOptions{
+		Scopes:   []string{string(arm.AzurePublicCloud) + ".default"},
+		TenantID: opts.TenantID,
 	})
 	if err != nil {
-		return authConfig, err
+		return authConfig, time.Time{}, err
 	}
 
 	// Obtain ACR access token using exchanger.
 	endpoint := fmt.Sprintf("%s://%s", c.scheme, ref.Context().RegistryStr())
 	ex := newExchanger(endpoint)
-	accessToken, err := ex.ExchangeACRAccessToken(string(armToken.Token))
+	accessToken, err := ex.ExchangeACRAccessToken(string(armToken.Token), opts.TenantID)
 	if err != nil {
-		return authConfig, fmt.Errorf("error exchanging token: %w", err)
+		return authConfig, time.Time{}, fmt.Errorf("error exchanging token: %w", err)
 	}
 
 	return authn.AuthConfig{
@@ -94,7 +120,7 @@ func (c *Client) getLoginAuth(ctx context.Context, ref name.Reference) (authn.Au
 		// See documentation: https://docs.microsoft.com/en-us/azure/container-registry/container-registry-authentication?tabs=azure-cli#az-acr-login-with---expose-token
 		Username: "00000000-0000-0000-0000-000000000000",
 		Password: accessToken,
-	}, nil
+	}, armToken.ExpiresOn, nil
 }
 
 // ValidHost returns if a given host is a Azure container registry.
@@ -109,19 +135,20 @@ func ValidHost(host string) bool {
 }
 
 // Login attempts to get the authentication material for ACR. The caller can
-// ensure that the passed image is a valid ACR image using ValidHost().
-func (c *Client) Login(ctx context.Context, autoLogin bool, image string, ref name.Reference) (authn.Authenticator, error) {
+// ensure that the passed image is a valid ACR image using ValidHost(). It
+// also returns when the authentication expires.
+func (c *Client) Login(ctx context.Context, autoLogin bool, image string, ref name.Reference, opts LoginOptions) (authn.Authenticator, time.Time, error) {
 	if autoLogin {
 		ctrl.LoggerFrom(ctx).Info("logging in to Azure ACR for " + image)
-		authConfig, err := c.getLoginAuth(ctx, ref)
+		authConfig, expiresAt, err := c.getLoginAuth(ctx, ref, opts)
 		if err != nil {
 			ctrl.LoggerFrom(ctx).Info("error logging into ACR " + err.Error())
-			return nil, err
+			return nil, time.Time{}, err
 		}
 
 		auth := authn.FromConfig(authConfig)
-		return auth, nil
+		return auth, expiresAt, nil
 	}
 	ctrl.LoggerFrom(ctx).Info("ACR authentication is not enabled. To enable, set the controller flag --azure-autologin-for-acr")
-	return nil, fmt.Errorf("ACR authentication failed: %w", registry.ErrUnconfiguredProvider)
+	return nil, time.Time{}, fmt.Errorf("ACR authentication failed: %w", registry.ErrUnconfiguredProvider)
 }
@@ -90,7 +90,7 @@ func TestGetAzureLoginAuth(t *testing.T) {
 				WithTokenCredential(tt.tokenCredential).
 				WithScheme("http")
 
-			auth, err := c.getLoginAuth(context.TODO(), ref)
+			auth, _, err := c.getLoginAuth(context.TODO(), ref, LoginOptions{})
 			g.Expect(err != nil).To(Equal(tt.wantErr))
 			if tt.statusCode == http.StatusOK {
 				g.Expect(auth).To(Equal(tt.wantAuthConfig))
@@ -171,7 +171,7 @@ func TestLogin(t *testing.T) {
 				WithTokenCredential(&FakeTokenCredential{Token: "foo"}).
 				WithScheme("http")
 
-			_, err = ac.Login(context.TODO(), tt.autoLogin, image, ref)
+			_, _, err = ac.Login(context.TODO(), tt.autoLogin, image, ref, LoginOptions{})
 			g.Expect(err != nil).To(Equal(tt.wantErr))
 		})
 	}
@@ -77,8 +77,8 @@ func newExchanger(endpoint string) *exchanger {
 }
 
 // ExchangeACRAccessToken exchanges an access token for a refresh token with the
-// exchange service.
-func (e *exchanger) ExchangeACRAccessToken(armToken string) (string, error) {
+// exchange service, in the tenant given if it is not empty.
+func (e *exchanger) ExchangeACRAccessToken(armToken, tenantID string) (string, error) {
 	// Construct the exchange URL.
 	exchangeURL, err := url.Parse(e.endpoint)
 	if err != nil {
@@ -90,6 +90,9 @@ func (e *exchanger) ExchangeACRAccessToken(armToken string) (string, error) {
 	parameters.Add("grant_type", "access_token")
 	parameters.Add("service", exchangeURL.Hostname())
 	parameters.Add("access_token", armToken)
+	if tenantID != "" {
+		parameters.Add("tenant", tenantID)
+	}
 
 	resp, err := http.PostForm(exchangeURL.String(), parameters)
 	if err != nil {
@@ -85,7 +85,7 @@ func TestExchanger_ExchangeACRAccessToken(t *testing.T) {
 			})
 
 			ex := newExchanger(srv.URL)
-			token, err := ex.ExchangeACRAccessToken("some-access-token")
+			token, err := ex.ExchangeACRAccessToken("some-access-token", "")
 			g.Expect(err != nil).To(Equal(tt.wantErr))
 			if tt.statusCode == http.StatusOK {
 				g.Expect(token).To(Equal(tt.wantToken))
@@ -93,3 +93,23 @@ func TestExchanger_ExchangeACRAccessToken(t *testing.T) {
 		})
 	}
 }
+
+func TestExchanger_ExchangeACRAccessTokenInTenant(t *testing.T) {
+	g := NewWithT(t)
+
+	var tenant string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		g.Expect(r.ParseForm()).To(Succeed())
+		tenant = r.PostForm.Get("tenant")
+		w.Write([]byte(`{"refresh_token": "bbbbb"}`))
+	}
+	srv := httptest.NewServer(http.HandlerFunc(handler))
+	t.Cleanup(func() {
+		srv.Close()
+	})
+
+	ex := newExchanger(srv.URL)
+	_, err := ex.ExchangeACRAccessToken("some-access-token", "some-tenant")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(tenant).To(Equal("some-tenant"))
+}
@@ -27,4 +27,5 @@ const (
 	ProviderAWS
 	ProviderGCR
 	ProviderAzure
+	ProviderOracle
 )
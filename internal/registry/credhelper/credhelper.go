@@ -0,0 +1,138 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package credhelper
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"github.com/fluxcd/image-reflector-controller/internal/registry"
+)
+
+const (
+	// binaryPrefix is the prefix of the name of the binary of a
+	// credential helper.
+	binaryPrefix = "docker-credential-"
+	// timeout bounds how long a credential helper is given to respond.
+	timeout = 30 * time.Second
+	// notFoundMessage is the message of a credential helper that has no
+	// credentials for the registry.
+	notFoundMessage = "credentials not found in native keychain"
+	// identityTokenUsername is the user name with which a credential
+	// helper gives an identity token, in place of a password.
+	identityTokenUsername = "<token>"
+	// dockerHubServerURL is the server URL of Docker Hub, as credentials
+	// for it are keyed by docker.
+	dockerHubServerURL = "https://index.docker.io/v1/"
+)
+
+var helperNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+// Helper is a docker credential helper, an executable which gives the
+// credentials for a registry by the protocol of docker.
+type Helper struct {
+	Path string
+}
+
+// Find returns the helper of the name given in the directory, as the
+// binary `docker-credential-<name>`. The directory is where the
+// operator of the controller supplies helpers; nothing outside it is
+// run. It returns an error wrapping registry.ErrUnconfiguredProvider if
+// no directory is given.
+func Find(dir, helperName string) (Helper, error) {
+	if dir == "" {
+		return Helper{}, fmt.Errorf("credential helper '%s' cannot be used: %w", helperName, registry.ErrUnconfiguredProvider)
+	}
+	if !helperNameRe.MatchString(helperName) {
+		return Helper{}, fmt.Errorf("invalid credential helper name '%s'", helperName)
+	}
+	path := filepath.Join(dir, binaryPrefix+helperName)
+	info, err := os.Stat(path)
+	if err != nil {
+		return Helper{}, fmt.Errorf("credential helper '%s' not found: %w", helperName, err)
+	}
+	if info.IsDir() || info.Mode()&0o111 == 0 {
+		return Helper{}, fmt.Errorf("credential helper '%s' is not executable", helperName)
+	}
+	return Helper{Path: path}, nil
+}
+
+// serverURL returns the server URL the credentials for the registry of
+// the repository are got for.
+func serverURL(repo name.Repository) string {
+	if repo.RegistryStr() == name.DefaultRegistry {
+		return dockerHubServerURL
+	}
+	return repo.RegistryStr()
+}
+
+// Login runs the helper to get the credentials for the registry of the
+// repository. It returns a nil authenticator, meaning anonymous access,
+// if the helper has no credentials for the registry.
+func (h Helper) Login(ctx context.Context, repo name.Repository) (authn.Authenticator, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, h.Path, "get")
+	cmd.Stdin = strings.NewReader(serverURL(repo))
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if strings.TrimSpace(stdout.String()) == notFoundMessage {
+			ctrl.LoggerFrom(ctx).Info("credential helper has no credentials for " + repo.RegistryStr())
+			return nil, nil
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			msg := strings.TrimSpace(stderr.String())
+			if msg == "" {
+				msg = strings.TrimSpace(stdout.String())
+			}
+			return nil, fmt.Errorf("credential helper '%s' failed: %s: %s", filepath.Base(h.Path), err, msg)
+		}
+		return nil, fmt.Errorf("unable to run credential helper '%s': %w", filepath.Base(h.Path), err)
+	}
+
+	var creds struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &creds); err != nil {
+		return nil, fmt.Errorf("invalid response from credential helper '%s': %w", filepath.Base(h.Path), err)
+	}
+	if creds.Username == identityTokenUsername {
+		return authn.FromConfig(authn.AuthConfig{IdentityToken: creds.Secret}), nil
+	}
+	return authn.FromConfig(authn.AuthConfig{
+		Username: creds.Username,
+		Password: creds.Secret,
+	}), nil
+}
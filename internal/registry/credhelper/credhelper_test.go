@@ -0,0 +1,157 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package credhelper
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	. "github.com/onsi/gomega"
+
+	"github.com/fluxcd/image-reflector-controller/internal/registry"
+)
+
+// writeHelper writes a credential helper of the name given, running the
+// shell script, to the directory.
+func writeHelper(t *testing.T, dir, helperName, script string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, binaryPrefix+helperName), []byte("#!/bin/sh\n"+script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFind(t *testing.T) {
+	dir := t.TempDir()
+	writeHelper(t, dir, "example", "exit 0\n")
+	if err := os.WriteFile(filepath.Join(dir, binaryPrefix+"data"), []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		dir        string
+		helperName string
+		wantErr    bool
+	}{
+		{name: "helper", dir: dir, helperName: "example"},
+		{name: "no directory", helperName: "example", wantErr: true},
+		{name: "missing helper", dir: dir, helperName: "missing", wantErr: true},
+		{name: "not executable", dir: dir, helperName: "data", wantErr: true},
+		{name: "path outside the directory", dir: dir, helperName: "../example", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			helper, err := Find(tt.dir, tt.helperName)
+			g.Expect(err != nil).To(Equal(tt.wantErr))
+			if !tt.wantErr {
+				g.Expect(helper.Path).To(Equal(filepath.Join(dir, "docker-credential-example")))
+			}
+		})
+	}
+
+	g := NewWithT(t)
+	_, err := Find("", "example")
+	g.Expect(errors.Is(err, registry.ErrUnconfiguredProvider)).To(BeTrue())
+}
+
+func TestHelper_Login(t *testing.T) {
+	tests := []struct {
+		name     string
+		image    string
+		script   string
+		wantErr  bool
+		wantAuth *authn.AuthConfig
+	}{
+		{
+			name:  "username and password",
+			image: "registry.example.com/foo/bar",
+			script: `read server
+[ "$1" = get ] && [ "$server" = registry.example.com ] || exit 1
+echo '{"ServerURL": "registry.example.com", "Username": "user", "Secret": "pass"}'
+`,
+			wantAuth: &authn.AuthConfig{Username: "user", Password: "pass"},
+		},
+		{
+			name:  "identity token",
+			image: "registry.example.com/foo/bar",
+			script: `echo '{"ServerURL": "registry.example.com", "Username": "<token>", "Secret": "t0ken"}'
+`,
+			wantAuth: &authn.AuthConfig{IdentityToken: "t0ken"},
+		},
+		{
+			name:  "docker hub",
+			image: "foo/bar",
+			script: `read server
+[ "$server" = https://index.docker.io/v1/ ] || exit 1
+echo '{"Username": "user", "Secret": "pass"}'
+`,
+			wantAuth: &authn.AuthConfig{Username: "user", Password: "pass"},
+		},
+		{
+			name:  "no credentials",
+			image: "registry.example.com/foo/bar",
+			script: `echo 'credentials not found in native keychain'
+exit 1
+`,
+		},
+		{
+			name:  "failure",
+			image: "registry.example.com/foo/bar",
+			script: `echo 'no access' >&2
+exit 1
+`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid response",
+			image:   "registry.example.com/foo/bar",
+			script:  "echo 'not json'\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			dir := t.TempDir()
+			writeHelper(t, dir, "example", tt.script)
+			helper, err := Find(dir, "example")
+			g.Expect(err).ToNot(HaveOccurred())
+
+			repo, err := name.NewRepository(tt.image)
+			g.Expect(err).ToNot(HaveOccurred())
+			auth, err := helper.Login(context.TODO(), repo)
+			g.Expect(err != nil).To(Equal(tt.wantErr))
+			if tt.wantAuth == nil {
+				g.Expect(auth).To(BeNil())
+				return
+			}
+			cfg, err := auth.Authorization()
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(cfg).To(Equal(tt.wantAuth))
+		})
+	}
+}
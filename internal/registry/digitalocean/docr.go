@@ -0,0 +1,134 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package digitalocean
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+)
+
+const (
+	// TokenKey is the key of a secret giving a DigitalOcean API token,
+	// with read access to the container registry.
+	TokenKey = "digitaloceanToken"
+
+	// RegistryHost is the host of DigitalOcean Container Registry.
+	RegistryHost = "registry.digitalocean.com"
+	// DefaultBaseURL is the URL of the DigitalOcean API.
+	DefaultBaseURL = "https://api.digitalocean.com"
+
+	// credentialsExpiry is how long the docker credentials got with the
+	// API token last.
+	credentialsExpiry = time.Hour
+)
+
+// Credentials are a DigitalOcean API token, for which short-lived docker
+// credentials are got to authenticate to the container registry.
+type Credentials struct {
+	Token   string
+	BaseURL string
+}
+
+// CredentialsFromSecret returns the DigitalOcean credentials given by
+// the data of a secret, and `true`, if the secret gives them, otherwise
+// `false`.
+func CredentialsFromSecret(data map[string][]byte) (Credentials, bool, error) {
+	token, ok := data[TokenKey]
+	if !ok {
+		return Credentials{}, false, nil
+	}
+	c := Credentials{
+		Token:   strings.TrimSpace(string(token)),
+		BaseURL: DefaultBaseURL,
+	}
+	if c.Token == "" {
+		return Credentials{}, true, fmt.Errorf("secret gives an empty '%s'", TokenKey)
+	}
+	return c, true, nil
+}
+
+// CacheKey returns a key identifying the API token, so that docker
+// credentials are only reused for a secret giving the same token.
+func (c Credentials) CacheKey() string {
+	sum := sha256.Sum256([]byte(c.Token))
+	return "docr/" + hex.EncodeToString(sum[:])
+}
+
+// Login gets read-only docker credentials for the container registry
+// with the API token, returning the authentication they give and when
+// they expire.
+func (c Credentials) Login(ctx context.Context) (authn.Authenticator, time.Time, error) {
+	query := url.Values{
+		"read_write":     {"false"},
+		"expiry_seconds": {strconv.Itoa(int(credentialsExpiry.Seconds()))},
+	}
+	u := c.BaseURL + "/v2/registry/docker-credentials?" + query.Encode()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	request.Header.Set("Authorization", "Bearer "+c.Token)
+
+	requested := time.Now()
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer response.Body.Close()
+	defer io.Copy(io.Discard, response.Body)
+
+	if response.StatusCode != http.StatusOK {
+		return nil, time.Time{}, fmt.Errorf("unexpected status from DigitalOcean getting docker credentials: %s", response.Status)
+	}
+
+	var dockerConfig struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&dockerConfig); err != nil {
+		return nil, time.Time{}, err
+	}
+	entry, ok := dockerConfig.Auths[RegistryHost]
+	if !ok {
+		return nil, time.Time{}, fmt.Errorf("no credentials for '%s' in the response from DigitalOcean", RegistryHost)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("invalid docker credentials from DigitalOcean: %w", err)
+	}
+	username, password, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return nil, time.Time{}, fmt.Errorf("invalid docker credentials from DigitalOcean")
+	}
+	return authn.FromConfig(authn.AuthConfig{
+		Username: username,
+		Password: password,
+	}), requested.Add(credentialsExpiry), nil
+}
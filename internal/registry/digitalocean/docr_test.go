@@ -0,0 +1,123 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package digitalocean
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestCredentialsFromSecret(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      map[string][]byte
+		wantOK    bool
+		wantErr   bool
+		wantCreds Credentials
+	}{
+		{
+			name: "no token",
+			data: map[string][]byte{".dockerconfigjson": []byte("{}")},
+		},
+		{
+			name:      "token",
+			data:      map[string][]byte{TokenKey: []byte("dop_v1_t0ken\n")},
+			wantOK:    true,
+			wantCreds: Credentials{Token: "dop_v1_t0ken", BaseURL: DefaultBaseURL},
+		},
+		{
+			name:    "empty token",
+			data:    map[string][]byte{TokenKey: []byte("")},
+			wantOK:  true,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			creds, ok, err := CredentialsFromSecret(tt.data)
+			g.Expect(err != nil).To(Equal(tt.wantErr))
+			g.Expect(ok).To(Equal(tt.wantOK))
+			g.Expect(creds).To(Equal(tt.wantCreds))
+		})
+	}
+}
+
+func TestCredentials_Login(t *testing.T) {
+	tests := []struct {
+		name         string
+		statusCode   int
+		responseBody string
+		wantErr      bool
+	}{
+		{
+			name:         "docker credentials",
+			statusCode:   http.StatusOK,
+			responseBody: fmt.Sprintf(`{"auths": {"registry.digitalocean.com": {"auth": "%s"}}}`, base64.StdEncoding.EncodeToString([]byte("user:pass"))),
+		},
+		{
+			name:       "invalid token",
+			statusCode: http.StatusUnauthorized,
+			wantErr:    true,
+		},
+		{
+			name:         "no credentials for the registry",
+			statusCode:   http.StatusOK,
+			responseBody: `{"auths": {}}`,
+			wantErr:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			handler := func(w http.ResponseWriter, r *http.Request) {
+				g.Expect(r.URL.Path).To(Equal("/v2/registry/docker-credentials"))
+				g.Expect(r.URL.Query().Get("read_write")).To(Equal("false"))
+				g.Expect(r.URL.Query().Get("expiry_seconds")).To(Equal("3600"))
+				g.Expect(r.Header.Get("Authorization")).To(Equal("Bearer dop_v1_t0ken"))
+				w.WriteHeader(tt.statusCode)
+				fmt.Fprint(w, tt.responseBody)
+			}
+			srv := httptest.NewServer(http.HandlerFunc(handler))
+			t.Cleanup(srv.Close)
+
+			creds := Credentials{Token: "dop_v1_t0ken", BaseURL: srv.URL}
+			requested := time.Now()
+			auth, expiresAt, err := creds.Login(context.TODO())
+			g.Expect(err != nil).To(Equal(tt.wantErr))
+			if tt.wantErr {
+				return
+			}
+			cfg, err := auth.Authorization()
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(cfg.Username).To(Equal("user"))
+			g.Expect(cfg.Password).To(Equal("pass"))
+			g.Expect(expiresAt).To(BeTemporally("~", requested.Add(time.Hour), time.Second))
+		})
+	}
+}
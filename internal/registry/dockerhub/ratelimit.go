@@ -0,0 +1,158 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dockerhub
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+const (
+	// LimitHeader is the header in which Docker Hub gives the number of
+	// pulls allowed in each window, e.g., `100;w=21600`.
+	LimitHeader = "ratelimit-limit"
+	// RemainingHeader is the header in which Docker Hub gives the number
+	// of pulls remaining in the current window, e.g., `76;w=21600`.
+	RemainingHeader = "ratelimit-remaining"
+
+	// lowQuotaPercent is the percentage of the limit at or under which
+	// the quota remaining is taken to be nearly exhausted.
+	lowQuotaPercent = 10
+)
+
+// IsDockerHub reports whether the registry is Docker Hub.
+func IsDockerHub(registry string) bool {
+	return registry == name.DefaultRegistry || registry == "registry-1.docker.io" || registry == "docker.io"
+}
+
+// RateLimit is the quota of pulls Docker Hub reports for the client.
+type RateLimit struct {
+	// Limit is the number of pulls allowed in each window.
+	Limit int
+	// Remaining is the number of pulls remaining in the current window.
+	Remaining int
+	// Window is the length of the window, if given.
+	Window time.Duration
+}
+
+// ParseRateLimit returns the rate limit given by the headers of a
+// response, and `true`, if they give one, otherwise `false`.
+func ParseRateLimit(header http.Header) (RateLimit, bool) {
+	limit, window, ok := parseQuota(header.Get(LimitHeader))
+	if !ok {
+		return RateLimit{}, false
+	}
+	remaining, remainingWindow, ok := parseQuota(header.Get(RemainingHeader))
+	if !ok {
+		return RateLimit{}, false
+	}
+	if window == 0 {
+		window = remainingWindow
+	}
+	return RateLimit{Limit: limit, Remaining: remaining, Window: window}, true
+}
+
+// parseQuota parses a quota header value, a number followed by optional
+// parameters, of which `w` is the window in seconds.
+func parseQuota(value string) (int, time.Duration, bool) {
+	if value == "" {
+		return 0, 0, false
+	}
+	parts := strings.Split(value, ";")
+	n, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil || n < 0 {
+		return 0, 0, false
+	}
+	var window time.Duration
+	for _, param := range parts[1:] {
+		key, val, found := strings.Cut(strings.TrimSpace(param), "=")
+		if !found || key != "w" {
+			continue
+		}
+		if seconds, err := strconv.Atoi(val); err == nil && seconds > 0 {
+			window = time.Duration(seconds) * time.Second
+		}
+	}
+	return n, window, true
+}
+
+// NearlyExhausted reports whether the quota remaining is at or under a
+// tenth of the limit.
+func (l RateLimit) NearlyExhausted() bool {
+	return l.Limit > 0 && l.Remaining*100 <= l.Limit*lowQuotaPercent
+}
+
+// Backoff returns how long to wait before pulling again when the quota
+// is nearly exhausted, spreading the pulls remaining over the window; it
+// is zero otherwise, or if the window is not known.
+func (l RateLimit) Backoff() time.Duration {
+	if !l.NearlyExhausted() || l.Window == 0 {
+		return 0
+	}
+	return l.Window / time.Duration(l.Remaining+1)
+}
+
+// Transport records the rate limit reported with each response from
+// Docker Hub, using the inner transport for the requests.
+type Transport struct {
+	inner http.RoundTripper
+
+	mu        sync.Mutex
+	rateLimit RateLimit
+	observed  bool
+}
+
+// NewTransport returns a transport which records the rate limit Docker
+// Hub reports, using the inner transport for the requests.
+func NewTransport(inner http.RoundTripper) *Transport {
+	return &Transport{inner: inner}
+}
+
+func (t *Transport) RoundTrip(request *http.Request) (*http.Response, error) {
+	response, err := t.inner.RoundTrip(request)
+	if err != nil {
+		return response, err
+	}
+	if l, ok := ParseRateLimit(response.Header); ok {
+		t.mu.Lock()
+		t.rateLimit, t.observed = l, true
+		t.mu.Unlock()
+	}
+	return response, nil
+}
+
+// RateLimit returns the rate limit reported with the latest response
+// giving one, and `true`, or `false` if none has.
+func (t *Transport) RateLimit() (RateLimit, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.rateLimit, t.observed
+}
+
+// RateLimitOf returns the rate limit recorded by the transport given,
+// and `true`, if it is a Transport which has recorded one.
+func RateLimitOf(rt http.RoundTripper) (RateLimit, bool) {
+	if t, ok := rt.(*Transport); ok {
+		return t.RateLimit()
+	}
+	return RateLimit{}, false
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dockerhub
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		name      string
+		limit     string
+		remaining string
+		wantOK    bool
+		want      RateLimit
+	}{
+		{
+			name: "no headers",
+		},
+		{
+			name:      "limit and remaining with window",
+			limit:     "100;w=21600",
+			remaining: "76;w=21600",
+			wantOK:    true,
+			want:      RateLimit{Limit: 100, Remaining: 76, Window: 6 * time.Hour},
+		},
+		{
+			name:      "without window",
+			limit:     "200",
+			remaining: "0",
+			wantOK:    true,
+			want:      RateLimit{Limit: 200, Remaining: 0},
+		},
+		{
+			name:      "window only with remaining",
+			limit:     "100",
+			remaining: "5;w=3600",
+			wantOK:    true,
+			want:      RateLimit{Limit: 100, Remaining: 5, Window: time.Hour},
+		},
+		{
+			name:  "no remaining",
+			limit: "100;w=21600",
+		},
+		{
+			name:      "invalid number",
+			limit:     "lots;w=21600",
+			remaining: "76;w=21600",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			header := http.Header{}
+			if tt.limit != "" {
+				header.Set(LimitHeader, tt.limit)
+			}
+			if tt.remaining != "" {
+				header.Set(RemainingHeader, tt.remaining)
+			}
+			l, ok := ParseRateLimit(header)
+			g.Expect(ok).To(Equal(tt.wantOK))
+			g.Expect(l).To(Equal(tt.want))
+		})
+	}
+}
+
+func TestRateLimit_Backoff(t *testing.T) {
+	g := NewWithT(t)
+	window := 6 * time.Hour
+	g.Expect(RateLimit{Limit: 100, Remaining: 50, Window: window}.Backoff()).To(BeZero())
+	g.Expect(RateLimit{Limit: 100, Remaining: 9, Window: window}.Backoff()).To(Equal(window / 10))
+	g.Expect(RateLimit{Limit: 100, Remaining: 0, Window: window}.Backoff()).To(Equal(window))
+	g.Expect(RateLimit{Limit: 100, Remaining: 0}.Backoff()).To(BeZero())
+}
+
+func TestTransport(t *testing.T) {
+	g := NewWithT(t)
+	remaining := "76;w=21600"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/foo/bar/manifests/latest" {
+			w.Header().Set(LimitHeader, "100;w=21600")
+			w.Header().Set(RemainingHeader, remaining)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	tr := NewTransport(http.DefaultTransport)
+	_, ok := RateLimitOf(tr)
+	g.Expect(ok).To(BeFalse())
+
+	get := func(path string) {
+		t.Helper()
+		response, err := (&http.Client{Transport: tr}).Get(srv.URL + path)
+		g.Expect(err).ToNot(HaveOccurred())
+		response.Body.Close()
+	}
+	get("/v2/foo/bar/manifests/latest")
+	l, ok := RateLimitOf(tr)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(l).To(Equal(RateLimit{Limit: 100, Remaining: 76, Window: 6 * time.Hour}))
+
+	// A response without the headers leaves the latest rate limit.
+	remaining = "75;w=21600"
+	get("/v2/foo/bar/tags/list")
+	l, _ = RateLimitOf(tr)
+	g.Expect(l.Remaining).To(Equal(76))
+	get("/v2/foo/bar/manifests/latest")
+	l, _ = RateLimitOf(tr)
+	g.Expect(l.Remaining).To(Equal(75))
+
+	_, ok = RateLimitOf(http.DefaultTransport)
+	g.Expect(ok).To(BeFalse())
+}
@@ -17,12 +17,15 @@ limitations under the License.
 package gcp
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strings"
+	"time"
 
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
@@ -40,6 +43,33 @@ type gceToken struct {
 // GCP_TOKEN_URL is the default GCP metadata endpoint used for authentication.
 const GCP_TOKEN_URL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
 
+// IAM_CREDENTIALS_URL is the default endpoint of the IAM Service Account
+// Credentials API, used to impersonate Google service accounts.
+const IAM_CREDENTIALS_URL = "https://iamcredentials.googleapis.com"
+
+// STS_URL is the default endpoint of the Google Security Token Service,
+// used to exchange the tokens of external identities with workload
+// identity federation.
+const STS_URL = "https://sts.googleapis.com/v1/token"
+
+// ServiceAccountAnnotation is the annotation of a Kubernetes service
+// account giving the Google service account it is bound to with
+// workload identity.
+const ServiceAccountAnnotation = "iam.gke.io/gcp-service-account"
+
+// cloudPlatformScope is the OAuth scope of the tokens got by
+// impersonating a Google service account.
+const cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// LoginOptions configure how the client gets the authentication for a
+// registry.
+type LoginOptions struct {
+	// ServiceAccount is the email of a Google service account to
+	// impersonate to get the authentication, if any. The identity of the
+	// controller must be allowed to create tokens for it.
+	ServiceAccount string
+}
+
 // ValidHost returns if a given host is a valid GCR host.
 func ValidHost(host string) bool {
 	return host == "gcr.io" || strings.HasSuffix(host, ".gcr.io") || strings.HasSuffix(host, "-docker.pkg.dev")
@@ -48,12 +78,14 @@ func ValidHost(host string) bool {
 // Client is a GCP GCR client which can log into the registry and return
 // authorization information.
 type Client struct {
-	tokenURL string
+	tokenURL          string
+	iamCredentialsURL string
+	stsURL            string
 }
 
 // NewClient creates a new GCR client with default configurations.
 func NewClient() *Client {
-	return &Client{tokenURL: GCP_TOKEN_URL}
+	return &Client{tokenURL: GCP_TOKEN_URL, iamCredentialsURL: IAM_CREDENTIALS_URL, stsURL: STS_URL}
 }
 
 // WithTokenURL sets the token URL used by the GCR client.
@@ -62,16 +94,31 @@ func (c *Client) WithTokenURL(url string) *Client {
 	return c
 }
 
+// WithIAMCredentialsURL sets the URL of the IAM Service Account
+// Credentials API used by the GCR client.
+func (c *Client) WithIAMCredentialsURL(url string) *Client {
+	c.iamCredentialsURL = url
+	return c
+}
+
+// WithSTSURL sets the URL of the Security Token Service used by the GCR
+// client.
+func (c *Client) WithSTSURL(url string) *Client {
+	c.stsURL = url
+	return c
+}
+
 // getLoginAuth obtains authentication by getting a token from the metadata API
 // on GCP. This assumes that the pod has right to pull the image which would be
 // the case if it is hosted on GCP. It works with both service account and
-// workload identity enabled clusters.
-func (c *Client) getLoginAuth(ctx context.Context) (authn.AuthConfig, error) {
+// workload identity enabled clusters. It also returns when the token
+// expires.
+func (c *Client) getLoginAuth(ctx context.Context) (authn.AuthConfig, time.Time, error) {
 	var authConfig authn.AuthConfig
 
 	request, err := http.NewRequestWithContext(ctx, http.MethodGet, c.tokenURL, nil)
 	if err != nil {
-		return authConfig, err
+		return authConfig, time.Time{}, err
 	}
 
 	request.Header.Add("Metadata-Flavor", "Google")
@@ -79,42 +126,151 @@ func (c *Client) getLoginAuth(ctx context.Context) (authn.AuthConfig, error) {
 	client := &http.Client{}
 	response, err := client.Do(request)
 	if err != nil {
-		return authConfig, err
+		return authConfig, time.Time{}, err
 	}
 	defer response.Body.Close()
 	defer io.Copy(io.Discard, response.Body)
 
 	if response.StatusCode != http.StatusOK {
-		return authConfig, fmt.Errorf("unexpected status from metadata service: %s", response.Status)
+		return authConfig, time.Time{}, fmt.Errorf("unexpected status from metadata service: %s", response.Status)
 	}
 
+	requested := time.Now()
 	var accessToken gceToken
 	decoder := json.NewDecoder(response.Body)
 	if err := decoder.Decode(&accessToken); err != nil {
-		return authConfig, err
+		return authConfig, time.Time{}, err
 	}
 
 	authConfig = authn.AuthConfig{
 		Username: "oauth2accesstoken",
 		Password: accessToken.AccessToken,
 	}
-	return authConfig, nil
+	return authConfig, requested.Add(time.Duration(accessToken.ExpiresIn) * time.Second), nil
+}
+
+// impersonate exchanges the token of the controller for one of the
+// Google service account, with the IAM Service Account Credentials API.
+// It returns the token, and when it expires.
+func (c *Client) impersonate(ctx context.Context, token, serviceAccount string) (string, time.Time, error) {
+	body, err := json.Marshal(map[string][]string{"scope": {cloudPlatformScope}})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	u := fmt.Sprintf("%s/v1/projects/-/serviceAccounts/%s:generateAccessToken", c.iamCredentialsURL, url.PathEscape(serviceAccount))
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer response.Body.Close()
+	defer io.Copy(io.Discard, response.Body)
+
+	if response.StatusCode != http.StatusOK {
+		return "", time.Time{}, fmt.Errorf("unexpected status from IAM credentials service impersonating '%s': %s", serviceAccount, response.Status)
+	}
+
+	var accessToken struct {
+		AccessToken string    `json:"accessToken"`
+		ExpireTime  time.Time `json:"expireTime"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&accessToken); err != nil {
+		return "", time.Time{}, err
+	}
+	return accessToken.AccessToken, accessToken.ExpireTime, nil
+}
+
+// exchangeToken exchanges the token of an external identity, e.g., a
+// Kubernetes service account, for a federated token with the Security
+// Token Service. The audience is the full resource name of the provider
+// of the workload identity pool trusting the issuer of the token. It
+// returns the federated token, and when it expires.
+func (c *Client) exchangeToken(ctx context.Context, subjectToken, audience string) (string, time.Time, error) {
+	form := url.Values{
+		"grant_type":           {"urn:ietf:params:oauth:grant-type:token-exchange"},
+		"audience":             {audience},
+		"scope":                {cloudPlatformScope},
+		"requested_token_type": {"urn:ietf:params:oauth:token-type:access_token"},
+		"subject_token_type":   {"urn:ietf:params:oauth:token-type:jwt"},
+		"subject_token":        {subjectToken},
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.stsURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer response.Body.Close()
+	defer io.Copy(io.Discard, response.Body)
+
+	if response.StatusCode != http.StatusOK {
+		return "", time.Time{}, fmt.Errorf("unexpected status from security token service exchanging token for '%s': %s", audience, response.Status)
+	}
+
+	requested := time.Now()
+	var accessToken gceToken
+	if err := json.NewDecoder(response.Body).Decode(&accessToken); err != nil {
+		return "", time.Time{}, err
+	}
+	return accessToken.AccessToken, requested.Add(time.Duration(accessToken.ExpiresIn) * time.Second), nil
+}
+
+// FederatedLogin gets the authentication for GCR with workload identity
+// federation, exchanging the token of an external identity for a
+// federated token, with the provider of the workload identity pool
+// given. If a service account is given, the federated token is used to
+// impersonate it; otherwise, the federated identity must be allowed to
+// read the registry itself. It also returns when the authentication
+// expires.
+func (c *Client) FederatedLogin(ctx context.Context, subjectToken, provider, serviceAccount string) (authn.Authenticator, time.Time, error) {
+	token, expiresAt, err := c.exchangeToken(ctx, subjectToken, provider)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if serviceAccount != "" {
+		if token, expiresAt, err = c.impersonate(ctx, token, serviceAccount); err != nil {
+			return nil, time.Time{}, err
+		}
+	}
+	return authn.FromConfig(authn.AuthConfig{
+		Username: "oauth2accesstoken",
+		Password: token,
+	}), expiresAt, nil
 }
 
 // Login attempts to get the authentication material for GCR. The caller can
-// ensure that the passed image is a valid GCR image using ValidHost().
-func (c *Client) Login(ctx context.Context, autoLogin bool, image string, ref name.Reference) (authn.Authenticator, error) {
+// ensure that the passed image is a valid GCR image using ValidHost(). If a
+// service account is given, it is impersonated to get the authentication.
+// It also returns when the authentication expires.
+func (c *Client) Login(ctx context.Context, autoLogin bool, image string, ref name.Reference, opts LoginOptions) (authn.Authenticator, time.Time, error) {
 	if autoLogin {
 		ctrl.LoggerFrom(ctx).Info("logging in to GCP GCR for " + image)
-		authConfig, err := c.getLoginAuth(ctx)
+		authConfig, expiresAt, err := c.getLoginAuth(ctx)
 		if err != nil {
 			ctrl.LoggerFrom(ctx).Info("error logging into GCP " + err.Error())
-			return nil, err
+			return nil, time.Time{}, err
+		}
+		if opts.ServiceAccount != "" {
+			authConfig.Password, expiresAt, err = c.impersonate(ctx, authConfig.Password, opts.ServiceAccount)
+			if err != nil {
+				ctrl.LoggerFrom(ctx).Info("error impersonating GCP service account " + err.Error())
+				return nil, time.Time{}, err
+			}
 		}
 
 		auth := authn.FromConfig(authConfig)
-		return auth, nil
+		return auth, expiresAt, nil
 	}
 	ctrl.LoggerFrom(ctx).Info("GCR authentication is not enabled. To enable, set the controller flag --gcp-autologin-for-gcr")
-	return nil, fmt.Errorf("GCR authentication failed: %w", registry.ErrUnconfiguredProvider)
+	return nil, time.Time{}, fmt.Errorf("GCR authentication failed: %w", registry.ErrUnconfiguredProvider)
 }
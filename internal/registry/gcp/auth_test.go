@@ -77,7 +77,7 @@ func TestGetLoginAuth(t *testing.T) {
 			})
 
 			gc := NewClient().WithTokenURL(srv.URL)
-			a, err := gc.getLoginAuth(context.TODO())
+			a, _, err := gc.getLoginAuth(context.TODO())
 			g.Expect(err != nil).To(Equal(tt.wantErr))
 			if tt.statusCode == http.StatusOK {
 				g.Expect(a).To(Equal(tt.wantAuthConfig))
@@ -159,8 +159,95 @@ func TestLogin(t *testing.T) {
 
 			gc := NewClient().WithTokenURL(srv.URL)
 
-			_, err = gc.Login(context.TODO(), tt.autoLogin, tt.image, ref)
+			_, _, err = gc.Login(context.TODO(), tt.autoLogin, tt.image, ref, LoginOptions{})
 			g.Expect(err != nil).To(Equal(tt.wantErr))
 		})
 	}
 }
+
+func TestLoginImpersonating(t *testing.T) {
+	g := NewWithT(t)
+
+	const serviceAccount = "tenant@project.iam.gserviceaccount.com"
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"access_token": "controller-token","expires_in": 3600, "token_type": "foo"}`))
+	})
+	mux.HandleFunc("/v1/projects/-/serviceAccounts/"+serviceAccount+":generateAccessToken", func(w http.ResponseWriter, r *http.Request) {
+		// The token of the controller is exchanged for that of the
+		// service account.
+		if r.Method != http.MethodPost || r.Header.Get("Authorization") != "Bearer controller-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"accessToken": "tenant-token", "expireTime": "2100-01-01T00:00:00Z"}`))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		srv.Close()
+	})
+
+	ref, err := name.ParseReference(testValidGCRImage)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	gc := NewClient().WithTokenURL(srv.URL + "/token").WithIAMCredentialsURL(srv.URL)
+	auth, expiresAt, err := gc.Login(context.TODO(), true, testValidGCRImage, ref, LoginOptions{ServiceAccount: serviceAccount})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(expiresAt.Year()).To(Equal(2100))
+	authConfig, err := auth.Authorization()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(authConfig.Password).To(Equal("tenant-token"))
+
+	_, _, err = gc.Login(context.TODO(), true, testValidGCRImage, ref, LoginOptions{ServiceAccount: "other@project.iam.gserviceaccount.com"})
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestFederatedLogin(t *testing.T) {
+	g := NewWithT(t)
+
+	const provider = "//iam.googleapis.com/projects/123/locations/global/workloadIdentityPools/pool/providers/cluster"
+	const serviceAccount = "tenant@project.iam.gserviceaccount.com"
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sts", func(w http.ResponseWriter, r *http.Request) {
+		// The token of the Kubernetes service account is exchanged for
+		// a federated token.
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("subject_token") != "sa-token" ||
+			r.PostForm.Get("audience") != provider ||
+			r.PostForm.Get("grant_type") != "urn:ietf:params:oauth:grant-type:token-exchange" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"access_token": "federated-token", "expires_in": 3600, "token_type": "Bearer"}`))
+	})
+	mux.HandleFunc("/v1/projects/-/serviceAccounts/"+serviceAccount+":generateAccessToken", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer federated-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"accessToken": "tenant-token", "expireTime": "2100-01-01T00:00:00Z"}`))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		srv.Close()
+	})
+
+	gc := NewClient().WithSTSURL(srv.URL + "/sts").WithIAMCredentialsURL(srv.URL)
+
+	// Without a service account, the federated token is used.
+	auth, _, err := gc.FederatedLogin(context.TODO(), "sa-token", provider, "")
+	g.Expect(err).ToNot(HaveOccurred())
+	authConfig, err := auth.Authorization()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(authConfig.Username).To(Equal("oauth2accesstoken"))
+	g.Expect(authConfig.Password).To(Equal("federated-token"))
+
+	auth, expiresAt, err := gc.FederatedLogin(context.TODO(), "sa-token", provider, serviceAccount)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(expiresAt.Year()).To(Equal(2100))
+	authConfig, err = auth.Authorization()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(authConfig.Password).To(Equal("tenant-token"))
+
+	_, _, err = gc.FederatedLogin(context.TODO(), "other-token", provider, "")
+	g.Expect(err).To(HaveOccurred())
+}
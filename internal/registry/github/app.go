@@ -0,0 +1,151 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package github
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/go-containerregistry/pkg/authn"
+)
+
+const (
+	// AppIDKey is the key of a secret giving the ID of a GitHub App.
+	AppIDKey = "githubAppID"
+	// AppInstallationIDKey is the key of a secret giving the ID of the
+	// installation of the GitHub App to get tokens for.
+	AppInstallationIDKey = "githubAppInstallationID"
+	// AppPrivateKeyKey is the key of a secret giving the PEM-encoded
+	// private key of the GitHub App.
+	AppPrivateKeyKey = "githubAppPrivateKey"
+	// AppBaseURLKey is the key of a secret giving the URL of the GitHub
+	// API, for GitHub Enterprise Server. It is optional.
+	AppBaseURLKey = "githubAppBaseURL"
+
+	// DefaultBaseURL is the URL of the GitHub API.
+	DefaultBaseURL = "https://api.github.com"
+)
+
+// App is a GitHub App installation, for which installation tokens are
+// minted to authenticate to GitHub Container Registry.
+type App struct {
+	ID             int64
+	InstallationID int64
+	PrivateKey     []byte
+	BaseURL        string
+}
+
+// AppFromSecret returns the GitHub App given by the data of a secret,
+// and `true`, if the secret gives one, otherwise `false`. It returns an
+// error if the secret gives an App, but not all of its fields or not
+// valid ones.
+func AppFromSecret(data map[string][]byte) (App, bool, error) {
+	if _, ok := data[AppIDKey]; !ok {
+		return App{}, false, nil
+	}
+	for _, key := range []string{AppInstallationIDKey, AppPrivateKeyKey} {
+		if len(data[key]) == 0 {
+			return App{}, true, fmt.Errorf("secret gives a GitHub App, but not '%s'", key)
+		}
+	}
+	id, err := strconv.ParseInt(strings.TrimSpace(string(data[AppIDKey])), 10, 64)
+	if err != nil {
+		return App{}, true, fmt.Errorf("invalid GitHub App ID: %w", err)
+	}
+	installationID, err := strconv.ParseInt(strings.TrimSpace(string(data[AppInstallationIDKey])), 10, 64)
+	if err != nil {
+		return App{}, true, fmt.Errorf("invalid GitHub App installation ID: %w", err)
+	}
+	app := App{
+		ID:             id,
+		InstallationID: installationID,
+		PrivateKey:     data[AppPrivateKeyKey],
+		BaseURL:        DefaultBaseURL,
+	}
+	if u := strings.TrimSpace(string(data[AppBaseURLKey])); u != "" {
+		app.BaseURL = strings.TrimSuffix(u, "/")
+	}
+	return app, true, nil
+}
+
+// CacheKey returns a key identifying the installation and the private
+// key of the App, so that a token minted for the App is only reused for
+// a secret giving the same private key.
+func (a App) CacheKey() string {
+	sum := sha256.Sum256(a.PrivateKey)
+	return fmt.Sprintf("github/%s/%d/%d/%s", a.BaseURL, a.ID, a.InstallationID, hex.EncodeToString(sum[:]))
+}
+
+// Login mints an installation token for the App, returning the
+// authentication it gives and when it expires.
+func (a App) Login(ctx context.Context) (authn.Authenticator, time.Time, error) {
+	key, err := jwt.ParseRSAPrivateKeyFromPEM(a.PrivateKey)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("invalid GitHub App private key: %w", err)
+	}
+	// The JWT authenticating as the App is backdated, in case of clock
+	// drift, and lasts less than the greatest ten minutes GitHub allows.
+	now := time.Now()
+	appToken, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.RegisteredClaims{
+		Issuer:    strconv.FormatInt(a.ID, 10),
+		IssuedAt:  jwt.NewNumericDate(now.Add(-time.Minute)),
+		ExpiresAt: jwt.NewNumericDate(now.Add(9 * time.Minute)),
+	}).SignedString(key)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	u := fmt.Sprintf("%s/app/installations/%d/access_tokens", a.BaseURL, a.InstallationID)
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, u, nil)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	request.Header.Set("Authorization", "Bearer "+appToken)
+	request.Header.Set("Accept", "application/vnd.github+json")
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer response.Body.Close()
+	defer io.Copy(io.Discard, response.Body)
+
+	if response.StatusCode != http.StatusCreated {
+		return nil, time.Time{}, fmt.Errorf("unexpected status from GitHub minting an installation token: %s", response.Status)
+	}
+
+	var installationToken struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&installationToken); err != nil {
+		return nil, time.Time{}, err
+	}
+	return authn.FromConfig(authn.AuthConfig{
+		Username: "x-access-token",
+		Password: installationToken.Token,
+	}), installationToken.ExpiresAt, nil
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package github
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+	. "github.com/onsi/gomega"
+)
+
+func TestAppFromSecret(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    map[string][]byte
+		wantOK  bool
+		wantErr bool
+		wantApp App
+	}{
+		{
+			name: "no app",
+			data: map[string][]byte{".dockerconfigjson": []byte("{}")},
+		},
+		{
+			name: "app",
+			data: map[string][]byte{
+				AppIDKey:             []byte("123"),
+				AppInstallationIDKey: []byte("456\n"),
+				AppPrivateKeyKey:     []byte("key"),
+			},
+			wantOK:  true,
+			wantApp: App{ID: 123, InstallationID: 456, PrivateKey: []byte("key"), BaseURL: DefaultBaseURL},
+		},
+		{
+			name: "app of GitHub Enterprise Server",
+			data: map[string][]byte{
+				AppIDKey:             []byte("123"),
+				AppInstallationIDKey: []byte("456"),
+				AppPrivateKeyKey:     []byte("key"),
+				AppBaseURLKey:        []byte("https://github.example.com/api/v3/"),
+			},
+			wantOK:  true,
+			wantApp: App{ID: 123, InstallationID: 456, PrivateKey: []byte("key"), BaseURL: "https://github.example.com/api/v3"},
+		},
+		{
+			name: "no private key",
+			data: map[string][]byte{
+				AppIDKey:             []byte("123"),
+				AppInstallationIDKey: []byte("456"),
+			},
+			wantOK:  true,
+			wantErr: true,
+		},
+		{
+			name: "invalid app ID",
+			data: map[string][]byte{
+				AppIDKey:             []byte("foo"),
+				AppInstallationIDKey: []byte("456"),
+				AppPrivateKeyKey:     []byte("key"),
+			},
+			wantOK:  true,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			app, ok, err := AppFromSecret(tt.data)
+			g.Expect(err != nil).To(Equal(tt.wantErr))
+			g.Expect(ok).To(Equal(tt.wantOK))
+			if !tt.wantErr {
+				g.Expect(app).To(Equal(tt.wantApp))
+			}
+		})
+	}
+}
+
+func TestAppLogin(t *testing.T) {
+	g := NewWithT(t)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	g.Expect(err).ToNot(HaveOccurred())
+	privateKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		// The request is authenticated with a JWT issued by the App,
+		// and signed with its private key.
+		token, err := jwt.ParseWithClaims(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), &jwt.RegisteredClaims{},
+			func(*jwt.Token) (interface{}, error) { return &key.PublicKey, nil })
+		if err != nil || token.Claims.(*jwt.RegisteredClaims).Issuer != "123" || r.URL.Path != "/app/installations/456/access_tokens" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"token": "ghs_token", "expires_at": "2100-01-01T00:00:00Z"}`))
+	}
+	srv := httptest.NewServer(http.HandlerFunc(handler))
+	t.Cleanup(func() {
+		srv.Close()
+	})
+
+	app := App{ID: 123, InstallationID: 456, PrivateKey: privateKey, BaseURL: srv.URL}
+	auth, expiresAt, err := app.Login(context.TODO())
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(expiresAt.Year()).To(Equal(2100))
+	authConfig, err := auth.Authorization()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(authConfig.Password).To(Equal("ghs_token"))
+
+	app.InstallationID = 789
+	_, _, err = app.Login(context.TODO())
+	g.Expect(err).To(HaveOccurred())
+
+	// The token is not reused for the same installation with another
+	// private key.
+	other := App{ID: 123, InstallationID: 456, PrivateKey: []byte("other"), BaseURL: srv.URL}
+	g.Expect(other.CacheKey()).ToNot(Equal(App{ID: 123, InstallationID: 456, PrivateKey: privateKey, BaseURL: srv.URL}.CacheKey()))
+}
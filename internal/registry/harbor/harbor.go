@@ -0,0 +1,223 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package harbor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// RobotAccountKey is the key of a secret giving a Harbor robot account,
+// as exported to a file by Harbor.
+const RobotAccountKey = "harborRobotAccount"
+
+// artifactsPageSize is the number of artifacts asked for in each page of
+// the artifacts API, the greatest Harbor allows.
+const artifactsPageSize = 100
+
+// robotAccount is a robot account as exported by Harbor.
+type robotAccount struct {
+	Name      string `json:"name"`
+	Secret    string `json:"secret"`
+	ExpiresAt int64  `json:"expires_at"`
+	Disabled  bool   `json:"disable"`
+}
+
+// RobotFromSecret returns the authentication given by the Harbor robot
+// account in the data of a secret, and `true`, if the secret gives one,
+// otherwise `false`. It returns an error if the robot account cannot be
+// parsed, or is disabled or expired, so that this is reported rather
+// than the registry refusing access.
+func RobotFromSecret(data map[string][]byte) (authn.AuthConfig, bool, error) {
+	content, ok := data[RobotAccountKey]
+	if !ok {
+		return authn.AuthConfig{}, false, nil
+	}
+	var robot robotAccount
+	if err := json.Unmarshal(content, &robot); err != nil {
+		return authn.AuthConfig{}, true, fmt.Errorf("invalid Harbor robot account: %w", err)
+	}
+	if robot.Name == "" || robot.Secret == "" {
+		return authn.AuthConfig{}, true, fmt.Errorf("invalid Harbor robot account: the name and secret must be given")
+	}
+	if robot.Disabled {
+		return authn.AuthConfig{}, true, fmt.Errorf("Harbor robot account '%s' is disabled", robot.Name)
+	}
+	// A robot account that never expires has an expiry of -1.
+	if robot.ExpiresAt > 0 && time.Unix(robot.ExpiresAt, 0).Before(time.Now()) {
+		return authn.AuthConfig{}, true, fmt.Errorf("Harbor robot account '%s' expired at %s", robot.Name, time.Unix(robot.ExpiresAt, 0).UTC().Format(time.RFC3339))
+	}
+	return authn.AuthConfig{Username: robot.Name, Password: robot.Secret}, true, nil
+}
+
+// ArtifactLister lists the tags of a Harbor repository with the Harbor
+// artifacts API, one page at a time, in the same way as
+// registry.TagLister does with the registry API. Each page gives the
+// digests of the images the tags refer to as well, so that these need
+// not be fetched for each tag.
+type ArtifactLister struct {
+	repo    name.Repository
+	client  *http.Client
+	auth    authn.Authenticator
+	digests map[string]v1.Hash
+}
+
+// NewArtifactLister returns an ArtifactLister for the given repository,
+// which must be in a project of a Harbor registry. A nil authenticator
+// means anonymous access, and a nil transport means
+// remote.DefaultTransport.
+func NewArtifactLister(repo name.Repository, auth authn.Authenticator, tr http.RoundTripper) (*ArtifactLister, error) {
+	if !strings.Contains(repo.RepositoryStr(), "/") {
+		return nil, fmt.Errorf("Harbor repository '%s' is not in a project", repo)
+	}
+	if auth == nil {
+		auth = authn.Anonymous
+	}
+	if tr == nil {
+		tr = remote.DefaultTransport
+	}
+	return &ArtifactLister{
+		repo:    repo,
+		client:  &http.Client{Transport: transport.NewRetry(tr)},
+		auth:    auth,
+		digests: map[string]v1.Hash{},
+	}, nil
+}
+
+// FirstPage returns the cursor for the first page of artifacts.
+func (l *ArtifactLister) FirstPage() string {
+	project, repository, _ := strings.Cut(l.repo.RepositoryStr(), "/")
+	// The repository name is escaped twice, since Harbor unescapes it
+	// once before routing.
+	escaped := url.PathEscape(repository)
+	u := &url.URL{
+		Scheme:   l.repo.Registry.Scheme(),
+		Host:     l.repo.Registry.RegistryStr(),
+		Path:     fmt.Sprintf("/api/v2.0/projects/%s/repositories/%s/artifacts", project, escaped),
+		RawPath:  fmt.Sprintf("/api/v2.0/projects/%s/repositories/%s/artifacts", project, url.PathEscape(escaped)),
+		RawQuery: fmt.Sprintf("with_tag=true&page=1&page_size=%d", artifactsPageSize),
+	}
+	return u.String()
+}
+
+// ValidCursor reports whether the cursor refers to a page of artifacts
+// of the repository of this lister.
+func (l *ArtifactLister) ValidCursor(cursor string) bool {
+	u, err := url.Parse(cursor)
+	if err != nil {
+		return false
+	}
+	first, _ := url.Parse(l.FirstPage())
+	return u.Scheme == first.Scheme && u.Host == first.Host && u.EscapedPath() == first.EscapedPath()
+}
+
+// Page fetches the page of artifacts at the cursor. It returns the tags
+// of the artifacts on the page, and the cursor for the next page, which
+// is empty if this was the last page.
+func (l *ArtifactLister) Page(ctx context.Context, cursor string) ([]string, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cursor, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Accept", "application/json")
+	authConfig, err := l.auth.Authorization()
+	if err != nil {
+		return nil, "", err
+	}
+	if authConfig.Username != "" || authConfig.Password != "" {
+		req.SetBasicAuth(authConfig.Username, authConfig.Password)
+	}
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if err := transport.CheckError(resp, http.StatusOK); err != nil {
+		return nil, "", err
+	}
+
+	var artifacts []struct {
+		Digest string `json:"digest"`
+		Tags   []struct {
+			Name string `json:"name"`
+		} `json:"tags"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&artifacts); err != nil {
+		return nil, "", err
+	}
+	var tags []string
+	for _, artifact := range artifacts {
+		digest, err := v1.NewHash(artifact.Digest)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid digest of artifact: %w", err)
+		}
+		for _, tag := range artifact.Tags {
+			tags = append(tags, tag.Name)
+			l.digests[tag.Name] = digest
+		}
+	}
+
+	next, err := nextPage(resp)
+	if err != nil {
+		return nil, "", err
+	}
+	return tags, next, nil
+}
+
+// Digests returns the digests of the images the tags listed so far refer
+// to.
+func (l *ArtifactLister) Digests() map[string]v1.Hash {
+	return l.digests
+}
+
+// nextPage returns the URL of the next page given in the Link header of
+// the response, or an empty string if there is no next page. Harbor
+// gives the previous page in the header too, so the link must be looked
+// for by its relation.
+func nextPage(resp *http.Response) (string, error) {
+	for _, link := range strings.Split(resp.Header.Get("Link"), ",") {
+		link = strings.TrimSpace(link)
+		if link == "" {
+			continue
+		}
+		if !strings.HasSuffix(link, `rel="next"`) {
+			continue
+		}
+		start, end := strings.Index(link, "<"), strings.Index(link, ">")
+		if start != 0 || end == -1 {
+			return "", fmt.Errorf("failed to parse link header: %s", link)
+		}
+		linkURL, err := url.Parse(link[1:end])
+		if err != nil {
+			return "", err
+		}
+		return resp.Request.URL.ResolveReference(linkURL).String(), nil
+	}
+	return "", nil
+}
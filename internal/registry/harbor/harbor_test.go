@@ -0,0 +1,149 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package harbor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	. "github.com/onsi/gomega"
+)
+
+func TestRobotFromSecret(t *testing.T) {
+	expired := time.Now().Add(-time.Hour).Unix()
+	tests := []struct {
+		name     string
+		data     map[string][]byte
+		wantOK   bool
+		wantErr  bool
+		wantAuth authn.AuthConfig
+	}{
+		{
+			name: "no robot account",
+			data: map[string][]byte{".dockerconfigjson": []byte("{}")},
+		},
+		{
+			name:     "robot account",
+			data:     map[string][]byte{RobotAccountKey: []byte(`{"name": "robot$project+scanner", "secret": "s3cret", "expires_at": -1}`)},
+			wantOK:   true,
+			wantAuth: authn.AuthConfig{Username: "robot$project+scanner", Password: "s3cret"},
+		},
+		{
+			name:    "expired robot account",
+			data:    map[string][]byte{RobotAccountKey: []byte(fmt.Sprintf(`{"name": "robot$project+scanner", "secret": "s3cret", "expires_at": %d}`, expired))},
+			wantOK:  true,
+			wantErr: true,
+		},
+		{
+			name:    "disabled robot account",
+			data:    map[string][]byte{RobotAccountKey: []byte(`{"name": "robot$project+scanner", "secret": "s3cret", "disable": true}`)},
+			wantOK:  true,
+			wantErr: true,
+		},
+		{
+			name:    "no secret",
+			data:    map[string][]byte{RobotAccountKey: []byte(`{"name": "robot$project+scanner"}`)},
+			wantOK:  true,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			auth, ok, err := RobotFromSecret(tt.data)
+			g.Expect(err != nil).To(Equal(tt.wantErr))
+			g.Expect(ok).To(Equal(tt.wantOK))
+			g.Expect(auth).To(Equal(tt.wantAuth))
+		})
+	}
+}
+
+func TestArtifactLister(t *testing.T) {
+	g := NewWithT(t)
+
+	const pages = 3
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.EscapedPath() != "/api/v2.0/projects/project/repositories/team%252Fapp/artifacts" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if user, password, _ := r.BasicAuth(); user != "robot$project+scanner" || password != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		var links []string
+		if page > 1 {
+			links = append(links, fmt.Sprintf(`<%s?page=%d&page_size=100&with_tag=true>; rel="prev"`, r.URL.EscapedPath(), page-1))
+		}
+		if page < pages {
+			links = append(links, fmt.Sprintf(`<%s?page=%d&page_size=100&with_tag=true>; rel="next"`, r.URL.EscapedPath(), page+1))
+		}
+		w.Header().Set("Link", strings.Join(links, " , "))
+		json.NewEncoder(w).Encode([]interface{}{
+			map[string]interface{}{
+				"digest": fmt.Sprintf("sha256:%064d", page),
+				"tags":   []interface{}{map[string]string{"name": fmt.Sprintf("v%d", page)}, map[string]string{"name": fmt.Sprintf("v%d.0", page)}},
+			},
+			// An untagged artifact has no tags listed.
+			map[string]interface{}{"digest": fmt.Sprintf("sha256:%064d", page+100)},
+		})
+	}
+	srv := httptest.NewServer(http.HandlerFunc(handler))
+	t.Cleanup(srv.Close)
+
+	repo, err := name.NewRepository(strings.TrimPrefix(srv.URL, "http://")+"/project/team/app", name.Insecure)
+	g.Expect(err).ToNot(HaveOccurred())
+	auth := authn.FromConfig(authn.AuthConfig{Username: "robot$project+scanner", Password: "s3cret"})
+	l, err := NewArtifactLister(repo, auth, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	var tags []string
+	for cursor := l.FirstPage(); cursor != ""; {
+		g.Expect(l.ValidCursor(cursor)).To(BeTrue())
+		var page []string
+		page, cursor, err = l.Page(context.TODO(), cursor)
+		g.Expect(err).ToNot(HaveOccurred())
+		tags = append(tags, page...)
+	}
+	g.Expect(tags).To(Equal([]string{"v1", "v1.0", "v2", "v2.0", "v3", "v3.0"}))
+	g.Expect(l.Digests()).To(HaveLen(6))
+	g.Expect(l.Digests()["v2.0"].String()).To(Equal(fmt.Sprintf("sha256:%064d", 2)))
+
+	other, err := name.NewRepository(strings.TrimPrefix(srv.URL, "http://")+"/project/other", name.Insecure)
+	g.Expect(err).ToNot(HaveOccurred())
+	otherLister, err := NewArtifactLister(other, auth, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(otherLister.ValidCursor(l.FirstPage())).To(BeFalse())
+
+	// A repository must be in a project.
+	top, err := name.NewRepository(strings.TrimPrefix(srv.URL, "http://")+"/app", name.Insecure)
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = NewArtifactLister(top, auth, nil)
+	g.Expect(err).To(HaveOccurred())
+}
@@ -0,0 +1,224 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// ErrNotModified is returned by TagLister.Page for the first page of
+// tags, once given validators with IfChanged, when the registry says
+// the tags have not changed since the listing the validators were given
+// with.
+var ErrNotModified = errors.New("the tags have not changed since they were last listed")
+
+// Validators are those a registry gives with a listing of tags, to make
+// a conditional request for the listing with later.
+type Validators struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+// IsZero reports whether there are no validators.
+func (v Validators) IsZero() bool {
+	return v.ETag == "" && v.LastModified == ""
+}
+
+// TagLister lists the tags of an image repository one page at a time, so
+// that a listing can be stopped after any page and resumed later from the
+// cursor returned with that page.
+type TagLister struct {
+	repo   name.Repository
+	client *http.Client
+
+	// ifChanged are the validators to request the first page with.
+	ifChanged Validators
+	// validators are those given with the first page, if it was the
+	// only one.
+	validators Validators
+}
+
+// NewTagLister returns a TagLister for the given repository. A nil
+// authenticator means anonymous access, and a nil transport means
+// remote.DefaultTransport.
+func NewTagLister(ctx context.Context, repo name.Repository, auth authn.Authenticator, tr http.RoundTripper) (*TagLister, error) {
+	client, err := newClient(ctx, repo, auth, tr)
+	if err != nil {
+		return nil, err
+	}
+	return &TagLister{
+		repo:   repo,
+		client: client,
+	}, nil
+}
+
+// newClient returns an HTTP client for pulling from the repository,
+// which authenticates and retries requests. A nil authenticator means
+// anonymous access, and a nil transport means remote.DefaultTransport.
+func newClient(ctx context.Context, repo name.Repository, auth authn.Authenticator, tr http.RoundTripper) (*http.Client, error) {
+	if auth == nil {
+		auth = authn.Anonymous
+	}
+	if tr == nil {
+		tr = remote.DefaultTransport
+	}
+	tr = transport.NewRetry(tr)
+	scopes := []string{repo.Scope(transport.PullScope)}
+	t, err := transport.NewWithContext(ctx, repo.Registry, auth, tr, scopes)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: t}, nil
+}
+
+// FirstPage returns the cursor for the first page of tags.
+func (l *TagLister) FirstPage() string {
+	u := &url.URL{
+		Scheme: l.repo.Registry.Scheme(),
+		Host:   l.repo.Registry.RegistryStr(),
+		Path:   fmt.Sprintf("/v2/%s/tags/list", l.repo.RepositoryStr()),
+	}
+	return u.String()
+}
+
+// PageSize is the number of tags asked for with each page of the tags
+// listed after a tag; registries may give fewer.
+const PageSize = 1000
+
+// PageAfter returns the cursor for the first page of the tags after the
+// given tag, in the lexical order registries list tags in. A registry
+// ignoring the `last` parameter gives the first page of all the tags.
+func (l *TagLister) PageAfter(tag string) string {
+	u, _ := url.Parse(l.FirstPage())
+	u.RawQuery = url.Values{
+		"n":    {strconv.Itoa(PageSize)},
+		"last": {tag},
+	}.Encode()
+	return u.String()
+}
+
+// ValidCursor reports whether the cursor refers to a page of tags for the
+// repository of this lister. A cursor saved for a different repository
+// (e.g., because `.spec.image` changed) is not valid.
+func (l *TagLister) ValidCursor(cursor string) bool {
+	u, err := url.Parse(cursor)
+	if err != nil {
+		return false
+	}
+	first, _ := url.Parse(l.FirstPage())
+	return u.Scheme == first.Scheme && u.Host == first.Host && u.Path == first.Path
+}
+
+// IfChanged has the first page of tags requested only if the tags have
+// changed since the listing the validators were given with, otherwise
+// Page returns ErrNotModified.
+func (l *TagLister) IfChanged(v Validators) {
+	l.ifChanged = v
+}
+
+// Validators returns the validators the registry gave with the first
+// page of tags, if it was the only page, since the validators of one
+// page say nothing of the others.
+func (l *TagLister) Validators() Validators {
+	return l.validators
+}
+
+// Page fetches the page of tags at the cursor. It returns the tags on the
+// page, and the cursor for the next page, which is empty if this was the
+// last page.
+func (l *TagLister) Page(ctx context.Context, cursor string) ([]string, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cursor, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	first := cursor == l.FirstPage()
+	if first {
+		if l.ifChanged.ETag != "" {
+			req.Header.Set("If-None-Match", l.ifChanged.ETag)
+		}
+		if l.ifChanged.LastModified != "" {
+			req.Header.Set("If-Modified-Since", l.ifChanged.LastModified)
+		}
+	}
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if first && !l.ifChanged.IsZero() && resp.StatusCode == http.StatusNotModified {
+		return nil, "", ErrNotModified
+	}
+	if err := transport.CheckError(resp, http.StatusOK); err != nil {
+		return nil, "", err
+	}
+
+	var parsed struct {
+		Tags []string `json:"tags"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return nil, "", err
+	}
+
+	next, err := nextPage(resp)
+	if err != nil {
+		return nil, "", err
+	}
+	if first {
+		l.validators = Validators{}
+		if next == "" {
+			l.validators = Validators{
+				ETag:         resp.Header.Get("ETag"),
+				LastModified: resp.Header.Get("Last-Modified"),
+			}
+		}
+	}
+	return parsed.Tags, next, nil
+}
+
+// nextPage returns the URL of the next page given in the Link header of the
+// response, or an empty string if there is no next page.
+func nextPage(resp *http.Response) (string, error) {
+	link := resp.Header.Get("Link")
+	if link == "" {
+		return "", nil
+	}
+	if link[0] != '<' {
+		return "", fmt.Errorf("failed to parse link header: missing '<' in: %s", link)
+	}
+	end := strings.Index(link, ">")
+	if end == -1 {
+		return "", fmt.Errorf("failed to parse link header: missing '>' in: %s", link)
+	}
+	linkURL, err := url.Parse(link[1:end])
+	if err != nil {
+		return "", err
+	}
+	return resp.Request.URL.ResolveReference(linkURL).String(), nil
+}
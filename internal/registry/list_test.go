@@ -0,0 +1,220 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	. "github.com/onsi/gomega"
+)
+
+// pagingHandler serves the tags of a single repository, pageSize tags at a
+// time, linking each page to the next as registries do. If etag is set,
+// it is given with each page, and a request for the first page with it
+// is answered with 304 Not Modified.
+type pagingHandler struct {
+	repo     string
+	tags     []string
+	pageSize int
+	etag     string
+}
+
+func (h *pagingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/v2/" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.URL.Path != fmt.Sprintf("/v2/%s/tags/list", h.repo) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if h.etag != "" {
+		if r.URL.RawQuery == "" && r.Header.Get("If-None-Match") == h.etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", h.etag)
+	}
+
+	start := 0
+	if last := r.URL.Query().Get("last"); last != "" {
+		for i, t := range h.tags {
+			if t == last {
+				start = i + 1
+			}
+		}
+	}
+	end := start + h.pageSize
+	if end >= len(h.tags) {
+		end = len(h.tags)
+	} else {
+		w.Header().Set("Link", fmt.Sprintf(`</v2/%s/tags/list?last=%s>; rel="next"`, h.repo, h.tags[end-1]))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"name": h.repo,
+		"tags": h.tags[start:end],
+	})
+}
+
+func newTestLister(t *testing.T, pageSize int, tags ...string) *TagLister {
+	t.Helper()
+	return newTestListerFor(t, &pagingHandler{repo: "foo/bar", tags: tags, pageSize: pageSize})
+}
+
+func newTestListerFor(t *testing.T, h *pagingHandler) *TagLister {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+
+	repo, err := name.NewRepository(strings.TrimPrefix(srv.URL, "http://") + "/foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := NewTagLister(context.TODO(), repo, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestTagLister_Page(t *testing.T) {
+	g := NewWithT(t)
+	l := newTestLister(t, 2, "v1", "v2", "v3", "v4", "v5")
+
+	var pages [][]string
+	cursor := l.FirstPage()
+	for cursor != "" {
+		tags, next, err := l.Page(context.TODO(), cursor)
+		g.Expect(err).ToNot(HaveOccurred())
+		pages = append(pages, tags)
+		cursor = next
+	}
+	g.Expect(pages).To(Equal([][]string{{"v1", "v2"}, {"v3", "v4"}, {"v5"}}))
+}
+
+func TestTagLister_PageResume(t *testing.T) {
+	g := NewWithT(t)
+	l := newTestLister(t, 2, "v1", "v2", "v3", "v4", "v5")
+
+	_, cursor, err := l.Page(context.TODO(), l.FirstPage())
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(l.ValidCursor(cursor)).To(BeTrue())
+
+	tags, _, err := l.Page(context.TODO(), cursor)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(tags).To(Equal([]string{"v3", "v4"}))
+}
+
+func TestTagLister_PageAfter(t *testing.T) {
+	g := NewWithT(t)
+	l := newTestLister(t, 2, "v1", "v2", "v3", "v4", "v5")
+
+	cursor := l.PageAfter("v2")
+	g.Expect(l.ValidCursor(cursor)).To(BeTrue())
+	g.Expect(cursor).To(ContainSubstring(fmt.Sprintf("n=%d", PageSize)))
+
+	tags, next, err := l.Page(context.TODO(), cursor)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(tags).To(Equal([]string{"v3", "v4"}))
+	tags, _, err = l.Page(context.TODO(), next)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(tags).To(Equal([]string{"v5"}))
+}
+
+func TestTagLister_IfChanged(t *testing.T) {
+	g := NewWithT(t)
+	l := newTestListerFor(t, &pagingHandler{repo: "foo/bar", tags: []string{"v1", "v2"}, pageSize: 2, etag: `"abc"`})
+
+	tags, next, err := l.Page(context.TODO(), l.FirstPage())
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(tags).To(Equal([]string{"v1", "v2"}))
+	g.Expect(next).To(BeEmpty())
+	g.Expect(l.Validators()).To(Equal(Validators{ETag: `"abc"`}))
+
+	l.IfChanged(l.Validators())
+	_, _, err = l.Page(context.TODO(), l.FirstPage())
+	g.Expect(err).To(MatchError(ErrNotModified))
+
+	// The tags are listed again once they have changed.
+	l.IfChanged(Validators{ETag: `"def"`})
+	tags, _, err = l.Page(context.TODO(), l.FirstPage())
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(tags).To(Equal([]string{"v1", "v2"}))
+}
+
+func TestTagLister_ValidatorsOfManyPages(t *testing.T) {
+	g := NewWithT(t)
+	l := newTestListerFor(t, &pagingHandler{repo: "foo/bar", tags: []string{"v1", "v2", "v3"}, pageSize: 2, etag: `"abc"`})
+
+	_, next, err := l.Page(context.TODO(), l.FirstPage())
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(next).ToNot(BeEmpty())
+	g.Expect(l.Validators().IsZero()).To(BeTrue())
+}
+
+func TestTagLister_ValidCursor(t *testing.T) {
+	l := newTestLister(t, 2)
+	first := l.FirstPage()
+
+	tests := []struct {
+		name   string
+		cursor string
+		want   bool
+	}{
+		{
+			name:   "first page",
+			cursor: first,
+			want:   true,
+		},
+		{
+			name:   "later page",
+			cursor: first + "?last=v2",
+			want:   true,
+		},
+		{
+			name:   "other repository",
+			cursor: strings.Replace(first, "foo/bar", "foo/baz", 1),
+			want:   false,
+		},
+		{
+			name:   "other registry",
+			cursor: "https://registry.example.com/v2/foo/bar/tags/list",
+			want:   false,
+		},
+		{
+			name:   "not a URL",
+			cursor: "::",
+			want:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(l.ValidCursor(tt.cursor)).To(Equal(tt.want))
+		})
+	}
+}
@@ -18,21 +18,54 @@ package login
 
 import (
 	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 
 	"github.com/fluxcd/image-reflector-controller/internal/registry"
 	"github.com/fluxcd/image-reflector-controller/internal/registry/aws"
 	"github.com/fluxcd/image-reflector-controller/internal/registry/azure"
+	"github.com/fluxcd/image-reflector-controller/internal/registry/credhelper"
+	"github.com/fluxcd/image-reflector-controller/internal/registry/digitalocean"
 	"github.com/fluxcd/image-reflector-controller/internal/registry/gcp"
+	"github.com/fluxcd/image-reflector-controller/internal/registry/github"
+	"github.com/fluxcd/image-reflector-controller/internal/registry/mirror"
+	"github.com/fluxcd/image-reflector-controller/internal/registry/oracle"
+	"github.com/fluxcd/image-reflector-controller/internal/registry/quay"
+	"github.com/fluxcd/image-reflector-controller/internal/registry/throttle"
 )
 
+// providerNames are the names by which providers can be given to log in
+// to registries at domains of their own, e.g., custom domains fronting
+// the registries of cloud providers. The generic provider has no login
+// attempted.
+var providerNames = map[string]registry.Provider{
+	"aws":     registry.ProviderAWS,
+	"gcp":     registry.ProviderGCR,
+	"azure":   registry.ProviderAzure,
+	"generic": registry.ProviderGeneric,
+}
+
+// IsProviderName reports whether a provider can be given by the name,
+// as .spec.provider.name of an image repository.
+func IsProviderName(name string) bool {
+	_, ok := providerNames[name]
+	return ok
+}
+
 // ImageRegistryProvider analyzes the provided image and returns the identified
 // container image registry provider.
 func ImageRegistryProvider(image string, ref name.Reference) registry.Provider {
 	_, _, ok := aws.ParseImage(image)
-	if ok {
+	if ok || aws.IsPublicImage(image) {
 		return registry.ProviderAWS
 	}
 	if gcp.ValidHost(ref.Context().RegistryStr()) {
@@ -41,6 +74,9 @@ func ImageRegistryProvider(image string, ref name.Reference) registry.Provider {
 	if azure.ValidHost(ref.Context().RegistryStr()) {
 		return registry.ProviderAzure
 	}
+	if oracle.ValidHost(ref.Context().RegistryStr()) {
+		return registry.ProviderOracle
+	}
 	return registry.ProviderGeneric
 }
 
@@ -55,22 +91,137 @@ type ProviderOptions struct {
 	// AzureAutoLogin enables automatic attempt to get credentials for images in
 	// ACR.
 	AzureAutoLogin bool
+	// OciAutoLogin enables automatic attempt to get credentials for images in
+	// OCIR.
+	OciAutoLogin bool
+	// AwsRoleARN is the ARN of an IAM role to assume to get credentials for
+	// images in ECR, if any.
+	AwsRoleARN string
+	// AwsRegion is the region to get credentials in for a registry
+	// given as ECR whose host does not give the region, if any.
+	AwsRegion string
+	// AwsEndpoint is the URL of the ECR API to use in place of the default,
+	// if any.
+	AwsEndpoint string
+	// AwsUseFIPSEndpoint has the FIPS endpoints of the ECR API used.
+	AwsUseFIPSEndpoint bool
+	// GcpServiceAccount is the email of a Google service account to
+	// impersonate to get credentials for images in GCP, if any.
+	GcpServiceAccount string
+	// AzureTenantID is the ID of the Azure tenant to authenticate in to get
+	// credentials for images in ACR, if any.
+	AzureTenantID string
+	// AzureClientID is the client ID of a user-assigned managed identity to
+	// authenticate as to get credentials for images in ACR, if any.
+	AzureClientID string
+	// Provider is the name of the provider to log in to the registry
+	// of, one of those of providerNames, in place of that detected from
+	// the host of the image, if any.
+	Provider string
+	// CredentialHelpersDir is the directory of the docker credential
+	// helpers that can be used, if any.
+	CredentialHelpersDir string
+	// CredentialHelper is the name of a docker credential helper in
+	// CredentialHelpersDir to get credentials from, for any registry, if
+	// any.
+	CredentialHelper string
+	// DockerConfigFile is the path of a docker config file, e.g., one
+	// holding the credentials of the node, to get credentials from for
+	// a registry no others are given for, if any.
+	DockerConfigFile string
+	// Mirrors are the mirrors to use in place of the registries they
+	// mirror, if any.
+	Mirrors []mirror.Mirror
+	// ServiceAccounts is the client to request the tokens of Kubernetes
+	// service accounts with, to exchange for the authentication for
+	// registries trusting the cluster, if any.
+	ServiceAccounts typedcorev1.ServiceAccountsGetter
+	// RequestLimits caps the rate of requests to each registry host,
+	// across all image repositories and policies, if given.
+	RequestLimits *throttle.Limits
+}
+
+// The exchanges of the token of a Kubernetes service account for the
+// authentication for a registry.
+const (
+	// ExchangeGCP exchanges the token with the Google Security Token
+	// Service, with workload identity federation.
+	ExchangeGCP = "GCP"
+	// ExchangePassword sends the token as the password of a user.
+	ExchangePassword = "Password"
+)
+
+// serviceAccountTokenExpiry is how long the tokens requested for
+// service accounts are valid for.
+const serviceAccountTokenExpiry = time.Hour
+
+// ServiceAccountToken configures the exchange of a token of a Kubernetes
+// service account for the authentication for a registry.
+type ServiceAccountToken struct {
+	// Namespace and Name are those of the service account.
+	Namespace string
+	Name      string
+	// Audience is the audience of the token requested.
+	Audience string
+	// Exchange is how the token is exchanged, ExchangeGCP or
+	// ExchangePassword.
+	Exchange string
+	// WorkloadIdentityProvider is the full resource name of the provider
+	// of the workload identity pool to exchange the token with, for
+	// ExchangeGCP.
+	WorkloadIdentityProvider string
+	// GCPServiceAccount is the email of a Google service account to
+	// impersonate with the federated token, for ExchangeGCP, if any.
+	GCPServiceAccount string
+	// Username is the user the token is the password of, for
+	// ExchangePassword.
+	Username string
+}
+
+// cacheKey returns the key of the authentication got with the token.
+func (t ServiceAccountToken) cacheKey() string {
+	return strings.Join([]string{"serviceaccount", t.Namespace, t.Name, t.Audience, t.Exchange,
+		t.WorkloadIdentityProvider, t.GCPServiceAccount, t.Username}, "/")
+}
+
+// tokenExpiryMargin is how long before it expires a cached
+// authentication stops being used, so that it does not expire while
+// in use.
+const tokenExpiryMargin = 5 * time.Minute
+
+// cachedToken is the authentication obtained by logging in to a
+// registry, when it was obtained, and when it expires.
+type cachedToken struct {
+	auth       authn.Authenticator
+	obtainedAt time.Time
+	expiresAt  time.Time
 }
 
-// Manager is a login manager for various registry providers.
+// Manager is a login manager for various registry providers. It caches
+// the authentication obtained by logging in, for as long as it is valid,
+// so that a Manager kept for the life of the controller logs in to each
+// registry only when the authentication for it is about to expire.
 type Manager struct {
-	ecr *aws.Client
-	gcr *gcp.Client
-	acr *azure.Client
+	ecr  *aws.Client
+	gcr  *gcp.Client
+	acr  *azure.Client
+	ocir *oracle.Client
+
+	mu     sync.Mutex
+	tokens map[string]cachedToken
+	now    func() time.Time
 }
 
 // NewManager initializes a Manager with default registry clients
 // configurations.
 func NewManager() *Manager {
 	return &Manager{
-		ecr: aws.NewClient(),
-		gcr: gcp.NewClient(),
-		acr: azure.NewClient(),
+		ecr:    aws.NewClient(),
+		gcr:    gcp.NewClient(),
+		acr:    azure.NewClient(),
+		ocir:   oracle.NewClient(),
+		tokens: map[string]cachedToken{},
+		now:    time.Now,
 	}
 }
 
@@ -92,16 +243,231 @@ func (m *Manager) WithACRClient(c *azure.Client) *Manager {
 	return m
 }
 
+// WithOCIRClient allows overriding the default OCIR client.
+func (m *Manager) WithOCIRClient(c *oracle.Client) *Manager {
+	m.ocir = c
+	return m
+}
+
 // Login performs authentication against a registry and returns the
 // authentication material. For generic registry provider, it is no-op.
+// The authentication is reused for the registry and the identity logged
+// in as, or for the account and region, and the role assumed, for ECR,
+// until shortly before it expires. If a provider is given, it is logged
+// in to whichever the host of the registry. If a credential helper is
+// given, it gives the authentication instead, for any registry.
 func (m *Manager) Login(ctx context.Context, image string, ref name.Reference, opts ProviderOptions) (authn.Authenticator, error) {
-	switch ImageRegistryProvider(image, ref) {
+	// A credential helper is run for each login, since it does not say
+	// when the credentials it gives expire.
+	if opts.CredentialHelper != "" {
+		helper, err := credhelper.Find(opts.CredentialHelpersDir, opts.CredentialHelper)
+		if err != nil {
+			return nil, err
+		}
+		return helper.Login(ctx, ref.Context())
+	}
+
+	var key string
+	var login func() (authn.Authenticator, time.Time, error)
+	provider := ImageRegistryProvider(image, ref)
+	if opts.Provider != "" {
+		p, ok := providerNames[opts.Provider]
+		if !ok {
+			return nil, fmt.Errorf("unknown registry provider '%s'", opts.Provider)
+		}
+		provider = p
+	}
+	switch provider {
 	case registry.ProviderAWS:
-		return m.ecr.Login(ctx, opts.AwsAutoLogin, image)
+		accountID, region, ok := aws.ParseImage(image)
+		if !ok {
+			region = opts.AwsRegion
+		}
+		key = "ecr/" + accountID + "/" + region + "/" + opts.AwsRoleARN
+		if aws.IsPublicImage(image) {
+			key = "ecr-public/" + opts.AwsRoleARN
+		}
+		login = func() (authn.Authenticator, time.Time, error) {
+			return m.ecr.Login(ctx, opts.AwsAutoLogin, image, aws.LoginOptions{
+				RoleARN:         opts.AwsRoleARN,
+				Endpoint:        opts.AwsEndpoint,
+				UseFIPSEndpoint: opts.AwsUseFIPSEndpoint,
+				Region:          opts.AwsRegion,
+			})
+		}
 	case registry.ProviderGCR:
-		return m.gcr.Login(ctx, opts.GcpAutoLogin, image, ref)
+		// The token is that of the service account of the pod, or of
+		// the one it impersonates, whichever the registry.
+		key = "gcr/" + opts.GcpServiceAccount
+		login = func() (authn.Authenticator, time.Time, error) {
+			return m.gcr.Login(ctx, opts.GcpAutoLogin, image, ref, gcp.LoginOptions{
+				ServiceAccount: opts.GcpServiceAccount,
+			})
+		}
 	case registry.ProviderAzure:
-		return m.acr.Login(ctx, opts.AzureAutoLogin, image, ref)
+		key = "acr/" + ref.Context().RegistryStr() + "/" + opts.AzureTenantID + "/" + opts.AzureClientID
+		login = func() (authn.Authenticator, time.Time, error) {
+			return m.acr.Login(ctx, opts.AzureAutoLogin, image, ref, azure.LoginOptions{
+				TenantID: opts.AzureTenantID,
+				ClientID: opts.AzureClientID,
+			})
+		}
+	case registry.ProviderOracle:
+		key = "ocir/" + ref.Context().RegistryStr()
+		login = func() (authn.Authenticator, time.Time, error) {
+			return m.ocir.Login(ctx, opts.OciAutoLogin, image, ref)
+		}
+	default:
+		return nil, nil
+	}
+
+	return m.cachedLogin(ctx, key, login)
+}
+
+// GitHubAppLogin mints an installation token for the GitHub App, to
+// authenticate to GitHub Container Registry. The token is reused, for
+// the same installation and private key, until shortly before it
+// expires.
+func (m *Manager) GitHubAppLogin(ctx context.Context, app github.App) (authn.Authenticator, error) {
+	return m.cachedLogin(ctx, app.CacheKey(), func() (authn.Authenticator, time.Time, error) {
+		return app.Login(ctx)
+	})
+}
+
+// DigitalOceanLogin gets docker credentials for DigitalOcean Container
+// Registry with the API token. The credentials are reused, for the same
+// token, until shortly before they expire.
+func (m *Manager) DigitalOceanLogin(ctx context.Context, creds digitalocean.Credentials) (authn.Authenticator, error) {
+	return m.cachedLogin(ctx, creds.CacheKey(), func() (authn.Authenticator, time.Time, error) {
+		return creds.Login(ctx)
+	})
+}
+
+// QuayLogin trades the Quay credentials for a token to pull from the
+// repository. The token is reused, for the same repository and
+// credentials, until shortly before it expires, and then refreshed.
+func (m *Manager) QuayLogin(ctx context.Context, creds quay.Credentials, repo name.Repository, tr http.RoundTripper) (authn.Authenticator, error) {
+	return m.cachedLogin(ctx, creds.CacheKey(repo), func() (authn.Authenticator, time.Time, error) {
+		return creds.Login(ctx, repo, tr)
+	})
+}
+
+// ServiceAccountTokenLogin requests a token for the Kubernetes service
+// account, and exchanges it for the authentication for a registry
+// trusting the cluster as an OIDC issuer. The authentication is reused,
+// for the same service account and exchange, until shortly before it,
+// or the token, expires.
+func (m *Manager) ServiceAccountTokenLogin(ctx context.Context, serviceAccounts typedcorev1.ServiceAccountsGetter, sat ServiceAccountToken) (authn.Authenticator, error) {
+	if serviceAccounts == nil {
+		return nil, fmt.Errorf("unable to request a token for service account '%s/%s': no client to request it with", sat.Namespace, sat.Name)
+	}
+	return m.cachedLogin(ctx, sat.cacheKey(), func() (authn.Authenticator, time.Time, error) {
+		expirationSeconds := int64(serviceAccountTokenExpiry.Seconds())
+		tokenRequest, err := serviceAccounts.ServiceAccounts(sat.Namespace).CreateToken(ctx, sat.Name, &authenticationv1.TokenRequest{
+			Spec: authenticationv1.TokenRequestSpec{
+				Audiences:         []string{sat.Audience},
+				ExpirationSeconds: &expirationSeconds,
+			},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("unable to request a token for service account '%s/%s': %w", sat.Namespace, sat.Name, err)
+		}
+		token, tokenExpiresAt := tokenRequest.Status.Token, tokenRequest.Status.ExpirationTimestamp.Time
+
+		switch sat.Exchange {
+		case ExchangeGCP:
+			if sat.WorkloadIdentityProvider == "" {
+				return nil, time.Time{}, fmt.Errorf("no workload identity provider to exchange the token of service account '%s/%s' with", sat.Namespace, sat.Name)
+			}
+			auth, expiresAt, err := m.gcr.FederatedLogin(ctx, token, sat.WorkloadIdentityProvider, sat.GCPServiceAccount)
+			if err != nil {
+				return nil, time.Time{}, err
+			}
+			if tokenExpiresAt.Before(expiresAt) {
+				expiresAt = tokenExpiresAt
+			}
+			return auth, expiresAt, nil
+		case ExchangePassword:
+			if sat.Username == "" {
+				return nil, time.Time{}, fmt.Errorf("no username to send the token of service account '%s/%s' as the password of", sat.Namespace, sat.Name)
+			}
+			return authn.FromConfig(authn.AuthConfig{
+				Username: sat.Username,
+				Password: token,
+			}), tokenExpiresAt, nil
+		default:
+			return nil, time.Time{}, fmt.Errorf("unknown exchange '%s' of the token of a service account", sat.Exchange)
+		}
+	})
+}
+
+// validForKey is the key of the context value giving how long the
+// authentication got by a login must stay valid for.
+type validForKey struct{}
+
+// WithValidFor returns a context for logins whose authentication is to
+// stay valid for the duration given, e.g., the timeout of the scan it is
+// for. A cached authentication expiring sooner is not reused, unless
+// that is more than half of its lifetime.
+func WithValidFor(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, validForKey{}, d)
+}
+
+// cachedLogin returns the authentication cached by the key, if it does
+// not expire soon, and otherwise that returned by login, caching it if
+// it gives when it expires. The authentication cached is not reused if
+// it expires within tokenExpiryMargin, or within the duration given by
+// WithValidFor, up to half of its lifetime.
+func (m *Manager) cachedLogin(ctx context.Context, key string, login func() (authn.Authenticator, time.Time, error)) (authn.Authenticator, error) {
+	m.mu.Lock()
+	token, ok := m.tokens[key]
+	m.mu.Unlock()
+	if ok {
+		margin := tokenExpiryMargin
+		if validFor, _ := ctx.Value(validForKey{}).(time.Duration); validFor > margin {
+			margin = validFor
+			if half := token.expiresAt.Sub(token.obtainedAt) / 2; margin > half {
+				margin = half
+			}
+			if margin < tokenExpiryMargin {
+				margin = tokenExpiryMargin
+			}
+		}
+		if m.now().Add(margin).Before(token.expiresAt) {
+			return token.auth, nil
+		}
+	}
+
+	obtainedAt := m.now()
+	auth, expiresAt, err := login()
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	if expiresAt.IsZero() {
+		delete(m.tokens, key)
+	} else {
+		m.tokens[key] = cachedToken{auth: auth, obtainedAt: obtainedAt, expiresAt: expiresAt}
+	}
+	m.mu.Unlock()
+	return auth, nil
+}
+
+// Forget drops the authentication from the cache, e.g., once a registry
+// has refused it, so that the next login gets it afresh. It reports
+// whether the authentication was cached.
+func (m *Manager) Forget(auth authn.Authenticator) bool {
+	if auth == nil {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var forgotten bool
+	for key, token := range m.tokens {
+		if token.auth == auth {
+			delete(m.tokens, key)
+			forgotten = true
+		}
 	}
-	return nil, nil
+	return forgotten
 }
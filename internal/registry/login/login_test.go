@@ -21,10 +21,17 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
 	. "github.com/onsi/gomega"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 
 	"github.com/fluxcd/image-reflector-controller/internal/registry"
 	"github.com/fluxcd/image-reflector-controller/internal/registry/aws"
@@ -39,8 +46,10 @@ func TestImageRegistryProvider(t *testing.T) {
 		want  registry.Provider
 	}{
 		{"ecr", "012345678901.dkr.ecr.us-east-1.amazonaws.com/foo:v1", registry.ProviderAWS},
+		{"ecr public", "public.ecr.aws/foo/bar:v1", registry.ProviderAWS},
 		{"gcr", "gcr.io/foo/bar:v1", registry.ProviderGCR},
 		{"acr", "foo.azurecr.io/bar:v1", registry.ProviderAzure},
+		{"ocir", "iad.ocir.io/tenancy/bar:v1", registry.ProviderOracle},
 		{"docker.io", "foo/bar:v1", registry.ProviderGeneric},
 	}
 
@@ -114,6 +123,53 @@ func TestLogin(t *testing.T) {
 				*image = "foo/bar:v1"
 			},
 		},
+		{
+			name:         "ecr at a custom domain",
+			responseBody: `{"authorizationData": [{"authorizationToken": "c29tZS1rZXk6c29tZS1zZWNyZXQ="}]}`,
+			providerOpts: ProviderOptions{AwsAutoLogin: true, Provider: "aws", AwsRegion: "us-east-1"},
+			beforeFunc: func(serverURL string, mgr *Manager, image *string) {
+				ecrClient := aws.NewClient()
+				ecrClient.Config = ecrClient.WithEndpoint(serverURL).
+					WithCredentials(credentials.NewStaticCredentials("x", "y", "z"))
+				mgr.WithECRClient(ecrClient)
+
+				*image = "registry.example.com/foo:v1"
+			},
+		},
+		{
+			name:         "gcr at a custom domain",
+			responseBody: `{"access_token": "some-token","expires_in": 10, "token_type": "foo"}`,
+			providerOpts: ProviderOptions{GcpAutoLogin: true, Provider: "gcp"},
+			beforeFunc: func(serverURL string, mgr *Manager, image *string) {
+				mgr.WithGCRClient(gcp.NewClient().WithTokenURL(serverURL))
+
+				*image = "registry.example.com/foo:v1"
+			},
+		},
+		{
+			name:         "custom domain without the autologin flag",
+			providerOpts: ProviderOptions{Provider: "gcp"},
+			beforeFunc: func(serverURL string, mgr *Manager, image *string) {
+				*image = "registry.example.com/foo:v1"
+			},
+			wantErr: true,
+		},
+		{
+			// Without the flag, a login to GCR would fail.
+			name:         "generic in place of gcr",
+			providerOpts: ProviderOptions{Provider: "generic"},
+			beforeFunc: func(serverURL string, mgr *Manager, image *string) {
+				*image = "gcr.io/foo/bar:v1"
+			},
+		},
+		{
+			name:         "unknown provider",
+			providerOpts: ProviderOptions{Provider: "foo"},
+			beforeFunc: func(serverURL string, mgr *Manager, image *string) {
+				*image = "registry.example.com/foo:v1"
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -145,3 +201,160 @@ func TestLogin(t *testing.T) {
 		})
 	}
 }
+
+func TestLoginCachesToken(t *testing.T) {
+	g := NewWithT(t)
+
+	var requests int
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"access_token": "some-token","expires_in": 3600, "token_type": "foo"}`))
+	}
+	srv := httptest.NewServer(http.HandlerFunc(handler))
+	t.Cleanup(func() {
+		srv.Close()
+	})
+
+	now := time.Now()
+	mgr := NewManager().WithGCRClient(gcp.NewClient().WithTokenURL(srv.URL))
+	mgr.now = func() time.Time { return now }
+	opts := ProviderOptions{GcpAutoLogin: true}
+
+	for _, image := range []string{"gcr.io/foo/bar:v1", "gcr.io/foo/baz:v1", "europe-docker.pkg.dev/foo/bar:v1"} {
+		ref, err := name.ParseReference(image)
+		g.Expect(err).ToNot(HaveOccurred())
+		_, err = mgr.Login(context.TODO(), image, ref, opts)
+		g.Expect(err).ToNot(HaveOccurred())
+	}
+	g.Expect(requests).To(Equal(1))
+
+	// Shortly before the token expires, a new one is obtained.
+	now = now.Add(time.Hour - tokenExpiryMargin + time.Second)
+	ref, err := name.ParseReference("gcr.io/foo/bar:v1")
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = mgr.Login(context.TODO(), "gcr.io/foo/bar:v1", ref, opts)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(requests).To(Equal(2))
+}
+
+func TestServiceAccountTokenLogin(t *testing.T) {
+	g := NewWithT(t)
+
+	// The token requested for the service account is its name and the
+	// audience, so that what is exchanged can be checked.
+	var tokenRequests int
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("create", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		create := action.(k8stesting.CreateAction)
+		if create.GetSubresource() != "token" {
+			return false, nil, nil
+		}
+		tokenRequests++
+		tr := create.GetObject().(*authenticationv1.TokenRequest)
+		tr.Status = authenticationv1.TokenRequestStatus{
+			Token:               create.GetNamespace() + "/" + action.(k8stesting.CreateActionImpl).Name + "@" + tr.Spec.Audiences[0],
+			ExpirationTimestamp: metav1.NewTime(time.Now().Add(time.Hour)),
+		}
+		return true, tr, nil
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sts", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("subject_token") != "tenant/scanner@gcp-cluster" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"access_token": "federated-token", "expires_in": 3600, "token_type": "Bearer"}`))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		srv.Close()
+	})
+
+	mgr := NewManager().WithGCRClient(gcp.NewClient().WithSTSURL(srv.URL + "/sts"))
+
+	// The token is sent as the password.
+	auth, err := mgr.ServiceAccountTokenLogin(context.TODO(), clientset.CoreV1(), ServiceAccountToken{
+		Namespace: "tenant",
+		Name:      "scanner",
+		Audience:  "harbor",
+		Exchange:  ExchangePassword,
+		Username:  "scanner",
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+	authConfig, err := auth.Authorization()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(authConfig.Username).To(Equal("scanner"))
+	g.Expect(authConfig.Password).To(Equal("tenant/scanner@harbor"))
+
+	// The token is exchanged with the security token service, and the
+	// federated token reused.
+	gcpToken := ServiceAccountToken{
+		Namespace:                "tenant",
+		Name:                     "scanner",
+		Audience:                 "gcp-cluster",
+		Exchange:                 ExchangeGCP,
+		WorkloadIdentityProvider: "//iam.googleapis.com/projects/123/locations/global/workloadIdentityPools/pool/providers/cluster",
+	}
+	for i := 0; i < 2; i++ {
+		auth, err = mgr.ServiceAccountTokenLogin(context.TODO(), clientset.CoreV1(), gcpToken)
+		g.Expect(err).ToNot(HaveOccurred())
+		authConfig, err = auth.Authorization()
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(authConfig.Password).To(Equal("federated-token"))
+	}
+	g.Expect(tokenRequests).To(Equal(2))
+
+	// The exchange must be configured completely.
+	gcpToken.WorkloadIdentityProvider = ""
+	_, err = mgr.ServiceAccountTokenLogin(context.TODO(), clientset.CoreV1(), gcpToken)
+	g.Expect(err).To(HaveOccurred())
+	_, err = mgr.ServiceAccountTokenLogin(context.TODO(), nil, gcpToken)
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestLoginValidFor(t *testing.T) {
+	g := NewWithT(t)
+
+	now := time.Now()
+	mgr := NewManager()
+	mgr.now = func() time.Time { return now }
+
+	// Each login gives a token lasting an hour.
+	var logins int
+	login := func() (authn.Authenticator, time.Time, error) {
+		logins++
+		return authn.FromConfig(authn.AuthConfig{Username: "user", Password: "token"}), now.Add(time.Hour), nil
+	}
+
+	auth, err := mgr.cachedLogin(context.TODO(), "key", login)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(logins).To(Equal(1))
+
+	// A token that lasts a scan of 20 minutes is reused, but not for a
+	// scan that would outlast it.
+	now = now.Add(35 * time.Minute)
+	_, err = mgr.cachedLogin(WithValidFor(context.TODO(), 20*time.Minute), "key", login)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(logins).To(Equal(1))
+	_, err = mgr.cachedLogin(WithValidFor(context.TODO(), 30*time.Minute), "key", login)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(logins).To(Equal(2))
+
+	// A scan longer than the lifetime of the token still reuses it for
+	// half of its lifetime.
+	now = now.Add(20 * time.Minute)
+	_, err = mgr.cachedLogin(WithValidFor(context.TODO(), 2*time.Hour), "key", login)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(logins).To(Equal(2))
+
+	// A token that is forgotten is got afresh.
+	g.Expect(mgr.Forget(auth)).To(BeFalse())
+	auth, err = mgr.cachedLogin(context.TODO(), "key", login)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(mgr.Forget(auth)).To(BeTrue())
+	_, err = mgr.cachedLogin(context.TODO(), "key", login)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(logins).To(Equal(3))
+}
@@ -0,0 +1,223 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// CreatedAnnotation is the OCI annotation giving the time an image was
+// created.
+const CreatedAnnotation = "org.opencontainers.image.created"
+
+// ImageCreated returns the time the image at the reference was created.
+// This is taken from the `org.opencontainers.image.created` annotation
+// of the manifest if there is one, and otherwise from the image config.
+// For an image index without the annotation, the first image in the
+// index is used.
+func ImageCreated(ref name.Reference, options ...remote.Option) (time.Time, error) {
+	desc, err := remote.Get(ref, options...)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var img v1.Image
+	if desc.MediaType.IsIndex() {
+		idx, err := desc.ImageIndex()
+		if err != nil {
+			return time.Time{}, err
+		}
+		manifest, err := idx.IndexManifest()
+		if err != nil {
+			return time.Time{}, err
+		}
+		if created, ok := manifest.Annotations[CreatedAnnotation]; ok {
+			return parseCreated(created)
+		}
+		if len(manifest.Manifests) == 0 {
+			return time.Time{}, fmt.Errorf("image index '%s' has no manifests", ref)
+		}
+		img, err = idx.Image(manifest.Manifests[0].Digest)
+		if err != nil {
+			return time.Time{}, err
+		}
+	} else {
+		img, err = desc.Image()
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	manifest, err := img.Manifest()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if created, ok := manifest.Annotations[CreatedAnnotation]; ok {
+		return parseCreated(created)
+	}
+
+	config, err := img.ConfigFile()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if config.Created.IsZero() {
+		return time.Time{}, fmt.Errorf("image '%s' has no creation time", ref)
+	}
+	return config.Created.Time, nil
+}
+
+func parseCreated(created string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, created)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s annotation '%s': %w", CreatedAnnotation, created, err)
+	}
+	return t, nil
+}
+
+// ImagePlatforms returns the platforms, written `os/arch[/variant]`, of
+// the image at the reference, with the digest of the image manifest for
+// each. For an image index, these are the platforms of the images in the
+// index; entries without a platform, or with the platform
+// `unknown/unknown` (e.g., attestations), are left out. For an image,
+// this is the platform given in its config.
+func ImagePlatforms(ref name.Reference, options ...remote.Option) (map[string]string, error) {
+	desc, err := remote.Get(ref, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	platforms := map[string]string{}
+	if desc.MediaType.IsIndex() {
+		idx, err := desc.ImageIndex()
+		if err != nil {
+			return nil, err
+		}
+		manifest, err := idx.IndexManifest()
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range manifest.Manifests {
+			if m.Platform == nil || m.Platform.OS == "" || m.Platform.OS == "unknown" {
+				continue
+			}
+			platforms[m.Platform.String()] = m.Digest.String()
+		}
+		return platforms, nil
+	}
+
+	img, err := desc.Image()
+	if err != nil {
+		return nil, err
+	}
+	config, err := img.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	if config.OS != "" {
+		p := v1.Platform{OS: config.OS, Architecture: config.Architecture, Variant: config.Variant, OSVersion: config.OSVersion}
+		platforms[p.String()] = desc.Digest.String()
+	}
+	return platforms, nil
+}
+
+// MatchPlatform returns the digest for the platform among those given,
+// as returned by ImagePlatforms. The platform matches any variant if it
+// has none, e.g., `linux/arm64` matches `linux/arm64/v8`, and likewise
+// for the OS version.
+func MatchPlatform(platforms map[string]string, platform string) (string, bool, error) {
+	want, err := v1.ParsePlatform(platform)
+	if err != nil {
+		return "", false, err
+	}
+	if want.OS == "" || want.Architecture == "" {
+		return "", false, fmt.Errorf("invalid platform '%s': must be of the form os/arch[/variant]", platform)
+	}
+
+	// Prefer an exact match, then the first of the other matches in
+	// order, so that the result does not depend on the order of the map.
+	if digest, ok := platforms[want.String()]; ok {
+		return digest, true, nil
+	}
+	var match string
+	for p := range platforms {
+		got, err := v1.ParsePlatform(p)
+		if err != nil {
+			continue
+		}
+		if got.OS != want.OS || got.Architecture != want.Architecture ||
+			(want.Variant != "" && got.Variant != want.Variant) ||
+			(want.OSVersion != "" && got.OSVersion != want.OSVersion) {
+			continue
+		}
+		if match == "" || p < match {
+			match = p
+		}
+	}
+	if match == "" {
+		return "", false, nil
+	}
+	return platforms[match], true, nil
+}
+
+// ImageConfig returns the config blob of the image at the reference. For
+// an image index, the config of the first image in the index is used.
+func ImageConfig(ref name.Reference, options ...remote.Option) ([]byte, error) {
+	desc, err := remote.Get(ref, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	var img v1.Image
+	if desc.MediaType.IsIndex() {
+		idx, err := desc.ImageIndex()
+		if err != nil {
+			return nil, err
+		}
+		manifest, err := idx.IndexManifest()
+		if err != nil {
+			return nil, err
+		}
+		if len(manifest.Manifests) == 0 {
+			return nil, fmt.Errorf("image index '%s' has no manifests", ref)
+		}
+		img, err = idx.Image(manifest.Manifests[0].Digest)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		img, err = desc.Image()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return img.RawConfigFile()
+}
+
+// ConfigLabels returns the labels given in the image config blob.
+func ConfigLabels(config []byte) (map[string]string, error) {
+	cf, err := v1.ParseConfigFile(bytes.NewReader(config))
+	if err != nil {
+		return nil, fmt.Errorf("invalid image config: %w", err)
+	}
+	return cf.Config.Labels, nil
+}
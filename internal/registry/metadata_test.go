@@ -0,0 +1,255 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	ggcrregistry "github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	. "github.com/onsi/gomega"
+)
+
+func TestImageCreated(t *testing.T) {
+	srv := httptest.NewServer(ggcrregistry.New())
+	t.Cleanup(srv.Close)
+	registryName := strings.TrimPrefix(srv.URL, "http://")
+
+	configCreated := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	annotationCreated := time.Date(2022, 5, 3, 9, 30, 0, 0, time.UTC)
+
+	newImage := func(t *testing.T) v1.Image {
+		t.Helper()
+		img, err := random.Image(512, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err = mutate.CreatedAt(img, v1.Time{Time: configCreated})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return img
+	}
+	annotations := map[string]string{CreatedAnnotation: annotationCreated.Format(time.RFC3339)}
+
+	tests := []struct {
+		name  string
+		write func(t *testing.T, ref name.Reference) error
+		want  time.Time
+	}{
+		{
+			name: "image with created in config",
+			write: func(t *testing.T, ref name.Reference) error {
+				return remote.Write(ref, newImage(t))
+			},
+			want: configCreated,
+		},
+		{
+			name: "image with created annotation",
+			write: func(t *testing.T, ref name.Reference) error {
+				img := mutate.Annotations(newImage(t), annotations).(v1.Image)
+				return remote.Write(ref, img)
+			},
+			want: annotationCreated,
+		},
+		{
+			name: "index without created annotation",
+			write: func(t *testing.T, ref name.Reference) error {
+				idx := mutate.AppendManifests(empty.Index, mutate.IndexAddendum{Add: newImage(t)})
+				return remote.WriteIndex(ref, idx)
+			},
+			want: configCreated,
+		},
+		{
+			name: "index with created annotation",
+			write: func(t *testing.T, ref name.Reference) error {
+				idx := mutate.AppendManifests(empty.Index, mutate.IndexAddendum{Add: newImage(t)})
+				idx = mutate.Annotations(idx, annotations).(v1.ImageIndex)
+				return remote.WriteIndex(ref, idx)
+			},
+			want: annotationCreated,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			ref, err := name.NewTag(registryName + "/foo/bar:" + string(rune('a'+i)))
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(tt.write(t, ref)).To(Succeed())
+
+			created, err := ImageCreated(ref)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(created.Equal(tt.want)).To(BeTrue(), "got %v, want %v", created, tt.want)
+		})
+	}
+}
+
+func TestImagePlatforms(t *testing.T) {
+	g := NewWithT(t)
+
+	srv := httptest.NewServer(ggcrregistry.New())
+	t.Cleanup(srv.Close)
+	registryName := strings.TrimPrefix(srv.URL, "http://")
+
+	newImage := func(platform v1.Platform) v1.Image {
+		img, err := random.Image(512, 1)
+		g.Expect(err).ToNot(HaveOccurred())
+		config, err := img.ConfigFile()
+		g.Expect(err).ToNot(HaveOccurred())
+		config.OS = platform.OS
+		config.Architecture = platform.Architecture
+		config.Variant = platform.Variant
+		img, err = mutate.ConfigFile(img, config)
+		g.Expect(err).ToNot(HaveOccurred())
+		return img
+	}
+	amd64 := v1.Platform{OS: "linux", Architecture: "amd64"}
+	arm64 := v1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}
+
+	// A single image gives the platform of its config.
+	ref, err := name.NewTag(registryName + "/foo/bar:image")
+	g.Expect(err).ToNot(HaveOccurred())
+	img := newImage(amd64)
+	g.Expect(remote.Write(ref, img)).To(Succeed())
+	digest, err := img.Digest()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	platforms, err := ImagePlatforms(ref)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(platforms).To(Equal(map[string]string{"linux/amd64": digest.String()}))
+
+	// An index gives the platforms of its images, leaving out those
+	// without a platform.
+	ref, err = name.NewTag(registryName + "/foo/bar:index")
+	g.Expect(err).ToNot(HaveOccurred())
+	amd64Image, arm64Image := newImage(amd64), newImage(arm64)
+	idx := mutate.AppendManifests(empty.Index,
+		mutate.IndexAddendum{Add: amd64Image, Descriptor: v1.Descriptor{Platform: &amd64}},
+		mutate.IndexAddendum{Add: arm64Image, Descriptor: v1.Descriptor{Platform: &arm64}},
+		mutate.IndexAddendum{Add: newImage(v1.Platform{}), Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "unknown", Architecture: "unknown"}}},
+	)
+	g.Expect(remote.WriteIndex(ref, idx)).To(Succeed())
+	amd64Digest, err := amd64Image.Digest()
+	g.Expect(err).ToNot(HaveOccurred())
+	arm64Digest, err := arm64Image.Digest()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	platforms, err = ImagePlatforms(ref)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(platforms).To(Equal(map[string]string{
+		"linux/amd64":    amd64Digest.String(),
+		"linux/arm64/v8": arm64Digest.String(),
+	}))
+}
+
+func TestMatchPlatform(t *testing.T) {
+	platforms := map[string]string{
+		"linux/amd64":    "sha256:amd64",
+		"linux/arm/v6":   "sha256:armv6",
+		"linux/arm/v7":   "sha256:armv7",
+		"linux/arm64/v8": "sha256:arm64",
+	}
+
+	tests := []struct {
+		platform   string
+		wantDigest string
+		wantMatch  bool
+		wantErr    bool
+	}{
+		{platform: "linux/amd64", wantDigest: "sha256:amd64", wantMatch: true},
+		{platform: "linux/arm64", wantDigest: "sha256:arm64", wantMatch: true},
+		{platform: "linux/arm64/v8", wantDigest: "sha256:arm64", wantMatch: true},
+		{platform: "linux/arm/v7", wantDigest: "sha256:armv7", wantMatch: true},
+		{platform: "linux/arm", wantDigest: "sha256:armv6", wantMatch: true},
+		{platform: "linux/s390x", wantMatch: false},
+		{platform: "windows/amd64", wantMatch: false},
+		{platform: "linux", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.platform, func(t *testing.T) {
+			g := NewWithT(t)
+
+			digest, ok, err := MatchPlatform(platforms, tt.platform)
+			if tt.wantErr {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(ok).To(Equal(tt.wantMatch))
+			g.Expect(digest).To(Equal(tt.wantDigest))
+		})
+	}
+}
+
+func TestImageConfig(t *testing.T) {
+	g := NewWithT(t)
+
+	srv := httptest.NewServer(ggcrregistry.New())
+	t.Cleanup(srv.Close)
+	registryName := strings.TrimPrefix(srv.URL, "http://")
+
+	newImage := func(labels map[string]string) v1.Image {
+		img, err := random.Image(512, 1)
+		g.Expect(err).ToNot(HaveOccurred())
+		config, err := img.ConfigFile()
+		g.Expect(err).ToNot(HaveOccurred())
+		config.Config.Labels = labels
+		img, err = mutate.ConfigFile(img, config)
+		g.Expect(err).ToNot(HaveOccurred())
+		return img
+	}
+	stable := map[string]string{"quality": "stable"}
+
+	ref, err := name.NewTag(registryName + "/foo/bar:image")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(remote.Write(ref, newImage(stable))).To(Succeed())
+
+	config, err := ImageConfig(ref)
+	g.Expect(err).ToNot(HaveOccurred())
+	labels, err := ConfigLabels(config)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(labels).To(Equal(stable))
+
+	// An index gives the config of its first image.
+	ref, err = name.NewTag(registryName + "/foo/bar:index")
+	g.Expect(err).ToNot(HaveOccurred())
+	idx := mutate.AppendManifests(empty.Index,
+		mutate.IndexAddendum{Add: newImage(stable)},
+		mutate.IndexAddendum{Add: newImage(map[string]string{"quality": "beta"})},
+	)
+	g.Expect(remote.WriteIndex(ref, idx)).To(Succeed())
+
+	config, err = ImageConfig(ref)
+	g.Expect(err).ToNot(HaveOccurred())
+	labels, err = ConfigLabels(config)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(labels).To(Equal(stable))
+
+	_, err = ConfigLabels([]byte("not json"))
+	g.Expect(err).To(HaveOccurred())
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mirror
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"sigs.k8s.io/yaml"
+)
+
+// Mirror is a registry serving the images of another under the same
+// names, e.g., a pull-through cache, for the controller to use in
+// place of the registry it mirrors.
+type Mirror struct {
+	// Registry is the host of the registry mirrored, e.g., `docker.io`.
+	Registry string `json:"registry"`
+	// Endpoint is the host of the mirror, with any port, e.g.,
+	// `mirror.corp:5000`.
+	Endpoint string `json:"endpoint"`
+	// Insecure has the mirror accessed over plain HTTP.
+	Insecure bool `json:"insecure,omitempty"`
+	// DockerConfigFile is the path of a docker config file holding the
+	// credentials for the mirror, if any.
+	DockerConfigFile string `json:"dockerConfigFile,omitempty"`
+	// CAFile is the path of a PEM-encoded CA certificate to verify the
+	// mirror with, as well as the system certificates, if any.
+	CAFile string `json:"caFile,omitempty"`
+	// CertFile and KeyFile are the paths of a PEM-encoded client
+	// certificate and key to present to the mirror, if any.
+	CertFile string `json:"certFile,omitempty"`
+	KeyFile  string `json:"keyFile,omitempty"`
+}
+
+// Config is the configuration of the mirrors of registries, as given to
+// the controller in a YAML or JSON file.
+type Config struct {
+	Mirrors []Mirror `json:"mirrors"`
+}
+
+// Load reads the configuration of mirrors from the file, returning an
+// error if it is not valid.
+func Load(path string) ([]Mirror, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config Config
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid mirrors config '%s': %w", path, err)
+	}
+
+	seen := map[string]bool{}
+	for i := range config.Mirrors {
+		m := &config.Mirrors[i]
+		reg, err := name.NewRegistry(m.Registry)
+		if err != nil || m.Registry == "" {
+			return nil, fmt.Errorf("invalid mirrors config '%s': invalid registry '%s'", path, m.Registry)
+		}
+		// The registry is given as the host requests are made to, e.g.,
+		// `index.docker.io` for `docker.io`.
+		m.Registry = reg.RegistryStr()
+		if seen[m.Registry] {
+			return nil, fmt.Errorf("invalid mirrors config '%s': more than one mirror of '%s'", path, m.Registry)
+		}
+		seen[m.Registry] = true
+		if m.Endpoint == "" || strings.ContainsAny(m.Endpoint, "/?#") {
+			return nil, fmt.Errorf("invalid mirrors config '%s': the endpoint of the mirror of '%s' must be a host, with any port", path, m.Registry)
+		}
+		if (m.CertFile == "") != (m.KeyFile == "") {
+			return nil, fmt.Errorf("invalid mirrors config '%s': the mirror of '%s' must give both certFile and keyFile, or neither", path, m.Registry)
+		}
+	}
+	return config.Mirrors, nil
+}
+
+// For returns the mirror of the registry, and true, if there is one.
+func For(mirrors []Mirror, registry string) (Mirror, bool) {
+	for _, m := range mirrors {
+		if m.Registry == registry {
+			return m, true
+		}
+	}
+	return Mirror{}, false
+}
+
+// Reference returns the reference to the same image as the reference
+// given, in the mirror.
+func (m Mirror) Reference(ref name.Reference) (name.Reference, error) {
+	var opts []name.Option
+	if m.Insecure {
+		opts = append(opts, name.Insecure)
+	}
+	return name.ParseReference(m.Endpoint+"/"+ref.Context().RepositoryStr()+":latest", opts...)
+}
+
+// TLSConfig returns the TLS configuration for connecting to the mirror,
+// or nil if the mirror gives no certificates.
+func (m Mirror) TLSConfig() (*tls.Config, error) {
+	if m.CAFile == "" && m.CertFile == "" {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{}
+	if m.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(m.CertFile, m.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load the client certificate of the mirror of '%s': %w", m.Registry, err)
+		}
+		tlsConfig.Certificates = append(tlsConfig.Certificates, cert)
+	}
+	if m.CAFile != "" {
+		caCert, err := os.ReadFile(m.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load the CA certificate of the mirror of '%s': %w", m.Registry, err)
+		}
+		syscerts, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, err
+		}
+		syscerts.AppendCertsFromPEM(caCert)
+		tlsConfig.RootCAs = syscerts
+	}
+	return tlsConfig, nil
+}
+
+// transport sends the requests for the registry mirrored to the mirror.
+type transport struct {
+	mirror Mirror
+	inner  http.RoundTripper
+}
+
+// NewTransport returns a transport sending the requests for the
+// registry the mirror mirrors to the mirror, and any others as they
+// are, with the transport given.
+func NewTransport(m Mirror, inner http.RoundTripper) http.RoundTripper {
+	return &transport{mirror: m, inner: inner}
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != t.mirror.Registry {
+		return t.inner.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	req.URL.Host = t.mirror.Endpoint
+	req.Host = t.mirror.Endpoint
+	req.URL.Scheme = "https"
+	if t.mirror.Insecure {
+		req.URL.Scheme = "http"
+	}
+	return t.inner.RoundTrip(req)
+}
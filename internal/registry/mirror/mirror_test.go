@@ -0,0 +1,136 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mirror
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	ggcrregistry "github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	. "github.com/onsi/gomega"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		want    []Mirror
+		wantErr bool
+	}{
+		{
+			name: "mirrors",
+			config: `
+mirrors:
+- registry: docker.io
+  endpoint: mirror.corp:5000
+  insecure: true
+- registry: ghcr.io
+  endpoint: ghcr-cache.corp
+  caFile: /etc/mirrors/ca.crt
+`,
+			want: []Mirror{
+				{Registry: "index.docker.io", Endpoint: "mirror.corp:5000", Insecure: true},
+				{Registry: "ghcr.io", Endpoint: "ghcr-cache.corp", CAFile: "/etc/mirrors/ca.crt"},
+			},
+		},
+		{
+			name:    "unknown field",
+			config:  "mirrors:\n- registry: docker.io\n  endpoint: mirror.corp\n  url: https://mirror.corp\n",
+			wantErr: true,
+		},
+		{
+			name:    "endpoint with a scheme",
+			config:  "mirrors:\n- registry: docker.io\n  endpoint: https://mirror.corp\n",
+			wantErr: true,
+		},
+		{
+			name:    "missing endpoint",
+			config:  "mirrors:\n- registry: docker.io\n",
+			wantErr: true,
+		},
+		{
+			name:    "two mirrors of a registry",
+			config:  "mirrors:\n- registry: docker.io\n  endpoint: a.corp\n- registry: index.docker.io\n  endpoint: b.corp\n",
+			wantErr: true,
+		},
+		{
+			name:    "client certificate without key",
+			config:  "mirrors:\n- registry: docker.io\n  endpoint: mirror.corp\n  certFile: /etc/mirrors/tls.crt\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			path := filepath.Join(t.TempDir(), "mirrors.yaml")
+			g.Expect(os.WriteFile(path, []byte(tt.config), 0o600)).To(Succeed())
+
+			mirrors, err := Load(path)
+			if tt.wantErr {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(mirrors).To(Equal(tt.want))
+		})
+	}
+}
+
+func TestFor(t *testing.T) {
+	g := NewWithT(t)
+	mirrors := []Mirror{{Registry: "index.docker.io", Endpoint: "mirror.corp:5000"}}
+
+	m, ok := For(mirrors, name.DefaultRegistry)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(m.Endpoint).To(Equal("mirror.corp:5000"))
+
+	_, ok = For(mirrors, "ghcr.io")
+	g.Expect(ok).To(BeFalse())
+}
+
+func TestTransport(t *testing.T) {
+	g := NewWithT(t)
+
+	srv := httptest.NewServer(ggcrregistry.New())
+	t.Cleanup(srv.Close)
+	endpoint := strings.TrimPrefix(srv.URL, "http://")
+
+	// The mirror has the image under the name it has in Docker Hub.
+	mirrored, err := name.NewTag(endpoint + "/library/app:v1.0.0")
+	g.Expect(err).ToNot(HaveOccurred())
+	img, err := random.Image(512, 1)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(remote.Write(mirrored, img)).To(Succeed())
+
+	m := Mirror{Registry: name.DefaultRegistry, Endpoint: endpoint, Insecure: true}
+	repo, err := name.NewRepository("docker.io/library/app")
+	g.Expect(err).ToNot(HaveOccurred())
+	tags, err := remote.List(repo, remote.WithTransport(NewTransport(m, remote.DefaultTransport)))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(tags).To(Equal([]string{"v1.0.0"}))
+
+	ref, err := m.Reference(mirrored)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ref.Context().String()).To(Equal(endpoint + "/library/app"))
+}
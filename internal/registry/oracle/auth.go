@@ -0,0 +1,456 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oracle
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"github.com/fluxcd/image-reflector-controller/internal/registry"
+)
+
+// METADATA_URL is the default endpoint of the instance metadata service
+// of OCI, from which the certificates of the instance principal are got.
+const METADATA_URL = "http://169.254.169.254/opc/v2"
+
+// AUTH_URL is the default endpoint of the OCI identity service, for the
+// canonical name of the region, from which the security tokens of the
+// instance principal are got.
+const AUTH_URL = "https://auth.%s.oraclecloud.com"
+
+const (
+	// resourcePrincipalVersionEnv and the other environment variables
+	// set for a pod with OKE workload identity select the workload
+	// principal, in place of the instance principal.
+	resourcePrincipalVersionEnv = "OCI_RESOURCE_PRINCIPAL_VERSION"
+	kubernetesServiceHostEnv    = "KUBERNETES_SERVICE_HOST"
+	// workloadProxyPort is the port of the OKE proxy giving the security
+	// tokens of workload principals.
+	workloadProxyPort       = "12250"
+	serviceAccountTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	serviceAccountCAPath    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+
+	// tokenUsername is the user name to log in to OCIR with a token from
+	// its docker token endpoint.
+	tokenUsername = "BEARER_TOKEN"
+)
+
+var hostRe = regexp.MustCompile(`^(?:[a-z0-9-]+\.ocir\.io|ocir\.[a-z0-9-]+\.oci\.oraclecloud\.com)$`)
+
+// ValidHost returns if a given host is a valid OCIR host.
+func ValidHost(host string) bool {
+	return hostRe.MatchString(host)
+}
+
+// Client is an OCIR client which can log into the registry and return
+// authorization information, as the instance principal of the node, or
+// the workload principal of the pod with OKE workload identity.
+type Client struct {
+	metadataURL string
+	authURL     string
+	workloadURL string
+	tokenPath   string
+	getenv      func(string) string
+}
+
+// NewClient creates a new OCIR client with default configurations.
+func NewClient() *Client {
+	return &Client{metadataURL: METADATA_URL, tokenPath: serviceAccountTokenPath, getenv: os.Getenv}
+}
+
+// WithMetadataURL sets the instance metadata URL used by the OCIR
+// client.
+func (c *Client) WithMetadataURL(url string) *Client {
+	c.metadataURL = url
+	return c
+}
+
+// WithAuthURL sets the identity service URL used by the OCIR client, in
+// place of that of the region of the instance.
+func (c *Client) WithAuthURL(url string) *Client {
+	c.authURL = url
+	return c
+}
+
+// WithWorkloadURL sets the URL of the OKE proxy used by the OCIR client
+// to get the security token of the workload principal, and has the
+// workload principal used.
+func (c *Client) WithWorkloadURL(url string) *Client {
+	c.workloadURL = url
+	return c
+}
+
+// principal is an identity of OCI, by which requests are signed: the
+// security token got for it, and the session key it was got for.
+type principal struct {
+	token string
+	key   *rsa.PrivateKey
+}
+
+// keyID returns the ID of the key of the principal for signing.
+func (p principal) keyID() string {
+	return "ST$" + p.token
+}
+
+// expiresAt returns when the security token of the principal expires,
+// or the zero time if it does not say.
+func (p principal) expiresAt() time.Time {
+	var claims jwt.RegisteredClaims
+	if _, _, err := jwt.NewParser().ParseUnverified(p.token, &claims); err == nil && claims.ExpiresAt != nil {
+		return claims.ExpiresAt.Time
+	}
+	return time.Time{}
+}
+
+// getPrincipal gets the security token of the workload principal, if
+// the pod has OKE workload identity, otherwise of the instance
+// principal.
+func (c *Client) getPrincipal(ctx context.Context) (principal, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return principal{}, err
+	}
+	if c.workloadURL != "" || c.getenv(resourcePrincipalVersionEnv) != "" {
+		return c.getWorkloadPrincipal(ctx, key)
+	}
+	return c.getInstancePrincipal(ctx, key)
+}
+
+// getMetadata gets the value at the path of the instance metadata
+// service.
+func (c *Client) getMetadata(ctx context.Context, path string) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, c.metadataURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Authorization", "Bearer Oracle")
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	defer io.Copy(io.Discard, response.Body)
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status from metadata service getting '%s': %s", path, response.Status)
+	}
+	return io.ReadAll(response.Body)
+}
+
+// getInstancePrincipal gets a security token for the session key, by
+// federating the certificate of the instance with the identity service.
+func (c *Client) getInstancePrincipal(ctx context.Context, key *rsa.PrivateKey) (principal, error) {
+	var values [4][]byte
+	for i, path := range []string{"/identity/cert.pem", "/identity/key.pem", "/identity/intermediate.pem", "/instance/canonicalRegionName"} {
+		value, err := c.getMetadata(ctx, path)
+		if err != nil {
+			return principal{}, err
+		}
+		values[i] = value
+	}
+	certPEM, keyPEM, intermediatePEM, region := values[0], values[1], values[2], strings.TrimSpace(string(values[3]))
+
+	cert, err := parseCertificate(certPEM)
+	if err != nil {
+		return principal{}, fmt.Errorf("invalid instance certificate: %w", err)
+	}
+	intermediate, err := parseCertificate(intermediatePEM)
+	if err != nil {
+		return principal{}, fmt.Errorf("invalid intermediate certificate: %w", err)
+	}
+	instanceKey, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return principal{}, fmt.Errorf("invalid instance key: %w", err)
+	}
+	tenancy := tenancyID(cert)
+	if tenancy == "" {
+		return principal{}, fmt.Errorf("no tenancy in the instance certificate")
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return principal{}, err
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"certificate":              base64.StdEncoding.EncodeToString(cert.Raw),
+		"intermediateCertificates": []string{base64.StdEncoding.EncodeToString(intermediate.Raw)},
+		"publicKey":                base64.StdEncoding.EncodeToString(publicKey),
+		"purpose":                  "DEFAULT",
+		"fingerprintAlgorithm":     "SHA256",
+	})
+	if err != nil {
+		return principal{}, err
+	}
+	authURL := c.authURL
+	if authURL == "" {
+		authURL = fmt.Sprintf(AUTH_URL, region)
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, authURL+"/v1/x509", nil)
+	if err != nil {
+		return principal{}, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if err := signRequest(request, tenancy+"/fed-x509-sha256/"+fingerprint(cert), instanceKey, body); err != nil {
+		return principal{}, err
+	}
+
+	token, err := getToken(request, "identity service")
+	if err != nil {
+		return principal{}, err
+	}
+	return principal{token: token, key: key}, nil
+}
+
+// getWorkloadPrincipal gets a security token for the session key from
+// the OKE proxy, as the service account of the pod.
+func (c *Client) getWorkloadPrincipal(ctx context.Context, key *rsa.PrivateKey) (principal, error) {
+	saToken, err := os.ReadFile(c.tokenPath)
+	if err != nil {
+		return principal{}, fmt.Errorf("unable to read the service account token for OKE workload identity: %w", err)
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return principal{}, err
+	}
+	body, err := json.Marshal(map[string]string{"podKey": base64.StdEncoding.EncodeToString(publicKey)})
+	if err != nil {
+		return principal{}, err
+	}
+
+	client := http.DefaultClient
+	u := c.workloadURL
+	if u == "" {
+		host := c.getenv(kubernetesServiceHostEnv)
+		if host == "" {
+			return principal{}, fmt.Errorf("%s is not set for OKE workload identity", kubernetesServiceHostEnv)
+		}
+		u = "https://" + host + ":" + workloadProxyPort
+		ca, err := os.ReadFile(serviceAccountCAPath)
+		if err != nil {
+			return principal{}, fmt.Errorf("unable to read the cluster CA certificate for OKE workload identity: %w", err)
+		}
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(ca)
+		client = &http.Client{Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		}}
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, u+"/resourcePrincipalSessionTokens", bytes.NewReader(body))
+	if err != nil {
+		return principal{}, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(saToken)))
+
+	response, err := client.Do(request)
+	if err != nil {
+		return principal{}, err
+	}
+	defer response.Body.Close()
+	defer io.Copy(io.Discard, response.Body)
+
+	if response.StatusCode != http.StatusOK {
+		return principal{}, fmt.Errorf("unexpected status from OKE proxy getting a workload principal token: %s", response.Status)
+	}
+	// The response is the token response, base64-encoded as a JSON
+	// string.
+	var encoded string
+	if err := json.NewDecoder(response.Body).Decode(&encoded); err != nil {
+		return principal{}, err
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return principal{}, err
+	}
+	var token struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(decoded, &token); err != nil {
+		return principal{}, err
+	}
+	return principal{token: strings.TrimPrefix(token.Token, "ST$"), key: key}, nil
+}
+
+// getToken sends the request, and returns the token in the response.
+func getToken(request *http.Request, service string) (string, error) {
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	defer io.Copy(io.Discard, response.Body)
+
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status from %s: %s", service, response.Status)
+	}
+	var token struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	return token.Token, nil
+}
+
+// getLoginAuth obtains authentication for the registry by getting a
+// docker token from it, in a request signed as the principal. It also
+// returns when the authentication expires.
+func (c *Client) getLoginAuth(ctx context.Context, ref name.Reference) (authn.AuthConfig, time.Time, error) {
+	var authConfig authn.AuthConfig
+
+	p, err := c.getPrincipal(ctx)
+	if err != nil {
+		return authConfig, time.Time{}, err
+	}
+
+	registryURL := ref.Context().Registry.Scheme() + "://" + ref.Context().RegistryStr()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, registryURL+"/20180419/docker/token", nil)
+	if err != nil {
+		return authConfig, time.Time{}, err
+	}
+	if err := signRequest(request, p.keyID(), p.key, nil); err != nil {
+		return authConfig, time.Time{}, err
+	}
+
+	requested := time.Now()
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return authConfig, time.Time{}, err
+	}
+	defer response.Body.Close()
+	defer io.Copy(io.Discard, response.Body)
+
+	if response.StatusCode != http.StatusOK {
+		return authConfig, time.Time{}, fmt.Errorf("unexpected status from OCIR getting a docker token: %s", response.Status)
+	}
+	var token struct {
+		Token     string `json:"token"`
+		ExpiresIn int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&token); err != nil {
+		return authConfig, time.Time{}, err
+	}
+
+	// The docker token is good for as long as it says, but no longer
+	// than the security token it was got with.
+	expiresAt := requested.Add(time.Duration(token.ExpiresIn) * time.Second)
+	if principalExpiry := p.expiresAt(); !principalExpiry.IsZero() && principalExpiry.Before(expiresAt) {
+		expiresAt = principalExpiry
+	}
+	authConfig = authn.AuthConfig{
+		Username: tokenUsername,
+		Password: token.Token,
+	}
+	return authConfig, expiresAt, nil
+}
+
+// Login attempts to get the authentication material for OCIR. The caller
+// can ensure that the passed image is a valid OCIR image using
+// ValidHost(). It also returns when the authentication expires.
+func (c *Client) Login(ctx context.Context, autoLogin bool, image string, ref name.Reference) (authn.Authenticator, time.Time, error) {
+	if autoLogin {
+		ctrl.LoggerFrom(ctx).Info("logging in to Oracle OCIR for " + image)
+		authConfig, expiresAt, err := c.getLoginAuth(ctx, ref)
+		if err != nil {
+			ctrl.LoggerFrom(ctx).Info("error logging into OCIR " + err.Error())
+			return nil, time.Time{}, err
+		}
+
+		auth := authn.FromConfig(authConfig)
+		return auth, expiresAt, nil
+	}
+	ctrl.LoggerFrom(ctx).Info("OCIR authentication is not enabled. To enable, set the controller flag --oci-autologin-for-ocir")
+	return nil, time.Time{}, fmt.Errorf("OCIR authentication failed: %w", registry.ErrUnconfiguredProvider)
+}
+
+// parseCertificate parses the first certificate in the PEM data.
+func parseCertificate(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// parsePrivateKey parses the RSA private key in the PEM data, in PKCS
+// #1 or PKCS #8 form.
+func parsePrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("not an RSA key")
+	}
+	return rsaKey, nil
+}
+
+// tenancyID returns the OCID of the tenancy of the instance, given in
+// the subject of its certificate.
+func tenancyID(cert *x509.Certificate) string {
+	for _, values := range [][]string{cert.Subject.OrganizationalUnit, cert.Subject.Organization} {
+		for _, v := range values {
+			for _, prefix := range []string{"opc-tenant:", "opc-identity:"} {
+				if strings.HasPrefix(v, prefix) {
+					return strings.TrimPrefix(v, prefix)
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// fingerprint returns the SHA-256 fingerprint of the certificate, as
+// colon-separated hex.
+func fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
@@ -0,0 +1,318 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oracle
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/go-containerregistry/pkg/name"
+	. "github.com/onsi/gomega"
+
+	"github.com/fluxcd/image-reflector-controller/internal/registry"
+)
+
+const testTenancy = "ocid1.tenancy.oc1..test"
+
+var signatureParamRe = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// verifySignature checks that the request is signed by the key given
+// for the key ID it is signed with, returning the key ID.
+func verifySignature(r *http.Request, body []byte, keys func(keyID string) *rsa.PublicKey) (string, error) {
+	params := map[string]string{}
+	for _, m := range signatureParamRe.FindAllStringSubmatch(strings.TrimPrefix(r.Header.Get("Authorization"), "Signature "), -1) {
+		params[m[1]] = m[2]
+	}
+	key := keys(params["keyId"])
+	if key == nil {
+		return "", errors.New("unknown key ID " + params["keyId"])
+	}
+	if params["algorithm"] != "rsa-sha256" {
+		return "", errors.New("unexpected algorithm " + params["algorithm"])
+	}
+	if sum := r.Header.Get("X-Content-Sha256"); sum != "" {
+		want := sha256.Sum256(body)
+		if sum != base64.StdEncoding.EncodeToString(want[:]) {
+			return "", errors.New("body is not that signed")
+		}
+	}
+	var lines []string
+	for _, h := range strings.Fields(params["headers"]) {
+		switch h {
+		case "(request-target)":
+			lines = append(lines, h+": "+strings.ToLower(r.Method)+" "+r.URL.RequestURI())
+		case "host":
+			lines = append(lines, h+": "+r.Host)
+		case "content-length":
+			lines = append(lines, h+": "+strconv.FormatInt(r.ContentLength, 10))
+		default:
+			lines = append(lines, h+": "+r.Header.Get(h))
+		}
+	}
+	signature, err := base64.StdEncoding.DecodeString(params["signature"])
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return params["keyId"], rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature)
+}
+
+// securityToken returns a security token expiring at the time given.
+func securityToken(t *testing.T, expiresAt time.Time) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+	}).SignedString([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// registryServer returns a server giving a docker token for requests
+// signed with the security token and the session key it records.
+func registryServer(t *testing.T, token string, sessionKey **rsa.PublicKey) *httptest.Server {
+	t.Helper()
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/20180419/docker/token" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if _, err := verifySignature(r, nil, func(keyID string) *rsa.PublicKey {
+			if keyID != "ST$"+token {
+				return nil
+			}
+			return *sessionKey
+		}); err != nil {
+			t.Log(err)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		io.WriteString(w, `{"token": "docker-token", "access_token": "docker-token", "expires_in": 3600}`)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(handler))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// sessionKey parses the public key of the session key in the request.
+func sessionKey(encoded string) (*rsa.PublicKey, error) {
+	der, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	return key.(*rsa.PublicKey), nil
+}
+
+func TestValidHost(t *testing.T) {
+	tests := []struct {
+		host   string
+		result bool
+	}{
+		{"iad.ocir.io", true},
+		{"us-ashburn-1.ocir.io", true},
+		{"ocir.us-ashburn-1.oci.oraclecloud.com", true},
+		{"ocir.io.example.com", false},
+		{"foo.ocir.io.example.com", false},
+		{"example.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(ValidHost(tt.host)).To(Equal(tt.result))
+		})
+	}
+}
+
+func TestGetLoginAuth_InstancePrincipal(t *testing.T) {
+	g := NewWithT(t)
+
+	// The certificate of the instance gives its tenancy.
+	instanceKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	g.Expect(err).ToNot(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			CommonName:         "ocid1.instance.oc1..test",
+			OrganizationalUnit: []string{"opc-instance:ocid1.instance.oc1..test", "opc-tenant:" + testTenancy},
+		},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter:  time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &instanceKey.PublicKey, instanceKey)
+	g.Expect(err).ToNot(HaveOccurred())
+	cert, err := x509.ParseCertificate(der)
+	g.Expect(err).ToNot(HaveOccurred())
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(instanceKey)})
+
+	metadata := map[string][]byte{
+		"/identity/cert.pem":            certPEM,
+		"/identity/key.pem":             keyPEM,
+		"/identity/intermediate.pem":    certPEM,
+		"/instance/canonicalRegionName": []byte("us-ashburn-1"),
+	}
+	metadataHandler := func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer Oracle" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		value, ok := metadata[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(value)
+	}
+	metadataSrv := httptest.NewServer(http.HandlerFunc(metadataHandler))
+	t.Cleanup(metadataSrv.Close)
+
+	// The identity service federates the certificate of the instance,
+	// giving a security token for the session key.
+	principalExpiry := time.Now().Add(20 * time.Minute).Truncate(time.Second)
+	token := securityToken(t, principalExpiry)
+	var session *rsa.PublicKey
+	authHandler := func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/x509" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if _, err := verifySignature(r, body, func(keyID string) *rsa.PublicKey {
+			if keyID != testTenancy+"/fed-x509-sha256/"+fingerprint(cert) {
+				return nil
+			}
+			return &instanceKey.PublicKey
+		}); err != nil {
+			t.Log(err)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var request struct {
+			Certificate string `json:"certificate"`
+			PublicKey   string `json:"publicKey"`
+		}
+		if err := json.Unmarshal(body, &request); err != nil || request.Certificate != base64.StdEncoding.EncodeToString(der) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if session, err = sessionKey(request.PublicKey); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": token})
+	}
+	authSrv := httptest.NewServer(http.HandlerFunc(authHandler))
+	t.Cleanup(authSrv.Close)
+
+	registrySrv := registryServer(t, token, &session)
+	ref, err := name.ParseReference(strings.TrimPrefix(registrySrv.URL, "http://")+"/tenancy/app:v1", name.Insecure)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	c := NewClient().WithMetadataURL(metadataSrv.URL).WithAuthURL(authSrv.URL)
+	c.getenv = func(string) string { return "" }
+	authConfig, expiresAt, err := c.getLoginAuth(context.TODO(), ref)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(authConfig.Username).To(Equal("BEARER_TOKEN"))
+	g.Expect(authConfig.Password).To(Equal("docker-token"))
+	// The docker token lasts an hour, but the security token less.
+	g.Expect(expiresAt).To(Equal(principalExpiry))
+}
+
+func TestGetLoginAuth_WorkloadPrincipal(t *testing.T) {
+	g := NewWithT(t)
+
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	g.Expect(os.WriteFile(tokenPath, []byte("sa-token\n"), 0o600)).To(Succeed())
+
+	// The OKE proxy gives a security token for the session key, to the
+	// service account of the pod.
+	token := securityToken(t, time.Now().Add(2*time.Hour))
+	var session *rsa.PublicKey
+	proxyHandler := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/resourcePrincipalSessionTokens" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer sa-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var request struct {
+			PodKey string `json:"podKey"`
+		}
+		var err error
+		if err = json.NewDecoder(r.Body).Decode(&request); err == nil {
+			session, err = sessionKey(request.PodKey)
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		response, _ := json.Marshal(map[string]string{"token": "ST$" + token})
+		json.NewEncoder(w).Encode(base64.StdEncoding.EncodeToString(response))
+	}
+	proxySrv := httptest.NewServer(http.HandlerFunc(proxyHandler))
+	t.Cleanup(proxySrv.Close)
+
+	registrySrv := registryServer(t, token, &session)
+	ref, err := name.ParseReference(strings.TrimPrefix(registrySrv.URL, "http://")+"/tenancy/app:v1", name.Insecure)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	c := NewClient().WithWorkloadURL(proxySrv.URL)
+	c.tokenPath = tokenPath
+	requested := time.Now()
+	authConfig, expiresAt, err := c.getLoginAuth(context.TODO(), ref)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(authConfig.Username).To(Equal("BEARER_TOKEN"))
+	g.Expect(authConfig.Password).To(Equal("docker-token"))
+	g.Expect(expiresAt).To(BeTemporally("~", requested.Add(time.Hour), time.Second))
+}
+
+func TestLogin(t *testing.T) {
+	g := NewWithT(t)
+
+	ref, err := name.ParseReference("iad.ocir.io/tenancy/app:v1")
+	g.Expect(err).ToNot(HaveOccurred())
+	_, _, err = NewClient().Login(context.TODO(), false, ref.String(), ref)
+	g.Expect(errors.Is(err, registry.ErrUnconfiguredProvider)).To(BeTrue())
+}
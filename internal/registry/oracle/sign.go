@@ -0,0 +1,80 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oracle
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// signRequest signs the request for the OCI APIs, as the key with the
+// ID given, with the draft HTTP signatures scheme OCI uses. The body,
+// if any, must be that of the request, since it is hashed into the
+// signature.
+func signRequest(request *http.Request, keyID string, key *rsa.PrivateKey, body []byte) error {
+	if request.Header.Get("Date") == "" {
+		request.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}
+	headers := []string{"date", "(request-target)", "host"}
+	if request.Method == http.MethodPost || request.Method == http.MethodPut {
+		sum := sha256.Sum256(body)
+		request.Header.Set("X-Content-Sha256", base64.StdEncoding.EncodeToString(sum[:]))
+		request.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		request.ContentLength = int64(len(body))
+		request.Body = io.NopCloser(bytes.NewReader(body))
+		headers = append(headers, "content-length", "content-type", "x-content-sha256")
+	}
+
+	signed := signingString(request, headers)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", fmt.Sprintf(`Signature version="1",keyId="%s",algorithm="rsa-sha256",headers="%s",signature="%s"`,
+		keyID, strings.Join(headers, " "), base64.StdEncoding.EncodeToString(signature)))
+	return nil
+}
+
+// signingString returns the string signed for the headers of the
+// request, a line for each, in order.
+func signingString(request *http.Request, headers []string) string {
+	lines := make([]string, len(headers))
+	for i, h := range headers {
+		var value string
+		switch h {
+		case "(request-target)":
+			value = strings.ToLower(request.Method) + " " + request.URL.RequestURI()
+		case "host":
+			value = request.URL.Host
+		default:
+			value = request.Header.Get(h)
+		}
+		lines[i] = h + ": " + value
+	}
+	return strings.Join(lines, "\n")
+}
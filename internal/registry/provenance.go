@@ -0,0 +1,245 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+const (
+	// InTotoMediaType is the media type of an in-toto statement.
+	InTotoMediaType = "application/vnd.in-toto+json"
+	// DSSEMediaType is the media type of a DSSE envelope, which may
+	// wrap an in-toto statement.
+	DSSEMediaType = "application/vnd.dsse.envelope.v1+json"
+	// SLSAProvenancePredicatePrefix is the prefix of the predicate types
+	// of SLSA provenance statements, e.g.,
+	// `https://slsa.dev/provenance/v0.2`.
+	SLSAProvenancePredicatePrefix = "https://slsa.dev/provenance/"
+)
+
+// referrer is a descriptor in the index of the artifacts referring to
+// an image. This includes the artifact type, which v1.Descriptor does
+// not have.
+type referrer struct {
+	MediaType    string  `json:"mediaType"`
+	ArtifactType string  `json:"artifactType,omitempty"`
+	Digest       v1.Hash `json:"digest"`
+}
+
+type dsseEnvelope struct {
+	PayloadType string `json:"payloadType"`
+	Payload     []byte `json:"payload"`
+}
+
+type builder struct {
+	ID string `json:"id"`
+}
+
+type inTotoStatement struct {
+	PredicateType string `json:"predicateType"`
+	Subject       []struct {
+		Digest map[string]string `json:"digest"`
+	} `json:"subject"`
+	Predicate struct {
+		// Builder is where SLSA provenance v0.1 and v0.2 give the
+		// builder.
+		Builder builder `json:"builder"`
+		// RunDetails is where SLSA provenance v1 gives the builder.
+		RunDetails struct {
+			Builder builder `json:"builder"`
+		} `json:"runDetails"`
+	} `json:"predicate"`
+}
+
+// ProvenanceBuilders returns the IDs of the builders named by the SLSA
+// provenance attestations for the image at the reference. Attestations
+// are discovered with the OCI referrers API, or with the referrers tag
+// schema (`sha256-<hex>`) for a registry that does not support it,
+// and are in-toto statements either as they are or in DSSE envelopes,
+// in image manifests; referrers of other media types are skipped. Only
+// attestations with the digest of the image as their subject are
+// counted. The signatures of the attestations are not verified.
+func ProvenanceBuilders(ctx context.Context, ref name.Reference, auth authn.Authenticator, tr http.RoundTripper) ([]string, error) {
+	options := []remote.Option{remote.WithContext(ctx)}
+	if auth != nil {
+		options = append(options, remote.WithAuth(auth))
+	}
+	if tr != nil {
+		options = append(options, remote.WithTransport(tr))
+	}
+
+	desc, err := remote.Head(ref, options...)
+	if err != nil {
+		return nil, err
+	}
+	digest := ref.Context().Digest(desc.Digest.String())
+
+	client, err := newClient(ctx, ref.Context(), auth, tr)
+	if err != nil {
+		return nil, err
+	}
+	referrers, err := listReferrers(ctx, client, digest, options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list referrers of '%s': %w", digest, err)
+	}
+
+	var builders []string
+	for _, r := range referrers {
+		if r.ArtifactType != "" && r.ArtifactType != InTotoMediaType && r.ArtifactType != DSSEMediaType {
+			continue
+		}
+		// Attestations are image manifests; other referrers, e.g., an
+		// index of signatures, are not attestations.
+		if !types.MediaType(r.MediaType).IsImage() {
+			continue
+		}
+		img, err := remote.Image(ref.Context().Digest(r.Digest.String()), options...)
+		if err != nil {
+			return nil, err
+		}
+		layers, err := img.Layers()
+		if err != nil {
+			return nil, err
+		}
+		for _, layer := range layers {
+			mediaType, err := layer.MediaType()
+			if err != nil {
+				return nil, err
+			}
+			if mediaType != InTotoMediaType && mediaType != DSSEMediaType {
+				continue
+			}
+			content, err := readLayer(layer)
+			if err != nil {
+				return nil, err
+			}
+			if id, ok := provenanceBuilder(content, string(mediaType), desc.Digest); ok {
+				builders = append(builders, id)
+			}
+		}
+	}
+	return builders, nil
+}
+
+// listReferrers returns the descriptors of the artifacts referring to
+// the image digest.
+func listReferrers(ctx context.Context, client *http.Client, digest name.Digest, options []remote.Option) ([]referrer, error) {
+	u := &url.URL{
+		Scheme: digest.Registry.Scheme(),
+		Host:   digest.RegistryStr(),
+		Path:   fmt.Sprintf("/v2/%s/referrers/%s", digest.RepositoryStr(), digest.DigestStr()),
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.oci.image.index.v1+json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var manifest []byte
+	if resp.StatusCode == http.StatusNotFound {
+		// Fall back to the referrers tag schema.
+		h, err := v1.NewHash(digest.DigestStr())
+		if err != nil {
+			return nil, err
+		}
+		desc, err := remote.Get(digest.Context().Tag(h.Algorithm+"-"+h.Hex), options...)
+		if err != nil {
+			var terr *transport.Error
+			if errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound {
+				return nil, nil
+			}
+			return nil, err
+		}
+		manifest = desc.Manifest
+	} else {
+		if err := transport.CheckError(resp, http.StatusOK); err != nil {
+			return nil, err
+		}
+		if manifest, err = io.ReadAll(resp.Body); err != nil {
+			return nil, err
+		}
+	}
+
+	var index struct {
+		Manifests []referrer `json:"manifests"`
+	}
+	if err := json.Unmarshal(manifest, &index); err != nil {
+		return nil, err
+	}
+	return index.Manifests, nil
+}
+
+func readLayer(layer v1.Layer) ([]byte, error) {
+	rc, err := layer.Compressed()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// provenanceBuilder returns the builder ID given in the attestation, if
+// it is a SLSA provenance statement with the image digest as a subject.
+func provenanceBuilder(content []byte, mediaType string, digest v1.Hash) (string, bool) {
+	if mediaType == DSSEMediaType {
+		var envelope dsseEnvelope
+		if err := json.Unmarshal(content, &envelope); err != nil || envelope.PayloadType != InTotoMediaType {
+			return "", false
+		}
+		content = envelope.Payload
+	}
+
+	var statement inTotoStatement
+	if err := json.Unmarshal(content, &statement); err != nil {
+		return "", false
+	}
+	if !strings.HasPrefix(statement.PredicateType, SLSAProvenancePredicatePrefix) {
+		return "", false
+	}
+	for _, s := range statement.Subject {
+		if s.Digest[digest.Algorithm] == digest.Hex {
+			if id := statement.Predicate.Builder.ID; id != "" {
+				return id, true
+			}
+			if id := statement.Predicate.RunDetails.Builder.ID; id != "" {
+				return id, true
+			}
+			return "", false
+		}
+	}
+	return "", false
+}
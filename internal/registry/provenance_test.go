@@ -0,0 +1,197 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	ggcrregistry "github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	. "github.com/onsi/gomega"
+)
+
+// attestation returns an image holding an in-toto statement of the given
+// predicate, with the digest as its subject, in a DSSE envelope if dsse
+// is true.
+func attestation(t *testing.T, digest v1.Hash, predicateType string, predicate interface{}, dsse bool) v1.Image {
+	t.Helper()
+	statement, err := json.Marshal(map[string]interface{}{
+		"_type":         "https://in-toto.io/Statement/v0.1",
+		"predicateType": predicateType,
+		"subject": []interface{}{
+			map[string]interface{}{
+				"name":   "image",
+				"digest": map[string]string{digest.Algorithm: digest.Hex},
+			},
+		},
+		"predicate": predicate,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, mediaType := statement, types.MediaType(InTotoMediaType)
+	if dsse {
+		content, err = json.Marshal(dsseEnvelope{PayloadType: InTotoMediaType, Payload: statement})
+		if err != nil {
+			t.Fatal(err)
+		}
+		mediaType = DSSEMediaType
+	}
+	img, err := mutate.Append(empty.Image, mutate.Addendum{Layer: static.NewLayer(content, mediaType)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mutate.MediaType(img, types.OCIManifestSchema1)
+}
+
+func TestProvenanceBuilders(t *testing.T) {
+	v02 := map[string]interface{}{"builder": map[string]string{"id": "https://example.com/builder@v1"}}
+	v10 := map[string]interface{}{"runDetails": map[string]interface{}{"builder": map[string]string{"id": "https://example.com/builder@v2"}}}
+
+	tests := []struct {
+		name         string
+		attestations func(t *testing.T, digest v1.Hash) []v1.Image
+		// withIndex has an index, which is not an attestation, among
+		// the referrers.
+		withIndex bool
+		want      []string
+	}{
+		{
+			name: "no attestations",
+			attestations: func(t *testing.T, digest v1.Hash) []v1.Image {
+				return nil
+			},
+		},
+		{
+			name: "v0.2 provenance statement",
+			attestations: func(t *testing.T, digest v1.Hash) []v1.Image {
+				return []v1.Image{attestation(t, digest, "https://slsa.dev/provenance/v0.2", v02, false)}
+			},
+			want: []string{"https://example.com/builder@v1"},
+		},
+		{
+			name: "v1 provenance statement in DSSE envelope",
+			attestations: func(t *testing.T, digest v1.Hash) []v1.Image {
+				return []v1.Image{attestation(t, digest, "https://slsa.dev/provenance/v1", v10, true)}
+			},
+			want: []string{"https://example.com/builder@v2"},
+		},
+		{
+			name: "provenance statement and an index",
+			attestations: func(t *testing.T, digest v1.Hash) []v1.Image {
+				return []v1.Image{attestation(t, digest, "https://slsa.dev/provenance/v0.2", v02, false)}
+			},
+			withIndex: true,
+			want:      []string{"https://example.com/builder@v1"},
+		},
+		{
+			name: "statement of another predicate type",
+			attestations: func(t *testing.T, digest v1.Hash) []v1.Image {
+				return []v1.Image{attestation(t, digest, "https://spdx.dev/Document", v02, false)}
+			},
+		},
+		{
+			name: "statement about another image",
+			attestations: func(t *testing.T, digest v1.Hash) []v1.Image {
+				other := v1.Hash{Algorithm: "sha256", Hex: strings.Repeat("0", 64)}
+				return []v1.Image{attestation(t, other, "https://slsa.dev/provenance/v0.2", v02, false)}
+			},
+		},
+	}
+
+	for _, referrersAPI := range []bool{false, true} {
+		for i, tt := range tests {
+			t.Run(fmt.Sprintf("%s (referrers API: %v)", tt.name, referrersAPI), func(t *testing.T) {
+				g := NewWithT(t)
+
+				// The referrers API is served from the index pushed at the
+				// referrers tag, if it is being used.
+				var srv *httptest.Server
+				reg := ggcrregistry.New()
+				handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					if !referrersAPI || !strings.Contains(r.URL.Path, "/referrers/") {
+						reg.ServeHTTP(w, r)
+						return
+					}
+					parts := strings.Split(r.URL.Path, "/referrers/")
+					h, err := v1.NewHash(parts[1])
+					if err != nil {
+						http.Error(w, err.Error(), http.StatusBadRequest)
+						return
+					}
+					index := strings.Replace(parts[0], "/v2/", "", 1) + ":" + h.Algorithm + "-" + h.Hex
+					ref, _ := name.NewTag(strings.TrimPrefix(srv.URL, "http://") + "/" + index)
+					desc, err := remote.Get(ref)
+					if err != nil {
+						w.Header().Set("Content-Type", string(types.OCIImageIndex))
+						fmt.Fprint(w, `{"schemaVersion":2,"manifests":[]}`)
+						return
+					}
+					w.Header().Set("Content-Type", string(types.OCIImageIndex))
+					w.Write(desc.Manifest)
+				})
+				srv = httptest.NewServer(handler)
+				t.Cleanup(srv.Close)
+				registryName := strings.TrimPrefix(srv.URL, "http://")
+
+				ref, err := name.NewTag(fmt.Sprintf("%s/foo/bar:%d", registryName, i))
+				g.Expect(err).ToNot(HaveOccurred())
+				img, err := random.Image(512, 1)
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(remote.Write(ref, img)).To(Succeed())
+				digest, err := img.Digest()
+				g.Expect(err).ToNot(HaveOccurred())
+
+				if atts := tt.attestations(t, digest); len(atts) > 0 {
+					idx := mutate.IndexMediaType(empty.Index, types.OCIImageIndex)
+					for _, att := range atts {
+						idx = mutate.AppendManifests(idx, mutate.IndexAddendum{Add: att})
+					}
+					if tt.withIndex {
+						// An index of images for another platform than
+						// the default cannot be read as an image.
+						img, err := random.Image(512, 1)
+						g.Expect(err).ToNot(HaveOccurred())
+						other := mutate.AppendManifests(mutate.IndexMediaType(empty.Index, types.OCIImageIndex), mutate.IndexAddendum{
+							Add:        img,
+							Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "windows", Architecture: "arm64"}},
+						})
+						idx = mutate.AppendManifests(idx, mutate.IndexAddendum{Add: other})
+					}
+					g.Expect(remote.WriteIndex(ref.Context().Tag(digest.Algorithm+"-"+digest.Hex), idx)).To(Succeed())
+				}
+
+				builders, err := ProvenanceBuilders(context.TODO(), ref, nil, nil)
+				g.Expect(err).ToNot(HaveOccurred())
+				g.Expect(builders).To(Equal(tt.want))
+			})
+		}
+	}
+}
@@ -0,0 +1,245 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quay
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+const (
+	// TokenKey is the key of a secret giving a token of a Quay robot
+	// account, or an application token.
+	TokenKey = "quayToken"
+	// RobotKey is the key of a secret giving the name of the Quay robot
+	// account the token is for, e.g., `org+robot`. Without it, the
+	// token is taken to be an application token.
+	RobotKey = "quayRobot"
+
+	// RegistryHost is the host of Quay.io.
+	RegistryHost = "quay.io"
+
+	// applicationTokenUser is the user name to log in with an
+	// application token.
+	applicationTokenUser = "$app"
+	// defaultTokenExpiry is how long a registry token lasts when Quay
+	// does not say, as given by the distribution token spec.
+	defaultTokenExpiry = 60 * time.Second
+)
+
+// Credentials are those of a Quay robot account or an application
+// token, which are traded for registry tokens.
+type Credentials struct {
+	Username string
+	Token    string
+}
+
+// CredentialsFromSecret returns the Quay credentials given by the data
+// of a secret, and `true`, if the secret gives them, otherwise `false`.
+func CredentialsFromSecret(data map[string][]byte) (Credentials, bool, error) {
+	token, ok := data[TokenKey]
+	if !ok {
+		return Credentials{}, false, nil
+	}
+	c := Credentials{
+		Username: applicationTokenUser,
+		Token:    strings.TrimSpace(string(token)),
+	}
+	if c.Token == "" {
+		return Credentials{}, true, fmt.Errorf("secret gives an empty '%s'", TokenKey)
+	}
+	if robot := strings.TrimSpace(string(data[RobotKey])); robot != "" {
+		c.Username = robot
+	}
+	return c, true, nil
+}
+
+// IsQuay reports whether the registry is Quay.io.
+func IsQuay(registry string) bool {
+	return registry == RegistryHost
+}
+
+// CacheKey returns a key identifying the repository and the
+// credentials, so that a registry token is only reused for the
+// repository it gives access to, and a secret giving the same token.
+func (c Credentials) CacheKey(repo name.Repository) string {
+	sum := sha256.Sum256([]byte(c.Token))
+	return fmt.Sprintf("quay/%s/%s/%s", repo.Name(), c.Username, hex.EncodeToString(sum[:]))
+}
+
+// Login trades the credentials for a token to pull from the repository,
+// returning the authentication it gives and when it expires. The
+// transport is used to connect to the registry; it may be nil, meaning
+// the default transport.
+func (c Credentials) Login(ctx context.Context, repo name.Repository, tr http.RoundTripper) (authn.Authenticator, time.Time, error) {
+	u := url.URL{
+		Scheme: repo.Registry.Scheme(),
+		Host:   repo.RegistryStr(),
+		Path:   "/v2/auth",
+		RawQuery: url.Values{
+			"service": {repo.RegistryStr()},
+			"scope":   {repo.Scope("pull")},
+		}.Encode(),
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	request.SetBasicAuth(c.Username, c.Token)
+
+	if tr == nil {
+		tr = http.DefaultTransport
+	}
+	requested := time.Now()
+	response, err := (&http.Client{Transport: tr}).Do(request)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer response.Body.Close()
+	defer io.Copy(io.Discard, response.Body)
+
+	if response.StatusCode != http.StatusOK {
+		return nil, time.Time{}, fmt.Errorf("unexpected status from Quay getting a registry token: %s", response.Status)
+	}
+
+	var registryToken struct {
+		Token     string `json:"token"`
+		ExpiresIn int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&registryToken); err != nil {
+		return nil, time.Time{}, err
+	}
+	if registryToken.Token == "" {
+		return nil, time.Time{}, fmt.Errorf("no registry token in the response from Quay")
+	}
+	return authn.FromConfig(authn.AuthConfig{
+		RegistryToken: registryToken.Token,
+	}), tokenExpiry(registryToken.Token, registryToken.ExpiresIn, requested), nil
+}
+
+// tokenExpiry returns when a registry token expires: after the seconds
+// given with it, if any, otherwise at the expiry of the JWT it is. The
+// JWT is not verified, since it is only for the registry to verify.
+func tokenExpiry(token string, expiresIn int, requested time.Time) time.Time {
+	if expiresIn > 0 {
+		return requested.Add(time.Duration(expiresIn) * time.Second)
+	}
+	var claims jwt.RegisteredClaims
+	if _, _, err := jwt.NewParser().ParseUnverified(token, &claims); err == nil && claims.ExpiresAt != nil {
+		return claims.ExpiresAt.Time
+	}
+	return requested.Add(defaultTokenExpiry)
+}
+
+const (
+	// maxRetries is the greatest number of times a rate-limited request
+	// is retried.
+	maxRetries = 3
+	// defaultRetryAfter is how long to wait before retrying a
+	// rate-limited request, when Quay does not say.
+	defaultRetryAfter = 2 * time.Second
+	// maxRetryAfter bounds how long to wait before retrying a
+	// rate-limited request.
+	maxRetryAfter = 30 * time.Second
+)
+
+// rateLimitTransport retries requests for which Quay responds with
+// `429 Too Many Requests`. Quay does so without an error in the body
+// that the registry client recognises as temporary, so otherwise the
+// request fails outright.
+type rateLimitTransport struct {
+	inner http.RoundTripper
+	// sleep waits for the duration, or until the context is done.
+	sleep func(context.Context, time.Duration) error
+}
+
+// NewTransport returns a transport that retries requests rate limited
+// by Quay, waiting as long as the `Retry-After` header says, using the
+// inner transport for the requests.
+func NewTransport(inner http.RoundTripper) http.RoundTripper {
+	return &rateLimitTransport{inner: inner, sleep: sleep}
+}
+
+func (t *rateLimitTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	for retries := 0; ; retries++ {
+		response, err := t.inner.RoundTrip(request)
+		if err != nil || response.StatusCode != http.StatusTooManyRequests || retries == maxRetries {
+			return response, err
+		}
+		// Only requests that can be sent again are retried.
+		if request.Body != nil && request.Body != http.NoBody {
+			if request.GetBody == nil {
+				return response, nil
+			}
+			body, err := request.GetBody()
+			if err != nil {
+				return response, nil
+			}
+			request = request.Clone(request.Context())
+			request.Body = body
+		}
+		io.Copy(io.Discard, response.Body)
+		response.Body.Close()
+		if err := t.sleep(request.Context(), retryAfter(response)); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// retryAfter returns how long the `Retry-After` header of the response
+// says to wait, in seconds or until a date, bounded by maxRetryAfter.
+func retryAfter(response *http.Response) time.Duration {
+	d := defaultRetryAfter
+	if h := response.Header.Get("Retry-After"); h != "" {
+		if seconds, err := strconv.Atoi(h); err == nil && seconds >= 0 {
+			d = time.Duration(seconds) * time.Second
+		} else if date, err := http.ParseTime(h); err == nil {
+			d = time.Until(date)
+		}
+	}
+	if d < 0 {
+		d = 0
+	}
+	if d > maxRetryAfter {
+		d = maxRetryAfter
+	}
+	return d
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,220 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quay
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/go-containerregistry/pkg/name"
+	. "github.com/onsi/gomega"
+)
+
+func TestCredentialsFromSecret(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      map[string][]byte
+		wantOK    bool
+		wantErr   bool
+		wantCreds Credentials
+	}{
+		{
+			name: "no token",
+			data: map[string][]byte{".dockerconfigjson": []byte("{}")},
+		},
+		{
+			name:      "application token",
+			data:      map[string][]byte{TokenKey: []byte("t0ken\n")},
+			wantOK:    true,
+			wantCreds: Credentials{Username: "$app", Token: "t0ken"},
+		},
+		{
+			name:      "robot token",
+			data:      map[string][]byte{TokenKey: []byte("t0ken"), RobotKey: []byte("org+robot")},
+			wantOK:    true,
+			wantCreds: Credentials{Username: "org+robot", Token: "t0ken"},
+		},
+		{
+			name:    "empty token",
+			data:    map[string][]byte{TokenKey: []byte(" ")},
+			wantOK:  true,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			creds, ok, err := CredentialsFromSecret(tt.data)
+			g.Expect(err != nil).To(Equal(tt.wantErr))
+			g.Expect(ok).To(Equal(tt.wantOK))
+			g.Expect(creds).To(Equal(tt.wantCreds))
+		})
+	}
+}
+
+func TestCredentials_Login(t *testing.T) {
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	jwtToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(expiry),
+	}).SignedString([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		response   map[string]interface{}
+		wantExpiry func(requested time.Time) time.Time
+	}{
+		{
+			name:     "expiry given",
+			response: map[string]interface{}{"token": "registry-token", "expires_in": 300},
+			wantExpiry: func(requested time.Time) time.Time {
+				return requested.Add(300 * time.Second)
+			},
+		},
+		{
+			name:     "expiry of JWT",
+			response: map[string]interface{}{"token": jwtToken},
+			wantExpiry: func(time.Time) time.Time {
+				return expiry
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			var host string
+			handler := func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/v2/auth" || r.URL.Query().Get("service") != host ||
+					r.URL.Query().Get("scope") != "repository:org/app:pull" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				if user, password, _ := r.BasicAuth(); user != "org+robot" || password != "t0ken" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				json.NewEncoder(w).Encode(tt.response)
+			}
+			srv := httptest.NewServer(http.HandlerFunc(handler))
+			t.Cleanup(srv.Close)
+			host = strings.TrimPrefix(srv.URL, "http://")
+
+			repo, err := name.NewRepository(host+"/org/app", name.Insecure)
+			g.Expect(err).ToNot(HaveOccurred())
+			creds := Credentials{Username: "org+robot", Token: "t0ken"}
+			requested := time.Now()
+			auth, expiresAt, err := creds.Login(context.TODO(), repo, nil)
+			g.Expect(err).ToNot(HaveOccurred())
+			cfg, err := auth.Authorization()
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(cfg.RegistryToken).To(Equal(tt.response["token"]))
+			g.Expect(expiresAt).To(BeTemporally("~", tt.wantExpiry(requested), time.Second))
+
+			// Other credentials are refused.
+			_, _, err = Credentials{Username: "$app", Token: "t0ken"}.Login(context.TODO(), repo, nil)
+			g.Expect(err).To(HaveOccurred())
+		})
+	}
+}
+
+func TestRateLimitTransport(t *testing.T) {
+	tests := []struct {
+		name        string
+		limited     int
+		retryAfter  string
+		wantStatus  int
+		wantWaiting []time.Duration
+	}{
+		{
+			name:       "not rate limited",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:        "rate limited with retry after",
+			limited:     2,
+			retryAfter:  "5",
+			wantStatus:  http.StatusOK,
+			wantWaiting: []time.Duration{5 * time.Second, 5 * time.Second},
+		},
+		{
+			name:        "rate limited without retry after",
+			limited:     1,
+			wantStatus:  http.StatusOK,
+			wantWaiting: []time.Duration{defaultRetryAfter},
+		},
+		{
+			name:        "retry after too long",
+			limited:     1,
+			retryAfter:  "3600",
+			wantStatus:  http.StatusOK,
+			wantWaiting: []time.Duration{maxRetryAfter},
+		},
+		{
+			name:        "still rate limited",
+			limited:     maxRetries + 1,
+			wantStatus:  http.StatusTooManyRequests,
+			wantWaiting: []time.Duration{defaultRetryAfter, defaultRetryAfter, defaultRetryAfter},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			var requests int
+			handler := func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				if requests <= tt.limited {
+					if tt.retryAfter != "" {
+						w.Header().Set("Retry-After", tt.retryAfter)
+					}
+					w.WriteHeader(http.StatusTooManyRequests)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}
+			srv := httptest.NewServer(http.HandlerFunc(handler))
+			t.Cleanup(srv.Close)
+
+			var waiting []time.Duration
+			tr := &rateLimitTransport{
+				inner: http.DefaultTransport,
+				sleep: func(_ context.Context, d time.Duration) error {
+					waiting = append(waiting, d)
+					return nil
+				},
+			}
+			response, err := (&http.Client{Transport: tr}).Get(srv.URL + "/v2/org/app/tags/list")
+			g.Expect(err).ToNot(HaveOccurred())
+			response.Body.Close()
+			g.Expect(response.StatusCode).To(Equal(tt.wantStatus))
+			g.Expect(waiting).To(Equal(tt.wantWaiting))
+		})
+	}
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+)
+
+// RequestCounter counts the requests sent to registries for a scan.
+type RequestCounter struct {
+	n int64
+}
+
+// Count returns the number of requests counted.
+func (c *RequestCounter) Count() int {
+	if c == nil {
+		return 0
+	}
+	return int(atomic.LoadInt64(&c.n))
+}
+
+type requestCounterKey struct{}
+
+// WithRequestCounter returns a context in which the requests sent by a
+// transport from CountRequests are counted, and the counter of them.
+func WithRequestCounter(ctx context.Context) (context.Context, *RequestCounter) {
+	c := &RequestCounter{}
+	return context.WithValue(ctx, requestCounterKey{}, c), c
+}
+
+// CountRequests returns a transport which counts each request it sends,
+// including each retry, in the counter of the context of the request,
+// if it has one.
+func CountRequests(inner http.RoundTripper) http.RoundTripper {
+	return &countingTransport{inner: inner}
+}
+
+type countingTransport struct {
+	inner http.RoundTripper
+}
+
+func (t *countingTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if c, ok := request.Context().Value(requestCounterKey{}).(*RequestCounter); ok {
+		atomic.AddInt64(&c.n, 1)
+	}
+	return t.inner.RoundTrip(request)
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestCountRequests(t *testing.T) {
+	g := NewWithT(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: CountRequests(http.DefaultTransport)}
+	send := func(ctx context.Context) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+		g.Expect(err).ToNot(HaveOccurred())
+		resp, err := client.Do(req)
+		g.Expect(err).ToNot(HaveOccurred())
+		resp.Body.Close()
+	}
+
+	ctx, counter := WithRequestCounter(context.Background())
+	send(ctx)
+	send(ctx)
+	g.Expect(counter.Count()).To(Equal(2))
+
+	// Requests without a counter are sent, but not counted.
+	send(context.Background())
+	g.Expect(counter.Count()).To(Equal(2))
+
+	var none *RequestCounter
+	g.Expect(none.Count()).To(Equal(0))
+}
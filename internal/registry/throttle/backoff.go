@@ -0,0 +1,115 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultBaseDelay is how long to back off from a registry the first
+	// time it throttles requests.
+	DefaultBaseDelay = 30 * time.Second
+	// DefaultMaxDelay bounds how long to back off from a registry which
+	// keeps throttling requests.
+	DefaultMaxDelay = time.Hour
+)
+
+// Backoff tracks the registries throttling requests, shared by all
+// the image repositories of each registry. The delay backing off from
+// a registry doubles each time it throttles requests again, from the
+// base delay up to the max, until a request to it succeeds.
+type Backoff struct {
+	base, max time.Duration
+	now       func() time.Time
+
+	mu         sync.Mutex
+	registries map[string]*registryBackoff
+}
+
+// registryBackoff is the backoff from a registry.
+type registryBackoff struct {
+	// delay is how long the backoff is; it doubles each time the
+	// registry throttles requests again.
+	delay time.Duration
+	// until is when the backoff ends.
+	until time.Time
+}
+
+// NewBackoff returns a Backoff with the base and max delays given.
+func NewBackoff(base, max time.Duration) *Backoff {
+	if max < base {
+		max = base
+	}
+	return &Backoff{
+		base:       base,
+		max:        max,
+		now:        time.Now,
+		registries: map[string]*registryBackoff{},
+	}
+}
+
+// Throttled returns how long is left of the backoff from the registry,
+// and `true`, if it is being backed off from, otherwise `false`.
+func (b *Backoff) Throttled(registry string) (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	rb, ok := b.registries[registry]
+	if !ok {
+		return 0, false
+	}
+	left := rb.until.Sub(b.now())
+	return left, left > 0
+}
+
+// Failed records the registry throttling a request, returning how long
+// to back off from it. Since the image repositories of a registry are
+// scanned concurrently, requests throttled while already backing off
+// do not extend the backoff.
+func (b *Backoff) Failed(registry string) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	rb, ok := b.registries[registry]
+	if !ok {
+		rb = &registryBackoff{}
+		b.registries[registry] = rb
+	}
+	if left := rb.until.Sub(now); left > 0 {
+		return left
+	}
+	switch {
+	case rb.delay == 0:
+		rb.delay = b.base
+	case rb.delay < b.max:
+		rb.delay *= 2
+		if rb.delay > b.max {
+			rb.delay = b.max
+		}
+	}
+	rb.until = now.Add(rb.delay)
+	return rb.delay
+}
+
+// Recovered records a request to the registry succeeding, which ends
+// the backoff from it.
+func (b *Backoff) Recovered(registry string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.registries, registry)
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package throttle
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestBackoff(t *testing.T) {
+	g := NewWithT(t)
+	now := time.Date(2022, 5, 6, 18, 0, 0, 0, time.UTC)
+	b := NewBackoff(30*time.Second, 2*time.Minute)
+	b.now = func() time.Time { return now }
+
+	_, throttled := b.Throttled("example.com")
+	g.Expect(throttled).To(BeFalse())
+
+	g.Expect(b.Failed("example.com")).To(Equal(30 * time.Second))
+	left, throttled := b.Throttled("example.com")
+	g.Expect(throttled).To(BeTrue())
+	g.Expect(left).To(Equal(30 * time.Second))
+	_, throttled = b.Throttled("other.example.com")
+	g.Expect(throttled).To(BeFalse())
+
+	// Requests throttled while backing off do not extend the backoff.
+	now = now.Add(10 * time.Second)
+	g.Expect(b.Failed("example.com")).To(Equal(20 * time.Second))
+
+	// The backoff doubles each time the registry throttles requests
+	// again, up to the max.
+	now = now.Add(20 * time.Second)
+	_, throttled = b.Throttled("example.com")
+	g.Expect(throttled).To(BeFalse())
+	g.Expect(b.Failed("example.com")).To(Equal(time.Minute))
+	now = now.Add(time.Minute)
+	g.Expect(b.Failed("example.com")).To(Equal(2 * time.Minute))
+	now = now.Add(2 * time.Minute)
+	g.Expect(b.Failed("example.com")).To(Equal(2 * time.Minute))
+
+	// Once a request succeeds, the backoff starts again from the base.
+	b.Recovered("example.com")
+	_, throttled = b.Throttled("example.com")
+	g.Expect(throttled).To(BeFalse())
+	g.Expect(b.Failed("example.com")).To(Equal(30 * time.Second))
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package throttle
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"golang.org/x/time/rate"
+	"sigs.k8s.io/yaml"
+)
+
+// Limit caps the rate of requests to a registry host.
+type Limit struct {
+	// Host is the host requests are sent to, with any port, e.g.,
+	// `index.docker.io` for `docker.io`.
+	Host string `json:"host"`
+	// QPS is the greatest number of requests a second, on average. Zero
+	// means no limit.
+	QPS float64 `json:"qps"`
+	// Burst is the greatest number of requests sent at once; by default
+	// the QPS rounded up.
+	Burst int `json:"burst,omitempty"`
+}
+
+// LimitsConfig is the configuration of the limits of the rate of
+// requests to registry hosts, as given to the controller in a YAML or
+// JSON file, e.g., one mounted from a ConfigMap.
+type LimitsConfig struct {
+	Registries []Limit `json:"registries"`
+}
+
+// LoadLimits reads the limits of registry hosts from the file,
+// returning an error if it is not valid.
+func LoadLimits(path string) ([]Limit, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config LimitsConfig
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid registry limits config '%s': %w", path, err)
+	}
+
+	seen := map[string]bool{}
+	for i := range config.Registries {
+		l := &config.Registries[i]
+		reg, err := name.NewRegistry(l.Host)
+		if err != nil || l.Host == "" {
+			return nil, fmt.Errorf("invalid registry limits config '%s': invalid host '%s'", path, l.Host)
+		}
+		l.Host = reg.RegistryStr()
+		if seen[l.Host] {
+			return nil, fmt.Errorf("invalid registry limits config '%s': more than one limit of '%s'", path, l.Host)
+		}
+		seen[l.Host] = true
+		if l.QPS < 0 || l.Burst < 0 {
+			return nil, fmt.Errorf("invalid registry limits config '%s': the limit of '%s' must not be negative", path, l.Host)
+		}
+	}
+	return config.Registries, nil
+}
+
+// Limits caps the rate of requests to each registry host, shared by all
+// the image repositories and image policies of the controller.
+type Limits struct {
+	defaults   Limit
+	registries map[string]Limit
+
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+// NewLimits returns Limits capping the requests to each host at the QPS
+// and burst given, or at those given for the host.
+func NewLimits(qps float64, burst int, registries []Limit) *Limits {
+	l := &Limits{
+		defaults:   Limit{QPS: qps, Burst: burst},
+		registries: map[string]Limit{},
+		limiters:   map[string]*rate.Limiter{},
+	}
+	for _, r := range registries {
+		l.registries[r.Host] = r
+	}
+	return l
+}
+
+// limiter returns the limiter of requests to the host, or nil if they
+// are not limited.
+func (l *Limits) limiter(host string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if limiter, ok := l.limiters[host]; ok {
+		return limiter
+	}
+	limit, ok := l.registries[host]
+	if !ok {
+		limit = l.defaults
+	}
+	var limiter *rate.Limiter
+	if limit.QPS > 0 {
+		burst := limit.Burst
+		if burst == 0 {
+			burst = int(math.Ceil(limit.QPS))
+		}
+		limiter = rate.NewLimiter(rate.Limit(limit.QPS), burst)
+	}
+	l.limiters[host] = limiter
+	return limiter
+}
+
+// Transport returns a transport which waits, before each request, until
+// the limit of the host it is sent to allows it, using the inner
+// transport for the requests.
+func (l *Limits) Transport(inner http.RoundTripper) http.RoundTripper {
+	return &limitTransport{limits: l, inner: inner}
+}
+
+type limitTransport struct {
+	limits *Limits
+	inner  http.RoundTripper
+}
+
+func (t *limitTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if limiter := t.limits.limiter(request.URL.Host); limiter != nil {
+		if err := limiter.Wait(request.Context()); err != nil {
+			return nil, fmt.Errorf("waiting for the request limit of '%s': %w", request.URL.Host, err)
+		}
+	}
+	return t.inner.RoundTrip(request)
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package throttle
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestLoadLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		want    []Limit
+		wantErr string
+	}{
+		{
+			name: "valid config",
+			config: `registries:
+- host: docker.io
+  qps: 2
+  burst: 5
+- host: registry.example.com:5000
+  qps: 0.5
+`,
+			want: []Limit{
+				{Host: "index.docker.io", QPS: 2, Burst: 5},
+				{Host: "registry.example.com:5000", QPS: 0.5},
+			},
+		},
+		{
+			name:    "unknown field",
+			config:  "registries:\n- host: docker.io\n  rps: 2\n",
+			wantErr: "invalid registry limits config",
+		},
+		{
+			name:    "duplicate host",
+			config:  "registries:\n- host: docker.io\n  qps: 2\n- host: index.docker.io\n  qps: 1\n",
+			wantErr: "more than one limit of 'index.docker.io'",
+		},
+		{
+			name:    "negative qps",
+			config:  "registries:\n- host: ghcr.io\n  qps: -1\n",
+			wantErr: "must not be negative",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			path := filepath.Join(t.TempDir(), "limits.yaml")
+			g.Expect(os.WriteFile(path, []byte(tt.config), 0o600)).To(Succeed())
+			limits, err := LoadLimits(path)
+			if tt.wantErr != "" {
+				g.Expect(err).To(MatchError(ContainSubstring(tt.wantErr)))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(limits).To(Equal(tt.want))
+		})
+	}
+}
+
+func TestLimits_Transport(t *testing.T) {
+	g := NewWithT(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(srv.Close)
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	limits := NewLimits(0, 0, []Limit{{Host: host, QPS: 20, Burst: 1}})
+	g.Expect(limits.limiter("ghcr.io")).To(BeNil())
+
+	client := &http.Client{Transport: limits.Transport(http.DefaultTransport)}
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		response, err := client.Get(srv.URL)
+		g.Expect(err).ToNot(HaveOccurred())
+		response.Body.Close()
+	}
+	// After the burst of one, each request waits a twentieth of a
+	// second.
+	g.Expect(time.Since(start)).To(BeNumerically(">=", 90*time.Millisecond))
+
+	// A request which cannot be sent within its deadline fails.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = client.Do(request)
+	g.Expect(err).To(MatchError(ContainSubstring("waiting for the request limit")))
+}
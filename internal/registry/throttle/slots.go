@@ -0,0 +1,146 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package throttle
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+)
+
+// Slots bounds the number of operations run at once, e.g., the scans
+// of registries, each holding a slot while it runs. Operations waiting
+// for a slot get one in order of priority, highest first, and in the
+// order they started waiting otherwise. Nil Slots bound nothing.
+type Slots struct {
+	mu      sync.Mutex
+	free    int
+	waiting waiters
+	// seq orders the operations waiting with the same priority.
+	seq uint64
+}
+
+// NewSlots returns Slots letting the number of operations given run at
+// once, or nil if the number is not positive.
+func NewSlots(n int) *Slots {
+	if n <= 0 {
+		return nil
+	}
+	return &Slots{free: n}
+}
+
+// Acquire waits for a slot, returning an error if the context is done
+// first.
+func (s *Slots) Acquire(ctx context.Context) error {
+	return s.AcquireWithPriority(ctx, 0)
+}
+
+// AcquireWithPriority waits for a slot, ahead of the operations waiting
+// with a lower priority, returning an error if the context is done
+// first.
+func (s *Slots) AcquireWithPriority(ctx context.Context, priority int) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	if s.free > 0 && len(s.waiting) == 0 {
+		s.free--
+		s.mu.Unlock()
+		return nil
+	}
+	s.seq++
+	w := &waiter{priority: priority, seq: s.seq, ready: make(chan struct{})}
+	heap.Push(&s.waiting, w)
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		granted := w.index < 0
+		if !granted {
+			heap.Remove(&s.waiting, w.index)
+		}
+		s.mu.Unlock()
+		// A slot given just as the context was done is passed on.
+		if granted {
+			s.Release()
+		}
+		return ctx.Err()
+	}
+}
+
+// Release frees the slot acquired, giving it to the operation waiting
+// with the highest priority, if any.
+func (s *Slots) Release() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.waiting) == 0 {
+		s.free++
+		return
+	}
+	w := heap.Pop(&s.waiting).(*waiter)
+	close(w.ready)
+}
+
+// waiter is an operation waiting for a slot.
+type waiter struct {
+	priority int
+	seq      uint64
+	ready    chan struct{}
+	// index is the position of the waiter in the heap, or -1 once it
+	// has been given a slot.
+	index int
+}
+
+// waiters is a heap of the operations waiting for a slot, that with the
+// highest priority first.
+type waiters []*waiter
+
+func (h waiters) Len() int { return len(h) }
+
+func (h waiters) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h waiters) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *waiters) Push(x interface{}) {
+	w := x.(*waiter)
+	w.index = len(*h)
+	*h = append(*h, w)
+}
+
+func (h *waiters) Pop() interface{} {
+	old := *h
+	w := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	w.index = -1
+	return w
+}
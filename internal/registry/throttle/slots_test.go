@@ -0,0 +1,94 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package throttle
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestSlots(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	var unbounded *Slots = NewSlots(0)
+	g.Expect(unbounded).To(BeNil())
+	g.Expect(unbounded.Acquire(ctx)).To(Succeed())
+	unbounded.Release()
+
+	s := NewSlots(2)
+	g.Expect(s.Acquire(ctx)).To(Succeed())
+	g.Expect(s.Acquire(ctx)).To(Succeed())
+
+	// With all the slots held, acquiring one waits until the context is
+	// done.
+	waitCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	g.Expect(s.Acquire(waitCtx)).To(MatchError(context.DeadlineExceeded))
+
+	// or until one is released.
+	acquired := make(chan error)
+	go func() { acquired <- s.Acquire(ctx) }()
+	s.Release()
+	g.Eventually(acquired).Should(Receive(BeNil()))
+}
+
+func TestSlots_AcquireWithPriority(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	s := NewSlots(1)
+	g.Expect(s.Acquire(ctx)).To(Succeed())
+
+	// The operations waiting get the slot highest priority first, and
+	// in the order they started waiting otherwise.
+	order := make(chan string, 4)
+	waiting := func() int {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.waiting)
+	}
+	wait := func(name string, priority int) {
+		n := waiting()
+		go func() {
+			if err := s.AcquireWithPriority(ctx, priority); err == nil {
+				order <- name
+			}
+		}()
+		// Each starts waiting before the next.
+		g.Eventually(waiting).Should(Equal(n + 1))
+	}
+	wait("low", -1)
+	wait("first", 0)
+	wait("second", 0)
+	wait("high", 10)
+
+	// One that stops waiting does not hold up the others.
+	waitCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	g.Expect(s.AcquireWithPriority(waitCtx, 100)).To(MatchError(context.DeadlineExceeded))
+
+	for _, want := range []string{"high", "first", "second", "low"} {
+		s.Release()
+		g.Eventually(order).Should(Receive(Equal(want)))
+	}
+	s.Release()
+	g.Expect(s.free).To(Equal(1))
+}
@@ -0,0 +1,227 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronFields are the fields of a cron expression, in order, and the
+// range of values of each.
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12},
+	{name: "day of week", min: 0, max: 6},
+}
+
+// Cron is a schedule given by a standard five-field cron expression:
+// minute, hour, day of month, month and day of week. Each field is `*`,
+// a number, a range `a-b`, any of these with a step `/n`, or a list of
+// them separated by commas. Sunday is day 0 (and 7) of the week.
+type Cron struct {
+	Expression string
+
+	// fields has, for each field, whether each value is in the schedule.
+	fields [5][]bool
+	// domStar and dowStar record whether the day of month and day of
+	// week fields are `*`, since a day matches if either field matches
+	// when both are restricted.
+	domStar, dowStar bool
+}
+
+// ParseCron parses the cron expression, returning an error if it is not
+// valid.
+func ParseCron(expr string) (*Cron, error) {
+	parts := strings.Fields(expr)
+	if len(parts) != len(cronFields) {
+		return nil, fmt.Errorf("invalid cron expression '%s': expected %d fields, got %d", expr, len(cronFields), len(parts))
+	}
+
+	c := &Cron{Expression: expr}
+	for i, part := range parts {
+		f := cronFields[i]
+		max := f.max
+		if i == 4 {
+			// Allow 7 for Sunday, as well as 0.
+			max = 7
+		}
+		values, err := parseField(part, f.min, max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression '%s': %s field: %w", expr, f.name, err)
+		}
+		if i == 4 && values[7] {
+			values[0] = true
+		}
+		c.fields[i] = values
+	}
+	c.domStar = parts[2] == "*"
+	c.dowStar = parts[4] == "*"
+	return c, nil
+}
+
+// parseField returns, for each value up to max, whether it is given by
+// the field.
+func parseField(field string, min, max int) ([]bool, error) {
+	values := make([]bool, max+1)
+	for _, item := range strings.Split(field, ",") {
+		rangePart, step := item, 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			rangePart = item[:i]
+			n, err := strconv.Atoi(item[i+1:])
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid step in '%s'", item)
+			}
+			step = n
+		}
+
+		lo, hi := min, max
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("invalid range '%s'", item)
+			}
+			if hi, err = strconv.Atoi(bounds[1]); err != nil {
+				return nil, fmt.Errorf("invalid range '%s'", item)
+			}
+		default:
+			n, err := strconv.Atoi(rangePart)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value '%s'", item)
+			}
+			lo, hi = n, n
+			if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return nil, fmt.Errorf("'%s' is out of range %d-%d", item, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
+
+// Matches reports whether the minute of the time given is in the
+// schedule.
+func (c *Cron) Matches(t time.Time) bool {
+	if !c.fields[0][t.Minute()] || !c.fields[1][t.Hour()] || !c.fields[3][int(t.Month())] {
+		return false
+	}
+	return c.dayMatches(t)
+}
+
+// dayMatches reports whether the day of the time given is in the
+// schedule, by its day of month and day of week.
+func (c *Cron) dayMatches(t time.Time) bool {
+	dom := c.fields[2][t.Day()]
+	dow := c.fields[4][int(t.Weekday())]
+	switch {
+	case c.domStar && c.dowStar:
+		return true
+	case c.domStar:
+		return dow
+	case c.dowStar:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// Next returns the first minute after the time given that is in the
+// schedule, in the location of the time given, or the zero time if there
+// is none within five years, e.g., for the 30th of February.
+func (c *Cron) Next(t time.Time) time.Time {
+	loc := t.Location()
+	next := t.Truncate(time.Minute).Add(time.Minute)
+	end := next.AddDate(5, 0, 0)
+	// advance moves to the time given, or the next minute if that is
+	// not later, e.g., at a change of daylight saving time.
+	advance := func(to time.Time) {
+		if !to.After(next) {
+			to = next.Add(time.Minute)
+		}
+		next = to
+	}
+	for next.Before(end) {
+		switch {
+		case !c.fields[3][int(next.Month())]:
+			advance(time.Date(next.Year(), next.Month()+1, 1, 0, 0, 0, 0, loc))
+		case !c.dayMatches(next):
+			advance(time.Date(next.Year(), next.Month(), next.Day()+1, 0, 0, 0, 0, loc))
+		case !c.fields[1][next.Hour()]:
+			advance(time.Date(next.Year(), next.Month(), next.Day(), next.Hour()+1, 0, 0, 0, loc))
+		case !c.fields[0][next.Minute()]:
+			advance(next.Add(time.Minute))
+		default:
+			return next
+		}
+	}
+	return time.Time{}
+}
+
+// Window is a recurring period of time, which starts at each time in
+// the schedule and lasts for the duration given.
+type Window struct {
+	Start    *Cron
+	Duration time.Duration
+	Location *time.Location
+}
+
+// NextStart returns the first start of the window after the time given,
+// or the zero time if there is none within five years.
+func (w Window) NextStart(t time.Time) time.Time {
+	loc := w.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	return w.Start.Next(t.In(loc))
+}
+
+// ActiveUntil reports whether the window is active at the time given,
+// and if so the time it ends. If the window has started again before
+// the end of a previous start, it ends at the end of the latest start.
+func (w Window) ActiveUntil(t time.Time) (time.Time, bool) {
+	loc := w.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	t = t.In(loc)
+
+	// Look back, a minute at a time, for the latest start within the
+	// duration of the window.
+	start := t.Truncate(time.Minute)
+	earliest := t.Add(-w.Duration)
+	for ; start.After(earliest); start = start.Add(-time.Minute) {
+		if w.Start.Matches(start) {
+			return start.Add(w.Duration), true
+		}
+	}
+	return time.Time{}, false
+}
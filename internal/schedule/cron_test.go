@@ -0,0 +1,180 @@
+/*
+Copyright 2022 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	cases := []struct {
+		label     string
+		exprs     []string
+		expectErr bool
+	}{
+		{
+			label: "With valid expression",
+			exprs: []string{"* * * * *", "0 18 * * 5", "*/15 9-17 * * 1-5", "0 0 1,15 * *", "30 2 * 12 0,7", "5/10 * * * *"},
+		},
+		{
+			label:     "With invalid expression",
+			exprs:     []string{"", "* * * *", "* * * * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *"},
+			expectErr: true,
+		},
+	}
+
+	for _, tt := range cases {
+		for _, expr := range tt.exprs {
+			t.Run(tt.label, func(t *testing.T) {
+				_, err := ParseCron(expr)
+				if tt.expectErr && err == nil {
+					t.Fatalf("expecting error, got nil for expression: '%s'", expr)
+				}
+				if !tt.expectErr && err != nil {
+					t.Fatalf("returned unexpected error: %s", err)
+				}
+			})
+		}
+	}
+}
+
+func TestCron_Matches(t *testing.T) {
+	// 2022-05-06 is a Friday.
+	friday := time.Date(2022, 5, 6, 18, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		expr    string
+		time    time.Time
+		matches bool
+	}{
+		{expr: "* * * * *", time: friday, matches: true},
+		{expr: "0 18 * * 5", time: friday, matches: true},
+		{expr: "0 18 * * 5", time: friday.Add(time.Minute), matches: false},
+		{expr: "0 18 * * 1-4", time: friday, matches: false},
+		{expr: "*/15 9-18 * * 1-5", time: friday.Add(45 * time.Minute), matches: true},
+		{expr: "0 18 6 * *", time: friday, matches: true},
+		{expr: "0 18 7 * *", time: friday, matches: false},
+		// When both the day of month and day of week are restricted, a
+		// day matching either is in the schedule.
+		{expr: "0 18 7 * 5", time: friday, matches: true},
+		{expr: "0 18 * 5 *", time: friday, matches: true},
+		{expr: "0 18 * 6 *", time: friday, matches: false},
+		{expr: "0 18 * * 7", time: friday.Add(48 * time.Hour), matches: true},
+	}
+
+	for _, tt := range cases {
+		c, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("returned unexpected error: %s", err)
+		}
+		if got := c.Matches(tt.time); got != tt.matches {
+			t.Errorf("'%s' matching %s: got %v, expected %v", tt.expr, tt.time, got, tt.matches)
+		}
+	}
+}
+
+func TestCron_Next(t *testing.T) {
+	// 2022-05-06 is a Friday.
+	friday := time.Date(2022, 5, 6, 18, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		expr string
+		time time.Time
+		next time.Time
+	}{
+		{expr: "* * * * *", time: friday, next: friday.Add(time.Minute)},
+		{expr: "* * * * *", time: friday.Add(30 * time.Second), next: friday.Add(time.Minute)},
+		{expr: "0 18 * * 5", time: friday, next: friday.AddDate(0, 0, 7)},
+		{expr: "0 18 * * 5", time: friday.Add(-time.Minute), next: friday},
+		{expr: "*/15 9-17 * * 1-5", time: friday, next: time.Date(2022, 5, 9, 9, 0, 0, 0, time.UTC)},
+		{expr: "30 2 1 * *", time: friday, next: time.Date(2022, 6, 1, 2, 30, 0, 0, time.UTC)},
+		{expr: "0 0 1 1 *", time: friday, next: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{expr: "0 0 29 2 *", time: friday, next: time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{expr: "0 0 30 2 *", time: friday, next: time.Time{}},
+	}
+
+	for _, tt := range cases {
+		c, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("returned unexpected error: %s", err)
+		}
+		if got := c.Next(tt.time); !got.Equal(tt.next) {
+			t.Errorf("'%s' after %s: got %s, expected %s", tt.expr, tt.time, got, tt.next)
+		}
+	}
+
+	// The schedule is in the location of the time given.
+	c, err := ParseCron("0 9 * * *")
+	if err != nil {
+		t.Fatalf("returned unexpected error: %s", err)
+	}
+	loc := time.FixedZone("UTC+2", 2*60*60)
+	if got, want := c.Next(friday.In(loc)), time.Date(2022, 5, 7, 7, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("next in %s: got %s, expected %s", loc, got, want)
+	}
+}
+
+func TestWindow_ActiveUntil(t *testing.T) {
+	c, err := ParseCron("0 18 * * 5")
+	if err != nil {
+		t.Fatalf("returned unexpected error: %s", err)
+	}
+	// From Friday 18:00 to Monday 06:00.
+	w := Window{Start: c, Duration: 60 * time.Hour}
+	start := time.Date(2022, 5, 6, 18, 0, 0, 0, time.UTC)
+	end := start.Add(60 * time.Hour)
+
+	cases := []struct {
+		label  string
+		time   time.Time
+		active bool
+	}{
+		{label: "before the start", time: start.Add(-time.Second), active: false},
+		{label: "at the start", time: start, active: true},
+		{label: "during the window", time: start.Add(30 * time.Hour), active: true},
+		{label: "just before the end", time: end.Add(-time.Second), active: true},
+		{label: "at the end", time: end, active: false},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.label, func(t *testing.T) {
+			until, active := w.ActiveUntil(tt.time)
+			if active != tt.active {
+				t.Fatalf("got active %v, expected %v", active, tt.active)
+			}
+			if active && !until.Equal(end) {
+				t.Errorf("got end %s, expected %s", until, end)
+			}
+		})
+	}
+
+	if next := w.NextStart(start); !next.Equal(start.AddDate(0, 0, 7)) {
+		t.Errorf("got next start %s, expected %s", next, start.AddDate(0, 0, 7))
+	}
+
+	// In a time zone two hours ahead, the window starts two hours
+	// earlier.
+	loc := time.FixedZone("UTC+2", 2*60*60)
+	w.Location = loc
+	if _, active := w.ActiveUntil(start.Add(-2*time.Hour - time.Second)); active {
+		t.Errorf("expected window in %s not to be active before %s", loc, start.Add(-2*time.Hour))
+	}
+	if until, active := w.ActiveUntil(start.Add(-2 * time.Hour)); !active || !until.Equal(end.Add(-2*time.Hour)) {
+		t.Errorf("expected window in %s to be active at %s until %s, got %v until %s", loc, start.Add(-2*time.Hour), end.Add(-2*time.Hour), active, until)
+	}
+}
@@ -19,11 +19,12 @@ package main
 import (
 	"fmt"
 	"os"
+	"time"
 
-	"github.com/dgraph-io/badger/v3"
 	flag "github.com/spf13/pflag"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -38,11 +39,16 @@ import (
 	"github.com/fluxcd/pkg/runtime/pprof"
 	"github.com/fluxcd/pkg/runtime/probes"
 
+	imagev1alpha1 "github.com/fluxcd/image-reflector-controller/api/v1alpha1"
+	imagev1alpha2 "github.com/fluxcd/image-reflector-controller/api/v1alpha2"
 	imagev1 "github.com/fluxcd/image-reflector-controller/api/v1beta1"
+	imagev1beta2 "github.com/fluxcd/image-reflector-controller/api/v1beta2"
 	// +kubebuilder:scaffold:imports
 	"github.com/fluxcd/image-reflector-controller/controllers"
 	"github.com/fluxcd/image-reflector-controller/internal/database"
 	"github.com/fluxcd/image-reflector-controller/internal/registry/login"
+	"github.com/fluxcd/image-reflector-controller/internal/registry/mirror"
+	"github.com/fluxcd/image-reflector-controller/internal/registry/throttle"
 )
 
 const controllerName = "image-reflector-controller"
@@ -56,25 +62,53 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(imagev1.AddToScheme(scheme))
+	// The other versions are converted to and from v1beta1 by the
+	// conversion webhook.
+	utilruntime.Must(imagev1alpha1.AddToScheme(scheme))
+	utilruntime.Must(imagev1alpha2.AddToScheme(scheme))
+	utilruntime.Must(imagev1beta2.AddToScheme(scheme))
 	// +kubebuilder:scaffold:scheme
 }
 
 func main() {
 	var (
-		metricsAddr             string
-		eventsAddr              string
-		healthAddr              string
-		clientOptions           client.Options
-		logOptions              logger.Options
-		leaderElectionOptions   leaderelection.Options
-		watchAllNamespaces      bool
-		storagePath             string
-		storageValueLogFileSize int64
-		concurrent              int
-		awsAutoLogin            bool
-		gcpAutoLogin            bool
-		azureAutoLogin          bool
-		aclOptions              acl.Options
+		metricsAddr           string
+		eventsAddr            string
+		healthAddr            string
+		clientOptions         client.Options
+		logOptions            logger.Options
+		leaderElectionOptions leaderelection.Options
+		watchAllNamespaces    bool
+		concurrent            int
+		awsAutoLogin          bool
+		awsECREndpoint        string
+		awsUseFIPSEndpoint    bool
+		gcpAutoLogin          bool
+		azureAutoLogin        bool
+		ociAutoLogin          bool
+		credentialHelpersDir  string
+		dockerConfigFile      string
+		mirrorsConfig         string
+		aclOptions            acl.Options
+		dbOptions             database.Options
+		insecureAllowHTTP     bool
+		dbCollectInterval     time.Duration
+		defaultTagLimit       int
+		readOnly              bool
+		backoffBaseDelay      time.Duration
+		backoffMaxDelay       time.Duration
+		registryQPS           float64
+		registryBurst         int
+		registryLimitsConfig  string
+		concurrentScans       int
+		scanBackoffBaseDelay  time.Duration
+		scanBackoffMaxDelay   time.Duration
+		minScanInterval       time.Duration
+		enableWebhooks        bool
+		defaultInterval       time.Duration
+		defaultTimeout        time.Duration
+		defaultExclusionList  []string
+		defaultProvider       string
 	)
 
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
@@ -82,34 +116,87 @@ func main() {
 	flag.StringVar(&healthAddr, "health-addr", ":9440", "The address the health endpoint binds to.")
 	flag.BoolVar(&watchAllNamespaces, "watch-all-namespaces", true,
 		"Watch for custom resources in all namespaces, if set to false it will only watch the runtime namespace.")
-	flag.StringVar(&storagePath, "storage-path", "/data", "Where to store the persistent database of image metadata")
-	flag.Int64Var(&storageValueLogFileSize, "storage-value-log-file-size", 1<<28, "Set the database's memory mapped value log file size in bytes. Effective memory usage is about two times this size.")
 	flag.IntVar(&concurrent, "concurrent", 4, "The number of concurrent resource reconciles.")
+	flag.IntVar(&concurrentScans, "concurrent-scans", 0, "The greatest number of image repositories listing tags or fetching metadata from registries at once, across all of them, counting the lookups image policies make for their checks. Set to 0 for no bound beyond --concurrent.")
 	flag.BoolVar(&awsAutoLogin, "aws-autologin-for-ecr", false, "(AWS) Attempt to get credentials for images in Elastic Container Registry, when no secret is referenced")
+	flag.StringVar(&awsECREndpoint, "aws-ecr-endpoint", "", "(AWS) The URL of the Elastic Container Registry API to get credentials from, in place of the default for the region of the image, e.g., that of a VPC endpoint")
+	flag.BoolVar(&awsUseFIPSEndpoint, "aws-use-fips-endpoint", false, "(AWS) Get credentials for images in Elastic Container Registry from the FIPS endpoint of its API")
 	flag.BoolVar(&gcpAutoLogin, "gcp-autologin-for-gcr", false, "(GCP) Attempt to get credentials for images in Google Container Registry, when no secret is referenced")
 	flag.BoolVar(&azureAutoLogin, "azure-autologin-for-acr", false, "(Azure) Attempt to get credentials for images in Azure Container Registry, when no secret is referenced")
+	flag.BoolVar(&ociAutoLogin, "oci-autologin-for-ocir", false, "(OCI) Attempt to get credentials for images in Oracle Cloud Infrastructure Registry, as the instance principal or the OKE workload identity, when no secret is referenced")
+	flag.StringVar(&credentialHelpersDir, "credential-helpers-dir", "", "The directory of docker credential helpers, as docker-credential-<name> binaries, that image repositories can get credentials from with .spec.provider.exec.helper. Unset, no helper can be used.")
+	flag.StringVar(&dockerConfigFile, "docker-config-file", "", "The path of a docker config file, e.g., one mounted with the registry credentials of the nodes, to get credentials from for a registry an image repository is given no other credentials for. Unset, no such file is used.")
+	flag.StringVar(&mirrorsConfig, "registry-mirrors-config", "", "The path of a YAML file giving mirrors of registries, e.g., pull-through caches, to scan in place of the registries they mirror, with the credentials and certificates for each. Unset, registries are scanned directly.")
+	flag.BoolVar(&insecureAllowHTTP, "insecure-allow-http", true, "Allow image repositories to connect to registries over plain HTTP with .spec.insecure. Set to false to refuse all insecure connections.")
 
+	flag.DurationVar(&dbCollectInterval, "database-collect-interval", 0, "The interval at which to delete the database records of images no image repository scans, e.g., 1h. Unset, or set to 0, nothing is deleted.")
+	flag.IntVar(&defaultTagLimit, "default-tag-limit", 0, "The greatest number of tags stored for an image repository that does not set .spec.tagLimit. Set to 0 for no limit.")
+	flag.BoolVar(&readOnly, "read-only", false, "Scan image repositories and evaluate image policies without recording anything in the database or changing the latest image of any policy, reporting in events what would change instead.")
+	flag.DurationVar(&backoffBaseDelay, "registry-backoff-base-delay", throttle.DefaultBaseDelay, "How long to put off the scans of the images of a registry the first time it throttles requests, with 429 Too Many Requests or a server error. The delay doubles each time the registry throttles requests again, until a scan succeeds. Set to 0 to disable.")
+	flag.DurationVar(&backoffMaxDelay, "registry-backoff-max-delay", throttle.DefaultMaxDelay, "The longest delay putting off the scans of the images of a registry which keeps throttling requests.")
+	flag.DurationVar(&scanBackoffBaseDelay, "scan-backoff-base-delay", 0, "How long after a failed scan of an image repository the next is made, e.g., 10s. The delay doubles with each scan of it failing in a row, until a scan succeeds. Unset, or set to 0, failed scans are retried as other errors are.")
+	flag.DurationVar(&scanBackoffMaxDelay, "scan-backoff-max-delay", time.Hour, "The longest delay between the scans of an image repository which keeps failing.")
+	flag.DurationVar(&minScanInterval, "min-scan-interval", 0, "The shortest time allowed between the scans of an image repository. A shorter .spec.interval, or a schedule with times closer together, is held to it. Set to 0 for no minimum.")
+	flag.BoolVar(&enableWebhooks, "enable-webhooks", false, "Serve the webhooks validating image repositories and policies, and setting the defaults of new image repositories, at admission, and converting them between the versions of the API, on port 9443, with the certificate and key in /tmp/k8s-webhook-server/serving-certs.")
+	flag.DurationVar(&defaultInterval, "default-interval", 0, "The .spec.interval set by the webhook for new image repositories giving neither an interval nor a schedule, with --enable-webhooks. Set to 0 to set none.")
+	flag.DurationVar(&defaultTimeout, "default-timeout", 0, "The .spec.timeout set by the webhook for new image repositories giving none, with --enable-webhooks. Set to 0 to set none.")
+	flag.StringSliceVar(&defaultExclusionList, "default-exclusion-list", nil, "The .spec.exclusionList set by the webhook for new image repositories giving none, with --enable-webhooks, in place of the exclusion of cosign signatures. Unset, none is set.")
+	flag.StringVar(&defaultProvider, "default-provider", "", "The .spec.provider.name set by the webhook for new image repositories giving no .spec.provider, with --enable-webhooks: aws, gcp, azure or generic. Unset, none is set.")
+	flag.Float64Var(&registryQPS, "registry-qps", 0, "The greatest number of requests a second, on average, sent to each registry host, across all image repositories and policies. Set to 0 for no limit.")
+	flag.IntVar(&registryBurst, "registry-burst", 0, "The greatest number of requests sent to a registry host at once, within --registry-qps. Set to 0 for the QPS rounded up.")
+	flag.StringVar(&registryLimitsConfig, "registry-limits-config", "", "The path of a YAML file, e.g., one mounted from a ConfigMap, giving the QPS and burst of requests to particular registry hosts, in place of --registry-qps and --registry-burst.")
 	clientOptions.BindFlags(flag.CommandLine)
 	logOptions.BindFlags(flag.CommandLine)
 	leaderElectionOptions.BindFlags(flag.CommandLine)
 	aclOptions.BindFlags(flag.CommandLine)
+	dbOptions.BindFlags(flag.CommandLine)
 	flag.Parse()
 
 	log := logger.NewLogger(logOptions)
 	ctrl.SetLogger(log)
 
-	badgerOpts := badger.DefaultOptions(storagePath)
-	badgerOpts.ValueLogFileSize = storageValueLogFileSize
-	badgerDB, err := badger.Open(badgerOpts)
+	var mirrors []mirror.Mirror
+	if mirrorsConfig != "" {
+		var err error
+		if mirrors, err = mirror.Load(mirrorsConfig); err != nil {
+			setupLog.Error(err, "unable to load the mirrors of registries")
+			os.Exit(1)
+		}
+	}
+
+	var requestLimits *throttle.Limits
+	if registryQPS > 0 || registryLimitsConfig != "" {
+		var limits []throttle.Limit
+		if registryLimitsConfig != "" {
+			var err error
+			if limits, err = throttle.LoadLimits(registryLimitsConfig); err != nil {
+				setupLog.Error(err, "unable to load the request limits of registries")
+				os.Exit(1)
+			}
+		}
+		requestLimits = throttle.NewLimits(registryQPS, registryBurst, limits)
+	}
+
+	db, closeDB, err := dbOptions.Open()
 	if err != nil {
-		setupLog.Error(err, "unable to open the Badger database")
+		setupLog.Error(err, "unable to open the database")
 		os.Exit(1)
 	}
-	defer badgerDB.Close()
-	db := database.NewBadgerDatabase(badgerDB)
+	defer closeDB()
+	if readOnly {
+		db = database.ReadOnly(db)
+	}
+
+	scanSlots := throttle.NewSlots(concurrentScans)
+
+	var registryBackoff *throttle.Backoff
+	if backoffBaseDelay > 0 {
+		registryBackoff = throttle.NewBackoff(backoffBaseDelay, backoffMaxDelay)
+	}
 
 	metricsRecorder := metrics.NewRecorder()
 	crtlmetrics.Registry.MustRegister(metricsRecorder.Collectors()...)
+	crtlmetrics.Registry.MustRegister(controllers.RateLimitRemaining)
 
 	watchNamespace := ""
 	if !watchAllNamespaces {
@@ -135,6 +222,14 @@ func main() {
 		os.Exit(1)
 	}
 
+	// The tokens of service accounts are requested with a clientset,
+	// since the controller-runtime client cannot create subresources.
+	kubeClient, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		setupLog.Error(err, "unable to create the Kubernetes client")
+		os.Exit(1)
+	}
+
 	probes.SetupChecks(mgr, setupLog)
 	pprof.SetupHandlers(mgr, setupLog)
 
@@ -144,23 +239,54 @@ func main() {
 		os.Exit(1)
 	}
 
-	if err = (&controllers.ImageRepositoryReconciler{
-		Client:          mgr.GetClient(),
-		Scheme:          mgr.GetScheme(),
-		EventRecorder:   eventRecorder,
-		MetricsRecorder: metricsRecorder,
-		Database:        db,
-		ProviderOptions: login.ProviderOptions{
-			AwsAutoLogin:   awsAutoLogin,
-			GcpAutoLogin:   gcpAutoLogin,
-			AzureAutoLogin: azureAutoLogin,
-		},
-	}).SetupWithManager(mgr, controllers.ImageRepositoryReconcilerOptions{
+	providerOptions := login.ProviderOptions{
+		AwsAutoLogin:         awsAutoLogin,
+		AwsEndpoint:          awsECREndpoint,
+		AwsUseFIPSEndpoint:   awsUseFIPSEndpoint,
+		GcpAutoLogin:         gcpAutoLogin,
+		AzureAutoLogin:       azureAutoLogin,
+		OciAutoLogin:         ociAutoLogin,
+		CredentialHelpersDir: credentialHelpersDir,
+		DockerConfigFile:     dockerConfigFile,
+		Mirrors:              mirrors,
+		ServiceAccounts:      kubeClient.CoreV1(),
+		RequestLimits:        requestLimits,
+	}
+	// The settings of image repositories and cluster image repositories
+	// are the same, since they are scanned alike.
+	newImageRepositoryReconciler := func() controllers.ImageRepositoryReconciler {
+		return controllers.ImageRepositoryReconciler{
+			Client:               mgr.GetClient(),
+			Scheme:               mgr.GetScheme(),
+			EventRecorder:        eventRecorder,
+			MetricsRecorder:      metricsRecorder,
+			Database:             db,
+			ProviderOptions:      providerOptions,
+			InsecureAllowHTTP:    insecureAllowHTTP,
+			DefaultTagLimit:      defaultTagLimit,
+			ReadOnly:             readOnly,
+			RegistryBackoff:      registryBackoff,
+			ScanSlots:            scanSlots,
+			ScanBackoffBaseDelay: scanBackoffBaseDelay,
+			ScanBackoffMaxDelay:  scanBackoffMaxDelay,
+			MinScanInterval:      minScanInterval,
+		}
+	}
+	imageRepositoryReconciler := newImageRepositoryReconciler()
+	if err = imageRepositoryReconciler.SetupWithManager(mgr, controllers.ImageRepositoryReconcilerOptions{
 		MaxConcurrentReconciles: concurrent,
 	}); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", imagev1.ImageRepositoryKind)
 		os.Exit(1)
 	}
+	if err = (&controllers.ClusterImageRepositoryReconciler{
+		ImageRepositoryReconciler: newImageRepositoryReconciler(),
+	}).SetupWithManager(mgr, controllers.ImageRepositoryReconcilerOptions{
+		MaxConcurrentReconciles: concurrent,
+	}); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", imagev1.ClusterImageRepositoryKind)
+		os.Exit(1)
+	}
 	if err = (&controllers.ImagePolicyReconciler{
 		Client:          mgr.GetClient(),
 		Scheme:          mgr.GetScheme(),
@@ -168,12 +294,51 @@ func main() {
 		MetricsRecorder: metricsRecorder,
 		Database:        db,
 		ACLOptions:      aclOptions,
+		ProviderOptions: providerOptions,
+		APIReader:       mgr.GetAPIReader(),
+		ReadOnly:        readOnly,
+		RegistryBackoff: registryBackoff,
+		ScanSlots:       scanSlots,
 	}).SetupWithManager(mgr, controllers.ImagePolicyReconcilerOptions{
 		MaxConcurrentReconciles: concurrent,
 	}); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", imagev1.ImagePolicyKind)
 		os.Exit(1)
 	}
+	if enableWebhooks {
+		if err = controllers.SetupConversionWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create conversion webhook")
+			os.Exit(1)
+		}
+		if err = (&controllers.ImageRepositoryValidator{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", imagev1.ImageRepositoryKind)
+			os.Exit(1)
+		}
+		if err = (&controllers.ImageRepositoryDefaulter{
+			Interval:      defaultInterval,
+			Timeout:       defaultTimeout,
+			ExclusionList: defaultExclusionList,
+			Provider:      defaultProvider,
+		}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", imagev1.ImageRepositoryKind)
+			os.Exit(1)
+		}
+		if err = (&controllers.ImagePolicyValidator{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", imagev1.ImagePolicyKind)
+			os.Exit(1)
+		}
+	}
+	// The collector would delete nothing in read-only mode.
+	if dbCollectInterval > 0 && !readOnly {
+		if err = mgr.Add(&controllers.DatabaseCollector{
+			Reader:   mgr.GetAPIReader(),
+			Database: db,
+			Interval: dbCollectInterval,
+		}); err != nil {
+			setupLog.Error(err, "unable to add the database collector")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	setupLog.Info("starting manager")